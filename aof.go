@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Append-only file written when appendfilename is not configured, in dir,
+// and how often it is fsynced when appendfsync is not, as in Redis
+const (
+	defaultAppendFilename = "appendonly.aof"
+	defaultAppendFsync    = fsyncEverysec
+)
+
+// appendfsync policies
+const (
+	fsyncAlways   = "always"   // fsync before replying to the command
+	fsyncEverysec = "everysec" // fsync once a second
+	fsyncNo       = "no"       // Leave it to the kernel
+)
+
+// Settings changed by CONFIG SET, overriding the configuration file's
+var (
+	appendOnlyOverride     atomic.Pointer[bool]
+	appendFilenameOverride atomic.Pointer[string]
+	appendFsyncOverride    atomic.Pointer[string]
+)
+
+// appendOnly reports whether appendonly is on
+func appendOnly() bool {
+	if override := appendOnlyOverride.Load(); override != nil {
+		return *override
+	}
+	return config.AppendOnly
+}
+
+// aofPath returns the path of the append-only file
+func aofPath() string {
+	name := config.AppendFilename
+	if override := appendFilenameOverride.Load(); override != nil {
+		name = *override
+	}
+	return filepath.Join(dataDir(), name)
+}
+
+// appendFsync returns the appendfsync policy
+func appendFsync() string {
+	if override := appendFsyncOverride.Load(); override != nil {
+		return *override
+	}
+	return config.AppendFsync
+}
+
+// The append-only file while appendonly is on, nil otherwise
+var aof atomic.Pointer[aofFile]
+
+// Orders the commands changing the dataset as their changes are appended to
+// the append-only file: while appendonly is on, every command holds it after
+// taking execMu for reading, and EXEC and scripts hold execMu for writing.
+// As in Redis, commands then run one at a time.
+var aofMu sync.Mutex
+
+// aofFile is the append-only file while appendonly is on. Commands append to
+// buf; the flusher goroutine writes it to the file and fsyncs the file as
+// appendfsync says.
+type aofFile struct {
+	path string
+	file *os.File
+	base *rdbSnapshot // Dataset the file starts from, written first (nil when appending to an existing file)
+
+	mu        sync.Mutex
+	buf       []byte      // Appended, not yet written
+	appended  int64       // Offset of the end of buf, counting the commands appended since the file was opened
+	synced    int64       // Offset the file is fsynced up to
+	waiters   []aofWaiter // Clients waiting for synced to reach their offset
+	lastDB    int         // Database of the last command appended, -1 before the first
+	multi     bool        // A MULTI was appended for the transaction or script running
+	size      int64       // Bytes in the file
+	rewriting bool        // The base is being written
+	writeErr  error       // Error of the last write or fsync, nil once one succeeds
+	closed    bool        // Set once the flusher stopped
+
+	writeMu sync.Mutex    // Serializes writing and fsyncing the file
+	written int64         // Offset the file is written up to, under writeMu
+	fsynced int64         // Offset the file was last fsynced up to, under writeMu
+	wake    chan struct{} // Tells the flusher that buf has data (buffered)
+	stop    chan struct{} // Closed to stop the flusher
+	done    chan struct{} // Closed once the flusher stopped
+}
+
+// aofWaiter is a client waiting for an offset of the file to be fsynced
+type aofWaiter struct {
+	offset int64
+	ready  chan struct{} // Closed once it is
+}
+
+// openAOF opens the append-only file, whose flusher the caller starts once it
+// is in aof. Without base, commands are appended to the existing file; with
+// one, a new file is written from it, in place of any previous file once the
+// base is complete.
+func openAOF(base *rdbSnapshot) (*aofFile, error) {
+	f := &aofFile{
+		path:   aofPath(),
+		base:   base,
+		lastDB: -1,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	var err error
+	if base == nil {
+		f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = f.file.Stat(); err == nil {
+				f.size = info.Size()
+			} else {
+				f.file.Close()
+			}
+		}
+	} else {
+		base.aofBase = true
+		f.file, err = os.CreateTemp(filepath.Dir(f.path), "temp-rewriteaof-*.aof")
+		f.rewriting = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// startAOF opens the append-only file once the data is loaded at startup:
+// the file the data came from is appended to, and one is written from the
+// data if there is none yet
+func startAOF() error {
+	var base *rdbSnapshot
+	if _, err := os.Stat(aofPath()); errors.Is(err, os.ErrNotExist) {
+		base = takeSnapshot(databases)
+	}
+	f, err := openAOF(base)
+	if err != nil {
+		if base != nil {
+			base.release()
+		}
+		return err
+	}
+	aof.Store(f)
+	go f.flusher()
+	return nil
+}
+
+// enableAOF opens the append-only file after CONFIG SET turned appendonly on.
+// The file starts from a snapshot of the dataset taken while no command runs,
+// so what the commands appended afterwards change is exactly what it lacks.
+func enableAOF() {
+	execMu.Lock()
+	defer execMu.Unlock()
+	if !appendOnly() || aof.Load() != nil {
+		return // Turned off again, or enabled by an earlier CONFIG SET
+	}
+	snap := takeSnapshot(databases)
+	f, err := openAOF(snap)
+	if err != nil {
+		snap.release()
+		fmt.Printf("Error opening the append only file: %v\n", err)
+		disabled := false
+		appendOnlyOverride.Store(&disabled)
+		return
+	}
+	aof.Store(f)
+	go f.flusher()
+	fmt.Println("Background append only file rewriting started")
+}
+
+// flusher writes what commands append to the file, after the base if there is
+// one, and fsyncs it: after every write with appendfsync always, once a second
+// with everysec, and never with no
+func (f *aofFile) flusher() {
+	defer close(f.done)
+	f.mu.Lock()
+	base := f.base
+	f.mu.Unlock()
+	if base != nil && !f.writeBase() {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.wake:
+			f.flush(appendFsync() == fsyncAlways)
+		case <-ticker.C:
+			// Also retries a failed write or fsync
+			f.flush(appendFsync() != fsyncNo)
+		case <-f.stop:
+			f.flush(true)
+			return
+		}
+	}
+}
+
+// writeBase writes the base the file starts from and moves the file into
+// place. On failure appendonly is turned off, as the file is incomplete.
+// Returns whether it succeeded.
+func (f *aofFile) writeBase() bool {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	counted := &countingWriter{w: f.file}
+	w := bufio.NewWriterSize(counted, rdbFlushSize)
+	err := f.base.write(w, nil)
+	f.base.release()
+	f.mu.Lock()
+	f.base = nil
+	f.mu.Unlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(f.file.Name(), f.path)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rewriting = false
+	if err != nil {
+		fmt.Printf("Error writing the append only file base, appendonly turned off: %v\n", err)
+		f.file.Close()
+		os.Remove(f.file.Name())
+		f.writeErr, f.closed = err, true
+		f.releaseWaiters()
+		if aof.CompareAndSwap(f, nil) {
+			disabled := false
+			appendOnlyOverride.Store(&disabled)
+		}
+		return false
+	}
+	f.size += counted.n
+	fmt.Println("Background AOF rewrite terminated with success")
+	return true
+}
+
+// flush writes buf to the file, and fsyncs the file if sync is set and
+// anything was written since the last fsync. With appendfsync no, written
+// data counts as fsynced.
+func (f *aofFile) flush(sync bool) {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	f.mu.Lock()
+	if f.closed || f.base != nil {
+		f.mu.Unlock()
+		return // Stopped, or the flusher is yet to write the base
+	}
+	data, end := f.buf, f.appended
+	f.buf = nil
+	f.mu.Unlock()
+
+	var err error
+	if len(data) > 0 {
+		var n int
+		n, err = f.file.Write(data)
+		f.mu.Lock()
+		f.size += int64(n)
+		if err != nil {
+			// Kept for the next attempt, ahead of what was appended since
+			f.buf = append(data[n:], f.buf...)
+		}
+		f.mu.Unlock()
+		if err == nil {
+			f.written = end
+		}
+	}
+	if err == nil && sync && f.fsynced < f.written {
+		if err = f.file.Sync(); err == nil {
+			f.fsynced = f.written
+		}
+	}
+	synced := f.fsynced
+	if appendFsync() == fsyncNo {
+		synced = f.written
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		if f.writeErr == nil {
+			fmt.Printf("Error writing to the append only file: %v\n", err)
+		}
+		f.writeErr = err
+		if appendFsync() == fsyncAlways {
+			// Replies were promised to follow the fsync, as in Redis
+			fmt.Println("Can't recover from AOF write error when the AOF fsync policy is 'always'. Exiting...")
+			os.Exit(1)
+		}
+		return
+	}
+	if f.writeErr != nil {
+		fmt.Println("AOF write error looks solved, the server can write again.")
+		f.writeErr = nil
+	}
+	if synced > f.synced {
+		f.synced = synced
+		f.releaseWaiters()
+	}
+}
+
+// releaseWaiters wakes the clients waiting for offsets now fsynced, or all of
+// them once the file is closed
+// Callers must hold f.mu.
+func (f *aofFile) releaseWaiters() {
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if f.closed || w.offset <= f.synced {
+			close(w.ready)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	f.waiters = waiting
+}
+
+// close stops the flusher once it wrote and fsynced everything appended,
+// ending a transaction left open, and closes the file
+func (f *aofFile) close() {
+	f.endTransaction()
+	close(f.stop)
+	<-f.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.file.Close()
+		f.closed = true
+		f.releaseWaiters()
+	}
+}
+
+// feed appends a command run in database db, after a SELECT if the previous
+// one ran in another database. The commands of a transaction or script are
+// wrapped in MULTI and EXEC.
+func (f *aofFile) feed(db int, args []string, transaction bool) {
+	f.mu.Lock()
+	size := len(f.buf)
+	if transaction && !f.multi {
+		f.buf = append(f.buf, formatArray([]string{"MULTI"})...)
+		f.multi = true
+	}
+	if db != f.lastDB {
+		f.buf = append(f.buf, formatArray([]string{"SELECT", strconv.Itoa(db)})...)
+		f.lastDB = db
+	}
+	f.buf = append(f.buf, formatArray(args)...)
+	f.appended += int64(len(f.buf) - size)
+	f.mu.Unlock()
+	f.signal()
+}
+
+// endTransaction appends the EXEC of the transaction or script running, if
+// any of its commands was appended
+func (f *aofFile) endTransaction() {
+	f.mu.Lock()
+	if !f.multi {
+		f.mu.Unlock()
+		return
+	}
+	size := len(f.buf)
+	f.buf = append(f.buf, formatArray([]string{"EXEC"})...)
+	f.appended += int64(len(f.buf) - size)
+	f.multi = false
+	f.mu.Unlock()
+	f.signal()
+}
+
+// signal wakes the flusher, unless it is already due to run
+func (f *aofFile) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// offset returns the offset of the end of what was appended
+func (f *aofFile) offset() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.appended
+}
+
+// fsyncedTo reports whether the file is fsynced up to offset
+func (f *aofFile) fsyncedTo(offset int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.synced >= offset
+}
+
+// waitFsync returns a channel closed once the file is fsynced up to offset,
+// or closed without it
+func (f *aofFile) waitFsync(offset int64) <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ready := make(chan struct{})
+	if f.closed || f.synced >= offset {
+		close(ready)
+		return ready
+	}
+	f.waiters = append(f.waiters, aofWaiter{offset: offset, ready: ready})
+	return ready
+}
+
+// Commands that change the dataset through the commands they run, which are
+// appended instead, wrapped in MULTI and EXEC
+var aofContainerCommands = map[string]bool{
+	"exec": true, "eval": true, "evalsha": true, "fcall": true, "fcall_ro": true,
+}
+
+// Commands that change the dataset without counting as key changes,
+// appended whenever they succeed
+var aofUnkeyedCommands = map[string]bool{
+	"flushdb": true, "flushall": true, "swapdb": true,
+	"function|load": true, "function|delete": true, "function|restore": true, "function|flush": true,
+}
+
+// lockAOF takes aofMu while the client runs a command with appendonly on,
+// unless it runs within EXEC or a script, or without the transaction lock.
+// Returns the function releasing it, which with appendfsync always then waits
+// for what the command appended to be fsynced.
+func (c *Client) lockAOF() func() {
+	f := aof.Load()
+	if f == nil || c.inExec || !c.execLocked {
+		return func() {}
+	}
+	aofMu.Lock()
+	c.aofLocked = true
+	start := f.offset()
+	return func() {
+		end := f.offset()
+		c.aofLocked = false
+		aofMu.Unlock()
+		if end == start {
+			return
+		}
+		c.aofFile, c.aofOffset = f, end
+		if appendFsync() == fsyncAlways {
+			<-f.waitFsync(end)
+		}
+	}
+}
+
+// lockServerWrite takes the locks a command holds, for a change the server
+// makes on its own, such as archiving an idle key, so that with appendonly on
+// it is appended in order with the commands. Returns the function releasing
+// them.
+func lockServerWrite() func() {
+	execMu.RLock()
+	if aof.Load() == nil {
+		return execMu.RUnlock
+	}
+	aofMu.Lock()
+	return func() {
+		aofMu.Unlock()
+		execMu.RUnlock()
+	}
+}
+
+// feedAOF appends a command that changed the dataset to the append-only file,
+// in the form its handler rewrote it to, if it did. A command that waited in a
+// blocking command is appended by the client that served it instead.
+func (c *Client) feedAOF(cmd *Command, args []string, reply string, changed bool) {
+	waited, rewritten := c.aofWaited, c.aofRewrite
+	c.aofWaited, c.aofRewrite = false, nil
+	f := aof.Load()
+	if f == nil || waited || aofContainerCommands[cmd.Canonical] {
+		return
+	}
+	if !changed && (!aofUnkeyedCommands[cmd.Canonical] || strings.HasPrefix(reply, "-")) {
+		return
+	}
+	if rewritten == nil {
+		if canonical := cmd.topLevel().Canonical; !strings.EqualFold(args[0], canonical) {
+			// Replayed under the command's own name, whatever aliases the
+			// server loading the file has
+			args = append([]string{canonical}, args[1:]...)
+		}
+		rewritten = [][]string{args}
+	}
+	f.feedAll(c.dbIndex, rewritten, c.inExec)
+}
+
+// rewriteAOF makes the command running append cmds to the append-only file in
+// its place, for commands whose effects would differ when replayed: random
+// picks, generated IDs and relative times. No commands appends nothing.
+func (c *Client) rewriteAOF(cmds ...[]string) {
+	c.aofRewrite = append([][]string{}, cmds...)
+}
+
+// feedServed appends the command of a blocked client that was just served
+// and changed the dataset, in the database the client waited in
+func feedServed(bc *blockedClient) {
+	f := aof.Load()
+	if f == nil {
+		return
+	}
+	cmds := [][]string{bc.command}
+	if bc.client != nil && bc.client.aofRewrite != nil {
+		cmds, bc.client.aofRewrite = bc.client.aofRewrite, nil
+	}
+	f.feedAll(bc.dbIndex, cmds, false)
+}
+
+// feedAll appends the commands one command is replayed as, followed by a
+// PEXPIREAT for each key it stored that the default TTL policy gave an
+// expiration time, so replay keeps that time instead of starting a new TTL.
+// Several commands are wrapped in MULTI and EXEC unless a transaction or
+// script already wraps them.
+func (f *aofFile) feedAll(db int, cmds [][]string, transaction bool) {
+	expires, dbs := defaultExpires.take()
+	wrap := !transaction && len(cmds)+len(expires) > 1
+	for _, args := range cmds {
+		f.feed(db, args, transaction || wrap)
+	}
+	for i, e := range expires {
+		if dbs[i] >= 0 {
+			f.feed(dbs[i], []string{"PEXPIREAT", e.key, strconv.FormatInt(e.at.UnixMilli(), 10)}, transaction || wrap)
+		}
+	}
+	if wrap {
+		f.endTransaction()
+	}
+}
+
+// aofFsynced reports whether what the client appended to the append-only file
+// f is fsynced. Appends to a previous file were fsynced when it was closed.
+func (c *Client) aofFsynced(f *aofFile) bool {
+	return c.aofFile != f || f.fsyncedTo(c.aofOffset)
+}
+
+// flushAOF writes and fsyncs everything appended, before the process exits
+func flushAOF() {
+	if f := aof.Load(); f != nil {
+		fmt.Println("Calling fsync() on the AOF file.")
+		f.flush(true)
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// loadAOF replays the append-only file at path into databases: the RDB
+// preamble it starts with, if any, then its commands. A file cut short in the
+// middle of a command or transaction, as a crash leaves it, is truncated to
+// the last complete one.
+// Returns the number of keys the preamble held and of commands replayed.
+func loadAOF(path string) (keys, commands int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	counted := &countingReader{r: loading.track(file)}
+	r := bufio.NewReaderSize(counted, rdbFlushSize)
+	offset := func() int64 { return counted.n - int64(r.Buffered()) }
+
+	if head, _ := r.Peek(5); string(head) == "REDIS" {
+		if keys, _, err = loadRDB(r, databases); err != nil {
+			return 0, 0, fmt.Errorf("RDB preamble: %v", err)
+		}
+	}
+
+	// Blocking commands do not block, nor does the load refuse commands
+	client := &Client{inExec: true, replaying: true}
+	valid := offset() // End of the last complete command outside a transaction
+	for {
+		if next, err := r.Peek(1); err == nil && next[0] == '#' {
+			// Annotation, as Redis writes them
+			if _, err := r.ReadString('\n'); err != nil {
+				break
+			}
+			if client.multi == nil {
+				valid = offset()
+			}
+			continue
+		}
+		args, err := ReadRESP(r)
+		if err == io.EOF && offset() == valid {
+			return keys, commands, nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return keys, commands, fmt.Errorf("bad file format reading the append only file: %v", err)
+		}
+		if len(args) == 0 {
+			return keys, commands, errors.New("bad file format reading the append only file: empty command")
+		}
+		if lookupCommand(args[0]) == nil {
+			return keys, commands, fmt.Errorf("unknown command '%s' reading the append only file", args[0])
+		}
+		client.execute(args)
+		commands++
+		if client.multi == nil {
+			valid = offset()
+		}
+	}
+
+	fmt.Printf("!!! Warning: short read while loading the AOF file %s, truncating it at offset %d\n", path, valid)
+	if err := os.Truncate(path, valid); err != nil {
+		return keys, commands, err
+	}
+	return keys, commands, nil
+}
+
+// loadAOFOnStartup replays the append-only file, when appendonly is on and
+// there is one, while the server answers -LOADING; the dump file is then
+// not loaded, as in Redis. What it holds does not count as changes to save.
+// Returns whether it was loaded.
+func loadAOFOnStartup() (bool, error) {
+	if !appendOnly() {
+		return false, nil
+	}
+	path := aofPath()
+	start := time.Now()
+	keys, commands, err := loadAOF(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	saves.mu.Lock()
+	saves.savedChanges = keyChanges.Load()
+	saves.mu.Unlock()
+	fmt.Printf("Done loading AOF, keys in the RDB preamble: %d, commands replayed: %d.\n", keys, commands)
+	fmt.Printf("DB loaded from append only file: %.3f seconds\n", time.Since(start).Seconds())
+	return true, nil
+}
+
+// infoAOF returns the append-only file fields of INFO persistence
+func infoAOF() []string {
+	f := aof.Load()
+	if f == nil {
+		return []string{"aof_enabled:0", "aof_rewrite_in_progress:0", "aof_last_write_status:ok"}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rewriting, status := 0, "ok"
+	if f.rewriting {
+		rewriting = 1
+	}
+	if f.writeErr != nil {
+		status = "err"
+	}
+	return []string{
+		"aof_enabled:1",
+		fmt.Sprintf("aof_rewrite_in_progress:%d", rewriting),
+		"aof_last_write_status:" + status,
+		fmt.Sprintf("aof_current_size:%d", f.size),
+		fmt.Sprintf("aof_buffer_length:%d", len(f.buf)),
+	}
+}
+
+// parseAppendFsync checks an appendfsync policy
+func parseAppendFsync(value string) (string, error) {
+	switch policy := strings.ToLower(value); policy {
+	case fsyncAlways, fsyncEverysec, fsyncNo:
+		return policy, nil
+	}
+	return "", fmt.Errorf("argument must be one of 'always', 'everysec' or 'no', got '%s'", value)
+}
+
+// validateAppendFilename checks that name is a file name, not a path
+func validateAppendFilename(name string) error {
+	if name == "" || filepath.Base(name) != name || name == "." || name == ".." {
+		return errors.New("appendfilename can't be a path, just a filename")
+	}
+	return nil
+}
+
+// Parameters of CONFIG GET and CONFIG SET for the append-only file.
+// appendfilename takes effect the next time the file is opened.
+var aofConfigParams = []configParam{
+	{
+		name: "appendonly",
+		get: func() string {
+			if appendOnly() {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			appendOnlyOverride.Store(&enabled)
+			if !enabled {
+				// The command turning it off holds aofMu, or runs within
+				// EXEC or a script: nothing is appended meanwhile
+				if f := aof.Swap(nil); f != nil {
+					f.close()
+				}
+			} else if aof.Load() == nil {
+				// Waits for the transaction lock, which the command
+				// turning it on holds for reading
+				go enableAOF()
+			}
+			return nil
+		},
+	},
+	{
+		name:      "appendfilename",
+		get:       func() string { return filepath.Base(aofPath()) },
+		protected: true,
+		set: func(value string) error {
+			if err := validateAppendFilename(value); err != nil {
+				return err
+			}
+			appendFilenameOverride.Store(&value)
+			return nil
+		},
+	},
+	{
+		name: "appendfsync",
+		get:  appendFsync,
+		set: func(value string) error {
+			policy, err := parseAppendFsync(value)
+			if err != nil {
+				return err
+			}
+			appendFsyncOverride.Store(&policy)
+			return nil
+		},
+	},
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stopAOF closes the append-only file, as CONFIG SET appendonly no does
+func stopAOF() {
+	if f := aof.Swap(nil); f != nil {
+		f.close()
+	}
+}
+
+// replayAOF replays the append-only file into fresh databases
+func replayAOF(t *testing.T, path string) {
+	t.Helper()
+	databases = NewDatabases(2)
+	if _, _, err := loadAOF(path); err != nil {
+		t.Fatalf("expected the append-only file to replay: %v", err)
+	}
+}
+
+func TestAOF(t *testing.T) {
+	saved, savedSaves, savedDir := databases, saves, dirOverride.Load()
+	databases = NewDatabases(2)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		stopAOF()
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+		appendOnlyOverride.Store(nil)
+		appendFsyncOverride.Store(nil)
+	}()
+	dir := t.TempDir()
+	dirOverride.Store(&dir)
+	path := filepath.Join(dir, defaultAppendFilename)
+	client := &Client{}
+
+	// Test 1: The directives parse, and invalid values are refused
+	cfg, err := ParseConfig(strings.NewReader("appendonly yes\nappendfilename log.aof\nappendfsync ALWAYS\n"))
+	if err != nil || !cfg.AppendOnly || cfg.AppendFilename != "log.aof" || cfg.AppendFsync != fsyncAlways {
+		t.Fatalf("expected the directives to parse, got %+v %v", cfg, err)
+	}
+	if cfg := DefaultConfig(); cfg.AppendOnly || cfg.AppendFilename != "appendonly.aof" || cfg.AppendFsync != "everysec" {
+		t.Fatalf("unexpected defaults %v %q %q", cfg.AppendOnly, cfg.AppendFilename, cfg.AppendFsync)
+	}
+	for _, input := range []string{"appendonly maybe\n", "appendfsync sometimes\n", "appendfilename dir/log.aof\n"} {
+		if _, err := ParseConfig(strings.NewReader(input)); err == nil {
+			t.Fatalf("expected %q to be refused", input)
+		}
+	}
+
+	// Test 2: Write commands are appended in RESP, after a SELECT when the
+	// database changes; reads and failed writes are not
+	client.execute([]string{"SET", "before", "1"})
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to open: %v", err)
+	}
+	client.execute([]string{"SET", "k", "v"})
+	client.execute([]string{"GET", "k"})
+	client.execute([]string{"LPUSH", "k", "x"})
+	client.execute([]string{"SELECT", "1"})
+	client.execute([]string{"RPUSH", "list", "a", "b"})
+	client.execute([]string{"SELECT", "0"})
+	stopAOF()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected the append-only file to exist: %v", err)
+	}
+	body := string(data[strings.Index(string(data), "*2\r\n$6\r\nSELECT"):])
+	want := formatArray([]string{"SELECT", "0"}) + formatArray([]string{"SET", "k", "v"}) +
+		formatArray([]string{"SELECT", "1"}) + formatArray([]string{"RPUSH", "list", "a", "b"})
+	if body != want {
+		t.Fatalf("expected %q, got %q", want, body)
+	}
+
+	// Test 3: A file created from existing data starts with an RDB preamble
+	// holding it, and replays with the commands after it
+	if !strings.HasPrefix(string(data), "REDIS0011") || !strings.Contains(string(data), "aof-base\xc0\x01") {
+		t.Fatalf("expected an RDB preamble, got %q", data[:20])
+	}
+	replayAOF(t, path)
+	if reply := client.execute([]string{"MGET", "before", "k"}); reply != "*2\r\n$1\r\n1\r\n$1\r\nv\r\n" {
+		t.Fatalf("expected the preamble and commands to replay, got %q", reply)
+	}
+	if reply := client.execute([]string{"LRANGE", "list", "0", "-1"}); reply != "*0\r\n" {
+		t.Fatalf("expected the list in database 1 only, got %q", reply)
+	}
+
+	// Test 4: Transactions and scripts are wrapped in MULTI and EXEC, and
+	// commands whose effects are random or timed are appended rewritten
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to reopen: %v", err)
+	}
+	client.execute([]string{"MULTI"})
+	client.execute([]string{"HINCRBY", "h", "f", "1"})
+	client.execute([]string{"HINCRBY", "h", "f", "1"})
+	client.execute([]string{"EXEC"})
+	client.execute([]string{"EVAL", "redis.call('SET', 'script', 'ran') return redis.call('GET', 'script')", "0"})
+	client.execute([]string{"SADD", "set", "m"})
+	client.execute([]string{"SPOP", "set"})
+	id := client.execute([]string{"XADD", "stream", "*", "f", "v"})
+	payload, _, _ := databases.Get(0).Dump("k")
+	client.execute([]string{"RESTORE", "restored", "100000", payload})
+	client.execute([]string{"FLUSHDB"})
+	client.execute([]string{"FLUSHDB"})
+	client.execute([]string{"SET", "after", "flush"})
+	stopAOF()
+	data, _ = os.ReadFile(path)
+	for _, fragment := range []string{
+		formatArray([]string{"HINCRBY", "h", "f", "1"}) + formatArray([]string{"HINCRBY", "h", "f", "1"}) + formatArray([]string{"EXEC"}),
+		formatArray([]string{"MULTI"}) + formatArray([]string{"SET", "script", "ran"}) + formatArray([]string{"EXEC"}),
+		formatArray([]string{"SREM", "set", "m"}),
+		formatArray([]string{"XADD", "stream", id[strings.Index(id, "\n")+1 : len(id)-2], "f", "v"}),
+		"$6\r\nABSTTL\r\n",
+	} {
+		if !strings.Contains(string(data), fragment) {
+			t.Fatalf("expected the file to hold %q, got %q", fragment, data)
+		}
+	}
+	if strings.Contains(string(data), "EVAL") || strings.Contains(string(data), "SPOP") || strings.Count(string(data), "FLUSHDB") != 2 {
+		t.Fatalf("expected scripts and SPOP not to be appended, and both FLUSHDBs to be")
+	}
+	replayAOF(t, path)
+	if reply := client.execute([]string{"DBSIZE"}); reply != ":1\r\n" || client.execute([]string{"GET", "after"}) != "$5\r\nflush\r\n" {
+		t.Fatalf("expected only the key set after FLUSHDB, got %q", reply)
+	}
+
+	// Test 5: A blocked command is appended by the client serving it,
+	// right after the command or transaction that served it
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to reopen: %v", err)
+	}
+	blocked := &Client{}
+	done := make(chan string)
+	go func() { done <- blocked.execute([]string{"BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"}) }()
+	for blockedClients.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.execute([]string{"MULTI"})
+	client.execute([]string{"RPUSH", "src", "a", "b"})
+	client.execute([]string{"EXEC"})
+	if reply := <-done; reply != "$1\r\na\r\n" {
+		t.Fatalf("expected BLMOVE to move a, got %q", reply)
+	}
+	client.execute([]string{"LPOP", "src"})
+	stopAOF()
+	data, _ = os.ReadFile(path)
+	want = formatArray([]string{"MULTI"}) + formatArray([]string{"SELECT", "0"}) + formatArray([]string{"RPUSH", "src", "a", "b"}) +
+		formatArray([]string{"EXEC"}) + formatArray([]string{"BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"}) +
+		formatArray([]string{"LPOP", "src"})
+	if !strings.HasSuffix(string(data), want) {
+		t.Fatalf("expected the served BLMOVE after RPUSH, got %q", data)
+	}
+	replayAOF(t, path)
+	if reply := client.execute([]string{"LRANGE", "dst", "0", "-1"}); reply != "*1\r\n$1\r\na\r\n" {
+		t.Fatalf("expected dst to hold a after the replay, got %q", reply)
+	}
+
+	// Test 6: WAITAOF waits for the local fsync: at once with appendfsync
+	// always, within a second with everysec
+	if reply := client.execute([]string{"WAITAOF", "1", "0", "0"}); !strings.HasPrefix(reply, "-ERR WAITAOF cannot be used") {
+		t.Fatalf("expected WAITAOF to need appendonly, got %q", reply)
+	}
+	for _, policy := range []string{fsyncAlways, fsyncEverysec, fsyncNo} {
+		client.execute([]string{"CONFIG", "SET", "appendfsync", policy})
+		if err := startAOF(); err != nil {
+			t.Fatalf("expected the append-only file to reopen: %v", err)
+		}
+		client.execute([]string{"SET", "k", policy})
+		f := aof.Load()
+		if policy == fsyncAlways && !f.fsyncedTo(f.offset()) {
+			t.Fatalf("expected the write to be fsynced before the reply")
+		}
+		start := time.Now()
+		if reply := client.execute([]string{"WAITAOF", "1", "0", "0"}); reply != "*2\r\n:1\r\n:0\r\n" {
+			t.Fatalf("expected WAITAOF to get the local fsync with %s, got %q", policy, reply)
+		}
+		if time.Since(start) > 1500*time.Millisecond {
+			t.Fatalf("expected the fsync within a second with %s", policy)
+		}
+		stopAOF()
+	}
+	client.execute([]string{"CONFIG", "SET", "appendfsync", "everysec"})
+
+	// Test 7: A file cut short in a command or transaction is truncated to
+	// the last complete command
+	complete, _ := os.ReadFile(path)
+	for _, tail := range []string{"*3\r\n$3\r\nSET\r\n$1\r\nx", formatArray([]string{"MULTI"}) + formatArray([]string{"SET", "x", "1"})} {
+		os.WriteFile(path, append(append([]byte{}, complete...), tail...), 0o644)
+		replayAOF(t, path)
+		if reply := client.execute([]string{"GET", "x"}); reply != "$-1\r\n" {
+			t.Fatalf("expected the incomplete tail not to apply, got %q", reply)
+		}
+		if truncated, _ := os.ReadFile(path); string(truncated) != string(complete) {
+			t.Fatalf("expected the file truncated to %d bytes, got %d", len(complete), len(truncated))
+		}
+	}
+	os.WriteFile(path, append(append([]byte{}, complete...), formatArray([]string{"NOSUCHCOMMAND"})...), 0o644)
+	databases = NewDatabases(2)
+	if _, _, err := loadAOF(path); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("expected an unknown command to fail the load, got %v", err)
+	}
+	os.WriteFile(path, complete, 0o644)
+
+	// Test 8: CONFIG SET appendonly yes writes a new file from the dataset,
+	// and no closes it; INFO persistence reports it
+	databases = NewDatabases(2)
+	client.execute([]string{"SET", "runtime", "1"})
+	if reply := client.execute([]string{"CONFIG", "SET", "appendonly", "yes"}); reply != "+OK\r\n" {
+		t.Fatalf("expected CONFIG SET appendonly to succeed, got %q", reply)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(client.execute([]string{"INFO", "persistence"}), "aof_rewrite_in_progress:0") || aof.Load() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the append-only file to open")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	info := client.execute([]string{"INFO", "persistence"})
+	if !strings.Contains(info, "aof_enabled:1\r\n") || !strings.Contains(info, "aof_last_write_status:ok\r\n") {
+		t.Fatalf("unexpected INFO persistence %q", info)
+	}
+	client.execute([]string{"SET", "appended", "1"})
+	if reply := client.execute([]string{"CONFIG", "SET", "appendonly", "no"}); reply != "+OK\r\n" || aof.Load() != nil {
+		t.Fatalf("expected CONFIG SET appendonly no to close the file, got %q", reply)
+	}
+	replayAOF(t, path)
+	if reply := client.execute([]string{"MGET", "runtime", "appended", "k"}); reply != "*3\r\n$1\r\n1\r\n$1\r\n1\r\n$-1\r\n" {
+		t.Fatalf("expected the rewritten file to hold the dataset, got %q", reply)
+	}
+
+	// Test 9: At startup the file is replayed instead of the dump file,
+	// which is loaded while appendonly is off or there is no file
+	client.execute([]string{"SET", "dumped", "1"})
+	client.execute([]string{"SAVE"})
+	databases = NewDatabases(2)
+	enabled := true
+	appendOnlyOverride.Store(&enabled)
+	if loaded, err := loadAOFOnStartup(); !loaded || err != nil {
+		t.Fatalf("expected the append-only file to load, got %v %v", loaded, err)
+	}
+	if reply := client.execute([]string{"MGET", "dumped", "runtime"}); reply != "*2\r\n$-1\r\n$1\r\n1\r\n" {
+		t.Fatalf("expected only the append-only file's keys, got %q", reply)
+	}
+	os.Remove(path)
+	if loaded, err := loadAOFOnStartup(); loaded || err != nil {
+		t.Fatalf("expected a missing append-only file to be skipped, got %v %v", loaded, err)
+	}
+
+	// Test 10: Stream reads and claims are appended as the owners, delivery
+	// times and counts they leave, which replaying the idle times would change
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+	databases = NewDatabases(2)
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to reopen: %v", err)
+	}
+	client.execute([]string{"XADD", "s", "1-1", "f", "v"})
+	client.execute([]string{"XGROUP", "CREATE", "s", "g", "0"})
+	client.execute([]string{"XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", ">"})
+	vc.Advance(time.Minute)
+	client.execute([]string{"XCLAIM", "s", "g", "c2", "30000", "1-1"})
+	go func() {
+		done <- blocked.execute([]string{"XREADGROUP", "GROUP", "g", "c3", "BLOCK", "0", "STREAMS", "s", ">"})
+	}()
+	for blockedClients.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.execute([]string{"XADD", "s", "2-1", "f", "v"})
+	<-done
+	stopAOF()
+	data, _ = os.ReadFile(path)
+	ms := strconv.FormatInt(vc.Now().UnixMilli(), 10)
+	for _, fragment := range []string{
+		formatArray([]string{"XCLAIM", "s", "g", "c2", "0", "1-1", "TIME", ms, "RETRYCOUNT", "2", "FORCE", "JUSTID"}),
+		formatArray([]string{"MULTI"}) + formatArray([]string{"XCLAIM", "s", "g", "c3", "0", "2-1", "TIME", ms, "RETRYCOUNT", "1", "FORCE", "JUSTID"}) +
+			formatArray([]string{"XGROUP", "SETID", "s", "g", "2-1", "ENTRIESREAD", "2"}) + formatArray([]string{"EXEC"}),
+	} {
+		if !strings.Contains(string(data), fragment) {
+			t.Fatalf("expected the file to hold %q, got %q", fragment, data)
+		}
+	}
+	if strings.Contains(string(data), "XREADGROUP") {
+		t.Fatalf("expected XREADGROUP not to be appended, got %q", data)
+	}
+	replayAOF(t, path)
+	pending := "*2\r\n*4\r\n$3\r\n1-1\r\n$2\r\nc2\r\n:0\r\n:2\r\n*4\r\n$3\r\n2-1\r\n$2\r\nc3\r\n:0\r\n:1\r\n"
+	if reply := client.execute([]string{"XPENDING", "s", "g", "-", "+", "10"}); reply != pending {
+		t.Fatalf("expected the claims to replay, got %q", reply)
+	}
+	if reply := client.execute([]string{"XREADGROUP", "GROUP", "g", "c4", "STREAMS", "s", ">"}); reply != "*-1\r\n" {
+		t.Fatalf("expected the group to have read every entry, got %q", reply)
+	}
+
+	// Test 11: Keys given a default TTL are appended with their expiration
+	// time, which replay keeps instead of starting the TTL anew
+	databases = NewDatabases(2)
+	databases.Get(0).SetDefaultTTLs(time.Hour, nil)
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to reopen: %v", err)
+	}
+	client.execute([]string{"SET", "ttl", "v"})
+	expireTime := client.execute([]string{"PEXPIRETIME", "ttl"})
+	stopAOF()
+	data, _ = os.ReadFile(path)
+	at := strings.TrimSuffix(strings.TrimPrefix(expireTime, ":"), "\r\n")
+	if fragment := formatArray([]string{"PEXPIREAT", "ttl", at}); !strings.Contains(string(data), fragment) {
+		t.Fatalf("expected the file to hold %q, got %q", fragment, data)
+	}
+	vc.Advance(time.Minute)
+	databases = NewDatabases(2)
+	databases.Get(0).SetDefaultTTLs(time.Hour, nil)
+	if _, _, err := loadAOF(path); err != nil {
+		t.Fatalf("expected the append-only file to replay: %v", err)
+	}
+	if reply := client.execute([]string{"PEXPIRETIME", "ttl"}); reply != expireTime {
+		t.Fatalf("expected the expiration time %q to replay, got %q", expireTime, reply)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Archival cycle parameters
+const (
+	archiveInterval       = time.Second      // How often each database is scanned for idle keys
+	archiveBucketsPerScan = 128              // Keyspace buckets visited per database per cycle
+	archiveWebhookTimeout = 10 * time.Second // Deadline for one webhook delivery
+)
+
+// ArchiveHook receives a key that has been idle longer than the archive
+// threshold, before the key is deleted. The entry must be treated as
+// read-only. Returning an error keeps the key; it is offered again on a
+// later cycle.
+type ArchiveHook func(db int, key string, entry *Entry) error
+
+// archiver holds the idle-key archival policy shared by every database
+type archiver struct {
+	mu    sync.RWMutex
+	idle  time.Duration // Idle time after which keys are archived (0 disables)
+	hooks []ArchiveHook
+
+	archived atomic.Int64 // Keys handed to every hook and deleted
+	failures atomic.Int64 // Hook invocations that returned an error
+}
+
+// Global archival policy
+var archive = &archiver{}
+
+// RegisterArchiveHook adds a hook run for every key selected for archival
+// Hooks run in registration order and all of them must succeed
+func RegisterArchiveHook(hook ArchiveHook) {
+	archive.mu.Lock()
+	archive.hooks = append(archive.hooks, hook)
+	archive.mu.Unlock()
+}
+
+// SetArchiveIdle sets the idle time after which keys are archived and
+// deleted. Zero disables archival.
+func SetArchiveIdle(idle time.Duration) {
+	archive.mu.Lock()
+	archive.idle = idle
+	archive.mu.Unlock()
+}
+
+func (a *archiver) policy() (time.Duration, []ArchiveHook) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.idle, a.hooks
+}
+
+// archiveCandidate is a key found idle during a scan
+type archiveCandidate struct {
+	key      string
+	entry    *Entry // Entry in the keyspace when the key was found
+	snapshot *Entry // Copy handed to the hooks, safe to read without the lock
+}
+
+// archiveIdle visits part of the keyspace starting at cursor, runs the hooks
+// for keys idle longer than idle and deletes those every hook accepted, as a
+// DEL would: the append-only file gets one and the tombstone window keeps
+// them. Hooks get a copy of the entry and run without the store lock held; a
+// key read or rewritten in the meantime is kept. Returns the cursor to
+// continue from.
+func (s *Store) archiveIdle(db int, cursor uint64, idle time.Duration, hooks []ArchiveHook) uint64 {
+	var candidates []archiveCandidate
+	now := clockNow()
+
+	s.mu.RLock()
+	for i := 0; i < archiveBucketsPerScan; i++ {
+		cursor = s.data.scanBucket(cursor, func(key string, entry *Entry) {
+			if entry.idleTime(now) >= idle {
+				snapshot := entry.clone()
+				snapshot.lastAccess.Store(entry.lastAccess.Load())
+				candidates = append(candidates, archiveCandidate{key, entry, snapshot})
+			}
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, c := range candidates {
+		if !runArchiveHooks(hooks, db, c.key, c.snapshot) {
+			continue
+		}
+		unlock := lockServerWrite()
+		// SWAPDB may have moved the store since the scan
+		index := slices.Index(databases.All(), s)
+		s.mu.Lock()
+		if s.data.peek(c.key) == c.entry && c.entry.idleTime(clockNow()) >= idle {
+			s.data.delete(c.key)
+			s.keyModified(c.key)
+			s.bury(c.key, c.entry)
+			archive.archived.Add(1)
+			if f := aof.Load(); f != nil && index >= 0 {
+				f.feed(index, []string{"DEL", c.key}, false)
+			}
+		}
+		s.mu.Unlock()
+		unlock()
+	}
+	return cursor
+}
+
+// runArchiveHooks reports whether every hook accepted the key
+func runArchiveHooks(hooks []ArchiveHook, db int, key string, entry *Entry) bool {
+	for _, hook := range hooks {
+		if err := hook(db, key, entry); err != nil {
+			archive.failures.Add(1)
+			fmt.Printf("Archive hook failed for key '%s' in db %d: %v\n", key, db, err)
+			return false
+		}
+	}
+	return true
+}
+
+// archiveLoop periodically archives idle keys of every database while a
+// threshold and at least one hook are configured
+func archiveLoop() {
+	cursors := make(map[*Store]uint64)
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		idle, hooks := archive.policy()
+		if idle <= 0 || len(hooks) == 0 {
+			continue
+		}
+		for i, db := range databases.All() {
+			cursors[db] = db.archiveIdle(i, cursors[db], idle, hooks)
+		}
+	}
+}
+
+// archiveRecord is the JSON document posted by the webhook hook
+type archiveRecord struct {
+	DB          int         `json:"db"`
+	Key         string      `json:"key"`
+	Type        string      `json:"type"`
+	Value       interface{} `json:"value"`
+	IdleSeconds int64       `json:"idle_seconds"`
+	ExpiresAtMs int64       `json:"expires_at_ms,omitempty"`
+}
+
+// archiveValue converts an entry value to its JSON form; lists and sets
+// become arrays, sets in sorted order, and hashes and sorted sets objects
+// (sorted sets mapping members to their formatted scores)
+func archiveValue(entry *Entry) interface{} {
+	if list, ok := entry.Value.(listValue); ok {
+		return list.values()
+	}
+	if hp, ok := entry.Value.(*hashPack); ok {
+		return map[string]string(hp.hashTable())
+	}
+	if zs, ok := entry.Value.(*zset); ok {
+		scores := make(map[string]string, zs.len())
+		zs.each(func(member string, score float64) bool {
+			scores[member] = formatScore(score)
+			return true
+		})
+		return scores
+	}
+	if st, ok := entry.Value.(*stream); ok {
+		entries := make([][2]interface{}, 0, st.len())
+		st.each(func(e streamEntry) bool {
+			entries = append(entries, [2]interface{}{e.id.String(), e.fields})
+			return true
+		})
+		return entries
+	}
+	if entry.Type == TypeSet {
+		list := setMembers(asSet(entry.Value))
+		sort.Strings(list)
+		return list
+	}
+	return entry.Value
+}
+
+// webhookArchiveHook returns a hook that POSTs each archived key as JSON to
+// url. Any response other than 2xx is a failure and keeps the key.
+func webhookArchiveHook(url string) ArchiveHook {
+	client := &http.Client{Timeout: archiveWebhookTimeout}
+	return func(db int, key string, entry *Entry) error {
+		record := archiveRecord{
+			DB:          db,
+			Key:         key,
+			Type:        redisTypeNames[entry.Type],
+			Value:       archiveValue(entry),
+			IdleSeconds: int64(entry.idleTime(clockNow()) / time.Second),
+		}
+		if !entry.ExpiresAt.IsZero() {
+			record.ExpiresAtMs = entry.ExpiresAt.UnixMilli()
+		}
+		body, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeIdle backdates the last access of key
+func makeIdle(s *Store, key string, idle time.Duration) {
+	s.data.peek(key).lastAccess.Store(time.Now().Add(-idle).UnixNano())
+}
+
+func TestStoreArchiveIdle(t *testing.T) {
+	store := NewStore()
+	store.Set("old", "v1")
+	store.Set("fresh", "v2")
+	store.Set("refused", "v3")
+	makeIdle(store, "old", time.Hour)
+	makeIdle(store, "refused", time.Hour)
+
+	var seen []string
+	hooks := []ArchiveHook{func(db int, key string, entry *Entry) error {
+		seen = append(seen, key)
+		if key == "refused" {
+			return errors.New("archive unavailable")
+		}
+		if entry.Value != "v1" {
+			t.Fatalf("expected the hook to see v1, got %v", entry.Value)
+		}
+		return nil
+	}}
+
+	// Test 1: Only idle keys reach the hook, and only accepted ones are deleted
+	cursor := uint64(0)
+	for {
+		cursor = store.archiveIdle(3, cursor, time.Minute, hooks)
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected two idle keys offered, got %v", seen)
+	}
+	if _, exists, _ := store.Get("old"); exists {
+		t.Fatalf("expected archived key to be deleted")
+	}
+	if _, exists, _ := store.Get("refused"); !exists {
+		t.Fatalf("expected key refused by the hook to be kept")
+	}
+	if _, exists, _ := store.Get("fresh"); !exists {
+		t.Fatalf("expected recently used key to be kept")
+	}
+
+	// Test 2: A key accessed while its hook runs is kept
+	store.Set("raced", "v")
+	makeIdle(store, "raced", time.Hour)
+	touching := []ArchiveHook{func(db int, key string, entry *Entry) error {
+		store.Get(key)
+		return nil
+	}}
+	store.archiveIdle(0, 0, time.Minute, touching)
+	if _, exists, _ := store.Get("raced"); !exists {
+		t.Fatalf("expected key read during archival to be kept")
+	}
+}
+
+func TestArchiveAOF(t *testing.T) {
+	saved, savedSaves, savedDir := databases, saves, dirOverride.Load()
+	databases = NewDatabases(2)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		stopAOF()
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+	}()
+	dir := t.TempDir()
+	dirOverride.Store(&dir)
+	path := filepath.Join(dir, defaultAppendFilename)
+	store := databases.Get(1)
+	store.SetTombstoneWindow(time.Minute)
+	store.Set("old", "v")
+	makeIdle(store, "old", time.Hour)
+	if err := startAOF(); err != nil {
+		t.Fatalf("expected the append-only file to open: %v", err)
+	}
+	accept := []ArchiveHook{func(int, string, *Entry) error { return nil }}
+
+	// Test 1: An archived key is appended as a DEL in its database
+	for cursor := store.archiveIdle(1, 0, time.Minute, accept); cursor != 0; {
+		cursor = store.archiveIdle(1, cursor, time.Minute, accept)
+	}
+	stopAOF()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected the append-only file to exist: %v", err)
+	}
+	if want := formatArray([]string{"SELECT", "1"}) + formatArray([]string{"DEL", "old"}); !strings.HasSuffix(string(data), want) {
+		t.Fatalf("expected the file to end with %q, got %q", want, data)
+	}
+	replayAOF(t, path)
+	if _, exists, _ := databases.Get(1).Get("old"); exists {
+		t.Fatalf("expected the archived key to stay deleted on replay")
+	}
+
+	// Test 2: Within the tombstone window it can be undeleted
+	if !store.Undelete("old", false) {
+		t.Fatalf("expected the archived key to be tombstoned")
+	}
+}
+
+func TestArchiveWebhook(t *testing.T) {
+	var record archiveRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if record.Key == "reject" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	hook := webhookArchiveHook(server.URL)
+	entry := &Entry{Type: TypeSet, Value: map[string]struct{}{"b": {}, "a": {}}}
+	entry.initAccess(time.Now().Add(-2 * time.Hour))
+
+	// Test 1: The key is posted as JSON
+	if err := hook(2, "members", entry); err != nil {
+		t.Fatalf("expected webhook delivery to succeed, got %v", err)
+	}
+	values, _ := record.Value.([]interface{})
+	if record.DB != 2 || record.Type != "set" || len(values) != 2 || values[0] != "a" || record.IdleSeconds < 7200 {
+		t.Fatalf("unexpected archive record: %+v", record)
+	}
+
+	// Test 2: A non-2xx response is reported as a failure
+	if err := hook(0, "reject", entry); err == nil {
+		t.Fatalf("expected error for rejected delivery")
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Longest string BITFIELD may grow a value to, as Redis's proto-max-bulk-len
+// default; offsets past it are rejected
+const maxBitfieldString = 512 << 20
+
+// How BITFIELD SET and INCRBY handle values that do not fit their field
+const (
+	overflowWrap = iota // Keep the low bits, wrapping around
+	overflowSat         // Saturate at the field's minimum or maximum
+	overflowFail        // Leave the field alone and reply nil
+)
+
+// BITFIELD operations
+const (
+	bitfieldGet = iota
+	bitfieldSet
+	bitfieldIncrBy
+)
+
+// bitfieldOp is one GET, SET or INCRBY of a BITFIELD command
+type bitfieldOp struct {
+	kind     int   // bitfieldGet, bitfieldSet or bitfieldIncrBy
+	signed   bool  // Whether the field is a two's complement integer
+	bits     int   // Width of the field, 1-64 if signed and 1-63 if not
+	offset   int64 // Position of the field's most significant bit
+	value    int64 // Value of SET, increment of INCRBY
+	overflow int   // Overflow behavior of SET and INCRBY
+}
+
+// getBits reads the bits-wide unsigned field at offset of buf, most
+// significant bit first; bits past the end of buf read as 0
+func getBits[B string | []byte](buf B, offset int64, bits int) uint64 {
+	var v uint64
+	for i := range int64(bits) {
+		v <<= 1
+		if pos := offset + i; pos>>3 < int64(len(buf)) && buf[pos>>3]&(0x80>>(pos&7)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// setBits writes the low bits of v in the bits-wide field at offset of buf,
+// which must be long enough
+func setBits(buf []byte, offset int64, bits int, v uint64) {
+	for i := range int64(bits) {
+		pos := offset + i
+		mask := byte(0x80 >> (pos & 7))
+		if v&(1<<(int64(bits)-1-i)) != 0 {
+			buf[pos>>3] |= mask
+		} else {
+			buf[pos>>3] &^= mask
+		}
+	}
+}
+
+// signExtend interprets the low bits of v as a two's complement integer
+func signExtend(v uint64, bits int) int64 {
+	if bits < 64 && v&(1<<(bits-1)) != 0 {
+		v |= math.MaxUint64 << bits
+	}
+	return int64(v)
+}
+
+// addUnsigned adds incr to the unsigned field value v as overflow directs
+// Returns (result, whether it fit or was wrapped or saturated)
+func addUnsigned(v uint64, incr int64, bits, overflow int) (uint64, bool) {
+	limit := uint64(math.MaxUint64) >> (64 - bits)
+	sum := v + uint64(incr)
+	var over bool
+	switch {
+	case v > limit || (incr > 0 && uint64(incr) > limit-v):
+		over = true
+	case incr < 0 && uint64(-incr) > v:
+	default:
+		return sum, true
+	}
+	switch overflow {
+	case overflowSat:
+		if over {
+			return limit, true
+		}
+		return 0, true
+	case overflowFail:
+		return 0, false
+	}
+	return sum & limit, true
+}
+
+// addSigned adds incr to the signed field value v as overflow directs
+// Returns (result, whether it fit or was wrapped or saturated)
+func addSigned(v, incr int64, bits, overflow int) (int64, bool) {
+	highest := int64(math.MaxInt64 >> (64 - bits))
+	lowest := -highest - 1
+	sum := int64(uint64(v) + uint64(incr))
+	// Compare the increment with the room left in the field; a 64-bit field
+	// leaves more room than an int64 holds past 0, where the sum cannot
+	// overflow anyway
+	var over bool
+	switch {
+	case v > highest || (incr > 0 && (bits < 64 || v >= 0) && incr > highest-v):
+		over = true
+	case v < lowest || (incr < 0 && (bits < 64 || v < 0) && incr < lowest-v):
+	default:
+		return sum, true
+	}
+	switch overflow {
+	case overflowSat:
+		if over {
+			return highest, true
+		}
+		return lowest, true
+	case overflowFail:
+		return 0, false
+	}
+	return signExtend(uint64(sum), bits), true
+}
+
+// fieldValue returns the value of the field op reads or writes in buf
+func fieldValue[B string | []byte](buf B, op bitfieldOp) int64 {
+	v := getBits(buf, op.offset, op.bits)
+	if op.signed {
+		return signExtend(v, op.bits)
+	}
+	return int64(v)
+}
+
+// apply runs a SET or INCRBY on buf, which the caller grew to fit the field
+// Returns the previous value for SET and the new one for INCRBY, or nil if
+// the overflow behavior is FAIL and the new value did not fit
+func (op bitfieldOp) apply(buf []byte) *int64 {
+	current := fieldValue(buf, op)
+	var v uint64
+	if op.signed {
+		incr, base := op.value, current
+		if op.kind == bitfieldSet {
+			incr, base = 0, op.value
+		}
+		sum, ok := addSigned(base, incr, op.bits, op.overflow)
+		if !ok {
+			return nil
+		}
+		v = uint64(sum)
+	} else {
+		// A negative SET value is its two's complement, out of range of any
+		// unsigned field
+		incr, base := op.value, uint64(current)
+		if op.kind == bitfieldSet {
+			incr, base = 0, uint64(op.value)
+		}
+		sum, ok := addUnsigned(base, incr, op.bits, op.overflow)
+		if !ok {
+			return nil
+		}
+		v = sum
+	}
+	setBits(buf, op.offset, op.bits, v)
+	if op.kind == bitfieldSet {
+		return &current
+	}
+	result := fieldValue(buf, op)
+	return &result
+}
+
+// BitField runs ops in order on the string at key. SET and INCRBY create
+// the key if needed, growing the string with zero bytes to fit their field
+// even if the overflow behavior FAIL leaves it unchanged.
+// Returns (the value of each operation, nil for failed ones, isCorrectType)
+func (s *Store) BitField(key string, ops []bitfieldOp) ([]*int64, bool) {
+	readOnly := true
+	for _, op := range ops {
+		readOnly = readOnly && op.kind == bitfieldGet
+	}
+	if readOnly {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	} else {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	entry := s.data.get(key)
+	if entry != nil && entry.Type != TypeString {
+		return nil, false
+	}
+	var value string
+	if entry != nil {
+		value = entry.Value.(string)
+	}
+	results := make([]*int64, len(ops))
+	if readOnly {
+		for i, op := range ops {
+			v := fieldValue(value, op)
+			results[i] = &v
+		}
+		return results, true
+	}
+
+	buf := []byte(value)
+	for i, op := range ops {
+		if op.kind == bitfieldGet {
+			v := fieldValue(buf, op)
+			results[i] = &v
+			continue
+		}
+		if need := int((op.offset + int64(op.bits) + 7) >> 3); need > len(buf) {
+			buf = append(buf, make([]byte, need-len(buf))...)
+		}
+		results[i] = op.apply(buf)
+	}
+
+	if entry == nil {
+		s.put(key, &Entry{Type: TypeString, Value: string(buf)})
+	} else {
+		entry.Value = string(buf)
+		s.keyModified(key)
+	}
+	return results, true
+}
+
+// parseBitfieldType parses a field type such as i16 or u8
+func parseBitfieldType(s string) (signed bool, bits int, ok bool) {
+	if len(s) < 2 {
+		return false, 0, false
+	}
+	switch s[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, false
+	}
+	bits, err := strconv.Atoi(s[1:])
+	if err != nil || bits < 1 || (signed && bits > 64) || (!signed && bits > 63) {
+		return false, 0, false
+	}
+	return signed, bits, true
+}
+
+// parseBitfieldOffset parses a field offset in bits, or with a "#" prefix
+// in multiples of the field's width
+func parseBitfieldOffset(s string, bits int) (int64, bool) {
+	multiple := strings.HasPrefix(s, "#")
+	offset, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	if multiple {
+		if offset > math.MaxInt64/int64(bits) {
+			return 0, false
+		}
+		offset *= int64(bits)
+	}
+	return offset, offset>>3 < maxBitfieldString
+}
+
+// parseBitfield parses the subcommands of BITFIELD, or of BITFIELD_RO with
+// readOnly
+// Returns a non-empty error reply on failure
+func parseBitfield(args []string, readOnly bool) ([]bitfieldOp, string) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		sub := strings.ToUpper(args[i])
+		if readOnly && sub != "GET" {
+			return nil, formatError("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		var op bitfieldOp
+		switch {
+		case sub == "OVERFLOW" && i+1 < len(args):
+			i++
+			switch strings.ToUpper(args[i]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, formatError("ERR Invalid OVERFLOW type specified")
+			}
+			continue
+		case sub == "GET" && i+2 < len(args):
+			op.kind = bitfieldGet
+		case sub == "SET" && i+3 < len(args):
+			op.kind = bitfieldSet
+		case sub == "INCRBY" && i+3 < len(args):
+			op.kind = bitfieldIncrBy
+		default:
+			return nil, formatError("ERR syntax error")
+		}
+
+		var ok bool
+		if op.signed, op.bits, ok = parseBitfieldType(args[i+1]); !ok {
+			return nil, formatError("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		if op.offset, ok = parseBitfieldOffset(args[i+2], op.bits); !ok {
+			return nil, formatError("ERR bit offset is not an integer or out of range")
+		}
+		i += 2
+		if op.kind != bitfieldGet {
+			i++
+			value, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, formatError("ERR value is not an integer or out of range")
+			}
+			op.value = value
+		}
+		op.overflow = overflow
+		ops = append(ops, op)
+	}
+	return ops, ""
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+func bitfieldCommand(c *Client, args []string) string {
+	return bitfieldGeneric(c, args, false)
+}
+
+// BITFIELD_RO key [GET type offset ...]
+func bitfieldRoCommand(c *Client, args []string) string {
+	return bitfieldGeneric(c, args, true)
+}
+
+func bitfieldGeneric(c *Client, args []string, readOnly bool) string {
+	ops, errReply := parseBitfield(args[2:], readOnly)
+	if errReply != "" {
+		return errReply
+	}
+	results, ok := c.db().BitField(args[1], ops)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(results)) + "\r\n")
+	for _, result := range results {
+		if result == nil {
+			b.WriteString(formatNullBulkString())
+		} else {
+			b.WriteString(formatInteger(int(*result)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBitField(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	ints := func(values ...string) string {
+		var b strings.Builder
+		for _, v := range values {
+			if v == "nil" {
+				b.WriteString(formatNullBulkString())
+			} else {
+				b.WriteString(":" + v + "\r\n")
+			}
+		}
+		return "*" + strconv.Itoa(len(values)) + "\r\n" + b.String()
+	}
+
+	// Test 1: GET reads fields across byte boundaries, zero past the end
+	run("SET", "k", "\x0f\xf0")
+	for args, want := range map[string]string{
+		"BITFIELD k GET u8 4":          ints("255"),
+		"BITFIELD k GET i8 4":          ints("-1"),
+		"BITFIELD k GET u4 0 GET u4 4": ints("0", "15"),
+		"BITFIELD k GET u16 8":         ints("61440"),
+		"BITFIELD k GET i64 0":         ints("1148417904979476480"),
+		"BITFIELD k GET u8 #1":         ints("240"),
+		"BITFIELD missing GET i16 0":   ints("0"),
+		"BITFIELD_RO k GET U4 4":       ints("15"),
+		"BITFIELD k":                   "*0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 2: SET replies the old value and grows the string with zero bytes
+	if reply := run("BITFIELD", "new", "SET", "u8", "#2", "200", "GET", "u8", "16"); reply != ints("0", "200") {
+		t.Fatalf("unexpected SET %q", reply)
+	}
+	if reply := run("GET", "new"); reply != formatBulkString("\x00\x00\xc8") {
+		t.Fatalf("unexpected value after SET %q", reply)
+	}
+	if reply := run("BITFIELD", "new", "SET", "i5", "3", "-3", "SET", "u8", "#2", "1"); reply != ints("0", "200") {
+		t.Fatalf("unexpected second SET %q", reply)
+	}
+	if reply := run("BITFIELD", "new", "GET", "i5", "3"); reply != ints("-3") {
+		t.Fatalf("expected -3 back, got %q", reply)
+	}
+
+	// Test 3: INCRBY wraps by default, or saturates or fails with OVERFLOW
+	run("SET", "c", "")
+	// Each step builds on the previous ones
+	for _, step := range []struct{ args, want string }{
+		{"BITFIELD c INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", ints("1", "1")},
+		{"BITFIELD c INCRBY u8 0 250 INCRBY u8 0 10", ints("250", "4")},
+		{"BITFIELD c OVERFLOW SAT INCRBY u8 0 300 INCRBY u8 0 -1000", ints("255", "0")},
+		{"BITFIELD c OVERFLOW FAIL INCRBY u8 0 256 INCRBY u8 0 255", ints("nil", "255")},
+		{"BITFIELD c SET i8 8 127 INCRBY i8 8 1", ints("0", "-128")},
+		{"BITFIELD c OVERFLOW SAT INCRBY i8 8 -1 INCRBY i8 8 300", ints("-128", "127")},
+		{"BITFIELD c OVERFLOW FAIL SET i8 8 128 SET i8 8 -129 SET i8 8 -128", ints("nil", "nil", "127")},
+		{"BITFIELD c OVERFLOW WRAP SET u8 16 -1 OVERFLOW SAT SET u8 24 -1", ints("0", "0")},
+		{"BITFIELD c GET u8 16 GET u8 24", ints("255", "255")},
+		{"BITFIELD c SET i64 32 9223372036854775807 INCRBY i64 32 1", ints("0", "-9223372036854775808")},
+		{"BITFIELD c OVERFLOW SAT INCRBY i64 32 -1 INCRBY i64 32 -9223372036854775808", ints("-9223372036854775808", "-9223372036854775808")},
+		{"BITFIELD c OVERFLOW SAT SET u63 128 0 INCRBY u63 128 9223372036854775807", ints("0", "9223372036854775807")},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 4: Writes keep the key's TTL
+	databases.Get(0).Restore("timed", &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(time.Hour)}, false)
+	run("BITFIELD", "timed", "SET", "u1", "0", "1")
+	if _, _, hasTTL := databases.Get(0).TTL("timed"); !hasTTL {
+		t.Fatalf("expected BITFIELD to keep the TTL")
+	}
+
+	// Test 5: Errors
+	run("LPUSH", "list", "x")
+	typeError := "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"
+	for args, want := range map[string]string{
+		"BITFIELD k GET u64 0":         typeError,
+		"BITFIELD k GET i65 0":         typeError,
+		"BITFIELD k GET x8 0":          typeError,
+		"BITFIELD k GET i0 0":          typeError,
+		"BITFIELD k GET u8 -1":         "-ERR bit offset is not an integer or out of range\r\n",
+		"BITFIELD k GET u8 4294967296": "-ERR bit offset is not an integer or out of range\r\n",
+		"BITFIELD k SET u8 0 x":        "-ERR value is not an integer or out of range\r\n",
+		"BITFIELD k OVERFLOW LOOSE":    "-ERR Invalid OVERFLOW type specified\r\n",
+		"BITFIELD k GET u8":            "-ERR syntax error\r\n",
+		"BITFIELD k FETCH u8 0":        "-ERR syntax error\r\n",
+		"BITFIELD_RO k SET u8 0 1":     "-ERR BITFIELD_RO only supports the GET subcommand\r\n",
+		"BITFIELD list GET u8 0":       "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
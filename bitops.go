@@ -0,0 +1,311 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// word64 returns the first eight bytes of s as a little-endian word
+func word64(s string) uint64 {
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+// popcount counts the set bits of s, eight bytes at a time
+func popcount(s string) int {
+	n := 0
+	for len(s) >= 8 {
+		n += bits.OnesCount64(word64(s))
+		s = s[8:]
+	}
+	for i := range len(s) {
+		n += bits.OnesCount8(s[i])
+	}
+	return n
+}
+
+// bitRange resolves the start and end of BITCOUNT and BITPOS against a
+// length of n bytes, or bits with isBit, as Redis does: negative indexes
+// count from the end and both are then clamped into the string.
+// ok is false if the range is empty.
+func bitRange(start, end int64, n int, isBit bool) (int64, int64, bool) {
+	total := int64(n)
+	if isBit {
+		total *= 8
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start, end = max(start, 0), max(end, 0)
+	end = min(end, total-1)
+	return start, end, start <= end
+}
+
+// parseBitRange parses the "start end [BYTE|BIT]" arguments of BITCOUNT and
+// BITPOS
+// Returns a non-empty error reply on failure
+func parseBitRange(args []string) (start, end int64, isBit bool, errReply string) {
+	var err error
+	if start, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+		return 0, 0, false, formatError("ERR value is not an integer or out of range")
+	}
+	if end, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+		return 0, 0, false, formatError("ERR value is not an integer or out of range")
+	}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, false, formatError("ERR syntax error")
+		}
+	}
+	return start, end, isBit, ""
+}
+
+// bitCount counts the set bits of value within the resolved range
+func bitCount(value string, start, end int64, isBit bool) int {
+	if !isBit {
+		return popcount(value[start : end+1])
+	}
+	first, last := start>>3, end>>3
+	n := popcount(value[first : last+1])
+	// Leave out the bits of the first and last bytes outside the range
+	n -= bits.OnesCount8(value[first] &^ (0xff >> (start & 7)))
+	n -= bits.OnesCount8(value[last] & (0xff >> (end&7 + 1)))
+	return n
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func bitcountCommand(c *Client, args []string) string {
+	var start, end int64
+	isBit, ranged := false, len(args) > 2
+	switch len(args) {
+	case 2:
+	case 4, 5:
+		var errReply string
+		if start, end, isBit, errReply = parseBitRange(args[2:]); errReply != "" {
+			return errReply
+		}
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	value, exists, ok := c.db().Get(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !exists {
+		return formatInteger(0)
+	}
+	if !ranged {
+		return formatInteger(popcount(value))
+	}
+	// Unlike BITPOS, a range ending before it starts from the end is empty
+	// even when both indexes are clamped to 0
+	if start < 0 && end < 0 && start > end {
+		return formatInteger(0)
+	}
+	start, end, nonEmpty := bitRange(start, end, len(value), isBit)
+	if !nonEmpty {
+		return formatInteger(0)
+	}
+	return formatInteger(bitCount(value, start, end, isBit))
+}
+
+// bitPos returns the position of the first bit equal to bit within the
+// resolved range, counting from the start of value, or -1 if there is none
+func bitPos(value string, start, end int64, isBit bool, bit int) int64 {
+	first, last := start, end
+	var firstMask, lastMask byte // Bits of the first and last bytes outside the range
+	if isBit {
+		first, last = start>>3, end>>3
+		firstMask = ^byte(0xff >> (start & 7))
+		lastMask = 0xff >> (end&7 + 1)
+	}
+	// Bytes, and words, with no bit equal to bit
+	skip, skipWord := byte(0), uint64(0)
+	if bit == 0 {
+		skip, skipWord = 0xff, math.MaxUint64
+	}
+
+	for i := first; i <= last; i++ {
+		if i > first && i+8 <= last && word64(value[i:]) == skipWord {
+			i += 7
+			continue
+		}
+		b := value[i]
+		// Give the bits outside the range the value not searched for
+		var outside byte
+		if i == first {
+			outside |= firstMask
+		}
+		if i == last {
+			outside |= lastMask
+		}
+		if bit == 1 {
+			b &^= outside
+		} else {
+			b |= outside
+		}
+		if b != skip {
+			if bit == 0 {
+				b = ^b
+			}
+			return i*8 + int64(bits.LeadingZeros8(b))
+		}
+	}
+	return -1
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func bitposCommand(c *Client, args []string) string {
+	bit, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if bit != 0 && bit != 1 {
+		return formatError("ERR The bit argument must be 1 or 0.")
+	}
+	var start, end int64
+	isBit, endGiven := false, len(args) > 4
+	switch len(args) {
+	case 3:
+	case 4:
+		if start, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+	case 5, 6:
+		var errReply string
+		if start, end, isBit, errReply = parseBitRange(args[3:]); errReply != "" {
+			return errReply
+		}
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	value, exists, ok := c.db().Get(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !exists {
+		// A missing key counts as a string of clear bits
+		if bit == 1 {
+			return formatInteger(-1)
+		}
+		return formatInteger(0)
+	}
+	if !endGiven {
+		end = -1
+	}
+	start, end, nonEmpty := bitRange(start, end, len(value), isBit)
+	if !nonEmpty {
+		return formatInteger(-1)
+	}
+	pos := bitPos(value, start, end, isBit, bit)
+	if pos < 0 && bit == 0 && !endGiven {
+		// Without an end, the string counts as padded with clear bits
+		pos = int64(len(value)) * 8
+	}
+	return formatInteger(int(pos))
+}
+
+// BITOP operations
+const (
+	bitopAnd = iota
+	bitopOr
+	bitopXor
+	bitopNot
+)
+
+// BitOp stores in dst the bitwise op of the strings at keys, NOT taking a
+// single key. Shorter strings and missing keys count as zero bytes up to
+// the longest length; an empty result deletes dst.
+// Returns (length of the result, isCorrectType)
+func (s *Store) BitOp(op int, dst string, keys []string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := make([]string, len(keys))
+	longest := 0
+	for i, key := range keys {
+		entry := s.data.get(key)
+		if entry == nil {
+			continue
+		}
+		if entry.Type != TypeString {
+			return 0, false
+		}
+		sources[i] = entry.Value.(string)
+		longest = max(longest, len(sources[i]))
+	}
+	if longest == 0 {
+		if s.data.delete(dst) {
+			s.keyModified(dst)
+		}
+		return 0, true
+	}
+
+	result := make([]byte, longest)
+	copy(result, sources[0])
+	switch op {
+	case bitopNot:
+		for i := range result {
+			result[i] = ^result[i]
+		}
+	case bitopAnd:
+		for _, src := range sources[1:] {
+			for i := range result {
+				if i < len(src) {
+					result[i] &= src[i]
+				} else {
+					result[i] = 0
+				}
+			}
+		}
+	case bitopOr, bitopXor:
+		for _, src := range sources[1:] {
+			for i := range len(src) {
+				if op == bitopOr {
+					result[i] |= src[i]
+				} else {
+					result[i] ^= src[i]
+				}
+			}
+		}
+	}
+	s.put(dst, &Entry{Type: TypeString, Value: string(result)})
+	return longest, true
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func bitopCommand(c *Client, args []string) string {
+	var op int
+	switch strings.ToUpper(args[1]) {
+	case "AND":
+		op = bitopAnd
+	case "OR":
+		op = bitopOr
+	case "XOR":
+		op = bitopXor
+	case "NOT":
+		op = bitopNot
+		if len(args) != 4 {
+			return formatError("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	n, ok := c.db().BitOp(op, args[2], args[3:])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBitCount(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: popcount agrees with a bit-by-bit count across word boundaries
+	value := strings.Repeat("\xff\x01\x80\x0f", 9) + "abc"
+	want := 0
+	for i := range len(value) {
+		for b := value[i]; b != 0; b >>= 1 {
+			want += int(b & 1)
+		}
+	}
+	if got := popcount(value); got != want {
+		t.Fatalf("expected %d set bits, got %d", want, got)
+	}
+
+	// Test 2: Whole strings and byte ranges, negative indexes counting from the end
+	run("SET", "k", "foobar")
+	for args, want := range map[string]string{
+		"BITCOUNT k":            ":26\r\n",
+		"BITCOUNT k 0 0":        ":4\r\n",
+		"BITCOUNT k 1 1":        ":6\r\n",
+		"BITCOUNT k 1 1 BYTE":   ":6\r\n",
+		"BITCOUNT k -2 -1":      ":7\r\n",
+		"BITCOUNT k 0 100":      ":26\r\n",
+		"BITCOUNT k 4 2":        ":0\r\n",
+		"BITCOUNT k -1 -2":      ":0\r\n",
+		"BITCOUNT k 0 -100":     ":4\r\n",
+		"BITCOUNT missing":      ":0\r\n",
+		"BITCOUNT missing 0 -1": ":0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 3: BIT ranges count partial bytes
+	// "f" is 01100110, "o" is 01101111 and "r" is 01110010
+	for args, want := range map[string]string{
+		"BITCOUNT k 5 30 BIT":  ":17\r\n",
+		"BITCOUNT k 0 7 bit":   ":4\r\n",
+		"BITCOUNT k 1 2 BIT":   ":2\r\n",
+		"BITCOUNT k 3 4 BIT":   ":0\r\n",
+		"BITCOUNT k 6 9 BIT":   ":2\r\n",
+		"BITCOUNT k -8 -1 BIT": ":4\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 4: Errors
+	run("LPUSH", "list", "a")
+	for args, want := range map[string]string{
+		"BITCOUNT k 0":          "-ERR syntax error\r\n",
+		"BITCOUNT k 0 1 WORD":   "-ERR syntax error\r\n",
+		"BITCOUNT k 0 1 BIT x":  "-ERR syntax error\r\n",
+		"BITCOUNT k x 1":        "-ERR value is not an integer or out of range\r\n",
+		"BITCOUNT list":         "-" + wrongTypeError + "\r\n",
+		"BITCOUNT list 0 1 BIT": "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("SET", "a", "\xf0\x0f\xff")
+	run("SET", "b", "\x3c")
+
+	// Test 1: Shorter sources count as zero bytes up to the longest length
+	for op, want := range map[string]string{
+		"AND": "\x30\x00\x00",
+		"OR":  "\xfc\x0f\xff",
+		"XOR": "\xcc\x0f\xff",
+	} {
+		if reply := run("BITOP", op, "dst", "a", "b"); reply != ":3\r\n" {
+			t.Fatalf("expected BITOP %s to reply the length 3, got %q", op, reply)
+		}
+		if reply := run("GET", "dst"); reply != formatBulkString(want) {
+			t.Fatalf("unexpected BITOP %s result %q", op, reply)
+		}
+	}
+	if reply := run("BITOP", "and", "dst", "a", "missing"); reply != ":3\r\n" || run("GET", "dst") != formatBulkString("\x00\x00\x00") {
+		t.Fatalf("expected AND with a missing key to be all zeros, got %q", reply)
+	}
+
+	// Test 2: NOT inverts a single key
+	if reply := run("BITOP", "NOT", "dst", "a"); reply != ":3\r\n" || run("GET", "dst") != formatBulkString("\x0f\xf0\x00") {
+		t.Fatalf("unexpected BITOP NOT %q", reply)
+	}
+	if reply := run("BITOP", "NOT", "dst", "a", "b"); reply != "-ERR BITOP NOT must be called with a single source key.\r\n" {
+		t.Fatalf("expected a single source error, got %q", reply)
+	}
+
+	// Test 3: An empty result deletes the destination
+	if reply := run("BITOP", "OR", "dst", "missing", "other"); reply != ":0\r\n" {
+		t.Fatalf("expected 0, got %q", reply)
+	}
+	if _, found := databases.Get(0).KeyType("dst"); found {
+		t.Fatalf("expected an empty result to delete the destination")
+	}
+
+	// Test 4: Errors
+	run("LPUSH", "list", "x")
+	if reply := run("BITOP", "NAND", "dst", "a"); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected a syntax error, got %q", reply)
+	}
+	if reply := run("BITOP", "AND", "dst", "a", "list"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+	if reply := run("BITOP", "AND", "list", "a"); reply != ":3\r\n" {
+		t.Fatalf("expected the destination to be replaced whatever its type, got %q", reply)
+	}
+}
+
+func TestBitPos(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("SET", "k", "\xff\xf0\x00")
+	run("SET", "ones", "\xff\xff\xff")
+	run("SET", "long", strings.Repeat("\x00", 40)+"\x02"+strings.Repeat("\xff", 40))
+
+	for args, want := range map[string]string{
+		// Test 1: Whole strings and byte ranges
+		"BITPOS k 0":            ":12\r\n",
+		"BITPOS k 1":            ":0\r\n",
+		"BITPOS k 1 2":          ":-1\r\n",
+		"BITPOS k 1 1":          ":8\r\n",
+		"BITPOS k 0 -1":         ":16\r\n",
+		"BITPOS k 1 -2 -1 BYTE": ":8\r\n",
+		"BITPOS k 1 3":          ":-1\r\n",
+		"BITPOS long 1":         ":326\r\n",
+		"BITPOS long 0 41":      ":648\r\n",
+		"BITPOS long 0 41 -1":   ":-1\r\n",
+		"BITPOS long 0 40":      ":320\r\n",
+
+		// Test 2: Clear bits past the end count only without an explicit end
+		"BITPOS ones 0":          ":24\r\n",
+		"BITPOS ones 0 1":        ":24\r\n",
+		"BITPOS ones 0 0 -1":     ":-1\r\n",
+		"BITPOS ones 0 5 -1 BIT": ":-1\r\n",
+		"BITPOS ones 0 5 BIT":    "-ERR value is not an integer or out of range\r\n",
+		"BITPOS missing 0":       ":0\r\n",
+		"BITPOS missing 1":       ":-1\r\n",
+		"BITPOS missing 0 1 2":   ":0\r\n",
+
+		// Test 3: BIT ranges start and stop within bytes
+		"BITPOS k 1 3 10 BIT":   ":3\r\n",
+		"BITPOS k 0 3 10 BIT":   ":-1\r\n",
+		"BITPOS k 0 3 12 BIT":   ":12\r\n",
+		"BITPOS k 1 13 23 BIT":  ":-1\r\n",
+		"BITPOS k 0 2 3 bit":    ":-1\r\n",
+		"BITPOS k 0 -12 -1 BIT": ":12\r\n",
+		"BITPOS k 1 9 9 BIT":    ":9\r\n",
+		"BITPOS k 0 9 9 BIT":    ":-1\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 4: Errors
+	run("LPUSH", "list", "x")
+	for args, want := range map[string]string{
+		"BITPOS k 2":           "-ERR The bit argument must be 1 or 0.\r\n",
+		"BITPOS k x":           "-ERR value is not an integer or out of range\r\n",
+		"BITPOS k 1 x":         "-ERR value is not an integer or out of range\r\n",
+		"BITPOS k 1 0 1 WORD":  "-ERR syntax error\r\n",
+		"BITPOS k 1 0 1 BIT x": "-ERR syntax error\r\n",
+		"BITPOS list 1":        "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// blockedClient is a client parked by a blocking command until a write to
+// one of its keys lets it be served
+type blockedClient struct {
+	keys   []string
+	serve  serveFunc   // Tries to serve from key under the write lock
+	reply  chan string // Receives the reply once served (buffered)
+	served bool        // Set once served or cancelled, under the write lock
+
+	// Database whose queues hold the client. SWAPDB moves the queues along
+	// with the index, so the client keeps waiting on the database it selected.
+	home atomic.Pointer[Store]
+
+	// The blocking command and the database it runs in, appended to the
+	// append-only file by the client serving it, in the form serve rewrote
+	// it to on the blocked client, if it did
+	client  *Client
+	command []string
+	dbIndex int
+}
+
+// serveFunc tries to serve a blocked command from key of db, the database
+// the client waits in, reporting whether it did
+type serveFunc func(db *Store, key string) (string, bool)
+
+// Number of clients currently blocked, across all databases
+var blockedClients atomic.Int64
+
+// signalReady marks key as worth retrying for the clients blocked on it.
+// Called by keyModified with the write lock held.
+func (s *Store) signalReady(key string) {
+	if len(s.blocked[key]) == 0 {
+		return
+	}
+	if s.readyKeys == nil {
+		s.readyKeys = make(map[string]struct{})
+	}
+	s.readyKeys[key] = struct{}{}
+	s.hasReady.Store(true)
+}
+
+// serveOrBlock tries serve on each key in order, as the command would without
+// blocking. If no key can serve it, the client is queued on every key.
+// Keys holding a type other than keyType fail with WRONGTYPE.
+// Returns the reply, or the blocked client to wait on.
+func (s *Store) serveOrBlock(keys []string, keyType string, serve serveFunc) (string, *blockedClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if entry := s.data.get(key); entry != nil && entry.Type != keyType {
+			return formatError(wrongTypeError), nil
+		}
+	}
+	for _, key := range keys {
+		if reply, ok := serve(s, key); ok {
+			return reply, nil
+		}
+	}
+
+	bc := &blockedClient{keys: keys, serve: serve, reply: make(chan string, 1)}
+	bc.home.Store(s)
+	if s.blocked == nil {
+		s.blocked = make(map[string][]*blockedClient)
+	}
+	for _, key := range keys {
+		queue := s.blocked[key]
+		if len(queue) > 0 && queue[len(queue)-1] == bc {
+			continue // Key repeated in the command
+		}
+		s.blocked[key] = append(queue, bc)
+	}
+	blockedClients.Add(1)
+	return "", bc
+}
+
+// unblock removes a client from the queues of all its keys
+// Callers must hold the write lock.
+func (s *Store) unblock(bc *blockedClient) {
+	bc.served = true
+	for _, key := range bc.keys {
+		queue := s.blocked[key]
+		for i, waiting := range queue {
+			if waiting == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.blocked, key)
+		} else {
+			s.blocked[key] = queue
+		}
+	}
+	blockedClients.Add(-1)
+}
+
+// swapBlocked exchanges the blocked clients of s and other, as SWAPDB
+// exchanges their indexes, so every client keeps waiting on the index it
+// selected. Keys the clients wait on that their new database holds are ready.
+// Callers must hold both write locks.
+func (s *Store) swapBlocked(other *Store) {
+	s.blocked, other.blocked = other.blocked, s.blocked
+	for _, db := range []*Store{s, other} {
+		db.readyKeys = nil
+		db.hasReady.Store(false)
+		for key, queue := range db.blocked {
+			for _, bc := range queue {
+				bc.home.Store(db)
+			}
+			if db.data.get(key) != nil {
+				db.signalReady(key)
+			}
+		}
+	}
+}
+
+// lockHome write-locks the database whose queues hold bc
+// Returns the database, for the caller to unlock
+func (bc *blockedClient) lockHome() *Store {
+	for {
+		s := bc.home.Load()
+		s.mu.Lock()
+		if bc.home.Load() == s {
+			return s
+		}
+		s.mu.Unlock() // Moved by SWAPDB in the meantime
+	}
+}
+
+// cancel stops waiting for bc, unless it was served in the meantime
+// Returns (reply, served)
+func (bc *blockedClient) cancel() (string, bool) {
+	s := bc.lockHome()
+	defer s.mu.Unlock()
+
+	if bc.served {
+		return <-bc.reply, true
+	}
+	s.unblock(bc)
+	return "", false
+}
+
+// serveBlocked serves the clients blocked on keys written since the last
+// call, in the order they blocked. Serving a client may write other keys
+// (BLMOVE pushes to its destination), which are then served in turn.
+func (s *Store) serveBlocked() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.readyKeys) > 0 {
+		ready := s.readyKeys
+		s.readyKeys = nil
+		for key := range ready {
+			for len(s.blocked[key]) > 0 {
+				bc := s.blocked[key][0]
+				changes := keyChanges.Load()
+				reply, ok := bc.serve(s, key)
+				if !ok {
+					break
+				}
+				s.unblock(bc)
+				if keyChanges.Load() != changes {
+					feedServed(bc)
+				}
+				bc.reply <- reply
+			}
+		}
+	}
+	s.hasReady.Store(false)
+}
+
+// serveBlockedClients serves blocked clients in every database with ready
+// keys. Run after each command, so a push wakes waiters before its reply;
+// waiters on the keys a transaction or script writes are served once it ends,
+// so none pops in between its commands.
+func serveBlockedClients() {
+	for _, db := range databases.All() {
+		if db.hasReady.Load() {
+			db.serveBlocked()
+		}
+	}
+}
+
+// waitBlocked parks the calling connection, running the blocking command
+// args, until bc is served, the timeout elapses (0 waits forever), CLIENT
+// UNBLOCK ends the wait or the client disconnects
+func (c *Client) waitBlocked(bc *blockedClient, args []string, timeout time.Duration, timeoutReply string) string {
+	if c.inExec {
+		// Nothing can serve a transaction's command before it ends, so it
+		// times out at once
+		if reply, served := bc.cancel(); served {
+			return reply
+		}
+		return timeoutReply
+	}
+	home := bc.lockHome()
+	bc.client, bc.command, bc.dbIndex = c, args, c.dbIndex
+	home.mu.Unlock()
+	c.aofWaited = true
+	defer c.releaseExec()()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	unblocked := c.beginBlock(bc.keys, timeout)
+	defer c.endBlock()
+
+	withError := false
+	select {
+	case reply := <-bc.reply:
+		return reply
+	case <-expired:
+	case withError = <-unblocked:
+	case <-c.hangup: // The peer closed the connection
+		c.closing = true
+	}
+	if reply, served := bc.cancel(); served && !c.closing {
+		return reply
+	}
+	if c.closing {
+		return ""
+	}
+	if withError {
+		return formatError(unblockedError)
+	}
+	return timeoutReply
+}
+
+// parseBlockTimeout parses the timeout argument of blocking commands,
+// in seconds with decimals allowed
+// Returns a non-empty error reply on failure
+func parseBlockTimeout(arg string) (time.Duration, string) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, formatError("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, formatError("ERR timeout is negative")
+	}
+	// Also refuses inf and nan, which ParseFloat accepts
+	nanos := seconds * float64(time.Second)
+	if !(nanos < math.MaxInt64) {
+		return 0, formatError("ERR timeout is out of range")
+	}
+	return time.Duration(nanos), ""
+}
+
+// BLPOP key [key ...] timeout
+func blpopCommand(c *Client, args []string) string {
+	return blockingPopReply(c, args, true)
+}
+
+// BRPOP key [key ...] timeout
+func brpopCommand(c *Client, args []string) string {
+	return blockingPopReply(c, args, false)
+}
+
+func blockingPopReply(c *Client, args []string, front bool) string {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != "" {
+		return errReply
+	}
+	serve := func(db *Store, key string) (string, bool) {
+		popped, _, ok := db.pop(key, front, 1)
+		if !ok || len(popped) == 0 {
+			return "", false
+		}
+		return formatArray([]string{key, popped[0]}), true
+	}
+
+	reply, bc := c.db().serveOrBlock(args[1:len(args)-1], TypeList, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, timeout, formatNullArray())
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func blmoveCommand(c *Client, args []string) string {
+	fromFront, ok1 := parseListEnd(args[3])
+	toFront, ok2 := parseListEnd(args[4])
+	if !ok1 || !ok2 {
+		return formatError("ERR syntax error")
+	}
+	timeout, errReply := parseBlockTimeout(args[5])
+	if errReply != "" {
+		return errReply
+	}
+	src, dst := args[1], args[2]
+	serve := func(db *Store, key string) (string, bool) {
+		element, moved, ok := db.lmove(src, dst, fromFront, toFront)
+		if !ok {
+			if list, srcOK := db.list(src); srcOK && list != nil {
+				// The destination holds another type: fail rather than wait
+				return formatError(wrongTypeError), true
+			}
+			return "", false
+		}
+		if !moved {
+			return "", false
+		}
+		return formatBulkString(element), true
+	}
+
+	reply, bc := c.db().serveOrBlock([]string{src}, TypeList, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, timeout, formatNullBulkString())
+}
+
+// parseMPop parses the "numkeys key [key ...] LEFT|RIGHT [COUNT count]"
+// arguments shared by LMPOP and BLMPOP
+// Returns a non-empty error reply on failure
+func parseMPop(args []string) (keys []string, front bool, count int, errReply string) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, formatError("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-1 {
+		return nil, false, 0, formatError("ERR Number of keys can't be greater than number of args")
+	}
+	keys = args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+	if len(rest) == 0 {
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+
+	front, ok := parseListEnd(rest[0])
+	if !ok {
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+	count = 1
+	switch {
+	case len(rest) == 3 && strings.ToUpper(rest[1]) == "COUNT":
+		count, err = strconv.Atoi(rest[2])
+		if err != nil || count <= 0 {
+			return nil, false, 0, formatError("ERR count should be greater than 0")
+		}
+	case len(rest) != 1:
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+	return keys, front, count, ""
+}
+
+// mpopServe pops up to count elements from key, replying with the key
+// name and the popped elements
+func mpopServe(front bool, count int) serveFunc {
+	return func(db *Store, key string) (string, bool) {
+		popped, _, ok := db.pop(key, front, count)
+		if !ok || len(popped) == 0 {
+			return "", false
+		}
+		return "*2\r\n" + formatBulkString(key) + formatArray(popped), true
+	}
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func lmpopCommand(c *Client, args []string) string {
+	keys, front, count, errReply := parseMPop(args[1:])
+	if errReply != "" {
+		return errReply
+	}
+	key, popped, ok := c.db().MPop(keys, front, count)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if len(popped) == 0 {
+		return formatNullArray()
+	}
+	return "*2\r\n" + formatBulkString(key) + formatArray(popped)
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func blmpopCommand(c *Client, args []string) string {
+	timeout, errReply := parseBlockTimeout(args[1])
+	if errReply != "" {
+		return errReply
+	}
+	keys, front, count, errReply := parseMPop(args[2:])
+	if errReply != "" {
+		return errReply
+	}
+	reply, bc := c.db().serveOrBlock(keys, TypeList, mpopServe(front, count))
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, timeout, formatNullArray())
+}
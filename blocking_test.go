@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForBlocked waits until n clients are blocked
+func waitForBlocked(t *testing.T, n int64) {
+	deadline := time.Now().Add(time.Second)
+	for blockedClients.Load() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d blocked clients, got %d", n, blockedClients.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockingPops(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	pusher := &Client{}
+
+	// Test 1: Data already present is served without blocking
+	pusher.execute([]string{"RPUSH", "q", "a"})
+	if reply := (&Client{}).execute([]string{"BLPOP", "empty", "q", "0"}); reply != formatArray([]string{"q", "a"}) {
+		t.Fatalf("expected immediate pop from q, got %q", reply)
+	}
+
+	// Test 2: Timeouts reply with a null array
+	start := time.Now()
+	if reply := (&Client{}).execute([]string{"BRPOP", "q", "0.05"}); reply != "*-1\r\n" {
+		t.Fatalf("expected null array on timeout, got %q", reply)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("expected BRPOP to wait for its timeout")
+	}
+
+	// Test 3: Waiters are woken in the order they blocked
+	first, second := make(chan string, 1), make(chan string, 1)
+	go func() { first <- (&Client{}).execute([]string{"BLPOP", "q", "5"}) }()
+	waitForBlocked(t, 1)
+	go func() { second <- (&Client{}).execute([]string{"BLPOP", "other", "q", "5"}) }()
+	waitForBlocked(t, 2)
+
+	pusher.execute([]string{"RPUSH", "q", "first", "second"})
+	if reply := <-first; reply != formatArray([]string{"q", "first"}) {
+		t.Fatalf("expected first waiter to get 'first', got %q", reply)
+	}
+	if reply := <-second; reply != formatArray([]string{"q", "second"}) {
+		t.Fatalf("expected second waiter to get 'second', got %q", reply)
+	}
+	waitForBlocked(t, 0)
+
+	// Test 4: WRONGTYPE and bad timeouts fail immediately
+	pusher.execute([]string{"SET", "s", "v"})
+	if reply := (&Client{}).execute([]string{"BLPOP", "s", "0"}); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"BLPOP", "q", "-1"}); reply != "-ERR timeout is negative\r\n" {
+		t.Fatalf("expected negative timeout error, got %q", reply)
+	}
+	for _, timeout := range []string{"1e300", "inf", "+Inf", "nan", "9300000000"} {
+		if reply := (&Client{}).execute([]string{"BLPOP", "q", timeout}); reply != "-ERR timeout is out of range\r\n" {
+			t.Fatalf("expected timeout %s to be out of range, got %q", timeout, reply)
+		}
+	}
+}
+
+func TestListMove(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: LMOVE between lists and rotation within one list
+	run("RPUSH", "src", "a", "b", "c")
+	if reply := run("LMOVE", "src", "dst", "LEFT", "RIGHT"); reply != "$1\r\na\r\n" {
+		t.Fatalf("expected a, got %q", reply)
+	}
+	if reply := run("LMOVE", "src", "src", "RIGHT", "LEFT"); reply != "$1\r\nc\r\n" {
+		t.Fatalf("expected c, got %q", reply)
+	}
+	if reply := run("LRANGE", "src", "0", "-1"); reply != formatArray([]string{"c", "b"}) {
+		t.Fatalf("unexpected rotated list %q", reply)
+	}
+	if reply := run("LMOVE", "nosuch", "dst", "LEFT", "LEFT"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+	run("SET", "str", "v")
+	if reply := run("LMOVE", "src", "str", "LEFT", "LEFT"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+
+	// Test 2: BLMOVE waits for the source, and its push wakes the next waiter
+	moved := make(chan string, 1)
+	popped := make(chan string, 1)
+	go func() { moved <- (&Client{}).execute([]string{"BLMOVE", "jobs", "working", "LEFT", "LEFT", "5"}) }()
+	waitForBlocked(t, 1)
+	go func() { popped <- (&Client{}).execute([]string{"BLPOP", "working", "5"}) }()
+	waitForBlocked(t, 2)
+
+	run("RPUSH", "jobs", "job1")
+	if reply := <-moved; reply != "$4\r\njob1\r\n" {
+		t.Fatalf("expected BLMOVE to move job1, got %q", reply)
+	}
+	if reply := <-popped; reply != formatArray([]string{"working", "job1"}) {
+		t.Fatalf("expected BLPOP on the destination to be woken, got %q", reply)
+	}
+}
+
+func TestBlockedClientDisconnect(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	server, peer := net.Pipe()
+	defer server.Close()
+	client := &Client{conn: server, reader: bufio.NewReader(server)}
+	stop := make(chan struct{})
+	defer close(stop)
+	client.startPipeline(1, stop)
+
+	// Test 1: Closing the connection unblocks the waiting command
+	done := make(chan string, 1)
+	go func() { done <- client.execute([]string{"BLPOP", "q", "0"}) }()
+	waitForBlocked(t, 1)
+	peer.Close()
+
+	select {
+	case reply := <-done:
+		if reply != "" || !client.closing {
+			t.Fatalf("expected the client to be dropped, got %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected BLPOP to return after the peer closed")
+	}
+	waitForBlocked(t, 0)
+
+	// Test 2: A push after the disconnect is kept for other clients
+	(&Client{}).execute([]string{"RPUSH", "q", "kept"})
+	if reply := (&Client{}).execute([]string{"LLEN", "q"}); reply != ":1\r\n" {
+		t.Fatalf("expected the element to stay in the list, got %q", reply)
+	}
+}
+
+func TestMultiKeyPops(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "b", "1", "2", "3")
+
+	// Test 1: LMPOP pops from the first non-empty key
+	if reply := run("LMPOP", "2", "a", "b", "RIGHT", "COUNT", "2"); reply != "*2\r\n$1\r\nb\r\n"+formatArray([]string{"3", "2"}) {
+		t.Fatalf("unexpected LMPOP reply %q", reply)
+	}
+	if reply := run("LMPOP", "1", "a", "LEFT"); reply != "*-1\r\n" {
+		t.Fatalf("expected null array, got %q", reply)
+	}
+
+	// Test 2: Argument validation
+	for args, want := range map[string]string{
+		"0 a LEFT":         "-ERR numkeys should be greater than 0\r\n",
+		"4 a b LEFT":       "-ERR Number of keys can't be greater than number of args\r\n",
+		"3 a b LEFT":       "-ERR syntax error\r\n",
+		"1 a UP":           "-ERR syntax error\r\n",
+		"1 a LEFT COUNT 0": "-ERR count should be greater than 0\r\n",
+	} {
+		if reply := client.execute(append([]string{"LMPOP"}, strings.Fields(args)...)); reply != want {
+			t.Fatalf("LMPOP %s: expected %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 3: BLMPOP blocks until any of its keys gets elements
+	done := make(chan string, 1)
+	go func() { done <- (&Client{}).execute([]string{"BLMPOP", "5", "2", "x", "y", "LEFT", "COUNT", "5"}) }()
+	waitForBlocked(t, 1)
+	run("RPUSH", "y", "p", "q")
+	if reply := <-done; reply != "*2\r\n$1\r\ny\r\n"+formatArray([]string{"p", "q"}) {
+		t.Fatalf("unexpected BLMPOP reply %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"BLMPOP", "0.01", "1", "x", "LEFT"}); reply != "*-1\r\n" {
+		t.Fatalf("expected null array on timeout, got %q", reply)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connected clients by ID, for CLIENT LIST, KILL and UNBLOCK
+var clientRegistry = struct {
+	sync.Mutex
+	byID map[int64]*Client
+}{byID: make(map[int64]*Client)}
+
+// Source of client IDs, which are never reused
+var nextClientID atomic.Int64
+
+// Error a command blocked by CLIENT UNBLOCK ... ERROR fails with
+const unblockedError = "UNBLOCKED client unblocked via CLIENT UNBLOCK"
+
+// clientInfo is the part of a client's state shown to other connections by
+// CLIENT LIST. It is only changed by the client's own goroutine, under mu.
+type clientInfo struct {
+	mu      sync.Mutex
+	name    string      // Set by HELLO SETNAME, empty for none
+	db      int         // Selected database as of the last command
+	cmd     string      // Command running, or the last one run
+	lastRun time.Time   // When the last command ended
+	block   *blockState // Set while a blocking command waits
+}
+
+// blockState describes what a blocked client waits for
+type blockState struct {
+	keys     []string
+	deadline time.Time // When the wait times out, zero if never
+	unblock  chan bool // Receives true to fail with UNBLOCKED, false to time out (buffered)
+	released bool      // Set once CLIENT UNBLOCK signalled unblock
+}
+
+// registerClient assigns the next ID to a newly connected client and makes
+// it visible to CLIENT LIST
+func registerClient(c *Client) {
+	c.id = nextClientID.Add(1)
+	c.connectedAt = time.Now()
+	c.info.lastRun = c.connectedAt
+	clientRegistry.Lock()
+	clientRegistry.byID[c.id] = c
+	clientRegistry.Unlock()
+}
+
+// unregisterClient removes a disconnected client from the registry
+func unregisterClient(c *Client) {
+	clientRegistry.Lock()
+	delete(clientRegistry.byID, c.id)
+	clientRegistry.Unlock()
+}
+
+// connectedClientList returns the registered clients in ID order
+func connectedClientList() []*Client {
+	clientRegistry.Lock()
+	list := make([]*Client, 0, len(clientRegistry.byID))
+	for _, c := range clientRegistry.byID {
+		list = append(list, c)
+	}
+	clientRegistry.Unlock()
+	slices.SortFunc(list, func(a, b *Client) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return list
+}
+
+// started records that the client began running cmd
+func (c *Client) started(cmd string) {
+	c.info.mu.Lock()
+	c.info.cmd = cmd
+	c.info.mu.Unlock()
+}
+
+// finished records that the client's current command ended
+func (c *Client) finished() {
+	c.info.mu.Lock()
+	c.info.db = c.dbIndex
+	c.info.lastRun = time.Now()
+	c.info.mu.Unlock()
+}
+
+// beginBlock records that the client waits on keys for timeout (forever if
+// 0). The returned channel delivers CLIENT UNBLOCK requests.
+// The replies to the commands pipelined before go out first, as whatever
+// ends the wait may depend on them.
+func (c *Client) beginBlock(keys []string, timeout time.Duration) <-chan bool {
+	c.flushReplies()
+	block := &blockState{keys: keys, unblock: make(chan bool, 1)}
+	if timeout > 0 {
+		block.deadline = time.Now().Add(timeout)
+	}
+	c.info.mu.Lock()
+	c.info.block = block
+	c.info.mu.Unlock()
+	return block.unblock
+}
+
+// endBlock records that the client's wait is over
+func (c *Client) endBlock() {
+	c.info.mu.Lock()
+	c.info.block = nil
+	c.info.mu.Unlock()
+}
+
+// unblockClient ends the wait of a blocked client, as if it timed out or, with
+// withError, failing with UNBLOCKED. Reports false if it was not blocked.
+func unblockClient(c *Client, withError bool) bool {
+	c.info.mu.Lock()
+	defer c.info.mu.Unlock()
+	block := c.info.block
+	if block == nil || block.released {
+		return false
+	}
+	block.released = true
+	block.unblock <- withError
+	return true
+}
+
+// setName names the client, as HELLO SETNAME does; an empty name removes it
+// Returns the error reply refusing name, or ""
+func (c *Client) setName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return formatError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	c.info.mu.Lock()
+	c.info.name = name
+	c.info.mu.Unlock()
+	return ""
+}
+
+// describe formats the client as a line of CLIENT LIST. bkeys lists the keys
+// a blocked client waits on and btimeout the milliseconds left before it
+// times out, -1 when it never does or is not blocked.
+func (c *Client) describe(now time.Time) string {
+	c.info.mu.Lock()
+	name, db, cmd, lastRun, block := c.info.name, c.info.db, c.info.cmd, c.info.lastRun, c.info.block
+	c.info.mu.Unlock()
+
+	flags, keys, timeout := "N", "", int64(-1)
+	if block != nil {
+		flags, keys = "b", strings.Join(block.keys, ",")
+		if !block.deadline.IsZero() {
+			timeout = max(block.deadline.Sub(now).Milliseconds(), 0)
+		}
+	}
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d cmd=%s bkeys=%s btimeout=%d",
+		c.id, c.remoteAddr(), c.localAddr(), name, int64(now.Sub(c.connectedAt).Seconds()),
+		int64(now.Sub(lastRun).Seconds()), flags, db, cmd, keys, timeout)
+}
+
+func (c *Client) remoteAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *Client) localAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.LocalAddr().String()
+}
+
+// kill disconnects the client. A client killing itself is dropped once its
+// reply is sent; others have their connection closed at once, which also
+// wakes them from a blocking command.
+func (c *Client) kill(self *Client) {
+	if c == self {
+		c.closing = true
+		return
+	}
+	c.conn.Close()
+}
+
+// CLIENT ID
+func clientIDReply(c *Client) string {
+	return formatInteger(int(c.id))
+}
+
+// CLIENT LIST [TYPE normal] [ID id [id ...]]
+func clientListReply(c *Client, args []string) string {
+	var ids []int64
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TYPE":
+			if i+1 == len(args) {
+				return formatError("ERR syntax error")
+			}
+			i++
+			switch strings.ToLower(args[i]) {
+			case "normal":
+			case "master", "replica", "pubsub":
+				return formatBulkString("") // None of these connect yet
+			default:
+				return formatError(fmt.Sprintf("ERR Unknown client type '%s'", args[i]))
+			}
+		case "ID":
+			if i+1 == len(args) {
+				return formatError("ERR syntax error")
+			}
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					return formatError("ERR Invalid client ID")
+				}
+				ids = append(ids, id)
+			}
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	var lines strings.Builder
+	now := time.Now()
+	for _, client := range connectedClientList() {
+		if ids != nil && !slices.Contains(ids, client.id) {
+			continue
+		}
+		lines.WriteString(client.describe(now))
+		lines.WriteByte('\n')
+	}
+	return formatBulkString(lines.String())
+}
+
+// CLIENT INFO
+func clientInfoReply(c *Client) string {
+	return formatBulkString(c.describe(time.Now()) + "\n")
+}
+
+// clientFilter selects the clients CLIENT KILL disconnects
+type clientFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	maxAge int64 // Seconds; 0 matches any age
+	skipMe bool
+	none   bool // Set by a TYPE no client can have
+}
+
+func (f *clientFilter) matches(c, self *Client, now time.Time) bool {
+	switch {
+	case f.none:
+		return false
+	case f.skipMe && c == self:
+		return false
+	case f.id != 0 && c.id != f.id:
+		return false
+	case f.addr != "" && c.remoteAddr() != f.addr:
+		return false
+	case f.laddr != "" && c.localAddr() != f.laddr:
+		return false
+	case f.maxAge != 0 && int64(now.Sub(c.connectedAt).Seconds()) < f.maxAge:
+		return false
+	}
+	return true
+}
+
+// CLIENT KILL addr
+// CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [TYPE normal] [MAXAGE seconds] [SKIPME yes|no]
+func clientKillReply(c *Client, args []string) string {
+	now := time.Now()
+	if len(args) == 0 {
+		return formatError("ERR wrong number of arguments for 'client|kill' command")
+	}
+	if len(args) == 1 {
+		// Old form: kill the client at one address, replying OK
+		for _, client := range connectedClientList() {
+			if client.remoteAddr() == args[0] {
+				client.kill(c)
+				return formatSimpleString("OK")
+			}
+		}
+		return formatError("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return formatError("ERR syntax error")
+	}
+
+	filter := clientFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return formatError("ERR client-id should be greater than 0")
+			}
+			filter.id = id
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "TYPE":
+			switch strings.ToLower(value) {
+			case "normal":
+			case "master", "replica", "slave", "pubsub":
+				filter.none = true
+			default:
+				return formatError(fmt.Sprintf("ERR Unknown client type '%s'", value))
+			}
+		case "MAXAGE":
+			age, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || age <= 0 {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			filter.maxAge = age
+		case "SKIPME":
+			skip, err := parseYesNo(value)
+			if err != nil {
+				return formatError("ERR syntax error")
+			}
+			filter.skipMe = skip
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	killed := 0
+	for _, client := range connectedClientList() {
+		if filter.matches(client, c, now) {
+			client.kill(c)
+			killed++
+		}
+	}
+	return formatInteger(killed)
+}
+
+// CLIENT UNBLOCK id [TIMEOUT|ERROR]
+func clientUnblockReply(args []string) string {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	withError := false
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			return formatError("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+		}
+	} else if len(args) > 2 {
+		return formatError("ERR syntax error")
+	}
+
+	clientRegistry.Lock()
+	target := clientRegistry.byID[id]
+	clientRegistry.Unlock()
+	return formatMembership(target != nil && unblockClient(target, withError))
+}
+
+// shutdownExit ends the process once SHUTDOWN has disconnected every client
+var shutdownExit = os.Exit
+
+// How long SHUTDOWN waits for disconnected clients to wind down
+const shutdownGrace = time.Second
+
+// SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+//
+// Disconnects every client, which ends their blocking commands, saves the
+// dump file if save points are configured or SAVE is given (NOSAVE skips
+// it), then exits. A failed save keeps the server running unless FORCE is
+// given.
+func shutdownCommand(c *Client, args []string) string {
+	save, nosave, force := false, false, false
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "NOSAVE":
+			nosave = true
+		case "SAVE":
+			save = true
+		case "FORCE":
+			force = true
+		case "NOW":
+		case "ABORT":
+			return formatError("ERR No shutdown in progress.")
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+	if save && nosave {
+		return formatError("ERR syntax error")
+	}
+	save = save || (!nosave && len(savePoints()) > 0)
+
+	fmt.Println("Shutdown requested, disconnecting clients")
+	for _, client := range connectedClientList() {
+		if client != c {
+			client.kill(c)
+		}
+	}
+	deadline := time.Now().Add(shutdownGrace)
+	for blockedClients.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if save {
+		fmt.Println("Saving the final RDB snapshot before exiting")
+		// A BGSAVE in progress finishes first
+		for !saves.begin(false) {
+			time.Sleep(time.Millisecond)
+		}
+		if err := saveForeground(); err != nil && !force {
+			fmt.Println("Error trying to save the DB, can't exit")
+			return formatError("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+	}
+	c.closing = true
+	flushAOF()
+	shutdownExit(0)
+	return ""
+}
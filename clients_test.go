@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConn is a RESP connection to a listener started by a test
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (tc *testConn) send(args ...string) {
+	msg := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		msg += formatBulkString(arg)
+	}
+	if _, err := tc.conn.Write([]byte(msg)); err != nil {
+		tc.t.Fatalf("expected to send %v, got %v", args, err)
+	}
+}
+
+// reply reads one reply, returning the payload of bulk strings
+func (tc *testConn) reply() string {
+	line, err := tc.reader.ReadString('\n')
+	if err != nil {
+		tc.t.Fatalf("expected a reply, got %v", err)
+	}
+	if line[0] != '$' || line == "$-1\r\n" {
+		return line
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	data := make([]byte, n+2)
+	io.ReadFull(tc.reader, data)
+	return string(data[:n])
+}
+
+func (tc *testConn) run(args ...string) string {
+	tc.send(args...)
+	return tc.reply()
+}
+
+func TestClientKillAndUnblock(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	closer, addr, err := startListener(ListenerConfig{Name: "clients", Kind: listenerTCP, Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("expected listener to start, got %v", err)
+	}
+	defer closer.Close()
+	dial := func() *testConn {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatalf("expected to connect, got %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	}
+	admin, worker := dial(), dial()
+	workerID := strings.TrimSpace(worker.run("CLIENT", "ID")[1:])
+	workerLine := func() string {
+		return admin.run("CLIENT", "LIST", "ID", workerID)
+	}
+
+	// Test 1: CLIENT INFO and CLIENT LIST describe connections
+	if info := worker.run("CLIENT", "INFO"); !strings.HasPrefix(info, "id="+workerID+" addr="+worker.conn.LocalAddr().String()) {
+		t.Fatalf("unexpected CLIENT INFO %q", info)
+	}
+	if line := workerLine(); !strings.Contains(line, "flags=N") || !strings.Contains(line, "cmd=client") {
+		t.Fatalf("expected an idle worker, got %q", line)
+	}
+
+	// Test 2: Blocked clients show their keys, command and remaining timeout
+	worker.send("BLPOP", "jobs", "urgent", "30")
+	waitForBlocked(t, 1)
+	line := workerLine()
+	if !strings.Contains(line, "flags=b") || !strings.Contains(line, "cmd=blpop") || !strings.Contains(line, "bkeys=jobs,urgent") {
+		t.Fatalf("expected a blocked worker, got %q", line)
+	}
+	var remaining int
+	fmt.Sscanf(line[strings.Index(line, "btimeout="):], "btimeout=%d", &remaining)
+	if remaining <= 25000 || remaining > 30000 {
+		t.Fatalf("expected about 30s of timeout left, got %q", line)
+	}
+
+	// Test 3: CLIENT UNBLOCK ends the wait as a timeout or an error
+	if reply := admin.run("CLIENT", "UNBLOCK", workerID); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := worker.reply(); reply != "*-1\r\n" {
+		t.Fatalf("expected a timed out BLPOP, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+	worker.send("BLPOP", "jobs", "0")
+	waitForBlocked(t, 1)
+	if reply := admin.run("CLIENT", "UNBLOCK", workerID, "ERROR"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := worker.reply(); reply != formatError(unblockedError) {
+		t.Fatalf("expected an UNBLOCKED error, got %q", reply)
+	}
+	if reply := admin.run("CLIENT", "UNBLOCK", workerID); reply != ":0\r\n" {
+		t.Fatalf("expected :0 for a client that is not blocked, got %q", reply)
+	}
+	if reply := admin.run("CLIENT", "UNBLOCK", workerID, "LATER"); !strings.Contains(reply, "TIMEOUT or ERROR") {
+		t.Fatalf("expected a reason error, got %q", reply)
+	}
+
+	// Test 4: Killing a blocked client disconnects it and leaves the key queue
+	worker.send("BLPOP", "jobs", "0")
+	waitForBlocked(t, 1)
+	if reply := admin.run("CLIENT", "KILL", "ID", workerID); reply != ":1\r\n" {
+		t.Fatalf("expected one killed client, got %q", reply)
+	}
+	if _, err := worker.reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected the killed connection to be closed")
+	}
+	waitForBlocked(t, 0)
+	admin.run("RPUSH", "jobs", "kept")
+	if reply := admin.run("LLEN", "jobs"); reply != ":1\r\n" {
+		t.Fatalf("expected the element to stay for other clients, got %q", reply)
+	}
+
+	// Test 5: Filters and the old address form
+	other := dial()
+	otherAddr := other.conn.LocalAddr().String()
+	if reply := admin.run("CLIENT", "KILL", "ADDR", admin.conn.LocalAddr().String()); reply != ":0\r\n" {
+		t.Fatalf("expected SKIPME to spare the caller, got %q", reply)
+	}
+	if reply := admin.run("CLIENT", "KILL", "1.2.3.4:5"); !strings.Contains(reply, "No such client") {
+		t.Fatalf("expected an unknown address error, got %q", reply)
+	}
+	if reply := admin.run("CLIENT", "KILL", otherAddr); reply != "+OK\r\n" {
+		t.Fatalf("expected OK, got %q", reply)
+	}
+	if _, err := other.reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected the connection killed by address to be closed")
+	}
+	if reply := admin.run("CLIENT", "KILL", "ID", "0"); !strings.Contains(reply, "greater than 0") {
+		t.Fatalf("expected an ID error, got %q", reply)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	saved, savedSaves, savedDir, savedPoints := databases, saves, dirOverride.Load(), savePointsOverride.Load()
+	databases = NewDatabases(1)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+		savePointsOverride.Store(savedPoints)
+	}()
+	exited := make(chan int, 1)
+	savedExit := shutdownExit
+	shutdownExit = func(code int) { exited <- code }
+	defer func() { shutdownExit = savedExit }()
+	dir := t.TempDir()
+	dirOverride.Store(&dir)
+	dump := filepath.Join(dir, defaultDBFilename)
+
+	closer, addr, err := startListener(ListenerConfig{Name: "shutdown", Kind: listenerTCP, Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("expected listener to start, got %v", err)
+	}
+	defer closer.Close()
+	dial := func() *testConn {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatalf("expected to connect, got %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	}
+	exits := func() bool {
+		select {
+		case code := <-exited:
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+	blocked, admin := dial(), dial()
+
+	// Test 1: Options are validated before anything happens
+	if reply := admin.run("SHUTDOWN", "SAVE", "NOSAVE"); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected SAVE with NOSAVE to be refused, got %q", reply)
+	}
+	if reply := admin.run("SHUTDOWN", "ABORT"); reply != "-ERR No shutdown in progress.\r\n" {
+		t.Fatalf("unexpected ABORT reply %q", reply)
+	}
+
+	// Test 2: Blocked clients are disconnected and unblocked before exiting,
+	// and NOSAVE saves nothing even with save points configured
+	blocked.send("BLPOP", "q", "0")
+	waitForBlocked(t, 1)
+	admin.send("SHUTDOWN", "NOSAVE")
+	if _, err := blocked.reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected the blocked client to be disconnected")
+	}
+	if _, err := admin.reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected the client running SHUTDOWN to be disconnected")
+	}
+	if !exits() || blockedClients.Load() != 0 {
+		t.Fatalf("expected an exit with no blocked client, got %d blocked", blockedClients.Load())
+	}
+	if _, err := os.Stat(dump); err == nil {
+		t.Fatalf("expected NOSAVE not to save")
+	}
+
+	// Test 3: A save that fails keeps the server running, unless FORCE is given
+	missing := filepath.Join(dir, "missing")
+	dirOverride.Store(&missing)
+	admin = dial()
+	if reply := admin.run("SHUTDOWN", "SAVE"); reply != "-ERR Errors trying to SHUTDOWN. Check logs.\r\n" || exits() {
+		t.Fatalf("expected the failed save to abort the shutdown, got %q", reply)
+	}
+	admin.send("SHUTDOWN", "SAVE", "FORCE")
+	if !exits() {
+		t.Fatalf("expected FORCE to exit despite the failed save")
+	}
+	dirOverride.Store(&dir)
+
+	// Test 4: A plain SHUTDOWN saves when save points are configured
+	admin = dial()
+	admin.run("SET", "k", "v")
+	admin.send("SHUTDOWN")
+	if !exits() {
+		t.Fatalf("expected SHUTDOWN to exit")
+	}
+	if _, err := os.Stat(dump); err != nil {
+		t.Fatalf("expected SHUTDOWN to save the dump file, got %v", err)
+	}
+
+	// Test 5: Without save points it does not, but SAVE still does
+	os.Remove(dump)
+	none := []savePoint{}
+	savePointsOverride.Store(&none)
+	admin = dial()
+	admin.send("SHUTDOWN")
+	if !exits() {
+		t.Fatalf("expected SHUTDOWN to exit")
+	}
+	if _, err := os.Stat(dump); err == nil {
+		t.Fatalf("expected no save without save points")
+	}
+	admin = dial()
+	admin.send("SHUTDOWN", "SAVE")
+	if !exits() {
+		t.Fatalf("expected SHUTDOWN SAVE to exit")
+	}
+	if _, err := os.Stat(dump); err != nil {
+		t.Fatalf("expected SHUTDOWN SAVE to save the dump file, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for everything that ages data: TTLs and
+// precise expiration timers, idle times used by archival and OBJECT
+// IDLETIME, tombstone windows and the hot-key cache's expiration checks.
+// Network deadlines and background loop schedules always follow the system
+// clock.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc arranges for f to be called once d has elapsed on the clock
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a pending AfterFunc call
+type ClockTimer interface {
+	// Stop prevents the call, reporting whether it was still pending
+	Stop() bool
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// Clock in use, holding a clockBox so the dynamic type may change
+var activeClock atomic.Value
+
+// clockBox gives every stored clock the same concrete type, as atomic.Value requires
+type clockBox struct{ Clock }
+
+func init() {
+	activeClock.Store(clockBox{systemClock{}})
+}
+
+// SetClock makes the server read time from c; nil restores the system clock.
+// Timers armed before the change keep running on the previous clock, and
+// whatever they guard is checked against the new one when they fire.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	activeClock.Store(clockBox{c})
+}
+
+// currentClock returns the clock set with SetClock
+func currentClock() Clock {
+	return activeClock.Load().(clockBox).Clock
+}
+
+// clockNow returns the current time of the server's clock
+func clockNow() time.Time {
+	return currentClock().Now()
+}
+
+// VirtualClock is a clock that only moves when advanced, so tests can step
+// through expirations deterministically instead of sleeping
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+// virtualTimer is an AfterFunc call pending on a VirtualClock
+type virtualTimer struct {
+	clock *VirtualClock
+	at    time.Time
+	f     func()
+}
+
+// NewVirtualClock creates a virtual clock reading start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (vc *VirtualClock) Now() time.Time {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.now
+}
+
+func (vc *VirtualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	t := &virtualTimer{clock: vc, at: vc.now.Add(d), f: f}
+	vc.timers = append(vc.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and runs the calls that came due,
+// earliest first, before returning
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.mu.Lock()
+	vc.now = vc.now.Add(d)
+	var due, pending []*virtualTimer
+	for _, t := range vc.timers {
+		if t.at.After(vc.now) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	vc.timers = pending
+	vc.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// Pending returns the number of AfterFunc calls not yet due
+func (vc *VirtualClock) Pending() int {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return len(vc.timers)
+}
+
+func (t *virtualTimer) Stop() bool {
+	vc := t.clock
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	for i, pending := range vc.timers {
+		if pending == t {
+			vc.timers = append(vc.timers[:i], vc.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// debugClock implements the DEBUG CLOCK family:
+//
+//	DEBUG CLOCK FREEZE                 stop the clock at the current time
+//	DEBUG CLOCK ADVANCE <milliseconds> move the frozen clock forward, firing due expirations
+//	DEBUG CLOCK RESUME                 go back to the system clock
+//
+// ADVANCE replies with the new time as Unix milliseconds.
+func debugClock(args []string) string {
+	if len(args) == 0 {
+		return formatError("ERR wrong number of arguments for 'debug|clock' command")
+	}
+	vc, frozen := currentClock().(*VirtualClock)
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "FREEZE" && len(args) == 1:
+		if !frozen {
+			SetClock(NewVirtualClock(time.Now()))
+		}
+		return formatSimpleString("OK")
+
+	case sub == "RESUME" && len(args) == 1:
+		SetClock(nil)
+		return formatSimpleString("OK")
+
+	case sub == "ADVANCE" && len(args) == 2:
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || ms < 0 {
+			return formatError("ERR the time to advance must be a non-negative integer number of milliseconds")
+		}
+		if !frozen {
+			return formatError("ERR the clock is not frozen, use DEBUG CLOCK FREEZE first")
+		}
+		vc.Advance(time.Duration(ms) * time.Millisecond)
+		return formatInteger(int(vc.Now().UnixMilli()))
+	}
+	return formatError("ERR syntax error")
+}
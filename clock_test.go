@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	SetClock(vc)
+	defer SetClock(nil)
+
+	// Test 1: Keys expire exactly when the clock passes their TTL
+	store := NewStore()
+	store.Restore("k", &Entry{Type: TypeString, Value: "v", ExpiresAt: start.Add(10 * time.Second)}, false)
+	vc.Advance(9 * time.Second)
+	if ttl, _, _ := store.TTL("k"); ttl != time.Second {
+		t.Fatalf("expected 1s of TTL left, got %v", ttl)
+	}
+	vc.Advance(time.Second)
+	if _, exists, _ := store.Get("k"); exists {
+		t.Fatalf("expected the key to expire at its deadline")
+	}
+
+	// Test 2: Precise expiration timers fire during Advance
+	store.SetPreciseExpireLimit(10)
+	store.Restore("timed", &Entry{Type: TypeString, Value: "v", ExpiresAt: vc.Now().Add(time.Minute)}, false)
+	if vc.Pending() != 1 {
+		t.Fatalf("expected one pending timer, got %d", vc.Pending())
+	}
+	expired := preciseExpired.Load()
+	vc.Advance(time.Minute)
+	if store.PreciseTimerCount() != 0 || preciseExpired.Load() != expired+1 {
+		t.Fatalf("expected the timer to remove the key")
+	}
+
+	// Test 3: Stopped timers do not fire
+	fired := false
+	timer := vc.AfterFunc(time.Second, func() { fired = true })
+	if !timer.Stop() || timer.Stop() {
+		t.Fatalf("expected the first Stop only to report a pending call")
+	}
+	vc.Advance(time.Hour)
+	if fired {
+		t.Fatalf("expected a stopped timer not to fire")
+	}
+
+	// Test 4: Due calls run in deadline order
+	var order []int
+	vc.AfterFunc(2*time.Second, func() { order = append(order, 2) })
+	vc.AfterFunc(time.Second, func() { order = append(order, 1) })
+	vc.Advance(3 * time.Second)
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatalf("expected calls in deadline order, got %v", order)
+	}
+}
+
+func TestDebugClock(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	config.EnableDebugCommand = true
+	defer func() {
+		databases = saved
+		config.EnableDebugCommand = false
+		SetClock(nil)
+	}()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: ADVANCE needs a frozen clock
+	if reply := run("DEBUG", "CLOCK", "ADVANCE", "1000"); reply[0] != '-' {
+		t.Fatalf("expected ADVANCE to be refused on the system clock, got %q", reply)
+	}
+
+	// Test 2: A frozen clock moves only when advanced
+	if reply := run("DEBUG", "CLOCK", "FREEZE"); reply != "+OK\r\n" {
+		t.Fatalf("expected OK, got %q", reply)
+	}
+	databases.Get(0).Restore("k", &Entry{Type: TypeString, Value: "v", ExpiresAt: clockNow().Add(5 * time.Second)}, false)
+	if reply := run("PTTL", "k"); reply != ":5000\r\n" {
+		t.Fatalf("expected the TTL not to move while frozen, got %q", reply)
+	}
+	before := clockNow()
+	reply := run("DEBUG", "CLOCK", "ADVANCE", "5000")
+	if reply != formatInteger(int(before.Add(5*time.Second).UnixMilli())) {
+		t.Fatalf("expected the new time in milliseconds, got %q", reply)
+	}
+	if reply := run("GET", "k"); reply != "$-1\r\n" {
+		t.Fatalf("expected the key to expire, got %q", reply)
+	}
+
+	// Test 3: RESUME goes back to the system clock
+	run("DEBUG", "CLOCK", "RESUME")
+	if _, frozen := currentClock().(*VirtualClock); frozen {
+		t.Fatalf("expected the system clock after RESUME")
+	}
+	if reply := run("DEBUG", "CLOCK", "ADVANCE", "-1"); reply[0] != '-' {
+		t.Fatalf("expected a negative advance to be refused, got %q", reply)
+	}
+}
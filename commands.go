@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client holds the state of a single client connection
+type Client struct {
+	conn       net.Conn      // Underlying network connection
+	reader     *bufio.Reader // Buffered reader over conn, used by the pipeline reader
+	hangup     chan struct{} // Closed once reading the connection fails (nil without one)
+	replies    replyBuffer   // Pooled write buffer, sized by recent replies
+	writeMu    sync.Mutex    // Serializes writes to conn, which other connections push messages through
+	dbIndex    int           // Currently selected database
+	closing    bool          // Set when the connection must be dropped after the current command
+	handshake  bool          // Set until the first command is received
+	resp3      bool          // Protocol negotiated with HELLO 3
+	attributes bool          // Set by CLIENT ATTRIBUTES ON: replies carry RESP3 attribute metadata
+	replyAttrs []string      // Encoded attribute fields and values collected for the current reply
+
+	policy        *listenerPolicy // Rules of the listener the client connected through (nil allows all)
+	authenticated bool            // Set once AUTH succeeded against the listener's password
+
+	id          int64      // Connection ID, 0 for clients not in the registry
+	connectedAt time.Time  // When the connection was accepted
+	info        clientInfo // State shown by CLIENT LIST
+
+	subscriptions subscriptions // Pub/sub channels the connection subscribed to
+	outbox        *outbox       // Frames pushed to the connection, from its first subscription on
+
+	multi      *transaction // Commands queued since MULTI, nil outside a transaction
+	inExec     bool         // Set while the client holds execMu for writing, running EXEC or a script
+	execLocked bool         // Set while a command holds execMu for reading
+	watching   []watchedKey // Keys of WATCH, until EXEC, DISCARD, UNWATCH or RESET
+	watchDirty atomic.Bool  // Set once a watched key changed: EXEC fails
+
+	replaying bool // Replays the append-only file: runs while the dataset loads
+
+	aofLocked  bool       // Set while a command holds aofMu
+	aofWaited  bool       // Set once the command running waited in a blocking command
+	aofRewrite [][]string // Commands appended to the append-only file in place of the one running (nil for itself)
+	aofFile    *aofFile   // Append-only file the client last appended to, and the offset of the end of that
+	aofOffset  int64
+}
+
+// db returns the client's currently selected database
+func (c *Client) db() *Store {
+	return databases.Get(c.dbIndex)
+}
+
+// Error returned by commands applied to a key of another type
+const wrongTypeError = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// CommandHandler executes a command and returns the RESP-encoded reply
+// args includes the command name at args[0]
+type CommandHandler func(c *Client, args []string) string
+
+// Command describes an entry of the command registry
+type Command struct {
+	Name     string         // Lowercase command name as reported to clients, container|sub for subcommands
+	Arity    int            // Argument count including the name; negative means at least -Arity
+	Handler  CommandHandler // Function executing the command
+	Category string         // data, admin or connection, as listeners allow them
+
+	// Name of the command an alias stands for, Name itself otherwise. Command
+	// policies and the append-only file know commands by it.
+	Canonical string
+
+	Subcommands map[string]*Command // Entries of a container command such as CONFIG, by uppercase name
+	Container   *Command            // Command a subcommand belongs to, nil for top-level entries
+
+	stats *commandStats // Calls reported by INFO commandstats
+}
+
+// commandTable maps uppercase command names (and aliases) to their definitions
+var commandTable = make(map[string]*Command)
+
+// registerCommand adds a command to the registry
+func registerCommand(name string, arity int, handler CommandHandler) {
+	commandTable[strings.ToUpper(name)] = &Command{
+		Name:      strings.ToLower(name),
+		Arity:     arity,
+		Handler:   handler,
+		Category:  commandCategory(name),
+		Canonical: strings.ToLower(name),
+		stats:     &commandStats{},
+	}
+}
+
+// registerSubcommand adds a subcommand of an already registered container
+// command, such as CONFIG GET. Subcommands run the container's handler but
+// are registry entries of their own, named container|name, with their own
+// arity, category and statistics. The category is the container's unless
+// commandCategories lists the full name.
+func registerSubcommand(container, name string, arity int) {
+	parent := lookupCommand(container)
+	full := strings.ToLower(container + "|" + name)
+	category, ok := commandCategories[full]
+	if !ok {
+		category = parent.Category
+	}
+	if parent.Subcommands == nil {
+		parent.Subcommands = make(map[string]*Command)
+	}
+	parent.Subcommands[strings.ToUpper(name)] = &Command{
+		Name:      full,
+		Arity:     arity,
+		Handler:   parent.Handler,
+		Category:  category,
+		Canonical: full,
+		Container: parent,
+		stats:     &commandStats{},
+	}
+}
+
+// registerAlias makes alias invoke the same handler as target. The alias
+// keeps the target's canonical name, so it is subject to the same policies.
+func registerAlias(alias, target string) error {
+	cmd := lookupCommand(target)
+	if cmd == nil {
+		return fmt.Errorf("alias '%s' refers to unknown command '%s'", alias, target)
+	}
+	if lookupCommand(alias) != nil {
+		return fmt.Errorf("alias '%s' would shadow an existing command", alias)
+	}
+
+	aliased := *cmd
+	aliased.Name = strings.ToLower(alias)
+	aliased.stats = &commandStats{}
+	commandTable[strings.ToUpper(alias)] = &aliased
+	return nil
+}
+
+// lookupCommand finds a command by name, case-insensitively
+func lookupCommand(name string) *Command {
+	return commandTable[strings.ToUpper(name)]
+}
+
+// resolve returns the registry entry args run: the subcommand named by
+// args[1] if cmd has it, cmd itself otherwise. Unknown subcommands are left
+// to the container's handler to report.
+func (cmd *Command) resolve(args []string) *Command {
+	if len(args) > 1 && cmd.Subcommands != nil {
+		if sub := cmd.Subcommands[strings.ToUpper(args[1])]; sub != nil {
+			return sub
+		}
+	}
+	return cmd
+}
+
+// topLevel returns the container of a subcommand, or cmd itself
+func (cmd *Command) topLevel() *Command {
+	if cmd.Container != nil {
+		return cmd.Container
+	}
+	return cmd
+}
+
+// checkArity reports whether argc arguments satisfy the command's arity
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.Arity < 0 {
+		return argc >= -cmd.Arity
+	}
+	return argc == cmd.Arity
+}
+
+// execute looks up and runs a command, returning the reply to send
+func (c *Client) execute(args []string) string {
+	cmd := lookupCommand(args[0])
+	if cmd == nil {
+		c.flagTransaction()
+		return formatError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(args[0])))
+	}
+	if cmd.checkArity(len(args)) {
+		cmd = cmd.resolve(args)
+	}
+	if !cmd.checkArity(len(args)) {
+		cmd.stats.rejected.Add(1)
+		c.flagTransaction()
+		return formatError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.Name))
+	}
+	if refused := c.policy.check(c, cmd); refused != "" {
+		cmd.stats.rejected.Add(1)
+		c.flagTransaction()
+		return refused
+	}
+	if loading.refuses(c, cmd) {
+		cmd.stats.rejected.Add(1)
+		c.flagTransaction()
+		return loading.errorReply()
+	}
+	if c.subscribedContext() && !subscribedCommands[cmd.topLevel().Canonical] {
+		cmd.stats.rejected.Add(1)
+		return formatError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", cmd.Name))
+	}
+	if c.multi != nil && !transactionCommands[cmd.topLevel().Canonical] {
+		c.multi.commands = append(c.multi.commands, queuedCommand{cmd: cmd, args: args})
+		return formatSimpleString("QUEUED")
+	}
+	if cmd.Canonical != "debug" {
+		reply, disconnect := faults.inject(cmd.topLevel().Canonical)
+		if disconnect {
+			c.closing = true
+			return ""
+		}
+		if reply != "" {
+			return reply
+		}
+	}
+	if !busyAllowedCommands[cmd.Canonical] {
+		unlock, ok := c.lockExec()
+		if !ok {
+			cmd.stats.rejected.Add(1)
+			return formatError(busyError)
+		}
+		defer unlock()
+	}
+	return c.call(cmd, args)
+}
+
+// call runs a command that passed execute's checks
+func (c *Client) call(cmd *Command, args []string) string {
+	defer c.lockAOF()()
+	c.replyAttrs = c.replyAttrs[:0]
+	c.started(cmd.Name)
+	start := time.Now()
+	changes := keyChanges.Load()
+	reply := cmd.Handler(c, args)
+	cmd.stats.record(time.Since(start), strings.HasPrefix(reply, "-"))
+	c.finished()
+	c.feedAOF(cmd, args, reply, keyChanges.Load() != changes)
+	if blockedClients.Load() > 0 && !c.inExec {
+		serveBlockedClients()
+	}
+	return c.withAttributes(reply)
+}
+
+func init() {
+	registerCommand("ping", -1, pingCommand)
+	registerCommand("echo", 2, echoCommand)
+	registerCommand("quit", -1, quitCommand)
+	registerCommand("reset", 1, resetCommand)
+	registerCommand("multi", 1, multiCommand)
+	registerCommand("exec", 1, execCommand)
+	registerCommand("discard", 1, discardCommand)
+	registerCommand("watch", -2, watchCommand)
+	registerCommand("unwatch", 1, unwatchCommand)
+	registerCommand("eval", -3, evalCommand)
+	registerCommand("evalsha", -3, evalshaCommand)
+	registerCommand("script", -2, scriptCommand)
+	registerCommand("fcall", -3, fcallCommand)
+	registerCommand("fcall_ro", -3, fcallROCommand)
+	registerCommand("function", -2, functionCommand)
+	registerCommand("get", 2, getCommand)
+	registerCommand("set", 3, setCommand)
+	registerCommand("del", -2, delCommand)
+	registerCommand("unlink", -2, unlinkCommand)
+	registerCommand("mget", -2, mgetCommand)
+	registerCommand("mset", -3, msetCommand)
+	registerCommand("bitcount", -2, bitcountCommand)
+	registerCommand("bitop", -4, bitopCommand)
+	registerCommand("bitpos", -3, bitposCommand)
+	registerCommand("bitfield", -2, bitfieldCommand)
+	registerCommand("bitfield_ro", -2, bitfieldRoCommand)
+	registerCommand("pfadd", -2, pfaddCommand)
+	registerCommand("pfcount", -2, pfcountCommand)
+	registerCommand("pfmerge", -2, pfmergeCommand)
+	registerCommand("lpush", -3, lpushCommand)
+	registerCommand("rpush", -3, rpushCommand)
+	registerCommand("lpushx", -3, lpushxCommand)
+	registerCommand("rpushx", -3, rpushxCommand)
+	registerCommand("lpop", -2, lpopCommand)
+	registerCommand("rpop", -2, rpopCommand)
+	registerCommand("llen", 2, llenCommand)
+	registerCommand("lrange", 4, lrangeCommand)
+	registerCommand("lindex", 3, lindexCommand)
+	registerCommand("lset", 4, lsetCommand)
+	registerCommand("linsert", 5, linsertCommand)
+	registerCommand("lrem", 4, lremCommand)
+	registerCommand("ltrim", 4, ltrimCommand)
+	registerCommand("lpos", -3, lposCommand)
+	registerCommand("lmove", 5, lmoveCommand)
+	registerCommand("blpop", -3, blpopCommand)
+	registerCommand("brpop", -3, brpopCommand)
+	registerCommand("blmove", 6, blmoveCommand)
+	registerCommand("lmpop", -4, lmpopCommand)
+	registerCommand("blmpop", -5, blmpopCommand)
+	registerCommand("sadd", -3, saddCommand)
+	registerCommand("srem", -3, sremCommand)
+	registerCommand("smembers", 2, smembersCommand)
+	registerCommand("sismember", 3, sismemberCommand)
+	registerCommand("smismember", -3, smismemberCommand)
+	registerCommand("scard", 2, scardCommand)
+	registerCommand("smove", 4, smoveCommand)
+	registerCommand("spop", -2, spopCommand)
+	registerCommand("srandmember", -2, srandmemberCommand)
+	registerCommand("sunion", -2, sunionCommand)
+	registerCommand("sinter", -2, sinterCommand)
+	registerCommand("sdiff", -2, sdiffCommand)
+	registerCommand("sunionstore", -3, sunionstoreCommand)
+	registerCommand("sinterstore", -3, sinterstoreCommand)
+	registerCommand("sdiffstore", -3, sdiffstoreCommand)
+	registerCommand("hset", -4, hsetCommand)
+	registerCommand("hget", 3, hgetCommand)
+	registerCommand("hdel", -3, hdelCommand)
+	registerCommand("hgetall", 2, hgetallCommand)
+	registerCommand("hlen", 2, hlenCommand)
+	registerCommand("hexists", 3, hexistsCommand)
+	registerCommand("hmget", -3, hmgetCommand)
+	registerCommand("hsetnx", 4, hsetnxCommand)
+	registerCommand("hkeys", 2, hkeysCommand)
+	registerCommand("hvals", 2, hvalsCommand)
+	registerCommand("hstrlen", 3, hstrlenCommand)
+	registerCommand("hincrby", 4, hincrbyCommand)
+	registerCommand("hrandfield", -2, hrandfieldCommand)
+	registerCommand("zadd", -4, zaddCommand)
+	registerCommand("zscore", 3, zscoreCommand)
+	registerCommand("zmscore", -3, zmscoreCommand)
+	registerCommand("zcard", 2, zcardCommand)
+	registerCommand("zrank", -3, zrankCommand)
+	registerCommand("zrevrank", -3, zrevrankCommand)
+	registerCommand("zrandmember", -2, zrandmemberCommand)
+	registerCommand("zrange", -4, zrangeCommand)
+	registerCommand("zrevrange", -4, zrevrangeCommand)
+	registerCommand("zrangebyscore", -4, zrangebyscoreCommand)
+	registerCommand("zrevrangebyscore", -4, zrevrangebyscoreCommand)
+	registerCommand("zrangebylex", -4, zrangebylexCommand)
+	registerCommand("zrevrangebylex", -4, zrevrangebylexCommand)
+	registerCommand("zcount", 4, zcountCommand)
+	registerCommand("zlexcount", 4, zlexcountCommand)
+	registerCommand("zincrby", 4, zincrbyCommand)
+	registerCommand("zrem", -3, zremCommand)
+	registerCommand("zremrangebyrank", 4, zremrangebyrankCommand)
+	registerCommand("zremrangebyscore", 4, zremrangebyscoreCommand)
+	registerCommand("zremrangebylex", 4, zremrangebylexCommand)
+	registerCommand("zpopmin", -2, zpopminCommand)
+	registerCommand("zpopmax", -2, zpopmaxCommand)
+	registerCommand("bzpopmin", -3, bzpopminCommand)
+	registerCommand("bzpopmax", -3, bzpopmaxCommand)
+	registerCommand("zmpop", -4, zmpopCommand)
+	registerCommand("bzmpop", -5, bzmpopCommand)
+	registerCommand("zunion", -3, zunionCommand)
+	registerCommand("zinter", -3, zinterCommand)
+	registerCommand("zdiff", -3, zdiffCommand)
+	registerCommand("zunionstore", -4, zunionstoreCommand)
+	registerCommand("zinterstore", -4, zinterstoreCommand)
+	registerCommand("zdiffstore", -4, zdiffstoreCommand)
+	registerCommand("geoadd", -5, geoaddCommand)
+	registerCommand("geopos", -2, geoposCommand)
+	registerCommand("geodist", -4, geodistCommand)
+	registerCommand("geohash", -2, geohashCommand)
+	registerCommand("geosearch", -7, geosearchCommand)
+	registerCommand("geosearchstore", -8, geosearchstoreCommand)
+	registerCommand("xadd", -5, xaddCommand)
+	registerCommand("xlen", 2, xlenCommand)
+	registerCommand("xrange", -4, xrangeCommand)
+	registerCommand("xrevrange", -4, xrevrangeCommand)
+	registerCommand("xtrim", -4, xtrimCommand)
+	registerCommand("xdel", -3, xdelCommand)
+	registerCommand("xsetid", -3, xsetidCommand)
+	registerCommand("xinfo", -2, xinfoCommand)
+	registerCommand("subscribe", -2, subscribeCommand)
+	registerCommand("unsubscribe", -1, unsubscribeCommand)
+	registerCommand("publish", 3, publishCommand)
+	registerCommand("psubscribe", -2, psubscribeCommand)
+	registerCommand("punsubscribe", -1, punsubscribeCommand)
+	registerCommand("pubsub", -2, pubsubCommand)
+	registerCommand("xread", -4, xreadCommand)
+	registerCommand("xgroup", -2, xgroupCommand)
+	registerCommand("xreadgroup", -7, xreadgroupCommand)
+	registerCommand("xack", -4, xackCommand)
+	registerCommand("xpending", -3, xpendingCommand)
+	registerCommand("xclaim", -6, xclaimCommand)
+	registerCommand("xautoclaim", -6, xautoclaimCommand)
+	registerCommand("shutdown", -1, shutdownCommand)
+	registerCommand("config", -2, configCommand)
+	registerCommand("scan", -2, scanCommand)
+	registerCommand("sscan", -3, sscanCommand)
+	registerCommand("hscan", -3, hscanCommand)
+	registerCommand("zscan", -3, zscanCommand)
+	registerCommand("copy", -3, copyCommand)
+	registerCommand("randomkey", 1, randomKeyCommand)
+	registerCommand("touch", -2, touchCommand)
+	registerCommand("dbsize", 1, dbsizeCommand)
+	registerCommand("flushdb", -1, flushdbCommand)
+	registerCommand("flushall", -1, flushallCommand)
+	registerCommand("undelete", -2, undeleteCommand)
+	registerCommand("select", 2, selectCommand)
+	registerCommand("swapdb", 3, swapdbCommand)
+	registerCommand("move", 3, moveCommand)
+	registerCommand("dump", 2, dumpCommand)
+	registerCommand("restore", -4, restoreCommand)
+	registerCommand("ttl", 2, ttlCommand)
+	registerCommand("pttl", 2, pttlCommand)
+	registerCommand("expiretime", 2, expiretimeCommand)
+	registerCommand("pexpiretime", 2, pexpiretimeCommand)
+	registerCommand("pexpireat", -3, pexpireatCommand)
+	registerCommand("object", -2, objectCommand)
+	registerCommand("memory", -2, memoryCommand)
+	registerCommand("convert", -3, convertCommand)
+	registerCommand("debug", -2, debugCommand)
+	registerCommand("info", -1, infoCommand)
+	registerCommand("save", 1, saveCommand)
+	registerCommand("bgsave", -1, bgsaveCommand)
+	registerCommand("lastsave", 1, lastsaveCommand)
+	registerCommand("waitaof", 4, waitaofCommand)
+	registerCommand("hello", -1, helloCommand)
+	registerCommand("auth", -2, authCommand)
+	registerCommand("client", -2, clientCommand)
+
+	registerSubcommand("config", "get", -3)
+	registerSubcommand("config", "set", -4)
+	registerSubcommand("config", "help", 2)
+	registerSubcommand("client", "id", 2)
+	registerSubcommand("client", "info", 2)
+	registerSubcommand("client", "list", -2)
+	registerSubcommand("client", "kill", -3)
+	registerSubcommand("client", "unblock", -3)
+	registerSubcommand("client", "attributes", 3)
+	registerSubcommand("client", "help", 2)
+	registerSubcommand("object", "encoding", 3)
+	registerSubcommand("object", "freq", 3)
+	registerSubcommand("object", "idletime", 3)
+	registerSubcommand("object", "refcount", 3)
+	registerSubcommand("object", "help", 2)
+	registerSubcommand("memory", "usage", -3)
+	registerSubcommand("memory", "prefixes", 2)
+	registerSubcommand("memory", "help", 2)
+	registerSubcommand("pubsub", "channels", -2)
+	registerSubcommand("pubsub", "numsub", -2)
+	registerSubcommand("pubsub", "numpat", 2)
+	registerSubcommand("pubsub", "shardchannels", -2)
+	registerSubcommand("pubsub", "help", 2)
+	registerSubcommand("script", "load", 3)
+	registerSubcommand("script", "exists", -3)
+	registerSubcommand("script", "flush", -2)
+	registerSubcommand("script", "kill", 2)
+	registerSubcommand("script", "help", 2)
+	registerSubcommand("function", "load", -3)
+	registerSubcommand("function", "list", -2)
+	registerSubcommand("function", "delete", 3)
+	registerSubcommand("function", "dump", 2)
+	registerSubcommand("function", "restore", -3)
+	registerSubcommand("function", "flush", -2)
+	registerSubcommand("function", "kill", 2)
+	registerSubcommand("function", "help", 2)
+}
+
+// PING [message]
+func pingCommand(c *Client, args []string) string {
+	if len(args) > 2 {
+		return formatError("ERR wrong number of arguments for 'ping' command")
+	}
+	if c.subscribedContext() {
+		// Replies of subscribed clients are pub/sub frames
+		message := ""
+		if len(args) > 1 {
+			message = args[1]
+		}
+		return formatArray([]string{"pong", message})
+	}
+	// If an argument is provided, use it as message, else default "PONG"
+	message := "PONG"
+	if len(args) > 1 {
+		message = args[1]
+	}
+	return formatSimpleString(message)
+}
+
+// QUIT
+func quitCommand(c *Client, args []string) string {
+	c.closing = true
+	return formatSimpleString("OK")
+}
+
+// RESET
+// Returns the connection to the state it had when it connected: no
+// subscriptions or transaction, RESP2, database 0, no attributes and, behind
+// a listener password, not authenticated
+func resetCommand(c *Client, args []string) string {
+	broker.unsubscribeAll(c)
+	c.multi = nil
+	c.unwatchAll()
+	c.dbIndex = 0
+	c.resp3 = false
+	c.attributes = false
+	c.authenticated = false
+	return formatSimpleString("RESET")
+}
+
+// ECHO message
+func echoCommand(c *Client, args []string) string {
+	return formatBulkString(args[1])
+}
+
+// GET key
+func getCommand(c *Client, args []string) string {
+	value, exists, isCorrectType, cacheHit := c.db().lookup(args[1])
+	if c.attributes {
+		c.keyAttributes(args[1])
+		c.attribute("cache-hit", formatBoolean(cacheHit))
+	}
+	if exists && !isCorrectType {
+		// Key exists but wrong type
+		return formatError(wrongTypeError)
+	}
+	if !exists {
+		// Key not found - return nil bulk string
+		return formatNullBulkString()
+	}
+	return formatBulkString(value)
+}
+
+// SET key value
+func setCommand(c *Client, args []string) string {
+	return statusReply(c.db().Set(args[1], args[2]))
+}
+
+// DEL key [key ...]
+func delCommand(c *Client, args []string) string {
+	return formatInteger(c.db().Del(args[1:]...))
+}
+
+// MGET key [key ...]
+func mgetCommand(c *Client, args []string) string {
+	return formatNullableArray(c.db().MGet(args[1:]...))
+}
+
+// MSET key value [key value ...]
+func msetCommand(c *Client, args []string) string {
+	if len(args)%2 != 1 {
+		return formatError("ERR wrong number of arguments for 'mset' command")
+	}
+	return statusReply(c.db().MSet(args[1:]...))
+}
+
+// statusReply formats the result of a store write: "OK", or an error message
+func statusReply(status string) string {
+	if status != "OK" {
+		return formatError(status)
+	}
+	return formatSimpleString(status)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// commandStats counts the calls of one registry entry
+type commandStats struct {
+	calls    atomic.Int64
+	usec     atomic.Int64 // Microseconds spent in the handler, blocking included
+	failed   atomic.Int64 // Calls that replied with an error
+	rejected atomic.Int64 // Calls refused before running, by arity or listener rules
+}
+
+// record counts a call that ran for elapsed
+func (st *commandStats) record(elapsed time.Duration, failed bool) {
+	st.calls.Add(1)
+	st.usec.Add(elapsed.Microseconds())
+	if failed {
+		st.failed.Add(1)
+	}
+}
+
+// infoCommandStats reports every registry entry that was called or
+// rejected, subcommands as container|sub like Redis 7 does, by name
+func infoCommandStats() []string {
+	var entries []*Command
+	for _, cmd := range commandTable {
+		entries = append(entries, cmd)
+		for _, sub := range cmd.Subcommands {
+			// Aliases share the subcommands of their target
+			if sub.Container == cmd {
+				entries = append(entries, sub)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	var lines []string
+	for _, cmd := range entries {
+		calls, rejected := cmd.stats.calls.Load(), cmd.stats.rejected.Load()
+		if calls == 0 && rejected == 0 {
+			continue
+		}
+		usec := cmd.stats.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			cmd.Name, calls, usec, perCall, rejected, cmd.stats.failed.Load()))
+	}
+	return lines
+}
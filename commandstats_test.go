@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubcommandEntries(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	stat := func(name string) string {
+		for _, line := range infoCommandStats() {
+			if strings.HasPrefix(line, "cmdstat_"+name+":") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	// Test 1: Subcommands resolve case-insensitively to their own entries
+	config := lookupCommand("config")
+	if cmd := config.resolve([]string{"CONFIG", "Get", "gogc"}); cmd.Name != "config|get" || cmd.Container != config {
+		t.Fatalf("expected config|get, got %s", cmd.Name)
+	}
+	if cmd := config.resolve([]string{"CONFIG", "REWRITE"}); cmd != config {
+		t.Fatalf("expected an unknown subcommand to resolve to the container, got %s", cmd.Name)
+	}
+
+	// Test 2: Arity errors name the subcommand and count as rejected calls
+	before := config.Subcommands["SET"].stats.rejected.Load()
+	if reply := run("CONFIG", "SET", "gogc"); reply != "-ERR wrong number of arguments for 'config|set' command\r\n" {
+		t.Fatalf("unexpected arity error %q", reply)
+	}
+	if rejected := config.Subcommands["SET"].stats.rejected.Load(); rejected != before+1 {
+		t.Fatalf("expected a rejected config|set call, got %d", rejected-before)
+	}
+	if reply := run("OBJECT", "HELP", "extra"); !strings.Contains(reply, "'object|help'") {
+		t.Fatalf("expected an object|help arity error, got %q", reply)
+	}
+
+	// Test 3: INFO commandstats reports subcommands separately
+	run("SET", "k", "v")
+	run("OBJECT", "ENCODING", "k")
+	run("OBJECT", "ENCODING", "k")
+	run("OBJECT", "FREQ", "k")
+	if line := stat("object|encoding"); !strings.Contains(line, "calls=") || !strings.Contains(line, "failed_calls=0") {
+		t.Fatalf("expected object|encoding calls, got %q", line)
+	}
+	object := lookupCommand("object")
+	failed := object.stats.failed.Load()
+	run("OBJECT", "NOPE", "k")
+	if object.stats.failed.Load() != failed+1 || stat("object") == "" {
+		t.Fatalf("expected the unknown subcommand to fail on the container")
+	}
+	if reply := run("INFO", "commandstats"); !strings.Contains(reply, "# Commandstats\r\n") || !strings.Contains(reply, "cmdstat_object|freq:") {
+		t.Fatalf("expected a commandstats section, got %q", reply)
+	}
+
+	// Test 4: Listener rules apply per subcommand
+	dataOnly := &Client{policy: ListenerConfig{Name: "app", Categories: []string{categoryData}}.policy()}
+	if reply := dataOnly.execute([]string{"CLIENT", "ID"}); strings.HasPrefix(reply, "-") {
+		t.Fatalf("expected CLIENT ID to be allowed, got %q", reply)
+	}
+	kill := lookupCommand("client").Subcommands["KILL"]
+	rejected := kill.stats.rejected.Load()
+	reply := dataOnly.execute([]string{"CLIENT", "KILL", "ID", "12345"})
+	if reply != "-NOPERM listener 'app' does not allow the 'client|kill' command (admin)\r\n" {
+		t.Fatalf("expected CLIENT KILL to be refused, got %q", reply)
+	}
+	if kill.stats.rejected.Load() != rejected+1 || !strings.Contains(stat("client|kill"), "rejected_calls=") {
+		t.Fatalf("expected a rejected client|kill call, got %q", stat("client|kill"))
+	}
+
+	// Test 5: CLIENT LIST shows the running subcommand
+	if info := run("CLIENT", "INFO"); !strings.Contains(info, "cmd=client|info") {
+		t.Fatalf("expected cmd=client|info, got %q", info)
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings read from the configuration file
+type Config struct {
+	Port                   int         // TCP port to listen on
+	Databases              int         // Number of logical databases
+	Aliases                [][2]string // Command aliases as (alias, target) pairs, in file order
+	EnableDebugCommand     bool        // Whether the DEBUG command may be used
+	EnableProtectedConfigs bool        // Whether CONFIG SET may change dir, dbfilename and appendfilename
+	HotKeyProtection       bool        // Whether hot keys are served from the read cache
+	HotKeyThreshold        int         // Reads per second above which a key is hot
+	TombstoneWindow        int         // Seconds deleted keys are kept for UNDELETE (0 disables)
+	DefaultTTLs            []DefaultTTLRule
+	ArchiveIdle            int              // Seconds of idle time after which keys are archived (0 disables)
+	ArchiveWebhook         string           // URL receiving archived keys as JSON
+	ActiveRehashing        bool             // Whether resizes are advanced in the background, not only by writes
+	PreciseExpireKeys      int              // Keys per database that may get an exact expiration timer
+	ForbidTypeOverwrite    bool             // Whether SET and MSET refuse to replace keys of another type
+	MaxClients             int              // Maximum number of simultaneous client connections
+	HandshakeTimeout       time.Duration    // Time a new connection has to send its first command (0 disables)
+	MaxPendingHandshake    int              // Connections allowed to be waiting for their first command (0 means no cap)
+	PipelineMaxPending     int              // Commands a connection may have read ahead of their execution
+	ListMaxListpackSize    int              // Largest list kept as a listpack: elements if positive, -1..-5 for 4KB..64KB
+	SetMaxIntsetEntries    int              // Largest set of integers kept as an intset (0 disables intsets)
+	HashMaxListpackEntries int              // Most fields of a hash kept as a listpack
+	HashMaxListpackValue   int              // Longest field or value of a hash kept as a listpack
+	ZsetMaxListpackEntries int              // Most members of a sorted set kept packed
+	ZsetMaxListpackValue   int              // Longest member of a sorted set kept packed
+	MemoryPrefixes         []string         // Key prefixes MEMORY PREFIXES breaks usage down by
+	CompressedPrefixes     []string         // Key prefixes stored as a short code (key-prefix-compression)
+	Listeners              []ListenerConfig // Endpoints besides the one on Port, in file order
+	GoMaxProcs             int              // GOMAXPROCS (0 derives it from the cgroup CPU quota)
+	GoGC                   int              // GOGC percentage, -1 disabling the collector (0 keeps the default)
+	GoMemLimit             int64            // GOMEMLIMIT in bytes, -1 for none (0 derives it from the cgroup memory limit)
+	HandoffSocket          string           // Unix socket a new process takes the listeners and data over from (empty disables)
+	PubSubOutputLimit      outputLimit      // Pending pushed frames a subscriber may have before it is disconnected
+	BusyReplyThreshold     time.Duration    // How long a script runs before other clients get BUSY replies (0 means never)
+	Dir                    string           // Directory the dump file is written to
+	DBFilename             string           // Name of the dump file SAVE and BGSAVE write
+	SavePoints             []savePoint      // Thresholds starting a BGSAVE automatically, empty for none
+	RDBCompression         bool             // Whether strings in RDB data are LZF compressed
+	AppendOnly             bool             // Whether write commands are logged to the append-only file
+	AppendFilename         string           // Name of the append-only file, in Dir
+	AppendFsync            string           // How often the append-only file is fsynced: always, everysec or no
+	NotifyKeyspaceEvents   int              // notify-keyspace-events flags, see notify.go
+
+	savePointsSet bool // Whether a save directive replaced the default save points
+}
+
+// DefaultTTLRule is one default-ttl directive: keys created without a TTL in
+// database DB, or starting with Prefix in any database, expire after Seconds
+type DefaultTTLRule struct {
+	DB      int    // Database the rule applies to, or -1 for a prefix rule
+	Prefix  string // Key prefix the rule applies to
+	Seconds int
+}
+
+// DefaultConfig returns the settings used when no configuration file is given
+func DefaultConfig() *Config {
+	return &Config{
+		Port:            6379,
+		Databases:       defaultDatabases,
+		HotKeyThreshold: hotKeyDefaultLimit,
+		ActiveRehashing: true,
+		MaxClients:      defaultMaxClients,
+
+		PipelineMaxPending:  defaultPipelineMaxPending,
+		ListMaxListpackSize: defaultListPackSize,
+		SetMaxIntsetEntries: defaultIntsetEntries,
+
+		HashMaxListpackEntries: defaultHashListpackEntries,
+		HashMaxListpackValue:   defaultHashListpackValue,
+		ZsetMaxListpackEntries: defaultZsetListpackEntries,
+		ZsetMaxListpackValue:   defaultZsetListpackValue,
+
+		PubSubOutputLimit:  defaultPubSubOutputLimit,
+		BusyReplyThreshold: defaultBusyReplyThreshold,
+
+		Dir:        defaultDir,
+		DBFilename: defaultDBFilename,
+		SavePoints: defaultSavePoints,
+
+		RDBCompression: true,
+		AppendFilename: defaultAppendFilename,
+		AppendFsync:    defaultAppendFsync,
+	}
+}
+
+// Connection limit when maxclients is not configured, as in Redis
+const defaultMaxClients = 10000
+
+// Global configuration instance
+var config = DefaultConfig()
+
+// Absolute path of the configuration file the server was started with, empty
+// without one
+var configFile string
+
+// LoadConfigFile reads a configuration file in redis.conf syntax
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// ParseConfig parses configuration directives, one per line
+// Blank lines and lines starting with '#' are ignored
+func ParseConfig(r io.Reader) (*Config, error) {
+	cfg := DefaultConfig()
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if err := cfg.applyDirective(strings.ToLower(fields[0]), fields[1:]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyDirective sets the option named by a single configuration line
+func (cfg *Config) applyDirective(name string, args []string) error {
+	switch name {
+	case "port":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		port, err := strconv.Atoi(args[0])
+		if err != nil || port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", args[0])
+		}
+		cfg.Port = port
+
+	case "listener":
+		lc, err := parseListener(args)
+		if err != nil {
+			return err
+		}
+		if lc.Name == defaultListenerName {
+			return fmt.Errorf("listener name '%s' is reserved for the listener on 'port'", lc.Name)
+		}
+		for _, other := range cfg.Listeners {
+			if other.Name == lc.Name {
+				return fmt.Errorf("listener '%s' is defined twice", lc.Name)
+			}
+		}
+		cfg.Listeners = append(cfg.Listeners, lc)
+
+	case "databases":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid number of databases '%s'", args[0])
+		}
+		cfg.Databases = count
+
+	case "maxclients":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid maxclients '%s'", args[0])
+		}
+		cfg.MaxClients = limit
+
+	case "list-max-listpack-size", "list-max-ziplist-size":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size == 0 || size < listPackMaxSizeStep {
+			return fmt.Errorf("invalid %s '%s' (use a positive element count or -1 to -5)", name, args[0])
+		}
+		cfg.ListMaxListpackSize = size
+
+	case "set-max-intset-entries":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid set-max-intset-entries '%s'", args[0])
+		}
+		cfg.SetMaxIntsetEntries = n
+
+	case "hash-max-listpack-entries", "hash-max-ziplist-entries",
+		"hash-max-listpack-value", "hash-max-ziplist-value":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		n, err := parseListpackLimit(name, args[0])
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, "-entries") {
+			cfg.HashMaxListpackEntries = n
+		} else {
+			cfg.HashMaxListpackValue = n
+		}
+
+	case "zset-max-listpack-entries", "zset-max-ziplist-entries",
+		"zset-max-listpack-value", "zset-max-ziplist-value":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		n, err := parseListpackLimit(name, args[0])
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, "-entries") {
+			cfg.ZsetMaxListpackEntries = n
+		} else {
+			cfg.ZsetMaxListpackValue = n
+		}
+
+	case "client-output-buffer-limit":
+		limit, found, err := parseOutputLimits(args)
+		if err != nil {
+			return err
+		}
+		if found {
+			cfg.PubSubOutputLimit = limit
+		}
+
+	case "busy-reply-threshold", "lua-time-limit":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		threshold, err := parseBusyReplyThreshold(name, args[0])
+		if err != nil {
+			return err
+		}
+		cfg.BusyReplyThreshold = threshold
+
+	case "dir":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		cfg.Dir = args[0]
+
+	case "dbfilename":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		if err := validateDBFilename(args[0]); err != nil {
+			return err
+		}
+		cfg.DBFilename = args[0]
+
+	case "save":
+		if len(args) == 0 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		points, err := parseSavePoints(args)
+		if err != nil {
+			return err
+		}
+		if !cfg.savePointsSet {
+			cfg.SavePoints, cfg.savePointsSet = nil, true
+		}
+		cfg.SavePoints = append(cfg.SavePoints, points...)
+
+	case "rdbcompression":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.RDBCompression = enabled
+
+	case "appendonly":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.AppendOnly = enabled
+
+	case "appendfilename":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		if err := validateAppendFilename(args[0]); err != nil {
+			return err
+		}
+		cfg.AppendFilename = args[0]
+
+	case "appendfsync":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		policy, err := parseAppendFsync(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.AppendFsync = policy
+
+	case "notify-keyspace-events":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		flags, err := parseNotifyFlags(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.NotifyKeyspaceEvents = flags
+
+	case "handoff-socket":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		cfg.HandoffSocket = args[0]
+
+	case "key-prefix-compression":
+		if len(args) == 0 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		cfg.CompressedPrefixes = append(cfg.CompressedPrefixes, args...)
+
+	case "memory-prefix":
+		if len(args) == 0 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		cfg.MemoryPrefixes = append(cfg.MemoryPrefixes, args...)
+
+	case "handshake-timeout":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		seconds, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid handshake-timeout '%s'", args[0])
+		}
+		cfg.HandshakeTimeout = time.Duration(seconds * float64(time.Second))
+
+	case "max-pending-handshakes":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid max-pending-handshakes '%s'", args[0])
+		}
+		cfg.MaxPendingHandshake = limit
+
+	case "pipeline-max-pending":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid pipeline-max-pending '%s'", args[0])
+		}
+		cfg.PipelineMaxPending = limit
+
+	case "gomaxprocs", "gogc", "gomemlimit":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		var err error
+		switch name {
+		case "gomaxprocs":
+			cfg.GoMaxProcs, err = parseGoMaxProcs(args[0])
+		case "gogc":
+			cfg.GoGC, err = parseGoGC(args[0])
+		default:
+			cfg.GoMemLimit, err = parseGoMemLimit(args[0])
+		}
+		if err != nil {
+			return err
+		}
+
+	case "enable-debug-command":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.EnableDebugCommand = enabled
+
+	case "enable-protected-configs":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.EnableProtectedConfigs = enabled
+
+	case "hotkey-protection":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.HotKeyProtection = enabled
+
+	case "hotkey-threshold":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		threshold, err := strconv.Atoi(args[0])
+		if err != nil || threshold < 1 {
+			return fmt.Errorf("invalid hotkey-threshold '%s'", args[0])
+		}
+		cfg.HotKeyThreshold = threshold
+
+	case "tombstone-window":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		window, err := strconv.Atoi(args[0])
+		if err != nil || window < 0 {
+			return fmt.Errorf("invalid tombstone-window '%s'", args[0])
+		}
+		cfg.TombstoneWindow = window
+
+	case "default-ttl":
+		// default-ttl db <index> <seconds>
+		// default-ttl prefix <prefix> <seconds>
+		if len(args) != 3 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		seconds, err := strconv.Atoi(args[2])
+		if err != nil || seconds < 1 {
+			return fmt.Errorf("invalid default TTL '%s'", args[2])
+		}
+		rule := DefaultTTLRule{DB: -1, Seconds: seconds}
+		switch strings.ToLower(args[0]) {
+		case "db":
+			index, err := strconv.Atoi(args[1])
+			if err != nil || index < 0 {
+				return fmt.Errorf("invalid database index '%s'", args[1])
+			}
+			rule.DB = index
+		case "prefix":
+			rule.Prefix = args[1]
+		default:
+			return fmt.Errorf("default-ttl scope must be 'db' or 'prefix', got '%s'", args[0])
+		}
+		cfg.DefaultTTLs = append(cfg.DefaultTTLs, rule)
+
+	case "activerehashing":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.ActiveRehashing = enabled
+
+	case "forbid-type-overwrite":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		enabled, err := parseYesNo(args[0])
+		if err != nil {
+			return err
+		}
+		cfg.ForbidTypeOverwrite = enabled
+
+	case "precise-expire-keys":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid precise-expire-keys '%s'", args[0])
+		}
+		cfg.PreciseExpireKeys = limit
+
+	case "archive-idle":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		idle, err := strconv.Atoi(args[0])
+		if err != nil || idle < 0 {
+			return fmt.Errorf("invalid archive-idle '%s'", args[0])
+		}
+		cfg.ArchiveIdle = idle
+
+	case "archive-webhook":
+		if len(args) != 1 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		if !strings.HasPrefix(args[0], "http://") && !strings.HasPrefix(args[0], "https://") {
+			return fmt.Errorf("archive-webhook must be an http or https URL, got '%s'", args[0])
+		}
+		cfg.ArchiveWebhook = args[0]
+
+	case "alias":
+		// alias <new-name> <existing-command>
+		if len(args) != 2 {
+			return fmt.Errorf("wrong number of arguments for '%s'", name)
+		}
+		cfg.Aliases = append(cfg.Aliases, [2]string{args[0], args[1]})
+
+	default:
+		return fmt.Errorf("bad directive '%s'", name)
+	}
+	return nil
+}
+
+// apply installs the settings that live outside the Config struct, such as
+// aliases and store tuning
+func (cfg *Config) apply() error {
+	prefixTTLs := make(map[string]time.Duration)
+	for _, rule := range cfg.DefaultTTLs {
+		if rule.DB >= databases.Count() {
+			return fmt.Errorf("default-ttl refers to database %d, but only %d are configured", rule.DB, databases.Count())
+		}
+		if rule.DB < 0 {
+			prefixTTLs[rule.Prefix] = time.Duration(rule.Seconds) * time.Second
+		}
+	}
+
+	for i, db := range databases.All() {
+		db.hot.enabled.Store(cfg.HotKeyProtection)
+		db.hot.limit.Store(int64(cfg.HotKeyThreshold))
+		db.SetTombstoneWindow(time.Duration(cfg.TombstoneWindow) * time.Second)
+		db.SetPreciseExpireLimit(cfg.PreciseExpireKeys)
+		db.SetTypeGuard(cfg.ForbidTypeOverwrite)
+		db.SetListPackSize(cfg.ListMaxListpackSize)
+		db.SetIntsetEntries(cfg.SetMaxIntsetEntries)
+		db.SetHashListpackLimits(cfg.HashMaxListpackEntries, cfg.HashMaxListpackValue)
+		db.SetZsetListpackLimits(cfg.ZsetMaxListpackEntries, cfg.ZsetMaxListpackValue)
+		if err := db.SetKeyPrefixCompression(cfg.CompressedPrefixes); err != nil {
+			return err
+		}
+
+		var dbTTL time.Duration
+		for _, rule := range cfg.DefaultTTLs {
+			if rule.DB == i {
+				dbTTL = time.Duration(rule.Seconds) * time.Second
+			}
+		}
+		db.SetDefaultTTLs(dbTTL, prefixTTLs)
+	}
+
+	keyspaceEvents.Store(int64(cfg.NotifyKeyspaceEvents))
+	SetArchiveIdle(time.Duration(cfg.ArchiveIdle) * time.Second)
+	SetMemoryPrefixes(cfg.MemoryPrefixes)
+	if cfg.ArchiveWebhook != "" {
+		RegisterArchiveHook(webhookArchiveHook(cfg.ArchiveWebhook))
+	}
+
+	for _, alias := range cfg.Aliases {
+		if err := registerAlias(alias[0], alias[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseYesNo parses a boolean directive argument
+func parseYesNo(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no', got '%s'", arg)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	// Test 1: Directives, comments and blank lines
+	input := `
+# Listen somewhere else
+port 7000
+enable-protected-configs yes
+
+alias CACHEGET GET
+alias cacheset set
+`
+	cfg, err := ParseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Port != 7000 || !cfg.EnableProtectedConfigs {
+		t.Fatalf("expected port 7000 with protected configs enabled, got %d and %v", cfg.Port, cfg.EnableProtectedConfigs)
+	}
+	if len(cfg.Aliases) != 2 || cfg.Aliases[0] != [2]string{"CACHEGET", "GET"} {
+		t.Fatalf("expected two aliases starting with CACHEGET->GET, got %v", cfg.Aliases)
+	}
+
+	// Test 2: Unknown directive reports the line number
+	_, err = ParseConfig(strings.NewReader("port 7000\nbogus yes\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+
+	// Test 3: Wrong argument count
+	_, err = ParseConfig(strings.NewReader("alias ONLYONE\n"))
+	if err == nil {
+		t.Fatalf("expected error for alias with one argument")
+	}
+}
+
+func TestCommandAliases(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader("alias TESTCACHEGET GET\nalias TESTCACHESET SET\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := cfg.apply(); err != nil {
+		t.Fatalf("expected no error applying aliases, got %v", err)
+	}
+	defer func() {
+		delete(commandTable, "TESTCACHEGET")
+		delete(commandTable, "TESTCACHESET")
+	}()
+
+	client := &Client{}
+
+	// Test 1: Aliases run the target handlers
+	reply := client.execute([]string{"testcacheset", "aliaskey", "aliasvalue"})
+	if reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	reply = client.execute([]string{"TESTCACHEGET", "aliaskey"})
+	if reply != "$10\r\naliasvalue\r\n" {
+		t.Fatalf("expected aliasvalue bulk string, got %q", reply)
+	}
+	client.db().Del("aliaskey")
+
+	// Test 2: Errors mention the alias name
+	reply = client.execute([]string{"TESTCACHEGET"})
+	if reply != "-ERR wrong number of arguments for 'testcacheget' command\r\n" {
+		t.Fatalf("unexpected arity error %q", reply)
+	}
+
+	// Test 3: Invalid aliases are rejected
+	if err := registerAlias("TESTNOPE", "NOSUCHCOMMAND"); err == nil {
+		t.Fatalf("expected error aliasing an unknown command")
+	}
+	if err := registerAlias("GET", "SET"); err == nil {
+		t.Fatalf("expected error when an alias shadows an existing command")
+	}
+
+	// Test 4: Aliases are subject to the policies of their target: an EXEC
+	// alias runs the transaction, a MULTI alias is refused in scripts, and a
+	// SET alias may not write from read-only functions
+	for alias, target := range map[string]string{"TESTCOMMIT": "EXEC", "TESTBEGIN": "MULTI", "TESTPUT": "SET"} {
+		if err := registerAlias(alias, target); err != nil {
+			t.Fatalf("expected %s to alias %s, got %v", alias, target, err)
+		}
+		defer delete(commandTable, alias)
+	}
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	savedLibraries, savedVM := libraries, functionVM
+	libraries, functionVM = newFunctionRegistry(), nil
+	defer func() { libraries, functionVM = savedLibraries, savedVM }()
+	for _, step := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"TESTBEGIN"}, "+OK\r\n"},
+		{[]string{"TESTPUT", "k", "v"}, "+QUEUED\r\n"},
+		{[]string{"TESTCOMMIT"}, "*1\r\n+OK\r\n"},
+		{[]string{"EVAL", "return redis.call('TESTBEGIN')", "0"}, "-ERR This Redis command is not allowed from script"},
+		{[]string{"FUNCTION", "LOAD", "#!lua name=aliaslib\n" +
+			"redis.register_function{function_name='ro_put', callback=function(keys) return redis.call('TESTPUT', keys[1], 'w') end, flags={'no-writes'}}\n"}, "$8\r\naliaslib\r\n"},
+		{[]string{"FCALL_RO", "ro_put", "1", "k"}, "-ERR Write commands are not allowed from read-only scripts."},
+		{[]string{"FCALL", "ro_put", "1", "k"}, "-ERR Write commands are not allowed from read-only scripts."},
+		{[]string{"GET", "k"}, "$1\r\nv\r\n"},
+	} {
+		if reply := client.execute(step.args); !strings.HasPrefix(reply, step.want) {
+			t.Fatalf("expected %v to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+}
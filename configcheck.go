@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// File descriptors the server needs besides client connections (listener,
+// log output, webhook connections, ...), following Redis's CONFIG_MIN_RESERVED_FDS
+const reservedFDs = 32
+
+// checkConfig validates a configuration file as a deployment gate, without
+// changing any server state. Each problem is reported with what to change.
+func checkConfig(path string) []string {
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	problems = append(problems, cfg.checkDefaultTTLs()...)
+	problems = append(problems, cfg.checkAliases()...)
+	problems = append(problems, cfg.checkArchive()...)
+	absPath, _ := filepath.Abs(path)
+	problems = append(problems, cfg.checkListeners(absPath)...)
+	problems = append(problems, cfg.checkFileLimit()...)
+	problems = append(problems, cfg.checkDir()...)
+	return problems
+}
+
+func (cfg *Config) checkDefaultTTLs() []string {
+	var problems []string
+	for _, rule := range cfg.DefaultTTLs {
+		if rule.DB >= cfg.Databases {
+			problems = append(problems, fmt.Sprintf(
+				"default-ttl refers to database %d, but only %d are configured: raise 'databases' or fix the index",
+				rule.DB, cfg.Databases))
+		}
+	}
+	return problems
+}
+
+// checkAliases applies the rules of registerAlias against the command table,
+// including aliases defined earlier in the file
+func (cfg *Config) checkAliases() []string {
+	var problems []string
+	defined := make(map[string]bool)
+	for _, alias := range cfg.Aliases {
+		name, target := strings.ToUpper(alias[0]), strings.ToUpper(alias[1])
+		if lookupCommand(target) == nil && !defined[target] {
+			problems = append(problems, fmt.Sprintf("alias '%s' refers to unknown command '%s'", alias[0], alias[1]))
+		}
+		if lookupCommand(name) != nil || defined[name] {
+			problems = append(problems, fmt.Sprintf("alias '%s' would shadow an existing command: pick another name", alias[0]))
+		}
+		defined[name] = true
+	}
+	return problems
+}
+
+func (cfg *Config) checkArchive() []string {
+	switch {
+	case cfg.ArchiveIdle > 0 && cfg.ArchiveWebhook == "":
+		return []string{"archive-idle is set without archive-webhook, so no key will be archived: add a webhook or remove archive-idle"}
+	case cfg.ArchiveIdle == 0 && cfg.ArchiveWebhook != "":
+		return []string{"archive-webhook is set but archive-idle is 0, so the webhook is never called: set archive-idle"}
+	}
+	return nil
+}
+
+// checkListeners verifies every listener can be started: its address
+// parses, names a local interface and a port the process may bind, and is
+// not taken by a process other than a server running this configuration
+// (path), as when checking a file before restarting with it. For tls
+// listeners, the key pair must load. Nothing is bound, so the check never
+// takes a port from a running server.
+func (cfg *Config) checkListeners(path string) []string {
+	var problems []string
+	for _, lc := range cfg.listeners() {
+		if lc.Kind == listenerTLS {
+			if _, err := tls.LoadX509KeyPair(lc.CertFile, lc.KeyFile); err != nil {
+				problems = append(problems, fmt.Sprintf("listener '%s' cannot load its certificate (%v): fix 'cert' and 'key'", lc.Name, err))
+				continue
+			}
+		}
+		if lc.Kind == listenerUnix {
+			// Binding would replace a stale socket, so only check the directory
+			if _, err := os.Stat(filepath.Dir(lc.Address)); err != nil {
+				problems = append(problems, fmt.Sprintf("listener '%s' socket directory is not usable (%v): create it or change the path", lc.Name, err))
+			}
+			continue
+		}
+
+		err := checkBindable(lc.Address)
+		if err == nil && listenerTaken(lc, path) {
+			err = errors.New("in use by another process")
+		}
+		if err != nil {
+			if lc.Name == defaultListenerName {
+				problems = append(problems, fmt.Sprintf("port %d cannot be bound (%v): stop the process using it or change 'port'", cfg.Port, err))
+			} else {
+				problems = append(problems, fmt.Sprintf("listener '%s' cannot bind %s (%v): stop the process using it or change its address", lc.Name, lc.Address, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkBindable checks that a host:port address can be bound by the
+// process: its host is one of the machine's addresses, and its port is not
+// privileged unless the process may bind those
+func checkBindable(address string) error {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port '%s'", portText)
+	}
+	if host != "" {
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			if ips, err = net.LookupIP(host); err != nil {
+				return err
+			}
+		}
+		local, err := net.InterfaceAddrs()
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			if !ip.IsUnspecified() && !ip.IsLoopback() && !slices.ContainsFunc(local, func(addr net.Addr) bool {
+				ipNet, ok := addr.(*net.IPNet)
+				return ok && ipNet.IP.Equal(ip)
+			}) {
+				return fmt.Errorf("%s is not an address of this machine", ip)
+			}
+		}
+	}
+	if start := unprivilegedPortStart(); port > 0 && port < start && !canBindPrivileged() {
+		return fmt.Errorf("ports below %d need root or CAP_NET_BIND_SERVICE", start)
+	}
+	return nil
+}
+
+// unprivilegedPortStart returns the lowest port unprivileged processes may
+// bind, as the kernel is configured
+func unprivilegedPortStart() int {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return 1024
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 1024
+	}
+	return start
+}
+
+// canBindPrivileged reports whether the process runs as root or holds
+// CAP_NET_BIND_SERVICE. Where capabilities cannot be read, it is assumed to.
+func canBindPrivileged() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if mask, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(mask), 16, 64)
+			return err != nil || caps&(1<<capNetBindService) != 0
+		}
+	}
+	return true
+}
+
+// Bit of CAP_NET_BIND_SERVICE in the capability masks
+const capNetBindService = 10
+
+// listenerTaken reports whether a process accepts connections on the
+// address of lc, unless it is a server running the configuration file path:
+// one whose INFO server names it, or that refuses INFO as this server's
+// listeners do
+func listenerTaken(lc ListenerConfig, path string) bool {
+	host, port, _ := net.SplitHostPort(lc.Address)
+	if ip := net.ParseIP(host); host == "" || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	var reply string
+	switch lc.Kind {
+	case listenerHTTP:
+		reply = probeHTTP(conn, lc.Password)
+	case listenerTLS:
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true}) // Only to identify the server
+		reply = probeRESP(tlsConn, lc.Password)
+	default:
+		reply = probeRESP(conn, lc.Password)
+	}
+	owned := path != "" && strings.Contains(reply, "config_file:"+path+"\r\n")
+	refused := strings.HasPrefix(reply, "-NOPERM listener '"+lc.Name+"'")
+	return !owned && !refused
+}
+
+// probeRESP sends INFO server over conn, after AUTH if password is set
+// Returns the reply, empty if there was none
+func probeRESP(conn net.Conn, password string) string {
+	r := bufio.NewReader(conn)
+	if password != "" {
+		conn.Write([]byte(formatArray([]string{"AUTH", password})))
+		if _, err := r.ReadString('\n'); err != nil {
+			return ""
+		}
+	}
+	conn.Write([]byte(formatArray([]string{"INFO", "server"})))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "$") {
+		return line
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 0 {
+		return line
+	}
+	body := make([]byte, n+2)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return ""
+	}
+	return line + string(body)
+}
+
+// probeHTTP runs INFO server through the command endpoint of an http
+// listener on conn
+// Returns the RESP reply, empty if there was none
+func probeHTTP(conn net.Conn, password string) string {
+	req, _ := http.NewRequest(http.MethodPost, "http://"+conn.RemoteAddr().String()+"/command", strings.NewReader(`["INFO","server"]`))
+	if password != "" {
+		req.Header.Set("Authorization", "Bearer "+password)
+	}
+	if err := req.Write(conn); err != nil {
+		return ""
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// checkFileLimit verifies the open files limit leaves room for maxclients connections
+func (cfg *Config) checkFileLimit() []string {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return []string{fmt.Sprintf("cannot read the open files limit: %v", err)}
+	}
+	needed := uint64(cfg.MaxClients + reservedFDs)
+	if limit.Cur < needed {
+		return []string{fmt.Sprintf(
+			"open files limit is %d, but maxclients %d needs %d: raise it (ulimit -n %d) or lower maxclients to %d",
+			limit.Cur, cfg.MaxClients, needed, needed, max(int(limit.Cur)-reservedFDs, 1))}
+	}
+	return nil
+}
+
+// runConfigTest prints the result of checkConfig and returns the process exit code
+func runConfigTest(path string) int {
+	problems := checkConfig(path)
+	if len(problems) == 0 {
+		fmt.Printf("Configuration file %s is OK\n", path)
+		return 0
+	}
+	fmt.Printf("Configuration file %s has %d problem(s):\n", path, len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+	return 1
+}
+
+// checkDir verifies the directory the dump and append-only files are
+// written to exists and a file can be created in it, by creating and removing
+// one
+func (cfg *Config) checkDir() []string {
+	if err := validateDir(cfg.Dir); err != nil {
+		return []string{fmt.Sprintf("dir '%s' can't be used for the dump file (%v): create it or fix the path", cfg.Dir, err)}
+	}
+	probe, err := os.CreateTemp(cfg.Dir, "temp-config-check-*")
+	if err != nil {
+		return []string{fmt.Sprintf("dir '%s' is not writable (%v): fix its permissions or change 'dir'", cfg.Dir, err)}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
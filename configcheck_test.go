@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a configuration file into a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return path
+}
+
+func TestCheckConfig(t *testing.T) {
+	// Test 1: A valid file has no problems
+	good := writeConfig(t, "port 0\nmaxclients 100\nalias FETCH GET\nalias FETCH2 FETCH\n")
+	if problems := checkConfig(good); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	// Test 2: Syntax errors stop the check
+	if problems := checkConfig(writeConfig(t, "port 0\nbogus 1\n")); len(problems) != 1 || !strings.Contains(problems[0], "line 2") {
+		t.Fatalf("expected one syntax problem, got %v", problems)
+	}
+
+	// Test 3: Semantic problems are all reported
+	bad := writeConfig(t, "port 0\nmaxclients 100\ndatabases 2\ndefault-ttl db 5 60\nalias GET SET\nalias X NOSUCH\narchive-idle 60\n")
+	problems := checkConfig(bad)
+	if len(problems) != 4 {
+		t.Fatalf("expected 4 problems, got %v", problems)
+	}
+
+	// Test 4: A port already in use is reported
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	busy := writeConfig(t, "maxclients 100\nport "+strconv.Itoa(port)+"\n")
+	if problems := checkConfig(busy); len(problems) != 1 || !strings.Contains(problems[0], "cannot be bound") {
+		t.Fatalf("expected a port problem, got %v", problems)
+	}
+
+	// Test 5: A port this server holds with the same file is not a problem,
+	// nor is a listener refusing INFO as this server's do; addresses that
+	// cannot be bound are reported without binding anything
+	ours := writeConfig(t, "")
+	savedFile := configFile
+	configFile = ours
+	defer func() { configFile = savedFile }()
+	closer, addr, err := startListener(ListenerConfig{Name: defaultListenerName, Kind: listenerTCP, Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("expected the listener to start: %v", err)
+	}
+	defer closer.Close()
+	ourPort := strconv.Itoa(addr.(*net.TCPAddr).Port)
+	os.WriteFile(ours, []byte("maxclients 100\nport "+ourPort+"\n"), 0o644)
+	if problems := checkConfig(ours); len(problems) != 0 {
+		t.Fatalf("expected the running server's port to be accepted, got %v", problems)
+	}
+	restricted, addr, err := startListener(ListenerConfig{Name: "internal", Kind: listenerTCP, Address: "127.0.0.1:0", Categories: []string{categoryData}})
+	if err != nil {
+		t.Fatalf("expected the listener to start: %v", err)
+	}
+	defer restricted.Close()
+	other := writeConfig(t, "port 0\nmaxclients 100\nlistener internal tcp "+addr.String()+" commands data\n")
+	if problems := checkConfig(other); len(problems) != 0 {
+		t.Fatalf("expected a listener refusing INFO to be accepted, got %v", problems)
+	}
+	unbindable := writeConfig(t, "port 0\nmaxclients 100\nlistener remote tcp 192.0.2.1:6379\nlistener bad tcp 127.0.0.1:notaport\n")
+	if problems := checkConfig(unbindable); len(problems) != 2 || !strings.Contains(problems[0], "not an address of this machine") ||
+		!strings.Contains(problems[1], "invalid port") {
+		t.Fatalf("expected two address problems, got %v", problems)
+	}
+
+	// Test 6: A dir the dump file cannot be created in is reported, and
+	// the probe file is removed
+	dir := t.TempDir()
+	if problems := checkConfig(writeConfig(t, "port 0\nmaxclients 100\ndir "+dir+"\n")); len(problems) != 0 {
+		t.Fatalf("expected a writable dir to be accepted, got %v", problems)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the probe file to be removed, got %v", entries)
+	}
+	if os.Geteuid() != 0 { // root writes regardless of permissions
+		os.Chmod(dir, 0o555)
+		defer os.Chmod(dir, 0o755)
+		if problems := checkConfig(writeConfig(t, "port 0\nmaxclients 100\ndir "+dir+"\n")); len(problems) != 1 || !strings.Contains(problems[0], "not writable") {
+			t.Fatalf("expected a read-only dir to be reported, got %v", problems)
+		}
+	}
+
+	// Test 7: maxclients beyond the open files limit is reported
+	huge := writeConfig(t, "port 0\nmaxclients 1000000000\n")
+	if problems := checkConfig(huge); len(problems) != 1 || !strings.Contains(problems[0], "open files limit") {
+		t.Fatalf("expected a file limit problem, got %v", problems)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configParam is a parameter of CONFIG GET and CONFIG SET
+type configParam struct {
+	name string
+	get  func() string
+	set  func(value string) error // Validates and applies value
+
+	// Whether CONFIG SET may change it only with enable-protected-configs:
+	// paths the server writes to would let any client write files anywhere
+	protected bool
+}
+
+// Parameters of CONFIG GET and CONFIG SET, in the order CONFIG GET lists them
+var configParams = slices.Concat(runtimeConfigParams, hashConfigParams, zsetConfigParams, outputLimitConfigParams, busyConfigParams, saveConfigParams, savePointConfigParams, aofConfigParams, notifyConfigParams)
+
+// Serializes CONFIG SET, whose parameters are changed one at a time
+var configMu sync.Mutex
+
+// lookupConfigParam returns the parameter called name, or nil
+func lookupConfigParam(name string) *configParam {
+	for i := range configParams {
+		if configParams[i].name == name {
+			return &configParams[i]
+		}
+	}
+	return nil
+}
+
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value> [<directive> <value> ...]",
+	"    Set the configuration <directive> to <value>.",
+	"HELP",
+	"    Print this help.",
+	"Parameters are gomaxprocs (a count or auto), gogc (a percentage or off),",
+	"gomemlimit (a size such as 2gb, off or auto), hash-max-listpack-entries,",
+	"hash-max-listpack-value, zset-max-listpack-entries, zset-max-listpack-value,",
+	"client-output-buffer-limit (pubsub <hard> <soft> <seconds>) and",
+	"busy-reply-threshold (milliseconds, alias lua-time-limit), dir, dbfilename,",
+	"save (<seconds> <changes> pairs, or \"\" for none), rdbcompression,",
+	"appendonly, appendfilename, appendfsync (always, everysec or no) and",
+	"notify-keyspace-events (flags such as Ex, or \"\" for none); dir, dbfilename",
+	"and appendfilename need enable-protected-configs yes.",
+}
+
+// CONFIG GET pattern [pattern ...]
+// CONFIG SET parameter value [parameter value ...]
+// CONFIG HELP
+func configCommand(c *Client, args []string) string {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range configHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(configHelp)) + "\r\n" + lines
+	case sub == "GET" && len(args) >= 3:
+		return configGetReply(c, args[2:])
+	case sub == "SET" && len(args) >= 4 && len(args)%2 == 0:
+		return configSetReply(args[2:])
+	case sub == "GET" || sub == "SET":
+		return formatError(fmt.Sprintf("ERR wrong number of arguments for 'config|%s' command", strings.ToLower(sub)))
+	}
+	return formatError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[1]))
+}
+
+func configGetReply(c *Client, patterns []string) string {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	var pairs []string
+	for _, param := range configParams {
+		for _, pattern := range patterns {
+			if matchPattern(strings.ToLower(pattern), param.name) {
+				pairs = append(pairs, formatBulkString(param.name), formatBulkString(param.get()))
+				break
+			}
+		}
+	}
+	return c.formatMap(pairs)
+}
+
+// configSetReply applies every pair or none: after a failure, parameters
+// already set are restored to their previous values
+func configSetReply(pairs []string) string {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	params := make([]*configParam, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		param := lookupConfigParam(strings.ToLower(pairs[i]))
+		if param == nil {
+			return formatError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i]))
+		}
+		for _, seen := range params {
+			if seen == param {
+				return formatError(fmt.Sprintf("ERR Duplicate parameter - '%s'", pairs[i]))
+			}
+		}
+		if param.protected && !config.EnableProtectedConfigs {
+			return formatError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set protected config", pairs[i]))
+		}
+		params = append(params, param)
+	}
+
+	previous := make([]string, len(params))
+	for i, param := range params {
+		previous[i] = param.get()
+	}
+	for i, param := range params {
+		if err := param.set(pairs[2*i+1]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				params[j].set(previous[j])
+			}
+			return formatError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", pairs[2*i], err))
+		}
+	}
+	return formatSimpleString("OK")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Hash field a string is stored under when converted to a hash, unless
+// CONVERT is given a FIELD
+const convertDefaultField = "value"
+
+// SetTypeGuard controls whether SET and MSET may replace a key holding
+// another type. With the guard on they fail, and CONVERT changes types.
+func (s *Store) SetTypeGuard(enabled bool) {
+	s.mu.Lock()
+	s.typeGuard = enabled
+	s.mu.Unlock()
+}
+
+// checkOverwrite returns an error message if the type guard forbids
+// replacing key with a value of type newType. Callers must hold the lock.
+func (s *Store) checkOverwrite(key, newType string) string {
+	if !s.typeGuard {
+		return ""
+	}
+	entry := s.data.peek(key)
+	if entry == nil || entry.Type == newType {
+		return ""
+	}
+	return "WRONGTYPE Refusing to overwrite a " + redisTypeNames[entry.Type] + " with a " +
+		redisTypeNames[newType] + " (forbid-type-overwrite is on; use CONVERT or DEL first)"
+}
+
+// convertValue converts a value between types. field names the hash field
+// a string becomes. Returns a non-empty error message on failure.
+func convertValue(from *Entry, toType, field string) (interface{}, string) {
+	var elements []string
+	switch v := from.Value.(type) {
+	case string:
+		elements = []string{v}
+	case listValue:
+		elements = v.values()
+	case map[string]struct{}, *intset:
+		elements = setMembers(asSet(v))
+		sort.Strings(elements)
+	case map[string]string, *hashPack:
+		// Hashes convert as field/value pairs, in field order
+		hash := asHash(v)
+		fields := hashColumn(hash, func(field, _ string) string { return field })
+		sort.Strings(fields)
+		for _, f := range fields {
+			value, _ := hash.get(f)
+			elements = append(elements, f, value)
+		}
+	default:
+		return nil, "ERR values of type " + redisTypeNames[from.Type] + " cannot be converted"
+	}
+
+	switch toType {
+	case TypeString:
+		if from.Type != TypeString {
+			return nil, "ERR only strings can be converted to a string"
+		}
+		return elements[0], ""
+	case TypeList:
+		return newDeque(elements...), ""
+	case TypeSet:
+		members := make(map[string]struct{}, len(elements))
+		for _, e := range elements {
+			members[e] = struct{}{}
+		}
+		return members, ""
+	case TypeHash:
+		if from.Type == TypeString {
+			return map[string]string{field: elements[0]}, ""
+		}
+		if from.Type == TypeSet || len(elements)%2 != 0 {
+			return nil, "ERR only strings and lists of field/value pairs can be converted to a hash"
+		}
+		hash := make(map[string]string, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			hash[elements[i]] = elements[i+1]
+		}
+		return hash, ""
+	}
+	return nil, "ERR values cannot be converted to " + redisTypeNames[toType]
+}
+
+// Convert changes the type of the value at key in place, keeping its TTL
+// Returns a non-empty error reply on failure
+func (s *Store) Convert(key, toType, field string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.data.get(key)
+	if entry == nil {
+		return formatError("ERR no such key")
+	}
+	if entry.Type == toType {
+		return ""
+	}
+	value, msg := convertValue(entry, toType, field)
+	if msg != "" {
+		return formatError(msg)
+	}
+	switch v := value.(type) {
+	case listValue:
+		value = s.encodeList(v)
+	case map[string]struct{}:
+		value = setStorage(s.encodeSet(v))
+	}
+	s.put(key, &Entry{Type: toType, Value: value, ExpiresAt: entry.ExpiresAt})
+	return ""
+}
+
+// CONVERT key type [FIELD field]
+func convertCommand(c *Client, args []string) string {
+	toType, ok := entryTypeByName(args[2])
+	if !ok {
+		return formatError("ERR unknown type name '" + args[2] + "'")
+	}
+	field := convertDefaultField
+	switch {
+	case len(args) == 5 && strings.ToUpper(args[3]) == "FIELD":
+		field = args[4]
+	case len(args) != 3:
+		return formatError("ERR syntax error")
+	}
+	if errReply := c.db().Convert(args[1], toType, field); errReply != "" {
+		return errReply
+	}
+	return formatSimpleString("OK")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertCommand(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: A string counter becomes a hash field, keeping its TTL
+	databases.Get(0).Restore("counter", &Entry{Type: TypeString, Value: "42", ExpiresAt: time.Now().Add(time.Hour)}, false)
+	if reply := run("CONVERT", "counter", "hash", "FIELD", "hits"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	entry := databases.Get(0).data.peek("counter")
+	if entry.Type != TypeHash || entry.Value.(map[string]string)["hits"] != "42" || entry.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected converted entry %+v", entry)
+	}
+
+	// Test 2: Hash to list flattens pairs, list to set deduplicates
+	if reply := run("CONVERT", "counter", "list"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := run("LRANGE", "counter", "0", "-1"); reply != formatArray([]string{"hits", "42"}) {
+		t.Fatalf("unexpected list %q", reply)
+	}
+	run("RPUSH", "dups", "a", "b", "a")
+	run("CONVERT", "dups", "set")
+	if members := databases.Get(0).data.peek("dups").Value.(map[string]struct{}); len(members) != 2 {
+		t.Fatalf("expected 2 set members, got %v", members)
+	}
+
+	// Test 3: Impossible conversions and missing keys
+	if reply := run("CONVERT", "dups", "string"); !strings.HasPrefix(reply, "-ERR only strings") {
+		t.Fatalf("expected conversion error, got %q", reply)
+	}
+	run("RPUSH", "odd", "a", "b", "c")
+	if reply := run("CONVERT", "odd", "hash"); !strings.HasPrefix(reply, "-ERR only strings and lists") {
+		t.Fatalf("expected odd-length list error, got %q", reply)
+	}
+	if reply := run("CONVERT", "nosuch", "list"); reply != "-ERR no such key\r\n" {
+		t.Fatalf("expected no such key, got %q", reply)
+	}
+	if reply := run("CONVERT", "odd", "bogus"); reply != "-ERR unknown type name 'bogus'\r\n" {
+		t.Fatalf("expected unknown type error, got %q", reply)
+	}
+}
+
+func TestTypeOverwriteGuard(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "queue", "job")
+
+	// Test 1: Without the guard SET replaces the list, as in Redis
+	if reply := run("SET", "queue", "v"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+
+	// Test 2: With the guard SET and MSET refuse, and MSET writes nothing
+	databases.Get(0).SetTypeGuard(true)
+	run("DEL", "queue")
+	run("RPUSH", "queue", "job")
+	if reply := run("SET", "queue", "v"); !strings.HasPrefix(reply, "-WRONGTYPE Refusing to overwrite a list") {
+		t.Fatalf("expected guard error, got %q", reply)
+	}
+	if reply := run("MSET", "fresh", "1", "queue", "v"); !strings.HasPrefix(reply, "-WRONGTYPE") {
+		t.Fatalf("expected guard error from MSET, got %q", reply)
+	}
+	if reply := run("GET", "fresh"); reply != "$-1\r\n" {
+		t.Fatalf("expected MSET to write nothing, got %q", reply)
+	}
+
+	// Test 3: Strings can still be overwritten with strings
+	run("SET", "s", "1")
+	if reply := run("SET", "s", "2"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "sync/atomic"
+
+// Range reads of at least this many elements copy them outside the store lock
+const cowMinElements = 1024
+
+// valueShare counts the readers of a collection value that borrowed it to
+// read outside the store lock
+type valueShare struct {
+	readers atomic.Int32
+}
+
+// borrow lets the caller keep reading the entry's value after releasing the
+// store lock: writers copy a borrowed value before changing it (see own), so
+// the borrower sees it exactly as it was. Callers must hold the read or write
+// lock, and call release on the result once done with the value.
+func (e *Entry) borrow() *valueShare {
+	for {
+		share := e.share.Load()
+		if share == nil {
+			share = &valueShare{}
+			if !e.share.CompareAndSwap(nil, share) {
+				continue // Another reader installed one first
+			}
+		}
+		share.readers.Add(1)
+		return share
+	}
+}
+
+// release ends a borrow
+func (share *valueShare) release() {
+	share.readers.Add(-1)
+}
+
+// own makes the entry's value safe to change in place: if readers still hold
+// it outside the lock, the entry gets a private copy. The next borrow starts
+// a new share, so readers of the old value never cause another copy.
+// Callers must hold the write lock.
+func (e *Entry) own() {
+	share := e.share.Load()
+	if share == nil {
+		return
+	}
+	e.share.Store(nil)
+	if share.readers.Load() > 0 {
+		e.Value = cloneValue(e.Value)
+		cowCopies.Add(1)
+	}
+}
+
+// borrowed reports whether readers may still hold the entry's value outside
+// the lock, so it must not be cleared. Callers must hold the write lock.
+func (e *Entry) borrowed() bool {
+	share := e.share.Load()
+	return share != nil && share.readers.Load() > 0
+}
+
+// Values copied because a writer changed them while borrowed, across all databases
+var cowCopies atomic.Int64
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCopyOnWrite(t *testing.T) {
+	store := NewStore()
+	values := make([]string, 2*cowMinElements)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	store.Push("big", false, values...)
+
+	borrow := func(key string) (*Entry, *valueShare) {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		entry := store.data.lookup(key)
+		return entry, entry.borrow()
+	}
+
+	// Test 1: A writer copies a borrowed value instead of changing it
+	entry, share := borrow("big")
+	held := entry.Value.(listValue)
+	copies := cowCopies.Load()
+	store.Push("big", false, "new")
+	if held.len() != len(values) {
+		t.Fatalf("expected the borrowed list to keep %d elements, got %d", len(values), held.len())
+	}
+	if n, _ := store.LLen("big"); n != len(values)+1 {
+		t.Fatalf("expected the stored list to grow, got %d", n)
+	}
+	if cowCopies.Load() != copies+1 {
+		t.Fatalf("expected one copy")
+	}
+
+	// Test 2: Once the share is replaced, further writes change the value in place
+	share.release()
+	store.Push("big", false, "newer")
+	if cowCopies.Load() != copies+1 {
+		t.Fatalf("expected no copy for an unborrowed value")
+	}
+
+	// Test 3: UNLINK leaves a borrowed value intact
+	entry, share = borrow("big")
+	held = entry.Value.(listValue)
+	store.Unlink("big")
+	if held.len() != len(values)+2 {
+		t.Fatalf("expected the borrowed list to survive UNLINK, got %d elements", held.len())
+	}
+	share.release()
+
+	// Test 4: Borrowed sets are copied by writers too
+	store.SAdd("members", values...)
+	entry, share = borrow("members")
+	set := entry.Value.(map[string]struct{})
+	store.SRem("members", "0")
+	if _, kept := set["0"]; !kept {
+		t.Fatalf("expected the borrowed set to keep its member")
+	}
+	share.release()
+}
+
+func TestLRangeDuringWrites(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 2*cowMinElements; i++ {
+		store.Push("list", false, strconv.Itoa(i))
+	}
+
+	// Test 1: Long ranges read while the list grows are consistent snapshots
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2 * cowMinElements; i < 3*cowMinElements; i++ {
+			store.Push("list", false, strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		elements, _ := store.LRange("list", 0, -1)
+		for j, e := range elements {
+			if e != strconv.Itoa(j) {
+				t.Fatalf("expected element %d to be %d, got %s", j, j, e)
+			}
+		}
+	}
+	wg.Wait()
+
+	// Test 2: Short ranges are still served under the lock
+	if elements, _ := store.LRange("list", 0, 2); len(elements) != 3 || elements[2] != "2" {
+		t.Fatalf("unexpected short range %v", elements)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Number of logical databases when not configured
+const defaultDatabases = 16
+
+// Databases holds the logical databases a client can SELECT.
+// Each database is an independent Store with its own lock; the slice itself
+// is guarded by mu because SWAPDB reorders it.
+type Databases struct {
+	mu  sync.RWMutex
+	dbs []*Store
+
+	// Held for writing while a snapshot copies the databases one at a time,
+	// and for reading by writes spanning two of them, which it would
+	// otherwise see on both sides or neither
+	crossMu sync.RWMutex
+}
+
+// NewDatabases creates n empty databases
+func NewDatabases(n int) *Databases {
+	dbs := make([]*Store, n)
+	for i := range dbs {
+		dbs[i] = NewStore()
+	}
+	return &Databases{dbs: dbs}
+}
+
+// Global database set
+var databases = NewDatabases(defaultDatabases)
+
+// Count returns the number of databases
+func (d *Databases) Count() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.dbs)
+}
+
+// Get returns the database at index, or nil if the index is out of range
+func (d *Databases) Get(index int) *Store {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if index < 0 || index >= len(d.dbs) {
+		return nil
+	}
+	return d.dbs[index]
+}
+
+// All returns every database in index order
+func (d *Databases) All() []*Store {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]*Store(nil), d.dbs...)
+}
+
+// Swap exchanges the contents of two databases, as seen by every client
+func (d *Databases) Swap(i, j int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i < 0 || i >= len(d.dbs) || j < 0 || j >= len(d.dbs) {
+		return false
+	}
+	if i != j {
+		unlock := d.lockPair(i, j)
+		d.dbs[i].touchAllWatched(d.dbs[j])
+		d.dbs[j].touchAllWatched(d.dbs[i])
+		d.dbs[i].swapBlocked(d.dbs[j])
+		// default-ttl db N configures the index, not the data
+		d.dbs[i].ttlPolicy, d.dbs[j].ttlPolicy = d.dbs[j].ttlPolicy, d.dbs[i].ttlPolicy
+		unlock()
+	}
+	d.dbs[i], d.dbs[j] = d.dbs[j], d.dbs[i]
+	return true
+}
+
+// lockPair write-locks two distinct databases in a consistent order so that
+// concurrent cross-database operations cannot deadlock
+// The databases' slice lock must be held so the order cannot change
+func (d *Databases) lockPair(i, j int) func() {
+	if i > j {
+		i, j = j, i
+	}
+	a, b := d.dbs[i], d.dbs[j]
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}
+}
+
+// Move transfers key from database src to database dst
+// Returns false if the key is missing in src or already exists in dst
+func (d *Databases) Move(key string, src, dst int) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	d.crossMu.RLock()
+	defer d.crossMu.RUnlock()
+	unlock := d.lockPair(src, dst)
+	defer unlock()
+
+	from, to := d.dbs[src], d.dbs[dst]
+	entry := from.data.get(key)
+	if entry == nil || to.data.get(key) != nil {
+		return false
+	}
+	from.data.delete(key)
+	from.keyModified(key)
+	to.put(key, entry)
+	return true
+}
+
+// CopyTo duplicates key from database src into key dstKey of database dst
+// Returns false if the source is missing, or the destination exists and replace is false
+func (d *Databases) CopyTo(srcKey string, src int, dstKey string, dst int, replace bool) bool {
+	if src == dst {
+		return d.Get(src).Copy(srcKey, dstKey, replace)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	d.crossMu.RLock()
+	defer d.crossMu.RUnlock()
+	unlock := d.lockPair(src, dst)
+	defer unlock()
+
+	from, to := d.dbs[src], d.dbs[dst]
+	entry := from.data.get(srcKey)
+	if entry == nil {
+		return false
+	}
+	if !replace && to.data.get(dstKey) != nil {
+		return false
+	}
+	to.put(dstKey, entry.clone())
+	return true
+}
+
+// parseDBIndex parses a database index argument and checks its range
+// Returns a non-empty error reply on failure
+func parseDBIndex(arg string) (int, string) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, formatError("ERR value is not an integer or out of range")
+	}
+	if index < 0 || index >= databases.Count() {
+		return 0, formatError("ERR DB index is out of range")
+	}
+	return index, ""
+}
+
+// SELECT index
+func selectCommand(c *Client, args []string) string {
+	index, errReply := parseDBIndex(args[1])
+	if errReply != "" {
+		return errReply
+	}
+	c.dbIndex = index
+	return formatSimpleString("OK")
+}
+
+// SWAPDB index1 index2
+func swapdbCommand(c *Client, args []string) string {
+	i, err1 := strconv.Atoi(args[1])
+	j, err2 := strconv.Atoi(args[2])
+	if err1 != nil {
+		return formatError("ERR invalid first DB index")
+	}
+	if err2 != nil {
+		return formatError("ERR invalid second DB index")
+	}
+	if !databases.Swap(i, j) {
+		return formatError("ERR DB index is out of range")
+	}
+	return formatSimpleString("OK")
+}
+
+// MOVE key db
+func moveCommand(c *Client, args []string) string {
+	dst, errReply := parseDBIndex(args[2])
+	if errReply != "" {
+		return errReply
+	}
+	if dst == c.dbIndex {
+		return formatError("ERR source and destination objects are the same")
+	}
+	if databases.Move(args[1], c.dbIndex, dst) {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDatabases(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(4)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+
+	// Test 1: Databases are independent
+	client.execute([]string{"SET", "k", "db0"})
+	if reply := client.execute([]string{"SELECT", "1"}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := client.execute([]string{"GET", "k"}); reply != "$-1\r\n" {
+		t.Fatalf("expected k to be missing in db1, got %q", reply)
+	}
+	client.execute([]string{"SET", "k", "db1"})
+
+	// Test 2: Out of range and invalid indexes
+	if reply := client.execute([]string{"SELECT", "4"}); reply != "-ERR DB index is out of range\r\n" {
+		t.Fatalf("expected out of range error, got %q", reply)
+	}
+	if reply := client.execute([]string{"SELECT", "x"}); reply != "-ERR value is not an integer or out of range\r\n" {
+		t.Fatalf("expected integer error, got %q", reply)
+	}
+
+	// Test 3: SWAPDB is visible to clients already using the database
+	if reply := client.execute([]string{"SWAPDB", "0", "1"}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := client.execute([]string{"GET", "k"}); reply != "$3\r\ndb0\r\n" {
+		t.Fatalf("expected db0's value after swap, got %q", reply)
+	}
+
+	// Test 4: MOVE refuses to overwrite and moves otherwise
+	if reply := client.execute([]string{"MOVE", "k", "0"}); reply != ":0\r\n" {
+		t.Fatalf("expected MOVE onto existing key to fail, got %q", reply)
+	}
+	client.execute([]string{"SET", "moved", "v"})
+	if reply := client.execute([]string{"MOVE", "moved", "2"}); reply != ":1\r\n" {
+		t.Fatalf("expected MOVE to succeed, got %q", reply)
+	}
+	if databases.Get(1).DBSize() != 1 || databases.Get(2).DBSize() != 1 {
+		t.Fatalf("expected moved key to live only in db2")
+	}
+
+	// Test 5: COPY across databases
+	if reply := client.execute([]string{"COPY", "k", "k", "DB", "3"}); reply != ":1\r\n" {
+		t.Fatalf("expected cross-database COPY to succeed, got %q", reply)
+	}
+	if value, _, _ := databases.Get(3).Get("k"); value != "db0" {
+		t.Fatalf("expected copied value db0 in db3, got %q", value)
+	}
+
+	// Test 6: FLUSHDB only clears the selected database, FLUSHALL clears all
+	client.execute([]string{"FLUSHDB"})
+	if databases.Get(1).DBSize() != 0 || databases.Get(3).DBSize() != 1 {
+		t.Fatalf("expected FLUSHDB to clear only db1")
+	}
+	client.execute([]string{"FLUSHALL"})
+	for i, db := range databases.All() {
+		if db.DBSize() != 0 {
+			t.Fatalf("expected db%d to be empty after FLUSHALL", i)
+		}
+	}
+
+	// Test 7: Blocked clients stay on the index they selected across SWAPDB:
+	// a list the swap brings in serves them, and so does a push to that index
+	blocked, pusher := &Client{}, &Client{}
+	done := make(chan string)
+	go func() { done <- blocked.execute([]string{"BLPOP", "q", "0"}) }()
+	for blockedClients.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	pusher.execute([]string{"SELECT", "1"})
+	pusher.execute([]string{"RPUSH", "q", "swapped"})
+	if reply := pusher.execute([]string{"SWAPDB", "0", "1"}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := <-done; reply != "*2\r\n$1\r\nq\r\n$7\r\nswapped\r\n" {
+		t.Fatalf("expected the list swapped into db0 to serve BLPOP, got %q", reply)
+	}
+	go func() { done <- blocked.execute([]string{"BLPOP", "q", "0"}) }()
+	for blockedClients.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.execute([]string{"SWAPDB", "0", "1"})
+	pusher.execute([]string{"SELECT", "0"})
+	pusher.execute([]string{"RPUSH", "q", "pushed"})
+	if reply := <-done; reply != "*2\r\n$1\r\nq\r\n$6\r\npushed\r\n" {
+		t.Fatalf("expected a push to db0 to serve BLPOP after the swap, got %q", reply)
+	}
+
+	// Test 8: Default TTLs stay with their database index across SWAPDB
+	databases.Get(0).SetDefaultTTLs(time.Hour, nil)
+	defer databases.Get(1).SetDefaultTTLs(0, nil)
+	defer databases.Get(0).SetDefaultTTLs(0, nil)
+	client.execute([]string{"SWAPDB", "0", "1"})
+	client.execute([]string{"SELECT", "0"})
+	client.execute([]string{"SET", "ttl0", "v"})
+	if reply := client.execute([]string{"TTL", "ttl0"}); reply != ":3600\r\n" {
+		t.Fatalf("expected db0's default TTL after the swap, got %q", reply)
+	}
+	client.execute([]string{"SELECT", "1"})
+	client.execute([]string{"SET", "ttl1", "v"})
+	if reply := client.execute([]string{"TTL", "ttl1"}); reply != ":-1\r\n" {
+		t.Fatalf("expected no default TTL in db1 after the swap, got %q", reply)
+	}
+}
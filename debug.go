@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faultRule describes the misbehaviour injected for one command
+type faultRule struct {
+	Latency        time.Duration // Delay added before the command runs
+	LatencyProb    float64       // Probability of adding the delay
+	ErrorProb      float64       // Probability of replying with ErrorMessage instead of running
+	ErrorMessage   string        // Error reply sent when an error is injected
+	DisconnectProb float64       // Probability of dropping the connection instead of replying
+}
+
+// faultInjector holds the fault rules installed with DEBUG INJECT
+// Rules are keyed by lowercase command name; "*" applies to every command
+type faultInjector struct {
+	mu    sync.RWMutex
+	rules map[string]*faultRule
+}
+
+// Global fault injector instance
+var faults = &faultInjector{rules: make(map[string]*faultRule)}
+
+// rule returns the rule for a command, creating it if needed
+// Callers must hold the write lock
+func (f *faultInjector) rule(name string) *faultRule {
+	r, ok := f.rules[name]
+	if !ok {
+		r = &faultRule{ErrorMessage: "ERR injected fault"}
+		f.rules[name] = r
+	}
+	return r
+}
+
+// inject applies the faults configured for a command before it runs
+// Returns a non-empty error reply if one was injected, and whether the
+// connection should be dropped instead
+func (f *faultInjector) inject(name string) (string, bool) {
+	f.mu.RLock()
+	if len(f.rules) == 0 {
+		f.mu.RUnlock()
+		return "", false
+	}
+	var rules []faultRule
+	for _, key := range []string{"*", name} {
+		if r, ok := f.rules[key]; ok {
+			rules = append(rules, *r)
+		}
+	}
+	f.mu.RUnlock()
+
+	for _, r := range rules {
+		if r.LatencyProb > 0 && rand.Float64() < r.LatencyProb {
+			time.Sleep(r.Latency)
+		}
+		if r.DisconnectProb > 0 && rand.Float64() < r.DisconnectProb {
+			return "", true
+		}
+		if r.ErrorProb > 0 && rand.Float64() < r.ErrorProb {
+			return formatError(r.ErrorMessage), false
+		}
+	}
+	return "", false
+}
+
+// DEBUG subcommand [arguments ...]
+func debugCommand(c *Client, args []string) string {
+	if !config.EnableDebugCommand {
+		return formatError("ERR DEBUG command not allowed. Set the enable-debug-command option to \"yes\" in the configuration file and restart the server.")
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "INJECT":
+		return debugInject(args[2:])
+	case "CLOCK":
+		return debugClock(args[2:])
+	default:
+		return formatError(fmt.Sprintf("ERR unknown subcommand '%s'. Try DEBUG INJECT or DEBUG CLOCK.", args[1]))
+	}
+}
+
+// debugInject implements the DEBUG INJECT family:
+//
+//	DEBUG INJECT <command|*> LATENCY <milliseconds> <probability>
+//	DEBUG INJECT <command|*> ERROR <probability> [message]
+//	DEBUG INJECT <command|*> DISCONNECT <probability>
+//	DEBUG INJECT <command|*> CLEAR
+//	DEBUG INJECT LIST
+//	DEBUG INJECT RESET
+func debugInject(args []string) string {
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0]) {
+		case "LIST":
+			return faults.list()
+		case "RESET":
+			faults.mu.Lock()
+			faults.rules = make(map[string]*faultRule)
+			faults.mu.Unlock()
+			return formatSimpleString("OK")
+		}
+	}
+	if len(args) < 2 {
+		return formatError("ERR wrong number of arguments for 'debug|inject' command")
+	}
+
+	name := strings.ToLower(args[0])
+	if name != "*" && lookupCommand(name) == nil {
+		return formatError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	switch strings.ToUpper(args[1]) {
+	case "LATENCY":
+		if len(args) != 4 {
+			return formatError("ERR syntax error")
+		}
+		ms, err := strconv.Atoi(args[2])
+		if err != nil || ms < 0 {
+			return formatError("ERR latency must be a non-negative integer number of milliseconds")
+		}
+		prob, ok := parseProbability(args[3])
+		if !ok {
+			return formatError("ERR probability must be a number between 0 and 1")
+		}
+		r := faults.rule(name)
+		r.Latency = time.Duration(ms) * time.Millisecond
+		r.LatencyProb = prob
+
+	case "ERROR":
+		if len(args) != 3 && len(args) != 4 {
+			return formatError("ERR syntax error")
+		}
+		prob, ok := parseProbability(args[2])
+		if !ok {
+			return formatError("ERR probability must be a number between 0 and 1")
+		}
+		r := faults.rule(name)
+		r.ErrorProb = prob
+		if len(args) == 4 {
+			r.ErrorMessage = args[3]
+		}
+
+	case "DISCONNECT":
+		if len(args) != 3 {
+			return formatError("ERR syntax error")
+		}
+		prob, ok := parseProbability(args[2])
+		if !ok {
+			return formatError("ERR probability must be a number between 0 and 1")
+		}
+		faults.rule(name).DisconnectProb = prob
+
+	case "CLEAR":
+		if len(args) != 2 {
+			return formatError("ERR syntax error")
+		}
+		delete(faults.rules, name)
+
+	default:
+		return formatError("ERR syntax error")
+	}
+	return formatSimpleString("OK")
+}
+
+// list describes every installed rule, one line per command
+func (f *faultInjector) list() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	names := make([]string, 0, len(f.rules))
+	for name := range f.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		r := f.rules[name]
+		lines = append(lines, fmt.Sprintf("%s latency=%dms:%g error=%g:%q disconnect=%g",
+			name, r.Latency.Milliseconds(), r.LatencyProb, r.ErrorProb, r.ErrorMessage, r.DisconnectProb))
+	}
+	return formatArray(lines)
+}
+
+// parseProbability parses a probability in [0, 1]
+func parseProbability(arg string) (float64, bool) {
+	prob, err := strconv.ParseFloat(arg, 64)
+	if err != nil || prob < 0 || prob > 1 {
+		return 0, false
+	}
+	return prob, true
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestDebugInject(t *testing.T) {
+	client := &Client{}
+
+	// Test 1: DEBUG is refused unless enabled
+	reply := client.execute([]string{"DEBUG", "INJECT", "LIST"})
+	if reply[0] != '-' {
+		t.Fatalf("expected DEBUG to be refused by default, got %q", reply)
+	}
+
+	config.EnableDebugCommand = true
+	defer func() {
+		config.EnableDebugCommand = false
+		client.execute([]string{"DEBUG", "INJECT", "RESET"})
+	}()
+
+	// Test 2: Injected errors replace the command's reply
+	reply = client.execute([]string{"DEBUG", "INJECT", "ping", "ERROR", "1", "ERR chaos"})
+	if reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	reply = client.execute([]string{"PING"})
+	if reply != "-ERR chaos\r\n" {
+		t.Fatalf("expected injected error, got %q", reply)
+	}
+	reply = client.execute([]string{"ECHO", "hi"})
+	if reply != "$2\r\nhi\r\n" {
+		t.Fatalf("expected other commands to be unaffected, got %q", reply)
+	}
+
+	// Test 3: Disconnects close the client without a reply
+	client.execute([]string{"DEBUG", "INJECT", "*", "DISCONNECT", "1"})
+	reply = client.execute([]string{"ECHO", "hi"})
+	if reply != "" || !client.closing {
+		t.Fatalf("expected disconnect, got reply %q closing=%v", reply, client.closing)
+	}
+
+	// Test 4: RESET removes every rule, invalid arguments are rejected
+	client.execute([]string{"DEBUG", "INJECT", "RESET"})
+	client.closing = false
+	reply = client.execute([]string{"PING"})
+	if reply != "+PONG\r\n" {
+		t.Fatalf("expected +PONG after reset, got %q", reply)
+	}
+	reply = client.execute([]string{"DEBUG", "INJECT", "ping", "ERROR", "2"})
+	if reply[0] != '-' {
+		t.Fatalf("expected error for probability above 1, got %q", reply)
+	}
+	reply = client.execute([]string{"DEBUG", "INJECT", "nosuch", "ERROR", "1"})
+	if reply[0] != '-' {
+		t.Fatalf("expected error for unknown command, got %q", reply)
+	}
+}
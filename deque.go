@@ -0,0 +1,201 @@
+package main
+
+// deque is a double-ended queue of strings stored in a ring buffer, giving
+// O(1) pushes and pops at both ends and O(1) access by index
+type deque struct {
+	buf   []string // Ring buffer; its length is zero or a power of two
+	head  int      // Index in buf of the first element
+	size  int      // Number of elements
+	bytes int      // Total length of the elements
+}
+
+// dequeMinCapacity is the ring buffer size allocated on first push
+const dequeMinCapacity = 8
+
+// newDeque creates a deque holding values in order
+func newDeque(values ...string) *deque {
+	d := &deque{}
+	for _, v := range values {
+		d.pushBack(v)
+	}
+	return d
+}
+
+func (d *deque) len() int {
+	return d.size
+}
+
+// slot maps a logical index to its position in the ring buffer
+func (d *deque) slot(i int) int {
+	return (d.head + i) & (len(d.buf) - 1)
+}
+
+// resize moves the elements into a ring buffer of the given capacity
+func (d *deque) resize(capacity int) {
+	buf := make([]string, capacity)
+	for i := 0; i < d.size; i++ {
+		buf[i] = d.buf[d.slot(i)]
+	}
+	d.buf = buf
+	d.head = 0
+}
+
+func (d *deque) grow() {
+	if d.size < len(d.buf) {
+		return
+	}
+	capacity := len(d.buf) * 2
+	if capacity == 0 {
+		capacity = dequeMinCapacity
+	}
+	d.resize(capacity)
+}
+
+// shrink halves the buffer once it is at most a quarter full
+func (d *deque) shrink() {
+	if len(d.buf) > dequeMinCapacity && d.size <= len(d.buf)/4 {
+		d.resize(len(d.buf) / 2)
+	}
+}
+
+func (d *deque) pushFront(v string) {
+	d.grow()
+	d.head = (d.head - 1) & (len(d.buf) - 1)
+	d.buf[d.head] = v
+	d.size++
+	d.bytes += len(v)
+}
+
+func (d *deque) pushBack(v string) {
+	d.grow()
+	d.buf[d.slot(d.size)] = v
+	d.size++
+	d.bytes += len(v)
+}
+
+// popFront removes and returns the first element; the deque must not be empty
+func (d *deque) popFront() string {
+	v := d.buf[d.head]
+	d.buf[d.head] = ""
+	d.head = d.slot(1)
+	d.size--
+	d.bytes -= len(v)
+	d.shrink()
+	return v
+}
+
+// popBack removes and returns the last element; the deque must not be empty
+func (d *deque) popBack() string {
+	i := d.slot(d.size - 1)
+	v := d.buf[i]
+	d.buf[i] = ""
+	d.size--
+	d.bytes -= len(v)
+	d.shrink()
+	return v
+}
+
+// at returns the element at index i, which must be in range
+func (d *deque) at(i int) string {
+	return d.buf[d.slot(i)]
+}
+
+// set replaces the element at index i, which must be in range
+func (d *deque) set(i int, v string) {
+	slot := d.slot(i)
+	d.bytes += len(v) - len(d.buf[slot])
+	d.buf[slot] = v
+}
+
+// insert places v at index i (0 <= i <= len), shifting the elements on the
+// shorter side of i by one position
+func (d *deque) insert(i int, v string) {
+	switch {
+	case i == 0:
+		d.pushFront(v)
+	case i == d.size:
+		d.pushBack(v)
+	case i < d.size/2:
+		d.pushFront(d.at(0))
+		for j := 1; j < i; j++ {
+			d.set(j, d.at(j+1))
+		}
+		d.set(i, v)
+	default:
+		d.pushBack(d.at(d.size - 1))
+		for j := d.size - 2; j > i; j-- {
+			d.set(j, d.at(j-1))
+		}
+		d.set(i, v)
+	}
+}
+
+// indexOf returns the index of the first element equal to v, or -1
+func (d *deque) indexOf(v string) int {
+	for i := 0; i < d.size; i++ {
+		if d.at(i) == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// each calls fn with every element and its index, from the tail if reverse
+// is set, until fn returns false
+func (d *deque) each(reverse bool, fn func(i int, v string) bool) {
+	for n := 0; n < d.size; n++ {
+		i := n
+		if reverse {
+			i = d.size - 1 - n
+		}
+		if !fn(i, d.at(i)) {
+			return
+		}
+	}
+}
+
+// retain keeps only the elements whose index satisfies keep, preserving order
+func (d *deque) retain(keep func(i int) bool) {
+	kept := 0
+	for i := 0; i < d.size; i++ {
+		if keep(i) {
+			d.set(kept, d.at(i))
+			kept++
+		}
+	}
+	for i := kept; i < d.size; i++ {
+		d.set(i, "")
+	}
+	d.size = kept
+	for len(d.buf) > dequeMinCapacity && d.size <= len(d.buf)/4 {
+		d.shrink()
+	}
+}
+
+// slice returns a copy of the elements from start to stop inclusive,
+// which must be in range
+func (d *deque) slice(start, stop int) []string {
+	if start > stop {
+		return []string{}
+	}
+	result := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		result = append(result, d.at(i))
+	}
+	return result
+}
+
+// values returns a copy of every element in order
+func (d *deque) values() []string {
+	return d.slice(0, d.size-1)
+}
+
+// clone returns an independent copy of the deque
+func (d *deque) clone() *deque {
+	return &deque{buf: append([]string(nil), d.buf...), head: d.head, size: d.size, bytes: d.bytes}
+}
+
+// clear removes every element and drops the buffer
+func (d *deque) clear() {
+	d.buf, d.head, d.size, d.bytes = nil, 0, 0, 0
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dump serializes the value stored at key in the Redis DUMP payload format:
+// the RDB-encoded value, a 2-byte RDB version and a CRC64 of everything before it
+// Returns (payload, exists, error)
+func (s *Store) Dump(key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.get(key)
+	if entry == nil {
+		return "", false, nil
+	}
+	payload, err := dumpPayload(entry)
+	return payload, true, err
+}
+
+// dumpPayload encodes an entry as a DUMP payload
+func dumpPayload(entry *Entry) (string, error) {
+	enc := &rdbEncoder{}
+	if err := enc.writeValue(entry); err != nil {
+		return "", err
+	}
+	return string(appendDumpFooter(enc.buf)), nil
+}
+
+// appendDumpFooter appends the RDB version and the checksum ending a DUMP
+// payload to its body
+func appendDumpFooter(buf []byte) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, rdbVersion)
+	return binary.LittleEndian.AppendUint64(buf, crc64Jones(0, buf))
+}
+
+// dumpPayloadBody verifies the footer of a DUMP payload
+// Returns the body before it, or false if the footer is wrong
+func dumpPayloadBody(payload string) ([]byte, bool) {
+	data := []byte(payload)
+	if len(data) < 10 {
+		return nil, false
+	}
+	footer := len(data) - 10
+	version := binary.LittleEndian.Uint16(data[footer:])
+	checksum := binary.LittleEndian.Uint64(data[footer+2:])
+	if version > rdbMaxVersion || crc64Jones(0, data[:footer+2]) != checksum {
+		return nil, false
+	}
+	return data[:footer], true
+}
+
+// decodeDumpPayload verifies the footer of a DUMP payload and decodes its value
+// Returns a non-empty error reply on failure
+func decodeDumpPayload(payload string) (*Entry, string) {
+	body, ok := dumpPayloadBody(payload)
+	if !ok {
+		return nil, formatError("ERR DUMP payload version or checksum are wrong")
+	}
+
+	reader := bytes.NewReader(body)
+	dec := newRDBDecoder(reader)
+	rdbType, err := dec.readByte()
+	if err != nil {
+		return nil, formatError("ERR Bad data format")
+	}
+	entry, err := dec.readValue(rdbType)
+	if err != nil {
+		return nil, formatError("ERR Bad data format")
+	}
+	// The value must account for the whole payload
+	if _, err := dec.readByte(); err == nil {
+		return nil, formatError("ERR Bad data format")
+	}
+	return entry, ""
+}
+
+// Restore stores a deserialized entry under key
+// Returns false if the key exists and replace is false
+func (s *Store) Restore(key string, entry *Entry, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !replace && s.data.get(key) != nil {
+		return false
+	}
+	// An already expired TTL leaves the key absent, as in Redis
+	if entry.expired(clockNow()) {
+		if s.data.delete(key) {
+			s.keyModified(key)
+		}
+		return true
+	}
+	s.compact(entry)
+	s.put(key, entry)
+	return true
+}
+
+// DUMP key
+func dumpCommand(c *Client, args []string) string {
+	payload, exists, err := c.db().Dump(args[1])
+	if err != nil {
+		return formatError("ERR " + err.Error())
+	}
+	if !exists {
+		return formatNullBulkString()
+	}
+	return formatBulkString(payload)
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+//
+// With ABSTTL, ttl is the Unix time in milliseconds the key expires at, as
+// read from the source with PEXPIRETIME, so moving a key does not stretch its
+// TTL by the time the move took. IDLETIME and FREQ carry the LRU idle time or
+// LFU counter of the source key over.
+func restoreCommand(c *Client, args []string) string {
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return formatError("ERR Invalid TTL value, must be >= 0")
+	}
+
+	replace, absTTL := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME":
+			if i+1 == len(args) || freq != -1 {
+				return formatError("ERR syntax error")
+			}
+			i++
+			idle, err = strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			if idle < 0 {
+				return formatError("ERR Invalid IDLETIME value, must be >= 0")
+			}
+		case "FREQ":
+			if i+1 == len(args) || idle != -1 {
+				return formatError("ERR syntax error")
+			}
+			i++
+			freq, err = strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			if freq < 0 || freq > 255 {
+				return formatError("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	entry, errReply := decodeDumpPayload(args[3])
+	if errReply != "" {
+		return errReply
+	}
+	if ttl > 0 {
+		if absTTL {
+			entry.ExpiresAt = time.UnixMilli(ttl)
+		} else {
+			entry.ExpiresAt = clockNow().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+	// Preset access metadata is kept when the entry is stored
+	now := clockNow()
+	switch {
+	case idle >= 0:
+		entry.lastAccess.Store(now.Add(-time.Duration(idle) * time.Second).UnixNano())
+		entry.frequency.Store(lfuInitValue)
+	case freq >= 0:
+		entry.lastAccess.Store(now.UnixNano())
+		entry.frequency.Store(uint32(freq))
+	}
+
+	if !c.db().Restore(args[1], entry, replace) {
+		return formatError("BUSYKEY Target key name already exists.")
+	}
+	if ttl > 0 && !absTTL {
+		// Replayed with the expiration time rather than what remained of it
+		rewritten := append(slices.Clone(args), "ABSTTL")
+		rewritten[2] = strconv.FormatInt(entry.ExpiresAt.UnixMilli(), 10)
+		c.rewriteAOF(rewritten)
+	}
+	return formatSimpleString("OK")
+}
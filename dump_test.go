@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	for _, value := range []string{"", "hello", "10", "-129", "40000", "3000000000", "007", string([]byte{0, 1, 2, 255})} {
+		entry, errReply := decodeDumpPayload(mustDump(t, &Entry{Type: TypeString, Value: value}))
+		if errReply != "" {
+			t.Fatalf("expected %q to round-trip, got %q", value, errReply)
+		}
+		if entry.Type != TypeString || entry.Value.(string) != value {
+			t.Fatalf("expected string %q, got %v %q", value, entry.Type, entry.Value)
+		}
+	}
+}
+
+func TestDumpRestoreCollections(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "list", "a", "1", "b")
+	run("SADD", "ints", "3", "1", "2")
+	run("SADD", "words", "x", "y")
+	run("HSET", "hash", "f", "v", "n", "10")
+	run("ZADD", "zset", "1.5", "a", "-inf", "b", "3", "c")
+
+	// Test 1: Every collection type round-trips through DUMP and RESTORE
+	reads := map[string][]string{
+		"list":  {"LRANGE", "0", "-1"},
+		"ints":  {"SMEMBERS"},
+		"words": {"SCARD"},
+		"hash":  {"HGET", "n"},
+		"zset":  {"ZSCAN", "0"},
+	}
+	for key, read := range reads {
+		payload, _, err := databases.Get(0).Dump(key)
+		if err != nil {
+			t.Fatalf("expected %s to dump, got %v", key, err)
+		}
+		if reply := run("RESTORE", key+":copy", "0", payload); reply != "+OK\r\n" {
+			t.Fatalf("expected %s to restore, got %q", key, reply)
+		}
+		original := client.execute(append([]string{read[0], key}, read[1:]...))
+		copied := client.execute(append([]string{read[0], key + ":copy"}, read[1:]...))
+		if original != copied {
+			t.Fatalf("expected %s to read back as %q, got %q", key, original, copied)
+		}
+	}
+
+	// Test 2: Restored values get the compact encodings
+	for key, want := range map[string]string{"list:copy": "listpack", "ints:copy": "intset", "hash:copy": "listpack"} {
+		if reply := run("OBJECT", "ENCODING", key); reply != formatBulkString(want) {
+			t.Fatalf("expected %s to be a %s, got %q", key, want, reply)
+		}
+	}
+
+	// Test 3: Empty collections and duplicate members are rejected
+	for _, value := range []string{"\x01\x00", "\x02\x02\x01a\x01a", "\x04\x01\x01f"} {
+		enc := &rdbEncoder{buf: []byte(value)}
+		enc.buf = binary.LittleEndian.AppendUint16(enc.buf, rdbVersion)
+		enc.buf = binary.LittleEndian.AppendUint64(enc.buf, crc64Jones(0, enc.buf))
+		if _, errReply := decodeDumpPayload(string(enc.buf)); errReply == "" {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestRestoreRedisPayload(t *testing.T) {
+	// Produced by Redis 7.0 for "SET mykey 10" followed by "DUMP mykey"
+	payload := "\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"
+	entry, errReply := decodeDumpPayload(payload)
+	if errReply != "" {
+		t.Fatalf("expected Redis payload to decode, got %q", errReply)
+	}
+	if entry.Value.(string) != "10" {
+		t.Fatalf("expected 10, got %q", entry.Value)
+	}
+
+	// Our own encoding of the same value only differs in the version
+	dumped := mustDump(t, &Entry{Type: TypeString, Value: "10"})
+	if dumped[:3] != payload[:3] {
+		t.Fatalf("expected value encoding %q, got %q", payload[:3], dumped[:3])
+	}
+
+	// A corrupted payload is rejected
+	corrupted := []byte(payload)
+	corrupted[2] = 11
+	if _, errReply := decodeDumpPayload(string(corrupted)); errReply == "" {
+		t.Fatalf("expected checksum error for corrupted payload")
+	}
+}
+
+func TestRestoreCommand(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	client := &Client{}
+
+	client.execute([]string{"SET", "src", "value"})
+	dumped := client.execute([]string{"DUMP", "src"})
+	payload := mustDump(t, &Entry{Type: TypeString, Value: "value"})
+	if dumped != formatBulkString(payload) {
+		t.Fatalf("unexpected DUMP reply %q", dumped)
+	}
+
+	// Test 1: Restore into a new key, then BUSYKEY without REPLACE
+	if reply := client.execute([]string{"RESTORE", "dst", "0", payload}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := client.execute([]string{"RESTORE", "dst", "0", payload}); reply != "-BUSYKEY Target key name already exists.\r\n" {
+		t.Fatalf("expected BUSYKEY, got %q", reply)
+	}
+	if reply := client.execute([]string{"RESTORE", "dst", "0", payload, "REPLACE"}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK with REPLACE, got %q", reply)
+	}
+
+	// Test 2: TTLs are applied, and already expired absolute TTLs create nothing
+	client.execute([]string{"RESTORE", "withttl", "60000", payload})
+	db := client.db()
+	db.mu.RLock()
+	expiresAt := db.data.get("withttl").ExpiresAt
+	db.mu.RUnlock()
+	if expiresAt.IsZero() {
+		t.Fatalf("expected restored key to carry a TTL")
+	}
+	if reply := client.execute([]string{"RESTORE", "expired", "1000", payload, "ABSTTL"}); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK for expired ABSTTL, got %q", reply)
+	}
+	if _, exists, _ := db.Get("expired"); exists {
+		t.Fatalf("expected already-expired key not to be created")
+	}
+
+	// Test 3: DUMP of a missing key is nil
+	if reply := client.execute([]string{"DUMP", "nosuch"}); reply != "$-1\r\n" {
+		t.Fatalf("expected nil, got %q", reply)
+	}
+}
+
+func TestKeyMovesKeepAbsoluteTTL(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	SetClock(vc)
+	defer SetClock(nil)
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	db := databases.Get(0)
+	expiresAt := start.Add(time.Minute)
+	db.Restore("src", &Entry{Type: TypeString, Value: "v", ExpiresAt: expiresAt}, false)
+
+	// Test 1: EXPIRETIME and PEXPIRETIME report the absolute expiration
+	if reply := run("PEXPIRETIME", "src"); reply != formatInteger(int(expiresAt.UnixMilli())) {
+		t.Fatalf("unexpected PEXPIRETIME %q", reply)
+	}
+	if reply := run("EXPIRETIME", "src"); reply != formatInteger(int(expiresAt.Unix())) {
+		t.Fatalf("unexpected EXPIRETIME %q", reply)
+	}
+	run("SET", "plain", "v")
+	if run("PEXPIRETIME", "plain") != ":-1\r\n" || run("EXPIRETIME", "nosuch") != ":-2\r\n" {
+		t.Fatalf("expected -1 without a TTL and -2 for a missing key")
+	}
+
+	// Test 2: DUMP + RESTORE ABSTTL keeps the deadline however long the move takes
+	payload := run("DUMP", "src")
+	payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+	deadline := strings.TrimSuffix(strings.TrimPrefix(run("PEXPIRETIME", "src"), ":"), "\r\n")
+	vc.Advance(5 * time.Second)
+	if reply := run("RESTORE", "dst", deadline, payload, "ABSTTL"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if at, _, _ := db.ExpireTime("dst"); !at.Equal(expiresAt) {
+		t.Fatalf("expected the restored key to expire at %v, got %v", expiresAt, at)
+	}
+
+	// Test 3: COPY and MOVE carry the same expiration time
+	run("COPY", "src", "copied", "DB", "1")
+	run("MOVE", "dst", "1")
+	for _, key := range []string{"copied", "dst"} {
+		if at, _, _ := databases.Get(1).ExpireTime(key); !at.Equal(expiresAt) {
+			t.Fatalf("expected %s to expire at %v, got %v", key, expiresAt, at)
+		}
+	}
+
+	// Test 4: IDLETIME and FREQ carry access metadata over
+	run("RESTORE", "idle", "0", payload, "IDLETIME", "3600")
+	if _, idle, _, _ := db.ObjectInfo("idle"); idle < time.Hour {
+		t.Fatalf("expected an hour of idle time, got %v", idle)
+	}
+	run("RESTORE", "hot", "0", payload, "FREQ", "200")
+	if _, _, freq, _ := db.ObjectInfo("hot"); freq != 200 {
+		t.Fatalf("expected LFU counter 200, got %d", freq)
+	}
+	for args, want := range map[string]string{
+		"IDLETIME -1":       "Invalid IDLETIME",
+		"FREQ 256":          "Invalid FREQ",
+		"IDLETIME 1 FREQ 1": "syntax error",
+		"FREQ x":            "not an integer",
+	} {
+		full := append([]string{"RESTORE", "bad", "0", payload}, strings.Fields(args)...)
+		if reply := run(full...); !strings.Contains(reply, want) {
+			t.Fatalf("expected %q for %s, got %q", want, args, reply)
+		}
+	}
+	if _, exists, _ := db.Get("bad"); exists {
+		t.Fatalf("expected invalid options to store nothing")
+	}
+}
+
+func mustDump(t *testing.T, entry *Entry) string {
+	t.Helper()
+	payload, err := dumpPayload(entry)
+	if err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	return payload
+}
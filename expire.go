@@ -0,0 +1,315 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Active expiration parameters, following Redis's expire cycle
+const (
+	activeExpireInterval  = 100 * time.Millisecond // How often each database is sampled
+	activeExpireSample    = 20                     // Keys with a TTL checked per round
+	activeExpireThreshold = 4                      // Keep going while more than 1/4 of a sample expired
+	activeExpireBudget    = 25 * time.Millisecond  // Maximum time spent per database per cycle
+)
+
+// expired reports whether the entry has an expiration time that has passed
+func (e *Entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// prefixTTL is a default TTL for keys starting with a prefix
+type prefixTTL struct {
+	prefix string
+	ttl    time.Duration
+}
+
+// ttlPolicy assigns a default TTL to keys stored without one
+type ttlPolicy struct {
+	dbDefault time.Duration // TTL for keys matching no prefix (0 means none)
+	prefixes  []prefixTTL   // Sorted longest prefix first
+}
+
+// defaultTTL returns the TTL the policy assigns to key, or 0 for none
+func (p *ttlPolicy) defaultTTL(key string) time.Duration {
+	if p == nil {
+		return 0
+	}
+	for _, rule := range p.prefixes {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule.ttl
+		}
+	}
+	return p.dbDefault
+}
+
+// SetDefaultTTLs installs the default TTL policy of the store: keys stored
+// without an expiration get the TTL of their longest matching prefix, or the
+// database-wide default if no prefix matches
+func (s *Store) SetDefaultTTLs(dbDefault time.Duration, prefixes map[string]time.Duration) {
+	policy := &ttlPolicy{dbDefault: dbDefault}
+	for prefix, ttl := range prefixes {
+		policy.prefixes = append(policy.prefixes, prefixTTL{prefix: prefix, ttl: ttl})
+	}
+	sort.Slice(policy.prefixes, func(i, j int) bool {
+		return len(policy.prefixes[i].prefix) > len(policy.prefixes[j].prefix)
+	})
+	if dbDefault == 0 && len(prefixes) == 0 {
+		policy = nil
+	}
+
+	s.mu.Lock()
+	s.ttlPolicy = policy
+	s.mu.Unlock()
+}
+
+// defaultExpire is an expiration time the default TTL policy gave a key
+type defaultExpire struct {
+	db  *Store
+	key string
+	at  time.Time
+}
+
+// defaultExpireLog collects the expiration times the default TTL policy gives
+// while appendonly is on, until the command that stored the keys is appended
+type defaultExpireLog struct {
+	mu      sync.Mutex
+	expires []defaultExpire
+}
+
+var defaultExpires defaultExpireLog
+
+func (l *defaultExpireLog) add(db *Store, key string, at time.Time) {
+	l.mu.Lock()
+	l.expires = append(l.expires, defaultExpire{db: db, key: key, at: at})
+	l.mu.Unlock()
+}
+
+// take empties the log
+// Returns the expiration times collected, with the index of their database
+func (l *defaultExpireLog) take() ([]defaultExpire, []int) {
+	l.mu.Lock()
+	expires := l.expires
+	l.expires = nil
+	l.mu.Unlock()
+	if len(expires) == 0 {
+		return nil, nil
+	}
+	// Looked up now rather than by put, which holds a store lock that SWAPDB
+	// takes after the databases lock
+	dbs := databases.All()
+	indexes := make([]int, len(expires))
+	for i, e := range expires {
+		indexes[i] = slices.Index(dbs, e.db)
+	}
+	return expires, indexes
+}
+
+// put stores an entry under key, applying the default TTL policy to entries
+// without an expiration. Every write path that replaces a whole entry goes
+// through here. Callers must hold the write lock.
+func (s *Store) put(key string, entry *Entry) {
+	if entry.ExpiresAt.IsZero() {
+		if ttl := s.ttlPolicy.defaultTTL(key); ttl > 0 {
+			entry.ExpiresAt = clockNow().Add(ttl)
+			if aof.Load() != nil {
+				defaultExpires.add(s, key, entry.ExpiresAt)
+			}
+		}
+	}
+	s.data.set(key, entry)
+	s.keyModified(key)
+	s.armExpireTimer(key, entry)
+}
+
+// ExpireCondition restricts when PExpireAt changes an expiration time
+type ExpireCondition struct {
+	NX bool // Only without an expiration time
+	XX bool // Only with one
+	GT bool // Only to a later time; keys without one never qualify
+	LT bool // Only to an earlier time; keys without one always do
+}
+
+// PExpireAt sets the expiration time of key to at, if cond allows it; a
+// time already past deletes the key
+// Returns whether the key exists and cond allowed it
+func (s *Store) PExpireAt(key string, at time.Time, cond ExpireCondition) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.data.get(key)
+	if entry == nil {
+		return false
+	}
+	current := entry.ExpiresAt
+	switch {
+	case cond.NX && !current.IsZero(),
+		cond.XX && current.IsZero(),
+		cond.GT && (current.IsZero() || !at.After(current)),
+		cond.LT && !current.IsZero() && !at.Before(current):
+		return false
+	}
+	if !at.After(clockNow()) {
+		s.data.delete(key)
+		s.keyModified(key)
+		s.bury(key, entry)
+		return true
+	}
+	s.data.expire(key, entry, at)
+	s.keyModified(key)
+	s.armExpireTimer(key, entry)
+	return true
+}
+
+// TTL returns the remaining time to live of key
+// Returns (ttl, exists, hasTTL)
+func (s *Store) TTL(key string) (time.Duration, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.peek(key)
+	if entry == nil {
+		return 0, false, false
+	}
+	if entry.ExpiresAt.IsZero() {
+		return 0, true, false
+	}
+	return entry.ExpiresAt.Sub(clockNow()), true, true
+}
+
+// ExpireTime returns the absolute expiration time of key
+// Returns (expiration time, exists, hasTTL)
+func (s *Store) ExpireTime(key string) (time.Time, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.peek(key)
+	if entry == nil {
+		return time.Time{}, false, false
+	}
+	return entry.ExpiresAt, true, !entry.ExpiresAt.IsZero()
+}
+
+// ExpiresCount returns the number of keys with an expiration time
+func (s *Store) ExpiresCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.expiresCount()
+}
+
+// activeExpire removes expired keys by sampling keys that have a TTL, repeating
+// while a large fraction of each sample turns out to be expired
+func (s *Store) activeExpire() {
+	start := time.Now()
+	for time.Since(start) < activeExpireBudget {
+		s.mu.Lock()
+		checked, expired := s.data.expireSample(clockNow(), activeExpireSample)
+		for _, key := range expired {
+			s.keyModified(key)
+		}
+		s.mu.Unlock()
+		notifyExpiredKeys(s, expired)
+
+		if checked == 0 || len(expired)*activeExpireThreshold <= checked {
+			return
+		}
+	}
+}
+
+// activeExpireLoop periodically runs the active expiration cycle on every database
+func activeExpireLoop() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, db := range databases.All() {
+			db.activeExpire()
+		}
+	}
+}
+
+// ttlReply formats the TTL of key in the given unit, with -2 for missing keys
+// and -1 for keys without an expiration
+func ttlReply(db *Store, key string, unit time.Duration) string {
+	ttl, exists, hasTTL := db.TTL(key)
+	if !exists {
+		return formatInteger(-2)
+	}
+	if !hasTTL {
+		return formatInteger(-1)
+	}
+	// Round to the nearest unit, as Redis does
+	return formatInteger(int((ttl + unit/2) / unit))
+}
+
+// TTL key
+func ttlCommand(c *Client, args []string) string {
+	return ttlReply(c.db(), args[1], time.Second)
+}
+
+// PTTL key
+func pttlCommand(c *Client, args []string) string {
+	return ttlReply(c.db(), args[1], time.Millisecond)
+}
+
+// expireTimeReply formats the Unix time key expires at, in seconds or in
+// milliseconds, with -2 for missing keys and -1 for keys without an expiration
+func expireTimeReply(db *Store, key string, millis bool) string {
+	at, exists, hasTTL := db.ExpireTime(key)
+	if !exists {
+		return formatInteger(-2)
+	}
+	if !hasTTL {
+		return formatInteger(-1)
+	}
+	if millis {
+		return formatInteger(int(at.UnixMilli()))
+	}
+	return formatInteger(int(at.Unix()))
+}
+
+// EXPIRETIME key
+func expiretimeCommand(c *Client, args []string) string {
+	return expireTimeReply(c.db(), args[1], false)
+}
+
+// PEXPIRETIME key
+func pexpiretimeCommand(c *Client, args []string) string {
+	return expireTimeReply(c.db(), args[1], true)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func pexpireatCommand(c *Client, args []string) string {
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	var cond ExpireCondition
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			cond.NX = true
+		case "XX":
+			cond.XX = true
+		case "GT":
+			cond.GT = true
+		case "LT":
+			cond.LT = true
+		default:
+			return formatError("ERR Unsupported option " + arg)
+		}
+	}
+	switch {
+	case cond.NX && (cond.XX || cond.GT || cond.LT):
+		return formatError("ERR NX and XX, GT or LT options at the same time are not compatible")
+	case cond.GT && cond.LT:
+		return formatError("ERR GT and LT options at the same time are not compatible")
+	}
+	if !c.db().PExpireAt(args[1], time.UnixMilli(ms), cond) {
+		return formatInteger(0)
+	}
+	return formatInteger(1)
+}
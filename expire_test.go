@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreExpiration(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: Expired keys are invisible before they are physically removed
+	store.Restore("short", &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(20 * time.Millisecond)}, false)
+	if _, exists, _ := store.Get("short"); !exists {
+		t.Fatalf("expected key to exist before its TTL")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, exists, _ := store.Get("short"); exists {
+		t.Fatalf("expected key to be expired")
+	}
+	if store.Del("short") != 0 {
+		t.Fatalf("expected DEL of an expired key to report 0")
+	}
+
+	// Test 2: Active expiration reclaims expired keys nobody reads
+	for i := 0; i < 100; i++ {
+		store.Restore(fmt.Sprintf("k%d", i), &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(time.Millisecond)}, false)
+	}
+	time.Sleep(5 * time.Millisecond)
+	store.activeExpire()
+	if store.ExpiresCount() != 0 || store.DBSize() != 0 {
+		t.Fatalf("expected active expiration to remove every key, got %d keys", store.DBSize())
+	}
+}
+
+func TestDefaultTTLPolicy(t *testing.T) {
+	store := NewStore()
+	store.SetDefaultTTLs(time.Hour, map[string]time.Duration{
+		"session:":      time.Minute,
+		"session:long:": 2 * time.Minute,
+	})
+
+	expect := func(key string, want time.Duration) {
+		t.Helper()
+		ttl, exists, hasTTL := store.TTL(key)
+		if !exists || !hasTTL {
+			t.Fatalf("expected %s to exist with a TTL", key)
+		}
+		if ttl > want || ttl < want-time.Second {
+			t.Fatalf("expected TTL of %s to be about %v, got %v", key, want, ttl)
+		}
+	}
+
+	// Test 1: Longest matching prefix wins, then the database default
+	store.Set("session:abc", "v")
+	store.Set("session:long:abc", "v")
+	store.Set("other", "v")
+	expect("session:abc", time.Minute)
+	expect("session:long:abc", 2*time.Minute)
+	expect("other", time.Hour)
+
+	// Test 2: Explicit TTLs are kept
+	store.Restore("explicit", &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(10 * time.Second)}, false)
+	expect("explicit", 10*time.Second)
+
+	// Test 3: Without a policy keys are immortal
+	store.SetDefaultTTLs(0, nil)
+	store.Set("immortal", "v")
+	if _, _, hasTTL := store.TTL("immortal"); hasTTL {
+		t.Fatalf("expected no TTL without a policy")
+	}
+}
+
+func TestDefaultTTLConfig(t *testing.T) {
+	if _, err := ParseConfig(strings.NewReader("default-ttl db 0 60\ndefault-ttl prefix cache: 30\n")); err != nil {
+		t.Fatalf("expected valid default-ttl directives, got %v", err)
+	}
+	if _, err := ParseConfig(strings.NewReader("default-ttl everywhere 0 60\n")); err == nil {
+		t.Fatalf("expected error for unknown default-ttl scope")
+	}
+	if _, err := ParseConfig(strings.NewReader("default-ttl db 0 0\n")); err == nil {
+		t.Fatalf("expected error for zero TTL")
+	}
+}
+
+func TestPreciseExpiration(t *testing.T) {
+	store := NewStore()
+	store.SetPreciseExpireLimit(2)
+	soon := func(d time.Duration) *Entry {
+		return &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(d)}
+	}
+
+	// Test 1: Timers are armed up to the limit only
+	store.Restore("a", soon(20*time.Millisecond), false)
+	store.Restore("b", soon(time.Hour), false)
+	store.Restore("c", soon(20*time.Millisecond), false)
+	if count := store.PreciseTimerCount(); count != 2 {
+		t.Fatalf("expected 2 timers, got %d", count)
+	}
+
+	// Test 2: A key with a timer is removed at its deadline without sampling
+	deadline := time.Now().Add(time.Second)
+	for store.ExpiresCount() == 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	store.mu.RLock()
+	aGone, cKept := store.data.lookup("a") == nil, store.data.lookup("c") != nil
+	store.mu.RUnlock()
+	if !aGone || !cKept {
+		t.Fatalf("expected a removed by its timer and c left for sampling (a gone: %v, c kept: %v)", aGone, cKept)
+	}
+
+	// Test 3: Overwriting or deleting a key disarms its timer
+	store.Set("b", "persistent")
+	if count := store.PreciseTimerCount(); count != 0 {
+		t.Fatalf("expected overwrite to disarm the timer, got %d timers", count)
+	}
+	store.Restore("d", soon(time.Hour), false)
+	store.Del("d")
+	if count := store.PreciseTimerCount(); count != 0 {
+		t.Fatalf("expected DEL to disarm the timer, got %d timers", count)
+	}
+
+	// Test 4: In-place changes keep the timer
+	store.Restore("e", soon(time.Hour), false)
+	store.mu.Lock()
+	store.keyModified("e")
+	store.mu.Unlock()
+	if count := store.PreciseTimerCount(); count != 1 {
+		t.Fatalf("expected the timer to survive an in-place change, got %d timers", count)
+	}
+}
+
+func TestPExpireAt(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	vc := NewVirtualClock(start)
+	SetClock(vc)
+	defer SetClock(nil)
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	at := func(d time.Duration) string {
+		return strconv.FormatInt(start.Add(d).UnixMilli(), 10)
+	}
+	run("SET", "k", "v")
+
+	// Test 1: The expiration time is set in milliseconds, and missing keys
+	// report 0
+	if reply := run("PEXPIREAT", "k", at(time.Minute)); reply != ":1\r\n" || run("PEXPIRETIME", "k") != ":"+at(time.Minute)+"\r\n" {
+		t.Fatalf("expected the expiration time to be set, got %q", reply)
+	}
+	if reply := run("PEXPIREAT", "nosuch", at(time.Minute)); reply != ":0\r\n" {
+		t.Fatalf("expected 0 for a missing key, got %q", reply)
+	}
+
+	// Test 2: NX, XX, GT and LT only change it as they allow
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PEXPIREAT", "k", at(time.Hour), "NX"}, ":0\r\n"},
+		{[]string{"PEXPIREAT", "k", at(time.Second), "GT"}, ":0\r\n"},
+		{[]string{"PEXPIREAT", "k", at(time.Hour), "LT"}, ":0\r\n"},
+		{[]string{"PEXPIREAT", "k", at(time.Hour), "XX", "GT"}, ":1\r\n"},
+		{[]string{"PEXPIREAT", "k", at(time.Hour), "NX", "GT"}, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{[]string{"PEXPIREAT", "k", at(time.Hour), "GT", "LT"}, "-ERR GT and LT options at the same time are not compatible\r\n"},
+		{[]string{"PEXPIREAT", "k", "soon"}, "-ERR value is not an integer or out of range\r\n"},
+	} {
+		if reply := run(tc.args...); reply != tc.want {
+			t.Fatalf("%v: expected %q, got %q", tc.args, tc.want, reply)
+		}
+	}
+	if reply := run("PEXPIRETIME", "k"); reply != ":"+at(time.Hour)+"\r\n" {
+		t.Fatalf("expected the time GT allowed, got %q", reply)
+	}
+
+	// Test 3: A time already past deletes the key
+	if reply := run("PEXPIREAT", "k", at(-time.Second)); reply != ":1\r\n" || run("DBSIZE") != ":0\r\n" {
+		t.Fatalf("expected the key to be deleted, got %q", reply)
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Name library code is compiled under, which prefixes its error positions
+const functionChunkName = "user_function"
+
+// Longest the code of a library may run while registering its functions
+const functionLoadTimeout = 500 * time.Millisecond
+
+// Interpreter libraries are loaded and their functions run in, created by
+// the first library. Like luaVM, it is guarded by execMu.
+var functionVM *lua.LState
+
+// Library being loaded, which redis.register_function adds functions to
+var loadingLibrary *functionLibrary
+
+// functionLibrary is a library loaded by FUNCTION LOAD
+type functionLibrary struct {
+	name      string
+	code      string             // Source, with its metadata line
+	functions []*libraryFunction // In registration order
+}
+
+// libraryFunction is a function a library registered, called by FCALL
+type libraryFunction struct {
+	name        string
+	library     *functionLibrary
+	callback    *lua.LFunction // Lives in functionVM
+	description string
+	flags       []string
+}
+
+// Flags functions may be registered with
+var functionFlags = map[string]bool{
+	"no-writes": true, "allow-oom": true, "allow-stale": true, "no-cluster": true, "allow-cross-slot-keys": true,
+}
+
+// functionRegistry holds the loaded libraries and their functions by name
+type functionRegistry struct {
+	mu        sync.Mutex
+	libraries map[string]*functionLibrary
+	functions map[string]*libraryFunction
+}
+
+func newFunctionRegistry() *functionRegistry {
+	return &functionRegistry{
+		libraries: make(map[string]*functionLibrary),
+		functions: make(map[string]*libraryFunction),
+	}
+}
+
+// Libraries loaded by FUNCTION LOAD and RESTORE, or carried over from an
+// RDB file
+var libraries = newFunctionRegistry()
+
+// function returns the function called name, or nil
+func (r *functionRegistry) function(name string) *libraryFunction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.functions[name]
+}
+
+// list returns the libraries sorted by name
+func (r *functionRegistry) list() []*functionLibrary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	libs := make([]*functionLibrary, 0, len(r.libraries))
+	for _, lib := range r.libraries {
+		libs = append(libs, lib)
+	}
+	slices.SortFunc(libs, func(a, b *functionLibrary) int { return strings.Compare(a.name, b.name) })
+	return libs
+}
+
+// add registers libs, replacing the libraries of the same names if replace
+// Returns a non-empty error reply, having registered none of them, if one
+// exists and not replace, or one of their functions belongs to another
+// library
+func (r *functionRegistry) add(libs []*functionLibrary, replace bool) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, lib := range libs {
+		if r.libraries[lib.name] != nil && !replace {
+			return formatError("ERR Library '" + lib.name + "' already exists")
+		}
+		for _, fn := range lib.functions {
+			if owner := r.functions[fn.name]; owner != nil && owner.library.name != lib.name {
+				return formatError("ERR Function " + fn.name + " already exists")
+			}
+		}
+	}
+	for _, lib := range libs {
+		r.remove(lib.name)
+		r.libraries[lib.name] = lib
+		for _, fn := range lib.functions {
+			r.functions[fn.name] = fn
+		}
+	}
+	return ""
+}
+
+// delete removes the library called name and its functions
+// Returns false if there is none
+func (r *functionRegistry) delete(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.remove(name)
+}
+
+// remove is delete with the registry locked
+func (r *functionRegistry) remove(name string) bool {
+	lib := r.libraries[name]
+	if lib == nil {
+		return false
+	}
+	for _, fn := range lib.functions {
+		delete(r.functions, fn.name)
+	}
+	delete(r.libraries, name)
+	return true
+}
+
+// flush removes every library
+func (r *functionRegistry) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.libraries = make(map[string]*functionLibrary)
+	r.functions = make(map[string]*libraryFunction)
+}
+
+// validFunctionName reports whether name can name a library or function:
+// letters, digits and underscores, at least one
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLibraryMetadata reads the name of a library from the first line of
+// its code, "#!lua name=<library>"
+// Returns a non-empty error reply if the line is missing or invalid
+func parseLibraryMetadata(code string) (string, string) {
+	line, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return "", formatError("ERR Missing library metadata")
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", formatError("ERR Engine '" + engine + "' not found")
+	}
+	name := ""
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", formatError("ERR Invalid metadata value given: " + field)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", formatError("ERR Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", formatError("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, ""
+}
+
+// newFunctionVM creates the interpreter libraries run in: the one scripts
+// run in, with redis.register_function
+func newFunctionVM() *lua.LState {
+	L := newScriptVM()
+	L.GetGlobal("redis").(*lua.LTable).RawSetString("register_function", L.NewFunction(registerFunction))
+	return L
+}
+
+// redis.register_function(name, callback)
+// redis.register_function{function_name=name, callback=callback, flags={flag ...}, description=text}
+func registerFunction(L *lua.LState) int {
+	lib := loadingLibrary
+	if lib == nil {
+		L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+	}
+	fn := &libraryFunction{library: lib}
+	switch L.GetTop() {
+	case 1:
+		t := L.CheckTable(1)
+		t.ForEach(func(k, v lua.LValue) {
+			switch lua.LVAsString(k) {
+			case "function_name":
+				fn.name = lua.LVAsString(v)
+			case "callback":
+				fn.callback, _ = v.(*lua.LFunction)
+			case "description":
+				fn.description = lua.LVAsString(v)
+			case "flags":
+				flags, ok := v.(*lua.LTable)
+				if !ok {
+					L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+				}
+				flags.ForEach(func(_, flag lua.LValue) {
+					if !functionFlags[lua.LVAsString(flag)] {
+						L.RaiseError("unknown flag given")
+					}
+					fn.flags = append(fn.flags, lua.LVAsString(flag))
+				})
+			default:
+				L.RaiseError("unknown argument given to redis.register_function")
+			}
+		})
+	case 2:
+		fn.name = L.CheckString(1)
+		fn.callback, _ = L.Get(2).(*lua.LFunction)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+	}
+	if !validFunctionName(fn.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if fn.callback == nil {
+		L.RaiseError("callback argument given to redis.register_function must be a function")
+	}
+	if slices.ContainsFunc(lib.functions, func(other *libraryFunction) bool { return other.name == fn.name }) {
+		L.RaiseError("Function already exists in the library")
+	}
+	lib.functions = append(lib.functions, fn)
+	return 0
+}
+
+// compileLibrary runs the code of a library, collecting the functions it
+// registers, without adding it to the registry
+// Callers must hold execMu for writing.
+// Returns a non-empty error reply if the code is not a valid library
+func compileLibrary(code string) (*functionLibrary, string) {
+	name, errReply := parseLibraryMetadata(code)
+	if errReply != "" {
+		return nil, errReply
+	}
+	// The metadata line is not Lua; leaving its newline keeps line numbers
+	body := ""
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		body = code[i:]
+	}
+	chunk, err := parse.Parse(strings.NewReader(body), functionChunkName)
+	if err != nil {
+		return nil, formatError("ERR Error compiling function: " + singleLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, functionChunkName)
+	if err != nil {
+		return nil, formatError("ERR Error compiling function: " + singleLine(err.Error()))
+	}
+
+	if functionVM == nil {
+		functionVM = newFunctionVM()
+	}
+	L := functionVM
+	lib := &functionLibrary{name: name, code: code}
+	loadingLibrary = lib
+	defer func() { loadingLibrary = nil }()
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, formatError("ERR Error registering functions: FUNCTION LOAD timeout")
+		}
+		msg := err.Error()
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			msg = apiErr.Object.String()
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				msg = lua.LVAsString(t.RawGetString("err"))
+			}
+		}
+		return nil, formatError("ERR Error registering functions: " + singleLine(msg))
+	}
+	if len(lib.functions) == 0 {
+		return nil, formatError("ERR No functions registered")
+	}
+	return lib, ""
+}
+
+// restoreLibrary loads a library read from an RDB file, replacing any of
+// the same name
+func restoreLibrary(code string) error {
+	execMu.Lock()
+	defer execMu.Unlock()
+
+	lib, errReply := compileLibrary(code)
+	if errReply == "" {
+		errReply = libraries.add([]*functionLibrary{lib}, true)
+	}
+	if errReply != "" {
+		return errors.New(strings.TrimSuffix(errReply[1:], "\r\n"))
+	}
+	return nil
+}
+
+// dumpLibraries encodes the code of every library as a FUNCTION DUMP
+// payload, in the format of a DUMP payload
+func dumpLibraries() string {
+	enc := &rdbEncoder{}
+	for _, lib := range libraries.list() {
+		enc.writeByte(rdbOpFunction2)
+		enc.writeString(lib.code)
+	}
+	return string(appendDumpFooter(enc.buf))
+}
+
+// parseLibrariesDump decodes the library codes of a FUNCTION DUMP payload
+// Returns a non-empty error reply if it is not one
+func parseLibrariesDump(payload string) ([]string, string) {
+	body, ok := dumpPayloadBody(payload)
+	if !ok {
+		return nil, formatError("ERR payload version or checksum are wrong")
+	}
+	dec := newRDBDecoder(bytes.NewReader(body))
+	var codes []string
+	for {
+		op, err := dec.readByte()
+		if err == io.EOF {
+			return codes, ""
+		}
+		if err != nil || op != rdbOpFunction2 {
+			return nil, formatError("ERR given type is not a function")
+		}
+		code, err := dec.readString()
+		if err != nil {
+			return nil, formatError("ERR Bad data format")
+		}
+		codes = append(codes, code)
+	}
+}
+
+// callFunction runs a library function as runScript runs scripts, passing
+// it the keys and arguments. Functions registered with the no-writes flag
+// may not call write commands, whether called by FCALL or FCALL_RO.
+// Returns the function's reply
+func (c *Client) callFunction(fn *libraryFunction, keys, argv []string) string {
+	readOnly := slices.Contains(fn.flags, "no-writes")
+	return c.runLua(&functionVM, newFunctionVM, fn.name, readOnly, func(L *lua.LState) int {
+		L.Push(fn.callback)
+		L.Push(stringTable(L, keys))
+		L.Push(stringTable(L, argv))
+		return 2
+	})
+}
+
+// FCALL function numkeys [key ...] [arg ...]
+func fcallCommand(c *Client, args []string) string {
+	keys, argv, errReply := scriptArgs(args)
+	if errReply != "" {
+		return errReply
+	}
+	fn := libraries.function(args[1])
+	if fn == nil {
+		return formatError("ERR Function not found")
+	}
+	return c.callFunction(fn, keys, argv)
+}
+
+// FCALL_RO function numkeys [key ...] [arg ...]
+// Only calls functions registered with the no-writes flag
+func fcallROCommand(c *Client, args []string) string {
+	keys, argv, errReply := scriptArgs(args)
+	if errReply != "" {
+		return errReply
+	}
+	fn := libraries.function(args[1])
+	if fn == nil {
+		return formatError("ERR Function not found")
+	}
+	if !slices.Contains(fn.flags, "no-writes") {
+		return formatError("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	return c.callFunction(fn, keys, argv)
+}
+
+var functionHelp = []string{
+	"FUNCTION <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"LOAD [REPLACE] <FUNCTION CODE>",
+	"    Create a new library with the given library name and code.",
+	"DELETE <LIBRARY NAME>",
+	"    Delete the given library.",
+	"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+	"    Return general information on all the libraries:",
+	"    * Library name",
+	"    * The engine used to run the Library",
+	"    * Functions list",
+	"    * Library code (if WITHCODE is given)",
+	"    It is also possible to get only the libraries matching a pattern using LIBRARYNAME.",
+	"KILL",
+	"    Kill the current running function.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Delete all the libraries.",
+	"DUMP",
+	"    Return a serialized payload representing the current libraries, can be restored using FUNCTION RESTORE command",
+	"RESTORE <PAYLOAD> [FLUSH|APPEND|REPLACE]",
+	"    Restore the libraries represented by the given payload. The policy handles existing libraries",
+	"    (default APPEND):",
+	"    * FLUSH: delete all existing libraries.",
+	"    * APPEND: appends the restored libraries to the existing libraries. On collision, abort.",
+	"    * REPLACE: appends the restored libraries to the existing libraries. On collision, replace the old",
+	"      libraries with the new libraries (a function name colliding with another library still fails).",
+	"HELP",
+	"    Print this help.",
+}
+
+// FUNCTION LOAD [REPLACE] code | LIST [LIBRARYNAME pattern] [WITHCODE] |
+// DELETE library | DUMP | RESTORE payload [FLUSH | APPEND | REPLACE] |
+// FLUSH [ASYNC | SYNC] | KILL | HELP
+func functionCommand(c *Client, args []string) string {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range functionHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(functionHelp)) + "\r\n" + lines
+
+	case sub == "LOAD":
+		replace := len(args) == 4 && strings.EqualFold(args[2], "REPLACE")
+		if len(args) != 3 && !replace {
+			return formatError("ERR Unknown option given: " + args[2])
+		}
+		var lib *functionLibrary
+		var errReply string
+		c.exclusive(func() {
+			if lib, errReply = compileLibrary(args[len(args)-1]); errReply == "" {
+				errReply = libraries.add([]*functionLibrary{lib}, replace)
+			}
+		})
+		if errReply != "" {
+			return errReply
+		}
+		return formatBulkString(lib.name)
+
+	case sub == "LIST":
+		return c.listLibraries(args[2:])
+
+	case sub == "DELETE" && len(args) == 3:
+		if !libraries.delete(args[2]) {
+			return formatError("ERR Library not found")
+		}
+		return formatSimpleString("OK")
+
+	case sub == "DUMP" && len(args) == 2:
+		return formatBulkString(dumpLibraries())
+
+	case sub == "RESTORE" && len(args) <= 4:
+		policy := "APPEND"
+		if len(args) == 4 {
+			policy = strings.ToUpper(args[3])
+		}
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			return formatError("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+		}
+		codes, errReply := parseLibrariesDump(args[2])
+		if errReply != "" {
+			return errReply
+		}
+		c.exclusive(func() {
+			libs := make([]*functionLibrary, 0, len(codes))
+			for _, code := range codes {
+				var lib *functionLibrary
+				if lib, errReply = compileLibrary(code); errReply != "" {
+					return
+				}
+				libs = append(libs, lib)
+			}
+			if policy == "FLUSH" {
+				libraries.flush()
+			}
+			errReply = libraries.add(libs, policy == "REPLACE")
+		})
+		if errReply != "" {
+			return errReply
+		}
+		return formatSimpleString("OK")
+
+	case sub == "FLUSH":
+		async, ok := parseFlushMode(args[1:])
+		if !ok {
+			return formatError("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		}
+		c.exclusive(func() {
+			libraries.flush()
+			if old := functionVM; old != nil {
+				functionVM = nil
+				if async {
+					go old.Close()
+				} else {
+					old.Close()
+				}
+			}
+		})
+		return formatSimpleString("OK")
+
+	case sub == "KILL" && len(args) == 2:
+		return killScript()
+	}
+	return formatError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try FUNCTION HELP.")
+}
+
+// listLibraries replies to FUNCTION LIST with the given options
+func (c *Client) listLibraries(options []string) string {
+	withCode, pattern, hasPattern := false, "", false
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(options[i]) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if hasPattern {
+				return formatError("ERR library name given multiple times")
+			}
+			if i+1 == len(options) {
+				return formatError("ERR library name argument was not given")
+			}
+			i++
+			pattern, hasPattern = options[i], true
+		default:
+			return formatError("ERR Unknown argument " + options[i])
+		}
+	}
+
+	var reply []string
+	for _, lib := range libraries.list() {
+		if hasPattern && !matchPattern(pattern, lib.name) {
+			continue
+		}
+		functions := make([]string, len(lib.functions))
+		for i, fn := range lib.functions {
+			description := formatNullBulkString()
+			if fn.description != "" {
+				description = formatBulkString(fn.description)
+			}
+			functions[i] = c.formatMap([]string{
+				formatBulkString("name"), formatBulkString(fn.name),
+				formatBulkString("description"), description,
+				formatBulkString("flags"), c.formatSet(fn.flags),
+			})
+		}
+		pairs := []string{
+			formatBulkString("library_name"), formatBulkString(lib.name),
+			formatBulkString("engine"), formatBulkString("LUA"),
+			formatBulkString("functions"), formatList(functions),
+		}
+		if withCode {
+			pairs = append(pairs, formatBulkString("library_code"), formatBulkString(lib.code))
+		}
+		reply = append(reply, c.formatMap(pairs))
+	}
+	return formatList(reply)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	savedLibraries, savedVM := libraries, functionVM
+	libraries, functionVM = newFunctionRegistry(), nil
+	defer func() { libraries, functionVM = savedLibraries, savedVM }()
+
+	client := &Client{}
+	lib := "#!lua name=mylib\n" +
+		"local function set(keys, args) return redis.call('SET', keys[1], args[1]) end\n" +
+		"redis.register_function('myset', set)\n" +
+		"redis.register_function{function_name='myget', callback=function(keys) return redis.call('GET', keys[1]) end, flags={'no-writes'}}\n"
+	steps := func(steps []struct {
+		args []string
+		want string
+	}) {
+		t.Helper()
+		for _, step := range steps {
+			if reply := client.execute(step.args); !strings.HasPrefix(reply, step.want) {
+				t.Fatalf("expected %v to reply %q, got %q", step.args, step.want, reply)
+			}
+		}
+	}
+
+	// Test 1: FUNCTION LOAD registers the library's functions, which FCALL
+	// calls with the keys and arguments
+	steps([]struct {
+		args []string
+		want string
+	}{
+		{[]string{"FUNCTION", "LOAD", lib}, "$5\r\nmylib\r\n"},
+		{[]string{"FCALL", "myset", "1", "k", "v"}, "+OK\r\n"},
+		{[]string{"FCALL", "myget", "1", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL_RO", "myget", "1", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL_RO", "myset", "1", "k", "w"}, "-ERR Can not execute a script with write flag using *_ro command.\r\n"},
+		{[]string{"FCALL", "nope", "0"}, "-ERR Function not found\r\n"},
+		{[]string{"FCALL", "myset", "2", "k"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+		{[]string{"FUNCTION", "LOAD", lib}, "-ERR Library 'mylib' already exists\r\n"},
+		{[]string{"FUNCTION", "LOAD", "REPLACE", lib}, "$5\r\nmylib\r\n"},
+	})
+
+	// Test 2: Invalid libraries are refused without registering anything
+	for _, tc := range []struct{ code, want string }{
+		{"return 1", "-ERR Missing library metadata"},
+		{"#!js name=x\n", "-ERR Engine 'js' not found"},
+		{"#!lua\n", "-ERR Library name was not given"},
+		{"#!lua name=a-b\n", "-ERR Library names can only contain"},
+		{"#!lua name=x foo=bar\n", "-ERR Invalid metadata value given: foo=bar"},
+		{"#!lua name=x\nreturn (", "-ERR Error compiling function: "},
+		{"#!lua name=x\nlocal a = 1", "-ERR No functions registered"},
+		{"#!lua name=x\nredis.register_function('f', 1)", "-ERR Error registering functions: " + functionChunkName + ":2: callback argument"},
+		{"#!lua name=x\nredis.register_function('myset', function() end)", "-ERR Function myset already exists"},
+		{"#!lua name=x\nredis.call('SET', 'k', 'v')", "-ERR Error registering functions: ERR redis.call is not allowed while loading a library"},
+		{"#!lua name=x\nwhile true do end", "-ERR Error registering functions: FUNCTION LOAD timeout"},
+	} {
+		if reply := client.execute([]string{"FUNCTION", "LOAD", tc.code}); !strings.HasPrefix(reply, tc.want) {
+			t.Fatalf("expected loading %q to reply %q..., got %q", tc.code, tc.want, reply)
+		}
+	}
+	client.execute([]string{"FUNCTION", "LOAD", "#!lua name=late\nredis.register_function('late', function() redis.register_function('f', print) end)"})
+	if reply := client.execute([]string{"FCALL", "late", "0"}); !strings.Contains(reply, "can only be called on FUNCTION LOAD command") {
+		t.Fatalf("expected functions not to register functions, got %q", reply)
+	}
+	client.execute([]string{"FUNCTION", "DELETE", "late"})
+
+	// Test 3: FUNCTION LIST and DELETE
+	client.execute([]string{"FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('ping2', function() return 'pong' end)"})
+	want := "*1\r\n*8\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*2\r\n" +
+		"*6\r\n$4\r\nname\r\n$5\r\nmyset\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n" +
+		"*6\r\n$4\r\nname\r\n$5\r\nmyget\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+		"$12\r\nlibrary_code\r\n" + formatBulkString(lib)
+	if reply := client.execute([]string{"FUNCTION", "LIST", "LIBRARYNAME", "my*", "WITHCODE"}); reply != want {
+		t.Fatalf("unexpected FUNCTION LIST reply %q", reply)
+	}
+	if reply := client.execute([]string{"FUNCTION", "LIST"}); !strings.HasPrefix(reply, "*2\r\n") {
+		t.Fatalf("expected both libraries listed, got %q", reply)
+	}
+	steps([]struct {
+		args []string
+		want string
+	}{
+		{[]string{"FUNCTION", "DELETE", "other"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "DELETE", "other"}, "-ERR Library not found\r\n"},
+		{[]string{"FCALL", "ping2", "0"}, "-ERR Function not found\r\n"},
+		{[]string{"FUNCTION", "LIST", "BOGUS"}, "-ERR Unknown argument BOGUS\r\n"},
+	})
+
+	// Test 4: FUNCTION DUMP and RESTORE, with each policy
+	payload := client.execute([]string{"FUNCTION", "DUMP"})
+	payload = strings.TrimSuffix(payload[strings.Index(payload, "\r\n")+2:], "\r\n")
+	steps([]struct {
+		args []string
+		want string
+	}{
+		{[]string{"FUNCTION", "FLUSH"}, "+OK\r\n"},
+		{[]string{"FCALL", "myget", "1", "k"}, "-ERR Function not found\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload}, "+OK\r\n"},
+		{[]string{"FCALL", "myget", "1", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload}, "-ERR Library 'mylib' already exists\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload, "REPLACE"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload, "FLUSH"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload, "MERGE"}, "-ERR Wrong restore policy given"},
+		{[]string{"FUNCTION", "RESTORE", "garbage"}, "-ERR payload version or checksum are wrong\r\n"},
+	})
+
+	// Test 5: Libraries are written to RDB files and loaded back from them
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, databases); err != nil {
+		t.Fatalf("writeRDB failed: %v", err)
+	}
+	libraries = newFunctionRegistry()
+	_, err := readRDB(&buf, func(db int, key string, entry *Entry) error { return nil }, restoreLibrary)
+	if err != nil {
+		t.Fatalf("readRDB failed: %v", err)
+	}
+	if reply := client.execute([]string{"FCALL", "myget", "1", "k"}); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected the library to be loaded back, got %q", reply)
+	}
+	if reply := client.execute([]string{"FUNCTION", "HELP"}); !strings.HasPrefix(reply, "*27\r\n") {
+		t.Fatalf("unexpected FUNCTION HELP reply %q", reply)
+	}
+
+	// Test 6: Functions flagged no-writes may not write, called by either
+	// command; read commands still run
+	steps([]struct {
+		args []string
+		want string
+	}{
+		{[]string{"FUNCTION", "LOAD", "#!lua name=sneaky\n" +
+			"redis.register_function{function_name='ro_set', callback=function(keys) return redis.call('SET', keys[1], 'w') end, flags={'no-writes'}}\n" +
+			"redis.register_function{function_name='ro_push', callback=function(keys) return redis.pcall('LPUSH', keys[1], 'w') end, flags={'no-writes'}}\n"},
+			"$6\r\nsneaky\r\n"},
+		{[]string{"FCALL_RO", "ro_set", "1", "k"}, "-ERR Write commands are not allowed from read-only scripts.\r\n"},
+		{[]string{"FCALL", "ro_set", "1", "k"}, "-ERR Write commands are not allowed from read-only scripts.\r\n"},
+		{[]string{"FCALL_RO", "ro_push", "1", "list"}, "-ERR Write commands are not allowed from read-only scripts.\r\n"},
+		{[]string{"GET", "k"}, "$1\r\nv\r\n"},
+		{[]string{"DBSIZE"}, ":1\r\n"},
+		{[]string{"FCALL_RO", "myget", "1", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL", "myset", "1", "k", "w"}, "+OK\r\n"},
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geo points are members of a sorted set scored by their 52-bit geohash, as
+// in Redis: 26 bits of latitude (over the Web Mercator range) interleaved
+// with 26 bits of longitude, latitude in the even bits. The score is exact in
+// a float64 and keeps nearby points close in score order.
+const (
+	geoStep      = 26 // Bits per coordinate of a geohash score
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLongMin   = -180.0
+	geoLongMax   = 180.0
+	earthRadiusM = 6372797.560856 // Earth's radius in meters, as Redis uses for distances
+)
+
+// geoRange is the span of one coordinate a geohash subdivides
+type geoRange struct{ min, max float64 }
+
+// Coordinate spans of sorted set scores and of standard geohash strings
+var (
+	geoLatRange     = geoRange{geoLatMin, geoLatMax}
+	geoLongRange    = geoRange{geoLongMin, geoLongMax}
+	geoStdLatRange  = geoRange{-90, 90}
+	geoStdLongRange = geoRange{-180, 180}
+)
+
+// spreadBits spaces out the 32 bits of v into the even bits of the result
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squashBits gathers the even bits of x, undoing spreadBits
+func squashBits(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+// geohashEncode returns the geohash of step bits per coordinate of the
+// point, within the given coordinate spans
+func geohashEncode(long, lat float64, longRange, latRange geoRange, step uint) uint64 {
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min) * float64(uint64(1)<<step)
+	longOffset := (long - longRange.min) / (longRange.max - longRange.min) * float64(uint64(1)<<step)
+	return spreadBits(uint32(latOffset)) | spreadBits(uint32(longOffset))<<1
+}
+
+// geohashArea is the cell of a geohash: the coordinate spans of every point
+// encoded the same
+type geohashArea struct{ long, lat geoRange }
+
+// geohashDecode returns the cell of the geohash of step bits per coordinate
+func geohashDecode(hash uint64, step uint) geohashArea {
+	latCell, longCell := squashBits(hash), squashBits(hash>>1)
+	cells := float64(uint64(1) << step)
+	latScale := geoLatRange.max - geoLatRange.min
+	longScale := geoLongRange.max - geoLongRange.min
+	return geohashArea{
+		lat: geoRange{
+			geoLatRange.min + float64(latCell)/cells*latScale,
+			geoLatRange.min + float64(latCell+1)/cells*latScale,
+		},
+		long: geoRange{
+			geoLongRange.min + float64(longCell)/cells*longScale,
+			geoLongRange.min + float64(longCell+1)/cells*longScale,
+		},
+	}
+}
+
+// geoDecodeScore returns the longitude and latitude of the center of the
+// cell of a sorted set score
+func geoDecodeScore(score float64) (long, lat float64) {
+	area := geohashDecode(uint64(score), geoStep)
+	long = min(max((area.long.min+area.long.max)/2, geoLongMin), geoLongMax)
+	lat = min(max((area.lat.min+area.lat.max)/2, geoLatMin), geoLatMax)
+	return long, lat
+}
+
+// degToRad converts degrees to radians
+func degToRad(deg float64) float64 {
+	return deg * (math.Pi / 180)
+}
+
+// geoDistance returns the great-circle distance in meters between two
+// points, with the haversine formula
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	v := math.Sin((degToRad(long2) - degToRad(long1)) / 2)
+	if v == 0 {
+		// Along a meridian
+		return geoLatDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// geoLatDistance returns the distance in meters between two latitudes
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusM * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// parseCoordinates parses a longitude and latitude pair, which must be
+// within the range geohash scores cover
+// Returns a non-empty error reply on failure
+func parseCoordinates(longArg, latArg string) (long, lat float64, errReply string) {
+	long, err1 := strconv.ParseFloat(longArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(long) || math.IsNaN(lat) {
+		return 0, 0, formatError("ERR value is not a valid float")
+	}
+	if long < geoLongMin || long > geoLongMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, formatError(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", long, lat))
+	}
+	return long, lat, ""
+}
+
+// parseDistanceUnit returns the number of meters in a unit of distance
+func parseDistanceUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+// formatCoordinate formats a longitude or latitude as Redis does, with up
+// to 17 decimals
+func (c *Client) formatCoordinate(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	s = strings.TrimSuffix(s, ".")
+	if c.resp3 {
+		return "," + s + "\r\n"
+	}
+	return formatBulkString(s)
+}
+
+// formatDistance formats a distance with four decimals
+func formatDistance(d float64) string {
+	return formatBulkString(strconv.FormatFloat(d, 'f', 4, 64))
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func geoaddCommand(c *Client, args []string) string {
+	var opts ZAddOptions
+	i := 2
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "CH":
+			opts.CH = true
+		default:
+			break flags
+		}
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 || (opts.NX && opts.XX) {
+		return formatError("ERR syntax error")
+	}
+
+	elements := make([]zsetElement, 0, len(triples)/3)
+	for j := 0; j < len(triples); j += 3 {
+		long, lat, errReply := parseCoordinates(triples[j], triples[j+1])
+		if errReply != "" {
+			return errReply
+		}
+		hash := geohashEncode(long, lat, geoLongRange, geoLatRange, geoStep)
+		elements = append(elements, zsetElement{member: triples[j+2], score: float64(hash)})
+	}
+
+	count, _, errMsg := c.db().ZAdd(args[1], opts, elements)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	return formatInteger(count)
+}
+
+// GEOPOS key [member ...]
+func geoposCommand(c *Client, args []string) string {
+	scores, ok := c.db().ZScore(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(scores)) + "\r\n")
+	for _, score := range scores {
+		if score == nil {
+			b.WriteString(formatNullArray())
+			continue
+		}
+		long, lat := geoDecodeScore(*score)
+		b.WriteString("*2\r\n" + c.formatCoordinate(long) + c.formatCoordinate(lat))
+	}
+	return b.String()
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func geodistCommand(c *Client, args []string) string {
+	unit := 1.0
+	switch len(args) {
+	case 4:
+	case 5:
+		var ok bool
+		if unit, ok = parseDistanceUnit(args[4]); !ok {
+			return formatError("ERR unsupported unit provided. please use M, KM, FT, MI")
+		}
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	scores, ok := c.db().ZScore(args[1], args[2], args[3])
+	switch {
+	case !ok:
+		return formatError(wrongTypeError)
+	case scores[0] == nil || scores[1] == nil:
+		return formatNullBulkString()
+	}
+	long1, lat1 := geoDecodeScore(*scores[0])
+	long2, lat2 := geoDecodeScore(*scores[1])
+	return formatDistance(geoDistance(long1, lat1, long2, lat2) / unit)
+}
+
+// Alphabet of standard geohash strings
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GEOHASH key [member ...]
+func geohashCommand(c *Client, args []string) string {
+	scores, ok := c.db().ZScore(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(scores)) + "\r\n")
+	for _, score := range scores {
+		if score == nil {
+			b.WriteString(formatNullBulkString())
+			continue
+		}
+		// Standard geohashes span latitudes of -90 to 90, so the point is
+		// encoded again; 52 bits give 10 characters and a half, the eleventh
+		// is always "0"
+		long, lat := geoDecodeScore(*score)
+		hash := geohashEncode(long, lat, geoStdLongRange, geoStdLatRange, geoStep)
+		var buf [11]byte
+		for i := range buf {
+			idx := 0
+			if i < 10 {
+				idx = int(hash>>(52-(i+1)*5)) & 0x1f
+			}
+			buf[i] = geohashAlphabet[idx]
+		}
+		b.WriteString(formatBulkString(string(buf[:])))
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: GEOADD scores points by their 52-bit geohash, as Redis does
+	if reply := run("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"); reply != ":2\r\n" {
+		t.Fatalf("expected 2 points added, got %q", reply)
+	}
+	if reply := run("ZRANGE", "Sicily", "0", "-1", "WITHSCORES"); reply != formatArray([]string{"Palermo", "3479099956230698", "Catania", "3479447370796909"}) {
+		t.Fatalf("unexpected scores %q", reply)
+	}
+
+	// Test 2: Positions, distances and geohashes of the Redis documentation
+	pos := func(long, lat string) string {
+		return "*2\r\n" + formatBulkString(long) + formatBulkString(lat)
+	}
+	for args, want := range map[string]string{
+		"GEOPOS Sicily Palermo Catania NonExisting": "*3\r\n" + pos("13.36138933897018433", "38.11555639549629859") +
+			pos("15.08726745843887329", "37.50266842333162032") + formatNullArray(),
+		"GEODIST Sicily Palermo Catania":    formatBulkString("166274.1516"),
+		"GEODIST Sicily Palermo Catania km": formatBulkString("166.2742"),
+		"GEODIST Sicily Palermo Catania MI": formatBulkString("103.3182"),
+		"GEODIST Sicily Foo Bar":            formatNullBulkString(),
+		"GEODIST missing Foo Bar":           formatNullBulkString(),
+		"GEOHASH Sicily Palermo Catania x":  "*3\r\n" + formatBulkString("sqc8b49rny0") + formatBulkString("sqdtr74hyu0") + formatNullBulkString(),
+		"GEOPOS missing a":                  "*1\r\n" + formatNullArray(),
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 3: NX, XX and CH behave as in ZADD
+	for _, step := range []struct{ args, want string }{
+		{"GEOADD Sicily NX 13 38 Palermo 14 37 Agrigento", ":1\r\n"},
+		{"GEOADD Sicily XX CH 13 38 Palermo 15 37 Siracusa", ":1\r\n"},
+		{"GEOADD Sicily 13.361389 38.115556 Palermo", ":0\r\n"},
+		{"ZCARD Sicily", ":3\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 4: Points round-trip within the precision of a cell
+	for _, p := range [][2]float64{{0, 0}, {-180, -85.05112878}, {180, 85.05112878}, {-73.9857, 40.7484}} {
+		long, lat := geoDecodeScore(float64(geohashEncode(p[0], p[1], geoLongRange, geoLatRange, geoStep)))
+		if d := geoDistance(p[0], p[1], long, lat); d > 1 {
+			t.Fatalf("expected %v to decode within a meter, got %v, %v", p, long, lat)
+		}
+	}
+
+	// Test 5: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"GEOADD k 181 10 m":           "-ERR invalid longitude,latitude pair 181.000000,10.000000\r\n",
+		"GEOADD k 10 86 m":            "-ERR invalid longitude,latitude pair 10.000000,86.000000\r\n",
+		"GEOADD k x 10 m":             "-ERR value is not a valid float\r\n",
+		"GEOADD k 10 10 m 20":         "-ERR syntax error\r\n",
+		"GEOADD k NX XX 10 10 m":      "-ERR syntax error\r\n",
+		"GEODIST Sicily Palermo a yd": "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n",
+		"GEOADD str 10 10 m":          "-" + wrongTypeError + "\r\n",
+		"GEOPOS str m":                "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
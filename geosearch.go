@@ -0,0 +1,456 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Half the circumference of the Earth in Web Mercator meters
+const mercatorMax = 20037726.37
+
+// geoShape is the area GEOSEARCH covers, a circle or an axis-aligned box
+// around the center
+type geoShape struct {
+	long, lat     float64 // Center
+	isBox         bool
+	radius        float64 // Radius in meters, BYRADIUS
+	width, height float64 // Sides in meters, BYBOX
+	unit          float64 // Meters per unit of the distances given and replied
+}
+
+// GeoSearchQuery is what GEOSEARCH and GEOSEARCHSTORE look for
+type GeoSearchQuery struct {
+	FromMember string // Member whose position is the center, with UseMember
+	UseMember  bool
+	Shape      geoShape
+	Count      int  // Most points returned (0 for all)
+	Any        bool // Stop at the first Count points found, not the nearest
+	Sort       int  // 1 nearest first, -1 farthest first, 0 unsorted
+}
+
+// geoPoint is a point GEOSEARCH found
+type geoPoint struct {
+	member    string
+	score     float64 // Geohash score
+	dist      float64 // Distance to the center in meters
+	long, lat float64
+}
+
+// geohashMove returns the geohash of the cell dLong cells east and dLat
+// cells north of hash, both -1, 0 or 1, wrapping around at the edges
+func geohashMove(hash uint64, step uint, dLong, dLat int) uint64 {
+	const odd, even = 0xaaaaaaaaaaaaaaaa, 0x5555555555555555
+	long, lat := hash&odd, hash&even
+	shift := 64 - step*2
+	if dLong != 0 {
+		zz := uint64(even) >> shift
+		if dLong > 0 {
+			long += zz + 1
+		} else {
+			long |= zz
+			long -= zz + 1
+		}
+		long &= odd >> shift
+	}
+	if dLat != 0 {
+		zz := uint64(odd) >> shift
+		if dLat > 0 {
+			lat += zz + 1
+		} else {
+			lat |= zz
+			lat -= zz + 1
+		}
+		lat &= even >> shift
+	}
+	return long | lat
+}
+
+// geohashEstimateStep returns the geohash precision whose cells are about
+// the size of a search of rangeMeters, coarser near the poles where cells
+// shrink
+func geohashEstimateStep(rangeMeters, lat float64) uint {
+	if rangeMeters == 0 {
+		return geoStep
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStep))
+}
+
+// radToDeg converts radians to degrees
+func radToDeg(rad float64) float64 {
+	return rad / (math.Pi / 180)
+}
+
+// boundingBox returns the longitudes and latitudes enclosing the shape
+func (shape geoShape) boundingBox() (minLong, minLat, maxLong, maxLat float64) {
+	height, width := shape.radius, shape.radius
+	if shape.isBox {
+		height, width = shape.height/2, shape.width/2
+	}
+	latDelta := radToDeg(height / earthRadiusM)
+	longDeltaTop := radToDeg(width / earthRadiusM / math.Cos(degToRad(shape.lat+latDelta)))
+	longDeltaBottom := radToDeg(width / earthRadiusM / math.Cos(degToRad(shape.lat-latDelta)))
+	// The side nearer the equator is the wider
+	longDelta := longDeltaTop
+	if shape.lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return shape.long - longDelta, shape.lat - latDelta, shape.long + longDelta, shape.lat + latDelta
+}
+
+// geohashCell is a cell of a geohash of step bits per coordinate
+type geohashCell struct {
+	hash uint64
+	step uint
+}
+
+// cells returns the geohash cells covering the shape: the cell of the
+// center at a precision where its neighbors reach past the shape, and the
+// neighbors the shape overlaps
+func (shape geoShape) cells() []geohashCell {
+	minLong, minLat, maxLong, maxLat := shape.boundingBox()
+	radius := shape.radius
+	if shape.isBox {
+		radius = math.Hypot(shape.width/2, shape.height/2)
+	}
+	step := geohashEstimateStep(radius, shape.lat)
+
+	// Neighbors in the order Redis visits them: N, S, E, W, NE, NW, SE, SW
+	moves := [8][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+	var hash uint64
+	var neighbors [8]uint64
+	locate := func() {
+		hash = geohashEncode(shape.long, shape.lat, geoLongRange, geoLatRange, step)
+		for i, m := range moves {
+			neighbors[i] = geohashMove(hash, step, m[0], m[1])
+		}
+	}
+	locate()
+	north, south := geohashDecode(neighbors[0], step), geohashDecode(neighbors[1], step)
+	east, west := geohashDecode(neighbors[2], step), geohashDecode(neighbors[3], step)
+	if step > 1 && (north.lat.max < maxLat || south.lat.min > minLat || east.long.max < maxLong || west.long.min > minLong) {
+		step--
+		locate()
+	}
+
+	// Leave out the neighbors beyond a side of the center cell that already
+	// reaches past the shape
+	skip := [8]bool{}
+	if step >= 2 {
+		area := geohashDecode(hash, step)
+		for i, m := range moves {
+			skip[i] = (m[1] < 0 && area.lat.min < minLat) || (m[1] > 0 && area.lat.max > maxLat) ||
+				(m[0] < 0 && area.long.min < minLong) || (m[0] > 0 && area.long.max > maxLong)
+		}
+	}
+	cells := []geohashCell{{hash, step}}
+	for i, neighbor := range neighbors {
+		// Coarse cells can be each other's neighbors more than once
+		if !skip[i] && !slices.Contains(cells, geohashCell{neighbor, step}) {
+			cells = append(cells, geohashCell{neighbor, step})
+		}
+	}
+	return cells
+}
+
+// contains returns the distance in meters from the center of the shape to
+// the point, if it is within the shape
+func (shape geoShape) contains(long, lat float64) (float64, bool) {
+	if shape.isBox {
+		// Latitude distance is the cheaper to check
+		if geoLatDistance(lat, shape.lat) > shape.height/2 {
+			return 0, false
+		}
+		if geoDistance(long, lat, shape.long, lat) > shape.width/2 {
+			return 0, false
+		}
+		return geoDistance(shape.long, shape.lat, long, lat), true
+	}
+	dist := geoDistance(shape.long, shape.lat, long, lat)
+	return dist, dist <= shape.radius
+}
+
+// geoSearch returns the points of zs within the shape of q, in the order
+// and number q asks for
+// Returns a non-empty error reply on failure
+// Callers must hold the lock.
+func (zs *zset) geoSearch(q GeoSearchQuery) ([]geoPoint, string) {
+	shape := q.Shape
+	if q.UseMember {
+		score, found := zs.score(q.FromMember)
+		if !found {
+			return nil, formatError("ERR could not decode requested zset member")
+		}
+		shape.long, shape.lat = geoDecodeScore(score)
+	}
+
+	var points []geoPoint
+	var elements []zsetElement
+	for _, cell := range shape.cells() {
+		shift := 2 * (geoStep - cell.step)
+		r := scoreRange{min: float64(cell.hash << shift), max: float64((cell.hash + 1) << shift), maxEx: true}
+		from, to := zs.scoreRanks(r)
+		elements = zs.appendRange(elements[:0], from, to, false)
+		for _, e := range elements {
+			long, lat := geoDecodeScore(e.score)
+			if dist, ok := shape.contains(long, lat); ok {
+				points = append(points, geoPoint{e.member, e.score, dist, long, lat})
+				if q.Any && len(points) == q.Count {
+					break
+				}
+			}
+		}
+		if q.Any && len(points) == q.Count {
+			break
+		}
+	}
+
+	if q.Sort != 0 {
+		slices.SortStableFunc(points, func(a, b geoPoint) int {
+			return q.Sort * cmp.Compare(a.dist, b.dist)
+		})
+	}
+	if q.Count > 0 && len(points) > q.Count {
+		points = points[:q.Count]
+	}
+	return points, ""
+}
+
+// GeoSearch returns the points of the sorted set at key within the shape
+// of q
+// Returns (points, a non-empty error reply on failure)
+func (s *Store) GeoSearch(key string, q GeoSearchQuery) ([]geoPoint, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if !ok {
+		return nil, formatError(wrongTypeError)
+	}
+	if zs == nil {
+		return nil, ""
+	}
+	return zs.geoSearch(q)
+}
+
+// GeoSearchStore stores in dst the points of the sorted set at key within
+// the shape of q, scored by geohash or with storeDist by their distance in
+// the unit of the query, replacing whatever dst held; no points delete dst
+// Returns (number of points, a non-empty error reply on failure)
+func (s *Store) GeoSearchStore(dst, key string, q GeoSearchQuery, storeDist bool) (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zs, ok := s.sortedSet(key)
+	if !ok {
+		return 0, formatError(wrongTypeError)
+	}
+	var points []geoPoint
+	if zs != nil {
+		var errReply string
+		if points, errReply = zs.geoSearch(q); errReply != "" {
+			return 0, errReply
+		}
+	}
+	if len(points) == 0 {
+		if s.data.delete(dst) {
+			s.keyModified(dst)
+		}
+		return 0, ""
+	}
+	scores := make(map[string]float64, len(points))
+	for _, p := range points {
+		if storeDist {
+			scores[p.member] = p.dist / q.Shape.unit
+		} else {
+			scores[p.member] = p.score
+		}
+	}
+	s.put(dst, &Entry{Type: TypeSortedSet, Value: s.encodeZset(scores)})
+	return len(points), ""
+}
+
+// geoSearchOptions are the reply options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchOptions struct {
+	withCoord, withDist, withHash bool
+	storeDist                     bool
+}
+
+// parseDistance parses a non-negative distance followed by its unit
+// Returns (meters, meters per unit, a non-empty error reply on failure)
+func parseDistance(value, unitArg, what, negative string) (float64, float64, string) {
+	d, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(d) {
+		return 0, 0, formatError("ERR need numeric " + what)
+	}
+	if d < 0 {
+		return 0, 0, formatError("ERR " + negative)
+	}
+	unit, ok := parseDistanceUnit(unitArg)
+	if !ok {
+		return 0, 0, formatError("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return d * unit, unit, ""
+}
+
+// parseGeoSearch parses the options of GEOSEARCH, or of GEOSEARCHSTORE with
+// store
+// Returns a non-empty error reply on failure
+func parseGeoSearch(name string, args []string, store bool) (GeoSearchQuery, geoSearchOptions, string) {
+	var q GeoSearchQuery
+	var opts geoSearchOptions
+	var fromLonLat, byRadius, byBox bool
+	syntaxError := formatError("ERR syntax error")
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "FROMMEMBER" && i+1 < len(args):
+			if q.UseMember || fromLonLat {
+				return q, opts, syntaxError
+			}
+			q.FromMember, q.UseMember = args[i+1], true
+			i++
+		case opt == "FROMLONLAT" && i+2 < len(args):
+			if q.UseMember || fromLonLat {
+				return q, opts, syntaxError
+			}
+			long, lat, errReply := parseCoordinates(args[i+1], args[i+2])
+			if errReply != "" {
+				return q, opts, errReply
+			}
+			q.Shape.long, q.Shape.lat, fromLonLat = long, lat, true
+			i += 2
+		case opt == "BYRADIUS" && i+2 < len(args):
+			if byRadius || byBox {
+				return q, opts, syntaxError
+			}
+			var errReply string
+			if q.Shape.radius, q.Shape.unit, errReply = parseDistance(args[i+1], args[i+2], "radius", "radius cannot be negative"); errReply != "" {
+				return q, opts, errReply
+			}
+			byRadius = true
+			i += 2
+		case opt == "BYBOX" && i+3 < len(args):
+			if byRadius || byBox {
+				return q, opts, syntaxError
+			}
+			var errReply string
+			if q.Shape.width, q.Shape.unit, errReply = parseDistance(args[i+1], args[i+3], "width", "height or width cannot be negative"); errReply != "" {
+				return q, opts, errReply
+			}
+			if q.Shape.height, _, errReply = parseDistance(args[i+2], args[i+3], "height", "height or width cannot be negative"); errReply != "" {
+				return q, opts, errReply
+			}
+			q.Shape.isBox, byBox = true, true
+			i += 3
+		case opt == "ASC":
+			q.Sort = 1
+		case opt == "DESC":
+			q.Sort = -1
+		case opt == "COUNT" && i+1 < len(args):
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return q, opts, formatError("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return q, opts, formatError("ERR COUNT must be > 0")
+			}
+			q.Count = count
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				q.Any = true
+				i++
+			}
+		case opt == "WITHCOORD":
+			opts.withCoord = true
+		case opt == "WITHDIST":
+			opts.withDist = true
+		case opt == "WITHHASH":
+			opts.withHash = true
+		case opt == "STOREDIST" && store:
+			opts.storeDist = true
+		default:
+			return q, opts, syntaxError
+		}
+	}
+
+	switch {
+	case !q.UseMember && !fromLonLat:
+		return q, opts, formatError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + name)
+	case !byRadius && !byBox:
+		return q, opts, formatError("ERR exactly one of BYRADIUS and BYBOX can be specified for " + name)
+	case store && (opts.withCoord || opts.withDist || opts.withHash):
+		return q, opts, formatError("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	// COUNT without an order keeps the nearest points, unless any will do
+	if q.Count > 0 && q.Sort == 0 && !q.Any {
+		q.Sort = 1
+	}
+	return q, opts, ""
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func geosearchCommand(c *Client, args []string) string {
+	q, opts, errReply := parseGeoSearch(args[0], args[2:], false)
+	if errReply != "" {
+		return errReply
+	}
+	points, errReply := c.db().GeoSearch(args[1], q)
+	if errReply != "" {
+		return errReply
+	}
+
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(points)) + "\r\n")
+	fields := 1
+	for _, with := range []bool{opts.withDist, opts.withHash, opts.withCoord} {
+		if with {
+			fields++
+		}
+	}
+	for _, p := range points {
+		if fields == 1 {
+			b.WriteString(formatBulkString(p.member))
+			continue
+		}
+		b.WriteString("*" + strconv.Itoa(fields) + "\r\n" + formatBulkString(p.member))
+		if opts.withDist {
+			b.WriteString(formatDistance(p.dist / q.Shape.unit))
+		}
+		if opts.withHash {
+			b.WriteString(formatInteger(int(p.score)))
+		}
+		if opts.withCoord {
+			b.WriteString("*2\r\n" + c.formatCoordinate(p.long) + c.formatCoordinate(p.lat))
+		}
+	}
+	return b.String()
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func geosearchstoreCommand(c *Client, args []string) string {
+	q, opts, errReply := parseGeoSearch(args[0], args[3:], true)
+	if errReply != "" {
+		return errReply
+	}
+	n, errReply := c.db().GeoSearchStore(args[1], args[2], q, opts.storeDist)
+	if errReply != "" {
+		return errReply
+	}
+	return formatInteger(n)
+}
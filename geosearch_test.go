@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGeoSearch(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	run("GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	// Test 1: Searches of the Redis documentation
+	coord := func(long, lat string) string {
+		return "*2\r\n" + formatBulkString(long) + formatBulkString(lat)
+	}
+	catania := coord("15.08726745843887329", "37.50266842333162032")
+	palermo := coord("13.36138933897018433", "38.11555639549629859")
+	edge2 := coord("17.24151045083999634", "38.78813451624225195")
+	edge1 := coord("12.7584877610206604", "38.78813451624225195")
+	for args, want := range map[string]string{
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC":  formatArray([]string{"Catania", "Palermo"}),
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km DESC": formatArray([]string{"Palermo", "Catania"}),
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHCOORD WITHDIST": "*4\r\n" +
+			"*3\r\n" + formatBulkString("Catania") + formatBulkString("56.4413") + catania +
+			"*3\r\n" + formatBulkString("Palermo") + formatBulkString("190.4424") + palermo +
+			"*3\r\n" + formatBulkString("edge2") + formatBulkString("279.7403") + edge2 +
+			"*3\r\n" + formatBulkString("edge1") + formatBulkString("279.7405") + edge1,
+		"GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 100 km ASC":              formatArray([]string{"Palermo", "edge1"}),
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 400 400 km COUNT 1":           formatArray([]string{"Catania"}),
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 100 km WITHHASH":           "*1\r\n*2\r\n" + formatBulkString("Catania") + ":3479447370796909\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 10 km":                     "*0\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 124.2 mi WITHDIST COUNT 1": "*1\r\n*2\r\n" + formatBulkString("Catania") + formatBulkString("35.0711"),
+		"GEOSEARCH missing FROMMEMBER x BYRADIUS 1 m":                          "*0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	if reply := run("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "1", "ANY"); !strings.HasPrefix(reply, "*1\r\n") {
+		t.Fatalf("expected COUNT 1 ANY to return one point, got %q", reply)
+	}
+
+	// Test 2: GEOSEARCHSTORE stores geohash scores, or distances with STOREDIST
+	for _, step := range []struct{ args, want string }{
+		{"GEOSEARCHSTORE key1 Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", ":3\r\n"},
+		{"ZRANGE key1 0 -1 WITHSCORES", formatArray([]string{"Palermo", "3479099956230698", "Catania", "3479447370796909", "edge2", "3481342659049484"})},
+		{"GEOSEARCHSTORE key2 Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3 STOREDIST", ":3\r\n"},
+		{"ZRANGE key2 0 -1", formatArray([]string{"Catania", "Palermo", "edge2"})},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	// Distances agree with Redis to the precision GEODIST replies with; the
+	// last digits depend on the math library
+	scores, _ := databases.Get(0).ZScore("key2", "Catania", "Palermo", "edge2")
+	for i, want := range []string{"56.4413", "190.4424", "279.7403"} {
+		if got := strconv.FormatFloat(*scores[i], 'f', 4, 64); got != want {
+			t.Fatalf("expected distance %s, got %s", want, got)
+		}
+	}
+	for _, step := range []struct{ args, want string }{
+		{"GEOSEARCHSTORE key2 Sicily FROMLONLAT 0 0 BYRADIUS 1 km", ":0\r\n"},
+		{"ZCARD key2", ":0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 3: Searches find exactly the points a scan of every point finds,
+	// at all scales and near the poles and the antimeridian
+	rng := rand.New(rand.NewSource(1))
+	type point struct {
+		name      string
+		long, lat float64
+	}
+	var points []point
+	args := []string{"GEOADD", "world"}
+	for i := range 3000 {
+		long, lat := rng.Float64()*360-180, rng.Float64()*170-85
+		if i%3 == 0 {
+			// Cluster a third of the points for small searches
+			long, lat = 2+rng.Float64()*0.2, 48+rng.Float64()*0.2
+		}
+		name := "p" + strconv.Itoa(i)
+		args = append(args, strconv.FormatFloat(long, 'f', -1, 64), strconv.FormatFloat(lat, 'f', -1, 64), name)
+		hash := geohashEncode(long, lat, geoLongRange, geoLatRange, geoStep)
+		long, lat = geoDecodeScore(float64(hash))
+		points = append(points, point{name, long, lat})
+	}
+	run(args...)
+	for _, search := range []struct {
+		long, lat, radius float64
+		isBox             bool
+	}{
+		{2.1, 48.1, 500, false}, {2.1, 48.1, 5000, true}, {2.1, 48.1, 15000, false},
+		{10, 0, 1000000, false}, {179.9, 10, 800000, true}, {-179.5, -20, 300000, false},
+		{0, 84, 500000, false}, {0, -84, 900000, true}, {60, 30, 8000000, false},
+	} {
+		shape := geoShape{long: search.long, lat: search.lat, isBox: search.isBox, radius: search.radius,
+			width: 2 * search.radius, height: search.radius, unit: 1}
+		var want []string
+		for _, p := range points {
+			if _, ok := shape.contains(p.long, p.lat); ok {
+				want = append(want, p.name)
+			}
+		}
+		found, _ := databases.Get(0).GeoSearch("world", GeoSearchQuery{Shape: shape})
+		var got []string
+		for _, p := range found {
+			got = append(got, p.member)
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("search %+v: expected %d points, got %d", search, len(want), len(got))
+		}
+	}
+
+	// Test 4: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"GEOSEARCH Sicily BYRADIUS 1 km ASC COUNT 1":                        "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 ASC WITHDIST":                    "-ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 FROMMEMBER a BYRADIUS 1 km":      "-ERR syntax error\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 km BYBOX 1 1 km":      "-ERR syntax error\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 km ANY":               "-ERR syntax error\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 km STOREDIST":         "-ERR syntax error\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS -1 km":                  "-ERR radius cannot be negative\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS x km":                   "-ERR need numeric radius\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 1 -1 km":                   "-ERR height or width cannot be negative\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 yd":                   "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 1 km COUNT 0":           "-ERR COUNT must be > 0\r\n",
+		"GEOSEARCH Sicily FROMLONLAT 200 37 BYRADIUS 1 km":                  "-ERR invalid longitude,latitude pair 200.000000,37.000000\r\n",
+		"GEOSEARCH Sicily FROMMEMBER nobody BYRADIUS 1 km":                  "-ERR could not decode requested zset member\r\n",
+		"GEOSEARCHSTORE dst Sicily FROMLONLAT 15 37 BYRADIUS 1 km WITHDIST": "-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n",
+		"GEOSEARCH str FROMLONLAT 15 37 BYRADIUS 1 km":                      "-" + wrongTypeError + "\r\n",
+		"GEOSEARCHSTORE dst str FROMLONLAT 15 37 BYRADIUS 1 km":             "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+// matchPattern reports whether str matches the glob-style pattern using the
+// same rules as Redis:
+//
+//   - * matches any sequence of bytes (including none)
+//   - ? matches exactly one byte
+//   - [abc] matches one byte from the set; [^abc] negates it and [a-z] is a range
+//   - \x matches x literally
+func matchPattern(pattern, str string) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// Collapse consecutive stars
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true // Trailing star matches everything left
+			}
+			for ; s <= len(str); s++ {
+				if matchPattern(pattern[p+1:], str[s:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			p++
+			negate := p < len(pattern) && pattern[p] == '^'
+			if negate {
+				p++
+			}
+			matched := false
+			for p < len(pattern) && pattern[p] != ']' {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					if pattern[p] == str[s] {
+						matched = true
+					}
+				case p+2 < len(pattern) && pattern[p+1] == '-':
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[s] >= start && str[s] <= end {
+						matched = true
+					}
+					p += 2
+				default:
+					if pattern[p] == str[s] {
+						matched = true
+					}
+				}
+				p++
+			}
+			if p >= len(pattern) {
+				p-- // Unterminated class: treat the end of pattern as the closing bracket
+			}
+			if matched == negate {
+				return false
+			}
+			s++
+
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+
+		default:
+			if s >= len(str) || pattern[p] != str[s] {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(str)
+}
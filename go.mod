@@ -1,3 +1,5 @@
 module github.com/atzgg132/redisgo
 
 go 1.24.4
+
+require github.com/yuin/gopher-lua v1.1.2
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Share of a cgroup memory limit used as the default GOMEMLIMIT, leaving
+// headroom for memory the Go runtime does not account for
+const cgroupMemoryShare = 0.9
+
+// Root of the cgroup filesystem the defaults are derived from
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupCPUs returns the CPU quota of the process's cgroup in CPUs, or 0
+// without a quota. Both cgroup v2 (cpu.max) and v1 (cpu.cfs_*) are read.
+func cgroupCPUs() float64 {
+	if data, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+				return quota / period
+			}
+		}
+		return 0
+	}
+	quota, err1 := readCgroupInt("cpu", "cpu.cfs_quota_us")
+	period, err2 := readCgroupInt("cpu", "cpu.cfs_period_us")
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+// cgroupMemory returns the memory limit of the process's cgroup in bytes,
+// or 0 without a limit
+func cgroupMemory() int64 {
+	if data, err := os.ReadFile(filepath.Join(cgroupRoot, "memory.max")); err == nil {
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil || limit <= 0 {
+			return 0 // "max"
+		}
+		return limit
+	}
+	limit, err := readCgroupInt("memory", "memory.limit_in_bytes")
+	// cgroup v1 reports no limit as a huge page-aligned number
+	if err != nil || limit <= 0 || limit >= math.MaxInt64/2 {
+		return 0
+	}
+	return limit
+}
+
+func readCgroupInt(controller, file string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(cgroupRoot, controller, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// autoMaxProcs returns the GOMAXPROCS fitting the cgroup CPU quota, rounded
+// up and never above the machine's CPUs
+func autoMaxProcs() int {
+	procs := runtime.NumCPU()
+	if cpus := cgroupCPUs(); cpus > 0 {
+		procs = min(procs, max(int(math.Ceil(cpus)), 1))
+	}
+	return procs
+}
+
+// autoMemoryLimit returns the GOMEMLIMIT derived from the cgroup memory
+// limit, or math.MaxInt64 (no limit) outside a memory-limited cgroup
+func autoMemoryLimit() int64 {
+	if limit := cgroupMemory(); limit > 0 {
+		return int64(float64(limit) * cgroupMemoryShare)
+	}
+	return math.MaxInt64
+}
+
+// applyRuntimeTuning sets GOMAXPROCS, GOGC and GOMEMLIMIT from the gomaxprocs,
+// gogc and gomemlimit directives. Unset directives leave the corresponding
+// environment variable in charge, and without one GOMAXPROCS and GOMEMLIMIT
+// are derived from the cgroup limits.
+func (cfg *Config) applyRuntimeTuning() {
+	switch {
+	case cfg.GoMaxProcs > 0:
+		runtime.GOMAXPROCS(cfg.GoMaxProcs)
+	case os.Getenv("GOMAXPROCS") == "":
+		runtime.GOMAXPROCS(autoMaxProcs())
+	}
+	if cfg.GoGC != 0 {
+		debug.SetGCPercent(cfg.GoGC)
+	}
+	switch {
+	case cfg.GoMemLimit > 0:
+		debug.SetMemoryLimit(cfg.GoMemLimit)
+	case cfg.GoMemLimit < 0:
+		debug.SetMemoryLimit(math.MaxInt64)
+	case os.Getenv("GOMEMLIMIT") == "":
+		debug.SetMemoryLimit(autoMemoryLimit())
+	}
+}
+
+// parseGoMaxProcs parses a gomaxprocs value: a positive count, or "auto"
+// (returned as 0) for the cgroup CPU quota
+func parseGoMaxProcs(value string) (int, error) {
+	if strings.EqualFold(value, "auto") {
+		return 0, nil
+	}
+	procs, err := strconv.Atoi(value)
+	if err != nil || procs < 1 {
+		return 0, fmt.Errorf("invalid gomaxprocs '%s'", value)
+	}
+	return procs, nil
+}
+
+// parseGoGC parses a gogc value: a positive percentage, or "off" (returned
+// as -1) to disable the collector
+func parseGoGC(value string) (int, error) {
+	if strings.EqualFold(value, "off") {
+		return -1, nil
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil || percent < 1 {
+		return 0, fmt.Errorf("invalid gogc '%s'", value)
+	}
+	return percent, nil
+}
+
+// parseGoMemLimit parses a gomemlimit value: a size such as 512mb, "off"
+// (returned as -1) for no limit, or "auto" (returned as 0) for a share of
+// the cgroup memory limit
+func parseGoMemLimit(value string) (int64, error) {
+	switch strings.ToLower(value) {
+	case "off":
+		return -1, nil
+	case "auto":
+		return 0, nil
+	}
+	limit, err := parseMemorySize(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid gomemlimit '%s'", value)
+	}
+	return limit, nil
+}
+
+// parseMemorySize parses a size in bytes with an optional unit, as in
+// redis.conf: k, m and g are powers of 1000, kb, mb and gb powers of 1024
+func parseMemorySize(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1},
+	}
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, factor = strings.TrimSuffix(lower, unit.suffix), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/factor {
+		return 0, fmt.Errorf("invalid memory size '%s'", value)
+	}
+	return n * factor, nil
+}
+
+// currentGCPercent returns the GOGC percentage in effect, -1 when the
+// collector is off
+func currentGCPercent() int {
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	return percent
+}
+
+// Runtime parameters of CONFIG GET and CONFIG SET, which report and change
+// the live runtime values
+var runtimeConfigParams = []configParam{
+	{
+		name: "gomaxprocs",
+		get:  func() string { return strconv.Itoa(runtime.GOMAXPROCS(0)) },
+		set: func(value string) error {
+			procs, err := parseGoMaxProcs(value)
+			if err != nil {
+				return err
+			}
+			if procs == 0 {
+				procs = autoMaxProcs()
+			}
+			runtime.GOMAXPROCS(procs)
+			return nil
+		},
+	},
+	{
+		name: "gogc",
+		get: func() string {
+			if percent := currentGCPercent(); percent >= 0 {
+				return strconv.Itoa(percent)
+			}
+			return "off"
+		},
+		set: func(value string) error {
+			percent, err := parseGoGC(value)
+			if err != nil {
+				return err
+			}
+			debug.SetGCPercent(percent)
+			return nil
+		},
+	},
+	{
+		name: "gomemlimit",
+		get: func() string {
+			if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+				return strconv.FormatInt(limit, 10)
+			}
+			return "off"
+		},
+		set: func(value string) error {
+			limit, err := parseGoMemLimit(value)
+			if err != nil {
+				return err
+			}
+			switch {
+			case limit == 0:
+				limit = autoMemoryLimit()
+			case limit < 0:
+				limit = math.MaxInt64
+			}
+			debug.SetMemoryLimit(limit)
+			return nil
+		},
+	},
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestCgroupDefaults(t *testing.T) {
+	saved := cgroupRoot
+	defer func() { cgroupRoot = saved }()
+	write := func(path, content string) {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("expected to write %s, got %v", path, err)
+		}
+	}
+
+	// Test 1: cgroup v2 quotas round up to whole CPUs, and memory keeps headroom
+	cgroupRoot = t.TempDir()
+	write(filepath.Join(cgroupRoot, "cpu.max"), "150000 100000\n")
+	write(filepath.Join(cgroupRoot, "memory.max"), "1073741824\n")
+	if cpus := cgroupCPUs(); cpus != 1.5 {
+		t.Fatalf("expected 1.5 CPUs, got %v", cpus)
+	}
+	if procs := autoMaxProcs(); procs != min(2, runtime.NumCPU()) {
+		t.Fatalf("expected GOMAXPROCS 2, got %d", procs)
+	}
+	if limit := autoMemoryLimit(); limit != 966367641 {
+		t.Fatalf("expected 90%% of 1GB, got %d", limit)
+	}
+
+	// Test 2: Unlimited cgroups fall back to every CPU and no memory limit
+	write(filepath.Join(cgroupRoot, "cpu.max"), "max 100000\n")
+	write(filepath.Join(cgroupRoot, "memory.max"), "max\n")
+	if autoMaxProcs() != runtime.NumCPU() || autoMemoryLimit() != math.MaxInt64 {
+		t.Fatalf("expected no limits from an unlimited cgroup")
+	}
+
+	// Test 3: cgroup v1 files are read when v2 ones are missing
+	cgroupRoot = t.TempDir()
+	write(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_quota_us"), "50000\n")
+	write(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_period_us"), "100000\n")
+	write(filepath.Join(cgroupRoot, "memory", "memory.limit_in_bytes"), "9223372036854771712\n")
+	if cpus := cgroupCPUs(); cpus != 0.5 || autoMaxProcs() != 1 {
+		t.Fatalf("expected half a CPU and GOMAXPROCS 1, got %v", cpus)
+	}
+	if limit := cgroupMemory(); limit != 0 {
+		t.Fatalf("expected the v1 'unlimited' value to mean no limit, got %d", limit)
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	// Test 1: Units follow redis.conf
+	for value, want := range map[string]int64{
+		"100": 100, "1k": 1000, "1kb": 1024, "2MB": 2 << 20, "1g": 1e9, "3gb": 3 << 30, "5b": 5,
+	} {
+		if got, err := parseMemorySize(value); err != nil || got != want {
+			t.Errorf("parseMemorySize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "gb", "-1mb", "1tb", "9999999999gb"} {
+		if _, err := parseMemorySize(value); err == nil {
+			t.Errorf("expected parseMemorySize(%q) to fail", value)
+		}
+	}
+}
+
+func TestConfigRuntimeTuning(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	percent := debug.SetGCPercent(100)
+	limit := debug.SetMemoryLimit(-1)
+	defer func() {
+		runtime.GOMAXPROCS(procs)
+		debug.SetGCPercent(percent)
+		debug.SetMemoryLimit(limit)
+	}()
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: CONFIG SET changes the live runtime values CONFIG GET reports
+	if reply := run("CONFIG", "SET", "gomaxprocs", "1", "gogc", "off", "gomemlimit", "512mb"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if runtime.GOMAXPROCS(0) != 1 || currentGCPercent() != -1 || debug.SetMemoryLimit(-1) != 512<<20 {
+		t.Fatalf("expected the runtime to be reconfigured")
+	}
+	want := "*6\r\n" + formatBulkString("gomaxprocs") + formatBulkString("1") +
+		formatBulkString("gogc") + formatBulkString("off") +
+		formatBulkString("gomemlimit") + formatBulkString("536870912")
+	if reply := run("CONFIG", "GET", "go*"); reply != want {
+		t.Fatalf("unexpected CONFIG GET reply %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"CONFIG", "GET", "gogc"}); reply != "%1\r\n$4\r\ngogc\r\n$3\r\noff\r\n" {
+		t.Fatalf("expected a RESP3 map, got %q", reply)
+	}
+
+	// Test 2: A failing SET leaves every parameter unchanged
+	if reply := run("CONFIG", "SET", "gogc", "50", "gomemlimit", "lots"); !strings.Contains(reply, "invalid gomemlimit") {
+		t.Fatalf("expected an invalid value error, got %q", reply)
+	}
+	if currentGCPercent() != -1 {
+		t.Fatalf("expected gogc to be rolled back, got %d", currentGCPercent())
+	}
+	if reply := run("CONFIG", "SET", "maxclients", "1"); !strings.Contains(reply, "Unknown option") {
+		t.Fatalf("expected an unknown option error, got %q", reply)
+	}
+	if reply := run("CONFIG", "SET", "gogc", "50", "GOGC", "60"); !strings.Contains(reply, "Duplicate parameter") {
+		t.Fatalf("expected a duplicate parameter error, got %q", reply)
+	}
+	if reply := run("CONFIG", "SET", "gogc"); !strings.Contains(reply, "wrong number of arguments") {
+		t.Fatalf("expected an arity error, got %q", reply)
+	}
+
+	// Test 3: Directives are parsed like CONFIG SET values
+	cfg, err := ParseConfig(strings.NewReader("gomaxprocs auto\ngogc 200\ngomemlimit off\n"))
+	if err != nil || cfg.GoMaxProcs != 0 || cfg.GoGC != 200 || cfg.GoMemLimit != -1 {
+		t.Fatalf("unexpected parsed tuning %+v, %v", cfg, err)
+	}
+	cfg.applyRuntimeTuning()
+	if currentGCPercent() != 200 || debug.SetMemoryLimit(-1) != math.MaxInt64 {
+		t.Fatalf("expected the directives to be applied")
+	}
+	if _, err := ParseConfig(strings.NewReader("gogc 0\n")); err == nil {
+		t.Fatalf("expected gogc 0 to be rejected")
+	}
+}
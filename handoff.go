@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Warm restart: with handoff-socket configured, a running server listens on
+// that Unix socket, and a new process started with the same configuration
+// connects to it before binding anything. The exchange is:
+//
+//  1. the successor sends handoffRequest
+//  2. the running server stops accepting connections and sends a
+//     handoffSockets message describing its listening sockets, whose file
+//     descriptors travel with it as SCM_RIGHTS ancillary data
+//  3. it disconnects its clients, waits for their commands to finish, and
+//     streams every database as an RDB file
+//  4. the successor loads the data and replies handoffDone, after which the
+//     old server exits and the successor starts accepting on the sockets
+//
+// The sockets stay bound throughout, so connections made during the handoff
+// wait in the kernel's backlog instead of being refused. If the successor
+// goes away before replying, the old server serves the sockets again.
+
+// First line a successor sends on the handoff socket
+const handoffRequest = "REDISGO TAKEOVER 1\n"
+
+// Reply of a successor that loaded the dataset
+const handoffDone = "DONE\n"
+
+// Largest handoffSockets message accepted
+const handoffMaxMessage = 64 << 10
+
+// How long a successor has to take the sockets and load the dataset before
+// the running server gives up and serves them again (a variable for tests)
+var handoffTimeout = time.Minute
+
+// handoffSocket describes one listening socket handed to a successor
+type handoffSocket struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+}
+
+// handoffServer hands the process over to successors connecting to path
+type handoffServer struct {
+	path   string
+	ln     *net.UnixListener
+	mu     sync.Mutex
+	served []*servedListener // Listeners currently served
+}
+
+// startHandoff listens for successors on path, which may be left over from
+// a previous process
+func startHandoff(path string, served []*servedListener) (*handoffServer, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// Whoever connects gets the listening sockets and the whole dataset
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	h := &handoffServer{path: path, ln: ln, served: served}
+	go h.serve()
+	return h, nil
+}
+
+// serve handles successors one at a time until the listener is closed
+func (h *handoffServer) serve() {
+	for {
+		conn, err := h.ln.AcceptUnix()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("Error accepting connection on handoff socket: %v\n", err)
+			continue
+		}
+		if err := h.handOver(conn); err != nil {
+			fmt.Printf("Handoff failed: %v\n", err)
+			continue
+		}
+		fmt.Println("Handoff complete, exiting")
+		shutdownExit(0)
+		return
+	}
+}
+
+// handOver runs the exchange with one successor. The process keeps serving
+// if an error is returned.
+func (h *handoffServer) handOver(conn *net.UnixConn) error {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	request, err := bufio.NewReader(io.LimitReader(conn, int64(len(handoffRequest)))).ReadString('\n')
+	if err != nil || request != handoffRequest {
+		return errors.New("not a takeover request")
+	}
+	conn.SetReadDeadline(time.Time{})
+	fmt.Println("Takeover requested, handing off listeners and data")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Duplicate the sockets first: stopping the listeners closes their own
+	// descriptors, and these keep the sockets bound
+	files := make([]*os.File, 0, len(h.served))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	sockets := make([]handoffSocket, 0, len(h.served))
+	for _, sl := range h.served {
+		f, err := socketFile(sl.socket)
+		if err != nil {
+			return fmt.Errorf("listener '%s': %v", sl.config.Name, err)
+		}
+		files = append(files, f)
+		sockets = append(sockets, handoffSocket{sl.config.Name, sl.config.Kind, sl.config.Address})
+	}
+	for _, sl := range h.served {
+		if ul, ok := sl.socket.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		sl.closer.Close()
+	}
+
+	if err := h.transfer(conn, sockets, files); err != nil {
+		h.resume(files)
+		return err
+	}
+	return nil
+}
+
+// transfer sends the sockets and the dataset, and waits for the successor
+// to load it, for up to handoffTimeout
+func (h *handoffServer) transfer(conn *net.UnixConn, sockets []handoffSocket, files []*os.File) error {
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	message, _ := json.Marshal(sockets)
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	payload := binary.BigEndian.AppendUint32(nil, uint32(len(message)))
+	if _, _, err := conn.WriteMsgUnix(append(payload, message...), syscall.UnixRights(fds...), nil); err != nil {
+		return err
+	}
+
+	for _, client := range connectedClientList() {
+		client.kill(nil)
+	}
+	deadline := time.Now().Add(shutdownGrace)
+	for connectedClients.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// The successor appends to the append-only file from where it ends
+	flushAOF()
+
+	w := bufio.NewWriter(conn)
+	if err := writeRDB(w, databases); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("the successor did not load the data within %v", handoffTimeout)
+	}
+	if err != nil || reply != handoffDone {
+		return errors.New("the successor did not load the data")
+	}
+	// The handoff socket now belongs to the successor
+	h.ln.SetUnlinkOnClose(false)
+	h.ln.Close()
+	return nil
+}
+
+// resume serves the handed off sockets again after a failed handoff
+func (h *handoffServer) resume(files []*os.File) {
+	for i, sl := range h.served {
+		socket, err := net.FileListener(files[i])
+		if err == nil {
+			var resumed *servedListener
+			if resumed, err = openListener(sl.config, socket); err == nil {
+				h.served[i] = resumed
+				continue
+			}
+		}
+		fmt.Printf("Error resuming listener '%s': %v\n", sl.config.Name, err)
+	}
+}
+
+// socketFile returns a duplicate of the descriptor of a TCP or Unix socket
+func socketFile(socket net.Listener) (*os.File, error) {
+	switch ln := socket.(type) {
+	case *net.TCPListener:
+		return ln.File()
+	case *net.UnixListener:
+		return ln.File()
+	}
+	return nil, errors.New("socket cannot be handed off")
+}
+
+// takeOver takes the sockets and the dataset of the server listening for
+// successors on path, loading the data into dbs. The sockets are returned
+// by listener name, for the listeners whose kind and address are unchanged
+// in listeners; the others are closed. Both results are nil if no server
+// listens on path.
+func takeOver(path string, listeners []ListenerConfig, dbs *Databases) (map[string]net.Listener, error) {
+	addr := &net.UnixAddr{Name: path, Net: "unix"}
+	conn, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, handoffRequest); err != nil {
+		return nil, err
+	}
+
+	sockets, files, rest, err := receiveSockets(conn)
+	if err != nil {
+		return nil, err
+	}
+	inherited := make(map[string]net.Listener, len(files))
+	defer func() {
+		if err != nil {
+			for _, socket := range inherited {
+				socket.Close()
+			}
+		}
+	}()
+	for i, f := range files {
+		socket, fileErr := net.FileListener(f)
+		f.Close()
+		wanted := slices.ContainsFunc(listeners, func(lc ListenerConfig) bool {
+			return lc.Name == sockets[i].Name && lc.Kind == sockets[i].Kind && lc.Address == sockets[i].Address
+		})
+		switch {
+		case fileErr != nil:
+			err = fileErr
+		case wanted && err == nil:
+			inherited[sockets[i].Name] = socket
+		default:
+			socket.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = readRDB(io.MultiReader(bytes.NewReader(rest), conn), func(db int, key string, entry *Entry) error {
+		if db >= dbs.Count() {
+			return fmt.Errorf("the data has database %d but only %d are configured", db, dbs.Count())
+		}
+		dbs.Get(db).Load(key, entry)
+		return nil
+	}, restoreLibrary)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(conn, handoffDone); err != nil {
+		return nil, err
+	}
+	return inherited, nil
+}
+
+// receiveSockets reads the handoffSockets message and the descriptors sent
+// with it. rest holds the data read past the message.
+func receiveSockets(conn *net.UnixConn) (sockets []handoffSocket, files []*os.File, rest []byte, err error) {
+	buf := make([]byte, handoffMaxMessage)
+	oob := make([]byte, syscall.CmsgSpace(64*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, m := range messages {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+	fail := func(err error) ([]handoffSocket, []*os.File, []byte, error) {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, nil, nil, err
+	}
+
+	// The message may arrive in several reads, though the descriptors come
+	// with its first byte
+	for n < 4 {
+		m, err := conn.Read(buf[n:])
+		if err != nil {
+			return fail(err)
+		}
+		n += m
+	}
+	size := int(binary.BigEndian.Uint32(buf))
+	if size > len(buf)-4 {
+		return fail(errors.New("handoff message too large"))
+	}
+	for n < 4+size {
+		m, err := conn.Read(buf[n:])
+		if err != nil {
+			return fail(err)
+		}
+		n += m
+	}
+	if err := json.Unmarshal(buf[4:4+size], &sockets); err != nil || len(sockets) != len(files) {
+		return fail(errors.New("malformed handoff message"))
+	}
+	return sockets, files, buf[4+size : n], nil
+}
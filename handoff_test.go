@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	saved, savedExit := databases, shutdownExit
+	databases = NewDatabases(2)
+	exited := make(chan int, 1)
+	shutdownExit = func(code int) { exited <- code }
+	defer func() { databases, shutdownExit = saved, savedExit }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("SET", "s", "v", "PX", "60000")
+	run("RPUSH", "l", "a", "b")
+	run("SADD", "set", "1", "2")
+	run("HSET", "h", "f", "v")
+	run("ZADD", "z", "2", "b", "1", "a")
+	run("SELECT", "1")
+	run("SET", "other", "x")
+	deadline := run("PEXPIRETIME", "s")
+
+	lc := ListenerConfig{Name: "main", Kind: listenerTCP, Address: "127.0.0.1:0"}
+	sl, err := openListener(lc, nil)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := sl.socket.Addr().String()
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	if _, err := startHandoff(path, []*servedListener{sl}); err != nil {
+		t.Fatalf("handoff socket: %v", err)
+	}
+	ping := func() (net.Conn, string) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "PING\r\n")
+		reply, _ := bufio.NewReader(conn).ReadString('\n')
+		return conn, reply
+	}
+
+	// Test 1: Without a server on the socket there is nothing to take over
+	if inherited, err := takeOver(filepath.Join(t.TempDir(), "none.sock"), nil, NewDatabases(1)); inherited != nil || err != nil {
+		t.Fatalf("expected nothing to take over, got %v, %v", inherited, err)
+	}
+
+	// Test 2: The successor gets the data with its TTLs, and clients are disconnected
+	conn, reply := ping()
+	if reply != "+PONG\r\n" {
+		t.Fatalf("expected PONG, got %q", reply)
+	}
+	successor := NewDatabases(2)
+	inherited, err := takeOver(path, []ListenerConfig{lc}, successor)
+	if err != nil {
+		t.Fatalf("takeover: %v", err)
+	}
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Fatalf("expected the old server to exit with 0, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the old server to exit")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the client to be disconnected")
+	}
+	databases = successor
+	moved := &Client{}
+	for args, want := range map[string]string{
+		"PEXPIRETIME s":     deadline,
+		"LRANGE l 0 -1":     formatArray([]string{"a", "b"}),
+		"SISMEMBER set 2":   ":1\r\n",
+		"HGET h f":          "$1\r\nv\r\n",
+		"ZSCAN z 0":         "*2\r\n$1\r\n0\r\n" + formatArray([]string{"a", "1", "b", "2"}),
+		"OBJECT ENCODING l": "$8\r\nlistpack\r\n",
+	} {
+		if reply := moved.execute(strings.Fields(args)); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	if _, exists, _ := successor.Get(1).Get("other"); !exists {
+		t.Fatalf("expected database 1 to move over")
+	}
+
+	// Test 3: The successor serves the inherited socket at the same address
+	socket := inherited["main"]
+	if socket == nil || socket.Addr().String() != address {
+		t.Fatalf("expected the socket on %s, got %v", address, inherited)
+	}
+	next, err := openListener(lc, socket)
+	if err != nil {
+		t.Fatalf("serve inherited socket: %v", err)
+	}
+	if _, reply := ping(); reply != "+PONG\r\n" {
+		t.Fatalf("expected the successor to answer, got %q", reply)
+	}
+
+	// Test 4: A successor that goes away before loading leaves the old server serving
+	if _, err := startHandoff(path, []*servedListener{next}); err != nil {
+		t.Fatalf("handoff socket: %v", err)
+	}
+	defer func() { next.closer.Close() }()
+	handoff, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("dial handoff socket: %v", err)
+	}
+	io.WriteString(handoff, handoffRequest)
+	_, files, _, err := receiveSockets(handoff)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one socket, got %d, %v", len(files), err)
+	}
+	files[0].Close()
+	handoff.Close()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, reply := ping(); reply == "+PONG\r\n" {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected the old server to resume")
+		}
+	}
+	select {
+	case <-exited:
+		t.Fatalf("expected the old server to keep running")
+	default:
+	}
+
+	// Test 5: So does a successor that never reports the data loaded
+	savedTimeout := handoffTimeout
+	handoffTimeout = 100 * time.Millisecond
+	defer func() { handoffTimeout = savedTimeout }()
+	handoff, err = net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("dial handoff socket: %v", err)
+	}
+	defer handoff.Close()
+	io.WriteString(handoff, handoffRequest)
+	_, files, _, err = receiveSockets(handoff)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one socket, got %d, %v", len(files), err)
+	}
+	files[0].Close()
+	go io.Copy(io.Discard, handoff)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, reply := ping(); reply == "+PONG\r\n" {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected the old server to resume after the timeout")
+		}
+	}
+
+	// Test 6: Only the owner may connect to the handoff socket
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat handoff socket: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the handoff socket to be 0600, got %v", info.Mode().Perm())
+	}
+}
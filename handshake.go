@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Connections accepted that have not completed their handshake yet: sent
+// their first command or, on listeners with a password, authenticated
+var pendingHandshakes atomic.Int64
+
+// Connections refused or closed for not completing the handshake
+var rejectedConnections atomic.Int64
+
+// admitConnection decides whether a newly accepted connection is served
+// Refused connections get an error reply and are closed.
+func admitConnection(conn net.Conn) bool {
+	reason := ""
+	switch {
+	case connectedClients.Load() >= int64(config.MaxClients):
+		reason = "ERR max number of clients reached"
+	case config.MaxPendingHandshake > 0 && pendingHandshakes.Load() >= int64(config.MaxPendingHandshake):
+		// Slow-open floods fill this up; established clients are unaffected
+		reason = "ERR too many connections waiting to send their first command"
+	}
+	if reason != "" {
+		rejectedConnections.Add(1)
+		conn.Write([]byte(formatError(reason)))
+		conn.Close()
+		return false
+	}
+	pendingHandshakes.Add(1)
+	return true
+}
+
+// beginHandshake starts the handshake deadline of a newly admitted
+// connection: it must send a complete first command, or authenticate if its
+// listener has a password, within handshake-timeout
+func (c *Client) beginHandshake() {
+	c.handshake = true
+	if config.HandshakeTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout))
+	}
+}
+
+// advanceHandshake completes the handshake once a command arrived, unless
+// the listener has a password the client has not authenticated with yet, so
+// sending commands that fail with NOAUTH does not leave the pending pool.
+// Called before each command and after it, for AUTH and HELLO AUTH.
+func (c *Client) advanceHandshake() {
+	if c.policy == nil || c.policy.password == "" || c.authenticated {
+		c.completeHandshake()
+	}
+}
+
+// completeHandshake lifts the handshake deadline once the handshake is
+// complete, or when the connection ends before that. Safe to call repeatedly.
+func (c *Client) completeHandshake() {
+	if !c.handshake {
+		return
+	}
+	c.handshake = false
+	pendingHandshakes.Add(-1)
+	if config.HandshakeTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Time{}); err != nil {
+			fmt.Printf("Error clearing handshake deadline: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	config.HandshakeTimeout = 50 * time.Millisecond
+	defer func() { config.HandshakeTimeout = 0 }()
+
+	// Test 1: A connection that sends nothing is closed after the timeout
+	server, peer := net.Pipe()
+	if !admitConnection(server) {
+		t.Fatalf("expected the connection to be admitted")
+	}
+	done := make(chan struct{})
+	go func() { handleConnection(server, nil); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the idle connection to be closed")
+	}
+	peer.Close()
+	if n := pendingHandshakes.Load(); n != 0 {
+		t.Fatalf("expected no pending handshakes after close, got %d", n)
+	}
+
+	// Test 2: Once the first command arrives the deadline no longer applies
+	server, peer = net.Pipe()
+	defer peer.Close()
+	admitConnection(server)
+	go handleConnection(server, nil)
+	reader := bufio.NewReader(peer)
+	peer.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("expected PONG, got %q", line)
+	}
+	if n := pendingHandshakes.Load(); n != 0 {
+		t.Fatalf("expected the handshake to be complete, got %d pending", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	peer.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("expected the connection to outlive the timeout, got %q", line)
+	}
+}
+
+func TestMaxPendingHandshakes(t *testing.T) {
+	config.MaxPendingHandshake = 1
+	defer func() { config.MaxPendingHandshake = 0 }()
+
+	// Test 1: Connections beyond the cap are refused with an error
+	first, firstPeer := net.Pipe()
+	defer firstPeer.Close()
+	if !admitConnection(first) {
+		t.Fatalf("expected the first connection to be admitted")
+	}
+	go handleConnection(first, nil)
+
+	second, secondPeer := net.Pipe()
+	reply := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(secondPeer).ReadString('\n')
+		reply <- line
+	}()
+	if admitConnection(second) {
+		t.Fatalf("expected the second connection to be refused")
+	}
+	if line := <-reply; line != "-ERR too many connections waiting to send their first command\r\n" {
+		t.Fatalf("unexpected refusal reply %q", line)
+	}
+
+	// Test 2: The slot is released once the pending client sends a command
+	reader := bufio.NewReader(firstPeer)
+	firstPeer.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("expected PONG, got %q", line)
+	}
+	third, thirdPeer := net.Pipe()
+	if !admitConnection(third) {
+		t.Fatalf("expected a new connection to be admitted")
+	}
+	go handleConnection(third, nil)
+	thirdPeer.Close()
+
+	// Test 3: Behind a password, only authenticating releases the slot
+	locked := ListenerConfig{Name: "locked", Password: "pw"}
+	for pendingHandshakes.Load() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fourth, fourthPeer := net.Pipe()
+	defer fourthPeer.Close()
+	if !admitConnection(fourth) {
+		t.Fatalf("expected a new connection to be admitted")
+	}
+	go handleConnection(fourth, locked.policy())
+	reader = bufio.NewReader(fourthPeer)
+	fourthPeer.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "-NOAUTH Authentication required.\r\n" {
+		t.Fatalf("expected NOAUTH, got %q", line)
+	}
+	if n := pendingHandshakes.Load(); n != 1 {
+		t.Fatalf("expected the unauthenticated client to stay pending, got %d", n)
+	}
+	fourthPeer.Write([]byte("*2\r\n$4\r\nAUTH\r\n$2\r\npw\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+OK\r\n" {
+		t.Fatalf("expected AUTH to succeed, got %q", line)
+	}
+	if n := pendingHandshakes.Load(); n != 0 {
+		t.Fatalf("expected AUTH to complete the handshake, got %d pending", n)
+	}
+}
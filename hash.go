@@ -0,0 +1,510 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// hash returns the hash stored at key. The hash is nil if the key does
+// not exist; ok is false if the key holds another type.
+// Callers must hold the lock.
+func (s *Store) hash(key string) (hashValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeHash {
+		return nil, false
+	}
+	return asHash(entry.Value), true
+}
+
+// mutableHash is like hash for callers about to change the hash in place:
+// a hash borrowed by readers outside the lock is copied first
+// Callers must hold the write lock.
+func (s *Store) mutableHash(key string) (hashValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeHash {
+		return nil, false
+	}
+	entry.own()
+	return asHash(entry.Value), true
+}
+
+// hashModified must be called after changing the hash at key in place, with
+// the hash as setField returned it; an emptied hash is removed, as Redis
+// never keeps empty hashes
+// Callers must hold the write lock.
+func (s *Store) hashModified(key string, hash hashValue) {
+	if hash.len() == 0 {
+		s.data.delete(key)
+	} else {
+		s.data.lookup(key).Value = hashStorage(hash)
+	}
+	s.keyModified(key)
+}
+
+// HSet sets field/value pairs of the hash at key, creating it if needed
+// Returns (fields created, isCorrectType)
+func (s *Store) HSet(key string, pairs ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.mutableHash(key)
+	if !ok {
+		return 0, false
+	}
+	created := hash == nil
+	if created {
+		hash = s.newHash(len(pairs) / 2)
+	}
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		var isNew bool
+		if hash, isNew = s.setField(hash, pairs[i], pairs[i+1]); isNew {
+			added++
+		}
+	}
+	if created {
+		s.put(key, &Entry{Type: TypeHash, Value: hashStorage(hash)})
+	} else {
+		s.hashModified(key, hash)
+	}
+	return added, true
+}
+
+// HGet returns the value of field in the hash at key
+// Returns (value, found, isCorrectType)
+func (s *Store) HGet(key, field string) (string, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, ok := s.hash(key)
+	if !ok || hash == nil {
+		return "", false, ok
+	}
+	value, found := hash.get(field)
+	return value, found, true
+}
+
+// HDel removes fields from the hash at key
+// Returns (fields removed, isCorrectType)
+func (s *Store) HDel(key string, fields ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.mutableHash(key)
+	if !ok || hash == nil {
+		return 0, ok
+	}
+	removed := 0
+	for _, field := range fields {
+		if hash.remove(field) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.hashModified(key, hash)
+	}
+	return removed, true
+}
+
+// HGetAll returns every field of the hash at key followed by its value
+// Returns (field/value pairs, isCorrectType)
+func (s *Store) HGetAll(key string) ([]string, bool) {
+	s.mu.RLock()
+	hash, ok := s.hash(key)
+	if !ok {
+		s.mu.RUnlock()
+		return nil, false
+	}
+	if hash == nil || hash.len() < cowMinElements {
+		defer s.mu.RUnlock()
+		return hashPairs(hash), true
+	}
+
+	// Copy big hashes without holding up writers
+	share := s.data.lookup(key).borrow()
+	s.mu.RUnlock()
+	defer share.release()
+	return hashPairs(hash), true
+}
+
+// hashPairs returns the fields of hash each followed by its value, in iteration order
+func hashPairs(hash hashValue) []string {
+	if hash == nil {
+		return []string{}
+	}
+	pairs := make([]string, 0, 2*hash.len())
+	hash.each(func(field, value string) bool {
+		pairs = append(pairs, field, value)
+		return true
+	})
+	return pairs
+}
+
+// HLen returns the number of fields of the hash at key, 0 if it does not exist
+// Returns (field count, isCorrectType)
+func (s *Store) HLen(key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, ok := s.hash(key)
+	if hash == nil {
+		return 0, ok
+	}
+	return hash.len(), true
+}
+
+// HMGet returns the values of fields in the hash at key, nil for missing fields
+// Returns (values, isCorrectType)
+func (s *Store) HMGet(key string, fields ...string) ([]*string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, ok := s.hash(key)
+	if !ok {
+		return nil, false
+	}
+	values := make([]*string, len(fields))
+	if hash == nil {
+		return values, true
+	}
+	for i, field := range fields {
+		if value, found := hash.get(field); found {
+			values[i] = &value
+		}
+	}
+	return values, true
+}
+
+// HSetNX sets field of the hash at key only if the field does not exist yet
+// Returns (whether the field was set, isCorrectType)
+func (s *Store) HSetNX(key, field, value string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.hash(key)
+	if !ok {
+		return false, false
+	}
+	if hash == nil {
+		hash, _ = s.setField(s.newHash(1), field, value)
+		s.put(key, &Entry{Type: TypeHash, Value: hashStorage(hash)})
+		return true, true
+	}
+	if _, exists := hash.get(field); exists {
+		return false, true
+	}
+	hash, _ = s.mutableHash(key)
+	hash, _ = s.setField(hash, field, value)
+	s.hashModified(key, hash)
+	return true, true
+}
+
+// HIncrBy adds delta to the integer stored in field of the hash at key,
+// starting from 0 if the field or the hash does not exist
+// Returns the new value, or an error message
+func (s *Store) HIncrBy(key, field string, delta int64) (int64, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.mutableHash(key)
+	if !ok {
+		return 0, wrongTypeError
+	}
+	created := hash == nil
+	if created {
+		hash = s.newHash(1)
+	}
+	var current int64
+	if value, exists := hash.get(field); exists {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, "ERR hash value is not an integer"
+		}
+		current = parsed
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, "ERR increment or decrement would overflow"
+	}
+	current += delta
+
+	hash, _ = s.setField(hash, field, strconv.FormatInt(current, 10))
+	if created {
+		s.put(key, &Entry{Type: TypeHash, Value: hashStorage(hash)})
+	} else {
+		s.hashModified(key, hash)
+	}
+	return current, ""
+}
+
+// randomFields picks count fields of hash. With distinct, each field is
+// picked at most once, so fewer than count may be returned; otherwise
+// fields may repeat and exactly count are returned.
+func randomFields(hash hashValue, count int, distinct bool) []string {
+	if count == 0 || hash == nil || hash.len() == 0 {
+		return []string{}
+	}
+	if distinct {
+		// Reservoir sampling keeps every subset of count fields equally likely
+		picked := make([]string, 0, min(count, hash.len()))
+		seen := 0
+		hash.each(func(field, _ string) bool {
+			if seen < count {
+				picked = append(picked, field)
+			} else if j := rand.IntN(seen + 1); j < count {
+				picked[j] = field
+			}
+			seen++
+			return true
+		})
+		rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		return picked
+	}
+	// count comes from the client: grow the reply rather than trusting it
+	fields := hashColumn(hash, func(field, _ string) string { return field })
+	picked := make([]string, 0, min(count, len(fields)))
+	for range count {
+		picked = append(picked, fields[rand.IntN(len(fields))])
+	}
+	return picked
+}
+
+// HRandField returns random fields of the hash at key, each followed by its
+// value if withValues is set; see randomFields for count and distinct
+// Returns (fields or field/value pairs, isCorrectType)
+func (s *Store) HRandField(key string, count int, distinct, withValues bool) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, ok := s.hash(key)
+	if !ok {
+		return nil, false
+	}
+	fields := randomFields(hash, count, distinct)
+	if !withValues {
+		return fields, true
+	}
+	pairs := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		value, _ := hash.get(field)
+		pairs = append(pairs, field, value)
+	}
+	return pairs, true
+}
+
+// HKeys returns the fields of the hash at key
+// Returns (fields, isCorrectType)
+func (s *Store) HKeys(key string) ([]string, bool) {
+	return s.hashColumn(key, func(field, _ string) string { return field })
+}
+
+// HVals returns the values of the hash at key
+// Returns (values, isCorrectType)
+func (s *Store) HVals(key string) ([]string, bool) {
+	return s.hashColumn(key, func(_, value string) string { return value })
+}
+
+// hashColumn returns pick(field, value) for every field of the hash at key,
+// copying big hashes outside the lock as HGetAll does
+func (s *Store) hashColumn(key string, pick func(field, value string) string) ([]string, bool) {
+	s.mu.RLock()
+	hash, ok := s.hash(key)
+	if !ok {
+		s.mu.RUnlock()
+		return nil, false
+	}
+	if hash == nil || hash.len() < cowMinElements {
+		defer s.mu.RUnlock()
+		return hashColumn(hash, pick), true
+	}
+
+	share := s.data.lookup(key).borrow()
+	s.mu.RUnlock()
+	defer share.release()
+	return hashColumn(hash, pick), true
+}
+
+func hashColumn(hash hashValue, pick func(field, value string) string) []string {
+	if hash == nil {
+		return []string{}
+	}
+	column := make([]string, 0, hash.len())
+	hash.each(func(field, value string) bool {
+		column = append(column, pick(field, value))
+		return true
+	})
+	return column
+}
+
+// HSET key field value [field value ...]
+func hsetCommand(c *Client, args []string) string {
+	if len(args)%2 != 0 {
+		return formatError("ERR wrong number of arguments for 'hset' command")
+	}
+	added, ok := c.db().HSet(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(added)
+}
+
+// HGET key field
+func hgetCommand(c *Client, args []string) string {
+	value, found, ok := c.db().HGet(args[1], args[2])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !found {
+		return formatNullBulkString()
+	}
+	return formatBulkString(value)
+}
+
+// HDEL key field [field ...]
+func hdelCommand(c *Client, args []string) string {
+	removed, ok := c.db().HDel(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(removed)
+}
+
+// HGETALL key
+func hgetallCommand(c *Client, args []string) string {
+	pairs, ok := c.db().HGetAll(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = formatBulkString(p)
+	}
+	return c.formatMap(encoded)
+}
+
+// HLEN key
+func hlenCommand(c *Client, args []string) string {
+	n, ok := c.db().HLen(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// HEXISTS key field
+func hexistsCommand(c *Client, args []string) string {
+	_, found, ok := c.db().HGet(args[1], args[2])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatMembership(found)
+}
+
+// HMGET key field [field ...]
+func hmgetCommand(c *Client, args []string) string {
+	values, ok := c.db().HMGet(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatNullableArray(values)
+}
+
+// HSETNX key field value
+func hsetnxCommand(c *Client, args []string) string {
+	set, ok := c.db().HSetNX(args[1], args[2], args[3])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatMembership(set)
+}
+
+// HKEYS key
+func hkeysCommand(c *Client, args []string) string {
+	fields, ok := c.db().HKeys(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatArray(fields)
+}
+
+// HVALS key
+func hvalsCommand(c *Client, args []string) string {
+	values, ok := c.db().HVals(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatArray(values)
+}
+
+// HSTRLEN key field
+func hstrlenCommand(c *Client, args []string) string {
+	value, _, ok := c.db().HGet(args[1], args[2])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(len(value))
+}
+
+// HINCRBY key field increment
+func hincrbyCommand(c *Client, args []string) string {
+	delta, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	value, errMsg := c.db().HIncrBy(args[1], args[2], delta)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	return formatInteger(int(value))
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+func hrandfieldCommand(c *Client, args []string) string {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHVALUES")) {
+		return formatError("ERR syntax error")
+	}
+	if len(args) == 2 {
+		picked, ok := c.db().HRandField(args[1], 1, true, false)
+		if !ok {
+			return formatError(wrongTypeError)
+		}
+		if len(picked) == 0 {
+			return formatNullBulkString()
+		}
+		return formatBulkString(picked[0])
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if count < -math.MaxInt64/2 {
+		return formatError("ERR value is out of range")
+	}
+	// A negative count allows the same field to be returned several times
+	distinct := count >= 0
+	if !distinct {
+		count = -count
+	}
+	withValues := len(args) == 4
+	picked, ok := c.db().HRandField(args[1], count, distinct, withValues)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !withValues || !c.resp3 {
+		return formatArray(picked)
+	}
+	// RESP3 replies pair each field with its value
+	pairs := make([]string, 0, len(picked)/2)
+	for i := 0; i < len(picked); i += 2 {
+		pairs = append(pairs, formatArray(picked[i:i+2]))
+	}
+	return "*" + strconv.Itoa(len(pairs)) + "\r\n" + strings.Join(pairs, "")
+}
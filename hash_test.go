@@ -0,0 +1,264 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: HSET counts only created fields and overwrites existing ones
+	if reply := run("HSET", "h", "name", "ann", "age", "7"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+	if reply := run("HSET", "h", "age", "8", "city", "Oslo"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := run("HGET", "h", "age"); reply != "$1\r\n8\r\n" {
+		t.Fatalf("expected the new value, got %q", reply)
+	}
+	if reply := run("HGET", "h", "nope"); reply != "$-1\r\n" {
+		t.Fatalf("expected a null reply, got %q", reply)
+	}
+	if reply := run("HSET", "h", "odd"); !strings.Contains(reply, "wrong number of arguments") {
+		t.Fatalf("expected an arity error, got %q", reply)
+	}
+
+	// Test 2: HLEN, HEXISTS and HGETALL
+	if reply := run("HLEN", "h"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+	if reply := run("HEXISTS", "h", "city"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := run("HEXISTS", "missing", "city"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	pairs, _ := databases.Get(0).HGetAll("h")
+	got := make(map[string]string)
+	for i := 0; i < len(pairs); i += 2 {
+		got[pairs[i]] = pairs[i+1]
+	}
+	if len(got) != 3 || got["name"] != "ann" || got["city"] != "Oslo" {
+		t.Fatalf("unexpected HGETALL pairs %v", pairs)
+	}
+	if reply := run("HGETALL", "missing"); reply != "*0\r\n" {
+		t.Fatalf("expected an empty array, got %q", reply)
+	}
+	resp3 := &Client{resp3: true}
+	run("HSET", "one", "f", "v")
+	if reply := resp3.execute([]string{"HGETALL", "one"}); reply != "%1\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Fatalf("expected a RESP3 map, got %q", reply)
+	}
+
+	// Test 3: Deleting the last field removes the key
+	if reply := run("HDEL", "h", "name", "age", "nope"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+	run("HDEL", "h", "city")
+	if _, exists := databases.Get(0).KeyType("h"); exists {
+		t.Fatalf("expected the empty hash to be deleted")
+	}
+
+	// Test 4: WRONGTYPE on non-hash keys
+	run("SET", "str", "v")
+	for _, args := range [][]string{{"HSET", "str", "f", "v"}, {"HGET", "str", "f"}, {"HDEL", "str", "f"}, {"HGETALL", "str"}, {"HLEN", "str"}, {"HEXISTS", "str", "f"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+	if kind, _ := databases.Get(0).KeyType("one"); kind != TypeHash {
+		t.Fatalf("expected a hash, got %s", kind)
+	}
+}
+
+func TestHashReads(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("HSET", "h", "a", "1", "b", "hello")
+
+	// Test 1: HMGET returns nil for missing fields and keys
+	if reply := run("HMGET", "h", "a", "nope", "b"); reply != "*3\r\n$1\r\n1\r\n$-1\r\n$5\r\nhello\r\n" {
+		t.Fatalf("unexpected HMGET reply %q", reply)
+	}
+	if reply := run("HMGET", "missing", "a"); reply != "*1\r\n$-1\r\n" {
+		t.Fatalf("expected a nil for a missing key, got %q", reply)
+	}
+
+	// Test 2: HSETNX only sets new fields, creating the hash if needed
+	if reply := run("HSETNX", "h", "a", "changed"); reply != ":0\r\n" {
+		t.Fatalf("expected :0 for an existing field, got %q", reply)
+	}
+	if reply := run("HSETNX", "h", "c", "3"); reply != ":1\r\n" {
+		t.Fatalf("expected :1 for a new field, got %q", reply)
+	}
+	if reply := run("HSETNX", "fresh", "f", "v"); reply != ":1\r\n" {
+		t.Fatalf("expected :1 on a missing key, got %q", reply)
+	}
+	if reply := run("HGET", "h", "a"); reply != "$1\r\n1\r\n" {
+		t.Fatalf("expected HSETNX to keep the old value, got %q", reply)
+	}
+
+	// Test 3: HKEYS and HVALS list fields and values
+	db := databases.Get(0)
+	keys, _ := db.HKeys("h")
+	values, _ := db.HVals("h")
+	sort.Strings(keys)
+	sort.Strings(values)
+	if strings.Join(keys, ",") != "a,b,c" || strings.Join(values, ",") != "1,3,hello" {
+		t.Fatalf("unexpected HKEYS %v / HVALS %v", keys, values)
+	}
+	if reply := run("HKEYS", "missing"); reply != "*0\r\n" {
+		t.Fatalf("expected an empty array, got %q", reply)
+	}
+
+	// Test 4: HSTRLEN is 0 for missing fields
+	if reply := run("HSTRLEN", "h", "b"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("HSTRLEN", "h", "nope"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+
+	// Test 5: WRONGTYPE on non-hash keys
+	run("SET", "str", "v")
+	for _, args := range [][]string{{"HMGET", "str", "f"}, {"HSETNX", "str", "f", "v"}, {"HKEYS", "str"}, {"HVALS", "str"}, {"HSTRLEN", "str", "f"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+}
+
+func TestHashIncrBy(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: Missing hashes and fields start from 0
+	if reply := run("HINCRBY", "counters", "views", "5"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("HINCRBY", "counters", "views", "-7"); reply != ":-2\r\n" {
+		t.Fatalf("expected :-2, got %q", reply)
+	}
+	if reply := run("HGET", "counters", "views"); reply != "$2\r\n-2\r\n" {
+		t.Fatalf("expected the stored value to be -2, got %q", reply)
+	}
+
+	// Test 2: Non-integer fields and increments are errors
+	run("HSET", "counters", "name", "ann", "big", "9223372036854775807")
+	if reply := run("HINCRBY", "counters", "name", "1"); reply != "-ERR hash value is not an integer\r\n" {
+		t.Fatalf("expected a non-integer error, got %q", reply)
+	}
+	if reply := run("HINCRBY", "counters", "views", "1.5"); reply != "-ERR value is not an integer or out of range\r\n" {
+		t.Fatalf("expected an increment error, got %q", reply)
+	}
+
+	// Test 3: Overflow is refused and leaves the value unchanged
+	if reply := run("HINCRBY", "counters", "big", "1"); reply != "-ERR increment or decrement would overflow\r\n" {
+		t.Fatalf("expected an overflow error, got %q", reply)
+	}
+	run("HSET", "counters", "small", "-9223372036854775808")
+	if reply := run("HINCRBY", "counters", "small", "-1"); !strings.Contains(reply, "overflow") {
+		t.Fatalf("expected an underflow error, got %q", reply)
+	}
+	if reply := run("HGET", "counters", "big"); reply != "$19\r\n9223372036854775807\r\n" {
+		t.Fatalf("expected the value to be unchanged, got %q", reply)
+	}
+
+	// Test 4: WRONGTYPE on non-hash keys
+	run("SET", "str", "v")
+	if reply := run("HINCRBY", "str", "f", "1"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
+
+func TestHashRandField(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	db := databases.Get(0)
+	run("HSET", "h", "a", "1", "b", "2", "c", "3")
+
+	// Test 1: Without a count a single field is returned, nil for a missing key
+	if reply := run("HRANDFIELD", "h"); reply != "$1\r\na\r\n" && reply != "$1\r\nb\r\n" && reply != "$1\r\nc\r\n" {
+		t.Fatalf("unexpected single field %q", reply)
+	}
+	if reply := run("HRANDFIELD", "missing"); reply != "$-1\r\n" {
+		t.Fatalf("expected a nil reply, got %q", reply)
+	}
+	if reply := run("HRANDFIELD", "missing", "3"); reply != "*0\r\n" {
+		t.Fatalf("expected an empty array, got %q", reply)
+	}
+
+	// Test 2: A positive count returns distinct fields, at most all of them
+	fields, _ := db.HRandField("h", 2, true, false)
+	if len(fields) != 2 || fields[0] == fields[1] {
+		t.Fatalf("expected 2 distinct fields, got %v", fields)
+	}
+	fields, _ = db.HRandField("h", 10, true, false)
+	sort.Strings(fields)
+	if strings.Join(fields, ",") != "a,b,c" {
+		t.Fatalf("expected every field once, got %v", fields)
+	}
+
+	// Test 3: A negative count may repeat fields and returns exactly that many
+	if reply := run("HRANDFIELD", "h", "-10"); !strings.HasPrefix(reply, "*10\r\n") {
+		t.Fatalf("expected 10 fields, got %q", reply)
+	}
+	for _, count := range []string{"-9223372036854775808", "-4611686018427387904"} {
+		if reply := run("HRANDFIELD", "h", count, "WITHVALUES"); reply != "-ERR value is out of range\r\n" {
+			t.Fatalf("expected count %s to be out of range, got %q", count, reply)
+		}
+	}
+
+	// Test 4: WITHVALUES pairs fields with their values, nested in RESP3
+	pairs, _ := db.HRandField("h", 5, false, true)
+	for i := 0; i < len(pairs); i += 2 {
+		if want := map[string]string{"a": "1", "b": "2", "c": "3"}[pairs[i]]; pairs[i+1] != want {
+			t.Fatalf("expected field %s to carry %s, got %s", pairs[i], want, pairs[i+1])
+		}
+	}
+	run("HSET", "one", "f", "v")
+	if reply := run("HRANDFIELD", "one", "1", "WITHVALUES"); reply != "*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Fatalf("unexpected RESP2 reply %q", reply)
+	}
+	resp3 := &Client{resp3: true}
+	if reply := resp3.execute([]string{"HRANDFIELD", "one", "1", "WITHVALUES"}); reply != "*1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Fatalf("unexpected RESP3 reply %q", reply)
+	}
+	if reply := run("HRANDFIELD", "one", "1", "VALUES"); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected a syntax error, got %q", reply)
+	}
+
+	// Test 5: WRONGTYPE on non-hash keys
+	run("SET", "str", "v")
+	if reply := run("HRANDFIELD", "str", "1"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Hash encoding limits, as Redis's hash-max-listpack-entries and
+// hash-max-listpack-value defaults
+const (
+	defaultHashListpackEntries = 128
+	defaultHashListpackValue   = 64
+)
+
+// hashValue is the representation of a hash value: a listpack while the
+// hash has few, short fields and values, a hash table otherwise
+type hashValue interface {
+	len() int
+	get(field string) (string, bool)
+	set(field, value string) bool // Reports whether field was created
+	remove(field string) bool
+	each(fn func(field, value string) bool)
+}
+
+// hashTable is the hash table encoding, stored in entries as a plain
+// map[string]string; converting between the two shares the same map
+type hashTable map[string]string
+
+func (ht hashTable) len() int {
+	return len(ht)
+}
+
+func (ht hashTable) get(field string) (string, bool) {
+	value, found := ht[field]
+	return value, found
+}
+
+func (ht hashTable) set(field, value string) bool {
+	_, exists := ht[field]
+	ht[field] = value
+	return !exists
+}
+
+func (ht hashTable) remove(field string) bool {
+	if _, exists := ht[field]; !exists {
+		return false
+	}
+	delete(ht, field)
+	return true
+}
+
+func (ht hashTable) each(fn func(field, value string) bool) {
+	for field, value := range ht {
+		if !fn(field, value) {
+			return
+		}
+	}
+}
+
+// hashPack is the compact encoding of a small hash: a listpack of fields
+// each followed by its value, in insertion order and searched linearly
+type hashPack listpack
+
+// pairs returns the listpack holding the fields and values
+func (hp *hashPack) pairs() *listpack {
+	return (*listpack)(hp)
+}
+
+func (hp *hashPack) len() int {
+	return hp.size / 2
+}
+
+// find returns the offset of field in the listpack, or -1
+func (hp *hashPack) find(field string) int {
+	lp := hp.pairs()
+	for off := 0; off < len(lp.buf); off = lp.next(lp.next(off)) {
+		n, k := binary.Uvarint(lp.buf[off:])
+		if start := off + k; string(lp.buf[start:start+int(n)]) == field {
+			return off
+		}
+	}
+	return -1
+}
+
+func (hp *hashPack) get(field string) (string, bool) {
+	off := hp.find(field)
+	if off < 0 {
+		return "", false
+	}
+	lp := hp.pairs()
+	value, _ := lp.entryAt(lp.next(off))
+	return value, true
+}
+
+func (hp *hashPack) set(field, value string) bool {
+	lp := hp.pairs()
+	off := hp.find(field)
+	if off < 0 {
+		lp.pushBack(field)
+		lp.pushBack(value)
+		return true
+	}
+	valueOff := lp.next(off)
+	lp.buf = slices.Replace(lp.buf, valueOff, lp.next(valueOff), appendListpackEntry(nil, value)...)
+	return false
+}
+
+func (hp *hashPack) remove(field string) bool {
+	off := hp.find(field)
+	if off < 0 {
+		return false
+	}
+	lp := hp.pairs()
+	lp.buf = slices.Delete(lp.buf, off, lp.next(lp.next(off)))
+	lp.size -= 2
+	return true
+}
+
+func (hp *hashPack) each(fn func(field, value string) bool) {
+	lp := hp.pairs()
+	for off := 0; off < len(lp.buf); {
+		field, valueOff := lp.entryAt(off)
+		value, next := lp.entryAt(valueOff)
+		if !fn(field, value) {
+			return
+		}
+		off = next
+	}
+}
+
+// clone returns an independent copy of the hash
+func (hp *hashPack) clone() *hashPack {
+	return (*hashPack)(hp.pairs().clone())
+}
+
+// hashTable converts the listpack to the hash table encoding
+func (hp *hashPack) hashTable() hashTable {
+	ht := make(hashTable, hp.len()+1)
+	hp.each(func(field, value string) bool {
+		ht[field] = value
+		return true
+	})
+	return ht
+}
+
+// asHash returns the hashValue view of a stored hash value
+func asHash(value any) hashValue {
+	if hp, ok := value.(*hashPack); ok {
+		return hp
+	}
+	return hashTable(value.(map[string]string))
+}
+
+// hashStorage returns the form a hash is stored in entries
+func hashStorage(hash hashValue) any {
+	if ht, ok := hash.(hashTable); ok {
+		return map[string]string(ht)
+	}
+	return hash
+}
+
+// SetHashListpackLimits sets the store's hash-max-listpack-entries and
+// hash-max-listpack-value: hashes of at most entries fields, none of whose
+// fields or values is longer than value bytes, are stored as listpacks.
+// Existing hashes keep their encoding until they are next written.
+func (s *Store) SetHashListpackLimits(entries, value int) {
+	s.mu.Lock()
+	s.hashPackEntries, s.hashPackValue = entries, value
+	s.mu.Unlock()
+}
+
+// hashListpackLimits returns the limits set by SetHashListpackLimits
+func (s *Store) hashListpackLimits() (entries, value int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hashPackEntries, s.hashPackValue
+}
+
+// newHash returns an empty hash in the encoding suited to size fields
+func (s *Store) newHash(size int) hashValue {
+	if size <= s.hashPackEntries {
+		return &hashPack{}
+	}
+	return make(hashTable, size)
+}
+
+// encodeHash returns the fields of a newly built hash in the most compact
+// encoding allowed
+func (s *Store) encodeHash(fields hashTable) hashValue {
+	if len(fields) == 0 || len(fields) > s.hashPackEntries {
+		return fields
+	}
+	for field, value := range fields {
+		if len(field) > s.hashPackValue || len(value) > s.hashPackValue {
+			return fields
+		}
+	}
+	hp := &hashPack{}
+	for field, value := range fields {
+		hp.set(field, value)
+	}
+	return hp
+}
+
+// setField sets field of hash, converting a listpack that would outgrow the
+// limits to a hash table. The caller stores the returned hash if it changed.
+// Callers must hold the write lock.
+func (s *Store) setField(hash hashValue, field, value string) (hashValue, bool) {
+	if hp, ok := hash.(*hashPack); ok {
+		tooLong := len(field) > s.hashPackValue || len(value) > s.hashPackValue
+		if tooLong || (hp.len() >= s.hashPackEntries && hp.find(field) < 0) {
+			hash = hp.hashTable()
+		}
+	}
+	return hash, hash.set(field, value)
+}
+
+// parseListpackLimit parses a hash-max-listpack-* or zset-max-listpack-*
+// value, a count or length of at least 0
+func parseListpackLimit(name, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return n, nil
+}
+
+// Hash encoding parameters of CONFIG GET and CONFIG SET, which apply to
+// every database
+var hashConfigParams = []configParam{
+	{
+		name: "hash-max-listpack-entries",
+		get: func() string {
+			entries, _ := databases.Get(0).hashListpackLimits()
+			return strconv.Itoa(entries)
+		},
+		set: func(v string) error {
+			n, err := parseListpackLimit("hash-max-listpack-entries", v)
+			if err != nil {
+				return err
+			}
+			for _, db := range databases.All() {
+				_, value := db.hashListpackLimits()
+				db.SetHashListpackLimits(n, value)
+			}
+			return nil
+		},
+	},
+	{
+		name: "hash-max-listpack-value",
+		get: func() string {
+			_, value := databases.Get(0).hashListpackLimits()
+			return strconv.Itoa(value)
+		},
+		set: func(v string) error {
+			n, err := parseListpackLimit("hash-max-listpack-value", v)
+			if err != nil {
+				return err
+			}
+			for _, db := range databases.All() {
+				entries, _ := db.hashListpackLimits()
+				db.SetHashListpackLimits(entries, n)
+			}
+			return nil
+		},
+	},
+}
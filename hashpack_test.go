@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashPack(t *testing.T) {
+	// Test 1: Fields keep insertion order and are updated in place
+	hp := &hashPack{}
+	if !hp.set("a", "1") || !hp.set("b", "2") || hp.set("a", "one") {
+		t.Fatalf("expected set to report created fields only")
+	}
+	if value, found := hp.get("a"); !found || value != "one" {
+		t.Fatalf("expected one, got %q (%v)", value, found)
+	}
+	// A value equal to a field name must not be mistaken for the field
+	hp.set("c", "b")
+	if value, _ := hp.get("b"); value != "2" {
+		t.Fatalf("expected 2, got %q", value)
+	}
+	if pairs := hashPairs(hp); strings.Join(pairs, ",") != "a,one,b,2,c,b" {
+		t.Fatalf("unexpected pairs %v", pairs)
+	}
+
+	// Test 2: Removing a field drops its value too
+	if !hp.remove("b") || hp.remove("b") || hp.len() != 2 {
+		t.Fatalf("expected b to be removed once, leaving 2 fields")
+	}
+	if ht := hp.hashTable(); len(ht) != 2 || ht["a"] != "one" || ht["c"] != "b" {
+		t.Fatalf("unexpected conversion %v", ht)
+	}
+}
+
+func TestHashListpackEncoding(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+
+	db := databases.Get(0)
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	encoding := func(key string) string {
+		enc, _, _, _ := db.ObjectInfo(key)
+		return enc
+	}
+
+	// Test 1: Small hashes are listpacks and every command works on them
+	run("HSET", "user", "name", "ann", "age", "7")
+	if enc := encoding("user"); enc != "listpack" {
+		t.Fatalf("expected listpack, got %s", enc)
+	}
+	run("HINCRBY", "user", "age", "1")
+	run("HSETNX", "user", "nick", "a")
+	run("HDEL", "user", "name")
+	if reply := run("HGETALL", "user"); reply != formatArray([]string{"age", "8", "nick", "a"}) {
+		t.Fatalf("unexpected fields %q", reply)
+	}
+	if reply := run("HLEN", "user"); reply != ":2\r\n" {
+		t.Fatalf("expected 2 fields, got %q", reply)
+	}
+
+	// Test 2: A long field or value converts the hash, keeping its fields
+	run("HSET", "user", "bio", strings.Repeat("x", defaultHashListpackValue+1))
+	if enc := encoding("user"); enc != "hashtable" {
+		t.Fatalf("expected hashtable after a long value, got %s", enc)
+	}
+	if reply := run("HGET", "user", "age"); reply != "$1\r\n8\r\n" {
+		t.Fatalf("expected 8, got %q", reply)
+	}
+	run("HSETNX", "long", strings.Repeat("f", defaultHashListpackValue+1), "v")
+	if enc := encoding("long"); enc != "hashtable" {
+		t.Fatalf("expected a hash created with a long field to be a hashtable, got %s", enc)
+	}
+
+	// Test 3: CONFIG SET changes the limits of every database
+	if reply := run("CONFIG", "SET", "hash-max-listpack-entries", "3", "hash-max-listpack-value", "10"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if entries, value := databases.Get(1).hashListpackLimits(); entries != 3 || value != 10 {
+		t.Fatalf("expected limits 3 and 10 in database 1, got %d and %d", entries, value)
+	}
+	want := "*4\r\n" + formatBulkString("hash-max-listpack-entries") + formatBulkString("3") +
+		formatBulkString("hash-max-listpack-value") + formatBulkString("10")
+	if reply := run("CONFIG", "GET", "hash-*"); reply != want {
+		t.Fatalf("unexpected CONFIG GET reply %q", reply)
+	}
+	run("HSET", "small", "a", "1", "b", "2", "c", "3")
+	run("HSET", "small", "c", "three")
+	if enc := encoding("small"); enc != "listpack" {
+		t.Fatalf("expected listpack at the limit, got %s", enc)
+	}
+	run("HSET", "small", "d", "4")
+	if enc := encoding("small"); enc != "hashtable" {
+		t.Fatalf("expected hashtable past the limit, got %s", enc)
+	}
+	run("HSET", "many", "a", "1", "b", "2", "c", "3", "d", "4")
+	if enc := encoding("many"); enc != "hashtable" {
+		t.Fatalf("expected a hash created past the limit to be a hashtable, got %s", enc)
+	}
+	if reply := run("CONFIG", "SET", "hash-max-listpack-value", "-1"); !strings.Contains(reply, "invalid hash-max-listpack-value") {
+		t.Fatalf("expected an invalid value error, got %q", reply)
+	}
+	run("CONFIG", "SET", "hash-max-listpack-entries", "0")
+	run("HSET", "none", "a", "1")
+	if enc := encoding("none"); enc != "hashtable" {
+		t.Fatalf("expected hash-max-listpack-entries 0 to disable listpacks, got %s", enc)
+	}
+
+	// Test 4: Listpacks take a fraction of the memory of a hash table
+	db.SetHashListpackLimits(defaultHashListpackEntries, defaultHashListpackValue)
+	pairs := make([]string, 0, 200)
+	for i := range 100 {
+		pairs = append(pairs, "field:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	db.HSet("packed", pairs...)
+	db.SetHashListpackLimits(0, defaultHashListpackValue)
+	db.HSet("table", pairs...)
+	packed, _ := db.MemoryUsage("packed", 0)
+	table, _ := db.MemoryUsage("table", 0)
+	if encoding("packed") != "listpack" || packed*3 > table {
+		t.Fatalf("expected the listpack to be much smaller: %d vs %d bytes", packed, table)
+	}
+
+	// Test 5: Directives set the limits
+	cfg, err := ParseConfig(strings.NewReader("hash-max-listpack-entries 16\nhash-max-ziplist-value 32\n"))
+	if err != nil || cfg.HashMaxListpackEntries != 16 || cfg.HashMaxListpackValue != 32 {
+		t.Fatalf("unexpected parsed limits %+v, %v", cfg, err)
+	}
+	if _, err := ParseConfig(strings.NewReader("hash-max-listpack-entries many\n")); err == nil {
+		t.Fatalf("expected a non-numeric limit to be rejected")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hot-key tracking parameters
+const (
+	hotKeyShards       = 64          // Number of independently locked shards
+	hotKeySampleRate   = 16          // One in this many reads is counted
+	hotKeyWindow       = time.Second // Length of the read-rate measurement window
+	hotKeyDefaultLimit = 10000       // Reads per second above which a key is hot
+)
+
+// hotValue is a cached copy of a hot string key
+type hotValue struct {
+	value     string
+	expiresAt time.Time
+}
+
+// hotFlight is an in-progress store lookup that concurrent readers of the same key wait on
+type hotFlight struct {
+	done   chan struct{}
+	value  string
+	exists bool
+	isStr  bool
+}
+
+// hotKeyShard tracks read rates and caches values for a subset of keys.
+// The cache and hot set are immutable maps swapped atomically, so reads of
+// hot keys never take a lock; the mutex only guards counting and updates.
+type hotKeyShard struct {
+	mu          sync.Mutex
+	counts      map[string]int // Sampled reads in the current window
+	windowStart time.Time      // Start of the current window
+	hot         atomic.Pointer[map[string]struct{}]
+	cache       atomic.Pointer[map[string]hotValue]
+	flights     map[string]*hotFlight
+}
+
+// HotKeyStats holds the counters reported in INFO stats
+type HotKeyStats struct {
+	Tracked       int   // Keys currently considered hot
+	Hits          int64 // Reads served from the hot-key cache
+	Misses        int64 // Reads of hot keys that had to go to the store
+	Coalesced     int64 // Reads that waited on another reader's lookup
+	Promotions    int64 // Times a key became hot
+	Invalidations int64 // Cached values dropped because the key was written
+}
+
+// hotKeys protects the store from extreme read rates on individual keys.
+// When enabled, keys whose sampled read rate crosses the limit are served from
+// a per-shard cache, and concurrent cache misses for the same key share a
+// single store lookup.
+type hotKeys struct {
+	enabled atomic.Bool
+	limit   atomic.Int64 // Reads per second above which a key is hot
+	seed    maphash.Seed
+	shards  [hotKeyShards]hotKeyShard
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	coalesced     atomic.Int64
+	promotions    atomic.Int64
+	invalidations atomic.Int64
+}
+
+// newHotKeys creates a disabled hot-key tracker
+func newHotKeys() *hotKeys {
+	h := &hotKeys{seed: maphash.MakeSeed()}
+	h.limit.Store(hotKeyDefaultLimit)
+	for i := range h.shards {
+		shard := &h.shards[i]
+		shard.counts = make(map[string]int)
+		shard.flights = make(map[string]*hotFlight)
+		shard.windowStart = time.Now()
+		shard.hot.Store(&map[string]struct{}{})
+		shard.cache.Store(&map[string]hotValue{})
+	}
+	return h
+}
+
+func (h *hotKeys) shard(key string) *hotKeyShard {
+	return &h.shards[maphash.String(h.seed, key)%hotKeyShards]
+}
+
+// cached returns the cached value of a hot key
+func (h *hotKeys) cached(key string) (string, bool) {
+	v, ok := (*h.shard(key).cache.Load())[key]
+	if !ok || (!v.expiresAt.IsZero() && !clockNow().Before(v.expiresAt)) {
+		return "", false
+	}
+	return v.value, true
+}
+
+// isHot reports whether key is currently considered hot
+func (h *hotKeys) isHot(key string) bool {
+	_, ok := (*h.shard(key).hot.Load())[key]
+	return ok
+}
+
+// sample counts a read of key, promoting or demoting keys at window boundaries
+func (h *hotKeys) sample(key string) {
+	if rand.Uint32()%hotKeySampleRate != 0 {
+		return
+	}
+	shard := h.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(shard.windowStart) >= hotKeyWindow {
+		h.rotate(shard, now)
+	}
+	shard.counts[key]++
+
+	// Promote as soon as the key crosses the limit within the window
+	if shard.counts[key]*hotKeySampleRate >= int(h.limit.Load()) && !h.isHot(key) {
+		hot := copyHotSet(*shard.hot.Load())
+		hot[key] = struct{}{}
+		shard.hot.Store(&hot)
+		h.promotions.Add(1)
+	}
+}
+
+// rotate starts a new measurement window, demoting keys that cooled down
+// Callers must hold shard.mu
+func (h *hotKeys) rotate(shard *hotKeyShard, now time.Time) {
+	elapsed := now.Sub(shard.windowStart)
+	hot := make(map[string]struct{})
+	for key := range *shard.hot.Load() {
+		rate := float64(shard.counts[key]*hotKeySampleRate) / elapsed.Seconds()
+		if rate >= float64(h.limit.Load()) {
+			hot[key] = struct{}{}
+		}
+	}
+	cache := make(map[string]hotValue)
+	for key, v := range *shard.cache.Load() {
+		if _, ok := hot[key]; ok {
+			cache[key] = v
+		}
+	}
+	shard.hot.Store(&hot)
+	shard.cache.Store(&cache)
+	shard.counts = make(map[string]int)
+	shard.windowStart = now
+}
+
+// beginFlight registers a lookup for key, or returns the flight already in progress
+func (h *hotKeys) beginFlight(key string) (*hotFlight, bool) {
+	shard := h.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if f, ok := shard.flights[key]; ok {
+		return f, false
+	}
+	f := &hotFlight{done: make(chan struct{})}
+	shard.flights[key] = f
+	return f, true
+}
+
+// finishFlight publishes a lookup's result, caching it if the key was a string
+// Callers must hold the store's read lock so no write can slip in between the
+// lookup and the cache fill
+func (h *hotKeys) finishFlight(key string, f *hotFlight, expiresAt time.Time) {
+	shard := h.shard(key)
+	shard.mu.Lock()
+	if f.exists && f.isStr && h.isHot(key) {
+		cache := copyHotCache(*shard.cache.Load())
+		cache[key] = hotValue{value: f.value, expiresAt: expiresAt}
+		shard.cache.Store(&cache)
+	}
+	delete(shard.flights, key)
+	shard.mu.Unlock()
+	close(f.done)
+}
+
+// invalidate drops the cached value of a key that is being written
+// Callers must hold the store's write lock
+func (h *hotKeys) invalidate(key string) {
+	shard := h.shard(key)
+	if _, ok := (*shard.cache.Load())[key]; !ok {
+		return
+	}
+	shard.mu.Lock()
+	cache := copyHotCache(*shard.cache.Load())
+	delete(cache, key)
+	shard.cache.Store(&cache)
+	shard.mu.Unlock()
+	h.invalidations.Add(1)
+}
+
+// clear drops every cached value
+// Callers must hold the store's write lock
+func (h *hotKeys) clear() {
+	for i := range h.shards {
+		shard := &h.shards[i]
+		shard.mu.Lock()
+		shard.cache.Store(&map[string]hotValue{})
+		shard.mu.Unlock()
+	}
+}
+
+// Stats returns a snapshot of the hot-key counters
+func (h *hotKeys) Stats() HotKeyStats {
+	tracked := 0
+	for i := range h.shards {
+		tracked += len(*h.shards[i].hot.Load())
+	}
+	return HotKeyStats{
+		Tracked:       tracked,
+		Hits:          h.hits.Load(),
+		Misses:        h.misses.Load(),
+		Coalesced:     h.coalesced.Load(),
+		Promotions:    h.promotions.Load(),
+		Invalidations: h.invalidations.Load(),
+	}
+}
+
+func copyHotSet(m map[string]struct{}) map[string]struct{} {
+	copied := make(map[string]struct{}, len(m)+1)
+	for k := range m {
+		copied[k] = struct{}{}
+	}
+	return copied
+}
+
+func copyHotCache(m map[string]hotValue) map[string]hotValue {
+	copied := make(map[string]hotValue, len(m)+1)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// getHot is the Get path used while hot-key protection is enabled
+// The last result reports whether the value came from the hot-key cache
+func (s *Store) getHot(key string) (string, bool, bool, bool) {
+	h := s.hot
+	h.sample(key)
+
+	if value, ok := h.cached(key); ok {
+		h.hits.Add(1)
+		return value, true, true, true
+	}
+	if !h.isHot(key) {
+		value, exists, isStr := s.getUncached(key)
+		return value, exists, isStr, false
+	}
+
+	h.misses.Add(1)
+	f, leader := h.beginFlight(key)
+	if !leader {
+		h.coalesced.Add(1)
+		<-f.done
+		return f.value, f.exists, !f.exists || f.isStr, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var expiresAt time.Time
+	if entry := s.data.get(key); entry != nil {
+		f.exists = true
+		f.value, f.isStr = entry.Value.(string)
+		f.isStr = f.isStr && entry.Type == TypeString
+		expiresAt = entry.ExpiresAt
+	}
+	h.finishFlight(key, f, expiresAt)
+	return f.value, f.exists, !f.exists || f.isStr, false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHotKeyCache(t *testing.T) {
+	store := NewStore()
+	store.hot.enabled.Store(true)
+	store.hot.limit.Store(64)
+
+	// Test 1: A heavily read key gets promoted and served from the cache
+	store.Set("hot", "v1")
+	for i := 0; i < 10000; i++ {
+		value, exists, isCorrectType := store.Get("hot")
+		if !exists || !isCorrectType || value != "v1" {
+			t.Fatalf("expected (v1, true, true), got (%s, %v, %v)", value, exists, isCorrectType)
+		}
+	}
+	stats := store.hot.Stats()
+	if stats.Promotions == 0 || stats.Hits == 0 {
+		t.Fatalf("expected the key to be promoted and cached, got %+v", stats)
+	}
+
+	// Test 2: Writes invalidate the cached value
+	store.Set("hot", "v2")
+	value, _, _ := store.Get("hot")
+	if value != "v2" {
+		t.Fatalf("expected v2 after write, got %s", value)
+	}
+	store.Del("hot")
+	_, exists, _ := store.Get("hot")
+	if exists {
+		t.Fatalf("expected hot key to be gone after DEL")
+	}
+	if store.hot.Stats().Invalidations < 2 {
+		t.Fatalf("expected at least 2 invalidations, got %+v", store.hot.Stats())
+	}
+
+	// Test 3: Wrong type is still reported for hot keys
+	store.SetForTesting("hot", TypeList, []string{"a"})
+	for i := 0; i < 1000; i++ {
+		_, exists, isCorrectType := store.Get("hot")
+		if !exists || isCorrectType {
+			t.Fatalf("expected wrong type for list key, got (%v, %v)", exists, isCorrectType)
+		}
+	}
+}
+
+func TestHotKeyConcurrentReadsSeeWrites(t *testing.T) {
+	store := NewStore()
+	store.hot.enabled.Store(true)
+	store.hot.limit.Store(64)
+	store.Set("hot", "0")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					store.Get("hot")
+				}
+			}
+		}()
+	}
+
+	// Every write must be visible to a read issued after it returns
+	for _, want := range []string{"1", "2", "3", "4", "5"} {
+		for i := 0; i < 1000; i++ {
+			store.Get("hot")
+		}
+		store.Set("hot", want)
+		if value, _, _ := store.Get("hot"); value != want {
+			t.Fatalf("expected %s right after write, got %s", want, value)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
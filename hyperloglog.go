@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are strings in the layout Redis uses, so DUMP payloads of PF
+// keys move between this server and Redis unchanged. A 16-byte header holds
+// the "HYLL" magic, the encoding, three unused bytes and the last
+// cardinality as a little-endian integer whose top bit marks it stale. The
+// dense encoding follows with 16384 6-bit registers packed least significant
+// bit first; the sparse one with run-length opcodes:
+//
+//	00xxxxxx          ZERO: xxxxxx+1 registers set to 0
+//	01xxxxxx yyyyyyyy XZERO: xxxxxxyyyyyyyy+1 registers set to 0
+//	1vvvvvxx          VAL: xx+1 registers set to vvvvv+1
+const (
+	hllP           = 14        // Bits of the hash choosing the register
+	hllQ           = 64 - hllP // Bits of the hash counted for the register value
+	hllRegisters   = 1 << hllP // Number of registers
+	hllBits        = 6         // Width of a dense register
+	hllHeaderSize  = 16        // Magic, encoding, unused bytes and cached cardinality
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense       = 0                       // Encoding byte of the dense encoding
+	hllSparse      = 1                       // Encoding byte of the sparse encoding
+	hllSeed        = 0xadc83b19              // MurmurHash64A seed of element hashes
+	hllAlphaInf    = 0.721347520444481703680 // Limit of the bias correction constant
+	hllSparseValue = 32                      // Largest register value a VAL opcode holds
+)
+
+// Length past which a sparse HyperLogLog converts to the dense encoding, as
+// Redis's hll-sparse-max-bytes default
+const hllSparseMaxBytes = 3000
+
+// Error replies for strings that are not HyperLogLogs, or whose registers
+// are malformed
+const (
+	hllNotValidError = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	hllCorruptError  = "INVALIDOBJ Corrupted HLL object detected"
+)
+
+// murmurHash64A is the 64-bit MurmurHash2 variant Redis hashes elements
+// with, reading the input as little-endian words
+func murmurHash64A(data string, seed uint64) uint64 {
+	const m, r = 0xc6a4a7935bd1e995, 47
+	h := seed ^ uint64(len(data))*m
+	for ; len(data) >= 8; data = data[8:] {
+		k := word64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register element maps to and the value it counts
+// for: one more than the number of trailing zero bits in the rest of its hash
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A(element, hllSeed)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ // Bounds the count at hllQ+1
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// newHLL returns an empty sparse HyperLogLog with a valid cached cardinality
+// of 0
+func newHLL() []byte {
+	hll := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(hll, "HYLL")
+	hll[4] = hllSparse
+	// A single XZERO covering every register
+	return append(hll, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// isHLL reports whether value has the header of a HyperLogLog; the sparse
+// registers are only checked once read
+func isHLL(value string) bool {
+	if len(value) < hllHeaderSize || value[:4] != "HYLL" {
+		return false
+	}
+	switch value[4] {
+	case hllDense:
+		return len(value) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// denseRegister returns register i of the dense registers r
+func denseRegister[B string | []byte](r B, i int) uint8 {
+	pos := i * hllBits
+	b, shift := pos>>3, uint(pos&7)
+	v := uint(r[b]) >> shift
+	if b+1 < len(r) {
+		v |= uint(r[b+1]) << (8 - shift)
+	}
+	return uint8(v & (1<<hllBits - 1))
+}
+
+// setDenseRegister sets register i of the dense registers r to v
+func setDenseRegister(r []byte, i int, v uint8) {
+	pos := i * hllBits
+	b, shift := pos>>3, uint(pos&7)
+	r[b] &^= byte(uint(1<<hllBits-1) << shift)
+	r[b] |= byte(uint(v) << shift)
+	if b+1 < len(r) {
+		r[b+1] &^= byte(uint(1<<hllBits-1) >> (8 - shift))
+		r[b+1] |= byte(uint(v) >> (8 - shift))
+	}
+}
+
+// hllRun is a run of registers of the same value in the sparse encoding
+type hllRun struct {
+	value  uint8
+	length int
+}
+
+// sparseRuns calls fn for each opcode of the sparse registers r
+// Returns false if r is truncated or does not cover exactly every register
+func sparseRuns[B string | []byte](r B, fn func(run hllRun)) bool {
+	covered := 0
+	for i := 0; i < len(r); {
+		var run hllRun
+		switch op := r[i]; {
+		case op&0xc0 == 0x00: // ZERO
+			run.length = int(op&0x3f) + 1
+			i++
+		case op&0xc0 == 0x40: // XZERO
+			if i+1 >= len(r) {
+				return false
+			}
+			run.length = (int(op&0x3f)<<8 | int(r[i+1])) + 1
+			i += 2
+		default: // VAL
+			run.value = (op>>2)&0x1f + 1
+			run.length = int(op&0x03) + 1
+			i++
+		}
+		if covered += run.length; covered > hllRegisters {
+			return false
+		}
+		fn(run)
+	}
+	return covered == hllRegisters
+}
+
+// appendSparseRun appends the shortest opcodes encoding run to r
+func appendSparseRun(r []byte, run hllRun) []byte {
+	for run.length > 0 {
+		var n int
+		switch {
+		case run.value > 0:
+			n = min(run.length, 4)
+			r = append(r, 0x80|(run.value-1)<<2|byte(n-1))
+		case run.length > 64:
+			n = min(run.length, hllRegisters)
+			r = append(r, 0x40|byte((n-1)>>8), byte(n-1))
+		default:
+			n = run.length
+			r = append(r, byte(n-1))
+		}
+		run.length -= n
+	}
+	return r
+}
+
+// encodeSparse appends the sparse encoding of runs to r, merging
+// consecutive runs of the same value
+func encodeSparse(r []byte, runs []hllRun) []byte {
+	for i := 0; i < len(runs); {
+		run := runs[i]
+		for i++; i < len(runs) && runs[i].value == run.value; i++ {
+			run.length += runs[i].length
+		}
+		r = appendSparseRun(r, run)
+	}
+	return r
+}
+
+// sparseToDense returns the dense HyperLogLog with the header and registers
+// of the sparse hll
+// Returns (dense, false if the registers are malformed)
+func sparseToDense(hll []byte) ([]byte, bool) {
+	dense := make([]byte, hllDenseSize)
+	copy(dense, hll[:hllHeaderSize])
+	dense[4] = hllDense
+	i := 0
+	ok := sparseRuns(hll[hllHeaderSize:], func(run hllRun) {
+		if run.value > 0 {
+			for j := range run.length {
+				setDenseRegister(dense[hllHeaderSize:], i+j, run.value)
+			}
+		}
+		i += run.length
+	})
+	return dense, ok
+}
+
+// hllSet raises register index of hll to count if it is lower, converting a
+// sparse hll to the dense encoding when count does not fit a VAL opcode or
+// the result would pass hllSparseMaxBytes
+// Returns (the updated hll, whether the register changed, false if the
+// registers are malformed)
+func hllSet(hll []byte, index int, count uint8) ([]byte, bool, bool) {
+	if hll[4] == hllSparse && count > hllSparseValue {
+		dense, ok := sparseToDense(hll)
+		if !ok {
+			return hll, false, false
+		}
+		hll = dense
+	}
+	if hll[4] == hllDense {
+		r := hll[hllHeaderSize:]
+		if denseRegister(r, index) >= count {
+			return hll, false, true
+		}
+		setDenseRegister(r, index, count)
+		return hll, true, true
+	}
+
+	// Split the run holding the register around it
+	var runs []hllRun
+	first, changed := 0, false
+	ok := sparseRuns(hll[hllHeaderSize:], func(run hllRun) {
+		last := first + run.length - 1
+		if index < first || index > last || run.value >= count {
+			runs = append(runs, run)
+		} else {
+			changed = true
+			if index > first {
+				runs = append(runs, hllRun{run.value, index - first})
+			}
+			runs = append(runs, hllRun{count, 1})
+			if index < last {
+				runs = append(runs, hllRun{run.value, last - index})
+			}
+		}
+		first = last + 1
+	})
+	if !ok || !changed {
+		return hll, false, ok
+	}
+	updated := encodeSparse(append(make([]byte, 0, len(hll)+3), hll[:hllHeaderSize]...), runs)
+	if len(updated) > hllSparseMaxBytes {
+		dense, _ := sparseToDense(updated)
+		return dense, true, true
+	}
+	return updated, true, true
+}
+
+// mergeRegisters raises each of regs to the matching register of hll
+// Returns false if the registers of hll are malformed
+func mergeRegisters(regs *[hllRegisters]uint8, hll string) bool {
+	if hll[4] == hllDense {
+		r := hll[hllHeaderSize:]
+		for i := range regs {
+			regs[i] = max(regs[i], denseRegister(r, i))
+		}
+		return true
+	}
+	i := 0
+	return sparseRuns(hll[hllHeaderSize:], func(run hllRun) {
+		for j := i; j < i+run.length; j++ {
+			regs[j] = max(regs[j], run.value)
+		}
+		i += run.length
+	})
+}
+
+// hllTau and hllSigma are the correction terms of Ertl's improved raw
+// estimator for registers at the maximum and minimum values
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+// hllEstimate returns the cardinality estimated from the number of
+// registers holding each value
+func hllEstimate(histogram *[hllQ + 2]int) uint64 {
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// hllCount estimates the cardinality of hll
+// Returns (cardinality, false if the registers are malformed)
+func hllCount(hll string) (uint64, bool) {
+	var histogram [hllQ + 2]int
+	if hll[4] == hllDense {
+		r := hll[hllHeaderSize:]
+		for i := range hllRegisters {
+			histogram[denseRegister(r, i)]++
+		}
+	} else if !sparseRuns(hll[hllHeaderSize:], func(run hllRun) { histogram[run.value] += run.length }) {
+		return 0, false
+	}
+	return hllEstimate(&histogram), true
+}
+
+// hllValue returns the HyperLogLog at key, or "" if it does not exist
+// Returns a non-empty error reply if the key holds anything else
+// Callers must hold the lock.
+func (s *Store) hllValue(key string) (string, string) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return "", ""
+	}
+	if entry.Type != TypeString {
+		return "", formatError(wrongTypeError)
+	}
+	value := entry.Value.(string)
+	if !isHLL(value) {
+		return "", formatError(hllNotValidError)
+	}
+	return value, ""
+}
+
+// putHLL stores hll at key, keeping the TTL of an existing key
+// Callers must hold the write lock.
+func (s *Store) putHLL(key string, hll []byte) {
+	if entry := s.data.get(key); entry != nil {
+		entry.Value = string(hll)
+		s.keyModified(key)
+		return
+	}
+	s.put(key, &Entry{Type: TypeString, Value: string(hll)})
+}
+
+// PFAdd adds elements to the HyperLogLog at key, creating it if needed
+// Returns (whether the key was created or a register changed, a non-empty
+// error reply on failure)
+func (s *Store) PFAdd(key string, elements []string) (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, errReply := s.hllValue(key)
+	if errReply != "" {
+		return false, errReply
+	}
+	var hll []byte
+	changed := value == ""
+	if changed {
+		hll = newHLL()
+	} else {
+		hll = []byte(value)
+	}
+	for _, element := range elements {
+		index, count := hllPatLen(element)
+		var set, ok bool
+		if hll, set, ok = hllSet(hll, index, count); !ok {
+			return false, formatError(hllCorruptError)
+		}
+		changed = changed || set
+	}
+	if changed {
+		hll[15] |= 0x80 // Mark the cached cardinality stale
+		s.putHLL(key, hll)
+	}
+	return changed, ""
+}
+
+// PFCount estimates the cardinality of the union of the HyperLogLogs at
+// keys, missing keys counting as empty. With a single key the estimate is
+// cached in its header until the next change.
+// Returns (cardinality, a non-empty error reply on failure)
+func (s *Store) PFCount(keys []string) (uint64, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(keys) == 1 {
+		value, errReply := s.hllValue(keys[0])
+		if errReply != "" || value == "" {
+			return 0, errReply
+		}
+		if value[15]&0x80 == 0 {
+			return binary.LittleEndian.Uint64([]byte(value[8:hllHeaderSize])), ""
+		}
+		n, ok := hllCount(value)
+		if !ok {
+			return 0, formatError(hllCorruptError)
+		}
+		hll := []byte(value)
+		binary.LittleEndian.PutUint64(hll[8:], n)
+		s.putHLL(keys[0], hll)
+		return n, ""
+	}
+
+	var regs [hllRegisters]uint8
+	for _, key := range keys {
+		value, errReply := s.hllValue(key)
+		if errReply != "" {
+			return 0, errReply
+		}
+		if value != "" && !mergeRegisters(&regs, value) {
+			return 0, formatError(hllCorruptError)
+		}
+	}
+	var histogram [hllQ + 2]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+	return hllEstimate(&histogram), ""
+}
+
+// PFMerge stores at dst the union of the HyperLogLogs at dst and sources,
+// dense if any of them is
+// Returns a non-empty error reply on failure
+func (s *Store) PFMerge(dst string, sources []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var regs [hllRegisters]uint8
+	dense := false
+	for _, key := range append([]string{dst}, sources...) {
+		value, errReply := s.hllValue(key)
+		if errReply != "" {
+			return errReply
+		}
+		if value == "" {
+			continue
+		}
+		if !mergeRegisters(&regs, value) {
+			return formatError(hllCorruptError)
+		}
+		dense = dense || value[4] == hllDense
+	}
+
+	hll := newHLL()[:hllHeaderSize]
+	if dense {
+		hll = append(hll, make([]byte, hllDenseSize-hllHeaderSize)...)
+		hll[4] = hllDense
+		for i, v := range regs {
+			setDenseRegister(hll[hllHeaderSize:], i, v)
+		}
+	} else {
+		runs := make([]hllRun, len(regs))
+		for i, v := range regs {
+			runs[i] = hllRun{v, 1}
+		}
+		if hll = encodeSparse(hll, runs); len(hll) > hllSparseMaxBytes {
+			hll, _ = sparseToDense(hll)
+		}
+	}
+	hll[15] |= 0x80
+	s.putHLL(dst, hll)
+	return ""
+}
+
+// PFADD key [element ...]
+func pfaddCommand(c *Client, args []string) string {
+	changed, errReply := c.db().PFAdd(args[1], args[2:])
+	if errReply != "" {
+		return errReply
+	}
+	if changed {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
+
+// PFCOUNT key [key ...]
+func pfcountCommand(c *Client, args []string) string {
+	n, errReply := c.db().PFCount(args[1:])
+	if errReply != "" {
+		return errReply
+	}
+	return formatInteger(int(n))
+}
+
+// PFMERGE destkey [sourcekey ...]
+func pfmergeCommand(c *Client, args []string) string {
+	if errReply := c.db().PFMerge(args[1], args[2:]); errReply != "" {
+		return errReply
+	}
+	return formatSimpleString("OK")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	db := databases.Get(0)
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	value := func(key string) string {
+		v, _, _ := db.Get(key)
+		return v
+	}
+
+	// Test 1: Counts of the Redis documentation examples
+	for _, step := range []struct{ args, want string }{
+		{"PFADD hll a b c d e f g", ":1\r\n"},
+		{"PFCOUNT hll", ":7\r\n"},
+		{"PFADD docs foo bar zap", ":1\r\n"},
+		{"PFADD docs zap zap zap", ":0\r\n"},
+		{"PFADD docs foo bar", ":0\r\n"},
+		{"PFCOUNT docs", ":3\r\n"},
+		{"PFADD other 1 2 3", ":1\r\n"},
+		{"PFCOUNT docs other", ":6\r\n"},
+		{"PFADD empty", ":1\r\n"},
+		{"PFADD empty", ":0\r\n"},
+		{"PFCOUNT empty missing", ":0\r\n"},
+		{"PFCOUNT missing", ":0\r\n"},
+		{"PFMERGE merged docs other missing", "+OK\r\n"},
+		{"PFCOUNT merged", ":6\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 2: Small HyperLogLogs are sparse strings in the Redis layout
+	hll := value("docs")
+	if !strings.HasPrefix(hll, "HYLL\x01\x00\x00\x00") || len(hll) > 32 {
+		t.Fatalf("expected a short sparse HyperLogLog, got %q", hll)
+	}
+	// PFADD marks the cached count stale even when it only creates the key
+	if empty := value("empty"); empty != "HYLL\x01"+strings.Repeat("\x00", 10)+"\x80\x7f\xff" {
+		t.Fatalf("unexpected empty HyperLogLog %q", empty)
+	}
+	var regs [hllRegisters]uint8
+	if !mergeRegisters(&regs, hll) {
+		t.Fatalf("expected well formed sparse registers")
+	}
+	set := 0
+	for _, v := range regs {
+		if v > 0 {
+			set++
+		}
+	}
+	for _, element := range []string{"foo", "bar", "zap"} {
+		if index, count := hllPatLen(element); regs[index] < count {
+			t.Fatalf("expected register %d to count %s", index, element)
+		}
+	}
+	if set != 3 {
+		t.Fatalf("expected 3 registers set, got %d", set)
+	}
+
+	// Test 3: The cardinality is cached in the header until the next change
+	if hll[15]&0x80 != 0 || word64(hll[8:16]) != 3 {
+		t.Fatalf("expected a cached count of 3, got %q", hll[8:16])
+	}
+	run("PFADD", "docs", "new")
+	if hll := value("docs"); hll[15]&0x80 == 0 {
+		t.Fatalf("expected PFADD to mark the cached count stale")
+	}
+	run("PFCOUNT", "docs")
+	if hll := value("docs"); hll[15]&0x80 != 0 || word64(hll[8:16]) != 4 {
+		t.Fatalf("expected PFCOUNT to cache 4, got %q", hll[8:16])
+	}
+
+	// Test 4: Growing past the sparse limit converts to dense, and both
+	// encodings estimate the same registers alike
+	for i := 0; i < 100000; i += 1000 {
+		elements := []string{"PFADD", "big"}
+		for j := i; j < i+1000; j++ {
+			elements = append(elements, "element:"+strconv.Itoa(j))
+		}
+		run(elements...)
+		if i == 0 {
+			sparse := value("big")
+			if sparse[4] != hllSparse {
+				t.Fatalf("expected 1000 elements to stay sparse")
+			}
+			n, _ := hllCount(sparse)
+			dense, _ := sparseToDense([]byte(sparse))
+			if m, _ := hllCount(string(dense)); m != n || math.Abs(float64(n)-1000) > 30 {
+				t.Fatalf("expected equal estimates near 1000, got %d and %d", n, m)
+			}
+		}
+	}
+	big := value("big")
+	if big[4] != hllDense || len(big) != hllDenseSize {
+		t.Fatalf("expected a dense HyperLogLog of %d bytes, got %d", hllDenseSize, len(big))
+	}
+	n, _ := strconv.Atoi(strings.Trim(run("PFCOUNT", "big"), ":\r\n"))
+	if math.Abs(float64(n)-100000) > 2000 {
+		t.Fatalf("expected an estimate within 2%% of 100000, got %d", n)
+	}
+
+	// Test 5: Merging a dense source gives a dense result and keeps the TTL
+	db.Restore("timed", &Entry{Type: TypeString, Value: value("docs"), ExpiresAt: time.Now().Add(time.Hour)}, false)
+	run("PFMERGE", "timed", "big")
+	if timed := value("timed"); timed[4] != hllDense {
+		t.Fatalf("expected a dense merge result")
+	}
+	if _, _, hasTTL := db.TTL("timed"); !hasTTL {
+		t.Fatalf("expected PFMERGE to keep the TTL")
+	}
+	if reply := run("PFCOUNT", "timed"); reply != run("PFCOUNT", "big", "docs") {
+		t.Fatalf("expected the merged count to match the union, got %q", reply)
+	}
+
+	// Test 6: DUMP and RESTORE carry the HyperLogLog as a string
+	dump := run("DUMP", "docs")
+	payload := dump[strings.Index(dump, "\r\n")+2 : len(dump)-2]
+	if reply := run("RESTORE", "restored", "0", payload); reply != "+OK\r\n" || value("restored") != value("docs") {
+		t.Fatalf("expected RESTORE to recreate the HyperLogLog, got %q", reply)
+	}
+
+	// Test 7: Errors
+	run("SET", "str", "not an hll")
+	run("LPUSH", "list", "x")
+	run("SET", "corrupt", "HYLL\x01"+strings.Repeat("\x00", 10)+"\x80\x00")
+	for args, want := range map[string]string{
+		"PFADD str a":          "-" + hllNotValidError + "\r\n",
+		"PFCOUNT str":          "-" + hllNotValidError + "\r\n",
+		"PFCOUNT docs str":     "-" + hllNotValidError + "\r\n",
+		"PFMERGE docs str":     "-" + hllNotValidError + "\r\n",
+		"PFADD list a":         "-" + wrongTypeError + "\r\n",
+		"PFMERGE list docs":    "-" + wrongTypeError + "\r\n",
+		"PFCOUNT corrupt":      "-" + hllCorruptError + "\r\n",
+		"PFADD corrupt a":      "-" + hllCorruptError + "\r\n",
+		"PFMERGE docs corrupt": "-" + hllCorruptError + "\r\n",
+		"PFCOUNT docs corrupt": "-" + hllCorruptError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
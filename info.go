@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version reported by INFO server
+const serverVersion = "7.2.0"
+
+// Time the process started, for uptime reporting
+var startTime = time.Now()
+
+// infoSection generates the "field:value" lines of one INFO section
+type infoSection struct {
+	name     string
+	generate func() []string
+}
+
+// infoSections lists the INFO sections in the order they are reported
+var infoSections = []infoSection{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"keyspace", infoKeyspace},
+	{"keytypes", infoKeyTypes},
+	{"rehash", infoRehash},
+	{"commandstats", infoCommandStats},
+}
+
+func infoServer() []string {
+	return []string{
+		"redis_version:" + serverVersion,
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("tcp_port:%d", config.Port),
+		"config_file:" + configFile,
+		fmt.Sprintf("uptime_in_seconds:%d", int64(time.Since(startTime).Seconds())),
+	}
+}
+
+func infoClients() []string {
+	return []string{
+		fmt.Sprintf("connected_clients:%d", connectedClients.Load()),
+		fmt.Sprintf("blocked_clients:%d", blockedClients.Load()),
+		fmt.Sprintf("pending_handshakes:%d", pendingHandshakes.Load()),
+		fmt.Sprintf("rejected_connections:%d", rejectedConnections.Load()),
+		fmt.Sprintf("client_reply_buffer_bytes:%d", replyBufferBytes.Load()),
+		fmt.Sprintf("pipeline_read_pauses:%d", pipelinePauses.Load()),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", outputLimitDisconnections.Load()),
+	}
+}
+
+func infoStats() []string {
+	var hot HotKeyStats
+	enabled, tombstones, timers, keyBytesSaved := 0, 0, 0, 0
+	for _, db := range databases.All() {
+		stats := db.hot.Stats()
+		hot.Tracked += stats.Tracked
+		hot.Hits += stats.Hits
+		hot.Misses += stats.Misses
+		hot.Coalesced += stats.Coalesced
+		hot.Promotions += stats.Promotions
+		hot.Invalidations += stats.Invalidations
+		if db.hot.enabled.Load() {
+			enabled = 1
+		}
+		tombstones += db.TombstoneCount()
+		timers += db.PreciseTimerCount()
+		keyBytesSaved += db.KeyBytesSaved()
+	}
+	return []string{
+		fmt.Sprintf("hotkey_protection:%d", enabled),
+		fmt.Sprintf("hotkeys_tracked:%d", hot.Tracked),
+		fmt.Sprintf("hotkey_cache_hits:%d", hot.Hits),
+		fmt.Sprintf("hotkey_cache_misses:%d", hot.Misses),
+		fmt.Sprintf("hotkey_coalesced_reads:%d", hot.Coalesced),
+		fmt.Sprintf("hotkey_promotions:%d", hot.Promotions),
+		fmt.Sprintf("hotkey_invalidations:%d", hot.Invalidations),
+		fmt.Sprintf("tombstones:%d", tombstones),
+		fmt.Sprintf("precise_expire_timers:%d", timers),
+		fmt.Sprintf("precise_expired_keys:%d", preciseExpired.Load()),
+		fmt.Sprintf("compressed_key_bytes_saved:%d", keyBytesSaved),
+		"lazyfree_pending_objects:0",
+		fmt.Sprintf("lazyfreed_objects:%d", lazyFreed.Load()),
+		fmt.Sprintf("cow_value_copies:%d", cowCopies.Load()),
+		fmt.Sprintf("archived_keys:%d", archive.archived.Load()),
+		fmt.Sprintf("archive_hook_failures:%d", archive.failures.Load()),
+		fmt.Sprintf("number_of_cached_scripts:%d", scripts.len()),
+	}
+}
+
+func infoKeyspace() []string {
+	var lines []string
+	for i, db := range databases.All() {
+		if keys := db.DBSize(); keys > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0", i, keys, db.ExpiresCount()))
+		}
+	}
+	return lines
+}
+
+func infoRehash() []string {
+	var lines []string
+	for i, db := range databases.All() {
+		stats := db.RehashStats()
+		rehashing := 0
+		if stats.Rehashing {
+			rehashing = 1
+		}
+		lines = append(lines, fmt.Sprintf("db%d:buckets=%d,rehashing=%d,target_buckets=%d,migrated_buckets=%d",
+			i, stats.Buckets, rehashing, stats.Target, stats.Migrated))
+	}
+	return lines
+}
+
+// INFO [section [section ...]]
+func infoCommand(c *Client, args []string) string {
+	wanted := make(map[string]bool)
+	for _, arg := range args[1:] {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, line := range section.generate() {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	return formatBulkString(b.String())
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+)
+
+// Largest set kept as an intset, as Redis's set-max-intset-entries default
+const defaultIntsetEntries = 512
+
+// setValue is the representation of a set value: an intset while every
+// member is an integer and the set is small, a hash table otherwise
+type setValue interface {
+	len() int
+	contains(member string) bool
+	add(member string) bool
+	remove(member string) bool
+	at(i int) string // The i-th member in iteration order, 0 <= i < len
+	each(fn func(member string) bool)
+}
+
+// hashSet is the hash table encoding, stored in entries as a plain
+// map[string]struct{}; converting between the two shares the same map
+type hashSet map[string]struct{}
+
+func (hs hashSet) len() int {
+	return len(hs)
+}
+
+func (hs hashSet) contains(member string) bool {
+	_, found := hs[member]
+	return found
+}
+
+func (hs hashSet) add(member string) bool {
+	if _, found := hs[member]; found {
+		return false
+	}
+	hs[member] = struct{}{}
+	return true
+}
+
+func (hs hashSet) remove(member string) bool {
+	if _, found := hs[member]; !found {
+		return false
+	}
+	delete(hs, member)
+	return true
+}
+
+// at walks the map to its i-th member, as maps have no positions
+func (hs hashSet) at(i int) string {
+	for member := range hs {
+		if i == 0 {
+			return member
+		}
+		i--
+	}
+	return ""
+}
+
+func (hs hashSet) each(fn func(member string) bool) {
+	for member := range hs {
+		if !fn(member) {
+			return
+		}
+	}
+}
+
+// intset is the compact encoding of a small set of integers: the members
+// in ascending order, found by binary search
+type intset struct {
+	values []int64
+}
+
+// parseSetInteger parses member as an intset element. Only the canonical
+// form of an integer qualifies, so members are returned exactly as added.
+func parseSetInteger(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+func (is *intset) len() int {
+	return len(is.values)
+}
+
+func (is *intset) contains(member string) bool {
+	v, ok := parseSetInteger(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(is.values, v)
+	return found
+}
+
+// add inserts member, which must be an integer (see fits)
+func (is *intset) add(member string) bool {
+	v, _ := parseSetInteger(member)
+	i, found := slices.BinarySearch(is.values, v)
+	if found {
+		return false
+	}
+	is.values = slices.Insert(is.values, i, v)
+	return true
+}
+
+func (is *intset) remove(member string) bool {
+	v, ok := parseSetInteger(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(is.values, v)
+	if !found {
+		return false
+	}
+	is.values = slices.Delete(is.values, i, i+1)
+	return true
+}
+
+func (is *intset) at(i int) string {
+	return strconv.FormatInt(is.values[i], 10)
+}
+
+func (is *intset) each(fn func(member string) bool) {
+	for _, v := range is.values {
+		if !fn(strconv.FormatInt(v, 10)) {
+			return
+		}
+	}
+}
+
+// clone returns an independent copy of the intset
+func (is *intset) clone() *intset {
+	return &intset{values: slices.Clone(is.values)}
+}
+
+// hashSet converts the intset to the hash table encoding
+func (is *intset) hashSet() hashSet {
+	hs := make(hashSet, len(is.values)+1)
+	for _, v := range is.values {
+		hs[strconv.FormatInt(v, 10)] = struct{}{}
+	}
+	return hs
+}
+
+// asSet returns the setValue view of a stored set value
+func asSet(value any) setValue {
+	if is, ok := value.(*intset); ok {
+		return is
+	}
+	return hashSet(value.(map[string]struct{}))
+}
+
+// setStorage returns the form a set is stored in entries
+func setStorage(set setValue) any {
+	if hs, ok := set.(hashSet); ok {
+		return map[string]struct{}(hs)
+	}
+	return set
+}
+
+// SetIntsetEntries sets the store's set-max-intset-entries: sets of
+// integers with at most this many members are stored as intsets
+func (s *Store) SetIntsetEntries(n int) {
+	s.mu.Lock()
+	s.intsetEntries = n
+	s.mu.Unlock()
+}
+
+// newSet returns an empty set in the encoding suited to its first member
+func (s *Store) newSet(first string, size int) setValue {
+	if _, ok := parseSetInteger(first); ok && s.intsetEntries > 0 {
+		return &intset{values: make([]int64, 0, min(size, s.intsetEntries))}
+	}
+	return make(hashSet, size)
+}
+
+// addMember adds member to set, converting an intset that cannot hold it to
+// a hash table. The caller stores the returned set if it changed.
+// Callers must hold the write lock.
+func (s *Store) addMember(set setValue, member string) (setValue, bool) {
+	if is, ok := set.(*intset); ok {
+		if _, isInt := parseSetInteger(member); !isInt || (is.len() >= s.intsetEntries && !is.contains(member)) {
+			set = is.hashSet()
+		}
+	}
+	return set, set.add(member)
+}
+
+// encodeSet returns the members of a newly built set in the most compact
+// encoding allowed
+func (s *Store) encodeSet(members hashSet) setValue {
+	if len(members) == 0 || len(members) > s.intsetEntries {
+		return members
+	}
+	values := make([]int64, 0, len(members))
+	for member := range members {
+		v, ok := parseSetInteger(member)
+		if !ok {
+			return members
+		}
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	return &intset{values: values}
+}
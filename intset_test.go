@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSetInteger(t *testing.T) {
+	// Test 1: Only canonical integers qualify
+	for member, want := range map[string]bool{
+		"0": true, "42": true, "-7": true, "9223372036854775807": true,
+		"": false, "01": false, "+1": false, "-0": false, "1.5": false, " 1": false,
+		"9223372036854775808": false, "abc": false,
+	} {
+		if _, ok := parseSetInteger(member); ok != want {
+			t.Errorf("parseSetInteger(%q) = %v, want %v", member, ok, want)
+		}
+	}
+}
+
+func TestIntsetEncoding(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	db := databases.Get(0)
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	encoding := func(key string) string {
+		enc, _, _, _ := db.ObjectInfo(key)
+		return enc
+	}
+
+	// Test 1: Sets of integers are intsets, kept sorted and deduplicated
+	run("SADD", "ids", "30", "-5", "10", "10")
+	if enc := encoding("ids"); enc != "intset" {
+		t.Fatalf("expected intset, got %s", enc)
+	}
+	if members, _ := db.SMembers("ids"); strings.Join(members, ",") != "-5,10,30" {
+		t.Fatalf("expected sorted members, got %v", members)
+	}
+	if reply := run("SISMEMBER", "ids", "10"); reply != ":1\r\n" {
+		t.Fatalf("expected 10 to be a member, got %q", reply)
+	}
+	if reply := run("SISMEMBER", "ids", "010"); reply != ":0\r\n" {
+		t.Fatalf("expected 010 not to be a member, got %q", reply)
+	}
+
+	// Test 2: A non-integer member converts the set, keeping its members
+	run("SADD", "ids", "x")
+	if enc := encoding("ids"); enc != "hashtable" {
+		t.Fatalf("expected hashtable after a non-integer member, got %s", enc)
+	}
+	if reply := run("SCARD", "ids"); reply != ":4\r\n" {
+		t.Fatalf("expected 4 members, got %q", reply)
+	}
+
+	// Test 3: Growing past set-max-intset-entries converts the set
+	db.SetIntsetEntries(4)
+	run("SADD", "small", "1", "2", "3", "4")
+	if enc := encoding("small"); enc != "intset" {
+		t.Fatalf("expected intset at the limit, got %s", enc)
+	}
+	run("SADD", "small", "4")
+	if enc := encoding("small"); enc != "intset" {
+		t.Fatalf("expected re-adding a member to keep the intset, got %s", enc)
+	}
+	run("SADD", "small", "5")
+	if enc := encoding("small"); enc != "hashtable" {
+		t.Fatalf("expected hashtable past the limit, got %s", enc)
+	}
+	db.SetIntsetEntries(defaultIntsetEntries)
+
+	// Test 4: Removing, popping and moving work on intsets
+	run("SADD", "nums", "1", "2", "3")
+	run("SREM", "nums", "2", "x")
+	popped, _, _ := db.SPop("nums", 1)
+	if len(popped) != 1 || (popped[0] != "1" && popped[0] != "3") {
+		t.Fatalf("unexpected popped member %v", popped)
+	}
+	rest, _ := db.SMembers("nums")
+	if reply := run("SMOVE", "nums", "other", rest[0]); reply != ":1\r\n" || encoding("other") != "intset" {
+		t.Fatalf("expected SMOVE to create an intset, got %q (%s)", reply, encoding("other"))
+	}
+	if _, exists := db.KeyType("nums"); exists {
+		t.Fatalf("expected the emptied intset to be deleted")
+	}
+
+	// Test 5: Set operations store integer results as intsets
+	run("SADD", "a", "1", "2", "3")
+	run("SADD", "b", "2", "3", "4")
+	run("SINTERSTORE", "both", "a", "b")
+	if enc := encoding("both"); enc != "intset" {
+		t.Fatalf("expected SINTERSTORE to produce an intset, got %s", enc)
+	}
+	members, _ := db.CombineSets(setUnion, []string{"a", "b", "ids"})
+	sort.Strings(members)
+	if strings.Join(members, ",") != "-5,1,10,2,3,30,4,x" {
+		t.Fatalf("unexpected union %v", members)
+	}
+
+	// Test 6: Intsets take a fraction of the memory of a hash table
+	values := make([]string, 500)
+	for i := range values {
+		values[i] = strconv.Itoa(1000000 + i)
+	}
+	db.SAdd("packed", values...)
+	db.SetIntsetEntries(0)
+	db.SAdd("table", values...)
+	db.SetIntsetEntries(defaultIntsetEntries)
+	packed, _ := db.MemoryUsage("packed", 0)
+	table, _ := db.MemoryUsage("table", 0)
+	if encoding("table") != "hashtable" || packed*3 > table {
+		t.Fatalf("expected the intset to be much smaller: %d vs %d bytes", packed, table)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Marker byte starting every key the codec rewrote
+const keyCodecMarker = 0x00
+
+// keyCodec implements key-prefix-compression: keys starting with one of a
+// fixed set of prefixes are stored with the prefix replaced by a short code,
+//
+//	<marker> <prefix number uvarint> <rest of the key>
+//
+// so a keyspace dominated by long common prefixes does not keep a copy of
+// them in every key. Keys that start with the marker byte themselves are
+// escaped as <marker> 0 <key>; all other keys are stored unchanged.
+//
+// The codec is fixed for the lifetime of a keyspace, since changing the
+// prefixes would change the stored form of existing keys.
+type keyCodec struct {
+	prefixes []string // Prefix number n+1 is prefixes[n]
+	longest  []int    // Indexes into prefixes, longest prefix first
+}
+
+// newKeyCodec creates a codec for prefixes, or returns nil if none of them
+// is long enough to be worth replacing by a code
+func newKeyCodec(prefixes []string) *keyCodec {
+	kc := &keyCodec{}
+	for _, prefix := range prefixes {
+		// The code takes at least two bytes, so shorter prefixes save nothing
+		if len(prefix) > 2 && !slices.Contains(kc.prefixes, prefix) {
+			kc.prefixes = append(kc.prefixes, prefix)
+			kc.longest = append(kc.longest, len(kc.prefixes)-1)
+		}
+	}
+	if len(kc.prefixes) == 0 {
+		return nil
+	}
+	sort.SliceStable(kc.longest, func(i, j int) bool {
+		return len(kc.prefixes[kc.longest[i]]) > len(kc.prefixes[kc.longest[j]])
+	})
+	return kc
+}
+
+// encode returns the stored form of key
+func (kc *keyCodec) encode(key string) string {
+	if kc == nil {
+		return key
+	}
+	for _, i := range kc.longest {
+		if strings.HasPrefix(key, kc.prefixes[i]) {
+			rest := key[len(kc.prefixes[i]):]
+			buf := make([]byte, 0, 1+binary.MaxVarintLen32+len(rest))
+			buf = append(buf, keyCodecMarker)
+			buf = binary.AppendUvarint(buf, uint64(i+1))
+			return string(append(buf, rest...))
+		}
+	}
+	if len(key) > 0 && key[0] == keyCodecMarker {
+		return string([]byte{keyCodecMarker, 0}) + key
+	}
+	return key
+}
+
+// decode returns the key whose stored form is stored
+func (kc *keyCodec) decode(stored string) string {
+	if kc == nil || len(stored) == 0 || stored[0] != keyCodecMarker {
+		return stored
+	}
+	// Decode the prefix number without copying the key into a byte slice
+	n, size := uint64(0), 1
+	for shift := 0; ; shift += 7 {
+		b := stored[size]
+		size++
+		n |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	rest := stored[size:]
+	if n == 0 {
+		return rest
+	}
+	return kc.prefixes[n-1] + rest
+}
+
+// SetKeyPrefixCompression makes the database store keys starting with one of
+// prefixes in compressed form. It can only be changed while the database is
+// empty, as on startup.
+func (s *Store) SetKeyPrefixCompression(prefixes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codec := newKeyCodec(prefixes)
+	if s.data.codec == nil && codec == nil {
+		return nil
+	}
+	if s.data.len() > 0 {
+		return fmt.Errorf("key-prefix-compression cannot be changed on a database holding keys")
+	}
+	s.data.codec = codec
+	return nil
+}
+
+// KeyBytesSaved returns how many bytes of key storage prefix compression saves
+func (s *Store) KeyBytesSaved() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.savedBytes
+}
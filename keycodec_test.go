@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyCodec(t *testing.T) {
+	kc := newKeyCodec([]string{"user:", "user:profile:", "ab", "user:"})
+
+	// Test 1: Keys round-trip, using the longest matching prefix
+	for _, key := range []string{"user:1", "user:profile:1", "user:", "other", "", "\x00raw", "\x00", "abc"} {
+		if got := kc.decode(kc.encode(key)); got != key {
+			t.Fatalf("round trip of %q gave %q", key, got)
+		}
+	}
+	if stored := kc.encode("user:profile:42"); stored != "\x00\x0242" {
+		t.Fatalf("expected the longest prefix to be replaced, got %q", stored)
+	}
+
+	// Test 2: Short prefixes are ignored, and keys without a prefix are unchanged
+	if stored := kc.encode("abc"); stored != "abc" {
+		t.Fatalf("expected a two-byte prefix to be left alone, got %q", stored)
+	}
+	if newKeyCodec([]string{"a", "bc"}) != nil {
+		t.Fatalf("expected no codec when no prefix is worth compressing")
+	}
+}
+
+func TestKeyPrefixCompression(t *testing.T) {
+	s := NewStore()
+	prefix := "tenant:acme:session:"
+	if err := s.SetKeyPrefixCompression([]string{prefix}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Test 1: Keys behave the same through every access path
+	for i := 0; i < 3000; i++ {
+		s.Set(fmt.Sprintf("%s%d", prefix, i), "v")
+	}
+	s.Set("\x00odd", "v")
+	s.Push(prefix+"queue", false, "job")
+	if value, exists, _ := s.Get(prefix + "1234"); !exists || value != "v" {
+		t.Fatalf("expected compressed key to be readable")
+	}
+	if value, exists, _ := s.Get("\x00odd"); !exists || value != "v" {
+		t.Fatalf("expected escaped key to be readable")
+	}
+	seen := scanAll(s, ScanOptions{Count: 100})
+	if len(seen) != 3002 {
+		t.Fatalf("expected 3002 keys from SCAN, got %d", len(seen))
+	}
+	for key := range seen {
+		if !strings.HasPrefix(key, prefix) && key != "\x00odd" {
+			t.Fatalf("SCAN returned undecoded key %q", key)
+		}
+	}
+	if seen := scanAll(s, ScanOptions{Type: TypeList}); seen[prefix+"queue"] != 1 {
+		t.Fatalf("expected the type index to decode keys, got %v", seen)
+	}
+	if key, ok := s.RandomKey(); !ok || (!strings.HasPrefix(key, prefix) && key != "\x00odd") {
+		t.Fatalf("unexpected random key %q", key)
+	}
+
+	// Test 2: Bytes saved are tracked through deletions
+	saved := s.KeyBytesSaved()
+	if saved < 3000*(len(prefix)-2) {
+		t.Fatalf("expected at least %d bytes saved, got %d", 3000*(len(prefix)-2), saved)
+	}
+	s.Del(prefix + "0")
+	if s.KeyBytesSaved() != saved-(len(prefix)-2) {
+		t.Fatalf("expected deletion to give back the savings of one key")
+	}
+
+	// Test 3: Expired compressed keys are found by the expiration cycle
+	s.mu.Lock()
+	s.put(prefix+"short", &Entry{Type: TypeString, Value: "v", ExpiresAt: time.Now().Add(-time.Second)})
+	s.mu.Unlock()
+	s.activeExpire()
+	if s.data.lookup(prefix+"short") != nil {
+		t.Fatalf("expected the expired key to be removed")
+	}
+
+	// Test 4: The prefixes cannot change once keys exist, but survive a flush
+	if err := s.SetKeyPrefixCompression([]string{"other:"}); err == nil {
+		t.Fatalf("expected an error on a non-empty database")
+	}
+	s.Flush(false)
+	s.Set(prefix+"again", "v")
+	if s.KeyBytesSaved() != len(prefix)-2 {
+		t.Fatalf("expected compression to stay on after FLUSHDB")
+	}
+}
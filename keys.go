@@ -0,0 +1,211 @@
+package main
+
+import (
+	"strings"
+)
+
+// clone returns a deep copy of the entry, so that mutating the copy's value
+// never affects the original
+func (e *Entry) clone() *Entry {
+	return &Entry{
+		Type:      e.Type,
+		Value:     cloneValue(e.Value),
+		ExpiresAt: e.ExpiresAt,
+	}
+}
+
+// cloneValue deep-copies the Go representation of an entry value
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case *deque:
+		return v.clone()
+	case *listpack:
+		return v.clone()
+	case *intset:
+		return v.clone()
+	case *hashPack:
+		return v.clone()
+	case *zset:
+		return v.clone()
+	case *stream:
+		return v.clone()
+	case map[string]string:
+		copied := make(map[string]string, len(v))
+		for field, val := range v {
+			copied[field] = val
+		}
+		return copied
+	case map[string]struct{}:
+		copied := make(map[string]struct{}, len(v))
+		for member := range v {
+			copied[member] = struct{}{}
+		}
+		return copied
+	default:
+		// Strings and other immutable values can be shared
+		return v
+	}
+}
+
+// Copy duplicates the value stored at src into dst
+// Returns false if src does not exist, or if dst exists and replace is false
+func (s *Store) Copy(src, dst string, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.data.get(src)
+	if entry == nil {
+		return false
+	}
+	if !replace && s.data.get(dst) != nil {
+		return false
+	}
+	s.put(dst, entry.clone())
+	return true
+}
+
+// RandomKey returns a random key that has not expired, or false if the
+// store has none
+func (s *Store) RandomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.randomKey(clockNow())
+}
+
+// Touch marks the given keys as accessed and returns how many exist
+func (s *Store) Touch(keys ...string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, key := range keys {
+		if s.data.get(key) != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// DBSize returns the number of keys in the store, not counting keys that
+// have expired but are not removed yet
+func (s *Store) DBSize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.liveCount(clockNow())
+}
+
+// Flush removes every key. With async set, the old keyspace is swapped out
+// under the lock and released on a background goroutine, so clients are
+// not stalled while a large dataset is torn down.
+func (s *Store) Flush(async bool) {
+	s.mu.Lock()
+	s.touchAllWatched(nil)
+	old := s.data
+	s.data = newKeyspace()
+	s.data.codec = old.codec
+	s.hot.clear()
+	s.tombstones = make(map[string]*tombstone)
+	s.tombstoneOrder = nil
+	s.scanIndexes = nil
+	s.stopExpireTimers()
+	s.mu.Unlock()
+
+	if async {
+		go old.release()
+	} else {
+		old.release()
+	}
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func copyCommand(c *Client, args []string) string {
+	replace := false
+	dst := c.dbIndex
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return formatError("ERR syntax error")
+			}
+			i++
+			index, errReply := parseDBIndex(args[i])
+			if errReply != "" {
+				return errReply
+			}
+			dst = index
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	if args[1] == args[2] && dst == c.dbIndex {
+		return formatError("ERR source and destination objects are the same")
+	}
+
+	if databases.CopyTo(args[1], c.dbIndex, args[2], dst, replace) {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
+
+// RANDOMKEY
+func randomKeyCommand(c *Client, args []string) string {
+	key, ok := c.db().RandomKey()
+	if !ok {
+		return formatNullBulkString()
+	}
+	return formatBulkString(key)
+}
+
+// TOUCH key [key ...]
+func touchCommand(c *Client, args []string) string {
+	return formatInteger(c.db().Touch(args[1:]...))
+}
+
+// DBSIZE
+func dbsizeCommand(c *Client, args []string) string {
+	return formatInteger(c.db().DBSize())
+}
+
+// parseFlushMode parses the optional ASYNC/SYNC argument of FLUSHDB and FLUSHALL
+func parseFlushMode(args []string) (bool, bool) {
+	if len(args) > 2 {
+		return false, false
+	}
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "ASYNC":
+			return true, true
+		case "SYNC":
+		default:
+			return false, false
+		}
+	}
+	return false, true
+}
+
+// FLUSHDB [ASYNC | SYNC]
+func flushdbCommand(c *Client, args []string) string {
+	async, ok := parseFlushMode(args)
+	if !ok {
+		return formatError("ERR syntax error")
+	}
+	c.db().Flush(async)
+	return formatSimpleString("OK")
+}
+
+// FLUSHALL [ASYNC | SYNC]
+func flushallCommand(c *Client, args []string) string {
+	async, ok := parseFlushMode(args)
+	if !ok {
+		return formatError("ERR syntax error")
+	}
+	for _, db := range databases.All() {
+		db.Flush(async)
+	}
+	return formatSimpleString("OK")
+}
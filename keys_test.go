@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStoreCopy(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: Copy a string
+	store.Set("src", "hello")
+	if !store.Copy("src", "dst", false) {
+		t.Fatalf("expected copy to succeed")
+	}
+	value, exists, _ := store.Get("dst")
+	if !exists || value != "hello" {
+		t.Fatalf("expected (hello, true), got (%s, %v)", value, exists)
+	}
+
+	// Test 2: Existing destination requires REPLACE
+	store.Set("src", "world")
+	if store.Copy("src", "dst", false) {
+		t.Fatalf("expected copy onto existing key to fail without replace")
+	}
+	if !store.Copy("src", "dst", true) {
+		t.Fatalf("expected copy with replace to succeed")
+	}
+	value, _, _ = store.Get("dst")
+	if value != "world" {
+		t.Fatalf("expected world after replace, got %s", value)
+	}
+
+	// Test 3: Missing source
+	if store.Copy("nosuch", "dst2", false) {
+		t.Fatalf("expected copy of missing key to fail")
+	}
+
+	// Test 4: Collections are deep-copied
+	original := []string{"a", "b"}
+	store.SetForTesting("list", TypeList, original)
+	store.Copy("list", "listcopy", false)
+	store.mu.RLock()
+	copied := store.data.get("listcopy").Value.([]string)
+	store.mu.RUnlock()
+	copied[0] = "changed"
+	if original[0] != "a" {
+		t.Fatalf("modifying the copy mutated the source list")
+	}
+}
+
+func TestStoreRandomKeyAndTouch(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: Empty store has no random key
+	if _, ok := store.RandomKey(); ok {
+		t.Fatalf("expected no random key in empty store")
+	}
+
+	// Test 2: Every key is eventually picked
+	for _, key := range []string{"a", "b", "c"} {
+		store.Set(key, "v")
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 1000 && len(seen) < 3; i++ {
+		key, ok := store.RandomKey()
+		if !ok {
+			t.Fatalf("expected a random key")
+		}
+		seen[key] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected all 3 keys to be picked, got %v", seen)
+	}
+
+	// Test 3: TOUCH counts existing keys only
+	if count := store.Touch("a", "b", "nosuch"); count != 2 {
+		t.Fatalf("expected 2 touched keys, got %d", count)
+	}
+}
+
+func TestKeyspaceCountsSkipExpiredKeys(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	SetClock(vc)
+	defer SetClock(nil)
+
+	store := NewStore()
+	store.Set("live", "v")
+	for i := 0; i < 500; i++ {
+		key := "gone:" + strconv.Itoa(i)
+		store.Restore(key, &Entry{Type: TypeString, Value: "v", ExpiresAt: start.Add(time.Second)}, false)
+	}
+	store.Restore("later", &Entry{Type: TypeString, Value: "v", ExpiresAt: start.Add(time.Hour)}, false)
+	vc.Advance(time.Second)
+
+	// Test 1: DBSIZE excludes keys that expired but are not removed yet
+	if size := store.DBSize(); size != 2 {
+		t.Fatalf("expected 2 live keys, got %d", size)
+	}
+
+	// Test 2: SCAN never returns expired keys
+	var scanned []string
+	cursor := uint64(0)
+	for {
+		var keys []string
+		keys, cursor = store.Scan(cursor, ScanOptions{Count: 10})
+		scanned = append(scanned, keys...)
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(scanned) != 2 {
+		t.Fatalf("expected SCAN to return only live keys, got %v", scanned)
+	}
+
+	// Test 3: RANDOMKEY picks again until it finds a live key
+	for i := 0; i < 50; i++ {
+		key, ok := store.RandomKey()
+		if !ok || (key != "live" && key != "later") {
+			t.Fatalf("expected a live random key, got %q (%v)", key, ok)
+		}
+	}
+	store.Del("live", "later")
+	if key, ok := store.RandomKey(); ok {
+		t.Fatalf("expected no random key when every key expired, got %q", key)
+	}
+	if size := store.DBSize(); size != 0 {
+		t.Fatalf("expected 0 live keys, got %d", size)
+	}
+
+	// Test 4: DBSIZE follows keys whose expiration time is replaced,
+	// removed or reached within the current microsecond
+	now := vc.Now()
+	store.Restore("gone:0", &Entry{Type: TypeString, Value: "v", ExpiresAt: now.Add(time.Minute)}, true)
+	store.Set("gone:1", "v")
+	store.Del("gone:2")
+	store.Restore("exact", &Entry{Type: TypeString, Value: "v", ExpiresAt: now}, false)
+	store.Restore("soon", &Entry{Type: TypeString, Value: "v", ExpiresAt: now.Add(time.Nanosecond)}, false)
+	if size := store.DBSize(); size != 3 {
+		t.Fatalf("expected 3 live keys, got %d", size)
+	}
+	vc.Advance(time.Minute)
+	if size := store.DBSize(); size != 1 {
+		t.Fatalf("expected 1 live key, got %d", size)
+	}
+}
+
+func TestStoreFlush(t *testing.T) {
+	store := NewStore()
+
+	for _, async := range []bool{false, true} {
+		store.MSet("a", "1", "b", "2", "c", "3")
+		if size := store.DBSize(); size != 3 {
+			t.Fatalf("expected 3 keys, got %d", size)
+		}
+
+		store.Flush(async)
+		if size := store.DBSize(); size != 0 {
+			t.Fatalf("expected empty store after flush (async=%v), got %d keys", async, size)
+		}
+		if _, exists, _ := store.Get("a"); exists {
+			t.Fatalf("expected key a to be gone after flush (async=%v)", async)
+		}
+
+		// The store stays usable after a flush
+		store.Set("after", "v")
+		if value, _, _ := store.Get("after"); value != "v" {
+			t.Fatalf("expected store to accept writes after flush, got %q", value)
+		}
+		store.Del("after")
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand/v2"
+	"time"
+)
+
+// Bucket sizing for the keyspace hash table
+const (
+	keyspaceInitialBuckets = 16 // Number of buckets in an empty keyspace (power of two)
+	keyspaceMaxLoad        = 32 // Average keys per bucket before the table doubles
+	keyspaceMinLoad        = 4  // Average keys per bucket below which the table halves
+	rehashEmptyVisits      = 64 // Empty buckets a single rehash step may skip
+)
+
+// keyspace is a hash table made of small maps ("buckets").
+// Keys are assigned to buckets by the low bits of their hash, and the number of
+// buckets is always a power of two. This layout is what makes SCAN possible:
+// a cursor names a bucket, and walking the bucket indexes in reverse-binary
+// order visits every key that stays in the table for the whole iteration at
+// least once, even when the table doubles or halves between calls.
+//
+// Resizing is incremental, as in Redis's dict: a second table is allocated
+// and buckets are migrated to it a few at a time, by every write and by the
+// background rehash cycle, so no single command pays for moving the whole
+// table. Migrating a bucket also rebuilds its map, which releases the space
+// Go maps keep after deletions. While a resize is in progress, a key lives in
+// exactly one of the two tables.
+//
+// Entries whose expiration time has passed are treated as absent by get, and
+// are physically removed by the active expiration cycle (or when overwritten).
+//
+// The keys of each collection type are also kept in a per-type index, itself
+// a keyspace, so SCAN ... TYPE visits only keys of that type; see typeindex.go.
+type keyspace struct {
+	seed      maphash.Seed
+	buckets   []map[string]*Entry // Main table; nil buckets are empty
+	rehashTo  []map[string]*Entry // Table being migrated to, nil when not resizing
+	rehashIdx int                 // Next bucket of the main table to migrate
+	count     int
+	expires   map[string]struct{}  // Keys that have an expiration time; nil in type indexes
+	deadlines *skiplist            // The same keys ordered by expiration time, in Unix microseconds
+	types     map[string]int       // Number of keys of each type
+	indexes   map[string]*keyspace // Keys of each indexed type; nil in type indexes
+
+	codec      *keyCodec // Stored form of keys when key-prefix-compression is on, else nil
+	savedBytes int       // Key bytes the codec saves
+}
+
+// newKeyspace creates an empty keyspace
+func newKeyspace() *keyspace {
+	return &keyspace{
+		seed:      maphash.MakeSeed(),
+		buckets:   make([]map[string]*Entry, keyspaceInitialBuckets),
+		expires:   make(map[string]struct{}),
+		deadlines: newSkiplist(),
+		types:     make(map[string]int),
+		indexes:   make(map[string]*keyspace),
+	}
+}
+
+// deadline returns the element ordering the key stored as stored, which
+// expires at expiresAt, among the deadlines
+func deadline(stored string, expiresAt time.Time) zsetElement {
+	return zsetElement{member: stored, score: float64(expiresAt.UnixMicro())}
+}
+
+func tableMask(table []map[string]*Entry) uint64 {
+	return uint64(len(table) - 1)
+}
+
+func (ks *keyspace) hash(key string) uint64 {
+	return maphash.String(ks.seed, key)
+}
+
+// lookup returns the entry stored under key in either table, expired or not
+func (ks *keyspace) lookup(key string) *Entry {
+	return ks.find(ks.codec.encode(key))
+}
+
+// find is lookup for a key in stored form
+func (ks *keyspace) find(stored string) *Entry {
+	h := ks.hash(stored)
+	if entry := ks.buckets[h&tableMask(ks.buckets)][stored]; entry != nil {
+		return entry
+	}
+	if ks.rehashTo != nil {
+		return ks.rehashTo[h&tableMask(ks.rehashTo)][stored]
+	}
+	return nil
+}
+
+// len returns the number of keys in the keyspace
+func (ks *keyspace) len() int {
+	return ks.count
+}
+
+// liveCount returns the number of keys in the keyspace that have not
+// expired yet. Expired keys awaiting removal are the first deadlines, found
+// in O(log n); only those in the microsecond of now need checking one by one.
+func (ks *keyspace) liveCount(now time.Time) int {
+	micros := float64(now.UnixMicro())
+	expired := ks.deadlines.search(func(e zsetElement) bool { return e.score >= micros })
+	for x := ks.deadlines.nodeAt(expired); x != nil && x.score == micros; x = x.next() {
+		if ks.find(x.member).expired(now) {
+			expired++
+		}
+	}
+	return ks.count - expired
+}
+
+// get returns the live entry stored under key, or nil if it is missing or
+// expired, and records the access in the entry's LRU/LFU metadata
+func (ks *keyspace) get(key string) *Entry {
+	now := clockNow()
+	entry := ks.lookup(key)
+	if entry == nil || entry.expired(now) {
+		return nil
+	}
+	entry.touch(now)
+	return entry
+}
+
+// peek is like get but leaves the access metadata untouched
+func (ks *keyspace) peek(key string) *Entry {
+	entry := ks.lookup(key)
+	if entry == nil || entry.expired(clockNow()) {
+		return nil
+	}
+	return entry
+}
+
+// set inserts or replaces the entry stored under key
+func (ks *keyspace) set(key string, entry *Entry) {
+	ks.rehashStep()
+
+	stored := ks.codec.encode(key)
+	h := ks.hash(stored)
+	table := ks.buckets
+	if ks.rehashTo != nil {
+		// New keys go to the new table; existing ones are replaced where they are
+		if _, exists := ks.buckets[h&tableMask(ks.buckets)][stored]; !exists {
+			table = ks.rehashTo
+		}
+	}
+	i := h & tableMask(table)
+	if table[i] == nil {
+		table[i] = make(map[string]*Entry)
+	}
+	old, exists := table[i][stored]
+	if !exists {
+		ks.count++
+		ks.savedBytes += len(key) - len(stored)
+	}
+	table[i][stored] = entry
+	entry.initAccess(clockNow())
+	if ks.indexes != nil {
+		ks.reindex(key, old, entry)
+	}
+	switch {
+	case ks.expires == nil:
+		// Type indexes do not track expirations
+	case exists && old.ExpiresAt.Equal(entry.ExpiresAt):
+	default:
+		if exists && !old.ExpiresAt.IsZero() {
+			ks.deadlines.delete(deadline(stored, old.ExpiresAt))
+		}
+		if entry.ExpiresAt.IsZero() {
+			delete(ks.expires, stored)
+		} else {
+			ks.expires[stored] = struct{}{}
+			ks.deadlines.insert(deadline(stored, entry.ExpiresAt))
+		}
+	}
+
+	if ks.count > len(ks.buckets)*keyspaceMaxLoad {
+		ks.startResize(len(ks.buckets) * 2)
+	}
+}
+
+// expire changes the expiration time of key, which holds entry, to at
+func (ks *keyspace) expire(key string, entry *Entry, at time.Time) {
+	stored := ks.codec.encode(key)
+	if !entry.ExpiresAt.IsZero() {
+		ks.deadlines.delete(deadline(stored, entry.ExpiresAt))
+	}
+	entry.ExpiresAt = at
+	ks.expires[stored] = struct{}{}
+	ks.deadlines.insert(deadline(stored, at))
+}
+
+// delete removes key and reports whether it was present and live
+func (ks *keyspace) delete(key string) bool {
+	ks.rehashStep()
+
+	stored := ks.codec.encode(key)
+	h := ks.hash(stored)
+	bucket := ks.buckets[h&tableMask(ks.buckets)]
+	entry, exists := bucket[stored]
+	if !exists && ks.rehashTo != nil {
+		bucket = ks.rehashTo[h&tableMask(ks.rehashTo)]
+		entry, exists = bucket[stored]
+	}
+	if !exists {
+		return false
+	}
+	delete(bucket, stored)
+	if !entry.ExpiresAt.IsZero() && ks.expires != nil {
+		delete(ks.expires, stored)
+		ks.deadlines.delete(deadline(stored, entry.ExpiresAt))
+	}
+	ks.count--
+	ks.savedBytes -= len(key) - len(stored)
+	if ks.indexes != nil {
+		ks.reindex(key, entry, nil)
+	}
+
+	if len(ks.buckets) > keyspaceInitialBuckets && ks.count < len(ks.buckets)*keyspaceMinLoad {
+		ks.startResize(len(ks.buckets) / 2)
+	}
+	return !entry.expired(clockNow())
+}
+
+// startResize begins migrating the keys to a table of n buckets
+// A resize already in progress is left to finish first.
+func (ks *keyspace) startResize(n int) {
+	if ks.rehashTo != nil {
+		return
+	}
+	ks.rehashTo = make([]map[string]*Entry, n)
+	ks.rehashIdx = 0
+}
+
+// rehashStep migrates the next non-empty bucket of the main table, visiting
+// at most rehashEmptyVisits empty ones. Reports whether a resize is still in
+// progress afterwards.
+func (ks *keyspace) rehashStep() bool {
+	if ks.rehashTo == nil {
+		return false
+	}
+	mask := tableMask(ks.rehashTo)
+	for visits := 0; ks.rehashIdx < len(ks.buckets); visits++ {
+		bucket := ks.buckets[ks.rehashIdx]
+		ks.buckets[ks.rehashIdx] = nil
+		ks.rehashIdx++
+		if len(bucket) > 0 {
+			for key, entry := range bucket {
+				i := ks.hash(key) & mask
+				if ks.rehashTo[i] == nil {
+					ks.rehashTo[i] = make(map[string]*Entry)
+				}
+				ks.rehashTo[i][key] = entry
+			}
+			break
+		}
+		if visits == rehashEmptyVisits {
+			break
+		}
+	}
+
+	if ks.rehashIdx == len(ks.buckets) {
+		ks.buckets = ks.rehashTo
+		ks.rehashTo = nil
+		ks.rehashIdx = 0
+		return false
+	}
+	return true
+}
+
+// rehashFor runs rehash steps until the resize completes or budget elapses
+// Reports whether a resize is still in progress.
+func (ks *keyspace) rehashFor(budget time.Duration) bool {
+	start := time.Now()
+	for ks.rehashStep() {
+		if time.Since(start) >= budget {
+			return true
+		}
+	}
+	return false
+}
+
+// RehashStats describes the state of a keyspace's hash table
+type RehashStats struct {
+	Buckets   int  // Buckets of the main table
+	Rehashing bool // Whether keys are being migrated to a new table
+	Target    int  // Buckets of the table being migrated to
+	Migrated  int  // Buckets of the main table already migrated
+}
+
+func (ks *keyspace) rehashStats() RehashStats {
+	return RehashStats{
+		Buckets:   len(ks.buckets),
+		Rehashing: ks.rehashTo != nil,
+		Target:    len(ks.rehashTo),
+		Migrated:  ks.rehashIdx,
+	}
+}
+
+// expiresCount returns the number of keys with an expiration time
+func (ks *keyspace) expiresCount() int {
+	return len(ks.expires)
+}
+
+// expireSample checks up to n keys that have an expiration time and removes
+// the expired ones. Returns how many keys were checked, and the removed keys.
+func (ks *keyspace) expireSample(now time.Time, n int) (checked int, expired []string) {
+	for stored := range ks.expires {
+		if checked == n {
+			break
+		}
+		checked++
+		if ks.find(stored).expired(now) {
+			expired = append(expired, ks.codec.decode(stored))
+		}
+	}
+	for _, key := range expired {
+		ks.delete(key)
+	}
+	return checked, expired
+}
+
+// Random picks RANDOMKEY makes before looking for a live key one by one
+const randomKeyTries = 100
+
+// randomKey returns a uniformly chosen live key, or false if the keyspace
+// has none. Expired keys awaiting removal are skipped by picking again.
+func (ks *keyspace) randomKey(now time.Time) (string, bool) {
+	for try := 0; try < randomKeyTries; try++ {
+		key, entry := ks.randomEntry()
+		if entry == nil {
+			return "", false
+		}
+		if !entry.expired(now) {
+			return key, true
+		}
+	}
+	// Nearly every key has expired: walk the keyspace for one that has not
+	found := ""
+	live := false
+	ks.forEach(func(key string, entry *Entry) bool {
+		found, live = key, !entry.expired(now)
+		return !live
+	})
+	if !live {
+		return "", false
+	}
+	return found, true
+}
+
+// randomEntry returns a uniformly chosen key, expired or not, and its entry,
+// or a nil entry if the keyspace is empty
+func (ks *keyspace) randomEntry() (string, *Entry) {
+	if ks.count == 0 {
+		return "", nil
+	}
+	n := rand.IntN(ks.count)
+	for _, table := range [][]map[string]*Entry{ks.buckets, ks.rehashTo} {
+		for _, bucket := range table {
+			if n >= len(bucket) {
+				n -= len(bucket)
+				continue
+			}
+			for key, entry := range bucket {
+				if n == 0 {
+					return ks.codec.decode(key), entry
+				}
+				n--
+			}
+		}
+	}
+	return "", nil
+}
+
+// forEach calls fn for every key until fn returns false
+func (ks *keyspace) forEach(fn func(key string, entry *Entry) bool) {
+	for _, table := range [][]map[string]*Entry{ks.buckets, ks.rehashTo} {
+		for _, bucket := range table {
+			for key, entry := range bucket {
+				if !fn(ks.codec.decode(key), entry) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// release drops every key so the memory can be reclaimed
+// The keyspace must no longer be reachable by other goroutines
+func (ks *keyspace) release() {
+	for _, table := range [][]map[string]*Entry{ks.buckets, ks.rehashTo} {
+		for i := range table {
+			clear(table[i])
+			table[i] = nil
+		}
+	}
+	for _, index := range ks.indexes {
+		index.release()
+	}
+	ks.buckets = nil
+	ks.rehashTo = nil
+	ks.count = 0
+	ks.expires = nil
+	if ks.deadlines != nil {
+		ks.deadlines.clear()
+		ks.deadlines = nil
+	}
+	ks.types = nil
+	ks.indexes = nil
+}
+
+// nextCursor advances a reverse-binary cursor over a table with the given mask
+func nextCursor(cursor, mask uint64) uint64 {
+	// Set the unmasked bits so the increment carries into the masked ones
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// scanBucket calls fn for every key in the bucket addressed by cursor and
+// returns the cursor of the next bucket to visit (0 once the walk is complete).
+//
+// The cursor is advanced by incrementing its bit-reversed value, so the high
+// bits of the bucket index change first. When the table doubles, every bucket
+// already visited maps to buckets whose indexes are also "behind" the cursor,
+// and when it halves the merged buckets are at worst visited twice.
+//
+// During a resize the cursor addresses a bucket of the smaller table, and
+// every bucket of the larger table that it expands to is visited as well.
+func (ks *keyspace) scanBucket(cursor uint64, fn func(key string, entry *Entry)) uint64 {
+	if ks.rehashTo == nil {
+		mask := tableMask(ks.buckets)
+		for key, entry := range ks.buckets[cursor&mask] {
+			fn(ks.codec.decode(key), entry)
+		}
+		return nextCursor(cursor, mask)
+	}
+
+	small, large := ks.buckets, ks.rehashTo
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	m0, m1 := tableMask(small), tableMask(large)
+	for key, entry := range small[cursor&m0] {
+		fn(ks.codec.decode(key), entry)
+	}
+	for {
+		for key, entry := range large[cursor&m1] {
+			fn(ks.codec.decode(key), entry)
+		}
+		cursor = nextCursor(cursor, m1)
+		// Continue while the bits only the larger table uses are non-zero
+		if cursor&(m0^m1) == 0 {
+			return cursor
+		}
+	}
+}
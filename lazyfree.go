@@ -0,0 +1,66 @@
+package main
+
+import "sync/atomic"
+
+// Values with more elements than this are counted as freed lazily by UNLINK
+const lazyFreeThreshold = 64
+
+// Large values unlinked, reported as lazyfreed_objects
+var lazyFreed atomic.Int64
+
+// valueLength returns the number of elements held by a collection value
+func valueLength(value interface{}) int {
+	switch v := value.(type) {
+	case []string:
+		return len(v)
+	case listValue:
+		return v.len()
+	case map[string]string:
+		return len(v)
+	case map[string]struct{}:
+		return len(v)
+	case *intset:
+		return v.len()
+	case *hashPack:
+		return v.len()
+	case *zset:
+		return v.len()
+	case *stream:
+		return v.len()
+	default:
+		return 1
+	}
+}
+
+// Unlink removes keys like Del. Redis frees large values on a background
+// thread; here dropping the entry is all the work there is, since the garbage
+// collector never marks a value that is no longer reachable and sweeps its
+// memory concurrently, so walking the value to clear it would only add work.
+// Large values are still counted, for INFO.
+func (s *Store) Unlink(keys ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, key := range keys {
+		entry := s.data.get(key)
+		if entry == nil {
+			continue
+		}
+		s.data.delete(key)
+		s.keyModified(key)
+		count++
+
+		// Tombstoned values stay intact for UNDELETE
+		if s.tombstoneWindow > 0 {
+			s.bury(key, entry)
+		} else if valueLength(entry.Value) > lazyFreeThreshold {
+			lazyFreed.Add(1)
+		}
+	}
+	return count
+}
+
+// UNLINK key [key ...]
+func unlinkCommand(c *Client, args []string) string {
+	return formatInteger(c.db().Unlink(args[1:]...))
+}
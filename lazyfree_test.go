@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreUnlink(t *testing.T) {
+	store := NewStore()
+
+	big := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		big[fmt.Sprintf("m%d", i)] = struct{}{}
+	}
+	store.SetForTesting("big", TypeSet, big)
+	store.Set("small", "v")
+
+	// Test 1: Keys disappear immediately and are counted like DEL
+	before := lazyFreed.Load()
+	if count := store.Unlink("big", "small", "nosuch"); count != 2 {
+		t.Fatalf("expected 2 unlinked keys, got %d", count)
+	}
+	if _, exists := store.KeyType("big"); exists {
+		t.Fatalf("expected big to be gone right after UNLINK")
+	}
+
+	// Test 2: The large value is counted as freed lazily
+	if lazyFreed.Load() != before+1 {
+		t.Fatalf("expected one large value freed, got %d", lazyFreed.Load()-before)
+	}
+
+	// Test 3: With soft deletion the value is kept intact for UNDELETE
+	store.SetTombstoneWindow(time.Hour)
+	store.SetForTesting("big", TypeSet, map[string]struct{}{"a": {}})
+	store.Unlink("big")
+	if !store.Undelete("big", false) {
+		t.Fatalf("expected unlinked key to be restorable")
+	}
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// listValue is the representation of a list value: a listpack while the
+// list is small, a deque once it outgrows list-max-listpack-size
+type listValue interface {
+	len() int
+	pushFront(v string)
+	pushBack(v string)
+	popFront() string
+	popBack() string
+	at(i int) string
+	set(i int, v string)
+	insert(i int, v string)
+	indexOf(v string) int
+	each(reverse bool, fn func(i int, v string) bool)
+	retain(keep func(i int) bool)
+	slice(start, stop int) []string
+	values() []string
+	clear()
+}
+
+// list returns the list stored at key. The list is nil if the key does
+// not exist; ok is false if the key holds another type.
+// Callers must hold the lock.
+func (s *Store) list(key string) (listValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeList {
+		return nil, false
+	}
+	return entry.Value.(listValue), true
+}
+
+// mutableList is like list for callers about to change the list in place:
+// a list borrowed by readers outside the lock is copied first
+// Callers must hold the write lock.
+func (s *Store) mutableList(key string) (listValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeList {
+		return nil, false
+	}
+	entry.own()
+	return entry.Value.(listValue), true
+}
+
+// listModified must be called after changing the list at key in place;
+// an emptied list is removed, as Redis never keeps empty lists, and one that
+// grew or shrank past the listpack limits changes encoding
+// Callers must hold the write lock.
+func (s *Store) listModified(key string, list listValue) {
+	if list.len() == 0 {
+		s.data.delete(key)
+	} else {
+		s.reencodeList(key, list)
+	}
+	s.keyModified(key)
+}
+
+// Push adds values to the head (front) or tail of the list at key, creating it if needed
+// Returns (new length, isCorrectType)
+func (s *Store) Push(key string, front bool, values ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.push(key, front, values...)
+}
+
+// push is Push for callers holding the write lock
+func (s *Store) push(key string, front bool, values ...string) (int, bool) {
+	list, ok := s.mutableList(key)
+	if !ok {
+		return 0, false
+	}
+	created := list == nil
+	if created {
+		list = newListpack()
+	}
+	stored := list
+	for _, v := range values {
+		if front {
+			list.pushFront(v)
+		} else {
+			list.pushBack(v)
+		}
+		if _, packed := list.(*listpack); packed {
+			// Convert as soon as the limit is crossed, not after a long variadic push
+			list = s.encodeList(list)
+		}
+	}
+	if created {
+		s.put(key, &Entry{Type: TypeList, Value: list})
+	} else {
+		if list != stored {
+			s.data.lookup(key).Value = list
+		}
+		s.keyModified(key)
+	}
+	return list.len(), true
+}
+
+// PushExisting is Push for lists that already exist: a missing key is left
+// missing and reported with a length of 0
+func (s *Store) PushExisting(key string, front bool, values ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.mutableList(key)
+	if !ok {
+		return 0, false
+	}
+	if list == nil {
+		return 0, true
+	}
+	return s.push(key, front, values...)
+}
+
+// Pop removes up to count elements from the head (front) or tail of the list at key
+// Returns (elements, exists, isCorrectType)
+func (s *Store) Pop(key string, front bool, count int) ([]string, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pop(key, front, count)
+}
+
+// pop is Pop for callers holding the write lock
+func (s *Store) pop(key string, front bool, count int) ([]string, bool, bool) {
+	list, ok := s.mutableList(key)
+	if !ok {
+		return nil, true, false
+	}
+	if list == nil {
+		return nil, false, true
+	}
+	popped := make([]string, 0, min(count, list.len()))
+	for len(popped) < count && list.len() > 0 {
+		if front {
+			popped = append(popped, list.popFront())
+		} else {
+			popped = append(popped, list.popBack())
+		}
+	}
+	if len(popped) > 0 {
+		s.listModified(key, list)
+	}
+	return popped, true, true
+}
+
+// MPop pops up to count elements from the first non-empty list among keys
+// Returns (key, elements, isCorrectType)
+func (s *Store) MPop(keys []string, front bool, count int) (string, []string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		popped, _, ok := s.pop(key, front, count)
+		if !ok {
+			return "", nil, false
+		}
+		if len(popped) > 0 {
+			return key, popped, true
+		}
+	}
+	return "", nil, true
+}
+
+// LMove pops an element from one end of the list at src and pushes it to
+// one end of the list at dst, atomically
+// Returns (element, moved, isCorrectType)
+func (s *Store) LMove(src, dst string, fromFront, toFront bool) (string, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lmove(src, dst, fromFront, toFront)
+}
+
+// lmove is LMove for callers holding the write lock
+func (s *Store) lmove(src, dst string, fromFront, toFront bool) (string, bool, bool) {
+	list, ok := s.mutableList(src)
+	if !ok {
+		return "", false, false
+	}
+	if list == nil {
+		return "", false, true
+	}
+	if src == dst {
+		// Rotate in place so a single-element list is never deleted and recreated
+		var element string
+		if fromFront {
+			element = list.popFront()
+		} else {
+			element = list.popBack()
+		}
+		if toFront {
+			list.pushFront(element)
+		} else {
+			list.pushBack(element)
+		}
+		s.keyModified(src)
+		return element, true, true
+	}
+	if _, ok := s.list(dst); !ok {
+		return "", false, false
+	}
+	popped, _, _ := s.pop(src, fromFront, 1)
+	s.push(dst, toFront, popped[0])
+	return popped[0], true, true
+}
+
+// LLen returns the length of the list at key, 0 if it does not exist
+// Returns (length, isCorrectType)
+func (s *Store) LLen(key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.list(key)
+	if !ok || list == nil {
+		return 0, ok
+	}
+	return list.len(), true
+}
+
+// LRange returns the elements of the list at key between start and stop
+// inclusive, where negative indexes count from the tail
+// Returns (elements, isCorrectType)
+func (s *Store) LRange(key string, start, stop int) ([]string, bool) {
+	s.mu.RLock()
+	list, ok := s.list(key)
+	if !ok || list == nil {
+		s.mu.RUnlock()
+		return []string{}, ok
+	}
+	start, stop, ok = clampRange(start, stop, list.len())
+	if !ok {
+		s.mu.RUnlock()
+		return []string{}, true
+	}
+	if stop-start+1 < cowMinElements {
+		defer s.mu.RUnlock()
+		return list.slice(start, stop), true
+	}
+
+	// Copy long ranges without holding up writers
+	share := s.data.lookup(key).borrow()
+	s.mu.RUnlock()
+	defer share.release()
+	return list.slice(start, stop), true
+}
+
+// normalizeIndex converts a possibly negative index into an index of a
+// sequence of length n; ok is false if it is out of range
+func normalizeIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
+
+// LIndex returns the element at index of the list at key
+// Returns (element, found, isCorrectType)
+func (s *Store) LIndex(key string, index int) (string, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.list(key)
+	if !ok || list == nil {
+		return "", false, ok
+	}
+	index, found := normalizeIndex(index, list.len())
+	if !found {
+		return "", false, true
+	}
+	return list.at(index), true, true
+}
+
+// LSet replaces the element at index of the list at key
+// Returns a non-empty error reply on failure
+func (s *Store) LSet(key string, index int, element string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.mutableList(key)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if list == nil {
+		return formatError("ERR no such key")
+	}
+	index, found := normalizeIndex(index, list.len())
+	if !found {
+		return formatError("ERR index out of range")
+	}
+	list.set(index, element)
+	s.listModified(key, list)
+	return ""
+}
+
+// LInsert inserts element before or after the first occurrence of pivot
+// Returns (new length, -1 if pivot is missing, 0 if the key is missing), isCorrectType
+func (s *Store) LInsert(key string, before bool, pivot, element string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.mutableList(key)
+	if !ok || list == nil {
+		return 0, ok
+	}
+	index := list.indexOf(pivot)
+	if index < 0 {
+		return -1, true
+	}
+	if !before {
+		index++
+	}
+	list.insert(index, element)
+	s.listModified(key, list)
+	return list.len(), true
+}
+
+// LRem removes occurrences of element from the list at key: the first count
+// from the head if count > 0, the last -count from the tail if count < 0,
+// or all of them if count is 0
+// Returns (removed, isCorrectType)
+func (s *Store) LRem(key string, count int, element string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.mutableList(key)
+	if !ok || list == nil {
+		return 0, ok
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	matches := make(map[int]bool)
+	list.each(count < 0, func(i int, v string) bool {
+		if v == element {
+			matches[i] = true
+		}
+		return limit == 0 || len(matches) < limit
+	})
+	if len(matches) > 0 {
+		list.retain(func(i int) bool { return !matches[i] })
+		s.listModified(key, list)
+	}
+	return len(matches), true
+}
+
+// LTrim keeps only the elements between start and stop inclusive of the list at key
+// Returns isCorrectType
+func (s *Store) LTrim(key string, start, stop int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.mutableList(key)
+	if !ok || list == nil {
+		return ok
+	}
+	length := list.len()
+	start, stop, nonEmpty := clampRange(start, stop, length)
+	if !nonEmpty {
+		list.clear()
+	} else {
+		for i := 0; i < start; i++ {
+			list.popFront()
+		}
+		for i := stop + 1; i < length; i++ {
+			list.popBack()
+		}
+	}
+	if list.len() != length {
+		s.listModified(key, list)
+	}
+	return true
+}
+
+// LPos returns the indexes of elements equal to element in the list at key.
+// Matching starts at the rank-th match (negative ranks search from the tail),
+// stops after count matches (0 for all) and compares at most maxLen
+// elements (0 for all).
+// Returns (indexes, isCorrectType)
+func (s *Store) LPos(key, element string, rank, count, maxLen int) ([]int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.list(key)
+	if !ok || list == nil {
+		return nil, ok
+	}
+
+	var matches []int
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	compared := 0
+	list.each(rank < 0, func(i int, v string) bool {
+		if maxLen > 0 && compared == maxLen {
+			return false
+		}
+		compared++
+		if v != element {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		matches = append(matches, i)
+		return count == 0 || len(matches) < count
+	})
+	return matches, true
+}
+
+// clampRange converts an inclusive range with Redis's negative index
+// semantics into valid indexes of a sequence of length n
+// ok is false if the range is empty.
+func clampRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// LPUSH key element [element ...]
+func lpushCommand(c *Client, args []string) string {
+	return pushReply(c, args, true)
+}
+
+// RPUSH key element [element ...]
+func rpushCommand(c *Client, args []string) string {
+	return pushReply(c, args, false)
+}
+
+// LPUSHX key element [element ...]
+func lpushxCommand(c *Client, args []string) string {
+	return pushxReply(c, args, true)
+}
+
+// RPUSHX key element [element ...]
+func rpushxCommand(c *Client, args []string) string {
+	return pushxReply(c, args, false)
+}
+
+func pushReply(c *Client, args []string, front bool) string {
+	length, ok := c.db().Push(args[1], front, args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(length)
+}
+
+func pushxReply(c *Client, args []string, front bool) string {
+	length, ok := c.db().PushExisting(args[1], front, args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(length)
+}
+
+// LPOP key [count]
+func lpopCommand(c *Client, args []string) string {
+	return popReply(c, args, true)
+}
+
+// RPOP key [count]
+func rpopCommand(c *Client, args []string) string {
+	return popReply(c, args, false)
+}
+
+// popReply replies with a single element, or an array when a count is given
+func popReply(c *Client, args []string, front bool) string {
+	if len(args) > 3 {
+		return formatError("ERR syntax error")
+	}
+	count, withCount := 1, len(args) == 3
+	if withCount {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return formatError("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	popped, exists, ok := c.db().Pop(args[1], front, count)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !exists {
+		if withCount {
+			return formatNullArray()
+		}
+		return formatNullBulkString()
+	}
+	if withCount {
+		return formatArray(popped)
+	}
+	if len(popped) == 0 {
+		return formatNullBulkString()
+	}
+	return formatBulkString(popped[0])
+}
+
+// LLEN key
+func llenCommand(c *Client, args []string) string {
+	length, ok := c.db().LLen(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(length)
+}
+
+// LRANGE key start stop
+func lrangeCommand(c *Client, args []string) string {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	elements, ok := c.db().LRange(args[1], start, stop)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatArray(elements)
+}
+
+// LINDEX key index
+func lindexCommand(c *Client, args []string) string {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	element, found, ok := c.db().LIndex(args[1], index)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !found {
+		return formatNullBulkString()
+	}
+	return formatBulkString(element)
+}
+
+// LSET key index element
+func lsetCommand(c *Client, args []string) string {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if errReply := c.db().LSet(args[1], index, args[3]); errReply != "" {
+		return errReply
+	}
+	return formatSimpleString("OK")
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func linsertCommand(c *Client, args []string) string {
+	var before bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return formatError("ERR syntax error")
+	}
+	length, ok := c.db().LInsert(args[1], before, args[3], args[4])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(length)
+}
+
+// LREM key count element
+func lremCommand(c *Client, args []string) string {
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	removed, ok := c.db().LRem(args[1], count, args[3])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(removed)
+}
+
+// LTRIM key start stop
+func ltrimCommand(c *Client, args []string) string {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if !c.db().LTrim(args[1], start, stop) {
+		return formatError(wrongTypeError)
+	}
+	return formatSimpleString("OK")
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lposCommand(c *Client, args []string) string {
+	rank, count, maxLen := 1, 1, 0
+	withCount := false
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return formatError("ERR syntax error")
+		}
+		value, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if value == 0 {
+				return formatError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				return formatError("ERR COUNT can't be negative")
+			}
+			count, withCount = value, true
+		case "MAXLEN":
+			if value < 0 {
+				return formatError("ERR MAXLEN can't be negative")
+			}
+			maxLen = value
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	matches, ok := c.db().LPos(args[1], args[2], rank, count, maxLen)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !withCount {
+		if len(matches) == 0 {
+			return formatNullBulkString()
+		}
+		return formatInteger(matches[0])
+	}
+	reply := "*" + strconv.Itoa(len(matches)) + "\r\n"
+	for _, index := range matches {
+		reply += formatInteger(index)
+	}
+	return reply
+}
+
+// parseListEnd parses a LEFT or RIGHT argument; front is true for LEFT
+func parseListEnd(arg string) (front bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmoveCommand(c *Client, args []string) string {
+	fromFront, ok1 := parseListEnd(args[3])
+	toFront, ok2 := parseListEnd(args[4])
+	if !ok1 || !ok2 {
+		return formatError("ERR syntax error")
+	}
+	element, moved, ok := c.db().LMove(args[1], args[2], fromFront, toFront)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if !moved {
+		return formatNullBulkString()
+	}
+	return formatBulkString(element)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeque(t *testing.T) {
+	d := newDeque()
+
+	// Test 1: Pushes at both ends keep order across buffer growth
+	for i := 0; i < 20; i++ {
+		d.pushBack(fmt.Sprint(i))
+		d.pushFront(fmt.Sprint(-i - 1))
+	}
+	if d.len() != 40 || d.at(0) != "-20" || d.at(39) != "19" {
+		t.Fatalf("unexpected deque contents: %v", d.values())
+	}
+
+	// Test 2: Pops drain from both ends and shrink the buffer
+	for i := 0; i < 19; i++ {
+		d.popFront()
+		d.popBack()
+	}
+	if got := d.values(); !reflect.DeepEqual(got, []string{"-1", "0"}) {
+		t.Fatalf("expected [-1 0], got %v", got)
+	}
+	if len(d.buf) != dequeMinCapacity {
+		t.Fatalf("expected buffer to shrink to %d, got %d", dequeMinCapacity, len(d.buf))
+	}
+
+	// Test 3: Inserts shift whichever side is shorter
+	d.insert(0, "first")
+	d.insert(d.len(), "last")
+	d.insert(1, "near-front")
+	d.insert(3, "near-back")
+	if got := d.values(); !reflect.DeepEqual(got, []string{"first", "near-front", "-1", "near-back", "0", "last"}) {
+		t.Fatalf("unexpected contents after inserts: %v", got)
+	}
+	for i := 0; i < 3; i++ {
+		d.popFront()
+	}
+	d.popBack()
+
+	// Test 4: Clones are independent
+	copied := d.clone()
+	copied.pushBack("x")
+	if d.len() != 2 || copied.len() != 3 {
+		t.Fatalf("expected clone to be independent")
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: Variadic pushes at both ends
+	if reply := run("RPUSH", "l", "a", "b", "c"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+	if reply := run("LPUSH", "l", "y", "z"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"z", "y", "a", "b", "c"}) {
+		t.Fatalf("unexpected LRANGE reply %q", reply)
+	}
+
+	// Test 2: LRANGE clamps out-of-range indexes
+	if reply := run("LRANGE", "l", "-2", "100"); reply != formatArray([]string{"b", "c"}) {
+		t.Fatalf("unexpected LRANGE reply %q", reply)
+	}
+	if reply := run("LRANGE", "l", "4", "2"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+
+	// Test 3: Pops with and without a count
+	if reply := run("LPOP", "l"); reply != "$1\r\nz\r\n" {
+		t.Fatalf("expected z, got %q", reply)
+	}
+	if reply := run("RPOP", "l", "2"); reply != formatArray([]string{"c", "b"}) {
+		t.Fatalf("expected [c b], got %q", reply)
+	}
+	if reply := run("LPOP", "l", "-1"); reply != "-ERR value is out of range, must be positive\r\n" {
+		t.Fatalf("expected range error, got %q", reply)
+	}
+	if reply := run("LLEN", "l"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+
+	// Test 4: Emptying the list removes the key
+	run("RPOP", "l", "10")
+	if reply := run("LLEN", "l"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if _, exists := databases.Get(0).KeyType("l"); exists {
+		t.Fatalf("expected empty list to be deleted")
+	}
+	if reply := run("LPOP", "l"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+	if reply := run("LPOP", "l", "1"); reply != "*-1\r\n" {
+		t.Fatalf("expected null array, got %q", reply)
+	}
+
+	// Test 5: WRONGTYPE on non-list keys
+	run("SET", "s", "v")
+	for _, args := range [][]string{{"LPUSH", "s", "x"}, {"RPOP", "s"}, {"LLEN", "s"}, {"LRANGE", "s", "0", "1"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+	if reply := run("GET", "s"); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected string to be untouched, got %q", reply)
+	}
+}
+
+func TestListPushIfExists(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: Missing keys are not created
+	if reply := run("LPUSHX", "l", "a"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if reply := run("RPUSHX", "l", "a", "b"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if _, exists := databases.Get(0).KeyType("l"); exists {
+		t.Fatalf("expected PUSHX not to create the list")
+	}
+
+	// Test 2: Existing lists are pushed to at either end
+	run("RPUSH", "l", "m")
+	if reply := run("LPUSHX", "l", "b", "a"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+	if reply := run("RPUSHX", "l", "y", "z"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"a", "b", "m", "y", "z"}) {
+		t.Fatalf("unexpected LRANGE reply %q", reply)
+	}
+
+	// Test 3: WRONGTYPE on non-list keys
+	run("SET", "s", "v")
+	if reply := run("LPUSHX", "s", "x"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
+
+func TestListPositionalCommands(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "l", "a", "b", "c")
+
+	// Test 1: LINDEX with positive, negative and out-of-range indexes
+	if reply := run("LINDEX", "l", "0"); reply != "$1\r\na\r\n" {
+		t.Fatalf("expected a, got %q", reply)
+	}
+	if reply := run("LINDEX", "l", "-1"); reply != "$1\r\nc\r\n" {
+		t.Fatalf("expected c, got %q", reply)
+	}
+	if reply := run("LINDEX", "l", "3"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+
+	// Test 2: LSET replaces in place and reports bad indexes and keys
+	if reply := run("LSET", "l", "-2", "B"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := run("LSET", "l", "5", "x"); reply != "-ERR index out of range\r\n" {
+		t.Fatalf("expected index error, got %q", reply)
+	}
+	if reply := run("LSET", "nosuch", "0", "x"); reply != "-ERR no such key\r\n" {
+		t.Fatalf("expected no such key, got %q", reply)
+	}
+
+	// Test 3: LINSERT before and after a pivot
+	if reply := run("LINSERT", "l", "BEFORE", "B", "a2"); reply != ":4\r\n" {
+		t.Fatalf("expected :4, got %q", reply)
+	}
+	if reply := run("LINSERT", "l", "after", "c", "d"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"a", "a2", "B", "c", "d"}) {
+		t.Fatalf("unexpected list %q", reply)
+	}
+
+	// Test 4: Missing pivot, missing key and bad position keyword
+	if reply := run("LINSERT", "l", "BEFORE", "zz", "x"); reply != ":-1\r\n" {
+		t.Fatalf("expected :-1, got %q", reply)
+	}
+	if reply := run("LINSERT", "nosuch", "BEFORE", "a", "x"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if reply := run("LINSERT", "l", "MIDDLE", "a", "x"); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected syntax error, got %q", reply)
+	}
+}
+
+func TestListRemoveAndTrim(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "l", "x", "a", "x", "b", "x", "c", "x")
+
+	// Test 1: Positive count removes from the head
+	if reply := run("LREM", "l", "2", "x"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"a", "b", "x", "c", "x"}) {
+		t.Fatalf("unexpected list %q", reply)
+	}
+
+	// Test 2: Negative count removes from the tail
+	if reply := run("LREM", "l", "-1", "x"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"a", "b", "x", "c"}) {
+		t.Fatalf("unexpected list %q", reply)
+	}
+
+	// Test 3: Zero removes every occurrence
+	run("RPUSH", "l", "x", "x")
+	if reply := run("LREM", "l", "0", "x"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+
+	// Test 4: LTRIM with negative indexes
+	run("RPUSH", "l", "d", "e")
+	if reply := run("LTRIM", "l", "1", "-2"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if reply := run("LRANGE", "l", "0", "-1"); reply != formatArray([]string{"b", "c", "d"}) {
+		t.Fatalf("unexpected list %q", reply)
+	}
+
+	// Test 5: An empty range deletes the key
+	if reply := run("LTRIM", "l", "5", "10"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if _, exists := databases.Get(0).KeyType("l"); exists {
+		t.Fatalf("expected trimmed-away list to be deleted")
+	}
+
+	// Test 6: Removing the last element deletes the key
+	run("RPUSH", "m", "only")
+	run("LREM", "m", "0", "only")
+	if _, exists := databases.Get(0).KeyType("m"); exists {
+		t.Fatalf("expected emptied list to be deleted")
+	}
+}
+
+func TestListPos(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	// Test 1: First match, and no match
+	if reply := run("LPOS", "l", "c"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+	if reply := run("LPOS", "l", "zz"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+
+	// Test 2: RANK skips matches, negative ranks search from the tail
+	if reply := run("LPOS", "l", "c", "RANK", "2"); reply != ":6\r\n" {
+		t.Fatalf("expected :6, got %q", reply)
+	}
+	if reply := run("LPOS", "l", "c", "RANK", "-1"); reply != ":7\r\n" {
+		t.Fatalf("expected :7, got %q", reply)
+	}
+
+	// Test 3: COUNT returns arrays, COUNT 0 returns every match
+	if reply := run("LPOS", "l", "c", "COUNT", "2"); reply != "*2\r\n:2\r\n:6\r\n" {
+		t.Fatalf("unexpected COUNT reply %q", reply)
+	}
+	if reply := run("LPOS", "l", "c", "COUNT", "0", "RANK", "-1"); reply != "*3\r\n:7\r\n:6\r\n:2\r\n" {
+		t.Fatalf("unexpected COUNT 0 reply %q", reply)
+	}
+	if reply := run("LPOS", "nosuch", "c", "COUNT", "1"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array for missing key, got %q", reply)
+	}
+
+	// Test 4: MAXLEN bounds the number of comparisons
+	if reply := run("LPOS", "l", "c", "COUNT", "0", "MAXLEN", "3"); reply != "*1\r\n:2\r\n" {
+		t.Fatalf("unexpected MAXLEN reply %q", reply)
+	}
+
+	// Test 5: Argument validation
+	if reply := run("LPOS", "l", "c", "RANK", "0"); !strings.HasPrefix(reply, "-ERR RANK can't be zero") {
+		t.Fatalf("expected RANK error, got %q", reply)
+	}
+	if reply := run("LPOS", "l", "c", "COUNT", "-1"); reply != "-ERR COUNT can't be negative\r\n" {
+		t.Fatalf("expected COUNT error, got %q", reply)
+	}
+	if reply := run("LPOS", "l", "c", "MAXLEN"); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected syntax error, got %q", reply)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listener kinds
+const (
+	listenerTCP  = "tcp"  // RESP over plain TCP
+	listenerTLS  = "tls"  // RESP over TLS
+	listenerUnix = "unix" // RESP over a Unix domain socket
+	listenerHTTP = "http" // Admin HTTP endpoint running one command per request
+)
+
+// Command categories a listener can allow
+const (
+	categoryData       = "data"       // Commands reading or writing keys
+	categoryAdmin      = "admin"      // Server administration and introspection
+	categoryConnection = "connection" // Connection state; allowed on every listener
+)
+
+// Name of the listener created from the port directive
+const defaultListenerName = "default"
+
+// Category of every command and subcommand, which a test checks are all
+// listed
+var commandCategories = map[string]string{
+	"ping":              categoryConnection,
+	"echo":              categoryConnection,
+	"hello":             categoryConnection,
+	"auth":              categoryConnection,
+	"select":            categoryConnection,
+	"client":            categoryConnection,
+	"client|id":         categoryConnection,
+	"client|info":       categoryConnection,
+	"client|attributes": categoryConnection,
+	"client|help":       categoryConnection,
+	"waitaof":           categoryConnection,
+	"quit":              categoryConnection,
+	"reset":             categoryConnection,
+	"multi":             categoryConnection,
+	"exec":              categoryConnection,
+	"discard":           categoryConnection,
+	"watch":             categoryConnection,
+	"unwatch":           categoryConnection,
+	"subscribe":         categoryConnection,
+	"unsubscribe":       categoryConnection,
+	"psubscribe":        categoryConnection,
+	"punsubscribe":      categoryConnection,
+	"info":              categoryAdmin,
+	"debug":             categoryAdmin,
+	"save":              categoryAdmin,
+	"bgsave":            categoryAdmin,
+	"lastsave":          categoryAdmin,
+	"memory":            categoryAdmin,
+	"memory|help":       categoryAdmin,
+	"memory|usage":      categoryAdmin,
+	"memory|prefixes":   categoryAdmin,
+	"flushdb":           categoryAdmin,
+	"flushall":          categoryAdmin,
+	"shutdown":          categoryAdmin,
+	"config":            categoryAdmin,
+	"config|get":        categoryAdmin,
+	"config|set":        categoryAdmin,
+	"config|help":       categoryAdmin,
+	"swapdb":            categoryAdmin,
+
+	// Acting on other connections is administration
+	"client|list":    categoryAdmin,
+	"client|kill":    categoryAdmin,
+	"client|unblock": categoryAdmin,
+
+	// As is acting on server-wide state or the running script
+	"undelete":         categoryAdmin,
+	"script|flush":     categoryAdmin,
+	"script|kill":      categoryAdmin,
+	"function|flush":   categoryAdmin,
+	"function|delete":  categoryAdmin,
+	"function|restore": categoryAdmin,
+	"function|kill":    categoryAdmin,
+
+	// Keys and strings
+	"get": categoryData, "set": categoryData, "mget": categoryData, "mset": categoryData,
+	"del": categoryData, "unlink": categoryData, "copy": categoryData, "move": categoryData,
+	"touch": categoryData, "randomkey": categoryData, "dbsize": categoryData, "scan": categoryData,
+	"dump": categoryData, "restore": categoryData, "convert": categoryData, "ttl": categoryData,
+	"pttl": categoryData, "expiretime": categoryData, "pexpiretime": categoryData, "pexpireat": categoryData,
+	"object": categoryData, "object|encoding": categoryData, "object|freq": categoryData,
+	"object|idletime": categoryData, "object|refcount": categoryData, "object|help": categoryData,
+
+	// Bitmaps and HyperLogLogs
+	"bitcount": categoryData, "bitop": categoryData, "bitpos": categoryData, "bitfield": categoryData,
+	"bitfield_ro": categoryData, "pfadd": categoryData, "pfcount": categoryData,
+	"pfmerge": categoryData,
+
+	// Lists
+	"lpush": categoryData, "rpush": categoryData, "lpushx": categoryData, "rpushx": categoryData,
+	"lpop": categoryData, "rpop": categoryData, "llen": categoryData, "lrange": categoryData,
+	"lindex": categoryData, "lset": categoryData, "linsert": categoryData, "lrem": categoryData,
+	"ltrim": categoryData, "lpos": categoryData, "lmove": categoryData, "lmpop": categoryData,
+	"blpop": categoryData, "brpop": categoryData, "blmove": categoryData, "blmpop": categoryData,
+
+	// Sets
+	"sadd": categoryData, "srem": categoryData, "smembers": categoryData, "sismember": categoryData,
+	"smismember": categoryData, "scard": categoryData, "smove": categoryData, "spop": categoryData,
+	"srandmember": categoryData, "sunion": categoryData, "sinter": categoryData,
+	"sdiff": categoryData, "sunionstore": categoryData, "sinterstore": categoryData,
+	"sdiffstore": categoryData, "sscan": categoryData,
+
+	// Hashes
+	"hset": categoryData, "hget": categoryData, "hdel": categoryData, "hgetall": categoryData,
+	"hlen": categoryData, "hexists": categoryData, "hmget": categoryData, "hsetnx": categoryData,
+	"hkeys": categoryData, "hvals": categoryData, "hstrlen": categoryData, "hincrby": categoryData,
+	"hrandfield": categoryData, "hscan": categoryData,
+
+	// Sorted sets
+	"zadd": categoryData, "zscore": categoryData, "zmscore": categoryData, "zcard": categoryData,
+	"zrank": categoryData, "zrevrank": categoryData, "zrandmember": categoryData,
+	"zrange": categoryData, "zrevrange": categoryData, "zrangebyscore": categoryData,
+	"zrevrangebyscore": categoryData, "zrangebylex": categoryData, "zrevrangebylex": categoryData,
+	"zcount": categoryData, "zlexcount": categoryData, "zincrby": categoryData, "zrem": categoryData,
+	"zremrangebyrank": categoryData, "zremrangebyscore": categoryData, "zremrangebylex": categoryData,
+	"zpopmin": categoryData, "zpopmax": categoryData, "bzpopmin": categoryData,
+	"bzpopmax": categoryData, "zmpop": categoryData, "bzmpop": categoryData, "zunion": categoryData,
+	"zinter": categoryData, "zdiff": categoryData, "zunionstore": categoryData,
+	"zinterstore": categoryData, "zdiffstore": categoryData, "zscan": categoryData,
+
+	// Geo
+	"geoadd": categoryData, "geopos": categoryData, "geodist": categoryData, "geohash": categoryData,
+	"geosearch": categoryData, "geosearchstore": categoryData,
+
+	// Streams
+	"xadd": categoryData, "xlen": categoryData, "xrange": categoryData, "xrevrange": categoryData,
+	"xtrim": categoryData, "xdel": categoryData, "xsetid": categoryData, "xinfo": categoryData,
+	"xread": categoryData, "xgroup": categoryData, "xreadgroup": categoryData, "xack": categoryData,
+	"xpending": categoryData, "xclaim": categoryData, "xautoclaim": categoryData,
+
+	// Pub/sub
+	"publish": categoryData, "pubsub": categoryData, "pubsub|channels": categoryData,
+	"pubsub|numsub": categoryData, "pubsub|numpat": categoryData,
+	"pubsub|shardchannels": categoryData, "pubsub|help": categoryData,
+
+	// Scripts and functions
+	"eval": categoryData, "evalsha": categoryData, "script": categoryData,
+	"script|load": categoryData, "script|exists": categoryData, "script|help": categoryData,
+	"fcall": categoryData, "fcall_ro": categoryData, "function": categoryData,
+	"function|load": categoryData, "function|list": categoryData, "function|dump": categoryData,
+	"function|help": categoryData,
+}
+
+// Commands a client may run before authenticating
+var noAuthCommands = map[string]bool{"auth": true, "hello": true, "quit": true, "reset": true}
+
+// commandCategory returns the category of the command registered as name
+func commandCategory(name string) string {
+	if category, ok := commandCategories[strings.ToLower(name)]; ok {
+		return category
+	}
+	return categoryData
+}
+
+// ListenerConfig is one network endpoint clients connect to, with its own
+// authentication and the command categories it accepts
+type ListenerConfig struct {
+	Name       string   // Name reported in errors and logs
+	Kind       string   // tcp, tls, unix or http
+	Address    string   // host:port to bind, or the socket path of a unix listener
+	Password   string   // Password AUTH must present before other commands (empty means none)
+	Categories []string // Command categories allowed (empty means all)
+	CertFile   string   // TLS certificate, for tls listeners
+	KeyFile    string   // TLS private key, for tls listeners
+}
+
+// parseListener parses the arguments of a listener directive:
+//
+//	listener <name> <kind> <address> [password <pw>] [commands <category,...>] [cert <file> key <file>]
+func parseListener(args []string) (ListenerConfig, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return ListenerConfig{}, fmt.Errorf("wrong number of arguments for 'listener'")
+	}
+	lc := ListenerConfig{Name: args[0], Kind: strings.ToLower(args[1]), Address: args[2]}
+	switch lc.Kind {
+	case listenerTCP, listenerTLS, listenerUnix, listenerHTTP:
+	default:
+		return lc, fmt.Errorf("listener '%s' has unknown kind '%s': use tcp, tls, unix or http", lc.Name, args[1])
+	}
+
+	for i := 3; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "password":
+			lc.Password = value
+		case "commands":
+			for _, category := range strings.Split(strings.ToLower(value), ",") {
+				switch category {
+				case categoryData, categoryAdmin, categoryConnection:
+					lc.Categories = append(lc.Categories, category)
+				default:
+					return lc, fmt.Errorf("listener '%s' has unknown command category '%s'", lc.Name, category)
+				}
+			}
+		case "cert":
+			lc.CertFile = value
+		case "key":
+			lc.KeyFile = value
+		default:
+			return lc, fmt.Errorf("listener '%s' has unknown option '%s'", lc.Name, args[i])
+		}
+	}
+
+	if lc.Kind == listenerTLS && (lc.CertFile == "" || lc.KeyFile == "") {
+		return lc, fmt.Errorf("tls listener '%s' needs both cert and key", lc.Name)
+	}
+	if lc.Kind != listenerTLS && (lc.CertFile != "" || lc.KeyFile != "") {
+		return lc, fmt.Errorf("listener '%s' is not a tls listener, cert and key do not apply", lc.Name)
+	}
+	return lc, nil
+}
+
+// listeners returns every listener to start: the one on port, unless port is
+// 0, followed by those of listener directives
+func (cfg *Config) listeners() []ListenerConfig {
+	var all []ListenerConfig
+	if cfg.Port != 0 {
+		all = append(all, ListenerConfig{
+			Name:    defaultListenerName,
+			Kind:    listenerTCP,
+			Address: ":" + strconv.Itoa(cfg.Port),
+		})
+	}
+	return append(all, cfg.Listeners...)
+}
+
+// listenerPolicy is what a listener demands of the clients connected to it
+type listenerPolicy struct {
+	name     string
+	password string
+	allowed  []string // Allowed categories besides connection, nil allowing all
+}
+
+func (lc ListenerConfig) policy() *listenerPolicy {
+	return &listenerPolicy{name: lc.Name, password: lc.Password, allowed: lc.Categories}
+}
+
+// check returns the error reply refusing cmd to c, or "" if it may run
+// A nil policy, as for clients created internally, allows everything.
+func (p *listenerPolicy) check(c *Client, cmd *Command) string {
+	if p == nil {
+		return ""
+	}
+	if p.password != "" && !c.authenticated && !noAuthCommands[cmd.Canonical] {
+		return formatError("NOAUTH Authentication required.")
+	}
+	if cmd.Category != categoryConnection && p.allowed != nil && !slices.Contains(p.allowed, cmd.Category) {
+		return formatError(fmt.Sprintf("NOPERM listener '%s' does not allow the '%s' command (%s)", p.name, cmd.Name, cmd.Category))
+	}
+	return ""
+}
+
+// authenticate checks password against the listener's and marks the client
+// authenticated on a match. Only the default user exists.
+func (c *Client) authenticate(username, password string) bool {
+	if c.policy == nil || username != "default" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(c.policy.password)) != 1 {
+		return false
+	}
+	c.authenticated = true
+	return true
+}
+
+// Error of AUTH and HELLO AUTH on a failed authentication
+const wrongPassError = "WRONGPASS invalid username-password pair or user is disabled."
+
+// AUTH [username] password
+func authCommand(c *Client, args []string) string {
+	if len(args) > 3 {
+		return formatError("ERR syntax error")
+	}
+	if c.policy == nil || c.policy.password == "" {
+		return formatError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	username, password := "default", args[1]
+	if len(args) == 3 {
+		username, password = args[1], args[2]
+	}
+	if !c.authenticate(username, password) {
+		return formatError(wrongPassError)
+	}
+	return formatSimpleString("OK")
+}
+
+// bind opens the socket the listener accepts on: a Unix domain socket for
+// unix listeners, a TCP socket for the others
+func (lc ListenerConfig) bind() (net.Listener, error) {
+	if lc.Kind == listenerUnix {
+		// A socket left behind by a previous run would make the bind fail
+		if info, err := os.Stat(lc.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(lc.Address)
+		}
+		return net.Listen("unix", lc.Address)
+	}
+	return net.Listen("tcp", lc.Address)
+}
+
+// wrap adds the TLS layer of tls listeners over a bound socket
+func (lc ListenerConfig) wrap(socket net.Listener) (net.Listener, error) {
+	if lc.Kind != listenerTLS {
+		return socket, nil
+	}
+	cert, err := tls.LoadX509KeyPair(lc.CertFile, lc.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(socket, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// servedListener is a listener being served, with the socket below it so
+// the socket can be handed to another process
+type servedListener struct {
+	config ListenerConfig
+	socket net.Listener // The bound socket, below any TLS layer
+	closer io.Closer    // Stops serving, closing the socket
+}
+
+// openListener serves the listener in the background on socket, binding a
+// new one if socket is nil
+func openListener(lc ListenerConfig, socket net.Listener) (*servedListener, error) {
+	if socket == nil {
+		var err error
+		if socket, err = lc.bind(); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := lc.wrap(socket)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	if lc.Kind == listenerHTTP {
+		server := &http.Server{Handler: adminHandler(lc.policy()), ReadHeaderTimeout: 10 * time.Second}
+		go server.Serve(ln)
+		return &servedListener{config: lc, socket: socket, closer: server}, nil
+	}
+	go serveListener(ln, lc.policy())
+	return &servedListener{config: lc, socket: socket, closer: ln}, nil
+}
+
+// startListener binds the listener and serves it in the background
+// The returned closer stops it.
+func startListener(lc ListenerConfig) (io.Closer, net.Addr, error) {
+	served, err := openListener(lc, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return served.closer, served.socket.Addr(), nil
+}
+
+// serveListener accepts RESP connections until the listener is closed
+func serveListener(ln net.Listener, policy *listenerPolicy) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("Error accepting connection on listener '%s': %v\n", policy.name, err)
+			continue
+		}
+
+		if !admitConnection(conn) {
+			continue
+		}
+
+		fmt.Println("New client connected")
+		go handleConnection(conn, policy)
+	}
+}
+
+// Largest request body the admin endpoint reads
+const adminMaxBody = 1 << 20
+
+// adminHandler serves an http listener:
+//
+//	GET /healthz    replies OK while the server runs
+//	POST /command   runs the command given as a JSON array of arguments and
+//	                replies with its RESP encoding
+//
+// A listener password must be sent as "Authorization: Bearer <password>".
+func adminHandler(policy *listenerPolicy) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	})
+	mux.HandleFunc("POST /command", func(w http.ResponseWriter, r *http.Request) {
+		var args []string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxBody)).Decode(&args); err != nil || len(args) == 0 {
+			http.Error(w, "request body must be a non-empty JSON array of strings", http.StatusBadRequest)
+			return
+		}
+
+		client := &Client{policy: policy}
+		defer broker.unsubscribeAll(client)
+		defer client.unwatchAll()
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && policy.password != "" {
+			client.authenticate("default", token)
+		}
+
+		reply := client.execute(args)
+		status := http.StatusOK
+		switch {
+		case strings.HasPrefix(reply, "-NOAUTH"):
+			status = http.StatusUnauthorized
+		case strings.HasPrefix(reply, "-NOPERM"):
+			status = http.StatusForbidden
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	})
+	return mux
+}
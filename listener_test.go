@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListener(t *testing.T) {
+	// Test 1: Options are parsed and port keeps its own listener
+	cfg, err := ParseConfig(strings.NewReader("port 7000\nlistener internal tcp 127.0.0.1:7001 password s3cret commands data,admin\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	all := cfg.listeners()
+	if len(all) != 2 || all[0].Name != defaultListenerName || all[0].Address != ":7000" {
+		t.Fatalf("expected the default listener first, got %v", all)
+	}
+	if lc := all[1]; lc.Password != "s3cret" || len(lc.Categories) != 2 || lc.Categories[1] != categoryAdmin {
+		t.Fatalf("expected password and categories to be parsed, got %+v", lc)
+	}
+
+	// Test 2: port 0 disables the default listener
+	cfg, _ = ParseConfig(strings.NewReader("port 0\nlistener sock unix /tmp/redisgo.sock\n"))
+	if all := cfg.listeners(); len(all) != 1 || all[0].Kind != listenerUnix {
+		t.Fatalf("expected only the unix listener, got %v", all)
+	}
+
+	// Test 3: Invalid definitions are rejected
+	for _, line := range []string{
+		"listener a udp :7001",
+		"listener a tcp :7001 commands data,bogus",
+		"listener a tls :7001",
+		"listener a tcp :7001 cert c.pem key k.pem",
+		"listener a tcp :7001 password",
+		"listener default tcp :7001",
+		"listener a tcp :7001\nlistener a tcp :7002",
+	} {
+		if _, err := ParseConfig(strings.NewReader(line + "\n")); err == nil {
+			t.Fatalf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestListenerPolicy(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	public := ListenerConfig{Name: "public", Password: "pw", Categories: []string{categoryData}}
+	client := &Client{policy: public.policy()}
+
+	// Test 1: Commands other than AUTH and HELLO need authentication
+	if reply := client.execute([]string{"SET", "k", "v"}); reply != "-NOAUTH Authentication required.\r\n" {
+		t.Fatalf("expected NOAUTH, got %q", reply)
+	}
+	if reply := client.execute([]string{"HELLO", "3"}); !strings.HasPrefix(reply, "-NOAUTH") {
+		t.Fatalf("expected HELLO without AUTH to be refused, got %q", reply)
+	}
+
+	// Test 2: A wrong password or user is refused
+	if reply := client.execute([]string{"AUTH", "nope"}); !strings.HasPrefix(reply, "-WRONGPASS") {
+		t.Fatalf("expected WRONGPASS, got %q", reply)
+	}
+	if reply := client.execute([]string{"AUTH", "admin", "pw"}); !strings.HasPrefix(reply, "-WRONGPASS") {
+		t.Fatalf("expected WRONGPASS for an unknown user, got %q", reply)
+	}
+
+	// Test 3: After AUTH, data commands run but admin ones are refused
+	if reply := client.execute([]string{"AUTH", "pw"}); reply != "+OK\r\n" {
+		t.Fatalf("expected OK, got %q", reply)
+	}
+	if reply := client.execute([]string{"SET", "k", "v"}); reply != "+OK\r\n" {
+		t.Fatalf("expected SET to run, got %q", reply)
+	}
+	if reply := client.execute([]string{"FLUSHALL"}); !strings.HasPrefix(reply, "-NOPERM listener 'public'") {
+		t.Fatalf("expected NOPERM for FLUSHALL, got %q", reply)
+	}
+	if reply := client.execute([]string{"PING"}); reply != "+PONG\r\n" {
+		t.Fatalf("expected connection commands to be allowed, got %q", reply)
+	}
+
+	// Test 4: HELLO AUTH authenticates and switches protocol at once
+	other := &Client{policy: public.policy()}
+	if reply := other.execute([]string{"HELLO", "3", "AUTH", "default", "pw"}); !strings.HasPrefix(reply, "%") || !other.authenticated {
+		t.Fatalf("expected a RESP3 map and an authenticated client, got %q", reply)
+	}
+
+	// Test 5: AUTH without a configured password is an error
+	open := ListenerConfig{Name: "open"}
+	if reply := (&Client{policy: open.policy()}).execute([]string{"AUTH", "pw"}); !strings.Contains(reply, "without any password configured") {
+		t.Fatalf("expected AUTH to fail without a password, got %q", reply)
+	}
+
+	// Test 6: Commands carry the category listeners check, every command
+	// and subcommand having one listed
+	if cmd := lookupCommand("flushdb"); cmd.Category != categoryAdmin {
+		t.Fatalf("expected FLUSHDB to be an admin command, got %q", cmd.Category)
+	}
+	for _, cmd := range commandTable {
+		if _, ok := commandCategories[cmd.Name]; !ok {
+			t.Fatalf("expected %s to have a category listed", cmd.Name)
+		}
+		for _, sub := range cmd.Subcommands {
+			if _, ok := commandCategories[sub.Name]; !ok {
+				t.Fatalf("expected %s to have a category listed", sub.Name)
+			}
+		}
+	}
+
+	// Test 7: Subcommands acting on server-wide state are refused where
+	// admin commands are, while the rest of their container runs
+	for _, args := range [][]string{
+		{"SCRIPT", "FLUSH"}, {"SCRIPT", "KILL"}, {"FUNCTION", "FLUSH"}, {"FUNCTION", "DELETE", "lib"},
+		{"FUNCTION", "RESTORE", "payload"}, {"FUNCTION", "KILL"}, {"UNDELETE", "k"},
+	} {
+		if reply := client.execute(args); !strings.HasPrefix(reply, "-NOPERM listener 'public'") {
+			t.Fatalf("expected NOPERM for %v, got %q", args, reply)
+		}
+	}
+	if reply := client.execute([]string{"SCRIPT", "EXISTS", "0"}); reply != "*1\r\n:0\r\n" {
+		t.Fatalf("expected SCRIPT EXISTS to run, got %q", reply)
+	}
+}
+
+// dialListener starts lc and returns a RESP connection to it
+func dialListener(t *testing.T, lc ListenerConfig, dial func(addr net.Addr) (net.Conn, error)) (net.Conn, *bufio.Reader) {
+	closer, addr, err := startListener(lc)
+	if err != nil {
+		t.Fatalf("expected listener to start, got %v", err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	conn, err := dial(addr)
+	if err != nil {
+		t.Fatalf("expected to connect, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redisgo.sock")
+
+	// Test 1: A stale socket file does not prevent binding
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("expected to create a socket, got %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	// Test 2: Commands are served over the socket
+	conn, reader := dialListener(t, ListenerConfig{Name: "sock", Kind: listenerUnix, Address: path},
+		func(addr net.Addr) (net.Conn, error) { return net.Dial("unix", path) })
+	conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("expected PONG, got %q", line)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected a key, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected a certificate, got %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestTLSListener(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	certFile, keyFile := writeTestCertificate(t)
+	lc := ListenerConfig{Name: "secure", Kind: listenerTLS, Address: "127.0.0.1:0", Password: "pw", CertFile: certFile, KeyFile: keyFile}
+
+	// Test 1: The listener's password applies to TLS connections
+	conn, reader := dialListener(t, lc, func(addr net.Addr) (net.Conn, error) {
+		return tls.Dial("tcp", addr.String(), &tls.Config{InsecureSkipVerify: true})
+	})
+	conn.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "-NOAUTH") {
+		t.Fatalf("expected NOAUTH, got %q", line)
+	}
+	conn.Write([]byte("*2\r\n$4\r\nAUTH\r\n$2\r\npw\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+OK\r\n" {
+		t.Fatalf("expected OK, got %q", line)
+	}
+	if line, _ := reader.ReadString('\n'); line != "$-1\r\n" {
+		t.Fatalf("expected a null reply, got %q", line)
+	}
+}
+
+func TestHTTPListener(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	lc := ListenerConfig{Name: "admin", Kind: listenerHTTP, Password: "pw", Categories: []string{categoryAdmin}}
+	server := httptest.NewServer(adminHandler(lc.policy()))
+	defer server.Close()
+
+	post := func(body, token string) (int, string) {
+		req, _ := http.NewRequest("POST", server.URL+"/command", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected a response, got %v", err)
+		}
+		defer resp.Body.Close()
+		reply, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(reply)
+	}
+
+	// Test 1: The health check needs no authentication
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected healthz to answer 200, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	// Test 2: Commands need the bearer password
+	if status, _ := post(`["DBSIZE"]`, ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", status)
+	}
+	if status, _ := post(`["DBSIZE"]`, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", status)
+	}
+
+	// Test 3: Allowed commands reply in RESP, others are forbidden
+	if status, reply := post(`["INFO","server"]`, "pw"); status != http.StatusOK || !strings.HasPrefix(reply, "$") {
+		t.Fatalf("expected INFO to run, got %d %q", status, reply)
+	}
+	if status, _ := post(`["SET","k","v"]`, "pw"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for a data command, got %d", status)
+	}
+
+	// Test 4: Malformed bodies are rejected
+	if status, _ := post(`{"cmd":"PING"}`, "pw"); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-array body, got %d", status)
+	}
+	if status, _ := post(`[]`, "pw"); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an empty command, got %d", status)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// List encoding limits, following Redis's list-max-listpack-size
+const (
+	defaultListPackSize = -2      // Same default as Redis: listpacks of up to 8KB
+	listPackSafetyLimit = 8 << 10 // Byte limit that applies when the size is given as an entry count
+	listPackMinByteSize = 4 << 10 // Byte limit of size -1; each further step doubles it
+	listPackMaxSizeStep = -5      // Most negative size accepted (64KB)
+)
+
+// listPackByteLimit returns the byte limit a negative list-max-listpack-size stands for
+func listPackByteLimit(size int) int {
+	return listPackMinByteSize << (-size - 1)
+}
+
+// listpack is the compact representation of a small list: every element is
+// stored in one byte slice as
+//
+//	<length uvarint> <bytes> <backlen>
+//
+// where backlen is the size of the first two parts as a uvarint written
+// backwards, so the slice can be walked from either end. Lists are stored this
+// way while they fit the list-max-listpack-size limits and become a deque once
+// they outgrow them.
+type listpack struct {
+	buf  []byte
+	size int // Number of elements
+}
+
+// newListpack creates a listpack holding values in order
+func newListpack(values ...string) *listpack {
+	lp := &listpack{}
+	for _, v := range values {
+		lp.pushBack(v)
+	}
+	return lp
+}
+
+// uvarintLen returns the number of bytes of n encoded as a uvarint
+func uvarintLen(n int) int {
+	size := 1
+	for ; n >= 0x80; n >>= 7 {
+		size++
+	}
+	return size
+}
+
+// appendListpackEntry appends the encoding of v to dst
+func appendListpackEntry(dst []byte, v string) []byte {
+	start := len(dst)
+	dst = binary.AppendUvarint(dst, uint64(len(v)))
+	dst = append(dst, v...)
+
+	var backlen [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(backlen[:], uint64(len(dst)-start))
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, backlen[i])
+	}
+	return dst
+}
+
+// entryAt decodes the element starting at off and returns it with the
+// offset of the next one
+func (lp *listpack) entryAt(off int) (string, int) {
+	n, k := binary.Uvarint(lp.buf[off:])
+	start := off + k
+	end := start + int(n)
+	return string(lp.buf[start:end]), end + uvarintLen(end-off)
+}
+
+// next returns the offset of the element following the one at off
+func (lp *listpack) next(off int) int {
+	n, k := binary.Uvarint(lp.buf[off:])
+	size := k + int(n)
+	return off + size + uvarintLen(size)
+}
+
+// prev returns the offset of the element ending at end
+func (lp *listpack) prev(end int) int {
+	size, shift, i := 0, 0, end-1
+	for {
+		b := lp.buf[i]
+		size |= int(b&0x7f) << shift
+		i--
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return i + 1 - size
+}
+
+// offsetOf returns the offset of element i (0 <= i <= len), walking from
+// whichever end is closer
+func (lp *listpack) offsetOf(i int) int {
+	if i <= lp.size/2 {
+		off := 0
+		for ; i > 0; i-- {
+			off = lp.next(off)
+		}
+		return off
+	}
+	off := len(lp.buf)
+	for j := lp.size; j > i; j-- {
+		off = lp.prev(off)
+	}
+	return off
+}
+
+func (lp *listpack) len() int {
+	return lp.size
+}
+
+func (lp *listpack) pushFront(v string) {
+	lp.insert(0, v)
+}
+
+func (lp *listpack) pushBack(v string) {
+	lp.buf = appendListpackEntry(lp.buf, v)
+	lp.size++
+}
+
+// popFront removes and returns the first element; the listpack must not be empty
+func (lp *listpack) popFront() string {
+	v, next := lp.entryAt(0)
+	lp.buf = slices.Delete(lp.buf, 0, next)
+	lp.size--
+	return v
+}
+
+// popBack removes and returns the last element; the listpack must not be empty
+func (lp *listpack) popBack() string {
+	start := lp.prev(len(lp.buf))
+	v, _ := lp.entryAt(start)
+	lp.buf = lp.buf[:start]
+	lp.size--
+	return v
+}
+
+// at returns the element at index i, which must be in range
+func (lp *listpack) at(i int) string {
+	v, _ := lp.entryAt(lp.offsetOf(i))
+	return v
+}
+
+// set replaces the element at index i, which must be in range
+func (lp *listpack) set(i int, v string) {
+	off := lp.offsetOf(i)
+	lp.buf = slices.Replace(lp.buf, off, lp.next(off), appendListpackEntry(nil, v)...)
+}
+
+// insert places v at index i (0 <= i <= len)
+func (lp *listpack) insert(i int, v string) {
+	lp.buf = slices.Insert(lp.buf, lp.offsetOf(i), appendListpackEntry(nil, v)...)
+	lp.size++
+}
+
+// indexOf returns the index of the first element equal to v, or -1
+func (lp *listpack) indexOf(v string) int {
+	index := -1
+	lp.each(false, func(i int, element string) bool {
+		if element == v {
+			index = i
+			return false
+		}
+		return true
+	})
+	return index
+}
+
+// each calls fn with every element and its index, from the tail if reverse
+// is set, until fn returns false
+func (lp *listpack) each(reverse bool, fn func(i int, v string) bool) {
+	if reverse {
+		end := len(lp.buf)
+		for i := lp.size - 1; i >= 0; i-- {
+			end = lp.prev(end)
+			v, _ := lp.entryAt(end)
+			if !fn(i, v) {
+				return
+			}
+		}
+		return
+	}
+	off := 0
+	for i := 0; i < lp.size; i++ {
+		v, next := lp.entryAt(off)
+		if !fn(i, v) {
+			return
+		}
+		off = next
+	}
+}
+
+// retain keeps only the elements whose index satisfies keep, preserving order
+func (lp *listpack) retain(keep func(i int) bool) {
+	var buf []byte
+	kept, off := 0, 0
+	for i := 0; i < lp.size; i++ {
+		next := lp.next(off)
+		if keep(i) {
+			buf = append(buf, lp.buf[off:next]...)
+			kept++
+		}
+		off = next
+	}
+	lp.buf, lp.size = buf, kept
+}
+
+// slice returns a copy of the elements from start to stop inclusive,
+// which must be in range
+func (lp *listpack) slice(start, stop int) []string {
+	if start > stop {
+		return []string{}
+	}
+	result := make([]string, 0, stop-start+1)
+	off := lp.offsetOf(start)
+	for i := start; i <= stop; i++ {
+		var v string
+		v, off = lp.entryAt(off)
+		result = append(result, v)
+	}
+	return result
+}
+
+// values returns a copy of every element in order
+func (lp *listpack) values() []string {
+	return lp.slice(0, lp.size-1)
+}
+
+// clone returns an independent copy of the listpack
+func (lp *listpack) clone() *listpack {
+	return &listpack{buf: slices.Clone(lp.buf), size: lp.size}
+}
+
+// clear removes every element and drops the buffer
+func (lp *listpack) clear() {
+	lp.buf, lp.size = nil, 0
+}
+
+// SetListPackSize sets the store's list-max-listpack-size: a positive value
+// is the most elements a listpack may hold, a negative one (-1 to -5) its
+// byte limit of 4KB to 64KB
+func (s *Store) SetListPackSize(size int) {
+	s.mu.Lock()
+	s.listPackSize = size
+	s.mu.Unlock()
+}
+
+// listFits reports whether a list of count elements taking bytes bytes when
+// packed stays within the listpack limits
+func (s *Store) listFits(count, bytes int) bool {
+	if s.listPackSize > 0 {
+		return count <= s.listPackSize && bytes <= listPackSafetyLimit
+	}
+	return bytes <= listPackByteLimit(s.listPackSize)
+}
+
+// encodeList returns list in the encoding its size calls for: listpacks that
+// outgrow the limits become deques, and deques that shrink to half of them
+// are packed again, the margin keeping lists near a limit from flapping
+func (s *Store) encodeList(list listValue) listValue {
+	switch l := list.(type) {
+	case *listpack:
+		if !s.listFits(l.size, len(l.buf)) {
+			d := newDeque()
+			l.each(false, func(_ int, v string) bool {
+				d.pushBack(v)
+				return true
+			})
+			return d
+		}
+	case *deque:
+		// Each element costs at most two bytes of framing for typical sizes
+		if s.listFits(2*l.size, 2*(l.bytes+2*l.size)) {
+			return newListpack(l.values()...)
+		}
+	}
+	return list
+}
+
+// reencodeList applies encodeList to the list stored at key, replacing the
+// entry's value if the encoding changes. Callers must hold the write lock.
+func (s *Store) reencodeList(key string, list listValue) listValue {
+	encoded := s.encodeList(list)
+	if encoded != list {
+		s.data.lookup(key).Value = encoded
+	}
+	return encoded
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	lp, d := newListpack(), newDeque()
+
+	// Test 1: Random operations match the deque, including long elements
+	// whose lengths need multi-byte framing
+	for n := 0; n < 2000; n++ {
+		v := strconv.Itoa(n)
+		if n%50 == 0 {
+			v = strings.Repeat("x", 200+n)
+		}
+		switch op := rand.IntN(6); {
+		case op == 0:
+			lp.pushFront(v)
+			d.pushFront(v)
+		case op == 1:
+			lp.pushBack(v)
+			d.pushBack(v)
+		case op == 2 && d.len() > 0:
+			if a, b := lp.popFront(), d.popFront(); a != b {
+				t.Fatalf("popFront: listpack %q, deque %q", a, b)
+			}
+		case op == 3 && d.len() > 0:
+			if a, b := lp.popBack(), d.popBack(); a != b {
+				t.Fatalf("popBack: listpack %q, deque %q", a, b)
+			}
+		case op == 4:
+			i := rand.IntN(d.len() + 1)
+			lp.insert(i, v)
+			d.insert(i, v)
+		case op == 5 && d.len() > 0:
+			i := rand.IntN(d.len())
+			lp.set(i, v)
+			d.set(i, v)
+		}
+		if lp.len() != d.len() {
+			t.Fatalf("length mismatch: %d vs %d", lp.len(), d.len())
+		}
+	}
+	if !reflect.DeepEqual(lp.values(), d.values()) {
+		t.Fatalf("contents differ after random operations")
+	}
+	for i := 0; i < d.len(); i++ {
+		if lp.at(i) != d.at(i) {
+			t.Fatalf("at(%d): listpack %q, deque %q", i, lp.at(i), d.at(i))
+		}
+	}
+
+	// Test 2: Reverse iteration and retain agree with the deque
+	var forward, backward []string
+	lp.each(false, func(_ int, v string) bool { forward = append(forward, v); return true })
+	lp.each(true, func(_ int, v string) bool { backward = append([]string{v}, backward...); return true })
+	if !reflect.DeepEqual(forward, backward) {
+		t.Fatalf("reverse iteration does not mirror forward iteration")
+	}
+	lp.retain(func(i int) bool { return i%3 == 0 })
+	d.retain(func(i int) bool { return i%3 == 0 })
+	if !reflect.DeepEqual(lp.values(), d.values()) {
+		t.Fatalf("contents differ after retain")
+	}
+}
+
+func TestListEncoding(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	encoding := func(key string) string {
+		return run("OBJECT", "ENCODING", key)
+	}
+
+	// Test 1: Small lists are listpacks, large ones quicklists
+	run("RPUSH", "small", "a", "b", "c")
+	if reply := encoding("small"); reply != "$8\r\nlistpack\r\n" {
+		t.Fatalf("expected listpack, got %q", reply)
+	}
+	run("RPUSH", "big", strings.Repeat("x", 9000))
+	if reply := encoding("big"); reply != "$9\r\nquicklist\r\n" {
+		t.Fatalf("expected quicklist for an element over 8KB, got %q", reply)
+	}
+
+	// Test 2: An element count limit converts past the limit, and back
+	// once the list is at half of it
+	databases.Get(0).SetListPackSize(4)
+	run("RPUSH", "n", "1", "2", "3", "4")
+	if reply := encoding("n"); reply != "$8\r\nlistpack\r\n" {
+		t.Fatalf("expected listpack at the limit, got %q", reply)
+	}
+	run("LPUSHX", "n", "0")
+	if reply := encoding("n"); reply != "$9\r\nquicklist\r\n" {
+		t.Fatalf("expected quicklist past the limit, got %q", reply)
+	}
+	run("RPOP", "n")
+	if reply := encoding("n"); reply != "$9\r\nquicklist\r\n" {
+		t.Fatalf("expected quicklist until half the limit, got %q", reply)
+	}
+	run("RPOP", "n", "2")
+	if reply := encoding("n"); reply != "$8\r\nlistpack\r\n" {
+		t.Fatalf("expected listpack at half the limit, got %q", reply)
+	}
+	if reply := run("LRANGE", "n", "0", "-1"); reply != formatArray([]string{"0", "1"}) {
+		t.Fatalf("unexpected contents after conversions %q", reply)
+	}
+
+	// Test 3: LINSERT and LSET convert too, and commands work on both encodings
+	run("LINSERT", "n", "AFTER", "1", "x")
+	run("LINSERT", "n", "AFTER", "x", "y")
+	run("LINSERT", "n", "AFTER", "y", "z")
+	if reply := encoding("n"); reply != "$9\r\nquicklist\r\n" {
+		t.Fatalf("expected quicklist after inserts, got %q", reply)
+	}
+	if reply := run("LPOS", "n", "z"); reply != ":4\r\n" {
+		t.Fatalf("expected z at 4, got %q", reply)
+	}
+	databases.Get(0).SetListPackSize(-1)
+	run("LSET", "small", "0", strings.Repeat("y", 5000))
+	if reply := encoding("small"); reply != "$9\r\nquicklist\r\n" {
+		t.Fatalf("expected LSET of a large element to convert, got %q", reply)
+	}
+	if reply := run("LINDEX", "small", "-1"); reply != "$1\r\nc\r\n" {
+		t.Fatalf("expected c, got %q", reply)
+	}
+}
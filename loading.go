@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadState tracks the dataset being loaded at startup. The listeners are
+// already open, so health checks see the server up, and commands that need
+// the data are refused with -LOADING until it is complete.
+type loadState struct {
+	active atomic.Bool
+	loaded atomic.Int64 // Bytes of the file read so far
+
+	mu      sync.Mutex
+	started time.Time
+	total   int64 // Size of the file being loaded, 0 until it is opened
+}
+
+var loading loadState
+
+// Commands answered while loading besides those of the connection category
+var loadingAllowedCommands = map[string]bool{"info": true}
+
+// begin marks the dataset as loading
+func (l *loadState) begin() {
+	l.mu.Lock()
+	l.started, l.total = time.Now(), 0
+	l.mu.Unlock()
+	l.loaded.Store(0)
+	l.active.Store(true)
+}
+
+// end marks the dataset as loaded
+func (l *loadState) end() {
+	l.active.Store(false)
+}
+
+// track returns a reader of f that counts its progress, if the dataset is
+// loading; the loaders read their file through it
+func (l *loadState) track(f *os.File) io.Reader {
+	if !l.active.Load() {
+		return f
+	}
+	if info, err := f.Stat(); err == nil {
+		l.mu.Lock()
+		l.total = info.Size()
+		l.mu.Unlock()
+	}
+	return &loadingReader{r: f, l: l}
+}
+
+// loadingReader adds the bytes read through it to the progress of the load
+type loadingReader struct {
+	r io.Reader
+	l *loadState
+}
+
+func (lr *loadingReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.l.loaded.Add(int64(n))
+	return n, err
+}
+
+// progress returns the bytes loaded, the total and the fraction done (0 to 1)
+func (l *loadState) progress() (loaded, total int64, done float64) {
+	l.mu.Lock()
+	total = l.total
+	l.mu.Unlock()
+	loaded = l.loaded.Load()
+	if total > 0 {
+		done = min(float64(loaded)/float64(total), 1)
+	}
+	return loaded, total, done
+}
+
+// refuses reports whether cmd must wait for the dataset to load
+func (l *loadState) refuses(c *Client, cmd *Command) bool {
+	return l.active.Load() && !c.replaying && cmd.Category != categoryConnection && !loadingAllowedCommands[cmd.topLevel().Canonical]
+}
+
+// errorReply returns the -LOADING error, with how far the load has got
+func (l *loadState) errorReply() string {
+	_, _, done := l.progress()
+	return formatError(fmt.Sprintf("LOADING Redis is loading the dataset in memory (%.2f%% loaded)", done*100))
+}
+
+// infoLoading returns the loading fields of INFO persistence
+func infoLoading() []string {
+	if !loading.active.Load() {
+		return []string{"loading:0", "async_loading:0"}
+	}
+	loading.mu.Lock()
+	started := loading.started
+	loading.mu.Unlock()
+	loaded, total, done := loading.progress()
+	eta := int64(1)
+	if elapsed := time.Since(started).Seconds(); done > 0 {
+		eta = int64(elapsed / done * (1 - done))
+	}
+	return []string{
+		"loading:1",
+		"async_loading:0",
+		fmt.Sprintf("loading_start_time:%d", started.Unix()),
+		fmt.Sprintf("loading_total_bytes:%d", total),
+		fmt.Sprintf("loading_loaded_bytes:%d", loaded),
+		fmt.Sprintf("loading_loaded_perc:%.2f", done*100),
+		fmt.Sprintf("loading_eta_seconds:%d", eta),
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoading(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	defer loading.end()
+
+	client := &Client{}
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	os.WriteFile(path, make([]byte, 400), 0o644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected the file to open: %v", err)
+	}
+	defer f.Close()
+
+	// Test 1: While loading, commands needing the data are refused with the
+	// progress of the load; connection commands and INFO are answered
+	loading.begin()
+	io.ReadFull(loading.track(f), make([]byte, 100))
+	if reply := client.execute([]string{"GET", "k"}); reply != "-LOADING Redis is loading the dataset in memory (25.00% loaded)\r\n" {
+		t.Fatalf("expected -LOADING, got %q", reply)
+	}
+	if reply := client.execute([]string{"PING"}); reply != "+PONG\r\n" {
+		t.Fatalf("expected PING to be answered, got %q", reply)
+	}
+	info := client.execute([]string{"INFO", "persistence"})
+	for _, field := range []string{"loading:1\r\n", "loading_total_bytes:400\r\n", "loading_loaded_bytes:100\r\n", "loading_loaded_perc:25.00\r\n"} {
+		if !strings.Contains(info, field) {
+			t.Fatalf("expected INFO persistence to report %q, got %q", field, info)
+		}
+	}
+
+	// Test 2: A transaction queuing a refused command is aborted
+	client.execute([]string{"MULTI"})
+	client.execute([]string{"SET", "k", "v"})
+	if reply := client.execute([]string{"EXEC"}); !strings.HasPrefix(reply, "-EXECABORT") {
+		t.Fatalf("expected EXEC to abort, got %q", reply)
+	}
+
+	// Test 3: The file being replayed runs its commands
+	replayer := &Client{replaying: true}
+	if reply := replayer.execute([]string{"SET", "k", "v"}); reply != "+OK\r\n" {
+		t.Fatalf("expected the replay to run, got %q", reply)
+	}
+
+	// Test 4: Once loaded, everything is answered
+	loading.end()
+	if reply := client.execute([]string{"GET", "k"}); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected GET to be answered, got %q", reply)
+	}
+	if info := client.execute([]string{"INFO", "persistence"}); !strings.Contains(info, "loading:0\r\n") {
+		t.Fatalf("expected loading:0, got %q", info)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import "errors"
+
+// LZF limits, as in the liblzf Redis embeds
+const (
+	lzfMaxLiteral = 1 << 5      // Longest literal run
+	lzfMaxOffset  = 1 << 13     // Farthest back reference
+	lzfMaxRef     = 1<<8 + 1<<3 // Longest back reference
+	lzfHashLog    = 14          // Bits of the match finder's hash table
+	lzfHashSize   = 1 << lzfHashLog
+)
+
+var errLZFFormat = errors.New("invalid LZF data")
+
+// lzfCompress compresses data in the LZF format Redis uses for RDB strings
+// Returns nil unless the result fits in maxLen bytes.
+func lzfCompress(data []byte, maxLen int) []byte {
+	var table [lzfHashSize]int32 // Position+1 of the last sequence with each hash
+	out := make([]byte, 1, maxLen+1)
+	lit := 0 // Length of the literal run being written, whose control byte is at len(out)-lit-1
+	end := len(data)
+	ip := 0
+	for ip < end-2 {
+		h := (uint32(data[ip])<<16 | uint32(data[ip+1])<<8 | uint32(data[ip+2])) * 2654435761 >> (32 - lzfHashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(ip + 1)
+		off := ip - ref - 1
+		if ref < 0 || off >= lzfMaxOffset || data[ref] != data[ip] || data[ref+1] != data[ip+1] || data[ref+2] != data[ip+2] {
+			out = append(out, data[ip])
+			ip++
+			if lit++; lit == lzfMaxLiteral {
+				out[len(out)-lit-1] = byte(lit - 1)
+				out = append(out, 0)
+				lit = 0
+			}
+			if len(out) > maxLen {
+				return nil
+			}
+			continue
+		}
+
+		// End the literal run, dropping its control byte if it is empty
+		if lit == 0 {
+			out = out[:len(out)-1]
+		} else {
+			out[len(out)-lit-1] = byte(lit - 1)
+		}
+		n := 3
+		for maxN := min(end-ip-2, lzfMaxRef); n < maxN && data[ref+n] == data[ip+n]; n++ {
+		}
+		if n-2 < 7 {
+			out = append(out, byte(off>>8)|byte(n-2)<<5)
+		} else {
+			out = append(out, byte(off>>8)|7<<5, byte(n-2-7))
+		}
+		out = append(out, byte(off), 0)
+		lit = 0
+		ip += n
+		if len(out) > maxLen+1 {
+			return nil
+		}
+	}
+	for ; ip < end; ip++ {
+		out = append(out, data[ip])
+		if lit++; lit == lzfMaxLiteral {
+			out[len(out)-lit-1] = byte(lit - 1)
+			out = append(out, 0)
+			lit = 0
+		}
+	}
+	if lit == 0 {
+		out = out[:len(out)-1]
+	} else {
+		out[len(out)-lit-1] = byte(lit - 1)
+	}
+	if len(out) > maxLen {
+		return nil
+	}
+	return out
+}
+
+// lzfDecompress decompresses LZF data into exactly size bytes
+func lzfDecompress(data []byte, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, errLZFFormat
+	}
+	// size comes from the data, which may be corrupt
+	out := make([]byte, 0, min(size, len(data)*lzfMaxRef))
+	for ip := 0; ip < len(data); {
+		ctrl := int(data[ip])
+		ip++
+		if ctrl < lzfMaxLiteral {
+			n := ctrl + 1
+			if ip+n > len(data) || len(out)+n > size {
+				return nil, errLZFFormat
+			}
+			out = append(out, data[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(data) {
+				return nil, errLZFFormat
+			}
+			n += int(data[ip])
+			ip++
+		}
+		if ip >= len(data) {
+			return nil, errLZFFormat
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(data[ip]) - 1
+		ip++
+		n += 2
+		if ref < 0 || len(out)+n > size {
+			return nil, errLZFFormat
+		}
+		// Byte by byte, as a reference may overlap what it produces
+		for i := range n {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != size {
+		return nil, errLZFFormat
+	}
+	return out, nil
+}
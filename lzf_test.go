@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLZF(t *testing.T) {
+	// Test 1: Literal runs and back references, including one overlapping
+	// the bytes it produces, decompress as liblzf writes them
+	data := []byte{0x00, 'a', 0xE0, 0x12, 0x00, 0x01, 'a', 'a'}
+	out, err := lzfDecompress(data, 30)
+	if err != nil || string(out) != strings.Repeat("a", 30) {
+		t.Fatalf("expected 30 a's, got %q %v", out, err)
+	}
+
+	// Test 2: Compressed data decompresses back, for repetitive, random
+	// and mixed inputs
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rng.Read(random)
+	inputs := [][]byte{
+		[]byte(strings.Repeat("abcdefgh", 200)),
+		[]byte(strings.Repeat("x", 1000)),
+		append([]byte(strings.Repeat("hello world ", 50)), random[:100]...),
+		random,
+	}
+	for i, in := range inputs {
+		compressed := lzfCompress(in, len(in)*2)
+		if compressed == nil {
+			t.Fatalf("expected input %d to compress", i)
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Fatalf("expected input %d to round-trip, got %v", i, err)
+		}
+	}
+	if compressed := lzfCompress(inputs[0], len(inputs[0])); len(compressed) >= len(inputs[0])/10 {
+		t.Fatalf("expected repetitive data to compress well, got %d bytes", len(compressed))
+	}
+
+	// Test 3: Nothing is returned when the result would not fit
+	if compressed := lzfCompress(random, len(random)-4); compressed != nil {
+		t.Fatalf("expected random data not to compress, got %d bytes", len(compressed))
+	}
+
+	// Test 4: Corrupt data is refused
+	for _, bad := range [][]byte{
+		{0x05, 'a'},             // Literal past the end
+		{0x00, 'a', 0x20, 0x05}, // Reference before the start
+		{0x00, 'a', 0xE0},       // Truncated reference
+	} {
+		if _, err := lzfDecompress(bad, 30); err == nil {
+			t.Fatalf("expected %v to be refused", bad)
+		}
+	}
+	if _, err := lzfDecompress(data, 29); err == nil {
+		t.Fatalf("expected a wrong size to be refused")
+	}
+}
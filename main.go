@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +22,7 @@ const (
 	TypeSet       = "set"
 	TypeHash      = "hash"
 	TypeSortedSet = "sortedset"
+	TypeStream    = "stream"
 )
 
 // Data type constants (numeric identifiers for future optimization)
@@ -28,36 +32,109 @@ const (
 	TypeSetID       = 3
 	TypeHashID      = 4
 	TypeSortedSetID = 5
+	TypeStreamID    = 6
 )
 
 // Entry represents a single key-value entry in the store
 type Entry struct {
-	Type      string      // Data type (string, list, set, hash, sortedset)
+	Type      string      // Data type (string, list, set, hash, sortedset, stream)
 	Value     interface{} // Actual data (cast based on Type)
 	ExpiresAt time.Time   // TTL expiration time (zero value means no expiration)
+
+	lastAccess atomic.Int64  // Unix nanoseconds of the last access (LRU metadata)
+	frequency  atomic.Uint32 // Logarithmic access counter (LFU metadata)
+
+	share atomic.Pointer[valueShare] // Readers of Value outside the store lock, see borrow
 }
 
 // Store represents the in-memory database
 type Store struct {
-	data map[string]*Entry // Key-value storage
-	mu   sync.RWMutex      // Read-write mutex for synchronization
+	data *keyspace    // Key-value storage
+	mu   sync.RWMutex // Read-write mutex for synchronization
+	hot  *hotKeys     // Read cache for keys under extreme read load
+
+	tombstones      map[string]*tombstone // Recently deleted entries, when soft deletion is enabled
+	tombstoneOrder  []*tombstone          // Tombstones in deletion order, for purging
+	tombstoneWindow time.Duration         // How long deleted entries are retained (0 disables)
+
+	ttlPolicy *ttlPolicy // Default TTLs for keys stored without one
+	typeGuard bool       // Refuse writes that would silently replace a key of another type
+
+	listPackSize    int // list-max-listpack-size: entry count if positive, byte limit step if negative
+	intsetEntries   int // set-max-intset-entries: largest set of integers stored as an intset
+	hashPackEntries int // hash-max-listpack-entries: most fields of a hash stored as a listpack
+	hashPackValue   int // hash-max-listpack-value: longest field or value of a hash stored as a listpack
+	zsetPackEntries int // zset-max-listpack-entries: most members of a sorted set stored packed
+	zsetPackValue   int // zset-max-listpack-value: longest member of a sorted set stored packed
+
+	timers       map[string]*expireTimer // Precise expiration timers by key
+	preciseLimit int                     // Maximum number of timers (0 disables)
+
+	blocked   map[string][]*blockedClient // Clients blocked on each key, in arrival order
+	readyKeys map[string]struct{}         // Keys with blocked clients written since last served
+	hasReady  atomic.Bool                 // Whether readyKeys is non-empty, readable without the lock
+
+	watchers map[string]map[*Client]bool // Clients watching each key, and whether it had expired when they did
+
+	scanMu      sync.Mutex                  // Guards scanIndexes, which scans fill under the read lock
+	scanIndexes map[string]*collectionIndex // Collections being scanned, sorted by element hash, by key
+
+	usage prefixTally // Background per-prefix key count and memory breakdown
 }
 
 // NewStore creates and initializes a new Store instance
 func NewStore() *Store {
 	return &Store{
-		data: make(map[string]*Entry),
+		data:       newKeyspace(),
+		hot:        newHotKeys(),
+		tombstones: make(map[string]*tombstone),
+
+		listPackSize:    defaultListPackSize,
+		intsetEntries:   defaultIntsetEntries,
+		hashPackEntries: defaultHashListpackEntries,
+		hashPackValue:   defaultHashListpackValue,
+		zsetPackEntries: defaultZsetListpackEntries,
+		zsetPackValue:   defaultZsetListpackValue,
 	}
 }
 
+// Keys written or removed since startup, as counted by keyModified
+var keyChanges atomic.Int64
+
+// keyModified must be called, with the write lock held, for every key a
+// write path changes or removes
+func (s *Store) keyModified(key string) {
+	keyChanges.Add(1)
+	s.hot.invalidate(key)
+	s.disarmStaleTimer(key)
+	s.signalReady(key)
+	s.touchWatched(key)
+	s.dropScanIndex(key)
+}
+
 // Get retrieves a string value for the given key
 // Returns (value, exists, isCorrectType)
 func (s *Store) Get(key string) (string, bool, bool) {
+	value, exists, isCorrectType, _ := s.lookup(key)
+	return value, exists, isCorrectType
+}
+
+// lookup is Get that also reports whether the hot-key cache served the value
+func (s *Store) lookup(key string) (value string, exists, isCorrectType, cacheHit bool) {
+	if s.hot.enabled.Load() {
+		return s.getHot(key)
+	}
+	value, exists, isCorrectType = s.getUncached(key)
+	return value, exists, isCorrectType, false
+}
+
+// getUncached looks the key up in the keyspace, bypassing the hot-key cache
+func (s *Store) getUncached(key string) (string, bool, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
-	entry, exists := s.data[key]
-	if !exists {
+	entry := s.data.get(key)
+	if entry == nil {
 		return "", false, true // Key doesn't exist, but type would be correct
 	}
 	
@@ -76,10 +153,15 @@ func (s *Store) Get(key string) (string, bool, bool) {
 }
 
 // Set stores a string value for the given key
+// Returns "OK", or an error message if type overwrites are forbidden and the key holds another type
 func (s *Store) Set(key string, val string) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
+	if msg := s.checkOverwrite(key, TypeString); msg != "" {
+		return msg
+	}
+	
 	// Create a new entry of string type
 	entry := &Entry{
 		Type:      TypeString,
@@ -88,7 +170,7 @@ func (s *Store) Set(key string, val string) string {
 	}
 	
 	// Insert or replace the entry
-	s.data[key] = entry
+	s.put(key, entry)
 	
 	return "OK"
 }
@@ -100,22 +182,67 @@ func (s *Store) Del(keys ...string) int {
 	
 	count := 0
 	for _, key := range keys {
-		if _, exists := s.data[key]; exists {
-			delete(s.data, key)
-			count++
+		entry := s.data.get(key)
+		if entry == nil {
+			continue
 		}
+		s.data.delete(key)
+		s.keyModified(key)
+		s.bury(key, entry)
+		count++
 	}
 	
 	return count
 }
 
+// MGet retrieves the string values for several keys at once
+// Missing keys and keys holding another type yield nil
+func (s *Store) MGet(keys ...string) []*string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make([]*string, len(keys))
+	for i, key := range keys {
+		entry := s.data.get(key)
+		if entry == nil || entry.Type != TypeString {
+			continue
+		}
+		if value, ok := entry.Value.(string); ok {
+			values[i] = &value
+		}
+	}
+	return values
+}
+
+// MSet stores several key/value pairs atomically
+// pairs alternates keys and values: key1, value1, key2, value2, ...
+// Returns "OK", or an error message as for Set
+func (s *Store) MSet(pairs ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Either every key is written or none is
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if msg := s.checkOverwrite(pairs[i], TypeString); msg != "" {
+			return msg
+		}
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s.put(pairs[i], &Entry{
+			Type:  TypeString,
+			Value: pairs[i+1],
+		})
+	}
+	return "OK"
+}
+
 // Helper method to check if a key exists and get its type
 func (s *Store) KeyType(key string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
-	entry, exists := s.data[key]
-	if !exists {
+	entry := s.data.get(key)
+	if entry == nil {
 		return "", false
 	}
 	return entry.Type, true
@@ -131,31 +258,111 @@ func (s *Store) SetForTesting(key string, entryType string, value interface{}) {
 		Value:     value,
 		ExpiresAt: time.Time{},
 	}
-	s.data[key] = entry
+	s.put(key, entry)
 }
 
-// Global store instance
-var store = NewStore()
-
 func main() {
-	listener, err := net.Listen("tcp", ":6379")
-	if err != nil {
-		fmt.Printf("Error starting TCP server: %v\n", err)
+	// --test-config <file> validates a configuration file and exits
+	if len(os.Args) > 1 && os.Args[1] == "--test-config" {
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: redisgo --test-config <config-file>")
+			os.Exit(2)
+		}
+		os.Exit(runConfigTest(os.Args[2]))
+	}
+
+	// An optional configuration file may be given as the first argument
+	if len(os.Args) > 1 {
+		cfg, err := LoadConfigFile(os.Args[1])
+		if err != nil {
+			fmt.Printf("Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+		configFile, _ = filepath.Abs(os.Args[1])
+		databases = NewDatabases(cfg.Databases)
+		if err := cfg.apply(); err != nil {
+			fmt.Printf("Error applying configuration: %v\n", err)
+			os.Exit(1)
+		}
+		config = cfg
+	}
+	config.applyRuntimeTuning()
+
+	go activeExpireLoop()
+	go archiveLoop()
+	go activeRehashLoop()
+	go prefixUsageLoop()
+	go savePointLoop()
+
+	listeners := config.listeners()
+	if len(listeners) == 0 {
+		fmt.Println("Error starting server: port is 0 and no listener is configured")
 		return
 	}
-	defer listener.Close()
-	fmt.Println("Server is listening on port 6379")
 
-	for {
-		conn, err := listener.Accept()
+	// A server already running with the same handoff socket hands over its
+	// listening sockets and data before anything is bound
+	var inherited map[string]net.Listener
+	if config.HandoffSocket != "" {
+		var err error
+		inherited, err = takeOver(config.HandoffSocket, listeners, databases)
 		if err != nil {
-			fmt.Printf("Error accepting connection: %v\n", err)
-			continue
+			fmt.Printf("Error taking over from the running server: %v\n", err)
+			os.Exit(1)
+		}
+		if inherited != nil {
+			fmt.Println("Took over listeners and data from the running server")
 		}
+	}
 
-		fmt.Println("New client connected")
-		go handleConnection(conn)
+	// Without a running server to take the data over from, the data is
+	// loaded once the listeners are open, answering -LOADING meanwhile
+	if inherited == nil {
+		loading.begin()
 	}
+	var served []*servedListener
+	for _, lc := range listeners {
+		sl, err := openListener(lc, inherited[lc.Name])
+		if err != nil {
+			fmt.Printf("Error starting listener '%s': %v\n", lc.Name, err)
+			return
+		}
+		defer sl.closer.Close()
+		served = append(served, sl)
+		if lc.Name == defaultListenerName {
+			fmt.Printf("Server is listening on port %d\n", config.Port)
+		} else {
+			fmt.Printf("Listener '%s' (%s) is listening on %s\n", lc.Name, lc.Kind, sl.socket.Addr())
+		}
+	}
+
+	// The append-only file is replayed if appendonly is on and there is one,
+	// and the dump file written by the last SAVE or BGSAVE is loaded otherwise
+	if inherited == nil {
+		loaded, err := loadAOFOnStartup()
+		if err == nil && !loaded {
+			err = loadDumpOnStartup()
+		}
+		if err != nil {
+			fmt.Printf("Fatal error loading the DB: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if appendOnly() {
+		if err := startAOF(); err != nil {
+			fmt.Printf("Can't open the append-only file %s: %v\n", aofPath(), err)
+			os.Exit(1)
+		}
+	}
+	loading.end()
+	if config.HandoffSocket != "" {
+		if _, err := startHandoff(config.HandoffSocket, served); err != nil {
+			fmt.Printf("Error listening on handoff socket: %v\n", err)
+			return
+		}
+	}
+
+	select {}
 }
 
 // ReadRESP reads the next RESP message from the reader
@@ -322,6 +529,27 @@ func formatBulkString(msg string) string {
 	return "$" + strconv.Itoa(len(msg)) + "\r\n" + msg + "\r\n"
 }
 
+func formatNullBulkString() string {
+	return "$-1\r\n"
+}
+
+func formatNullArray() string {
+	return "*-1\r\n"
+}
+
+// formatNullableArray formats an array of bulk strings where nil elements are null bulk strings
+func formatNullableArray(elems []*string) string {
+	result := "*" + strconv.Itoa(len(elems)) + "\r\n"
+	for _, elem := range elems {
+		if elem == nil {
+			result += formatNullBulkString()
+		} else {
+			result += formatBulkString(*elem)
+		}
+	}
+	return result
+}
+
 func formatArray(elems []string) string {
 	result := "*" + strconv.Itoa(len(elems)) + "\r\n"
 	for _, elem := range elems {
@@ -330,17 +558,42 @@ func formatArray(elems []string) string {
 	return result
 }
 
-func handleConnection(conn net.Conn) {
+// Number of open client connections
+var connectedClients atomic.Int64
+
+func handleConnection(conn net.Conn, policy *listenerPolicy) {
+	connectedClients.Add(1)
 	defer func() {
+		connectedClients.Add(-1)
 		conn.Close()
 		fmt.Println("Client disconnected")
 	}()
 
 	reader := bufio.NewReader(conn)
+	client := &Client{conn: conn, reader: reader, policy: policy}
+	registerClient(client)
+	defer unregisterClient(client)
+	client.beginHandshake()
+	defer client.completeHandshake()
+	defer client.releaseReplies()
+	defer client.stopOutbox()
+	defer broker.unsubscribeAll(client)
+	defer client.unwatchAll()
+	done := make(chan struct{})
+	defer close(done)
+	queue := client.startPipeline(config.PipelineMaxPending, done)
 	for {
-		cmdParts, err := ReadRESP(reader)
+		next := <-queue
+		cmdParts, err := next.args, next.err
 		if err != nil {
-			if err == io.EOF {
+			// Answer the commands read before it first
+			client.flushReplies()
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				// Closed by the peer, or dropped by the server
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				fmt.Println("Closing connection that did not complete its handshake within handshake-timeout")
 				return
 			}
 			// Protocol error
@@ -354,95 +607,25 @@ func handleConnection(conn net.Conn) {
 		if len(cmdParts) == 0 {
 			continue
 		}
+		client.advanceHandshake()
 
-		// Normalize command name to uppercase for case-insensitivity
-		command := strings.ToUpper(cmdParts[0])
-		
-		switch command {
-		case "PING":
-			// If an argument is provided, use it as message, else default "PONG"
-			message := "PONG"
-			if len(cmdParts) > 1 {
-				message = cmdParts[1]
-			}
-			_, err = conn.Write([]byte(formatSimpleString(message)))
-			if err != nil {
-				fmt.Printf("Error writing PING response: %v\n", err)
-				return
-			}
-			
-		case "ECHO":
-			// Respond with the argument as a bulk string
-			if len(cmdParts) < 2 {
-				_, err = conn.Write([]byte(formatError("ERR wrong number of arguments for 'echo' command")))
-			} else {
-				_, err = conn.Write([]byte(formatBulkString(cmdParts[1])))
-			}
+		reply := client.execute(cmdParts)
+		client.advanceHandshake()
+		if reply != "" {
+			err = client.send(reply)
 			if err != nil {
-				fmt.Printf("Error writing ECHO response: %v\n", err)
+				fmt.Printf("Error writing %s response: %v\n", strings.ToUpper(cmdParts[0]), err)
 				return
 			}
-			
-		case "GET":
-			// GET key
-			if len(cmdParts) != 2 {
-				_, err = conn.Write([]byte(formatError("ERR wrong number of arguments for 'get' command")))
-			} else {
-				key := cmdParts[1]
-				value, exists, isCorrectType := store.Get(key)
-				
-				if exists && !isCorrectType {
-					// Key exists but wrong type
-					_, err = conn.Write([]byte(formatError("WRONGTYPE Operation against a key holding the wrong kind of value")))
-				} else if !exists {
-					// Key not found - return nil bulk string
-					_, err = conn.Write([]byte("$-1\r\n"))
-				} else {
-					// Key found and correct type
-					_, err = conn.Write([]byte(formatBulkString(value)))
-				}
-			}
-			if err != nil {
-				fmt.Printf("Error writing GET response: %v\n", err)
-				return
-			}
-			
-		case "SET":
-			// SET key value
-			if len(cmdParts) != 3 {
-				_, err = conn.Write([]byte(formatError("ERR wrong number of arguments for 'set' command")))
-			} else {
-				key := cmdParts[1]
-				value := cmdParts[2]
-				result := store.Set(key, value)
-				_, err = conn.Write([]byte(formatSimpleString(result)))
-			}
-			if err != nil {
-				fmt.Printf("Error writing SET response: %v\n", err)
-				return
-			}
-			
-		case "DEL":
-			// DEL key [key ...]
-			if len(cmdParts) < 2 {
-				_, err = conn.Write([]byte(formatError("ERR wrong number of arguments for 'del' command")))
-			} else {
-				keys := cmdParts[1:] // All arguments after command name
-				count := store.Del(keys...)
-				_, err = conn.Write([]byte(formatInteger(count)))
-			}
-			if err != nil {
-				fmt.Printf("Error writing DEL response: %v\n", err)
-				return
-			}
-			
-		default:
-			// Unknown command
-			_, err = conn.Write([]byte(formatError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(command)))))
-			if err != nil {
-				fmt.Printf("Error writing unknown command response: %v\n", err)
+		}
+		if len(queue) == 0 || client.closing {
+			if err = client.idleReplies(); err != nil {
+				fmt.Printf("Error writing responses: %v\n", err)
 				return
 			}
 		}
+		if client.closing {
+			return
+		}
 	}
 }
@@ -242,3 +242,32 @@ func TestStoreBasicOperations(t *testing.T) {
 	}
 }
 
+
+func TestStoreMultiKeyOperations(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: MSET stores every pair
+	result := store.MSet("k1", "v1", "k2", "v2")
+	if result != "OK" {
+		t.Fatalf("expected OK, got %s", result)
+	}
+
+	// Test 2: MGET preserves order and reports missing/wrong-type keys as nil
+	store.SetForTesting("listkey", TypeList, []string{"item"})
+	values := store.MGet("k2", "missing", "k1", "listkey")
+	if len(values) != 4 {
+		t.Fatalf("expected 4 values, got %d", len(values))
+	}
+	if values[0] == nil || *values[0] != "v2" {
+		t.Fatalf("expected v2 at position 0, got %v", values[0])
+	}
+	if values[1] != nil {
+		t.Fatalf("expected nil for missing key, got %v", *values[1])
+	}
+	if values[2] == nil || *values[2] != "v1" {
+		t.Fatalf("expected v1 at position 2, got %v", values[2])
+	}
+	if values[3] != nil {
+		t.Fatalf("expected nil for wrong-type key, got %v", *values[3])
+	}
+}
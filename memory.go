@@ -0,0 +1,308 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory estimation parameters
+const (
+	entryOverhead        = 96                     // Entry struct, its bucket slot and the key's string header
+	elementOverhead      = 24                     // String header and bookkeeping per collection element
+	skiplistNodeOverhead = 80                     // Skiplist node of a sorted set member, with its 4/3 levels on average
+	memorySamples        = 5                      // Elements sampled per collection, as MEMORY USAGE does by default
+	prefixUsageInterval  = 100 * time.Millisecond // How often each database's prefix tally advances
+	prefixUsageBuckets   = 256                    // Keyspace buckets tallied per database per cycle
+	prefixUsageRemainder = ""                     // Prefix reported for keys matching no configured prefix
+)
+
+// entryMemory estimates the bytes used by key and its entry. Collections
+// are sized from up to samples of their elements (0 means all of them);
+// lists know their exact size.
+func entryMemory(key string, entry *Entry, samples int) int {
+	size := entryOverhead + len(key)
+	switch v := entry.Value.(type) {
+	case string:
+		size += len(v)
+	case *listpack:
+		size += cap(v.buf)
+	case *deque:
+		size += len(v.buf)*elementOverhead + v.bytes
+	case *intset:
+		size += 8 * cap(v.values)
+	case *hashPack:
+		size += cap(v.buf)
+	case []string:
+		size += sampledSize(len(v), samples, func(yield func(int) bool) {
+			for _, e := range v {
+				if !yield(len(e) + elementOverhead) {
+					return
+				}
+			}
+		})
+	case map[string]struct{}:
+		size += sampledSize(len(v), samples, func(yield func(int) bool) {
+			for member := range v {
+				if !yield(len(member) + elementOverhead) {
+					return
+				}
+			}
+		})
+	case map[string]string:
+		size += sampledSize(len(v), samples, func(yield func(int) bool) {
+			for field, value := range v {
+				if !yield(len(field) + len(value) + 2*elementOverhead) {
+					return
+				}
+			}
+		})
+	case *zset:
+		// Each member is held by the map, with its score, and a skiplist node,
+		// or only by the element of a packed set
+		perMember := elementOverhead + 8 + skiplistNodeOverhead
+		if v.isPacked() {
+			perMember = 24
+		}
+		size += sampledSize(v.len(), samples, func(yield func(int) bool) {
+			v.each(func(member string, _ float64) bool {
+				return yield(len(member) + perMember)
+			})
+		})
+	case *stream:
+		// Each entry holds its ID and a slice of fields and values
+		size += sampledSize(v.len(), samples, func(yield func(int) bool) {
+			v.each(func(e streamEntry) bool {
+				n := 16 + elementOverhead
+				for _, f := range e.fields {
+					n += len(f) + elementOverhead
+				}
+				return yield(n)
+			})
+		})
+	}
+	return size
+}
+
+// sampledSize extrapolates the size of a collection of n elements from the
+// sizes the first samples elements yield
+func sampledSize(n, samples int, sizes func(yield func(int) bool)) int {
+	total, seen := 0, 0
+	sizes(func(size int) bool {
+		total += size
+		seen++
+		return samples == 0 || seen < samples
+	})
+	if seen == 0 {
+		return 0
+	}
+	return total * n / seen
+}
+
+// PrefixUsage is the number of keys starting with a prefix and the memory
+// they use, as estimated by entryMemory
+type PrefixUsage struct {
+	Prefix string
+	Keys   int
+	Bytes  int
+}
+
+// Prefixes tallied by the background breakdown, longest first
+var memoryPrefixes struct {
+	mu   sync.Mutex
+	list []string
+}
+
+// SetMemoryPrefixes sets the key prefixes memory usage is broken down by
+// A key counts towards its longest matching prefix only.
+func SetMemoryPrefixes(prefixes []string) {
+	list := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix != prefixUsageRemainder && !slices.Contains(list, prefix) {
+			list = append(list, prefix)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+
+	memoryPrefixes.mu.Lock()
+	memoryPrefixes.list = list
+	memoryPrefixes.mu.Unlock()
+}
+
+func memoryPrefixList() []string {
+	memoryPrefixes.mu.Lock()
+	defer memoryPrefixes.mu.Unlock()
+	return memoryPrefixes.list
+}
+
+// prefixTally is a database's per-prefix breakdown. It is computed by a
+// background pass that walks the keyspace a few buckets at a time, like
+// SCAN, and published whole once the pass completes, so a breakdown never
+// requires visiting every key at once. Keys written during a pass may be
+// counted in the next one only.
+type prefixTally struct {
+	mu       sync.Mutex
+	prefixes []string      // Prefixes of the pass in progress
+	cursor   uint64        // Next bucket of the pass in progress
+	pending  []PrefixUsage // Totals so far, one per prefix plus the remainder
+	report   []PrefixUsage // Totals of the last complete pass
+	passes   int           // Passes completed since the prefixes last changed
+}
+
+func newPrefixUsage(prefixes []string) []PrefixUsage {
+	usage := make([]PrefixUsage, len(prefixes)+1)
+	for i, prefix := range prefixes {
+		usage[i].Prefix = prefix
+	}
+	usage[len(prefixes)].Prefix = prefixUsageRemainder
+	return usage
+}
+
+// tallyPrefixes advances the database's breakdown pass by prefixUsageBuckets
+// buckets, starting over if the prefixes changed since the last call
+func (s *Store) tallyPrefixes(prefixes []string) {
+	t := &s.usage
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !slices.Equal(t.prefixes, prefixes) || t.pending == nil {
+		t.prefixes = prefixes
+		t.cursor = 0
+		t.pending = newPrefixUsage(prefixes)
+		t.report = nil
+		t.passes = 0
+	}
+
+	now := clockNow()
+	s.mu.RLock()
+	for i := 0; i < prefixUsageBuckets; i++ {
+		t.cursor = s.data.scanBucket(t.cursor, func(key string, entry *Entry) {
+			if entry.expired(now) {
+				return
+			}
+			i := len(prefixes)
+			for j, prefix := range prefixes {
+				if strings.HasPrefix(key, prefix) {
+					i = j
+					break
+				}
+			}
+			t.pending[i].Keys++
+			t.pending[i].Bytes += entryMemory(key, entry, memorySamples)
+		})
+		if t.cursor == 0 {
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if t.cursor == 0 {
+		t.report = t.pending
+		t.pending = newPrefixUsage(prefixes)
+		t.passes++
+	}
+}
+
+// PrefixUsage returns the breakdown of the last complete pass, or nil if
+// none has completed yet
+func (s *Store) PrefixUsage() []PrefixUsage {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	return slices.Clone(s.usage.report)
+}
+
+// prefixUsageLoop advances the prefix breakdown of every database while
+// prefixes are configured
+func prefixUsageLoop() {
+	ticker := time.NewTicker(prefixUsageInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		prefixes := memoryPrefixList()
+		if len(prefixes) == 0 {
+			continue
+		}
+		for _, db := range databases.All() {
+			db.tallyPrefixes(prefixes)
+		}
+	}
+}
+
+// MemoryUsage estimates the bytes used by key and its value
+// Returns (bytes, exists)
+func (s *Store) MemoryUsage(key string, samples int) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.peek(key)
+	if entry == nil {
+		return 0, false
+	}
+	return entryMemory(key, entry, samples), true
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"PREFIXES",
+	"    Return the number of keys and the memory used under each configured",
+	"    memory-prefix, as of the last complete background pass. Keys matching",
+	"    no prefix are reported under the empty prefix.",
+	"HELP",
+	"    Print this help.",
+}
+
+// MEMORY USAGE key [SAMPLES count]
+// MEMORY PREFIXES
+// MEMORY HELP
+func memoryCommand(c *Client, args []string) string {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range memoryHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(memoryHelp)) + "\r\n" + lines
+
+	case sub == "USAGE" && len(args) >= 3:
+		samples := memorySamples
+		switch {
+		case len(args) == 5 && strings.ToUpper(args[3]) == "SAMPLES":
+			n, err := strconv.Atoi(args[4])
+			if err != nil || n < 0 {
+				return formatError("ERR value is out of range, must be positive")
+			}
+			samples = n
+		case len(args) != 3:
+			return formatError("ERR syntax error")
+		}
+		bytes, exists := c.db().MemoryUsage(args[2], samples)
+		if !exists {
+			return formatNullBulkString()
+		}
+		return formatInteger(bytes)
+
+	case sub == "PREFIXES" && len(args) == 2:
+		if len(memoryPrefixList()) == 0 {
+			return formatError("ERR no memory-prefix is configured")
+		}
+		usage := c.db().PrefixUsage()
+		if usage == nil {
+			return formatError("ERR the prefix breakdown is still being computed, try again shortly")
+		}
+		reply := "*" + strconv.Itoa(len(usage)) + "\r\n"
+		for _, u := range usage {
+			reply += c.formatMap([]string{
+				formatBulkString("prefix"), formatBulkString(u.Prefix),
+				formatBulkString("keys"), formatInteger(u.Keys),
+				formatBulkString("bytes"), formatInteger(u.Bytes),
+			})
+		}
+		return reply
+	}
+	return formatError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try MEMORY HELP.")
+}
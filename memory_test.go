@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: Estimates grow with the value and missing keys reply null
+	run("SET", "small", "v")
+	run("SET", "large", strings.Repeat("v", 1000))
+	small, _ := databases.Get(0).MemoryUsage("small", memorySamples)
+	large, _ := databases.Get(0).MemoryUsage("large", memorySamples)
+	if large-small != 999 {
+		t.Fatalf("expected the estimates to differ by the value length, got %d and %d", small, large)
+	}
+	if reply := run("MEMORY", "USAGE", "missing"); reply != "$-1\r\n" {
+		t.Fatalf("expected null for a missing key, got %q", reply)
+	}
+
+	// Test 2: SAMPLES is validated
+	if reply := run("MEMORY", "USAGE", "small", "SAMPLES", "-1"); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("expected an error for negative SAMPLES, got %q", reply)
+	}
+	if reply := run("MEMORY", "USAGE", "small", "SAMPLES", "0"); reply != formatInteger(small) {
+		t.Fatalf("expected %d, got %q", small, reply)
+	}
+}
+
+func TestMemoryPrefixes(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	defer SetMemoryPrefixes(nil)
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	db := databases.Get(0)
+
+	// Test 1: Nothing to report until prefixes are configured and a pass completes
+	if reply := run("MEMORY", "PREFIXES"); !strings.HasPrefix(reply, "-ERR no memory-prefix") {
+		t.Fatalf("expected an error without prefixes, got %q", reply)
+	}
+	SetMemoryPrefixes([]string{"session:", "session:admin:", "cart:"})
+	if reply := run("MEMORY", "PREFIXES"); !strings.HasPrefix(reply, "-ERR the prefix breakdown") {
+		t.Fatalf("expected an error before the first pass, got %q", reply)
+	}
+
+	// Test 2: Keys count towards their longest prefix, the rest to the remainder
+	for _, key := range []string{"session:1", "session:2", "session:admin:1", "cart:1", "other"} {
+		run("SET", key, "value")
+	}
+	run("RPUSH", "cart:2", "a", "b")
+	for db.PrefixUsage() == nil {
+		db.tallyPrefixes(memoryPrefixList())
+	}
+	usage := db.PrefixUsage()
+	expected := map[string]int{"session:admin:": 1, "session:": 2, "cart:": 2, "": 1}
+	if len(usage) != len(expected) {
+		t.Fatalf("expected %d prefixes, got %v", len(expected), usage)
+	}
+	for _, u := range usage {
+		if u.Keys != expected[u.Prefix] || u.Bytes <= 0 {
+			t.Fatalf("unexpected usage for prefix %q: %+v", u.Prefix, u)
+		}
+	}
+	if usage[0].Prefix != "session:admin:" || usage[len(usage)-1].Prefix != "" {
+		t.Fatalf("expected longest prefix first and the remainder last, got %v", usage)
+	}
+
+	// Test 3: The reply lists one entry per prefix
+	reply := run("MEMORY", "PREFIXES")
+	if !strings.HasPrefix(reply, "*4\r\n*6\r\n$6\r\nprefix\r\n$14\r\nsession:admin:\r\n$4\r\nkeys\r\n:1\r\n") {
+		t.Fatalf("unexpected MEMORY PREFIXES reply %q", reply)
+	}
+
+	// Test 4: Changing the prefixes starts a new pass
+	SetMemoryPrefixes([]string{"cart:"})
+	db.tallyPrefixes(memoryPrefixList())
+	if usage := db.PrefixUsage(); len(usage) != 2 || usage[0].Keys != 2 || usage[1].Keys != 4 {
+		t.Fatalf("expected a fresh breakdown by cart:, got %v", usage)
+	}
+}
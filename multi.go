@@ -0,0 +1,285 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Lock keeping transactions atomic: every command holds it for reading while
+// it runs, and EXEC holds it for writing, so no other client's command runs
+// in the middle of a transaction. Clients waiting in a blocking command
+// release it until they are served.
+var execMu = newExecLock()
+
+// execLock is a read-write lock whose waiting readers may give up once the
+// writer holding it is marked busy, as a script running past
+// busy-reply-threshold is. Waiting writers go before new readers.
+// Every command takes it for reading, so readers come and go with an atomic
+// add while no writer holds or waits for it; the mutex and the condition are
+// only used once one does.
+type execLock struct {
+	state atomic.Int64 // Readers holding the lock, plus execWriterBit while a writer holds or waits for it
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	writer  bool // Set while a writer holds the lock
+	waiting int  // Writers waiting for the readers to finish
+	busy    bool // Set while the writer is busy; cleared when it unlocks
+}
+
+// Bit of execLock.state sending readers to the slow path
+const execWriterBit = 1 << 62
+
+func newExecLock() *execLock {
+	l := &execLock{}
+	l.cond.L = &l.mu
+	return l
+}
+
+// RLock takes the lock for reading, waiting for writers however long they
+// take
+func (l *execLock) RLock() {
+	l.rlock(false)
+}
+
+// RLockUnlessBusy takes the lock for reading unless the writer holding it
+// is, or becomes while waiting, busy
+// Returns false, without the lock, in that case
+func (l *execLock) RLockUnlessBusy() bool {
+	return l.rlock(true)
+}
+
+func (l *execLock) rlock(unlessBusy bool) bool {
+	for {
+		state := l.state.Load()
+		if state&execWriterBit != 0 {
+			break
+		}
+		if l.state.CompareAndSwap(state, state+1) {
+			return true
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.writer || l.waiting > 0 {
+		if unlessBusy && l.busy {
+			return false
+		}
+		l.cond.Wait()
+	}
+	// Writers set the bit under the mutex, so none can come in before this
+	l.state.Add(1)
+	return true
+}
+
+func (l *execLock) RUnlock() {
+	if l.state.Add(-1) == execWriterBit {
+		// The last reader a writer waits for
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+func (l *execLock) Lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting++
+	l.state.Or(execWriterBit)
+	for l.writer || l.state.Load() != execWriterBit {
+		l.cond.Wait()
+	}
+	l.waiting--
+	l.writer = true
+}
+
+func (l *execLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writer, l.busy = false, false
+	l.release()
+}
+
+// downgrade turns the writer's hold into a read hold, letting no other
+// writer in between
+func (l *execLock) downgrade() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writer, l.busy = false, false
+	l.state.Add(1)
+	l.release()
+}
+
+// release lets readers back on the fast path unless another writer waits,
+// and wakes the waiters. Callers must hold mu.
+func (l *execLock) release() {
+	if l.waiting == 0 {
+		l.state.And(^execWriterBit)
+	}
+	l.cond.Broadcast()
+}
+
+// setBusy marks the writer holding the lock as busy, so that readers waiting
+// with RLockUnlessBusy give up, or as no longer busy
+func (l *execLock) setBusy(busy bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.busy = busy
+	l.cond.Broadcast()
+}
+
+// transaction is the state of a connection between MULTI and EXEC
+type transaction struct {
+	commands []queuedCommand
+	aborted  bool // Set when a command failed to queue: EXEC discards the transaction
+}
+
+// queuedCommand is a command checked at queue time, run by EXEC
+type queuedCommand struct {
+	cmd  *Command
+	args []string
+}
+
+// Commands a client in a transaction runs at once rather than queuing
+var transactionCommands = map[string]bool{
+	"multi": true, "exec": true, "discard": true, "watch": true, "quit": true, "reset": true,
+}
+
+// Error of EXEC after a command failed to queue
+const execAbortError = "EXECABORT Transaction discarded because of previous errors."
+
+// flagTransaction makes the client's transaction, if any, fail at EXEC
+// because a command could not be queued
+func (c *Client) flagTransaction() {
+	if c.multi != nil {
+		c.multi.aborted = true
+	}
+}
+
+// lockExec takes the transaction lock for reading while the client runs a
+// command, unless it runs within EXEC or a script, which hold it for writing
+// Returns the function releasing it, or false if a busy script holds it
+func (c *Client) lockExec() (func(), bool) {
+	if c.inExec {
+		return func() {}, true
+	}
+	if !execMu.RLockUnlessBusy() {
+		return nil, false
+	}
+	c.execLocked = true
+	return func() {
+		c.execLocked = false
+		execMu.RUnlock()
+	}, true
+}
+
+// releaseExec gives up the client's read hold of the transaction lock, and
+// aofMu if it holds it, so transactions run while it waits in a blocking
+// command and EXEC can take it for writing. The returned function takes them
+// back.
+func (c *Client) releaseExec() func() {
+	if !c.execLocked {
+		return func() {}
+	}
+	ordered := c.aofLocked
+	if ordered {
+		c.aofLocked = false
+		aofMu.Unlock()
+	}
+	execMu.RUnlock()
+	return func() {
+		execMu.RLock()
+		if ordered {
+			aofMu.Lock()
+			c.aofLocked = true
+		}
+	}
+}
+
+// exclusive runs fn holding the transaction lock for writing, so no other
+// client's command runs in the meantime and the client's own commands do not
+// block. Within EXEC, which holds it already, fn simply runs. The holds the
+// client had are taken back before the lock is released, so no other command
+// runs, or is appended to the append-only file, between fn and the rest of
+// the command.
+func (c *Client) exclusive(fn func()) {
+	if c.inExec {
+		fn()
+		return
+	}
+	reading, ordered := c.execLocked, c.aofLocked
+	c.releaseExec()
+	execMu.Lock()
+	c.inExec = true
+	defer func() {
+		c.inExec = false
+		if f := aof.Load(); f != nil {
+			f.endTransaction()
+		}
+		if ordered {
+			aofMu.Lock() // Free: its holders hold execMu
+			c.aofLocked = true
+		}
+		if reading {
+			execMu.downgrade()
+		} else {
+			execMu.Unlock()
+		}
+	}()
+	fn()
+}
+
+// MULTI
+func multiCommand(c *Client, args []string) string {
+	if c.multi != nil {
+		return formatError("ERR MULTI calls can not be nested")
+	}
+	c.multi = &transaction{}
+	return formatSimpleString("OK")
+}
+
+// EXEC
+// Runs the queued commands with no other client's command in between, unless
+// a key the client watches changed since WATCH
+// Returns their replies, in order, or a null array if a watched key changed
+func execCommand(c *Client, args []string) string {
+	tx := c.multi
+	if tx == nil {
+		return formatError("ERR EXEC without MULTI")
+	}
+	c.multi = nil
+	if tx.aborted {
+		c.unwatchAll()
+		return formatError(execAbortError)
+	}
+
+	var reply string
+	c.exclusive(func() {
+		changed := c.watchChanged()
+		c.unwatchAll()
+		if changed {
+			reply = formatNullArray()
+			return
+		}
+		replies := make([]string, len(tx.commands))
+		for i, queued := range tx.commands {
+			replies[i] = c.call(queued.cmd, queued.args)
+		}
+		reply = "*" + strconv.Itoa(len(replies)) + "\r\n" + strings.Join(replies, "")
+	})
+	c.replyAttrs = c.replyAttrs[:0]
+	return reply
+}
+
+// DISCARD
+func discardCommand(c *Client, args []string) string {
+	if c.multi == nil {
+		return formatError("ERR DISCARD without MULTI")
+	}
+	c.multi = nil
+	c.unwatchAll()
+	return formatSimpleString("OK")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransactions(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	steps := func(steps []struct{ args, want string }) {
+		t.Helper()
+		for _, step := range steps {
+			if reply := client.execute(strings.Fields(step.args)); reply != step.want {
+				t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+			}
+		}
+	}
+
+	// Test 1: Commands after MULTI are queued and run by EXEC, which returns
+	// their replies
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"SET k 1", "+QUEUED\r\n"},
+		{"HINCRBY h f 2", "+QUEUED\r\n"},
+		{"GET k", "+QUEUED\r\n"},
+		{"EXEC", "*3\r\n+OK\r\n:2\r\n$1\r\n1\r\n"},
+		{"EXEC", "-ERR EXEC without MULTI\r\n"},
+	})
+
+	// Test 2: A command refused at queue time aborts the transaction
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"SET k", "-ERR wrong number of arguments for 'set' command\r\n"},
+		{"HINCRBY h f 1", "+QUEUED\r\n"},
+		{"NOPE", "-ERR unknown command 'nope'\r\n"},
+		{"EXEC", "-" + execAbortError + "\r\n"},
+		{"HGET h f", "$1\r\n2\r\n"},
+	})
+
+	// Test 3: Errors while running do not stop the other commands
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"SET s x", "+QUEUED\r\n"},
+		{"HINCRBY s f 1", "+QUEUED\r\n"},
+		{"HINCRBY h f 1", "+QUEUED\r\n"},
+		{"EXEC", "*3\r\n+OK\r\n-" + wrongTypeError + "\r\n:3\r\n"},
+	})
+
+	// Test 4: DISCARD, RESET and misplaced transaction commands
+	steps([]struct{ args, want string }{
+		{"DISCARD", "-ERR DISCARD without MULTI\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"MULTI", "-ERR MULTI calls can not be nested\r\n"},
+		{"HINCRBY h f 1", "+QUEUED\r\n"},
+		{"DISCARD", "+OK\r\n"},
+		{"HGET h f", "$1\r\n3\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"HINCRBY h f 1", "+QUEUED\r\n"},
+		{"RESET", "+RESET\r\n"},
+		{"EXEC", "-ERR EXEC without MULTI\r\n"},
+		{"HGET h f", "$1\r\n3\r\n"},
+	})
+
+	// Test 5: Blocking commands in a transaction time out at once
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"BLPOP empty 0", "+QUEUED\r\n"},
+		{"EXEC", "*1\r\n" + formatNullArray()},
+	})
+	if n := blockedClients.Load(); n != 0 {
+		t.Fatalf("expected no blocked clients, got %d", n)
+	}
+
+	// Test 6: Other clients' commands do not run in the middle of EXEC, and
+	// transactions run while other clients are blocked
+	blocked := make(chan string)
+	go func() { blocked <- (&Client{}).execute([]string{"BLPOP", "list", "0"}) }()
+	for blockedClients.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other := &Client{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				other.execute([]string{"HINCRBY", "counter", "f", "1"})
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		client.execute([]string{"MULTI"})
+		client.execute([]string{"DEL", "counter"})
+		for j := 0; j < 10; j++ {
+			client.execute([]string{"HINCRBY", "counter", "f", "1"})
+		}
+		reply := client.execute([]string{"EXEC"})
+		if !strings.HasSuffix(reply, ":9\r\n:10\r\n") {
+			t.Fatalf("expected the transaction to run alone, got %q", reply)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// Test 7: The blocked client is served once EXEC ends, not between the
+	// transaction's commands
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"RPUSH list a", "+QUEUED\r\n"},
+		{"LLEN list", "+QUEUED\r\n"},
+		{"EXEC", "*2\r\n:1\r\n:1\r\n"},
+	})
+	select {
+	case reply := <-blocked:
+		if reply != formatArray([]string{"list", "a"}) {
+			t.Fatalf("unexpected reply of the blocked client %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the transaction to serve the blocked client")
+	}
+}
+
+func TestExecLock(t *testing.T) {
+	l := newExecLock()
+
+	// Test 1: Readers share the lock without a writer around
+	l.RLock()
+	if !l.RLockUnlessBusy() {
+		t.Fatalf("expected a second reader to get the lock")
+	}
+	if l.state.Load() != 2 {
+		t.Fatalf("expected 2 readers, got %d", l.state.Load())
+	}
+
+	// Test 2: A writer waits for the readers, and new readers wait for it
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(locked)
+	}()
+	for l.state.Load()&execWriterBit == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	read := make(chan struct{})
+	go func() {
+		l.RLock()
+		close(read)
+	}()
+	l.RUnlock()
+	l.RUnlock()
+	<-locked
+	select {
+	case <-read:
+		t.Fatalf("expected the reader to wait for the writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Test 3: Readers waiting give up once the writer is busy
+	l.setBusy(true)
+	if l.RLockUnlessBusy() {
+		t.Fatalf("expected the reader to give up on a busy writer")
+	}
+
+	// Test 4: Downgrading lets the waiting reader in, and the fast path resumes
+	l.downgrade()
+	<-read
+	l.RUnlock()
+	l.RUnlock()
+	if l.state.Load() != 0 {
+		t.Fatalf("expected the lock to be free, got state %d", l.state.Load())
+	}
+	l.Lock()
+	l.Unlock()
+	if !l.RLockUnlessBusy() || l.state.Load() != 1 {
+		t.Fatalf("expected a reader on the fast path, got state %d", l.state.Load())
+	}
+	l.RUnlock()
+}
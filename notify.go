@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Keyspace notification flags, set by notify-keyspace-events with Redis's letters
+const (
+	notifyKeyspace = 1 << iota // K: events published on __keyspace@<db>__:<key>
+	notifyKeyevent             // E: events published on __keyevent@<db>__:<event>
+	notifyGeneric              // g: DEL, EXPIRE, RENAME...
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x: keys removed by expiration
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyModule               // d
+	notifyKeyMiss              // m
+	notifyNew                  // n
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule // A
+)
+
+// Letters of notify-keyspace-events, in the order CONFIG GET lists them
+var notifyFlagLetters = []struct {
+	letter byte
+	flag   int
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'h', notifyHash}, {'z', notifyZset}, {'x', notifyExpired}, {'e', notifyEvicted},
+	{'t', notifyStream}, {'d', notifyModule}, {'K', notifyKeyspace}, {'E', notifyKeyevent},
+	{'m', notifyKeyMiss}, {'n', notifyNew},
+}
+
+// The notify-keyspace-events flags in effect (none by default)
+var keyspaceEvents atomic.Int64
+
+// parseNotifyFlags parses a notify-keyspace-events value such as "Ex"
+func parseNotifyFlags(value string) (int, error) {
+	if value == `""` {
+		value = "" // As written in a configuration file
+	}
+	flags := 0
+	for i := 0; i < len(value); i++ {
+		flag := 0
+		if value[i] == 'A' {
+			flag = notifyAll
+		}
+		for _, l := range notifyFlagLetters {
+			if l.letter == value[i] {
+				flag = l.flag
+			}
+		}
+		if flag == 0 {
+			return 0, fmt.Errorf("invalid notify-keyspace-events '%s'", value)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// formatNotifyFlags returns the letters of flags, A standing for every class
+func formatNotifyFlags(flags int) string {
+	var letters []byte
+	if flags&notifyAll == notifyAll {
+		letters = append(letters, 'A')
+	}
+	for _, l := range notifyFlagLetters {
+		if l.flag&notifyAll != 0 && flags&notifyAll == notifyAll {
+			continue
+		}
+		if flags&l.flag != 0 {
+			letters = append(letters, l.letter)
+		}
+	}
+	return string(letters)
+}
+
+// notifyKeyspaceEvent publishes event for key of database dbIndex, on the
+// keyspace and keyevent channels enabled for its class
+func notifyKeyspaceEvent(class int, event, key string, dbIndex int) {
+	flags := int(keyspaceEvents.Load())
+	if flags&class == 0 {
+		return
+	}
+	db := strconv.Itoa(dbIndex)
+	if flags&notifyKeyspace != 0 {
+		broker.publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		broker.publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
+
+// notifyExpiredKeys publishes the expired event of keys removed from db by
+// expiration. Callers must not hold the store lock: the index of db is looked
+// up under the databases lock, which SWAPDB takes before the store locks.
+func notifyExpiredKeys(db *Store, keys []string) {
+	flags := int(keyspaceEvents.Load())
+	if len(keys) == 0 || flags&notifyExpired == 0 || flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return
+	}
+	index := slices.Index(databases.All(), db)
+	if index < 0 {
+		return // A store of its own, as in tests
+	}
+	for _, key := range keys {
+		notifyKeyspaceEvent(notifyExpired, "expired", key, index)
+	}
+}
+
+// Parameter of CONFIG GET and CONFIG SET for keyspace notifications
+var notifyConfigParams = []configParam{{
+	name: "notify-keyspace-events",
+	get:  func() string { return formatNotifyFlags(int(keyspaceEvents.Load())) },
+	set: func(value string) error {
+		flags, err := parseNotifyFlags(value)
+		if err != nil {
+			return err
+		}
+		keyspaceEvents.Store(int64(flags))
+		return nil
+	},
+}}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceNotifications(t *testing.T) {
+	savedDBs, savedBroker := databases, broker
+	databases, broker = NewDatabases(2), newPubSubBroker()
+	defer func() { databases, broker = savedDBs, savedBroker }()
+	defer keyspaceEvents.Store(0)
+	vc := NewVirtualClock(time.Now())
+	SetClock(vc)
+	defer SetClock(nil)
+
+	client := &Client{}
+	sub, reader := dialPubSub(t)
+	defer sub.Close()
+	expectFrames(t, sub, reader, formatPush(false, formatBulkString("psubscribe"), formatBulkString("__key*"), formatInteger(1)),
+		"PSUBSCRIBE", "__key*")
+	pmessage := func(channel, msg string) string {
+		return formatArray([]string{"pmessage", "__key*", channel, msg})
+	}
+	setExpiring := func(key string) {
+		client.execute([]string{"SET", key, "v"})
+		client.execute([]string{"PEXPIREAT", key, strconv.FormatInt(vc.Now().Add(100*time.Millisecond).UnixMilli(), 10)})
+	}
+
+	// Test 1: Flags are validated and listed back with A for every class
+	if reply := client.execute([]string{"CONFIG", "SET", "notify-keyspace-events", "Kw"}); reply[0] != '-' {
+		t.Fatalf("expected an unknown flag to be refused, got %q", reply)
+	}
+	client.execute([]string{"CONFIG", "SET", "notify-keyspace-events", "KEA"})
+	if reply := client.execute([]string{"CONFIG", "GET", "notify-keyspace-events"}); reply != formatArray([]string{"notify-keyspace-events", "AKE"}) {
+		t.Fatalf("expected AKE, got %q", reply)
+	}
+
+	// Test 2: A key removed by its precise timer is published on both channels
+	databases.Get(1).SetPreciseExpireLimit(10)
+	client.execute([]string{"SELECT", "1"})
+	setExpiring("timed")
+	vc.Advance(100 * time.Millisecond)
+	expectFrames(t, sub, reader, pmessage("__keyspace@1__:timed", "expired")+pmessage("__keyevent@1__:expired", "timed"))
+
+	// Test 3: So is a key removed by the sampled expiration cycle
+	client.execute([]string{"SELECT", "0"})
+	setExpiring("sampled")
+	vc.Advance(100 * time.Millisecond)
+	databases.Get(0).activeExpire()
+	expectFrames(t, sub, reader, pmessage("__keyspace@0__:sampled", "expired")+pmessage("__keyevent@0__:expired", "sampled"))
+
+	// Test 4: Only the enabled channels get events
+	client.execute([]string{"CONFIG", "SET", "notify-keyspace-events", "Ex"})
+	setExpiring("evented")
+	vc.Advance(100 * time.Millisecond)
+	databases.Get(0).activeExpire()
+	expectFrames(t, sub, reader, pmessage("__keyevent@0__:expired", "evented"))
+
+	// Test 5: Without K or E nothing is published
+	client.execute([]string{"CONFIG", "SET", "notify-keyspace-events", "x"})
+	setExpiring("silent")
+	vc.Advance(100 * time.Millisecond)
+	databases.Get(0).activeExpire()
+	if reply := broker.publish("__keyevent@0__:marker", "m"); reply != 1 {
+		t.Fatalf("expected the marker to reach the subscriber, got %d", reply)
+	}
+	expectFrames(t, sub, reader, pmessage("__keyevent@0__:marker", "m"))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LFU counter parameters, matching Redis's defaults
+const (
+	lfuInitValue = 5           // Counter of a newly created key
+	lfuLogFactor = 10          // Higher values make the counter saturate more slowly
+	lfuDecayTime = time.Minute // Counter decreases by one per elapsed period without access
+)
+
+// Maximum string length stored with the "embstr" encoding in Redis
+const embstrMaxLength = 44
+
+// initAccess sets the access metadata of a newly stored entry
+func (e *Entry) initAccess(now time.Time) {
+	if e.lastAccess.Load() == 0 {
+		e.lastAccess.Store(now.UnixNano())
+		e.frequency.Store(lfuInitValue)
+	}
+}
+
+// touch records an access: the LRU timestamp is refreshed and the LFU
+// counter is decayed for the idle time, then incremented logarithmically
+func (e *Entry) touch(now time.Time) {
+	last := e.lastAccess.Swap(now.UnixNano())
+	counter := e.decayedFrequency(last, now)
+
+	if counter < 255 {
+		base := float64(counter) - lfuInitValue
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	e.frequency.Store(counter)
+}
+
+// decayedFrequency returns the LFU counter after decaying it for the time since last
+func (e *Entry) decayedFrequency(last int64, now time.Time) uint32 {
+	counter := e.frequency.Load()
+	periods := uint32(now.Sub(time.Unix(0, last)) / lfuDecayTime)
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// idleTime returns how long ago the entry was last accessed
+func (e *Entry) idleTime(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, e.lastAccess.Load()))
+}
+
+// encoding returns the name OBJECT ENCODING reports for the entry
+func (e *Entry) encoding() string {
+	switch e.Type {
+	case TypeString:
+		s, _ := e.Value.(string)
+		if len(s) <= 20 {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+				return "int"
+			}
+		}
+		if len(s) <= embstrMaxLength {
+			return "embstr"
+		}
+		return "raw"
+	case TypeList:
+		if _, packed := e.Value.(*listpack); packed {
+			return "listpack"
+		}
+		return "quicklist"
+	case TypeSet:
+		if _, packed := e.Value.(*intset); packed {
+			return "intset"
+		}
+		return "hashtable"
+	case TypeHash:
+		if _, packed := e.Value.(*hashPack); packed {
+			return "listpack"
+		}
+		return "hashtable"
+	case TypeSortedSet:
+		if zs, _ := e.Value.(*zset); zs != nil && zs.isPacked() {
+			return "listpack"
+		}
+		return "skiplist"
+	case TypeStream:
+		return "stream"
+	default:
+		return "hashtable"
+	}
+}
+
+// ObjectInfo returns what OBJECT reports about key, without touching its
+// access metadata. ok is false if the key does not exist.
+func (s *Store) ObjectInfo(key string) (encoding string, idle time.Duration, freq uint32, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.peek(key)
+	if entry == nil {
+		return "", 0, 0, false
+	}
+	now := clockNow()
+	return entry.encoding(), entry.idleTime(now), entry.decayedFrequency(entry.lastAccess.Load(), now), true
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// OBJECT ENCODING|FREQ|IDLETIME|REFCOUNT key
+// OBJECT HELP
+func objectCommand(c *Client, args []string) string {
+	sub := strings.ToUpper(args[1])
+	if sub == "HELP" && len(args) == 2 {
+		lines := ""
+		for _, line := range objectHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(objectHelp)) + "\r\n" + lines
+	}
+
+	switch sub {
+	case "ENCODING", "FREQ", "IDLETIME", "REFCOUNT":
+		if len(args) != 3 {
+			return formatError("ERR wrong number of arguments for 'object|" + strings.ToLower(sub) + "' command")
+		}
+	default:
+		return formatError("ERR unknown subcommand '" + args[1] + "'. Try OBJECT HELP.")
+	}
+
+	encoding, idle, freq, ok := c.db().ObjectInfo(args[2])
+	if !ok {
+		return formatNullBulkString()
+	}
+	switch sub {
+	case "ENCODING":
+		return formatBulkString(encoding)
+	case "FREQ":
+		return formatInteger(int(freq))
+	case "IDLETIME":
+		return formatInteger(int(idle / time.Second))
+	default:
+		// Values are never shared between keys
+		return formatInteger(1)
+	}
+}
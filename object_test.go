@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	cases := map[string]string{
+		"12345":                 "int",
+		"-7":                    "int",
+		"007":                   "embstr",
+		"hello":                 "embstr",
+		strings.Repeat("x", 45): "raw",
+	}
+	for value, want := range cases {
+		entry := &Entry{Type: TypeString, Value: value}
+		if got := entry.encoding(); got != want {
+			t.Errorf("encoding of %q = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestEntryAccessMetadata(t *testing.T) {
+	store := NewStore()
+	store.Set("k", "v")
+
+	// Test 1: New keys start idle at the initial LFU value
+	_, idle, freq, ok := store.ObjectInfo("k")
+	if !ok || idle > time.Second || freq != lfuInitValue {
+		t.Fatalf("expected fresh key with freq %d, got (idle %v, freq %d, ok %v)", lfuInitValue, idle, freq, ok)
+	}
+
+	// Test 2: Reads increase the frequency counter, OBJECT itself does not
+	for i := 0; i < 1000; i++ {
+		store.Get("k")
+	}
+	_, _, freq, _ = store.ObjectInfo("k")
+	if freq <= lfuInitValue {
+		t.Fatalf("expected frequency to grow with reads, got %d", freq)
+	}
+	_, _, again, _ := store.ObjectInfo("k")
+	if again != freq {
+		t.Fatalf("expected OBJECT not to count as an access (%d != %d)", again, freq)
+	}
+
+	// Test 3: The counter decays with idle time
+	store.mu.RLock()
+	entry := store.data.peek("k")
+	store.mu.RUnlock()
+	decayed := entry.decayedFrequency(entry.lastAccess.Load(), time.Now().Add(3*lfuDecayTime))
+	if decayed != freq-3 {
+		t.Fatalf("expected counter %d after 3 decay periods, got %d", freq-3, decayed)
+	}
+
+	// Test 4: Missing keys
+	if _, _, _, ok := store.ObjectInfo("nosuch"); ok {
+		t.Fatalf("expected no object info for missing key")
+	}
+}
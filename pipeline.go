@@ -0,0 +1,52 @@
+package main
+
+import "sync/atomic"
+
+// Commands a connection may have parsed but not yet executed, when
+// pipeline-max-pending is not configured
+const defaultPipelineMaxPending = 1024
+
+// Times a connection stopped reading because its pipeline was full
+var pipelinePauses atomic.Int64
+
+// pipelinedCommand is a command read from a connection ahead of its execution,
+// or the read error that ended the connection
+type pipelinedCommand struct {
+	args []string
+	err  error
+}
+
+// startPipeline starts parsing commands from the connection ahead of their
+// execution. At most limit parsed commands are held: once the returned queue
+// is full, the connection is not read until the client goroutine catches up,
+// so a client pipelining without reading replies fills its socket buffers
+// instead of the server's memory. The reader stops after a read error, which
+// is queued last, or once done is closed.
+func (c *Client) startPipeline(limit int, done <-chan struct{}) <-chan pipelinedCommand {
+	// The reader goroutine holds one parsed command while it waits
+	queue := make(chan pipelinedCommand, max(limit, 1)-1)
+	c.hangup = make(chan struct{})
+	go func() {
+		for {
+			args, err := ReadRESP(c.reader)
+			if err != nil {
+				close(c.hangup)
+			}
+			next := pipelinedCommand{args: args, err: err}
+			select {
+			case queue <- next:
+			default:
+				pipelinePauses.Add(1)
+				select {
+				case queue <- next:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return queue
+}
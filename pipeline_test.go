@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineBackpressure(t *testing.T) {
+	// Test 1: The reader stops once limit commands are parsed ahead
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		input.WriteString("*2\r\n$4\r\nECHO\r\n$" + strconv.Itoa(len(strconv.Itoa(i))) + "\r\n" + strconv.Itoa(i) + "\r\n")
+	}
+	client := &Client{reader: bufio.NewReader(strings.NewReader(input.String()))}
+	done := make(chan struct{})
+	defer close(done)
+	pauses := pipelinePauses.Load()
+	queue := client.startPipeline(4, done)
+	deadline := time.Now().Add(time.Second)
+	for pipelinePauses.Load() == pauses && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if pipelinePauses.Load() == pauses {
+		t.Fatalf("expected the reader to pause on a full pipeline")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := len(queue); n != 3 {
+		t.Fatalf("expected 3 queued commands besides the held one, got %d", n)
+	}
+
+	// Test 2: Draining the queue resumes reading, in order, ending with the read error
+	for i := 0; i < 10; i++ {
+		next := <-queue
+		if next.err != nil || len(next.args) != 2 || next.args[1] != strconv.Itoa(i) {
+			t.Fatalf("unexpected command %d: %v (%v)", i, next.args, next.err)
+		}
+	}
+	if next := <-queue; next.err != io.EOF {
+		t.Fatalf("expected EOF after the last command, got %v", next.err)
+	}
+	select {
+	case <-client.hangup:
+	default:
+		t.Fatalf("expected hangup to be closed after the read error")
+	}
+
+	// Test 3: A client that never reads replies stops being read, and catches up afterwards
+	saved := config.PipelineMaxPending
+	config.PipelineMaxPending = 2
+	defer func() { config.PipelineMaxPending = saved }()
+	server, peer := net.Pipe()
+	defer peer.Close()
+	go handleConnection(server, nil)
+	written := make(chan int, 1)
+	go func() {
+		n := 0
+		for ; n < 200; n++ {
+			if _, err := peer.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+				break
+			}
+		}
+		written <- n
+	}()
+	select {
+	case n := <-written:
+		t.Fatalf("expected writes to block while replies are unread, all %d went through", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	reader := bufio.NewReader(peer)
+	for i := 0; i < 200; i++ {
+		if line, _ := reader.ReadString('\n'); line != "+PONG\r\n" {
+			t.Fatalf("expected PONG %d, got %q", i, line)
+		}
+	}
+	if n := <-written; n != 200 {
+		t.Fatalf("expected all commands to be written, got %d", n)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expireTimer removes one key at its exact expiration time
+type expireTimer struct {
+	entry *Entry    // Entry the timer was armed for
+	at    time.Time // Expiration time it was armed for
+	timer ClockTimer
+}
+
+// Keys removed by a precise expiration timer, across all databases
+var preciseExpired atomic.Int64
+
+// SetPreciseExpireLimit sets how many keys of the store may get a dedicated
+// expiration timer. Keys with a timer are removed at their deadline instead
+// of whenever the sampled active expiration cycle finds them; keys beyond the
+// limit fall back to sampling. Zero disables precise expiration.
+func (s *Store) SetPreciseExpireLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preciseLimit = n
+	if n == 0 {
+		s.stopExpireTimers()
+	}
+}
+
+// armExpireTimer starts a timer for an entry just stored under key, if it has
+// an expiration time and the timer budget allows. Callers must hold the write lock.
+func (s *Store) armExpireTimer(key string, entry *Entry) {
+	if entry.ExpiresAt.IsZero() || s.timers[key] != nil || len(s.timers) >= s.preciseLimit {
+		return
+	}
+	t := &expireTimer{entry: entry, at: entry.ExpiresAt}
+	clock := currentClock()
+	t.timer = clock.AfterFunc(entry.ExpiresAt.Sub(clock.Now()), func() {
+		s.fireExpireTimer(key, t)
+	})
+	if s.timers == nil {
+		s.timers = make(map[string]*expireTimer)
+	}
+	s.timers[key] = t
+}
+
+// disarmStaleTimer stops the timer of key if the key no longer holds the
+// entry and expiration time the timer was armed for. Called by keyModified.
+func (s *Store) disarmStaleTimer(key string) {
+	t := s.timers[key]
+	if t == nil {
+		return
+	}
+	if entry := s.data.lookup(key); entry == t.entry && entry.ExpiresAt.Equal(t.at) {
+		return
+	}
+	t.timer.Stop()
+	delete(s.timers, key)
+}
+
+// fireExpireTimer deletes key if it still holds the entry the timer was armed for
+func (s *Store) fireExpireTimer(key string, t *expireTimer) {
+	s.mu.Lock()
+	if s.timers[key] != t {
+		s.mu.Unlock()
+		return // Disarmed while waiting for the lock
+	}
+	delete(s.timers, key)
+	if s.data.lookup(key) != t.entry || !t.entry.expired(clockNow()) {
+		s.mu.Unlock()
+		return
+	}
+	s.data.delete(key)
+	s.keyModified(key)
+	preciseExpired.Add(1)
+	s.mu.Unlock()
+
+	notifyExpiredKeys(s, []string{key})
+}
+
+// stopExpireTimers stops every timer. Callers must hold the write lock.
+func (s *Store) stopExpireTimers() {
+	for _, t := range s.timers {
+		t.timer.Stop()
+	}
+	s.timers = nil
+}
+
+// PreciseTimerCount returns the number of armed expiration timers
+func (s *Store) PreciseTimerCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.timers)
+}
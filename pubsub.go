@@ -0,0 +1,325 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pubsubBroker routes published messages to the connections subscribed to
+// their channel, or to a pattern matching it
+type pubsubBroker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{} // Subscribers by channel
+	patterns map[string]map[*Client]struct{} // Subscribers by glob-style pattern
+}
+
+// Broker shared by every connection, whatever database it selected
+var broker = newPubSubBroker()
+
+func newPubSubBroker() *pubsubBroker {
+	return &pubsubBroker{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// subscribers returns the subscribers of channels, or of patterns
+func (b *pubsubBroker) subscribers(patterns bool) map[string]map[*Client]struct{} {
+	if patterns {
+		return b.patterns
+	}
+	return b.channels
+}
+
+// subscriptions is the pub/sub state of a connection. It is changed by the
+// connection's own goroutine under the broker's write lock, so publishers
+// holding the read lock may use it.
+type subscriptions struct {
+	channels map[string]struct{} // Channels subscribed to
+	patterns map[string]struct{} // Patterns subscribed to
+	resp3    bool                // Push messages as RESP3, per the protocol as of the last subscription
+}
+
+// count returns the number of subscriptions, which confirmations report
+func (s *subscriptions) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// names returns the channels, or the patterns, subscribed to
+func (s *subscriptions) names(patterns bool) *map[string]struct{} {
+	if patterns {
+		return &s.patterns
+	}
+	return &s.channels
+}
+
+// formatPush formats already encoded elements as an array, or as a RESP3
+// push, which sets pub/sub frames apart from replies
+func formatPush(resp3 bool, elems ...string) string {
+	header := "*"
+	if resp3 {
+		header = ">"
+	}
+	return header + strconv.Itoa(len(elems)) + "\r\n" + strings.Join(elems, "")
+}
+
+// subscribe adds c to the subscribers of names, which are channels or, with
+// patterns, glob-style patterns
+// Returns one confirmation per name
+func (b *pubsubBroker) subscribe(c *Client, names []string, patterns bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kind := "subscribe"
+	if patterns {
+		kind = "psubscribe"
+	}
+	subs := &c.subscriptions
+	subscribed := subs.names(patterns)
+	if *subscribed == nil {
+		*subscribed = make(map[string]struct{})
+	}
+	subs.resp3 = c.resp3
+	all := b.subscribers(patterns)
+	var reply strings.Builder
+	for _, name := range names {
+		if _, ok := (*subscribed)[name]; !ok {
+			(*subscribed)[name] = struct{}{}
+			if all[name] == nil {
+				all[name] = make(map[*Client]struct{})
+			}
+			all[name][c] = struct{}{}
+		}
+		reply.WriteString(formatPush(subs.resp3, formatBulkString(kind), formatBulkString(name), formatInteger(subs.count())))
+	}
+	// Written before releasing the lock, so messages published to the
+	// channels afterwards follow the confirmations
+	return c.sendAhead(reply.String())
+}
+
+// unsubscribe removes c from the subscribers of names, or of every channel
+// (or pattern) it subscribed to if none are given
+// Returns one confirmation per name
+func (b *pubsubBroker) unsubscribe(c *Client, names []string, patterns bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kind := "unsubscribe"
+	if patterns {
+		kind = "punsubscribe"
+	}
+	subs := &c.subscriptions
+	subscribed := subs.names(patterns)
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(*subscribed))
+	}
+	if len(names) == 0 {
+		return formatPush(c.resp3, formatBulkString(kind), formatNullBulkString(), formatInteger(subs.count()))
+	}
+	var reply strings.Builder
+	for _, name := range names {
+		if _, ok := (*subscribed)[name]; ok {
+			delete(*subscribed, name)
+			b.removeSubscriber(b.subscribers(patterns), name, c)
+		}
+		reply.WriteString(formatPush(c.resp3, formatBulkString(kind), formatBulkString(name), formatInteger(subs.count())))
+	}
+	return reply.String()
+}
+
+// removeSubscriber drops c from the subscribers of name in all
+// Callers must hold the write lock.
+func (b *pubsubBroker) removeSubscriber(all map[string]map[*Client]struct{}, name string, c *Client) {
+	delete(all[name], c)
+	if len(all[name]) == 0 {
+		delete(all, name)
+	}
+}
+
+// unsubscribeAll drops every subscription of a client going away
+func (b *pubsubBroker) unsubscribeAll(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for channel := range c.subscriptions.channels {
+		b.removeSubscriber(b.channels, channel, c)
+	}
+	for pattern := range c.subscriptions.patterns {
+		b.removeSubscriber(b.patterns, pattern, c)
+	}
+	c.subscriptions.channels, c.subscriptions.patterns = nil, nil
+}
+
+// publish pushes message to the subscribers of channel and of the patterns
+// matching it, with the same rules as KEYS and SCAN MATCH
+// Returns the number of deliveries, one per matching pattern of a client
+func (b *pubsubBroker) publish(channel, message string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := 0
+	for c := range b.channels[channel] {
+		c.push(formatPush(c.subscriptions.resp3, formatBulkString("message"), formatBulkString(channel), formatBulkString(message)))
+		n++
+	}
+	for pattern, clients := range b.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			c.push(formatPush(c.subscriptions.resp3, formatBulkString("pmessage"), formatBulkString(pattern),
+				formatBulkString(channel), formatBulkString(message)))
+			n++
+		}
+	}
+	return n
+}
+
+// activeChannels returns the channels with subscribers matching pattern (all
+// if empty), in order; pattern subscriptions do not count
+func (b *pubsubBroker) activeChannels(pattern string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	channels := []string{}
+	for channel := range b.channels {
+		if pattern == "" || matchPattern(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+// numSub returns the number of subscribers of each channel
+func (b *pubsubBroker) numSub(channels []string) []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	counts := make([]int, len(channels))
+	for i, channel := range channels {
+		counts[i] = len(b.channels[channel])
+	}
+	return counts
+}
+
+// numPat returns the number of patterns with subscribers
+func (b *pubsubBroker) numPat() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.patterns)
+}
+
+// Commands a RESP2 client may run while subscribed, as their replies cannot
+// be told apart from published messages otherwise
+var subscribedCommands = map[string]bool{
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true,
+	"ping": true, "quit": true, "reset": true,
+}
+
+// subscribedContext reports whether the client is a RESP2 subscriber, which
+// only runs subscribedCommands
+func (c *Client) subscribedContext() bool {
+	return !c.resp3 && c.subscriptions.count() > 0
+}
+
+// push queues a frame for the client from another goroutine; clients
+// without a connection have no queue and miss it
+func (c *Client) push(frame string) {
+	if c.outbox != nil {
+		c.enqueue(frame)
+	}
+}
+
+// sendAhead queues reply with the frames pushed to the client rather than
+// returning it, so it goes out before frames other goroutines push later.
+// Clients without a connection get it as the command's reply instead, as do
+// transactions, whose replies EXEC collects.
+// Callers must hold the broker's write lock.
+func (c *Client) sendAhead(reply string) string {
+	c.startOutbox()
+	if c.outbox == nil || c.inExec {
+		return reply
+	}
+	c.enqueue(reply)
+	return ""
+}
+
+// SUBSCRIBE channel [channel ...]
+func subscribeCommand(c *Client, args []string) string {
+	return broker.subscribe(c, args[1:], false)
+}
+
+// UNSUBSCRIBE [channel [channel ...]]
+func unsubscribeCommand(c *Client, args []string) string {
+	return broker.unsubscribe(c, args[1:], false)
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribeCommand(c *Client, args []string) string {
+	return broker.subscribe(c, args[1:], true)
+}
+
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func punsubscribeCommand(c *Client, args []string) string {
+	return broker.unsubscribe(c, args[1:], true)
+}
+
+// PUBLISH channel message
+func publishCommand(c *Client, args []string) string {
+	return formatInteger(broker.publish(args[1], args[2]))
+}
+
+var pubsubHelp = []string{
+	"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CHANNELS [<pattern>]",
+	"    Return the currently active channels matching a <pattern> (default: '*').",
+	"NUMPAT",
+	"    Return number of subscriptions to patterns.",
+	"NUMSUB [<channel> ...]",
+	"    Return the number of subscribers for the specified channels, excluding",
+	"    pattern subscriptions (default: no channels).",
+	"SHARDCHANNELS [<pattern>]",
+	"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+	"HELP",
+	"    Print this help.",
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | HELP
+func pubsubCommand(c *Client, args []string) string {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range pubsubHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(pubsubHelp)) + "\r\n" + lines
+
+	case sub == "CHANNELS" && len(args) <= 3:
+		pattern := ""
+		if len(args) == 3 {
+			pattern = args[2]
+		}
+		return formatArray(broker.activeChannels(pattern))
+
+	case sub == "NUMSUB":
+		counts := broker.numSub(args[2:])
+		pairs := make([]string, 0, 2*len(counts))
+		for i, n := range counts {
+			pairs = append(pairs, formatBulkString(args[2+i]), formatInteger(n))
+		}
+		return c.formatMap(pairs)
+
+	case sub == "NUMPAT" && len(args) == 2:
+		return formatInteger(broker.numPat())
+
+	case sub == "SHARDCHANNELS" && len(args) <= 3:
+		// Sharded channels only exist in cluster mode, which this server
+		// does not run
+		return formatArray(nil)
+	}
+	return formatError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try PUBSUB HELP.")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialPubSub connects a client served by handleConnection over TCP, whose
+// socket buffers let a publisher push without waiting for the reader
+func dialPubSub(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	go handleConnection(server, nil)
+	return peer, bufio.NewReader(peer)
+}
+
+// expectFrames sends a command, unless args is empty, and checks the bytes
+// read next
+func expectFrames(t *testing.T, conn net.Conn, reader *bufio.Reader, want string, args ...string) {
+	t.Helper()
+	if len(args) > 0 {
+		if _, err := conn.Write([]byte(formatArray(args))); err != nil {
+			t.Fatalf("writing %v: %v", args, err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(reader, got); err != nil || string(got) != want {
+		t.Fatalf("expected %q after %v, got %q (%v)", want, args, got, err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	saved := broker
+	broker = newPubSubBroker()
+	defer func() { broker = saved }()
+
+	push := func(kind, channel string, n int) string {
+		return formatPush(false, formatBulkString(kind), formatBulkString(channel), formatInteger(n))
+	}
+	message := func(channel, msg string) string {
+		return formatArray([]string{"message", channel, msg})
+	}
+	sub, subReader := dialPubSub(t)
+	defer sub.Close()
+	other, otherReader := dialPubSub(t)
+	defer other.Close()
+	pub, pubReader := dialPubSub(t)
+	defer pub.Close()
+
+	// Test 1: Subscriptions are confirmed with their running count, once per
+	// channel even when repeated
+	expectFrames(t, sub, subReader, push("subscribe", "news", 1)+push("subscribe", "sport", 2)+push("subscribe", "news", 2),
+		"SUBSCRIBE", "news", "sport", "news")
+	expectFrames(t, other, otherReader, push("subscribe", "news", 1), "SUBSCRIBE", "news")
+
+	// Test 2: PUBLISH reaches every subscriber of the channel and counts them
+	expectFrames(t, pub, pubReader, ":2\r\n", "PUBLISH", "news", "hello")
+	expectFrames(t, sub, subReader, message("news", "hello"))
+	expectFrames(t, other, otherReader, message("news", "hello"))
+	expectFrames(t, pub, pubReader, ":1\r\n", "PUBLISH", "sport", "goal")
+	expectFrames(t, sub, subReader, message("sport", "goal"))
+	expectFrames(t, pub, pubReader, ":0\r\n", "PUBLISH", "weather", "rain")
+
+	// Test 3: UNSUBSCRIBE drops the given channels, or all of them
+	expectFrames(t, sub, subReader, push("unsubscribe", "news", 1)+push("unsubscribe", "nothing", 1), "UNSUBSCRIBE", "news", "nothing")
+	expectFrames(t, pub, pubReader, ":1\r\n", "PUBLISH", "news", "again")
+	expectFrames(t, other, otherReader, message("news", "again"))
+	expectFrames(t, sub, subReader, push("unsubscribe", "sport", 0), "UNSUBSCRIBE")
+	expectFrames(t, sub, subReader, "*3\r\n"+formatBulkString("unsubscribe")+formatNullBulkString()+":0\r\n", "UNSUBSCRIBE")
+
+	// Test 4: RESP3 clients get pushes
+	server, peer := net.Pipe()
+	defer peer.Close()
+	resp3 := &Client{conn: server, resp3: true}
+	defer broker.unsubscribeAll(resp3)
+	go resp3.execute([]string{"SUBSCRIBE", "sport"})
+	expectFrames(t, peer, bufio.NewReader(peer), formatPush(true, formatBulkString("subscribe"), formatBulkString("sport"), ":1\r\n"))
+	published := make(chan string, 1)
+	go func() { published <- (&Client{}).execute([]string{"PUBLISH", "sport", "late"}) }()
+	expectFrames(t, peer, bufio.NewReader(peer), ">3\r\n"+formatBulkString("message")+formatBulkString("sport")+formatBulkString("late"))
+	if reply := <-published; reply != ":1\r\n" {
+		t.Fatalf("expected one receiver, got %q", reply)
+	}
+
+	// Test 5: Disconnected clients are no longer subscribed
+	other.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		broker.mu.RLock()
+		n := len(broker.channels["news"])
+		broker.mu.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the closed subscriber to be dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectFrames(t, pub, pubReader, ":0\r\n", "PUBLISH", "news", "gone")
+}
+
+func TestPatternSubscriptions(t *testing.T) {
+	saved := broker
+	broker = newPubSubBroker()
+	defer func() { broker = saved }()
+
+	push := func(kind, name string, n int) string {
+		return formatPush(false, formatBulkString(kind), formatBulkString(name), formatInteger(n))
+	}
+	sub, subReader := dialPubSub(t)
+	defer sub.Close()
+	pub, pubReader := dialPubSub(t)
+	defer pub.Close()
+
+	// Test 1: Pattern and channel subscriptions share the running count
+	expectFrames(t, sub, subReader, push("psubscribe", "news.*", 1)+push("psubscribe", "h?llo", 2), "PSUBSCRIBE", "news.*", "h?llo")
+	expectFrames(t, sub, subReader, push("subscribe", "news.tech", 3), "SUBSCRIBE", "news.tech")
+
+	// Test 2: Matching patterns deliver pmessage frames, counted apart from
+	// the channel subscription of the same client
+	expectFrames(t, pub, pubReader, ":2\r\n", "PUBLISH", "news.tech", "go")
+	expectFrames(t, sub, subReader, formatArray([]string{"message", "news.tech", "go"})+
+		formatArray([]string{"pmessage", "news.*", "news.tech", "go"}))
+	expectFrames(t, pub, pubReader, ":1\r\n", "PUBLISH", "hallo", "hi")
+	expectFrames(t, sub, subReader, formatArray([]string{"pmessage", "h?llo", "hallo", "hi"}))
+	expectFrames(t, pub, pubReader, ":0\r\n", "PUBLISH", "news", "none")
+
+	// Test 3: PUNSUBSCRIBE leaves channel subscriptions alone
+	expectFrames(t, sub, subReader, push("punsubscribe", "h?llo", 2)+push("punsubscribe", "news.*", 1), "PUNSUBSCRIBE")
+	expectFrames(t, pub, pubReader, ":1\r\n", "PUBLISH", "news.tech", "still")
+	expectFrames(t, sub, subReader, formatArray([]string{"message", "news.tech", "still"}))
+	expectFrames(t, sub, subReader, "*3\r\n"+formatBulkString("punsubscribe")+formatNullBulkString()+":1\r\n", "PUNSUBSCRIBE")
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	saved := broker
+	broker = newPubSubBroker()
+	defer func() { broker = saved }()
+
+	first, second := &Client{}, &Client{}
+	defer broker.unsubscribeAll(first)
+	defer broker.unsubscribeAll(second)
+	first.execute([]string{"SUBSCRIBE", "news.tech", "news.art", "weather"})
+	second.execute([]string{"SUBSCRIBE", "news.tech"})
+	second.execute([]string{"PSUBSCRIBE", "news.*", "w*"})
+	first.execute([]string{"PSUBSCRIBE", "news.*"})
+
+	// Test 1: Active channels, subscriber counts and patterns
+	for _, step := range []struct{ args, want string }{
+		{"PUBSUB CHANNELS", formatArray([]string{"news.art", "news.tech", "weather"})},
+		{"PUBSUB CHANNELS news.*", formatArray([]string{"news.art", "news.tech"})},
+		{"PUBSUB CHANNELS sport", "*0\r\n"},
+		{"PUBSUB NUMSUB news.tech weather sport", "*6\r\n" + formatBulkString("news.tech") + ":2\r\n" +
+			formatBulkString("weather") + ":1\r\n" + formatBulkString("sport") + ":0\r\n"},
+		{"PUBSUB NUMSUB", "*0\r\n"},
+		{"PUBSUB NUMPAT", ":2\r\n"},
+		{"PUBSUB SHARDCHANNELS", "*0\r\n"},
+		{"PUBSUB NUMPAT x", "-ERR wrong number of arguments for 'pubsub|numpat' command\r\n"},
+		{"PUBSUB NOPE", "-ERR unknown subcommand or wrong number of arguments for 'NOPE'. Try PUBSUB HELP.\r\n"},
+	} {
+		if reply := (&Client{}).execute(strings.Fields(step.args)); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 2: Channels and patterns go once their last subscriber leaves
+	first.execute([]string{"UNSUBSCRIBE"})
+	second.execute([]string{"PUNSUBSCRIBE", "news.*"})
+	if reply := (&Client{}).execute([]string{"PUBSUB", "CHANNELS"}); reply != formatArray([]string{"news.tech"}) {
+		t.Fatalf("expected only news.tech to stay active, got %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"PUBSUB", "NUMPAT"}); reply != ":2\r\n" {
+		t.Fatalf("expected news.* to stay subscribed by the first client, got %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"PUBSUB", "NUMSUB", "news.tech"}); reply != "%1\r\n"+formatBulkString("news.tech")+":1\r\n" {
+		t.Fatalf("expected a RESP3 map, got %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"PUBSUB", "HELP"}); !strings.HasPrefix(reply, "*"+strconv.Itoa(len(pubsubHelp))+"\r\n") {
+		t.Fatalf("unexpected PUBSUB HELP reply %q", reply)
+	}
+}
+
+func TestSubscribedContext(t *testing.T) {
+	saved := broker
+	broker = newPubSubBroker()
+	defer func() { broker = saved }()
+
+	client := &Client{}
+	defer broker.unsubscribeAll(client)
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	restricted := func(name string) string {
+		return "-ERR Can't execute '" + name + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"
+	}
+
+	// Test 1: A subscribed RESP2 client only runs pub/sub commands, PING
+	// (replying with a frame), QUIT and RESET
+	run("SUBSCRIBE", "news")
+	for _, step := range []struct{ args, want string }{
+		{"GET k", restricted("get")},
+		{"CLIENT ID", restricted("client|id")},
+		{"PUBLISH news x", restricted("publish")},
+		{"PING", formatArray([]string{"pong", ""})},
+		{"PING hi", formatArray([]string{"pong", "hi"})},
+		{"PSUBSCRIBE n*", formatPush(false, formatBulkString("psubscribe"), formatBulkString("n*"), ":2\r\n")},
+		{"UNSUBSCRIBE", formatPush(false, formatBulkString("unsubscribe"), formatBulkString("news"), ":1\r\n")},
+		{"GET k", restricted("get")},
+		{"PUNSUBSCRIBE", formatPush(false, formatBulkString("punsubscribe"), formatBulkString("n*"), ":0\r\n")},
+		{"GET k", "$-1\r\n"},
+		{"PING", "+PONG\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 2: RESP3 clients run any command while subscribed
+	client.resp3 = true
+	run("SUBSCRIBE", "news")
+	if reply := run("GET", "k"); reply != "$-1\r\n" {
+		t.Fatalf("expected GET to run for a RESP3 subscriber, got %q", reply)
+	}
+
+	// Test 3: RESET drops subscriptions and returns to RESP2 and database 0
+	run("SELECT", "1")
+	if reply := run("RESET"); reply != "+RESET\r\n" {
+		t.Fatalf("unexpected RESET reply %q", reply)
+	}
+	if client.resp3 || client.dbIndex != 0 || client.subscriptions.count() != 0 || len(broker.channels) != 0 {
+		t.Fatalf("expected RESET to clear the connection state, got resp3=%v db=%d subscriptions=%d", client.resp3, client.dbIndex, client.subscriptions.count())
+	}
+
+	// Test 4: QUIT replies then drops the connection
+	run("SUBSCRIBE", "news")
+	if reply := run("QUIT"); reply != "+OK\r\n" || !client.closing {
+		t.Fatalf("expected QUIT to reply OK and close, got %q", reply)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outputLimit is a client-output-buffer-limit: a connection whose pending
+// frames exceed hard bytes, or soft bytes for softTime, is disconnected.
+// Zero limits are not enforced.
+type outputLimit struct {
+	hard, soft int64
+	softTime   time.Duration
+}
+
+// Limit of subscribers when client-output-buffer-limit is not configured, as
+// in Redis: 32mb, or 8mb for a minute
+var defaultPubSubOutputLimit = outputLimit{hard: 32 << 20, soft: 8 << 20, softTime: time.Minute}
+
+// Limit set by CONFIG SET, overriding the configuration file's
+var pubsubOutputLimitOverride atomic.Pointer[outputLimit]
+
+// pubsubOutputLimit returns the limit subscribers' pending frames are held to
+func pubsubOutputLimit() outputLimit {
+	if limit := pubsubOutputLimitOverride.Load(); limit != nil {
+		return *limit
+	}
+	return config.PubSubOutputLimit
+}
+
+// Connections dropped for exceeding their output buffer limit
+var outputLimitDisconnections atomic.Int64
+
+// parseOutputLimits parses client-output-buffer-limit values: groups of a
+// class, hard and soft sizes and soft seconds. Only the pubsub class applies
+// here; the normal and replica classes of redis.conf are accepted and left
+// unenforced, as this server has no replicas and answers normal clients
+// as it executes their commands.
+// Returns the pubsub limit, or false if the values do not set it
+func parseOutputLimits(args []string) (outputLimit, bool, error) {
+	if len(args) == 0 || len(args)%4 != 0 {
+		return outputLimit{}, false, fmt.Errorf("wrong number of arguments for 'client-output-buffer-limit'")
+	}
+	var limit outputLimit
+	found := false
+	for i := 0; i < len(args); i += 4 {
+		class := strings.ToLower(args[i])
+		if class != "normal" && class != "replica" && class != "slave" && class != "pubsub" {
+			return outputLimit{}, false, fmt.Errorf("invalid client class '%s'", args[i])
+		}
+		hard, hardErr := parseMemorySize(args[i+1])
+		soft, softErr := parseMemorySize(args[i+2])
+		seconds, secondsErr := strconv.ParseInt(args[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || hard < 0 || soft < 0 || seconds < 0 {
+			return outputLimit{}, false, fmt.Errorf("invalid client-output-buffer-limit '%s %s %s %s'", args[i], args[i+1], args[i+2], args[i+3])
+		}
+		if class == "pubsub" {
+			limit, found = outputLimit{hard: hard, soft: soft, softTime: time.Duration(seconds) * time.Second}, true
+		}
+	}
+	return limit, found, nil
+}
+
+// Parameter of CONFIG GET and CONFIG SET for the subscribers' limit
+var outputLimitConfigParams = []configParam{
+	{
+		name: "client-output-buffer-limit",
+		get: func() string {
+			limit := pubsubOutputLimit()
+			return fmt.Sprintf("pubsub %d %d %d", limit.hard, limit.soft, int64(limit.softTime/time.Second))
+		},
+		set: func(value string) error {
+			limit, found, err := parseOutputLimits(strings.Fields(value))
+			if err != nil || !found {
+				return err
+			}
+			pubsubOutputLimitOverride.Store(&limit)
+			return nil
+		},
+	},
+}
+
+// outbox queues the frames pushed to a subscriber until its flusher writes
+// them, so publishers never wait for a slow connection
+type outbox struct {
+	mu        sync.Mutex
+	frames    []string
+	bytes     int64         // Size of the queued frames
+	writing   int64         // Size of the frames being written
+	softSince time.Time     // When the queue went over the soft limit, zero while under
+	closed    bool          // Set once the connection is dropped or gone: frames are discarded
+	wake      chan struct{} // Signals the flusher that frames were queued (buffered)
+	done      chan struct{} // Closed when the connection ends
+}
+
+// startOutbox gives the client a queue for pushed frames and the goroutine
+// flushing it, unless it has one already
+// Callers must hold the broker's write lock.
+func (c *Client) startOutbox() {
+	if c.outbox != nil || c.conn == nil {
+		return
+	}
+	o := &outbox{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	c.outbox = o
+	go func() {
+		for {
+			select {
+			case <-o.wake:
+			case <-o.done:
+				return
+			}
+			c.writeMu.Lock()
+			err := c.flushOutbox()
+			if err == nil {
+				err = c.replies.flush(c.conn)
+			}
+			c.writeMu.Unlock()
+			if err != nil {
+				// The client's goroutine notices the failure when it reads
+				o.discard()
+				return
+			}
+		}
+	}()
+}
+
+// stopOutbox ends the flusher of a client whose connection is going away,
+// closing the connection so a write in progress fails
+func (c *Client) stopOutbox() {
+	if o := c.outbox; o != nil {
+		o.discard()
+		close(o.done)
+		c.conn.Close()
+	}
+}
+
+// enqueue queues frame for the client, dropping the connection if that takes
+// the queue past the subscribers' output buffer limit
+func (c *Client) enqueue(frame string) {
+	o := c.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.frames = append(o.frames, frame)
+	o.bytes += int64(len(frame))
+
+	limit, pending := pubsubOutputLimit(), o.bytes+o.writing
+	over := limit.hard > 0 && pending > limit.hard
+	if limit.soft > 0 && pending > limit.soft {
+		now := clockNow()
+		if o.softSince.IsZero() {
+			o.softSince = now
+		}
+		over = over || now.Sub(o.softSince) >= limit.softTime
+	} else {
+		o.softSince = time.Time{}
+	}
+	if over {
+		o.closed, o.frames, o.bytes = true, nil, 0
+		outputLimitDisconnections.Add(1)
+		fmt.Printf("Client id=%d closed for overcoming of output buffer limits.\n", c.id)
+		c.conn.Close()
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flushOutbox writes the queued frames ahead of anything else the client
+// sends, so they keep the order they were pushed in
+// Callers must hold writeMu.
+func (c *Client) flushOutbox() error {
+	o := c.outbox
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	frames := o.frames
+	o.frames, o.writing, o.bytes = nil, o.bytes, 0
+	o.mu.Unlock()
+	var err error
+	for _, frame := range frames {
+		if err = c.replies.write(c.conn, frame); err != nil {
+			break
+		}
+	}
+	o.mu.Lock()
+	o.writing = 0
+	if o.bytes <= pubsubOutputLimit().soft {
+		o.softSince = time.Time{}
+	}
+	o.mu.Unlock()
+	return err
+}
+
+// discard drops the queued frames and any pushed later
+func (o *outbox) discard() {
+	o.mu.Lock()
+	o.closed, o.frames, o.bytes = true, nil, 0
+	o.mu.Unlock()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriberBuffering(t *testing.T) {
+	saved := broker
+	broker = newPubSubBroker()
+	defer func() { broker = saved }()
+	defer pubsubOutputLimitOverride.Store(nil)
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+
+	publish := func(channel, message string) string {
+		return (&Client{}).execute([]string{"PUBLISH", channel, message})
+	}
+	waitSubscribers := func(channel string, n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for broker.numSub([]string{channel})[0] != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d subscribers of %s, got %d", n, channel, broker.numSub([]string{channel})[0])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// A subscriber over a pipe nobody reads, whose writes never complete
+	slowSubscriber := func(channel string) net.Conn {
+		server, peer := net.Pipe()
+		go handleConnection(server, nil)
+		peer.Write([]byte(formatArray([]string{"SUBSCRIBE", channel})))
+		return peer
+	}
+
+	// Test 1: A subscriber that stops reading blocks neither PUBLISH nor the
+	// other subscribers
+	slow := slowSubscriber("news")
+	defer slow.Close()
+	fast, fastReader := dialPubSub(t)
+	defer fast.Close()
+	expectFrames(t, fast, fastReader, formatPush(false, formatBulkString("subscribe"), formatBulkString("news"), ":1\r\n"), "SUBSCRIBE", "news")
+	waitSubscribers("news", 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if reply := publish("news", "hello"); reply != ":2\r\n" {
+				t.Errorf("expected two receivers, got %q", reply)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected PUBLISH not to wait for the slow subscriber")
+	}
+	for i := 0; i < 100; i++ {
+		expectFrames(t, fast, fastReader, formatArray([]string{"message", "news", "hello"}))
+	}
+
+	// Test 2: Going past the hard limit drops the subscriber
+	pubsubOutputLimitOverride.Store(&outputLimit{hard: 3000})
+	before := outputLimitDisconnections.Load()
+	publish("news", "past the limit")
+	waitSubscribers("news", 1)
+	if n := outputLimitDisconnections.Load() - before; n != 1 {
+		t.Fatalf("expected one disconnection, got %d", n)
+	}
+
+	// Test 3: Staying past the soft limit for its duration drops the subscriber
+	pubsubOutputLimitOverride.Store(&outputLimit{soft: 500, softTime: 10 * time.Second})
+	slow = slowSubscriber("sport")
+	defer slow.Close()
+	waitSubscribers("sport", 1)
+	publish("sport", strings.Repeat("x", 600))
+	vc.Advance(5 * time.Second)
+	publish("sport", "goal")
+	if n := broker.numSub([]string{"sport"})[0]; n != 1 {
+		t.Fatalf("expected the subscriber to be kept within the soft time, got %d subscribers", n)
+	}
+	vc.Advance(5 * time.Second)
+	publish("sport", "goal")
+	waitSubscribers("sport", 0)
+
+	// Test 4: The limit is configured like Redis's pubsub class
+	if reply := (&Client{}).execute([]string{"CONFIG", "SET", "client-output-buffer-limit", "normal 0 0 0 pubsub 1mb 256kb 30"}); reply != "+OK\r\n" {
+		t.Fatalf("unexpected CONFIG SET reply %q", reply)
+	}
+	if limit := pubsubOutputLimit(); limit != (outputLimit{hard: 1 << 20, soft: 256 << 10, softTime: 30 * time.Second}) {
+		t.Fatalf("unexpected limit %+v", limit)
+	}
+	if reply := (&Client{}).execute([]string{"CONFIG", "GET", "client-output-buffer-limit"}); !strings.Contains(reply, "pubsub 1048576 262144 30") {
+		t.Fatalf("unexpected CONFIG GET reply %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"CONFIG", "SET", "client-output-buffer-limit", "pubsub 1mb"}); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("expected an incomplete limit to be refused, got %q", reply)
+	}
+	cfg, err := ParseConfig(strings.NewReader("client-output-buffer-limit pubsub 64mb 16mb 90\n"))
+	if err != nil || cfg.PubSubOutputLimit != (outputLimit{hard: 64 << 20, soft: 16 << 20, softTime: 90 * time.Second}) {
+		t.Fatalf("unexpected parsed limit %+v (%v)", cfg.PubSubOutputLimit, err)
+	}
+	if _, err := ParseConfig(strings.NewReader("client-output-buffer-limit other 1 1 1\n")); err == nil {
+		t.Fatalf("expected an unknown class to be refused")
+	}
+}
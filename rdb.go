@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RDB format version written into DUMP payloads.
+// Version 9 is understood by every Redis release since 5.0.
+const rdbVersion = 9
+
+// RDB format version of RDB files, that of Redis 7.2, whose stream type
+// they use
+const rdbFileVersion = 11
+
+// Highest RDB format version accepted from other servers (Redis 7.2)
+const rdbMaxVersion = 11
+
+// RDB value type bytes
+const (
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeHash   = 4
+	rdbTypeZset2  = 5 // Scores as binary doubles
+)
+
+// RDB file opcodes, which share the byte with value types
+const (
+	rdbOpFunction2     = 0xF5 // Code of a function library
+	rdbOpFunctionPreGA = 0xF6 // Function written by Redis 7.0 release candidates
+	rdbOpModuleAux     = 0xF7 // Data of a module not attached to a key
+	rdbOpIdle          = 0xF8
+	rdbOpFreq          = 0xF9
+	rdbOpAux           = 0xFA
+	rdbOpResizeDB      = 0xFB
+	rdbOpExpireMS      = 0xFC
+	rdbOpExpire        = 0xFD
+	rdbOpSelectDB      = 0xFE
+	rdbOpEOF           = 0xFF
+)
+
+// RDB length encoding markers (two most significant bits of the first byte)
+const (
+	rdbLen6Bit   = 0
+	rdbLen14Bit  = 1
+	rdbLen32Bit  = 0x80
+	rdbLen64Bit  = 0x81
+	rdbEncodeVal = 3
+)
+
+// Special string encodings used when the top two bits are rdbEncodeVal
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var errRDBFormat = errors.New("invalid RDB encoding")
+
+// Strings up to this length are never compressed, as in Redis
+const rdbCompressMinLen = 20
+
+// rdbcompression set by CONFIG SET, overriding the configuration file's
+var rdbCompressionOverride atomic.Pointer[bool]
+
+// rdbCompression returns whether strings are written LZF compressed
+func rdbCompression() bool {
+	if override := rdbCompressionOverride.Load(); override != nil {
+		return *override
+	}
+	return config.RDBCompression
+}
+
+// crc64Table implements the CRC-64/Jones variant Redis uses for DUMP payloads
+// and RDB files (reflected, polynomial 0xad93d23594c935a9, no final xor)
+var crc64Table = crc64.MakeTable(bits.Reverse64(0xad93d23594c935a9))
+
+// crc64Jones computes the Redis CRC64 of data, continuing from crc
+// The hash/crc64 package inverts the value on entry and exit, which Redis does not
+func crc64Jones(crc uint64, data []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, data)
+}
+
+// rdbEncoder builds RDB-encoded data in memory
+type rdbEncoder struct {
+	buf []byte
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+// writeLength writes a length using the smallest RDB length encoding
+func (e *rdbEncoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.buf = append(e.buf, byte(n)|rdbLen6Bit<<6)
+	case n < 1<<14:
+		e.buf = append(e.buf, byte(n>>8)|rdbLen14Bit<<6, byte(n))
+	case n <= 0xFFFFFFFF:
+		e.buf = append(e.buf, rdbLen32Bit)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, rdbLen64Bit)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+// writeString writes a string, using the compact integer encoding when the
+// string is the canonical form of a small integer, and LZF compression when
+// it saves at least 4 bytes of a longer string (as Redis does)
+func (e *rdbEncoder) writeString(s string) {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+			switch {
+			case v >= -1<<7 && v < 1<<7:
+				e.buf = append(e.buf, rdbEncodeVal<<6|rdbEncInt8, byte(int8(v)))
+				return
+			case v >= -1<<15 && v < 1<<15:
+				e.buf = append(e.buf, rdbEncodeVal<<6|rdbEncInt16)
+				e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(int16(v)))
+				return
+			case v >= -1<<31 && v < 1<<31:
+				e.buf = append(e.buf, rdbEncodeVal<<6|rdbEncInt32)
+				e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(int32(v)))
+				return
+			}
+		}
+	}
+	if len(s) > rdbCompressMinLen && rdbCompression() {
+		if compressed := lzfCompress([]byte(s), len(s)-4); compressed != nil {
+			e.writeByte(rdbEncodeVal<<6 | rdbEncLZF)
+			e.writeLength(uint64(len(compressed)))
+			e.writeLength(uint64(len(s)))
+			e.buf = append(e.buf, compressed...)
+			return
+		}
+	}
+	e.writeLength(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// rdbValueTypes maps data types to the RDB value type they are written as
+var rdbValueTypes = map[string]byte{
+	TypeString:    rdbTypeString,
+	TypeList:      rdbTypeList,
+	TypeSet:       rdbTypeSet,
+	TypeHash:      rdbTypeHash,
+	TypeSortedSet: rdbTypeZset2,
+	TypeStream:    rdbTypeStreamListpacks3,
+}
+
+// writeValue writes the type byte and encoded value of an entry
+func (e *rdbEncoder) writeValue(entry *Entry) error {
+	rdbType, ok := rdbValueTypes[entry.Type]
+	if !ok {
+		return errors.New("type " + entry.Type + " cannot be serialized")
+	}
+	e.writeByte(rdbType)
+	e.writeObject(entry)
+	return nil
+}
+
+// writeObject writes the encoded value of an entry of a type in rdbValueTypes
+func (e *rdbEncoder) writeObject(entry *Entry) {
+	switch entry.Type {
+	case TypeString:
+		e.writeString(entry.Value.(string))
+	case TypeList:
+		values := entry.Value.(listValue).values()
+		e.writeLength(uint64(len(values)))
+		for _, v := range values {
+			e.writeString(v)
+		}
+	case TypeSet:
+		members := setMembers(asSet(entry.Value))
+		e.writeLength(uint64(len(members)))
+		for _, member := range members {
+			e.writeString(member)
+		}
+	case TypeHash:
+		hash := asHash(entry.Value)
+		e.writeLength(uint64(hash.len()))
+		hash.each(func(field, value string) bool {
+			e.writeString(field)
+			e.writeString(value)
+			return true
+		})
+	case TypeSortedSet:
+		zs := entry.Value.(*zset)
+		e.writeLength(uint64(zs.len()))
+		zs.each(func(member string, score float64) bool {
+			e.writeString(member)
+			e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(score))
+			return true
+		})
+	case TypeStream:
+		e.writeStream(entry.Value.(*stream))
+	}
+}
+
+// rdbDecoder reads RDB-encoded data
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64 // CRC64 of everything read so far
+}
+
+func newRDBDecoder(r io.Reader) *rdbDecoder {
+	return &rdbDecoder{r: bufio.NewReader(r)}
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.crc = crc64Jones(d.crc, []byte{b})
+	}
+	return b, err
+}
+
+// readFull reads n bytes, in chunks of at most rdbReadChunk so that a
+// corrupt length cannot allocate more than the data holds
+func (d *rdbDecoder) readFull(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errRDBFormat
+	}
+	buf := make([]byte, 0, min(n, rdbReadChunk))
+	for len(buf) < n {
+		chunk := min(n-len(buf), rdbReadChunk)
+		buf = append(buf, make([]byte, chunk)...)
+		read, err := io.ReadFull(d.r, buf[len(buf)-chunk:])
+		buf = buf[:len(buf)-chunk+read]
+		if err != nil {
+			d.crc = crc64Jones(d.crc, buf)
+			return buf, err
+		}
+	}
+	d.crc = crc64Jones(d.crc, buf)
+	return buf, nil
+}
+
+// Largest read allocated at once
+const rdbReadChunk = 1 << 20
+
+// readLength reads a length; encoded reports that the value is a special
+// string encoding number rather than a length
+func (d *rdbDecoder) readLength() (n uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case rdbLen6Bit:
+		return uint64(first & 0x3F), false, nil
+	case rdbLen14Bit:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case rdbEncodeVal:
+		return uint64(first & 0x3F), true, nil
+	}
+	switch first {
+	case rdbLen32Bit:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case rdbLen64Bit:
+		buf, err := d.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, errRDBFormat
+}
+
+// readPlainLength reads a length that must not be a special encoding
+func (d *rdbDecoder) readPlainLength() (uint64, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, errRDBFormat
+	}
+	return n, nil
+}
+
+// readString reads a string in any of the RDB string encodings
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.readFull(int(n))
+		return string(buf), err
+	}
+
+	switch n {
+	case rdbEncInt8:
+		b, err := d.readByte()
+		return strconv.FormatInt(int64(int8(b)), 10), err
+	case rdbEncInt16:
+		buf, err := d.readFull(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf))), 10), nil
+	case rdbEncInt32:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf))), 10), nil
+	case rdbEncLZF:
+		compressedLen, err := d.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		size, err := d.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		compressed, err := d.readFull(int(compressedLen))
+		if err != nil {
+			return "", err
+		}
+		buf, err := lzfDecompress(compressed, int(size))
+		return string(buf), err
+	}
+	return "", errors.New("unsupported RDB string encoding")
+}
+
+// readValue reads an encoded value of the given RDB type
+func (d *rdbDecoder) readValue(rdbType byte) (*Entry, error) {
+	switch rdbType {
+	case rdbTypeString:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{Type: TypeString, Value: s}, nil
+	case rdbTypeList:
+		values, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{Type: TypeList, Value: newDeque(values...)}, nil
+	case rdbTypeSet:
+		members, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return setEntry(members)
+	case rdbTypeHash:
+		pairs, err := d.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return hashEntry(pairs)
+	case rdbTypeZset2:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errRDBFormat
+		}
+		zs := newZset(int(min(n, rdbPreallocMax)))
+		for range n {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			buf, err := d.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			score := math.Float64frombits(binary.LittleEndian.Uint64(buf))
+			if _, dup := zs.score(member); dup || math.IsNaN(score) {
+				return nil, errRDBFormat
+			}
+			zs.set(member, score)
+		}
+		return &Entry{Type: TypeSortedSet, Value: zs}, nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		st, err := d.readStream(rdbType)
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{Type: TypeStream, Value: st}, nil
+	}
+	return d.readCompactValue(rdbType)
+}
+
+// Most elements allocated ahead from a length read from the data, which
+// could be corrupt
+const rdbPreallocMax = 1024
+
+// readStrings reads a non-empty collection of strings preceded by its
+// length, in groups of size strings per element
+func (d *rdbDecoder) readStrings(size int) ([]string, error) {
+	n, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	// Redis never writes empty collections
+	if n == 0 || n > math.MaxInt/uint64(size) {
+		return nil, errRDBFormat
+	}
+	values := make([]string, 0, min(n*uint64(size), rdbPreallocMax))
+	for range n * uint64(size) {
+		v, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Buffered bytes after which an RDB file stream is flushed
+const rdbFlushSize = 64 << 10
+
+// rdbFileWriter streams an RDB file, keeping the checksum of what it wrote
+type rdbFileWriter struct {
+	w   io.Writer
+	enc rdbEncoder
+	crc uint64
+}
+
+func (fw *rdbFileWriter) flush() error {
+	fw.crc = crc64Jones(fw.crc, fw.enc.buf)
+	_, err := fw.w.Write(fw.enc.buf)
+	fw.enc.buf = fw.enc.buf[:0]
+	return err
+}
+
+// writeRDB writes the function libraries and the keys of dbs as an RDB file
+// with their TTLs and LRU/LFU metadata, from a snapshot so that writers are
+// only held up while it is taken
+func writeRDB(w io.Writer, dbs *Databases) error {
+	snap := takeSnapshot(dbs)
+	defer snap.release()
+	return snap.write(w, nil)
+}
+
+// rdbSnapshot is the dataset as it was at one point in time, which an RDB
+// file is written from while writers go on
+type rdbSnapshot struct {
+	libraries []*functionLibrary
+	dbs       [][]rdbItem // Live keys of each database, by index
+	keys      int
+	changes   int64 // keyChanges when the snapshot was taken
+	aofBase   bool  // Written as the RDB preamble of an append-only file
+}
+
+// rdbItem is a key of a snapshot
+type rdbItem struct {
+	key   string
+	entry *Entry      // Type, value and TTL of the key
+	idle  uint64      // LRU idle time in seconds
+	freq  byte        // LFU counter
+	share *valueShare // Borrow of the value, nil for strings
+}
+
+// takeSnapshot records every live key of dbs. The databases are collected
+// one at a time, each under its read lock only, so writers to the others are
+// not held up meanwhile; MOVE and COPY between databases wait for the end of
+// the snapshot instead. Values are borrowed rather than copied, writers
+// copying a collection before changing it (see own).
+// The snapshot must be released once written.
+func takeSnapshot(dbs *Databases) *rdbSnapshot {
+	snap := &rdbSnapshot{libraries: libraries.list()}
+	dbs.mu.RLock()
+	defer dbs.mu.RUnlock()
+	dbs.crossMu.Lock()
+	defer dbs.crossMu.Unlock()
+	// Read first: writes made while the databases are collected count as
+	// unsaved even if the snapshot has them
+	snap.changes = keyChanges.Load()
+	for _, s := range dbs.dbs {
+		s.mu.RLock()
+		now := clockNow()
+		items := make([]rdbItem, 0, s.data.len())
+		s.data.forEach(func(key string, entry *Entry) bool {
+			if entry.expired(now) {
+				return true
+			}
+			item := rdbItem{
+				key:   key,
+				entry: &Entry{Type: entry.Type, Value: entry.Value, ExpiresAt: entry.ExpiresAt},
+				idle:  uint64(max(entry.idleTime(now)/time.Second, 0)),
+				freq:  byte(entry.decayedFrequency(entry.lastAccess.Load(), now)),
+			}
+			if entry.Type != TypeString {
+				item.share = entry.borrow()
+			}
+			items = append(items, item)
+			return true
+		})
+		s.mu.RUnlock()
+		snap.dbs = append(snap.dbs, items)
+		snap.keys += len(items)
+	}
+	return snap
+}
+
+// release ends the borrows of the snapshot's values
+func (snap *rdbSnapshot) release() {
+	for _, items := range snap.dbs {
+		for _, item := range items {
+			if item.share != nil {
+				item.share.release()
+			}
+		}
+	}
+}
+
+// write writes the snapshot as an RDB file, adding every key written to
+// written if not nil
+func (snap *rdbSnapshot) write(w io.Writer, written *atomic.Int64) error {
+	fw := &rdbFileWriter{w: w}
+	enc := &fw.enc
+	enc.buf = fmt.Appendf(enc.buf, "REDIS%04d", rdbFileVersion)
+	aofBase := "0"
+	if snap.aofBase {
+		aofBase = "1"
+	}
+	for _, aux := range [][2]string{
+		{"redis-ver", serverVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"aof-base", aofBase},
+	} {
+		enc.writeByte(rdbOpAux)
+		enc.writeString(aux[0])
+		enc.writeString(aux[1])
+	}
+	for _, lib := range snap.libraries {
+		enc.writeByte(rdbOpFunction2)
+		enc.writeString(lib.code)
+	}
+	for db, items := range snap.dbs {
+		if len(items) == 0 {
+			continue
+		}
+		expires := 0
+		for _, item := range items {
+			if !item.entry.ExpiresAt.IsZero() {
+				expires++
+			}
+		}
+		enc.writeByte(rdbOpSelectDB)
+		enc.writeLength(uint64(db))
+		enc.writeByte(rdbOpResizeDB)
+		enc.writeLength(uint64(len(items)))
+		enc.writeLength(uint64(expires))
+
+		for _, item := range items {
+			if !item.entry.ExpiresAt.IsZero() {
+				enc.writeByte(rdbOpExpireMS)
+				enc.buf = binary.LittleEndian.AppendUint64(enc.buf, uint64(item.entry.ExpiresAt.UnixMilli()))
+			}
+			enc.writeByte(rdbOpIdle)
+			enc.writeLength(item.idle)
+			enc.writeByte(rdbOpFreq)
+			enc.writeByte(item.freq)
+			rdbType, ok := rdbValueTypes[item.entry.Type]
+			if !ok {
+				return errors.New("type " + item.entry.Type + " cannot be serialized")
+			}
+			enc.writeByte(rdbType)
+			enc.writeString(item.key)
+			enc.writeObject(item.entry)
+			if written != nil {
+				written.Add(1)
+			}
+			if len(enc.buf) >= rdbFlushSize {
+				if err := fw.flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	enc.writeByte(rdbOpEOF)
+	if err := fw.flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint64(nil, fw.crc))
+	return err
+}
+
+// readRDB reads an RDB file, calling load with every key that has not
+// expired and loadLibrary with the code of every function library. Values
+// are understood in every encoding Redis up to 7.2 writes, but not module data.
+// Returns the number of keys skipped as expired.
+func readRDB(r io.Reader, load func(db int, key string, entry *Entry) error, loadLibrary func(code string) error) (int, error) {
+	dec := newRDBDecoder(r)
+	header, err := dec.readFull(9)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if string(header[:5]) != "REDIS" || err != nil || version < 1 || version > rdbMaxVersion {
+		return 0, errors.New("not an RDB file or unsupported RDB version")
+	}
+
+	db, expired := 0, 0
+	var expiresAt time.Time
+	idle, freq := int64(-1), -1
+	now := clockNow()
+	for {
+		op, err := dec.readByte()
+		if err != nil {
+			return expired, err
+		}
+		switch op {
+		case rdbOpEOF:
+			expected := dec.crc
+			buf, err := dec.readFull(8)
+			if err != nil {
+				return expired, err
+			}
+			// A zero checksum means the writer did not compute one
+			if checksum := binary.LittleEndian.Uint64(buf); checksum != 0 && checksum != expected {
+				return expired, errors.New("RDB checksum mismatch")
+			}
+			return expired, nil
+		case rdbOpSelectDB:
+			n, err := dec.readPlainLength()
+			if err != nil {
+				return expired, err
+			}
+			db = int(n)
+		case rdbOpResizeDB:
+			for range 2 {
+				if _, err := dec.readPlainLength(); err != nil {
+					return expired, err
+				}
+			}
+		case rdbOpFunction2:
+			code, err := dec.readString()
+			if err != nil {
+				return expired, err
+			}
+			if err := loadLibrary(code); err != nil {
+				return expired, fmt.Errorf("function library: %v", err)
+			}
+		case rdbOpModuleAux:
+			return expired, errModuleData
+		case rdbOpFunctionPreGA:
+			return expired, errors.New("functions of Redis 7.0 release candidates are not supported")
+		case rdbOpAux:
+			for range 2 {
+				if _, err := dec.readString(); err != nil {
+					return expired, err
+				}
+			}
+		case rdbOpExpireMS:
+			buf, err := dec.readFull(8)
+			if err != nil {
+				return expired, err
+			}
+			expiresAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(buf)))
+		case rdbOpExpire:
+			buf, err := dec.readFull(4)
+			if err != nil {
+				return expired, err
+			}
+			expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(buf)), 0)
+		case rdbOpIdle:
+			n, err := dec.readPlainLength()
+			if err != nil {
+				return expired, err
+			}
+			idle = int64(min(n, math.MaxInt32))
+		case rdbOpFreq:
+			b, err := dec.readByte()
+			if err != nil {
+				return expired, err
+			}
+			freq = int(b)
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return expired, err
+			}
+			entry, err := dec.readValue(op)
+			if err != nil {
+				return expired, fmt.Errorf("key '%s': %v", key, err)
+			}
+			entry.ExpiresAt = expiresAt
+			if idle >= 0 || freq >= 0 {
+				entry.lastAccess.Store(now.Add(-time.Duration(max(idle, 0)) * time.Second).UnixNano())
+				entry.frequency.Store(lfuInitValue)
+				if freq >= 0 {
+					entry.frequency.Store(uint32(freq))
+				}
+			}
+			if entry.expired(now) {
+				expired++
+			} else if err := load(db, key, entry); err != nil {
+				return expired, err
+			}
+			expiresAt, idle, freq = time.Time{}, -1, -1
+		}
+	}
+}
+
+// Load stores an entry read from an RDB file under key, in the encoding the
+// store's limits call for. Unlike Restore, it neither checks for an existing
+// key nor applies default TTLs, as the key is carried over as it was.
+func (s *Store) Load(key string, entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compact(entry)
+	s.data.set(key, entry)
+	s.keyModified(key)
+	s.armExpireTimer(key, entry)
+}
+
+// compact gives a decoded value the most compact encoding allowed, as values
+// are decoded into their general forms
+// Callers must hold the write lock.
+func (s *Store) compact(entry *Entry) {
+	switch v := entry.Value.(type) {
+	case listValue:
+		entry.Value = s.encodeList(v)
+	case map[string]struct{}:
+		entry.Value = setStorage(s.encodeSet(v))
+	case map[string]string:
+		entry.Value = hashStorage(s.encodeHash(v))
+	case *zset:
+		if !v.isPacked() {
+			entry.Value = s.encodeZset(v.dict)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// RDB value types Redis writes for values in their compact encodings, which
+// are read into the general forms before being compacted again
+const (
+	rdbTypeZset           = 3 // Scores as strings
+	rdbTypeModule         = 6
+	rdbTypeModule2        = 7
+	rdbTypeHashZipmap     = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZsetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeHashListpack   = 16
+	rdbTypeZsetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20
+)
+
+// Containers of quicklist2 nodes
+const (
+	rdbQuicklistNodePlain  = 1 // One large element, as is
+	rdbQuicklistNodePacked = 2 // A listpack
+)
+
+// Length bytes standing for special scores in rdbTypeZset
+const (
+	rdbScoreNaN    = 253
+	rdbScorePosInf = 254
+	rdbScoreNegInf = 255
+)
+
+const (
+	ziplistHeaderSize = 10 // Total bytes, tail offset (uint32 each) and entry count (uint16)
+	ziplistBigPrevLen = 254
+	ziplistEnd        = 0xFF
+	zipmapBigLen      = 254
+	zipmapEnd         = 0xFF
+	intsetHeaderSize  = 8 // Encoding and length (uint32 each)
+)
+
+var (
+	errModuleData    = errors.New("module data is not supported")
+	errZiplistFormat = errors.New("invalid ziplist encoding")
+	errZipmapFormat  = errors.New("invalid zipmap encoding")
+	errIntsetFormat  = errors.New("invalid intset encoding")
+)
+
+// readCompactValue reads a value of one of the compact RDB types
+func (d *rdbDecoder) readCompactValue(rdbType byte) (*Entry, error) {
+	switch rdbType {
+	case rdbTypeZset:
+		return d.readZsetStringScores()
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return d.readQuicklist(rdbType)
+	case rdbTypeModule, rdbTypeModule2:
+		return nil, errModuleData
+	}
+
+	blob, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(blob)
+	var values []string
+	switch rdbType {
+	case rdbTypeHashZipmap:
+		values, err = decodeZipmap(data)
+	case rdbTypeSetIntset:
+		values, err = decodeIntset(data)
+	case rdbTypeListZiplist, rdbTypeZsetZiplist, rdbTypeHashZiplist:
+		values, err = decodeZiplist(data)
+	case rdbTypeHashListpack, rdbTypeZsetListpack, rdbTypeSetListpack:
+		values, err = decodeRDBListpack(data)
+	default:
+		return nil, errors.New("unsupported RDB value type " + strconv.Itoa(int(rdbType)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Redis never writes empty collections
+	if len(values) == 0 {
+		return nil, errRDBFormat
+	}
+
+	switch rdbType {
+	case rdbTypeListZiplist:
+		return &Entry{Type: TypeList, Value: newDeque(values...)}, nil
+	case rdbTypeSetIntset, rdbTypeSetListpack:
+		return setEntry(values)
+	case rdbTypeHashZipmap, rdbTypeHashZiplist, rdbTypeHashListpack:
+		return hashEntry(values)
+	}
+	return zsetEntry(values)
+}
+
+// setEntry builds a set entry from its members, which must be distinct
+func setEntry(members []string) (*Entry, error) {
+	set := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	if len(set) != len(members) {
+		return nil, errRDBFormat
+	}
+	return &Entry{Type: TypeSet, Value: set}, nil
+}
+
+// hashEntry builds a hash entry from field and value pairs, whose fields
+// must be distinct
+func hashEntry(pairs []string) (*Entry, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errRDBFormat
+	}
+	hash := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+	if len(hash) != len(pairs)/2 {
+		return nil, errRDBFormat
+	}
+	return &Entry{Type: TypeHash, Value: hash}, nil
+}
+
+// zsetEntry builds a sorted set entry from member and score pairs, the
+// scores as strings, whose members must be distinct
+func zsetEntry(pairs []string) (*Entry, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errRDBFormat
+	}
+	zs := newZset(min(len(pairs)/2, rdbPreallocMax))
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if _, dup := zs.score(pairs[i]); err != nil || dup || math.IsNaN(score) {
+			return nil, errRDBFormat
+		}
+		zs.set(pairs[i], score)
+	}
+	return &Entry{Type: TypeSortedSet, Value: zs}, nil
+}
+
+// readZsetStringScores reads a sorted set of the RDB type predating binary
+// scores, each written as a string after a length byte
+func (d *rdbDecoder) readZsetStringScores() (*Entry, error) {
+	n, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errRDBFormat
+	}
+	zs := newZset(int(min(n, rdbPreallocMax)))
+	for range n {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		size, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		score := math.NaN()
+		switch size {
+		case rdbScorePosInf:
+			score = math.Inf(1)
+		case rdbScoreNegInf:
+			score = math.Inf(-1)
+		case rdbScoreNaN:
+		default:
+			buf, err := d.readFull(int(size))
+			if err != nil {
+				return nil, err
+			}
+			if score, err = strconv.ParseFloat(string(buf), 64); err != nil {
+				return nil, errRDBFormat
+			}
+		}
+		if _, dup := zs.score(member); dup || math.IsNaN(score) {
+			return nil, errRDBFormat
+		}
+		zs.set(member, score)
+	}
+	return &Entry{Type: TypeSortedSet, Value: zs}, nil
+}
+
+// readQuicklist reads a list written as quicklist nodes: ziplists, or for
+// quicklist2 either listpacks or single plain elements
+func (d *rdbDecoder) readQuicklist(rdbType byte) (*Entry, error) {
+	n, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for range n {
+		container := uint64(rdbQuicklistNodePacked)
+		if rdbType == rdbTypeListQuicklist2 {
+			if container, err = d.readPlainLength(); err != nil {
+				return nil, err
+			}
+		}
+		node, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case container == rdbQuicklistNodePlain:
+			values = append(values, node)
+			continue
+		case container != rdbQuicklistNodePacked:
+			return nil, errRDBFormat
+		}
+		var elements []string
+		if rdbType == rdbTypeListQuicklist2 {
+			elements, err = decodeRDBListpack([]byte(node))
+		} else {
+			elements, err = decodeZiplist([]byte(node))
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, elements...)
+	}
+	if len(values) == 0 {
+		return nil, errRDBFormat
+	}
+	return &Entry{Type: TypeList, Value: newDeque(values...)}, nil
+}
+
+// decodeIntset decodes a Redis intset, integers as decimal strings
+func decodeIntset(data []byte) ([]string, error) {
+	if len(data) < intsetHeaderSize {
+		return nil, errIntsetFormat
+	}
+	width := int(binary.LittleEndian.Uint32(data))
+	count := int(binary.LittleEndian.Uint32(data[4:]))
+	if (width != 2 && width != 4 && width != 8) || count < 0 || len(data) != intsetHeaderSize+count*width {
+		return nil, errIntsetFormat
+	}
+	values := make([]string, 0, count)
+	for off := intsetHeaderSize; off < len(data); off += width {
+		var n int64
+		switch width {
+		case 2:
+			n = int64(int16(binary.LittleEndian.Uint16(data[off:])))
+		case 4:
+			n = int64(int32(binary.LittleEndian.Uint32(data[off:])))
+		default:
+			n = int64(binary.LittleEndian.Uint64(data[off:]))
+		}
+		values = append(values, strconv.FormatInt(n, 10))
+	}
+	return values, nil
+}
+
+// decodeZiplist decodes a Redis ziplist, the encoding listpacks replaced in
+// Redis 7, integers as decimal strings
+func decodeZiplist(data []byte) ([]string, error) {
+	if len(data) < ziplistHeaderSize+1 || int(binary.LittleEndian.Uint32(data)) != len(data) || data[len(data)-1] != ziplistEnd {
+		return nil, errZiplistFormat
+	}
+	count := int(binary.LittleEndian.Uint16(data[8:]))
+	values := make([]string, 0, min(count, rdbPreallocMax))
+	off := ziplistHeaderSize
+	for data[off] != ziplistEnd {
+		// The length of the previous entry, for reading backwards
+		if data[off] >= ziplistBigPrevLen {
+			off += 4
+		}
+		off++
+		v, size, err := ziplistEntryAt(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off += size
+		values = append(values, v)
+	}
+	// Ziplists count their entries up to 65534, past which they must be walked
+	if count != math.MaxUint16 && count != len(values) {
+		return nil, errZiplistFormat
+	}
+	return values, nil
+}
+
+// ziplistEntryAt decodes the entry whose encoding starts at off
+// Returns its value and the size of its encoding and data
+func ziplistEntryAt(data []byte, off int) (string, int, error) {
+	need := func(n int) bool { return off+n <= len(data)-1 }
+	if !need(1) {
+		return "", 0, errZiplistFormat
+	}
+	b := data[off]
+	var n int64
+	var size int
+	switch {
+	case b>>6 == 0:
+		length := int(b & 0x3F)
+		if !need(1 + length) {
+			return "", 0, errZiplistFormat
+		}
+		return string(data[off+1 : off+1+length]), 1 + length, nil
+	case b>>6 == 1:
+		if !need(2) {
+			return "", 0, errZiplistFormat
+		}
+		length := int(b&0x3F)<<8 | int(data[off+1])
+		if !need(2 + length) {
+			return "", 0, errZiplistFormat
+		}
+		return string(data[off+2 : off+2+length]), 2 + length, nil
+	case b == 0x80:
+		if !need(5) {
+			return "", 0, errZiplistFormat
+		}
+		length := int(binary.BigEndian.Uint32(data[off+1:]))
+		if length < 0 || !need(5+length) {
+			return "", 0, errZiplistFormat
+		}
+		return string(data[off+5 : off+5+length]), 5 + length, nil
+	case b == 0xC0:
+		if !need(3) {
+			return "", 0, errZiplistFormat
+		}
+		n, size = int64(int16(binary.LittleEndian.Uint16(data[off+1:]))), 3
+	case b == 0xD0:
+		if !need(5) {
+			return "", 0, errZiplistFormat
+		}
+		n, size = int64(int32(binary.LittleEndian.Uint32(data[off+1:]))), 5
+	case b == 0xE0:
+		if !need(9) {
+			return "", 0, errZiplistFormat
+		}
+		n, size = int64(binary.LittleEndian.Uint64(data[off+1:])), 9
+	case b == 0xF0:
+		if !need(4) {
+			return "", 0, errZiplistFormat
+		}
+		u := int32(data[off+1]) | int32(data[off+2])<<8 | int32(data[off+3])<<16
+		n, size = int64(u<<8>>8), 4
+	case b == 0xFE:
+		if !need(2) {
+			return "", 0, errZiplistFormat
+		}
+		n, size = int64(int8(data[off+1])), 2
+	case b >= 0xF1 && b <= 0xFD:
+		// 4-bit immediates 1 to 13 stand for 0 to 12
+		n, size = int64(b&0x0F)-1, 1
+	default:
+		return "", 0, errZiplistFormat
+	}
+	return strconv.FormatInt(n, 10), size, nil
+}
+
+// decodeZipmap decodes a Redis zipmap, the hash encoding Redis used before
+// ziplists, as field and value pairs
+func decodeZipmap(data []byte) ([]string, error) {
+	if len(data) < 2 || data[len(data)-1] != zipmapEnd {
+		return nil, errZipmapFormat
+	}
+	// A length just before the end, or at it for the last value's free bytes
+	readLen := func(off int) (int, int, error) {
+		if data[off] < zipmapBigLen {
+			return int(data[off]), off + 1, nil
+		}
+		if data[off] != zipmapBigLen || off+5 > len(data)-1 {
+			return 0, 0, errZipmapFormat
+		}
+		return int(binary.LittleEndian.Uint32(data[off+1:])), off + 5, nil
+	}
+	var values []string
+	off := 1 // The entry count, which saturates at 254
+	for data[off] != zipmapEnd {
+		n, next, err := readLen(off)
+		if err != nil || n < 0 || next+n > len(data)-1 {
+			return nil, errZipmapFormat
+		}
+		field := string(data[next : next+n])
+		if n, next, err = readLen(next + n); err != nil || n < 0 || next+1+n > len(data)-1 {
+			return nil, errZipmapFormat
+		}
+		free := int(data[next])
+		next++
+		if next+n+free > len(data)-1 {
+			return nil, errZipmapFormat
+		}
+		values = append(values, field, string(data[next:next+n]))
+		off = next + n + free
+	}
+	return values, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rdbBlob encodes data as an RDB string of its length, as Redis writes the
+// ziplists, listpacks and intsets of compact values
+func rdbBlob(data string) string {
+	enc := &rdbEncoder{}
+	enc.writeLength(uint64(len(data)))
+	return string(enc.buf) + data
+}
+
+func TestRDBCompactEncodings(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	listZiplist := "\x1b\x00\x00\x00\x13\x00\x00\x00\x04\x00" +
+		"\x00\x01a" + "\x03\xf2" + "\x02\xc0\x2c\x01" + "\x04\x05hello" + "\xff"
+	client := &Client{}
+
+	// Test 1: Values in the encodings of Redis releases up to 7.2 restore,
+	// checked with commands reading them back
+	for _, tc := range []struct {
+		name  string
+		body  string
+		read  []string
+		reply string
+	}{
+		{"intset", "\x0b" + rdbBlob("\x02\x00\x00\x00\x03\x00\x00\x00\x01\x00\x02\x00\xff\xff"),
+			[]string{"SMISMEMBER", "intset", "1", "2", "-1", "3"}, "*4\r\n:1\r\n:1\r\n:1\r\n:0\r\n"},
+		{"ziplist", "\x0a" + rdbBlob(listZiplist),
+			[]string{"LRANGE", "ziplist", "0", "-1"}, "*4\r\n$1\r\na\r\n$1\r\n1\r\n$3\r\n300\r\n$5\r\nhello\r\n"},
+		{"zlhash", "\x0d" + rdbBlob("\x13\x00\x00\x00\x0e\x00\x00\x00\x02\x00"+"\x00\x02f1"+"\x04\x02v1"+"\xff"),
+			[]string{"HGET", "zlhash", "f1"}, "$2\r\nv1\r\n"},
+		{"zlzset", "\x0c" + rdbBlob("\x18\x00\x00\x00\x15\x00\x00\x00\x04\x00"+"\x00\x01m"+"\x03\x031.5"+"\x05\x01n"+"\x03\xf3"+"\xff"),
+			[]string{"ZRANGE", "zlzset", "0", "-1", "WITHSCORES"}, "*4\r\n$1\r\nm\r\n$3\r\n1.5\r\n$1\r\nn\r\n$1\r\n2\r\n"},
+		{"zipmap", "\x09" + rdbBlob("\x01\x01f\x03\x00bar\xff"),
+			[]string{"HGET", "zipmap", "f"}, "$3\r\nbar\r\n"},
+		{"quicklist", "\x0e\x01" + rdbBlob(listZiplist),
+			[]string{"LINDEX", "quicklist", "3"}, "$5\r\nhello\r\n"},
+		{"quicklist2", "\x12\x02" + "\x02" + rdbBlob("\x0c\x00\x00\x00\x02\x00"+"\x81x\x02"+"\x07\x01"+"\xff") + "\x01" + rdbBlob("big"),
+			[]string{"LRANGE", "quicklist2", "0", "-1"}, "*3\r\n$1\r\nx\r\n$1\r\n7\r\n$3\r\nbig\r\n"},
+		{"lpset", "\x14" + rdbBlob("\x0d\x00\x00\x00\x02\x00"+"\x81a\x02"+"\x81b\x02"+"\xff"),
+			[]string{"SCARD", "lpset"}, ":2\r\n"},
+		{"lphash", "\x10" + rdbBlob("\x0d\x00\x00\x00\x02\x00"+"\x81f\x02"+"\x81v\x02"+"\xff"),
+			[]string{"HGET", "lphash", "f"}, "$1\r\nv\r\n"},
+		{"lpzset", "\x11" + rdbBlob("\x0f\x00\x00\x00\x02\x00"+"\x81m\x02"+"\x831.5\x04"+"\xff"),
+			[]string{"ZSCORE", "lpzset", "m"}, "$3\r\n1.5\r\n"},
+		{"oldzset", "\x03\x02" + "\x01m\x032.5" + "\x01i\xfe",
+			[]string{"ZRANGE", "oldzset", "0", "-1", "WITHSCORES"}, "*4\r\n$1\r\nm\r\n$3\r\n2.5\r\n$1\r\ni\r\n$3\r\ninf\r\n"},
+		{"lzf", "\x00\xc3\x08\x1e" + "\x00a\xe0\x12\x00\x01aa",
+			[]string{"GET", "lzf"}, formatBulkString(strings.Repeat("a", 30))},
+	} {
+		payload := string(appendDumpFooter([]byte(tc.body)))
+		if reply := client.execute([]string{"RESTORE", tc.name, "0", payload}); reply != "+OK\r\n" {
+			t.Fatalf("expected the %s payload to restore, got %q", tc.name, reply)
+		}
+		if reply := client.execute(tc.read); reply != tc.reply {
+			t.Fatalf("expected %v to reply %q, got %q", tc.read, tc.reply, reply)
+		}
+	}
+
+	// Test 2: Corrupt or unsupported compact values are refused
+	for _, body := range []string{
+		"\x0b" + rdbBlob("\x03\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00"),   // Intset of 3-byte integers
+		"\x0a" + rdbBlob("\x0b\x00\x00\x00\x0a\x00\x00\x00\x01\x00\xff"),   // Ziplist counting a missing entry
+		"\x0d" + rdbBlob(listZiplist[:len(listZiplist)-3]+"\xff"),          // Truncated ziplist
+		"\x14" + rdbBlob("\x0d\x00\x00\x00\x02\x00\x81a\x02\x81a\x02\xff"), // Duplicate set members
+		"\x07\x00",                      // Module value
+		"\x00\xc3\x04\x1e\x00a\xe0\x12", // Truncated LZF string
+		"\x12\x01\x03" + rdbBlob("x"),   // Unknown quicklist2 container
+	} {
+		payload := string(appendDumpFooter([]byte(body)))
+		if reply := client.execute([]string{"RESTORE", "bad", "0", payload}); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("expected %q to be refused, got %q", body, reply)
+		}
+	}
+}
+
+func TestRDBFileFormat(t *testing.T) {
+	saved, savedSaves, savedDir := databases, saves, dirOverride.Load()
+	databases = NewDatabases(1)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+		rdbCompressionOverride.Store(nil)
+	}()
+	dir := t.TempDir()
+	dirOverride.Store(&dir)
+	client := &Client{}
+	long := strings.Repeat("compressible ", 20)
+
+	// Test 1: Dump files are RDB 11 files with Redis's aux fields, long
+	// strings LZF compressed
+	client.execute([]string{"SET", "long", long})
+	client.execute([]string{"SAVE"})
+	data, err := os.ReadFile(filepath.Join(dir, defaultDBFilename))
+	if err != nil {
+		t.Fatalf("expected the dump file to exist: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("REDIS0011\xfa\x09redis-ver")) {
+		t.Fatalf("unexpected dump file header %q", data[:min(len(data), 20)])
+	}
+	if bytes.Contains(data, []byte(long)) || len(data) > len(long) {
+		t.Fatalf("expected the long string to be compressed, got %d bytes", len(data))
+	}
+	loaded := loadDumpFile(t, filepath.Join(dir, defaultDBFilename), 1)
+	if value, _, _ := loaded.Get(0).Get("long"); value != long {
+		t.Fatalf("expected the long string to load back, got %q", value)
+	}
+
+	// Test 2: rdbcompression no writes strings as they are
+	if reply := client.execute([]string{"CONFIG", "SET", "rdbcompression", "no"}); reply != "+OK\r\n" {
+		t.Fatalf("expected CONFIG SET rdbcompression to succeed, got %q", reply)
+	}
+	payload, _, _ := databases.Get(0).Dump("long")
+	if !strings.Contains(payload, long) {
+		t.Fatalf("expected an uncompressed payload")
+	}
+
+	// Test 3: A file as Redis 7.2 writes it loads, its aux fields skipped and
+	// expired keys dropped; module data fails the load
+	file := []byte("REDIS0011" +
+		"\xfa\x09redis-ver\x057.2.4" + "\xfa\x0aredis-bits\xc0\x40" +
+		"\xfe\x00" + "\xfb\x02\x01" +
+		"\xfc" + string(binary.LittleEndian.AppendUint64(nil, 1)) + "\x00\x04gone\x01v" +
+		"\x00\x04kept\x05value" + "\xff")
+	file = binary.LittleEndian.AppendUint64(file, crc64Jones(0, file))
+	keys := map[string]string{}
+	expired, err := readRDB(bytes.NewReader(file), func(db int, key string, entry *Entry) error {
+		keys[key] = entry.Value.(string)
+		return nil
+	}, func(string) error { return nil })
+	if err != nil || expired != 1 || len(keys) != 1 || keys["kept"] != "value" {
+		t.Fatalf("expected only kept to load, got %v %d %v", keys, expired, err)
+	}
+	moduleAux := []byte("REDIS0011\xf7\x01\x02")
+	if _, err := readRDB(bytes.NewReader(moduleAux), nil, nil); err != errModuleData {
+		t.Fatalf("expected module data to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Redis listpack encoding bytes, as found in RDB files: each entry is an
+// encoding byte with its data, then the length of both for reading backwards
+const (
+	lpEnc7BitUint  = 0x00 // 0xxxxxxx
+	lpEnc6BitStr   = 0x80 // 10xxxxxx, then the string
+	lpEnc13BitInt  = 0xC0 // 110xxxxx yyyyyyyy
+	lpEnc12BitStr  = 0xE0 // 1110xxxx yyyyyyyy, then the string
+	lpEnc32BitStr  = 0xF0 // 4-byte length, then the string
+	lpEnc16BitInt  = 0xF1
+	lpEnc24BitInt  = 0xF2
+	lpEnc32BitInt  = 0xF3
+	lpEnc64BitInt  = 0xF4
+	lpEOF          = 0xFF
+	lpHeaderSize   = 6 // Total bytes (uint32) and element count (uint16)
+	lpUnknownCount = 0xFFFF
+)
+
+var errListpackFormat = errors.New("invalid listpack encoding")
+
+// encodeRDBListpack encodes values as a Redis listpack, storing those that
+// are canonical decimal integers as integers, as Redis does
+func encodeRDBListpack(values []string) []byte {
+	buf := make([]byte, lpHeaderSize, lpHeaderSize+len(values)*4)
+	for _, v := range values {
+		start := len(buf)
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && len(v) <= 20 && strconv.FormatInt(n, 10) == v {
+			buf = appendListpackInt(buf, n)
+		} else {
+			buf = appendListpackString(buf, v)
+		}
+		buf = appendListpackBacklen(buf, len(buf)-start)
+	}
+	buf = append(buf, lpEOF)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	count := len(values)
+	if count >= lpUnknownCount {
+		count = lpUnknownCount
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(count))
+	return buf
+}
+
+func appendListpackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 127:
+		return append(buf, byte(n))
+	case n >= -1<<12 && n < 1<<12:
+		u := uint16(n) & 0x1FFF
+		return append(buf, lpEnc13BitInt|byte(u>>8), byte(u))
+	case n >= -1<<15 && n < 1<<15:
+		return binary.LittleEndian.AppendUint16(append(buf, lpEnc16BitInt), uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		u := uint32(n)
+		return append(buf, lpEnc24BitInt, byte(u), byte(u>>8), byte(u>>16))
+	case n >= -1<<31 && n < 1<<31:
+		return binary.LittleEndian.AppendUint32(append(buf, lpEnc32BitInt), uint32(n))
+	}
+	return binary.LittleEndian.AppendUint64(append(buf, lpEnc64BitInt), uint64(n))
+}
+
+func appendListpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 1<<6:
+		buf = append(buf, lpEnc6BitStr|byte(n))
+	case n < 1<<12:
+		buf = append(buf, lpEnc12BitStr|byte(n>>8), byte(n))
+	default:
+		buf = binary.LittleEndian.AppendUint32(append(buf, lpEnc32BitStr), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// appendListpackBacklen appends the length of an entry, 7 bits per byte
+// from the most significant, every byte after the first flagged with the
+// top bit so that a reader going backwards knows to read on
+func appendListpackBacklen(buf []byte, n int) []byte {
+	size := listpackBacklenSize(n)
+	buf = append(buf, byte(n>>(7*(size-1))&127))
+	for i := size - 2; i >= 0; i-- {
+		buf = append(buf, byte(n>>(7*i)&127)|128)
+	}
+	return buf
+}
+
+// listpackBacklenSize returns the size of the backlen of an entry of n bytes
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// decodeRDBListpack decodes a Redis listpack, integers as decimal strings
+func decodeRDBListpack(data []byte) ([]string, error) {
+	if len(data) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(data)) != len(data) || data[len(data)-1] != lpEOF {
+		return nil, errListpackFormat
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	values := make([]string, 0, min(count, rdbPreallocMax))
+	off := lpHeaderSize
+	for data[off] != lpEOF {
+		v, size, err := listpackEntryAt(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off += size + listpackBacklenSize(size)
+		if off >= len(data) {
+			return nil, errListpackFormat
+		}
+		values = append(values, v)
+	}
+	if count != lpUnknownCount && count != len(values) {
+		return nil, errListpackFormat
+	}
+	return values, nil
+}
+
+// listpackEntryAt decodes the entry at off
+// Returns its value and the size of its encoding and data
+func listpackEntryAt(data []byte, off int) (string, int, error) {
+	need := func(n int) bool { return off+n <= len(data)-1 }
+	b := data[off]
+	var n int64
+	var size int
+	switch {
+	case b&0x80 == lpEnc7BitUint:
+		n, size = int64(b), 1
+	case b&0xC0 == lpEnc6BitStr:
+		length := int(b & 0x3F)
+		if !need(1 + length) {
+			return "", 0, errListpackFormat
+		}
+		return string(data[off+1 : off+1+length]), 1 + length, nil
+	case b&0xE0 == lpEnc13BitInt:
+		if !need(2) {
+			return "", 0, errListpackFormat
+		}
+		u := int64(b&0x1F)<<8 | int64(data[off+1])
+		if u >= 1<<12 {
+			u -= 1 << 13
+		}
+		n, size = u, 2
+	case b&0xF0 == lpEnc12BitStr:
+		if !need(2) {
+			return "", 0, errListpackFormat
+		}
+		length := int(b&0x0F)<<8 | int(data[off+1])
+		if !need(2 + length) {
+			return "", 0, errListpackFormat
+		}
+		return string(data[off+2 : off+2+length]), 2 + length, nil
+	case b == lpEnc32BitStr:
+		if !need(5) {
+			return "", 0, errListpackFormat
+		}
+		length := int(binary.LittleEndian.Uint32(data[off+1:]))
+		if length < 0 || !need(5+length) {
+			return "", 0, errListpackFormat
+		}
+		return string(data[off+5 : off+5+length]), 5 + length, nil
+	case b == lpEnc16BitInt:
+		if !need(3) {
+			return "", 0, errListpackFormat
+		}
+		n, size = int64(int16(binary.LittleEndian.Uint16(data[off+1:]))), 3
+	case b == lpEnc24BitInt:
+		if !need(4) {
+			return "", 0, errListpackFormat
+		}
+		u := int32(data[off+1]) | int32(data[off+2])<<8 | int32(data[off+3])<<16
+		n, size = int64(u<<8>>8), 4
+	case b == lpEnc32BitInt:
+		if !need(5) {
+			return "", 0, errListpackFormat
+		}
+		n, size = int64(int32(binary.LittleEndian.Uint32(data[off+1:]))), 5
+	case b == lpEnc64BitInt:
+		if !need(9) {
+			return "", 0, errListpackFormat
+		}
+		n, size = int64(binary.LittleEndian.Uint64(data[off+1:])), 9
+	default:
+		return "", 0, errListpackFormat
+	}
+	return strconv.FormatInt(n, 10), size, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RDB value types of streams: a radix tree of listpacks with consumer
+// groups, each version adding metadata. Streams are written in the newest
+// (Redis 7.2), which keeps all of ours.
+const (
+	rdbTypeStreamListpacks  = 15
+	rdbTypeStreamListpacks2 = 19 // Adds first and max deleted IDs, entries added and entries read
+	rdbTypeStreamListpacks3 = 21 // Adds consumer active times
+)
+
+// Flags of an entry in a stream listpack
+const (
+	streamItemDeleted    = 1 // Deleted by XDEL, left in place
+	streamItemSameFields = 2 // Has the master entry's fields, so only values follow
+)
+
+// appendStreamID appends an ID as the 16 big-endian bytes of a radix tree key
+func appendStreamID(buf []byte, id streamID) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(buf, id.ms), id.seq)
+}
+
+// writeMillis writes a Unix time in milliseconds, -1 for the zero time
+func (e *rdbEncoder) writeMillis(t time.Time) {
+	ms := int64(-1)
+	if !t.IsZero() {
+		ms = t.UnixMilli()
+	}
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(ms))
+}
+
+// writeStream writes a stream as rdbTypeStreamListpacks3. Each node is one
+// listpack, its first entry the master entry the others are relative to.
+func (e *rdbEncoder) writeStream(st *stream) {
+	e.writeLength(uint64(len(st.nodes)))
+	for _, node := range st.nodes {
+		master := node.entries[0]
+		e.writeString(string(appendStreamID(nil, master.id)))
+		e.writeString(string(encodeRDBListpack(streamNodeValues(node))))
+	}
+	e.writeLength(uint64(st.length))
+	first, _ := st.first()
+	for _, id := range []streamID{st.lastID, first.id, st.maxDeletedID} {
+		e.writeLength(id.ms)
+		e.writeLength(id.seq)
+	}
+	e.writeLength(st.entriesAdded)
+
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	e.writeLength(uint64(len(names)))
+	for _, name := range names {
+		g := st.groups[name]
+		e.writeString(name)
+		e.writeLength(g.lastID.ms)
+		e.writeLength(g.lastID.seq)
+		e.writeLength(uint64(g.entriesRead))
+		e.writeLength(uint64(len(g.pending)))
+		for _, pe := range g.pending {
+			e.buf = appendStreamID(e.buf, pe.id)
+			e.writeMillis(pe.deliveryTime)
+			e.writeLength(uint64(pe.deliveryCount))
+		}
+		consumers := make([]string, 0, len(g.consumers))
+		for name := range g.consumers {
+			consumers = append(consumers, name)
+		}
+		slices.Sort(consumers)
+		e.writeLength(uint64(len(consumers)))
+		for _, name := range consumers {
+			c := g.consumers[name]
+			e.writeString(name)
+			e.writeMillis(c.seenTime)
+			e.writeMillis(c.activeTime)
+			ids := make([]streamID, 0, len(c.pending))
+			for id := range c.pending {
+				ids = append(ids, id)
+			}
+			slices.SortFunc(ids, streamID.compare)
+			e.writeLength(uint64(len(ids)))
+			for _, id := range ids {
+				e.buf = appendStreamID(e.buf, id)
+			}
+		}
+	}
+}
+
+// streamNodeValues lays a node out as the elements of its listpack: the
+// master entry (count, deleted count, fields, terminator), then every entry
+// (flags, ID relative to the master, fields or only values if they are the
+// master's, and the element count for reading backwards)
+func streamNodeValues(node *streamNode) []string {
+	itoa := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	// Differences wrap around, as the sequence number of a later millisecond
+	// may be lower
+	diff := func(a, b uint64) string { return strconv.FormatInt(int64(a-b), 10) }
+	master := node.entries[0]
+	masterFields := make([]string, 0, len(master.fields)/2)
+	for i := 0; i < len(master.fields); i += 2 {
+		masterFields = append(masterFields, master.fields[i])
+	}
+	values := []string{itoa(uint64(len(node.entries))), "0", itoa(uint64(len(masterFields)))}
+	values = append(values, masterFields...)
+	values = append(values, "0")
+	for _, entry := range node.entries {
+		same := len(entry.fields) == 2*len(masterFields)
+		for i := 0; same && i < len(masterFields); i++ {
+			same = entry.fields[2*i] == masterFields[i]
+		}
+		flags, count := uint64(0), uint64(3+len(entry.fields)/2)
+		if same {
+			flags = streamItemSameFields
+		} else {
+			count += uint64(len(entry.fields)/2) + 1
+		}
+		values = append(values, itoa(flags), diff(entry.id.ms, master.id.ms), diff(entry.id.seq, master.id.seq))
+		if same {
+			for i := 1; i < len(entry.fields); i += 2 {
+				values = append(values, entry.fields[i])
+			}
+		} else {
+			values = append(values, itoa(uint64(len(entry.fields)/2)))
+			values = append(values, entry.fields...)
+		}
+		values = append(values, itoa(count))
+	}
+	return values
+}
+
+// readMillis reads a Unix time in milliseconds, the zero time for -1
+func (d *rdbDecoder) readMillis() (time.Time, error) {
+	buf, err := d.readFull(8)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms := int64(binary.LittleEndian.Uint64(buf))
+	if ms == -1 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// readStreamID reads an ID written as a radix tree key
+func (d *rdbDecoder) readStreamID() (streamID, error) {
+	buf, err := d.readFull(16)
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])}, nil
+}
+
+// readIDLengths reads an ID written as two lengths
+func (d *rdbDecoder) readIDLengths() (streamID, error) {
+	ms, err := d.readPlainLength()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := d.readPlainLength()
+	return streamID{ms, seq}, err
+}
+
+// readStream reads a stream in any of the stream RDB types
+func (d *rdbDecoder) readStream(rdbType byte) (*stream, error) {
+	st := newStream()
+	nodes, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	for range nodes {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		lp, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errRDBFormat
+		}
+		master := streamID{binary.BigEndian.Uint64([]byte(key)), binary.BigEndian.Uint64([]byte(key[8:]))}
+		values, err := decodeRDBListpack([]byte(lp))
+		if err != nil {
+			return nil, err
+		}
+		if err := addStreamNode(st, master, values); err != nil {
+			return nil, err
+		}
+	}
+	length, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	if st.lastID, err = d.readIDLengths(); err != nil {
+		return nil, err
+	}
+	if length != uint64(st.length) {
+		return nil, errRDBFormat
+	}
+	if rdbType >= rdbTypeStreamListpacks2 {
+		if _, err := d.readIDLengths(); err != nil { // First ID, which the entries give
+			return nil, err
+		}
+		if st.maxDeletedID, err = d.readIDLengths(); err != nil {
+			return nil, err
+		}
+		if st.entriesAdded, err = d.readPlainLength(); err != nil {
+			return nil, err
+		}
+	} else {
+		st.entriesAdded = uint64(st.length)
+	}
+
+	groups, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	if groups > 0 {
+		st.groups = make(map[string]*consumerGroup)
+	}
+	for range groups {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := d.readIDLengths()
+		if err != nil {
+			return nil, err
+		}
+		entriesRead := int64(-1)
+		if rdbType >= rdbTypeStreamListpacks2 {
+			n, err := d.readPlainLength()
+			if err != nil {
+				return nil, err
+			}
+			entriesRead = int64(n)
+		}
+		g := newConsumerGroup(lastID, entriesRead)
+		if err := d.readGroupState(g, rdbType); err != nil {
+			return nil, err
+		}
+		st.groups[name] = g
+	}
+	return st, nil
+}
+
+// readGroupState reads the pending entries and consumers of a group
+func (d *rdbDecoder) readGroupState(g *consumerGroup, rdbType byte) error {
+	n, err := d.readPlainLength()
+	if err != nil {
+		return err
+	}
+	byID := make(map[streamID]*pendingEntry, min(n, rdbPreallocMax))
+	for range n {
+		id, err := d.readStreamID()
+		if err != nil {
+			return err
+		}
+		deliveryTime, err := d.readMillis()
+		if err != nil {
+			return err
+		}
+		count, err := d.readPlainLength()
+		if err != nil {
+			return err
+		}
+		pe := &pendingEntry{id: id, deliveryTime: deliveryTime, deliveryCount: int64(count)}
+		if len(g.pending) > 0 && g.pending[len(g.pending)-1].id.compare(id) >= 0 {
+			return errRDBFormat
+		}
+		g.pending = append(g.pending, pe)
+		byID[id] = pe
+	}
+
+	consumers, err := d.readPlainLength()
+	if err != nil {
+		return err
+	}
+	for range consumers {
+		name, err := d.readString()
+		if err != nil {
+			return err
+		}
+		c := &streamConsumer{name: name, pending: make(map[streamID]*pendingEntry)}
+		if c.seenTime, err = d.readMillis(); err != nil {
+			return err
+		}
+		if rdbType >= rdbTypeStreamListpacks3 {
+			if c.activeTime, err = d.readMillis(); err != nil {
+				return err
+			}
+		} else {
+			c.activeTime = c.seenTime
+		}
+		owned, err := d.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for range owned {
+			id, err := d.readStreamID()
+			if err != nil {
+				return err
+			}
+			pe := byID[id]
+			if pe == nil || pe.consumer != nil {
+				return errRDBFormat
+			}
+			pe.consumer = c
+			c.pending[id] = pe
+		}
+		g.consumers[name] = c
+	}
+	// Every pending entry belongs to a consumer
+	for _, pe := range g.pending {
+		if pe.consumer == nil {
+			return errRDBFormat
+		}
+	}
+	return nil
+}
+
+// addStreamNode appends the live entries of a stream listpack to st
+func addStreamNode(st *stream, master streamID, values []string) error {
+	pos := 0
+	next := func() (uint64, bool) {
+		if pos == len(values) {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(values[pos], 10, 64)
+		pos++
+		return uint64(n), err == nil
+	}
+	count, ok1 := next()
+	deleted, ok2 := next()
+	numFields, ok3 := next()
+	if !ok1 || !ok2 || !ok3 || numFields > uint64(len(values)-pos) {
+		return errRDBFormat
+	}
+	masterFields := values[pos : pos+int(numFields)]
+	pos += int(numFields)
+	if terminator, ok := next(); !ok || terminator != 0 {
+		return errRDBFormat
+	}
+
+	node := &streamNode{}
+	for range count + deleted {
+		flags, ok1 := next()
+		msDiff, ok2 := next()
+		seqDiff, ok3 := next()
+		if !ok1 || !ok2 || !ok3 {
+			return errRDBFormat
+		}
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			if len(values)-pos < len(masterFields) {
+				return errRDBFormat
+			}
+			fields = make([]string, 0, 2*len(masterFields))
+			for i, field := range masterFields {
+				fields = append(fields, field, values[pos+i])
+			}
+			pos += len(masterFields)
+		} else {
+			n, ok := next()
+			if !ok || n > uint64(len(values)-pos)/2 {
+				return errRDBFormat
+			}
+			fields = slices.Clone(values[pos : pos+2*int(n)])
+			pos += 2 * int(n)
+		}
+		if _, ok := next(); !ok { // Element count, for reading backwards
+			return errRDBFormat
+		}
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		id := streamID{master.ms + msDiff, master.seq + seqDiff}
+		if last, ok := st.last(); ok && id.compare(last.id) <= 0 || len(node.entries) > 0 && id.compare(node.last().id) <= 0 {
+			return errRDBFormat
+		}
+		node.entries = append(node.entries, streamEntry{id, fields})
+		for _, f := range fields {
+			node.bytes += len(f)
+		}
+	}
+	if pos != len(values) {
+		return errRDBFormat
+	}
+	if len(node.entries) > 0 {
+		node.added = len(node.entries)
+		st.nodes = append(st.nodes, node)
+		st.length += len(node.entries)
+	}
+	return nil
+}
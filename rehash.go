@@ -0,0 +1,41 @@
+package main
+
+import "time"
+
+// Background rehash parameters. Like Redis's activerehashing, each database
+// gets a short slice of time every interval, so a resize finishes even when
+// the database receives no writes.
+const (
+	activeRehashInterval = 100 * time.Millisecond
+	activeRehashBudget   = time.Millisecond // Maximum time the write lock is held per slice
+)
+
+// RehashStats returns the hash table state of the store's keyspace
+func (s *Store) RehashStats() RehashStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.rehashStats()
+}
+
+// activeRehash advances an in-progress resize for at most activeRehashBudget
+func (s *Store) activeRehash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.rehashFor(activeRehashBudget)
+}
+
+// activeRehashLoop periodically gives every database a rehash time slice
+func activeRehashLoop() {
+	ticker := time.NewTicker(activeRehashInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !config.ActiveRehashing {
+			continue
+		}
+		for _, db := range databases.All() {
+			if db.RehashStats().Rehashing {
+				db.activeRehash()
+			}
+		}
+	}
+}
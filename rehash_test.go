@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scanKeyspace walks the whole keyspace with scanBucket
+func scanKeyspace(ks *keyspace) map[string]int {
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		cursor = ks.scanBucket(cursor, func(key string, entry *Entry) {
+			seen[key]++
+		})
+		if cursor == 0 {
+			return seen
+		}
+	}
+}
+
+func TestKeyspaceIncrementalRehash(t *testing.T) {
+	ks := newKeyspace()
+	limit := keyspaceInitialBuckets * keyspaceMaxLoad
+
+	// Test 1: Crossing the load limit starts a resize instead of moving every key
+	for i := 0; i <= limit; i++ {
+		ks.set(fmt.Sprintf("key:%d", i), &Entry{Type: TypeString, Value: "v"})
+	}
+	stats := ks.rehashStats()
+	if !stats.Rehashing || stats.Target != keyspaceInitialBuckets*2 || stats.Migrated != 0 {
+		t.Fatalf("expected a resize to have just started, got %+v", stats)
+	}
+
+	// Test 2: Each write migrates a bounded number of buckets
+	ks.set("extra", &Entry{Type: TypeString, Value: "v"})
+	if migrated := ks.rehashStats().Migrated; migrated < 1 || migrated > rehashEmptyVisits+1 {
+		t.Fatalf("expected one write to migrate a few buckets, got %d", migrated)
+	}
+
+	// Test 3: Keys in both tables are found, and a scan sees each of them
+	for i := 0; i <= limit; i++ {
+		if ks.get(fmt.Sprintf("key:%d", i)) == nil {
+			t.Fatalf("key:%d not found during resize", i)
+		}
+	}
+	seen := scanKeyspace(ks)
+	if len(seen) != limit+2 {
+		t.Fatalf("expected scan to see %d keys during resize, got %d", limit+2, len(seen))
+	}
+
+	// Test 4: A time slice finishes the resize
+	if ks.rehashFor(time.Second) {
+		t.Fatalf("expected the resize to complete")
+	}
+	if stats := ks.rehashStats(); stats.Rehashing || stats.Buckets != keyspaceInitialBuckets*2 {
+		t.Fatalf("expected a finished resize to 32 buckets, got %+v", stats)
+	}
+
+	// Test 5: Deleting most keys shrinks the table the same way
+	for i := 0; i <= limit; i++ {
+		ks.delete(fmt.Sprintf("key:%d", i))
+	}
+	ks.rehashFor(time.Second)
+	if stats := ks.rehashStats(); stats.Buckets != keyspaceInitialBuckets {
+		t.Fatalf("expected the table to shrink back to %d buckets, got %+v", keyspaceInitialBuckets, stats)
+	}
+	if seen := scanKeyspace(ks); ks.len() != 1 || seen["extra"] != 1 {
+		t.Fatalf("expected only extra to remain, got %d keys: %v", ks.len(), seen)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Reply buffer sizing, after Redis's client output buffer resizing
+const (
+	replyBufferMin     = 1 << 10  // Smallest buffer handed to a connection
+	replyBufferMax     = 16 << 10 // Largest buffer; bigger replies are written in chunks of this size
+	replyBufferClasses = 5        // Power-of-two sizes from replyBufferMin to replyBufferMax
+	replyBufferWindow  = 32       // Replies whose peak size decides whether the buffer shrinks
+)
+
+// One pool of *[]byte per size class, shared by all connections
+var replyBufferPools [replyBufferClasses]sync.Pool
+
+// Bytes of reply buffer currently held by connections
+var replyBufferBytes atomic.Int64
+
+// replyClass returns the smallest size class able to hold n bytes
+func replyClass(n int) int {
+	class := 0
+	for size := replyBufferMin; size < n && class < replyBufferClasses-1; size <<= 1 {
+		class++
+	}
+	return class
+}
+
+// replyBuffer is the write buffer of one connection. Replies accumulate in it
+// while pipelined commands are pending and go out in one write when the
+// connection runs out of commands, when the buffer fills up, or before the
+// client blocks. Its size follows the connection's recent replies: it grows
+// as soon as a reply does not fit, and shrinks when the peak reply of the last
+// replyBufferWindow replies would fit a smaller class. Buffers above the
+// minimum go back to the shared pool whenever the connection has no pipelined
+// commands left, so idle connections do not pin the space used by one big reply.
+type replyBuffer struct {
+	buf   *[]byte // Borrowed buffer holding the replies not written yet, nil when none is held
+	class int     // Size class to borrow next
+	peak  int     // Largest reply in the current window
+	count int     // Replies in the current window
+}
+
+// write queues reply for w, writing the buffer out whenever it fills up
+func (rb *replyBuffer) write(w io.Writer, reply string) error {
+	want := rb.observe(len(reply))
+	if grow := replyClass(len(reply)); grow > want {
+		want = grow
+	}
+	if rb.buf == nil || want != rb.class {
+		if err := rb.flush(w); err != nil {
+			return err
+		}
+		rb.resize(want)
+	}
+
+	for len(reply) > 0 {
+		buf := *rb.buf
+		if len(buf) == cap(buf) {
+			if err := rb.flush(w); err != nil {
+				return err
+			}
+			buf = *rb.buf
+		}
+		n := copy(buf[len(buf):cap(buf)], reply)
+		*rb.buf = buf[:len(buf)+n]
+		reply = reply[n:]
+	}
+	return nil
+}
+
+// flush writes the queued replies to w
+func (rb *replyBuffer) flush(w io.Writer) error {
+	if rb.buf == nil || len(*rb.buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(*rb.buf)
+	*rb.buf = (*rb.buf)[:0]
+	return err
+}
+
+// send queues reply for the client's connection, after the frames pushed to
+// it so far. It goes out with the replies to the commands pipelined after it,
+// once idleReplies or flushReplies is called.
+func (c *Client) send(reply string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.flushOutbox(); err != nil {
+		return err
+	}
+	return c.replies.write(c.conn, reply)
+}
+
+// flushReplies writes out the replies queued by send
+func (c *Client) flushReplies() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.replies.flush(c.conn)
+}
+
+// releaseReplies returns the reply buffer once the connection is closed
+func (c *Client) releaseReplies() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.replies.release()
+}
+
+// idleReplies is called when the client waits for its next command: the
+// queued replies are written out
+func (c *Client) idleReplies() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err := c.replies.flush(c.conn)
+	c.replies.idle()
+	return err
+}
+
+// observe records a reply size. At the end of a window it returns the class
+// the window's peak needs if that is smaller, and the current class otherwise.
+func (rb *replyBuffer) observe(size int) int {
+	rb.peak = max(rb.peak, size)
+	rb.count++
+	if rb.count < replyBufferWindow {
+		return rb.class
+	}
+	want := min(replyClass(rb.peak), rb.class)
+	rb.peak, rb.count = 0, 0
+	return want
+}
+
+// resize swaps the held buffer for one of the given class, dropping the
+// replies it holds
+func (rb *replyBuffer) resize(class int) {
+	if rb.buf != nil && class == rb.class {
+		return
+	}
+	rb.release()
+	rb.class = class
+	if pooled, ok := replyBufferPools[class].Get().(*[]byte); ok {
+		rb.buf = pooled
+	} else {
+		buf := make([]byte, 0, replyBufferMin<<class)
+		rb.buf = &buf
+	}
+	replyBufferBytes.Add(int64(cap(*rb.buf)))
+}
+
+// idle is called when the connection waits for its next command
+func (rb *replyBuffer) idle() {
+	if rb.class > 0 {
+		rb.release()
+	}
+}
+
+// release returns the held buffer to its pool, dropping the replies it holds
+func (rb *replyBuffer) release() {
+	if rb.buf == nil {
+		return
+	}
+	replyBufferBytes.Add(-int64(cap(*rb.buf)))
+	*rb.buf = (*rb.buf)[:0]
+	replyBufferPools[rb.class].Put(rb.buf)
+	rb.buf = nil
+}
+
+// held returns the capacity of the buffer the connection holds
+func (rb *replyBuffer) held() int {
+	if rb.buf == nil {
+		return 0
+	}
+	return cap(*rb.buf)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReplyBufferSizing(t *testing.T) {
+	var rb replyBuffer
+	var out bytes.Buffer
+	defer rb.release()
+
+	// Test 1: Small replies use the minimum buffer
+	rb.write(&out, "+OK\r\n")
+	if rb.held() != replyBufferMin {
+		t.Fatalf("expected a %d byte buffer, got %d", replyBufferMin, rb.held())
+	}
+
+	// Test 2: A bigger reply grows the buffer to fit it
+	big := strings.Repeat("x", 5000)
+	rb.write(&out, big)
+	if rb.held() != 8<<10 {
+		t.Fatalf("expected an 8KB buffer, got %d", rb.held())
+	}
+
+	// Test 3: Replies larger than the maximum are written in chunks
+	huge := strings.Repeat("y", 3*replyBufferMax+10)
+	rb.flush(&out)
+	out.Reset()
+	rb.write(&out, huge)
+	if err := rb.flush(&out); err != nil || out.String() != huge {
+		t.Fatalf("expected the whole reply to be written, got %d bytes (%v)", out.Len(), err)
+	}
+	if rb.held() != replyBufferMax {
+		t.Fatalf("expected the buffer to stop at %d, got %d", replyBufferMax, rb.held())
+	}
+
+	// Test 4: A window of small replies shrinks the buffer back
+	for i := 0; i < 2*replyBufferWindow; i++ {
+		rb.write(&out, "+OK\r\n")
+	}
+	if rb.held() != replyBufferMin {
+		t.Fatalf("expected the buffer to shrink to %d, got %d", replyBufferMin, rb.held())
+	}
+
+	// Test 5: Idle connections give back buffers above the minimum only
+	rb.idle()
+	if rb.held() != replyBufferMin {
+		t.Fatalf("expected the minimum buffer to be kept, got %d", rb.held())
+	}
+	rb.write(&out, big)
+	rb.idle()
+	if rb.held() != 0 {
+		t.Fatalf("expected the large buffer to be released, got %d", rb.held())
+	}
+	out.Reset()
+	rb.write(&out, "+OK\r\n")
+	rb.flush(&out)
+	if out.String() != "+OK\r\n" || rb.held() != 8<<10 {
+		t.Fatalf("expected the next reply to borrow the recent size, got %d bytes held", rb.held())
+	}
+
+	// Test 6: Replies accumulate until flushed, and go out in one write
+	var writes writeCounter
+	rb.write(&writes, "+OK\r\n")
+	rb.write(&writes, ":1\r\n")
+	if writes.calls != 0 {
+		t.Fatalf("expected the replies to be held, got %d writes", writes.calls)
+	}
+	rb.flush(&writes)
+	if writes.calls != 1 || writes.String() != "+OK\r\n:1\r\n" {
+		t.Fatalf("expected one write of both replies, got %d writes of %q", writes.calls, writes.String())
+	}
+}
+
+// writeCounter counts the writes made to it
+type writeCounter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.calls++
+	return w.Buffer.Write(p)
+}
+
+func TestPipelinedReplies(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	conn, reader := dialPubSub(t)
+	defer conn.Close()
+
+	// Test 1: Pipelined replies all arrive, in order
+	conn.Write([]byte(formatArray([]string{"PING"}) + formatArray([]string{"GET", "k"}) + formatArray([]string{"PING"})))
+	expectFrames(t, conn, reader, "+PONG\r\n$-1\r\n+PONG\r\n")
+
+	// Test 2: Replies queued before a blocking command are written before it waits
+	conn.Write([]byte(formatArray([]string{"SET", "k", "v"}) + formatArray([]string{"BLPOP", "q", "0"})))
+	expectFrames(t, conn, reader, "+OK\r\n")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatBoolean formats a RESP3 boolean
+func formatBoolean(b bool) string {
+	if b {
+		return "#t\r\n"
+	}
+	return "#f\r\n"
+}
+
+// formatMap formats already-encoded field/value pairs as a RESP3 map,
+// or as a flat array for RESP2 clients
+func (c *Client) formatMap(pairs []string) string {
+	header := "*" + strconv.Itoa(len(pairs)) + "\r\n"
+	if c.resp3 {
+		header = "%" + strconv.Itoa(len(pairs)/2) + "\r\n"
+	}
+	return header + strings.Join(pairs, "")
+}
+
+// formatSet formats elements as a RESP3 set, or as an array for RESP2 clients
+func (c *Client) formatSet(elems []string) string {
+	if !c.resp3 {
+		return formatArray(elems)
+	}
+	reply := "~" + strconv.Itoa(len(elems)) + "\r\n"
+	for _, e := range elems {
+		reply += formatBulkString(e)
+	}
+	return reply
+}
+
+// formatDouble formats a floating point reply as a RESP3 double, or as a bulk
+// string for RESP2 clients, in the notation of formatScore
+func (c *Client) formatDouble(f float64) string {
+	if c.resp3 {
+		return "," + formatScore(f) + "\r\n"
+	}
+	return formatBulkString(formatScore(f))
+}
+
+// attribute adds a field to the attribute map of the current reply
+// value must already be RESP-encoded
+func (c *Client) attribute(name, value string) {
+	if c.attributes {
+		c.replyAttrs = append(c.replyAttrs, formatBulkString(name), value)
+	}
+}
+
+// keyAttributes adds the metadata reported for a key read by the command:
+// the remaining TTL in milliseconds (-1 without one, -2 if missing) and
+// whether the reply came from a replica, which this server never is
+func (c *Client) keyAttributes(key string) {
+	ttl, exists, hasTTL := c.db().TTL(key)
+	switch {
+	case !exists:
+		c.attribute("ttl", formatInteger(-2))
+	case !hasTTL:
+		c.attribute("ttl", formatInteger(-1))
+	default:
+		c.attribute("ttl", formatInteger(int(ttl/time.Millisecond)))
+	}
+	c.attribute("replica", formatBoolean(false))
+}
+
+// withAttributes prefixes reply with the attribute map collected while
+// executing the command. Errors are sent without attributes.
+func (c *Client) withAttributes(reply string) string {
+	if len(c.replyAttrs) == 0 || reply == "" || reply[0] == '-' {
+		return reply
+	}
+	return "|" + strconv.Itoa(len(c.replyAttrs)/2) + "\r\n" + strings.Join(c.replyAttrs, "") + reply
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloCommand(c *Client, args []string) string {
+	resp3 := c.resp3
+	if len(args) >= 2 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return formatError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return formatError("NOPROTO unsupported protocol version")
+		}
+		resp3 = version == 3
+	}
+	var auth []string
+	name, setName := "", false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "AUTH" && i+2 < len(args):
+			auth = args[i+1 : i+3]
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			name, setName = args[i+1], true
+			i++
+		default:
+			return formatError("ERR Syntax error in HELLO option '" + args[i] + "'")
+		}
+	}
+	if auth != nil && !c.authenticate(auth[0], auth[1]) {
+		return formatError(wrongPassError)
+	}
+	if c.policy != nil && c.policy.password != "" && !c.authenticated {
+		return formatError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if setName {
+		if errReply := c.setName(name); errReply != "" {
+			return errReply
+		}
+	}
+
+	c.resp3 = resp3
+	if !c.resp3 {
+		c.attributes = false
+	}
+
+	proto := 2
+	if c.resp3 {
+		proto = 3
+	}
+	return c.formatMap([]string{
+		formatBulkString("server"), formatBulkString("redis"),
+		formatBulkString("version"), formatBulkString(serverVersion),
+		formatBulkString("proto"), formatInteger(proto),
+		formatBulkString("id"), formatInteger(int(c.id)),
+		formatBulkString("mode"), formatBulkString("standalone"),
+		formatBulkString("role"), formatBulkString("master"),
+		formatBulkString("modules"), formatArray(nil),
+	})
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ATTRIBUTES (ON|OFF)",
+	"    Control RESP3 attribute metadata (TTL, cache hit, replica) on replies.",
+	"    Requires the connection to have switched to RESP3 with HELLO 3.",
+	"HELP",
+	"    Print this help.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Kill connections by type.",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"    Blocked clients (flags=b) show the keys they wait on in bkeys and the",
+	"    milliseconds left before they time out in btimeout (-1 for never).",
+	"UNBLOCK <clientid> [TIMEOUT|ERROR]",
+	"    Unblock the specified blocked client.",
+}
+
+// CLIENT ATTRIBUTES ON|OFF
+// CLIENT HELP
+func clientCommand(c *Client, args []string) string {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range clientHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(clientHelp)) + "\r\n" + lines
+	case sub == "ID" && len(args) == 2:
+		return clientIDReply(c)
+	case sub == "INFO" && len(args) == 2:
+		return clientInfoReply(c)
+	case sub == "LIST":
+		return clientListReply(c, args[2:])
+	case sub == "KILL":
+		return clientKillReply(c, args[2:])
+	case sub == "UNBLOCK":
+		if len(args) < 3 {
+			return formatError("ERR wrong number of arguments for 'client|unblock' command")
+		}
+		return clientUnblockReply(args[2:])
+	case sub == "ATTRIBUTES":
+		if len(args) != 3 {
+			return formatError("ERR wrong number of arguments for 'client|attributes' command")
+		}
+		switch strings.ToUpper(args[2]) {
+		case "ON":
+			if !c.resp3 {
+				return formatError("ERR attribute replies require RESP3, switch with HELLO 3 first")
+			}
+			c.attributes = true
+		case "OFF":
+			c.attributes = false
+		default:
+			return formatError("ERR syntax error")
+		}
+		return formatSimpleString("OK")
+	}
+	return formatError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[1]))
+}
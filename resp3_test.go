@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHelloAndAttributes(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+
+	// Test 1: HELLO without arguments keeps RESP2 and replies with a flat array
+	reply := client.execute([]string{"HELLO"})
+	if !strings.HasPrefix(reply, "*14\r\n") || !strings.Contains(reply, ":2\r\n") {
+		t.Fatalf("unexpected HELLO reply: %q", reply)
+	}
+
+	// Test 2: Attributes require RESP3
+	if reply := client.execute([]string{"CLIENT", "ATTRIBUTES", "ON"}); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("expected error enabling attributes on RESP2, got %q", reply)
+	}
+	if reply := client.execute([]string{"HELLO", "4"}); !strings.HasPrefix(reply, "-NOPROTO") {
+		t.Fatalf("expected NOPROTO, got %q", reply)
+	}
+
+	// Test 3: HELLO 3 switches to maps
+	reply = client.execute([]string{"HELLO", "3"})
+	if !strings.HasPrefix(reply, "%7\r\n") || !strings.Contains(reply, ":3\r\n") {
+		t.Fatalf("unexpected HELLO 3 reply: %q", reply)
+	}
+
+	// Test 4: Without the flag replies are unchanged
+	databases.Get(0).Set("k", "v")
+	if reply := client.execute([]string{"GET", "k"}); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected plain reply, got %q", reply)
+	}
+
+	// Test 5: With the flag GET carries TTL, replica and cache-hit metadata
+	client.execute([]string{"CLIENT", "ATTRIBUTES", "ON"})
+	want := "|3\r\n$3\r\nttl\r\n:-1\r\n$7\r\nreplica\r\n#f\r\n$9\r\ncache-hit\r\n#f\r\n$1\r\nv\r\n"
+	if reply := client.execute([]string{"GET", "k"}); reply != want {
+		t.Fatalf("expected %q, got %q", want, reply)
+	}
+
+	// Test 6: The TTL attribute reports remaining milliseconds
+	databases.Get(0).SetForTesting("t", TypeString, "x")
+	databases.Get(0).data.get("t").ExpiresAt = time.Now().Add(time.Minute)
+	reply = client.execute([]string{"GET", "t"})
+	if !strings.HasPrefix(reply, "|3\r\n$3\r\nttl\r\n:59") {
+		t.Fatalf("expected TTL attribute near 60000ms, got %q", reply)
+	}
+
+	// Test 7: Errors and other commands carry no attributes
+	databases.Get(0).SetForTesting("l", TypeList, []string{"a"})
+	if reply := client.execute([]string{"GET", "l"}); !strings.HasPrefix(reply, "-WRONGTYPE") {
+		t.Fatalf("expected bare WRONGTYPE error, got %q", reply)
+	}
+	if reply := client.execute([]string{"PING"}); reply != "+PONG\r\n" {
+		t.Fatalf("expected bare PONG, got %q", reply)
+	}
+
+	// Test 8: Falling back to RESP2 turns attributes off
+	client.execute([]string{"HELLO", "2"})
+	if reply := client.execute([]string{"GET", "k"}); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected plain reply after HELLO 2, got %q", reply)
+	}
+
+	// Test 9: HELLO reports the connection ID and names the client with
+	// SETNAME, in either order with AUTH
+	registerClient(client)
+	defer unregisterClient(client)
+	reply = client.execute([]string{"HELLO", "2", "SETNAME", "worker-1"})
+	if !strings.Contains(reply, "$2\r\nid\r\n:"+strconv.FormatInt(client.id, 10)+"\r\n") {
+		t.Fatalf("expected HELLO to report the ID %d, got %q", client.id, reply)
+	}
+	if reply := client.execute([]string{"CLIENT", "INFO"}); !strings.Contains(reply, " name=worker-1 ") {
+		t.Fatalf("expected CLIENT INFO to show the name, got %q", reply)
+	}
+	locked := ListenerConfig{Name: "locked", Password: "pw"}
+	other := &Client{policy: locked.policy()}
+	if reply := other.execute([]string{"HELLO", "3", "SETNAME", "app", "AUTH", "default", "pw"}); !strings.HasPrefix(reply, "%7\r\n") || other.info.name != "app" {
+		t.Fatalf("expected HELLO to authenticate and name the client, got %q", reply)
+	}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HELLO", "2", "SETNAME", "has space"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+		{[]string{"HELLO", "2", "SETNAME"}, "-ERR Syntax error in HELLO option 'SETNAME'\r\n"},
+		{[]string{"HELLO", "2", "AUTH", "default"}, "-ERR Syntax error in HELLO option 'AUTH'\r\n"},
+		{[]string{"HELLO", "2", "BOGUS"}, "-ERR Syntax error in HELLO option 'BOGUS'\r\n"},
+	} {
+		if reply := client.execute(tc.args); reply != tc.want {
+			t.Fatalf("expected %v to reply %q, got %q", tc.args, tc.want, reply)
+		}
+	}
+	if client.info.name != "worker-1" {
+		t.Fatalf("expected refused names to leave the name alone, got %q", client.info.name)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dump file SAVE and BGSAVE write when dbfilename is not configured, in the
+// working directory unless dir says otherwise, as in Redis
+const (
+	defaultDBFilename = "dump.rdb"
+	defaultDir        = "."
+)
+
+// Directory and file name set by CONFIG SET, overriding the configuration file's
+var (
+	dirOverride        atomic.Pointer[string]
+	dbFilenameOverride atomic.Pointer[string]
+)
+
+// dataDir returns the directory the dump and append-only files are in
+func dataDir() string {
+	if override := dirOverride.Load(); override != nil {
+		return *override
+	}
+	return config.Dir
+}
+
+// rdbPath returns the path of the dump file
+func rdbPath() string {
+	name := config.DBFilename
+	if override := dbFilenameOverride.Load(); override != nil {
+		name = *override
+	}
+	return filepath.Join(dataDir(), name)
+}
+
+// saveState tracks SAVE and BGSAVE for INFO persistence and LASTSAVE
+type saveState struct {
+	mu           sync.Mutex
+	inProgress   bool          // A SAVE or BGSAVE is writing the dump file
+	background   bool          // The save in progress is a BGSAVE
+	scheduled    bool          // BGSAVE SCHEDULE asked for a BGSAVE once the current save ends
+	started      time.Time     // Start of the save in progress
+	lastSave     time.Time     // End of the last successful save
+	lastOK       bool          // Whether the last BGSAVE succeeded
+	lastDuration time.Duration // Duration of the last BGSAVE
+	savedChanges int64         // keyChanges as of the last successful save's snapshot
+	saves        int64         // Successful saves since startup
+
+	keysTotal atomic.Int64 // Keys in the snapshot of the save in progress
+	keysSaved atomic.Int64 // Keys of that snapshot written so far
+}
+
+// State of the saves of the dump file; as in Redis, the last save counts as
+// the startup time until there is one
+var saves = &saveState{lastSave: startTime, lastOK: true}
+
+// Error of a SAVE or BGSAVE started while another save runs
+const saveInProgressError = "ERR Background save already in progress"
+
+// begin marks a save as in progress
+// Returns false if another one is
+func (st *saveState) begin(background bool) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.inProgress {
+		return false
+	}
+	st.inProgress, st.background, st.started = true, background, time.Now()
+	st.keysTotal.Store(0)
+	st.keysSaved.Store(0)
+	return true
+}
+
+// end records the outcome of the save in progress
+// Returns whether BGSAVE SCHEDULE asked for another one meanwhile
+func (st *saveState) end(snap *rdbSnapshot, err error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.inProgress = false
+	if st.background {
+		st.lastOK, st.lastDuration = err == nil, time.Since(st.started)
+	}
+	if err == nil {
+		st.lastSave = time.Now()
+		st.savedChanges = snap.changes
+		st.saves++
+	}
+	scheduled := st.scheduled
+	st.scheduled = false
+	return scheduled
+}
+
+// schedule asks for a BGSAVE once the save in progress ends
+// Returns false if none is in progress
+func (st *saveState) schedule() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.inProgress {
+		st.scheduled = true
+	}
+	return st.inProgress
+}
+
+// saveSnapshot writes a snapshot to the dump file, through a temporary file
+// renamed over it once complete, so a failed save never leaves a partial dump
+func saveSnapshot(snap *rdbSnapshot) (err error) {
+	path := rdbPath()
+	f, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	saves.keysTotal.Store(int64(snap.keys))
+	w := bufio.NewWriterSize(f, rdbFlushSize)
+	if err = snap.write(w, &saves.keysSaved); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// startBackgroundSave takes a snapshot and writes it to the dump file in
+// the background
+// Returns false if a save is already in progress
+func startBackgroundSave() bool {
+	if !saves.begin(true) {
+		return false
+	}
+	snap := takeSnapshot(databases)
+	fmt.Println("Background saving started")
+	go func() {
+		err := saveSnapshot(snap)
+		snap.release()
+		if err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+		} else {
+			fmt.Println("Background saving terminated with success")
+		}
+		if saves.end(snap, err) {
+			startBackgroundSave()
+		}
+	}()
+	return true
+}
+
+// saveForeground takes a snapshot and writes it to the dump file before
+// returning. The caller must have begun the save.
+func saveForeground() error {
+	snap := takeSnapshot(databases)
+	err := saveSnapshot(snap)
+	snap.release()
+	if saves.end(snap, err) {
+		startBackgroundSave()
+	}
+	if err != nil {
+		fmt.Printf("Error saving DB on disk: %v\n", err)
+		return err
+	}
+	fmt.Println("DB saved on disk")
+	return nil
+}
+
+// SAVE
+// Writes the dump file before replying
+func saveCommand(c *Client, args []string) string {
+	if !saves.begin(false) {
+		return formatError(saveInProgressError)
+	}
+	if err := saveForeground(); err != nil {
+		return formatError("ERR " + err.Error())
+	}
+	return formatSimpleString("OK")
+}
+
+// BGSAVE [SCHEDULE]
+// With SCHEDULE, a save in progress makes it run once that one ends
+func bgsaveCommand(c *Client, args []string) string {
+	schedule := false
+	if len(args) == 2 && strings.EqualFold(args[1], "SCHEDULE") {
+		schedule = true
+	} else if len(args) > 1 {
+		return formatError("ERR syntax error")
+	}
+	if startBackgroundSave() {
+		return formatSimpleString("Background saving started")
+	}
+	if schedule && saves.schedule() {
+		return formatSimpleString("Background saving scheduled")
+	}
+	return formatError(saveInProgressError)
+}
+
+// LASTSAVE
+// Returns the Unix time of the last successful save
+func lastsaveCommand(c *Client, args []string) string {
+	saves.mu.Lock()
+	defer saves.mu.Unlock()
+	return formatInteger(int(saves.lastSave.Unix()))
+}
+
+func infoPersistence() []string {
+	saves.mu.Lock()
+	defer saves.mu.Unlock()
+
+	bgsave, current, processed, total := 0, int64(-1), int64(0), int64(0)
+	if saves.inProgress {
+		current = int64(time.Since(saves.started).Seconds())
+		processed, total = saves.keysSaved.Load(), saves.keysTotal.Load()
+		if saves.background {
+			bgsave = 1
+		}
+	}
+	status, lastDuration := "ok", int64(-1)
+	if !saves.lastOK {
+		status = "err"
+	}
+	if saves.lastDuration > 0 {
+		lastDuration = int64(saves.lastDuration.Seconds())
+	}
+	lines := []string{
+		fmt.Sprintf("rdb_changes_since_last_save:%d", keyChanges.Load()-saves.savedChanges),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", bgsave),
+		fmt.Sprintf("rdb_last_save_time:%d", saves.lastSave.Unix()),
+		"rdb_last_bgsave_status:" + status,
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", lastDuration),
+		fmt.Sprintf("rdb_current_bgsave_time_sec:%d", current),
+		fmt.Sprintf("rdb_saves:%d", saves.saves),
+		fmt.Sprintf("current_save_keys_processed:%d", processed),
+		fmt.Sprintf("current_save_keys_total:%d", total),
+	}
+	return append(append(infoLoading(), lines...), infoAOF()...)
+}
+
+// loadDump loads the dump file at path into dbs, along with its function
+// libraries
+// Returns the number of keys loaded and skipped as expired.
+func loadDump(path string, dbs *Databases) (loaded, expired int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return loadRDB(bufio.NewReaderSize(loading.track(f), rdbFlushSize), dbs)
+}
+
+// loadRDB loads RDB data from r into dbs, reading no further than its end
+func loadRDB(r *bufio.Reader, dbs *Databases) (loaded, expired int, err error) {
+	expired, err = readRDB(r, func(db int, key string, entry *Entry) error {
+		if db >= dbs.Count() {
+			return fmt.Errorf("the dump file has database %d but only %d are configured", db, dbs.Count())
+		}
+		dbs.Get(db).Load(key, entry)
+		loaded++
+		return nil
+	}, restoreLibrary)
+	return loaded, expired, err
+}
+
+// loadDumpOnStartup loads the dump file, if there is one, while the server
+// answers -LOADING. The keys it holds do not count as changes to save.
+func loadDumpOnStartup() error {
+	path := rdbPath()
+	start := time.Now()
+	loaded, expired, err := loadDump(path, databases)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	saves.mu.Lock()
+	saves.savedChanges = keyChanges.Load()
+	saves.mu.Unlock()
+	fmt.Printf("Done loading RDB, keys loaded: %d, keys expired: %d.\n", loaded, expired)
+	fmt.Printf("DB loaded from disk: %.3f seconds\n", time.Since(start).Seconds())
+	return nil
+}
+
+// validateDir checks that dir is a directory
+func validateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+	return nil
+}
+
+// validateDBFilename checks that name is a file name, not a path
+func validateDBFilename(name string) error {
+	if name == "" || filepath.Base(name) != name || name == "." || name == ".." {
+		return errors.New("dbfilename can't be a path, just a filename")
+	}
+	return nil
+}
+
+// Parameters of CONFIG GET and CONFIG SET for the dump file
+var saveConfigParams = []configParam{
+	{
+		name:      "dir",
+		get:       func() string { return filepath.Dir(rdbPath()) },
+		protected: true,
+		set: func(value string) error {
+			if err := validateDir(value); err != nil {
+				return err
+			}
+			dirOverride.Store(&value)
+			return nil
+		},
+	},
+	{
+		name:      "dbfilename",
+		get:       func() string { return filepath.Base(rdbPath()) },
+		protected: true,
+		set: func(value string) error {
+			if err := validateDBFilename(value); err != nil {
+				return err
+			}
+			dbFilenameOverride.Store(&value)
+			return nil
+		},
+	},
+	{
+		name: "rdbcompression",
+		get: func() string {
+			if rdbCompression() {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			rdbCompressionOverride.Store(&enabled)
+			return nil
+		},
+	},
+}
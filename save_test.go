@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveAndBgsave(t *testing.T) {
+	saved, savedSaves, savedDir := databases, saves, dirOverride.Load()
+	databases = NewDatabases(2)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+	}()
+
+	dir := t.TempDir()
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	if reply := run("CONFIG", "SET", "dir", dir); reply != "-ERR CONFIG SET failed (possibly related to argument 'dir') - can't set protected config\r\n" {
+		t.Fatalf("expected dir to be protected, got %q", reply)
+	}
+	config.EnableProtectedConfigs = true
+	defer func() { config.EnableProtectedConfigs = false }()
+	if reply := run("CONFIG", "SET", "dir", dir); reply != "+OK\r\n" {
+		t.Fatalf("expected CONFIG SET dir to succeed, got %q", reply)
+	}
+	run("SET", "str", "hello")
+	databases.Get(0).Restore("num", &Entry{Type: TypeString, Value: "12345", ExpiresAt: clockNow().Add(10 * time.Minute)}, false)
+	run("RPUSH", "list", "a", "1", "b")
+	run("SADD", "set", "x", "y")
+	run("HSET", "hash", "f", "v", "n", "10")
+	run("ZADD", "zset", "1.5", "a", "3", "c")
+	run("XADD", "stream", "1-1", "f", "v")
+	run("XADD", "stream", "2-1", "f", "w", "g", "1")
+	run("XADD", "stream", "3-0", "f", "x")
+	run("XDEL", "stream", "2-1")
+	run("XGROUP", "CREATE", "stream", "grp", "0")
+	run("XREADGROUP", "GROUP", "grp", "alice", "COUNT", "1", "STREAMS", "stream", ">")
+	run("XGROUP", "CREATECONSUMER", "stream", "grp", "bob")
+	run("SELECT", "1")
+	run("SET", "other", "db1")
+	run("SELECT", "0")
+
+	reads := [][]string{
+		{"GET", "str"},
+		{"GET", "num"},
+		{"PEXPIRETIME", "num"},
+		{"LRANGE", "list", "0", "-1"},
+		{"SCARD", "set"},
+		{"SISMEMBER", "set", "y"},
+		{"HMGET", "hash", "f", "n"},
+		{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		{"XINFO", "STREAM", "stream", "FULL"},
+		{"XPENDING", "stream", "grp"},
+		{"DBSIZE"},
+	}
+	want := make([]string, len(reads))
+	for i, read := range reads {
+		want[i] = run(read...)
+	}
+
+	// Test 1: SAVE writes every type to the dump file, which loads back as the
+	// same dataset
+	if reply := run("SAVE"); reply != "+OK\r\n" {
+		t.Fatalf("expected SAVE to succeed, got %q", reply)
+	}
+	if !strings.Contains(run("INFO", "persistence"), "rdb_changes_since_last_save:0\r\n") {
+		t.Fatalf("expected no changes since the save")
+	}
+	loaded := loadDumpFile(t, filepath.Join(dir, defaultDBFilename), 2)
+	databases = loaded
+	for i, read := range reads {
+		if reply := run(read...); reply != want[i] {
+			t.Fatalf("expected %v to read back %q, got %q", read, want[i], reply)
+		}
+	}
+	if reply := run("SELECT", "1"); reply != "+OK\r\n" || run("GET", "other") != "$3\r\ndb1\r\n" {
+		t.Fatalf("expected database 1 to be loaded back")
+	}
+	run("SELECT", "0")
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected only the dump file in the directory, got %v", entries)
+	}
+
+	// Test 2: A snapshot is not affected by writes made after it was taken
+	snap := takeSnapshot(databases)
+	run("HSET", "hash", "f", "changed")
+	run("RPUSH", "list", "c")
+	run("SET", "str", "changed")
+	run("XADD", "stream", "4-0", "f", "y")
+	var buf bytes.Buffer
+	if err := snap.write(&buf, nil); err != nil {
+		t.Fatalf("snapshot write failed: %v", err)
+	}
+	snap.release()
+	current := databases
+	databases = NewDatabases(2)
+	_, err := readRDB(&buf, func(db int, key string, entry *Entry) error {
+		databases.Get(db).Load(key, entry)
+		return nil
+	}, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("readRDB failed: %v", err)
+	}
+	for i, read := range reads {
+		if reply := run(read...); reply != want[i] {
+			t.Fatalf("expected the snapshot's %v to be %q, got %q", read, want[i], reply)
+		}
+	}
+	databases = current
+	if reply := run("HGET", "hash", "f"); reply != "$7\r\nchanged\r\n" {
+		t.Fatalf("expected the write after the snapshot to stay, got %q", reply)
+	}
+
+	// Test 3: BGSAVE writes in the background, reported by INFO persistence
+	// and LASTSAVE
+	before := saves.saves
+	if reply := run("BGSAVE"); reply != "+Background saving started\r\n" {
+		t.Fatalf("expected BGSAVE to start, got %q", reply)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Contains(run("INFO", "persistence"), "rdb_bgsave_in_progress:1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("BGSAVE did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	info := run("INFO", "persistence")
+	for _, field := range []string{"rdb_changes_since_last_save:0\r\n", "rdb_last_bgsave_status:ok\r\n", "current_save_keys_total:0\r\n"} {
+		if !strings.Contains(info, field) {
+			t.Fatalf("expected INFO persistence to contain %q, got %q", field, info)
+		}
+	}
+	if saves.saves != before+1 {
+		t.Fatalf("expected one more save, got %d", saves.saves-before)
+	}
+	if reply := run("LASTSAVE"); reply != formatInteger(int(saves.lastSave.Unix())) {
+		t.Fatalf("unexpected LASTSAVE reply %q", reply)
+	}
+	databases = loadDumpFile(t, filepath.Join(dir, defaultDBFilename), 2)
+	if reply := run("HGET", "hash", "f"); reply != "$7\r\nchanged\r\n" {
+		t.Fatalf("expected BGSAVE to write the current data, got %q", reply)
+	}
+
+	// Test 4: While a save is in progress, other saves are refused unless
+	// BGSAVE SCHEDULE queues one
+	saves.begin(true)
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SAVE"}, "-" + saveInProgressError + "\r\n"},
+		{[]string{"BGSAVE"}, "-" + saveInProgressError + "\r\n"},
+		{[]string{"BGSAVE", "SCHEDULE"}, "+Background saving scheduled\r\n"},
+		{[]string{"BGSAVE", "NOW"}, "-ERR syntax error\r\n"},
+	}
+	for _, step := range steps {
+		if reply := run(step.args...); reply != step.want {
+			t.Fatalf("expected %v to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	if !strings.Contains(run("INFO", "persistence"), "rdb_bgsave_in_progress:1\r\n") {
+		t.Fatalf("expected a background save in progress")
+	}
+	if !saves.end(nil, os.ErrPermission) {
+		t.Fatalf("expected the scheduled save to be reported")
+	}
+	if !strings.Contains(run("INFO", "persistence"), "rdb_last_bgsave_status:err\r\n") {
+		t.Fatalf("expected the failed save to be reported")
+	}
+
+	// Test 5: dir must be an existing directory and dbfilename a file name
+	for _, args := range [][]string{
+		{"CONFIG", "SET", "dir", filepath.Join(dir, "missing")},
+		{"CONFIG", "SET", "dbfilename", "sub/dump.rdb"},
+	} {
+		if reply := run(args...); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("expected %v to be refused, got %q", args, reply)
+		}
+	}
+	if reply := run("CONFIG", "GET", "dir"); reply != "*2\r\n$3\r\ndir\r\n"+formatBulkString(dir) {
+		t.Fatalf("unexpected CONFIG GET dir reply %q", reply)
+	}
+}
+
+// loadDumpFile reads a dump file into new databases
+func loadDumpFile(t *testing.T, path string, count int) *Databases {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected the dump file to exist: %v", err)
+	}
+	defer f.Close()
+	dbs := NewDatabases(count)
+	_, err = readRDB(bufio.NewReader(f), func(db int, key string, entry *Entry) error {
+		dbs.Get(db).Load(key, entry)
+		return nil
+	}, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("expected the dump file to load: %v", err)
+	}
+	return dbs
+}
+
+func TestSnapshotLocking(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+	client := &Client{}
+	client.execute([]string{"SET", "a", "1"})
+	databases.Get(1).Set("b", "2")
+
+	// Test 1: A database already collected is written to while the snapshot
+	// waits for the next one, and those writes count as unsaved
+	databases.Get(1).mu.Lock()
+	taken := make(chan *rdbSnapshot)
+	go func() { taken <- takeSnapshot(databases) }()
+	time.Sleep(10 * time.Millisecond)
+	written := make(chan string)
+	go func() { written <- client.execute([]string{"SET", "c", "3"}) }()
+	select {
+	case reply := <-written:
+		if reply != "+OK\r\n" {
+			t.Fatalf("expected SET to succeed, got %q", reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the write to db 0 not to wait for the snapshot")
+	}
+	databases.Get(1).mu.Unlock()
+	snap := <-taken
+	defer snap.release()
+	if len(snap.dbs) != 2 || len(snap.dbs[1]) != 1 || snap.changes >= keyChanges.Load() {
+		t.Fatalf("expected both databases with the last write unsaved, got %v and %d changes", snap.dbs, snap.changes)
+	}
+}
+
+func TestLoadDumpOnStartup(t *testing.T) {
+	saved, savedSaves, savedDir := databases, saves, dirOverride.Load()
+	databases = NewDatabases(2)
+	saves = &saveState{lastSave: startTime, lastOK: true}
+	defer func() {
+		databases, saves = saved, savedSaves
+		dirOverride.Store(savedDir)
+	}()
+	vc := NewVirtualClock(time.Now())
+	SetClock(vc)
+	defer SetClock(nil)
+
+	dir := t.TempDir()
+	dirOverride.Store(&dir)
+	client := &Client{}
+
+	// Test 1: Without a dump file, nothing is loaded
+	if err := loadDumpOnStartup(); err != nil {
+		t.Fatalf("expected a missing dump file to be skipped, got %v", err)
+	}
+
+	// Test 2: Keys are loaded with their TTLs, except those that expired
+	// since the save, and do not count as changes
+	databases.Get(0).Restore("kept", &Entry{Type: TypeString, Value: "v", ExpiresAt: vc.Now().Add(100 * time.Second)}, false)
+	databases.Get(0).Restore("gone", &Entry{Type: TypeString, Value: "v", ExpiresAt: vc.Now().Add(500 * time.Millisecond)}, false)
+	client.execute([]string{"HSET", "hash", "f", "v"})
+	if reply := client.execute([]string{"SAVE"}); reply != "+OK\r\n" {
+		t.Fatalf("expected SAVE to succeed, got %q", reply)
+	}
+	vc.Advance(time.Second)
+	databases = NewDatabases(2)
+	if err := loadDumpOnStartup(); err != nil {
+		t.Fatalf("expected the dump file to load, got %v", err)
+	}
+	if reply := client.execute([]string{"DBSIZE"}); reply != ":2\r\n" {
+		t.Fatalf("expected two keys loaded, got %q", reply)
+	}
+	if reply := client.execute([]string{"TTL", "kept"}); reply != ":99\r\n" {
+		t.Fatalf("expected the TTL to be kept, got %q", reply)
+	}
+	if !strings.Contains(client.execute([]string{"INFO", "persistence"}), "rdb_changes_since_last_save:0\r\n") {
+		t.Fatalf("expected loaded keys not to count as changes")
+	}
+	loaded, expired, err := loadDump(filepath.Join(dir, defaultDBFilename), NewDatabases(2))
+	if err != nil || loaded != 2 || expired != 1 {
+		t.Fatalf("expected 2 keys loaded and 1 expired, got %d %d %v", loaded, expired, err)
+	}
+
+	// Test 3: A dump file with more databases than configured, or a
+	// corrupt one, fails the startup
+	client.execute([]string{"SELECT", "1"})
+	client.execute([]string{"SET", "k", "v"})
+	client.execute([]string{"SAVE"})
+	databases = NewDatabases(1)
+	if err := loadDumpOnStartup(); err == nil || !strings.Contains(err.Error(), "only 1 are configured") {
+		t.Fatalf("expected the extra database to be refused, got %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, defaultDBFilename), []byte("REDIS0009garbage"), 0o644)
+	if err := loadDumpOnStartup(); err == nil {
+		t.Fatalf("expected a corrupt dump file to be refused")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// savePoint is one "save <seconds> <changes>" rule: a BGSAVE starts once
+// Changes keys were written and Seconds passed since the last save
+type savePoint struct {
+	Seconds int
+	Changes int
+}
+
+// Save points when none are configured, as in Redis: after an hour with one
+// change, five minutes with 100 or a minute with 10000
+var defaultSavePoints = []savePoint{{3600, 1}, {300, 100}, {60, 10000}}
+
+// How often the save points are checked
+const savePointInterval = 100 * time.Millisecond
+
+// Time a failed BGSAVE is given before save points try again, as in Redis
+const savePointRetryDelay = 5 * time.Second
+
+// Save points set by CONFIG SET, overriding the configuration file's
+var savePointsOverride atomic.Pointer[[]savePoint]
+
+// savePoints returns the save points in effect
+func savePoints() []savePoint {
+	if points := savePointsOverride.Load(); points != nil {
+		return *points
+	}
+	return config.SavePoints
+}
+
+// parseSavePoints parses "<seconds> <changes>" pairs; no pairs (or a single
+// "" argument) disable automatic saves
+func parseSavePoints(args []string) ([]savePoint, error) {
+	if len(args) == 1 && (args[0] == "" || args[0] == `""`) {
+		return []savePoint{}, nil
+	}
+	if len(args)%2 != 0 {
+		return nil, errors.New("invalid save parameters: expected <seconds> <changes> pairs")
+	}
+	points := make([]savePoint, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		seconds, err1 := strconv.Atoi(args[i])
+		changes, err2 := strconv.Atoi(args[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters '%s %s'", args[i], args[i+1])
+		}
+		points = append(points, savePoint{seconds, changes})
+	}
+	return points, nil
+}
+
+// formatSavePoints formats save points as CONFIG GET save reports them
+func formatSavePoints(points []savePoint) string {
+	fields := make([]string, 0, len(points)*2)
+	for _, p := range points {
+		fields = append(fields, strconv.Itoa(p.Seconds), strconv.Itoa(p.Changes))
+	}
+	return strings.Join(fields, " ")
+}
+
+// dueSavePoint returns the first save point reached at now, if any
+// A failed BGSAVE holds the save points back for savePointRetryDelay.
+func dueSavePoint(now time.Time) (savePoint, bool) {
+	points := savePoints()
+	if len(points) == 0 {
+		return savePoint{}, false
+	}
+	saves.mu.Lock()
+	defer saves.mu.Unlock()
+	if saves.inProgress || (!saves.lastOK && now.Sub(saves.started) < savePointRetryDelay) {
+		return savePoint{}, false
+	}
+	changes := keyChanges.Load() - saves.savedChanges
+	for _, p := range points {
+		if changes >= int64(p.Changes) && now.Sub(saves.lastSave) >= time.Duration(p.Seconds)*time.Second {
+			return p, true
+		}
+	}
+	return savePoint{}, false
+}
+
+// savePointLoop starts a BGSAVE whenever a save point is reached
+func savePointLoop() {
+	ticker := time.NewTicker(savePointInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if loading.active.Load() {
+			continue // What is loading is already on disk
+		}
+		if p, ok := dueSavePoint(now); ok {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", p.Changes, p.Seconds)
+			startBackgroundSave()
+		}
+	}
+}
+
+// Parameters of CONFIG GET and CONFIG SET for the save points
+var savePointConfigParams = []configParam{
+	{
+		name: "save",
+		get:  func() string { return formatSavePoints(savePoints()) },
+		set: func(value string) error {
+			points, err := parseSavePoints(strings.Fields(value))
+			if err != nil {
+				return err
+			}
+			savePointsOverride.Store(&points)
+			return nil
+		},
+	},
+}
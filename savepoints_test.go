@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSavePoints(t *testing.T) {
+	saved, savedSaves, savedPoints := databases, saves, savePointsOverride.Load()
+	databases = NewDatabases(1)
+	defer func() {
+		databases, saves = saved, savedSaves
+		savePointsOverride.Store(savedPoints)
+	}()
+
+	// Test 1: save directives replace the default save points, and save ""
+	// disables them
+	cfg, err := ParseConfig(strings.NewReader("save 900 1\nsave 300 10 60 1000\n"))
+	if err != nil {
+		t.Fatalf("expected save directives to parse, got %v", err)
+	}
+	if want := []savePoint{{900, 1}, {300, 10}, {60, 1000}}; !reflect.DeepEqual(cfg.SavePoints, want) {
+		t.Fatalf("expected save points %v, got %v", want, cfg.SavePoints)
+	}
+	if cfg, _ = ParseConfig(strings.NewReader(`save ""` + "\n")); len(cfg.SavePoints) != 0 {
+		t.Fatalf("expected no save points, got %v", cfg.SavePoints)
+	}
+	if !reflect.DeepEqual(DefaultConfig().SavePoints, defaultSavePoints) {
+		t.Fatalf("expected the default save points without a save directive")
+	}
+	for _, input := range []string{"save 900\n", "save 0 1\n", "save 900 x\n"} {
+		if _, err := ParseConfig(strings.NewReader(input)); err == nil {
+			t.Fatalf("expected %q to be refused", input)
+		}
+	}
+
+	// Test 2: CONFIG SET save replaces them at runtime
+	client := &Client{}
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"CONFIG", "SET", "save", "10 2 3600 1"}, "+OK\r\n"},
+		{[]string{"CONFIG", "GET", "save"}, "*2\r\n$4\r\nsave\r\n$11\r\n10 2 3600 1\r\n"},
+		{[]string{"CONFIG", "SET", "save", "10"}, "-ERR"},
+	}
+	for _, step := range steps {
+		if reply := client.execute(step.args); !strings.HasPrefix(reply, step.want) {
+			t.Fatalf("expected %v to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 3: A save point is due once both its changes and its seconds
+	// are reached since the last save
+	lastSave := time.Now()
+	saves = &saveState{lastSave: lastSave, lastOK: true, savedChanges: keyChanges.Load()}
+	client.execute([]string{"SET", "a", "1"})
+	if _, ok := dueSavePoint(lastSave.Add(20 * time.Second)); ok {
+		t.Fatalf("expected no save point due after one change")
+	}
+	client.execute([]string{"SET", "b", "1"})
+	if _, ok := dueSavePoint(lastSave.Add(5 * time.Second)); ok {
+		t.Fatalf("expected no save point due after 5 seconds")
+	}
+	if p, ok := dueSavePoint(lastSave.Add(10 * time.Second)); !ok || p != (savePoint{10, 2}) {
+		t.Fatalf("expected save point 10 2 to be due, got %v %v", p, ok)
+	}
+
+	// Test 4: A failed BGSAVE holds the save points back for a while, and
+	// none is due while a save runs
+	saves.lastOK, saves.started = false, lastSave.Add(8*time.Second)
+	if _, ok := dueSavePoint(lastSave.Add(10 * time.Second)); ok {
+		t.Fatalf("expected no retry right after a failed save")
+	}
+	if _, ok := dueSavePoint(lastSave.Add(15 * time.Second)); !ok {
+		t.Fatalf("expected a retry after the delay")
+	}
+	saves.inProgress = true
+	if _, ok := dueSavePoint(lastSave.Add(time.Hour)); ok {
+		t.Fatalf("expected no save point due while a save runs")
+	}
+	saves.inProgress = false
+
+	// Test 5: With no save points, nothing is ever due
+	client.execute([]string{"CONFIG", "SET", "save", ""})
+	if _, ok := dueSavePoint(lastSave.Add(time.Hour)); ok {
+		t.Fatalf("expected no save point due once disabled")
+	}
+	if reply := client.execute([]string{"CONFIG", "GET", "save"}); reply != "*2\r\n$4\r\nsave\r\n$0\r\n\r\n" {
+		t.Fatalf("unexpected CONFIG GET save reply %q", reply)
+	}
+}
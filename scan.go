@@ -0,0 +1,352 @@
+package main
+
+import (
+	"cmp"
+	"hash/maphash"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default number of keys a single SCAN call aims to return
+const scanDefaultCount = 10
+
+// Most keys space is reserved for up front; COUNT is only a hint, and clients
+// may pass any integer
+const scanMaxPrealloc = 1024
+
+// redisTypeNames maps entry types to the names clients see (TYPE replies, SCAN TYPE filters)
+var redisTypeNames = map[string]string{
+	TypeString:    "string",
+	TypeList:      "list",
+	TypeSet:       "set",
+	TypeHash:      "hash",
+	TypeSortedSet: "zset",
+	TypeStream:    "stream",
+}
+
+// ScanOptions holds the optional filters of a SCAN, SSCAN, HSCAN or ZSCAN call
+type ScanOptions struct {
+	Count    int    // Hint for how many keys to return
+	Pattern  string // Glob pattern keys must match (empty means all)
+	Type     string // Entry type keys must have (empty means all)
+	NoValues bool   // HSCAN NOVALUES: return hash fields without their values
+}
+
+// Scan performs one step of a cursor-based iteration over the keyspace.
+// Pass cursor 0 to start; the iteration is complete when the returned cursor is 0.
+// Iterations filtered by a collection type use that type's own cursors.
+// Every key present for the whole duration of an iteration is returned at least
+// once, but keys may be returned more than once. Expired keys are never returned.
+func (s *Store) Scan(cursor uint64, opts ScanOptions) ([]string, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := opts.Count
+	if count <= 0 {
+		count = scanDefaultCount
+	}
+
+	keys := make([]string, 0, min(count, scanMaxPrealloc))
+	now := clockNow()
+	// A TYPE filter walks that type's index, so other keys cost nothing
+	table := s.data.scanTable(opts.Type)
+	if table == nil {
+		return keys, 0
+	}
+	// Bound the work done on sparse tables: stop after visiting this many buckets
+	maxVisits := min(count, math.MaxInt/10) * 10
+	for visits := 0; visits < maxVisits; visits++ {
+		cursor = table.scanBucket(cursor, func(key string, entry *Entry) {
+			if entry.expired(now) {
+				return
+			}
+			if opts.Type != "" && entry.Type != opts.Type {
+				return
+			}
+			if opts.Pattern != "" && !matchPattern(opts.Pattern, key) {
+				return
+			}
+			keys = append(keys, key)
+		})
+		if cursor == 0 || len(keys) >= count {
+			break
+		}
+	}
+
+	return keys, cursor
+}
+
+// parseScanOptions parses the options following the cursor of SCAN (when
+// entryType is empty) or of SSCAN, HSCAN and ZSCAN. It returns an error reply
+// if they are invalid.
+func parseScanOptions(args []string, entryType string) (ScanOptions, string) {
+	opts := ScanOptions{Count: scanDefaultCount}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "NOVALUES" && entryType == TypeHash {
+			opts.NoValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, formatError("ERR syntax error")
+		}
+		i++
+		value := args[i]
+		switch {
+		case option == "MATCH":
+			if value != "*" {
+				opts.Pattern = value
+			}
+		case option == "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, formatError("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return opts, formatError("ERR syntax error")
+			}
+			opts.Count = count
+		case option == "TYPE" && entryType == "":
+			t, ok := entryTypeByName(value)
+			if !ok {
+				return opts, formatError("ERR unknown type name '" + value + "'")
+			}
+			opts.Type = t
+		default:
+			return opts, formatError("ERR syntax error")
+		}
+	}
+	return opts, ""
+}
+
+// scanCommand implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scanCommand(c *Client, args []string) string {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return formatError("ERR invalid cursor")
+	}
+	opts, errReply := parseScanOptions(args[2:], "")
+	if errReply != "" {
+		return errReply
+	}
+
+	keys, next := c.db().Scan(cursor, opts)
+	return "*2\r\n" + formatBulkString(strconv.FormatUint(next, 10)) + formatArray(keys)
+}
+
+// Seed of the element hashes collection cursors point into. It is fixed for
+// the life of the process, so cursors stay valid from one call to the next.
+var collectionScanSeed = maphash.MakeSeed()
+
+// Collection cursors are positions in a 63-bit hash space; each call returns
+// the elements whose hash falls between its cursor and the next one
+const collectionCursorSpace = uint64(1) << 63
+
+// collectionElements returns the number of elements of a collection value,
+// whether they come with a value (hash fields), and a function visiting them
+func collectionElements(value any) (int, bool, func(yield func(member, value string) bool)) {
+	switch v := value.(type) {
+	case map[string]struct{}:
+		return len(v), false, func(yield func(member, value string) bool) {
+			for member := range v {
+				if !yield(member, "") {
+					return
+				}
+			}
+		}
+	case *intset:
+		return v.len(), false, func(yield func(member, value string) bool) {
+			v.each(func(member string) bool { return yield(member, "") })
+		}
+	case map[string]string:
+		return len(v), true, func(yield func(member, value string) bool) {
+			for field, value := range v {
+				if !yield(field, value) {
+					return
+				}
+			}
+		}
+	case *hashPack:
+		return v.len(), true, func(yield func(member, value string) bool) {
+			v.each(yield)
+		}
+	case *zset:
+		return v.len(), true, func(yield func(member, value string) bool) {
+			v.each(func(member string, score float64) bool { return yield(member, formatScore(score)) })
+		}
+	}
+	return 0, false, func(func(member, value string) bool) {}
+}
+
+// ScanCollection performs one step of a cursor-based iteration over the
+// elements of the collection of type entryType stored at key, as SSCAN, HSCAN
+// and ZSCAN do. Collections of at most Count elements are returned whole on
+// the first call. Larger ones are walked in the order of their element hashes,
+// Count elements at a time, the cursor being the hash to resume from, so the
+// reply of each call stays small and the cursor is valid whatever is changed
+// in between: every element present for the whole iteration is returned once.
+// The order comes from a scan index of the collection, built by the first
+// call and kept until the collection is written. Hash elements are returned
+// as field/value pairs unless NoValues is set.
+// Returns (elements, next cursor, isCorrectType)
+func (s *Store) ScanCollection(key, entryType string, cursor uint64, opts ScanOptions) ([]string, uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elements := []string{}
+	entry := s.data.get(key)
+	if entry == nil {
+		return elements, 0, true
+	}
+	if entry.Type != entryType {
+		return nil, 0, false
+	}
+	if cursor >= collectionCursorSpace {
+		return elements, 0, true
+	}
+
+	count := opts.Count
+	if count <= 0 {
+		count = scanDefaultCount
+	}
+	n, pairs, each := collectionElements(entry.Value)
+	pairs = pairs && !opts.NoValues
+	emit := func(member, value string) {
+		if opts.Pattern != "" && !matchPattern(opts.Pattern, member) {
+			return
+		}
+		elements = append(elements, member)
+		if pairs {
+			elements = append(elements, value)
+		}
+	}
+
+	if n <= count {
+		each(func(member, value string) bool {
+			if cursor == 0 || collectionHash(member) >= cursor {
+				emit(member, value)
+			}
+			return true
+		})
+		return elements, 0, true
+	}
+
+	index := s.scanIndex(key, entry, n, each)
+	i := sort.Search(len(index.elements), func(i int) bool { return index.elements[i].hash >= cursor })
+	for visited := 0; i < len(index.elements); i++ {
+		e := index.elements[i]
+		// Elements of the same hash share a cursor, so they go together
+		if visited >= count && e.hash != index.elements[i-1].hash {
+			return elements, e.hash, true
+		}
+		emit(e.member, e.value)
+		visited++
+	}
+	return elements, 0, true
+}
+
+// collectionHash returns the position of a member in the cursor space
+func collectionHash(member string) uint64 {
+	return maphash.String(collectionScanSeed, member) >> 1
+}
+
+// collectionIndex is the elements of a collection sorted by collectionHash, so
+// that each page of a scan finds its cursor by binary search and visits only
+// what it returns
+type collectionIndex struct {
+	entry    *Entry // Entry the index was built from
+	elements []collectionIndexElement
+}
+
+type collectionIndexElement struct {
+	hash          uint64
+	member, value string
+}
+
+// Most collection indexes a store keeps at once; beyond it one is dropped at random
+const collectionIndexLimit = 16
+
+// scanIndex returns the scan index of the collection entry stored at key,
+// building it if there is none yet. Callers must hold the read lock.
+func (s *Store) scanIndex(key string, entry *Entry, n int, each func(yield func(member, value string) bool)) *collectionIndex {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	if index := s.scanIndexes[key]; index != nil && index.entry == entry {
+		return index
+	}
+	index := &collectionIndex{entry: entry, elements: make([]collectionIndexElement, 0, n)}
+	each(func(member, value string) bool {
+		index.elements = append(index.elements, collectionIndexElement{hash: collectionHash(member), member: member, value: value})
+		return true
+	})
+	slices.SortFunc(index.elements, func(a, b collectionIndexElement) int { return cmp.Compare(a.hash, b.hash) })
+
+	if s.scanIndexes == nil {
+		s.scanIndexes = make(map[string]*collectionIndex)
+	}
+	if _, ok := s.scanIndexes[key]; !ok && len(s.scanIndexes) >= collectionIndexLimit {
+		for other := range s.scanIndexes {
+			delete(s.scanIndexes, other)
+			break
+		}
+	}
+	s.scanIndexes[key] = index
+	return index
+}
+
+// dropScanIndex forgets the scan index of key, whose value changed. Callers
+// must hold the write lock, so no scan is using the indexes.
+func (s *Store) dropScanIndex(key string) {
+	if s.scanIndexes != nil {
+		delete(s.scanIndexes, key)
+	}
+}
+
+// collectionScanReply implements SSCAN, HSCAN and ZSCAN:
+// <command> key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func collectionScanReply(c *Client, args []string, entryType string) string {
+	cursor, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return formatError("ERR invalid cursor")
+	}
+	opts, errReply := parseScanOptions(args[3:], entryType)
+	if errReply != "" {
+		return errReply
+	}
+
+	elements, next, ok := c.db().ScanCollection(args[1], entryType, cursor, opts)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return "*2\r\n" + formatBulkString(strconv.FormatUint(next, 10)) + formatArray(elements)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func sscanCommand(c *Client, args []string) string {
+	return collectionScanReply(c, args, TypeSet)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func hscanCommand(c *Client, args []string) string {
+	return collectionScanReply(c, args, TypeHash)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func zscanCommand(c *Client, args []string) string {
+	return collectionScanReply(c, args, TypeSortedSet)
+}
+
+// entryTypeByName resolves a client-facing type name to the entry type constant
+func entryTypeByName(name string) (string, bool) {
+	name = strings.ToLower(name)
+	for entryType, redisName := range redisTypeNames {
+		if redisName == name {
+			return entryType, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:*:end", "a:b:end", true},
+		{"", "", true},
+	}
+
+	for _, c := range cases {
+		if got := matchPattern(c.pattern, c.str); got != c.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", c.pattern, c.str, got, c.want)
+		}
+	}
+}
+
+// scanAll runs a full SCAN iteration and returns how often each key was seen
+func scanAll(s *Store, opts ScanOptions) map[string]int {
+	seen := make(map[string]int)
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor = s.Scan(cursor, opts)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			return seen
+		}
+	}
+}
+
+func TestStoreScan(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: A full iteration returns every key
+	for i := 0; i < 1000; i++ {
+		store.Set(fmt.Sprintf("key:%d", i), "v")
+	}
+	seen := scanAll(store, ScanOptions{Count: 7})
+	for i := 0; i < 1000; i++ {
+		if seen[fmt.Sprintf("key:%d", i)] == 0 {
+			t.Fatalf("key:%d was not returned by SCAN", i)
+		}
+	}
+
+	// Test 2: MATCH and TYPE filters
+	store.SetForTesting("list:1", TypeList, []string{"a"})
+	seen = scanAll(store, ScanOptions{Pattern: "list:*"})
+	if len(seen) != 1 || seen["list:1"] != 1 {
+		t.Fatalf("expected only list:1 for MATCH list:*, got %v", seen)
+	}
+	seen = scanAll(store, ScanOptions{Type: TypeList})
+	if len(seen) != 1 || seen["list:1"] != 1 {
+		t.Fatalf("expected only list:1 for TYPE list, got %v", seen)
+	}
+
+	// Test 3: Empty store finishes immediately
+	keys, cursor := NewStore().Scan(0, ScanOptions{})
+	if len(keys) != 0 || cursor != 0 {
+		t.Fatalf("expected ([], 0) for empty store, got (%v, %d)", keys, cursor)
+	}
+
+	// Test 4: A huge COUNT is only a hint: the whole keyspace comes back at once
+	keys, cursor = store.Scan(0, ScanOptions{Count: math.MaxInt})
+	if len(keys) != 1001 || cursor != 0 {
+		t.Fatalf("expected every key in one call, got %d keys and cursor %d", len(keys), cursor)
+	}
+}
+
+func TestStoreScanDuringResize(t *testing.T) {
+	store := NewStore()
+	for i := 0; i < 500; i++ {
+		store.Set(fmt.Sprintf("stable:%d", i), "v")
+	}
+
+	// Writers grow and then shrink the table while the scan is running
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20000; i++ {
+			store.Set(fmt.Sprintf("churn:%d", i), "v")
+		}
+		for i := 0; i < 20000; i++ {
+			store.Del(fmt.Sprintf("churn:%d", i))
+		}
+	}()
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor = store.Scan(cursor, ScanOptions{Count: 5})
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < 500; i++ {
+		if seen[fmt.Sprintf("stable:%d", i)] == 0 {
+			t.Fatalf("stable:%d was missed while the table was resizing", i)
+		}
+	}
+}
+
+func TestScanTypeIndex(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 5000; i++ {
+		s.Set(fmt.Sprintf("str:%d", i), "v")
+	}
+	for i := 0; i < 20; i++ {
+		s.Push(fmt.Sprintf("list:%d", i), false, "a")
+	}
+	s.SAdd("set:0", "m")
+
+	// Test 1: A TYPE scan visits only the index of that type
+	keys, cursor := s.Scan(0, ScanOptions{Count: 100, Type: TypeList})
+	if len(keys) != 20 || cursor != 0 {
+		t.Fatalf("expected all 20 lists in one call, got %d keys and cursor %d", len(keys), cursor)
+	}
+	if seen := scanAll(s, ScanOptions{Type: TypeSet}); len(seen) != 1 || seen["set:0"] != 1 {
+		t.Fatalf("expected only set:0, got %v", seen)
+	}
+	if seen := scanAll(s, ScanOptions{Type: TypeString, Count: 500}); len(seen) != 5000 {
+		t.Fatalf("expected 5000 strings, got %d", len(seen))
+	}
+	if keys, cursor := s.Scan(0, ScanOptions{Type: TypeHash}); len(keys) != 0 || cursor != 0 {
+		t.Fatalf("expected no hashes, got %v", keys)
+	}
+
+	// Test 2: Deletions and type changes keep the indexes and counts exact
+	s.Del("list:0", "list:1", "str:0")
+	s.Convert("list:2", TypeSet, convertDefaultField)
+	if seen := scanAll(s, ScanOptions{Type: TypeList}); len(seen) != 17 || seen["list:2"] != 0 {
+		t.Fatalf("expected 17 lists without list:2, got %d", len(seen))
+	}
+	if seen := scanAll(s, ScanOptions{Type: TypeSet}); len(seen) != 2 || seen["list:2"] != 1 {
+		t.Fatalf("expected list:2 to be indexed as a set, got %v", seen)
+	}
+	counts := s.TypeCounts()
+	if counts[TypeString] != 4999 || counts[TypeList] != 17 || counts[TypeSet] != 2 {
+		t.Fatalf("unexpected type counts %v", counts)
+	}
+
+	// Test 3: Emptied collections leave their index
+	for i := 3; i < 20; i++ {
+		s.Pop(fmt.Sprintf("list:%d", i), true, 1)
+	}
+	if _, ok := s.TypeCounts()[TypeList]; ok {
+		t.Fatalf("expected no list count once every list is gone")
+	}
+	if keys, _ := s.Scan(0, ScanOptions{Type: TypeList}); len(keys) != 0 {
+		t.Fatalf("expected an empty list index, got %v", keys)
+	}
+}
+
+func TestCollectionScan(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	db := databases.Get(0)
+	client := &Client{}
+	members := make([]string, 1000)
+	for i := range members {
+		members[i] = fmt.Sprintf("m%d", i)
+	}
+	db.SAdd("big", members...)
+
+	// scanSet walks big with SSCAN and returns how often each member was seen
+	scanSet := func(during func()) (map[string]int, int) {
+		seen := make(map[string]int)
+		calls := 0
+		for cursor := uint64(0); ; calls++ {
+			elements, next, ok := db.ScanCollection("big", TypeSet, cursor, ScanOptions{Count: 50})
+			if !ok {
+				t.Fatalf("expected a set")
+			}
+			for _, m := range elements {
+				seen[m]++
+			}
+			if during != nil {
+				during()
+			}
+			if cursor = next; cursor == 0 {
+				return seen, calls + 1
+			}
+		}
+	}
+
+	// Test 1: Every member is returned exactly once, a few dozen at a time
+	seen, calls := scanSet(nil)
+	if len(seen) != 1000 || calls < 10 {
+		t.Fatalf("expected 1000 members over several calls, got %d in %d calls", len(seen), calls)
+	}
+	for m, n := range seen {
+		if n != 1 {
+			t.Fatalf("expected %s once, got %d", m, n)
+		}
+	}
+
+	// Test 2: Members present throughout are returned even while the set changes
+	added := 0
+	seen, _ = scanSet(func() {
+		db.SAdd("big", fmt.Sprintf("new%d", added))
+		db.SRem("big", fmt.Sprintf("m%d", 999-added))
+		added++
+	})
+	for i := 0; i < 1000-added; i++ {
+		if seen[fmt.Sprintf("m%d", i)] != 1 {
+			t.Fatalf("expected m%d to be returned once", i)
+		}
+	}
+
+	// Test 3: Small collections come back whole, with MATCH applied
+	db.Restore("h", &Entry{Type: TypeHash, Value: map[string]string{"name": "ann", "nick": "a", "age": "7"}}, false)
+	reply := client.execute([]string{"HSCAN", "h", "0", "MATCH", "n*"})
+	if !strings.HasPrefix(reply, "*2\r\n$1\r\n0\r\n*4\r\n") || !strings.Contains(reply, "$4\r\nname\r\n$3\r\nann\r\n") {
+		t.Fatalf("expected both n* fields with values, got %q", reply)
+	}
+	reply = client.execute([]string{"HSCAN", "h", "0", "NOVALUES"})
+	if !strings.HasPrefix(reply, "*2\r\n$1\r\n0\r\n*3\r\n") || strings.Contains(reply, "ann") {
+		t.Fatalf("expected three fields without values, got %q", reply)
+	}
+
+	// Test 4: Missing keys, wrong types and bad options
+	if reply := client.execute([]string{"SSCAN", "missing", "0"}); reply != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Fatalf("expected an empty scan, got %q", reply)
+	}
+	if reply := client.execute([]string{"ZSCAN", "big", "0"}); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+	if reply := client.execute([]string{"SSCAN", "big", "0", "NOVALUES"}); reply != "-ERR syntax error\r\n" {
+		t.Fatalf("expected NOVALUES to be refused for sets, got %q", reply)
+	}
+	if reply := client.execute([]string{"SSCAN", "big", "x"}); reply != "-ERR invalid cursor\r\n" {
+		t.Fatalf("expected an invalid cursor error, got %q", reply)
+	}
+
+	// Test 5: Any cursor and COUNT end the scan, with no element skipped
+	db.SAdd("one", "x")
+	if reply := client.execute([]string{"SSCAN", "one", "4611686018427387904", "COUNT", "4"}); !strings.HasPrefix(reply, "*2\r\n$1\r\n0\r\n") {
+		t.Fatalf("expected the scan to end, got %q", reply)
+	}
+	client.execute([]string{"ZADD", "z", "1", "a", "2", "b"})
+	if reply := client.execute([]string{"ZSCAN", "z", "0", "COUNT", strconv.Itoa(math.MaxInt64)}); !strings.HasPrefix(reply, "*2\r\n$1\r\n0\r\n*4\r\n") {
+		t.Fatalf("expected both members, got %q", reply)
+	}
+	if elements, next, _ := db.ScanCollection("big", TypeSet, 0, ScanOptions{Count: math.MaxInt}); len(elements) != 1000 || next != 0 {
+		t.Fatalf("expected the whole set, got %d members and cursor %d", len(elements), next)
+	}
+
+	// Test 6: Pages share the collection's index until it is written
+	_, next, _ := db.ScanCollection("big", TypeSet, 0, ScanOptions{Count: 10})
+	index := db.scanIndexes["big"]
+	db.ScanCollection("big", TypeSet, next, ScanOptions{Count: 10})
+	if index == nil || db.scanIndexes["big"] != index {
+		t.Fatalf("expected the second page to reuse the index")
+	}
+	db.SAdd("big", "added")
+	if db.scanIndexes["big"] != nil {
+		t.Fatalf("expected a write to drop the index")
+	}
+}
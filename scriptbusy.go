@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Time a script may run before other clients get BUSY replies when
+// busy-reply-threshold is not configured, as in Redis
+const defaultBusyReplyThreshold = 5 * time.Second
+
+// Threshold set by CONFIG SET, overriding the configuration file's
+var busyThresholdOverride atomic.Pointer[time.Duration]
+
+// busyReplyThreshold returns how long a script runs before it is busy, 0
+// if scripts never are
+func busyReplyThreshold() time.Duration {
+	if threshold := busyThresholdOverride.Load(); threshold != nil {
+		return *threshold
+	}
+	return config.BusyReplyThreshold
+}
+
+// Error of commands refused while a script is busy
+const busyError = "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."
+
+// Commands that run while a script is busy. They read and write no keys, so
+// they never wait for the transaction lock.
+var busyAllowedCommands = map[string]bool{
+	"script|kill": true, "function|kill": true, "shutdown": true, "auth": true, "hello": true, "quit": true,
+}
+
+// scriptRun is the state of the running script SCRIPT KILL acts on
+type scriptRun struct {
+	cancel context.CancelFunc // Stops the interpreter
+	mu     sync.Mutex
+	wrote  bool // Set once one of its commands changed a key: killing it would leave a partial write
+	killed bool // Set by SCRIPT KILL
+	done   bool // Set when the script ends
+}
+
+// Script running, nil between scripts
+var runningScript atomic.Pointer[scriptRun]
+
+// startScript registers the script about to run in L, which is marked busy
+// if it runs past busy-reply-threshold
+// Returns it and the function to call once it ends
+func startScript(L *lua.LState) (*scriptRun, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &scriptRun{cancel: cancel}
+	L.SetContext(ctx)
+	runningScript.Store(run)
+
+	var timer *time.Timer
+	if threshold := busyReplyThreshold(); threshold > 0 {
+		timer = time.AfterFunc(threshold, func() {
+			run.mu.Lock()
+			defer run.mu.Unlock()
+			if !run.done {
+				fmt.Printf("Slow script detected: still in execution after %d milliseconds. You can try killing the script using the SCRIPT KILL command.\n", threshold.Milliseconds())
+				execMu.setBusy(true)
+			}
+		})
+	}
+	return run, func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		run.mu.Lock()
+		run.done = true
+		run.mu.Unlock()
+		execMu.setBusy(false)
+		runningScript.Store(nil)
+		L.RemoveContext()
+		cancel()
+	}
+}
+
+// markWrite records that one of the script's commands changed a key
+func (run *scriptRun) markWrite() {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.wrote = true
+}
+
+// wasKilled reports whether SCRIPT KILL stopped the script
+func (run *scriptRun) wasKilled() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.killed
+}
+
+// killScript stops the running script, unless it wrote to the dataset
+// Returns the reply of SCRIPT KILL
+func killScript() string {
+	run := runningScript.Load()
+	if run == nil {
+		return formatError("NOTBUSY No scripts in execution right now.")
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	switch {
+	case run.done:
+		return formatError("NOTBUSY No scripts in execution right now.")
+	case run.wrote:
+		return formatError("UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+			"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+	run.killed = true
+	run.cancel()
+	return formatSimpleString("OK")
+}
+
+// parseBusyReplyThreshold parses busy-reply-threshold, in milliseconds
+func parseBusyReplyThreshold(name, value string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Parameters of CONFIG GET and CONFIG SET for the busy script threshold,
+// under its current name and the older lua-time-limit
+var busyConfigParams = []configParam{busyConfigParam("busy-reply-threshold"), busyConfigParam("lua-time-limit")}
+
+func busyConfigParam(name string) configParam {
+	return configParam{
+		name: name,
+		get:  func() string { return strconv.FormatInt(busyReplyThreshold().Milliseconds(), 10) },
+		set: func(value string) error {
+			threshold, err := parseBusyReplyThreshold(name, value)
+			if err != nil {
+				return err
+			}
+			busyThresholdOverride.Store(&threshold)
+			return nil
+		},
+	}
+}
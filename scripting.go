@@ -0,0 +1,518 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Name scripts are compiled under, which prefixes their error positions
+const scriptChunkName = "user_script"
+
+// Interpreter every script runs in, created by the first one. Scripts run
+// one at a time holding execMu for writing, which guards it along with
+// scriptClient.
+var luaVM *lua.LState
+
+// Client the running script's redis.call commands execute as
+var scriptClient *Client
+
+// scriptReadOnly is set while the running script may not write: a function
+// registered with the no-writes flag
+var scriptReadOnly bool
+
+// scriptCache holds compiled scripts by the SHA1 of their source, in
+// lowercase hex, for EVALSHA
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*lua.FunctionProto
+}
+
+// Scripts run by EVAL or loaded by SCRIPT LOAD, until SCRIPT FLUSH
+var scripts = &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+
+func (sc *scriptCache) get(sha string) *lua.FunctionProto {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.scripts[sha]
+}
+
+func (sc *scriptCache) add(sha string, proto *lua.FunctionProto) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.scripts[sha] = proto
+}
+
+func (sc *scriptCache) len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.scripts)
+}
+
+// flush empties the cache
+func (sc *scriptCache) flush() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// load returns the cached script with the given source, compiling and
+// caching it if needed
+// Returns a non-empty error reply if it does not compile
+func (sc *scriptCache) load(source string) (string, *lua.FunctionProto, string) {
+	sha := sha1Hex(source)
+	if proto := sc.get(sha); proto != nil {
+		return sha, proto, ""
+	}
+	proto, err := compileScript(source)
+	if err != nil {
+		return "", nil, formatError("ERR Error compiling script (new function): " + singleLine(err.Error()))
+	}
+	sc.add(sha, proto)
+	return sha, proto, ""
+}
+
+// Commands scripts may not call: they change the connection rather than the
+// data, or would run a script or a transaction of their own
+var scriptForbidden = map[string]bool{
+	"multi": true, "exec": true, "discard": true, "watch": true, "unwatch": true,
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true,
+	"eval": true, "evalsha": true, "script": true, "waitaof": true,
+	"fcall": true, "fcall_ro": true, "function": true,
+	"auth": true, "hello": true, "quit": true, "reset": true,
+}
+
+// scriptWriteCommands are the commands callable from scripts that may change
+// the dataset, which read-only scripts may not call
+var scriptWriteCommands = map[string]bool{
+	"set": true, "mset": true, "del": true, "unlink": true, "copy": true, "move": true,
+	"restore": true, "pexpireat": true, "convert": true, "undelete": true, "flushdb": true, "flushall": true, "swapdb": true,
+	"bitop": true, "bitfield": true, "pfadd": true, "pfmerge": true,
+	"lpush": true, "rpush": true, "lpushx": true, "rpushx": true, "lpop": true, "rpop": true,
+	"lset": true, "linsert": true, "lrem": true, "ltrim": true, "lmove": true, "lmpop": true,
+	"blpop": true, "brpop": true, "blmove": true, "blmpop": true,
+	"sadd": true, "srem": true, "smove": true, "spop": true,
+	"sunionstore": true, "sinterstore": true, "sdiffstore": true,
+	"hset": true, "hsetnx": true, "hdel": true, "hincrby": true,
+	"zadd": true, "zincrby": true, "zrem": true, "zremrangebyrank": true, "zremrangebyscore": true,
+	"zremrangebylex": true, "zpopmin": true, "zpopmax": true, "zmpop": true, "bzpopmin": true,
+	"bzpopmax": true, "bzmpop": true, "zunionstore": true, "zinterstore": true, "zdiffstore": true,
+	"geoadd": true, "geosearchstore": true,
+	"xadd": true, "xtrim": true, "xdel": true, "xsetid": true, "xgroup": true, "xreadgroup": true,
+	"xack": true, "xclaim": true, "xautoclaim": true,
+}
+
+// sha1Hex returns the lowercase hex SHA1 digest of s
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// compileScript compiles the source of a script
+func compileScript(source string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), scriptChunkName)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, scriptChunkName)
+}
+
+// newScriptVM creates the interpreter scripts run in: the base, table,
+// string and math libraries without file access, the redis library, and
+// globals scripts can read but not add to
+func newScriptVM() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return scriptCall(L, true) },
+		"pcall": func(L *lua.LState) int { return scriptCall(L, false) },
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1Hex(L.CheckString(1))))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			L.CheckInt(1)
+			parts := make([]string, 0, L.GetTop()-1)
+			for i := 2; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			fmt.Printf("Script log: %s\n", strings.Join(parts, " "))
+			return 0
+		},
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redis)
+
+	// Globals a script sets would outlive it, so setting one is an error,
+	// as is reading one that does not exist
+	guard := L.NewTable()
+	L.SetFuncs(guard, map[string]lua.LGFunction{
+		"__newindex": func(L *lua.LState) int {
+			L.RaiseError("Script attempted to create global variable '%s'", L.ToStringMeta(L.Get(2)).String())
+			return 0
+		},
+		"__index": func(L *lua.LState) int {
+			L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.ToStringMeta(L.Get(2)).String())
+			return 0
+		},
+	})
+	L.SetMetatable(L.G.Global, guard)
+	return L
+}
+
+// replyTable returns a table with a single field, the form Lua gives status
+// ("ok") and error ("err") replies
+func replyTable(L *lua.LState, field, value string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(value))
+	return t
+}
+
+// scriptCall runs the command given by the arguments of redis.call or, if
+// not raise, redis.pcall. Error replies are raised by redis.call and
+// returned as error tables by redis.pcall.
+func scriptCall(L *lua.LState, raise bool) int {
+	fail := func(msg string) int {
+		t := replyTable(L, "err", msg)
+		if raise {
+			L.Error(t, 1)
+		}
+		L.Push(t)
+		return 1
+	}
+	if scriptClient == nil {
+		// Libraries being loaded only register their functions
+		return fail("ERR redis.call is not allowed while loading a library")
+	}
+	if L.GetTop() == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, L.GetTop())
+	for i := range args {
+		switch arg := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = string(arg)
+		case lua.LNumber:
+			args[i] = arg.String()
+		default:
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd := lookupCommand(args[0])
+	if cmd != nil && scriptForbidden[cmd.Canonical] {
+		return fail("ERR This Redis command is not allowed from script")
+	}
+	if cmd != nil && scriptReadOnly && scriptWriteCommands[cmd.Canonical] {
+		return fail("ERR Write commands are not allowed from read-only scripts.")
+	}
+
+	changes := keyChanges.Load()
+	reply := scriptClient.execute(args)
+	if keyChanges.Load() != changes {
+		if run := runningScript.Load(); run != nil {
+			run.markWrite()
+		}
+	}
+	value, _ := replyToLua(L, reply)
+	if t, ok := value.(*lua.LTable); ok && raise && t.RawGetString("err") != lua.LNil {
+		L.Error(t, 1)
+	}
+	L.Push(value)
+	return 1
+}
+
+// replyToLua converts the RESP2 reply at the start of reply to Lua: integers
+// to numbers, bulk strings to strings, arrays to tables, status and error
+// replies to tables with an ok or err field, and nulls to false
+// Returns the value and what follows the reply
+func replyToLua(L *lua.LState, reply string) (lua.LValue, string) {
+	end := strings.Index(reply, "\r\n")
+	if end < 1 {
+		return lua.LFalse, ""
+	}
+	line, rest := reply[1:end], reply[end+2:]
+	switch reply[0] {
+	case '+':
+		return replyTable(L, "ok", line), rest
+	case '-':
+		return replyTable(L, "err", line), rest
+	case ':':
+		n, _ := strconv.ParseInt(line, 10, 64)
+		return lua.LNumber(n), rest
+	case '$':
+		n, _ := strconv.Atoi(line)
+		if n < 0 || n > len(rest) {
+			return lua.LFalse, rest
+		}
+		return lua.LString(rest[:n]), rest[min(n+2, len(rest)):]
+	case '*':
+		n, _ := strconv.Atoi(line)
+		if n < 0 {
+			return lua.LFalse, rest
+		}
+		t := L.CreateTable(n, 0)
+		for i := 1; i <= n; i++ {
+			var elem lua.LValue
+			elem, rest = replyToLua(L, rest)
+			t.RawSetInt(i, elem)
+		}
+		return t, rest
+	}
+	return lua.LFalse, ""
+}
+
+// luaToReply converts the value a script returned to its reply: numbers to
+// integers (truncated), strings to bulk strings, tables to arrays (up to
+// their first nil) unless they have an ok or err field, true to 1, and
+// false and nil to a null bulk string
+func luaToReply(value lua.LValue) string {
+	switch v := value.(type) {
+	case lua.LString:
+		return formatBulkString(string(v))
+	case lua.LNumber:
+		return formatInteger(int(v))
+	case lua.LBool:
+		if v {
+			return formatInteger(1)
+		}
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return formatError(singleLine(string(err)))
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return formatSimpleString(string(status))
+		}
+		var elems []string
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			elems = append(elems, luaToReply(elem))
+		}
+		return "*" + strconv.Itoa(len(elems)) + "\r\n" + strings.Join(elems, "")
+	}
+	return formatNullBulkString()
+}
+
+// scriptArgs splits the arguments of EVAL and EVALSHA following the script
+// into its KEYS and ARGV
+// Returns a non-empty error reply on failure
+func scriptArgs(args []string) ([]string, []string, string) {
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, nil, formatError("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, formatError("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-3 {
+		return nil, nil, formatError("ERR Number of keys can't be greater than number of args")
+	}
+	return args[3 : 3+numKeys], args[3+numKeys:], ""
+}
+
+// stringTable returns a Lua array of values
+func stringTable(L *lua.LState, values []string) *lua.LTable {
+	t := L.CreateTable(len(values), 0)
+	for i, v := range values {
+		t.RawSetInt(i+1, lua.LString(v))
+	}
+	return t
+}
+
+// runScript runs a compiled script with no other client's command in
+// between, its commands executing in the client's database
+// Returns the script's reply
+func (c *Client) runScript(sha string, proto *lua.FunctionProto, keys, argv []string) string {
+	return c.runLua(&luaVM, newScriptVM, sha, false, func(L *lua.LState) int {
+		L.G.Global.RawSetString("KEYS", stringTable(L, keys))
+		L.G.Global.RawSetString("ARGV", stringTable(L, argv))
+		L.Push(L.NewFunctionFromProto(proto))
+		return 0
+	})
+}
+
+// runLua makes a call in the interpreter *vm, created by newVM if nil, as
+// runScript runs scripts, refusing write commands if readOnly. push pushes
+// the function to call and then its arguments, returning how many it pushed.
+// Returns the reply of the call, whose errors name it
+func (c *Client) runLua(vm **lua.LState, newVM func() *lua.LState, name string, readOnly bool, push func(L *lua.LState) int) string {
+	var reply string
+	c.exclusive(func() {
+		if *vm == nil {
+			*vm = newVM()
+		}
+		L := *vm
+		scriptClient = &Client{dbIndex: c.dbIndex, policy: c.policy, authenticated: c.authenticated, inExec: true}
+		scriptReadOnly = readOnly
+		defer func() { scriptClient, scriptReadOnly = nil, false }()
+		nargs := push(L)
+		run, end := startScript(L)
+		defer end()
+
+		if err := L.PCall(nargs, 1, nil); err != nil {
+			if run.wasKilled() {
+				reply = formatError("ERR Script killed by user with SCRIPT KILL...")
+				return
+			}
+			reply = scriptErrorReply(err, name)
+			return
+		}
+		reply = luaToReply(L.Get(-1))
+		L.Pop(1)
+	})
+	return reply
+}
+
+// scriptErrorReply formats the error a script failed with. Errors raised as
+// error tables, as redis.call raises the error replies of its commands, are
+// replied as is.
+func scriptErrorReply(err error, sha string) string {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return formatError("ERR " + singleLine(err.Error()))
+	}
+	if t, ok := apiErr.Object.(*lua.LTable); ok {
+		if msg, ok := t.RawGetString("err").(lua.LString); ok {
+			return formatError(singleLine(string(msg)))
+		}
+	}
+	return formatError(fmt.Sprintf("ERR %s script: %s", singleLine(apiErr.Object.String()), sha))
+}
+
+// singleLine joins the lines of an error message, which error replies
+// cannot break
+func singleLine(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+func evalCommand(c *Client, args []string) string {
+	keys, argv, errReply := scriptArgs(args)
+	if errReply != "" {
+		return errReply
+	}
+	sha, proto, errReply := scripts.load(args[1])
+	if errReply != "" {
+		return errReply
+	}
+	return c.runScript(sha, proto, keys, argv)
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+// Runs a script EVAL ran or SCRIPT LOAD loaded before
+func evalshaCommand(c *Client, args []string) string {
+	keys, argv, errReply := scriptArgs(args)
+	if errReply != "" {
+		return errReply
+	}
+	sha := strings.ToLower(args[1])
+	proto := scripts.get(sha)
+	if proto == nil {
+		return formatError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return c.runScript(sha, proto, keys, argv)
+}
+
+var scriptHelp = []string{
+	"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"EXISTS <sha1> [<sha1> ...]",
+	"    Return information about the existence of the scripts in the script cache.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Flush the Lua scripts cache. Valid modes are:",
+	"    * ASYNC: Asynchronously flush the scripts cache.",
+	"    * SYNC: Synchronously flush the scripts cache.",
+	"KILL",
+	"    Kill the currently executing Lua script.",
+	"LOAD <script>",
+	"    Load a script into the scripts cache without executing it.",
+	"HELP",
+	"    Print this help.",
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC | SYNC] | KILL | HELP
+func scriptCommand(c *Client, args []string) string {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "HELP" && len(args) == 2:
+		lines := ""
+		for _, line := range scriptHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(scriptHelp)) + "\r\n" + lines
+
+	case sub == "LOAD" && len(args) == 3:
+		sha, _, errReply := scripts.load(args[2])
+		if errReply != "" {
+			return errReply
+		}
+		return formatBulkString(sha)
+
+	case sub == "EXISTS" && len(args) >= 3:
+		reply := "*" + strconv.Itoa(len(args)-2) + "\r\n"
+		for _, sha := range args[2:] {
+			exists := 0
+			if scripts.get(strings.ToLower(sha)) != nil {
+				exists = 1
+			}
+			reply += formatInteger(exists)
+		}
+		return reply
+
+	case sub == "KILL" && len(args) == 2:
+		return killScript()
+
+	case sub == "FLUSH":
+		async, ok := parseFlushMode(args[1:])
+		if !ok {
+			return formatError("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		}
+		scripts.flush()
+		// A fresh interpreter drops whatever the old scripts left in theirs
+		c.exclusive(func() {
+			if old := luaVM; old != nil {
+				luaVM = nil
+				if async {
+					go old.Close()
+				} else {
+					old.Close()
+				}
+			}
+		})
+		return formatSimpleString("OK")
+	}
+	return formatError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try SCRIPT HELP.")
+}
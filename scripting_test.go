@@ -0,0 +1,277 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestScripting(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	eval := func(script string, args ...string) string {
+		return client.execute(append([]string{"EVAL", script}, args...))
+	}
+
+	// Test 1: Values returned by scripts convert to replies
+	for _, tc := range []struct{ script, want string }{
+		{"return 42", ":42\r\n"},
+		{"return 3.99", ":3\r\n"},
+		{"return 'hello'", "$5\r\nhello\r\n"},
+		{"return true", ":1\r\n"},
+		{"return false", "$-1\r\n"},
+		{"return nil", "$-1\r\n"},
+		{"return {1, 'two', {3}, nil, 5}", "*3\r\n:1\r\n$3\r\ntwo\r\n*1\r\n:3\r\n"},
+		{"return redis.status_reply('FINE')", "+FINE\r\n"},
+		{"return redis.error_reply('ERR custom')", "-ERR custom\r\n"},
+		{"return {err = 'ERR table'}", "-ERR table\r\n"},
+		{"return redis.sha1hex('')", "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n"},
+		{"return #KEYS + #ARGV", ":0\r\n"},
+		{"return type(redis.call('GET', 'missing'))", "$7\r\nboolean\r\n"},
+		{"return redis.call('SET', 'k', 'v').ok", "$2\r\nOK\r\n"},
+		{"return redis.call('HSET', 'h', 'f', 1)", ":1\r\n"},
+		{"return redis.call('HGETALL', 'h')", "*2\r\n$1\r\nf\r\n$1\r\n1\r\n"},
+		{"return redis.pcall('HGET', 'k', 'f').err", formatBulkString(wrongTypeError)},
+		{"return redis.call('HGET', 'k', 'f')", "-" + wrongTypeError + "\r\n"},
+		{"return redis.call('MULTI')", "-ERR This Redis command is not allowed from script\r\n"},
+		{"return redis.call()", "-ERR Please specify at least one argument for this redis lib call\r\n"},
+		{"return redis.call('GET', {})", "-ERR Lua redis lib command arguments must be strings or integers\r\n"},
+		{"return redis.call('SELECT', 1) and #KEYS", ":0\r\n"},
+		{"return redis.call('BLPOP', 'empty', 0)", "$-1\r\n"},
+		{"return redis.call('GET', 'missing') == false", ":1\r\n"},
+	} {
+		if reply := eval(tc.script, "0"); reply != tc.want {
+			t.Fatalf("expected %s to reply %q, got %q", tc.script, tc.want, reply)
+		}
+	}
+	if reply := client.execute([]string{"GET", "k"}); reply != "$1\r\nv\r\n" {
+		t.Fatalf("expected SELECT in a script to leave the caller's database alone, got %q", reply)
+	}
+
+	// Test 2: KEYS and ARGV hold the arguments after numkeys
+	script := "redis.call('SET', KEYS[1], ARGV[1]) return {KEYS[1], ARGV[1], ARGV[2], #KEYS, #ARGV}"
+	if reply := eval(script, "1", "key", "value", "more"); reply != "*5\r\n$3\r\nkey\r\n$5\r\nvalue\r\n$4\r\nmore\r\n:1\r\n:2\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	if reply := client.execute([]string{"GET", "key"}); reply != "$5\r\nvalue\r\n" {
+		t.Fatalf("expected the script to set the key, got %q", reply)
+	}
+
+	// Test 3: EVALSHA runs scripts EVAL ran, by their SHA1 in either case.
+	// Arrays end at their first nil, here the missing ARGV[2].
+	sha := sha1Hex(script)
+	for args, want := range map[string]string{
+		"EVALSHA " + sha + " 1 other x":                  "*2\r\n$5\r\nother\r\n$1\r\nx\r\n",
+		"EVALSHA " + strings.ToUpper(sha) + " 1 other y": "*2\r\n$5\r\nother\r\n$1\r\ny\r\n",
+		"EVALSHA " + sha1Hex("unknown") + " 0":           "-NOSCRIPT No matching script. Please use EVAL.\r\n",
+	} {
+		if reply := client.execute(strings.Fields(args)); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 4: Errors
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"EVAL", "return 1", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"EVAL", "return 1", "-1"}, "-ERR Number of keys can't be negative\r\n"},
+		{[]string{"EVAL", "return 1", "2", "a"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+		{[]string{"EVAL", "return (", "0"}, "-ERR Error compiling script (new function): " + scriptChunkName},
+		{[]string{"EVAL", "x = 1", "0"}, "-ERR " + scriptChunkName + ":1: Script attempted to create global variable 'x'"},
+		{[]string{"EVAL", "return y", "0"}, "-ERR " + scriptChunkName + ":1: Script attempted to access nonexistent global variable 'y'"},
+		{[]string{"EVAL", "return dofile('/etc/passwd')", "0"}, "-ERR " + scriptChunkName + ":1: Script attempted to access nonexistent global variable 'dofile'"},
+	} {
+		if reply := client.execute(tc.args); !strings.HasPrefix(reply, tc.want) {
+			t.Fatalf("expected %v to reply %q..., got %q", tc.args, tc.want, reply)
+		}
+	}
+
+	// Test 5: Scripts run with no other client's command in between, and
+	// within transactions
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other := &Client{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				other.execute([]string{"HINCRBY", "counter", "f", "1"})
+			}
+		}
+	}()
+	script = "redis.call('DEL', KEYS[1]) for i = 1, 10 do redis.call('HINCRBY', KEYS[1], 'f', 1) end return redis.call('HGET', KEYS[1], 'f')"
+	for i := 0; i < 20; i++ {
+		if reply := eval(script, "1", "counter"); reply != "$2\r\n10\r\n" {
+			t.Fatalf("expected the script to run alone, got %q", reply)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	client.execute([]string{"MULTI"})
+	eval("return redis.call('HINCRBY', KEYS[1], 'f', 1)", "1", "counter")
+	if reply := client.execute([]string{"EXEC"}); !strings.HasPrefix(reply, "*1\r\n:") {
+		t.Fatalf("expected EXEC to run the script, got %q", reply)
+	}
+
+	// Test 6: A client blocked on a key the script pushes to is served once
+	// the script ends, not between its calls
+	blocked := make(chan string, 1)
+	go func() { blocked <- (&Client{}).execute([]string{"BLPOP", "q", "5"}) }()
+	waitForBlocked(t, 1)
+	if reply := eval("redis.call('RPUSH', KEYS[1], 'x') return redis.call('LLEN', KEYS[1])", "1", "q"); reply != ":1\r\n" {
+		t.Fatalf("expected the script to see its push, got %q", reply)
+	}
+	if reply := <-blocked; reply != formatArray([]string{"q", "x"}) {
+		t.Fatalf("expected the blocked client to get x, got %q", reply)
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	saved := scripts
+	scripts = &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+	defer func() { scripts = saved }()
+	savedDatabases := databases
+	databases = NewDatabases(1)
+	defer func() { databases = savedDatabases }()
+
+	client := &Client{}
+	sha := sha1Hex("return ARGV[1]")
+	missing := sha1Hex("return 0")
+
+	// Test 1: SCRIPT LOAD caches a script for EVALSHA without running it
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SCRIPT", "EXISTS", sha, missing}, "*2\r\n:0\r\n:0\r\n"},
+		{[]string{"SCRIPT", "LOAD", "return ARGV[1]"}, formatBulkString(sha)},
+		{[]string{"SCRIPT", "EXISTS", strings.ToUpper(sha), missing}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"EVALSHA", sha, "0", "hi"}, "$2\r\nhi\r\n"},
+		{[]string{"SCRIPT", "LOAD", "return ("}, "-ERR Error compiling script (new function): "},
+		{[]string{"SCRIPT", "LOAD", "redis.call('SET', 'k', 'v')"}, "$40\r\n"},
+		{[]string{"GET", "k"}, "$-1\r\n"},
+	} {
+		if reply := client.execute(tc.args); !strings.HasPrefix(reply, tc.want) {
+			t.Fatalf("expected %v to reply %q, got %q", tc.args, tc.want, reply)
+		}
+	}
+
+	// Test 2: SCRIPT FLUSH empties the cache, in either mode
+	for _, mode := range []string{"SYNC", "ASYNC", ""} {
+		client.execute([]string{"SCRIPT", "LOAD", "return ARGV[1]"})
+		args := []string{"SCRIPT", "FLUSH"}
+		if mode != "" {
+			args = append(args, mode)
+		}
+		if reply := client.execute(args); reply != "+OK\r\n" {
+			t.Fatalf("unexpected SCRIPT FLUSH %s reply %q", mode, reply)
+		}
+		if reply := client.execute([]string{"EVALSHA", sha, "0", "hi"}); !strings.HasPrefix(reply, "-NOSCRIPT") {
+			t.Fatalf("expected the script to be flushed, got %q", reply)
+		}
+	}
+	if reply := client.execute([]string{"EVAL", "return 1", "0"}); reply != ":1\r\n" {
+		t.Fatalf("expected scripts to run after a flush, got %q", reply)
+	}
+
+	// Test 3: Errors
+	for args, want := range map[string]string{
+		"SCRIPT FLUSH LATER": "-ERR SCRIPT FLUSH only support SYNC|ASYNC option\r\n",
+		"SCRIPT EXISTS":      "-ERR wrong number of arguments for 'script|exists' command\r\n",
+		"SCRIPT NOPE":        "-ERR unknown subcommand or wrong number of arguments for 'NOPE'. Try SCRIPT HELP.\r\n",
+	} {
+		if reply := client.execute(strings.Fields(args)); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	if reply := client.execute([]string{"EVAL", "return redis.call('SCRIPT', 'FLUSH')", "0"}); reply != "-ERR This Redis command is not allowed from script\r\n" {
+		t.Fatalf("expected scripts not to flush the cache, got %q", reply)
+	}
+	if reply := client.execute([]string{"SCRIPT", "HELP"}); !strings.HasPrefix(reply, "*13\r\n") {
+		t.Fatalf("unexpected SCRIPT HELP reply %q", reply)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	busyThresholdOverride.Store(new(time.Duration))
+	defer busyThresholdOverride.Store(nil)
+
+	client, other := &Client{}, &Client{}
+	if reply := client.execute([]string{"CONFIG", "SET", "lua-time-limit", "20"}); reply != "+OK\r\n" {
+		t.Fatalf("unexpected CONFIG SET reply %q", reply)
+	}
+	if reply := client.execute([]string{"CONFIG", "GET", "busy-reply-threshold"}); reply != formatArray([]string{"busy-reply-threshold", "20"}) {
+		t.Fatalf("unexpected CONFIG GET reply %q", reply)
+	}
+	run := func(script string) chan string {
+		done := make(chan string, 1)
+		go func() { done <- client.execute([]string{"EVAL", script, "0"}) }()
+		return done
+	}
+	waitBusy := func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for other.execute([]string{"PING"}) != "-"+busyError+"\r\n" {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the script to get busy")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Test 1: Past the threshold, other clients get BUSY until SCRIPT KILL
+	// stops the script
+	if reply := other.execute([]string{"SCRIPT", "KILL"}); reply != "-NOTBUSY No scripts in execution right now.\r\n" {
+		t.Fatalf("unexpected SCRIPT KILL reply %q", reply)
+	}
+	done := run("while true do end")
+	waitBusy()
+	if reply := other.execute([]string{"SCRIPT", "KILL"}); reply != "+OK\r\n" {
+		t.Fatalf("unexpected SCRIPT KILL reply %q", reply)
+	}
+	if reply := <-done; !strings.HasPrefix(reply, "-ERR Script killed by user with SCRIPT KILL") {
+		t.Fatalf("expected the script to be killed, got %q", reply)
+	}
+	if reply := other.execute([]string{"PING"}); reply != "+PONG\r\n" {
+		t.Fatalf("expected commands to run after the kill, got %q", reply)
+	}
+	if reply := client.execute([]string{"EVAL", "return 1", "0"}); reply != ":1\r\n" {
+		t.Fatalf("expected scripts to run after a kill, got %q", reply)
+	}
+
+	// Test 2: A script that wrote cannot be killed
+	done = run("redis.call('SET', 'k', 'v') local i = 0 while redis.call('GET', 'stop') == false do i = i + 1 end return i")
+	waitBusy()
+	if reply := other.execute([]string{"SCRIPT", "KILL"}); !strings.HasPrefix(reply, "-UNKILLABLE ") {
+		t.Fatalf("unexpected SCRIPT KILL reply %q", reply)
+	}
+	databases.Get(0).Set("stop", "1")
+	if reply := <-done; !strings.HasPrefix(reply, ":") {
+		t.Fatalf("expected the script to finish, got %q", reply)
+	}
+
+	// Test 3: Scripts within the threshold, or with it disabled, never get busy
+	client.execute([]string{"CONFIG", "SET", "busy-reply-threshold", "0"})
+	done = run("local i = 0 while redis.call('GET', 'stop') == '1' and i < 20000 do i = i + 1 end return i")
+	if reply := other.execute([]string{"HINCRBY", "h", "f", "1"}); reply != ":1\r\n" {
+		t.Fatalf("expected the command to wait for the script, got %q", reply)
+	}
+	if reply := <-done; reply != ":20000\r\n" {
+		t.Fatalf("unexpected script reply %q", reply)
+	}
+}
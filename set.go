@@ -0,0 +1,424 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+)
+
+// members returns the set stored at key. The set is nil if the key does
+// not exist; ok is false if the key holds another type.
+// Callers must hold the lock.
+func (s *Store) members(key string) (setValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeSet {
+		return nil, false
+	}
+	return asSet(entry.Value), true
+}
+
+// mutableMembers is like members for callers about to change the set in
+// place: a set borrowed by readers outside the lock is copied first
+// Callers must hold the write lock.
+func (s *Store) mutableMembers(key string) (setValue, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeSet {
+		return nil, false
+	}
+	entry.own()
+	return asSet(entry.Value), true
+}
+
+// setModified must be called after changing the set at key in place, with
+// the set as addMember returned it; an emptied set is removed, as Redis
+// never keeps empty sets
+// Callers must hold the write lock.
+func (s *Store) setModified(key string, set setValue) {
+	if set.len() == 0 {
+		s.data.delete(key)
+	} else {
+		s.data.lookup(key).Value = setStorage(set)
+	}
+	s.keyModified(key)
+}
+
+// SAdd adds members to the set at key, creating it if needed
+// Returns (members added, isCorrectType)
+func (s *Store) SAdd(key string, members ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, ok := s.mutableMembers(key)
+	if !ok {
+		return 0, false
+	}
+	created := set == nil
+	if created {
+		set = s.newSet(members[0], len(members))
+	}
+	added := 0
+	for _, member := range members {
+		var isNew bool
+		if set, isNew = s.addMember(set, member); isNew {
+			added++
+		}
+	}
+	if created {
+		s.put(key, &Entry{Type: TypeSet, Value: setStorage(set)})
+	} else if added > 0 {
+		s.setModified(key, set)
+	}
+	return added, true
+}
+
+// SRem removes members from the set at key
+// Returns (members removed, isCorrectType)
+func (s *Store) SRem(key string, members ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, ok := s.mutableMembers(key)
+	if !ok || set == nil {
+		return 0, ok
+	}
+	removed := 0
+	for _, member := range members {
+		if set.remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.setModified(key, set)
+	}
+	return removed, true
+}
+
+// SMembers returns every member of the set at key
+// Returns (members, isCorrectType)
+func (s *Store) SMembers(key string) ([]string, bool) {
+	s.mu.RLock()
+	set, ok := s.members(key)
+	if !ok {
+		s.mu.RUnlock()
+		return nil, false
+	}
+	if set == nil || set.len() < cowMinElements {
+		defer s.mu.RUnlock()
+		return setMembers(set), true
+	}
+
+	// Copy big sets without holding up writers
+	share := s.data.lookup(key).borrow()
+	s.mu.RUnlock()
+	defer share.release()
+	return setMembers(set), true
+}
+
+// setMembers returns the members of set in iteration order
+func setMembers(set setValue) []string {
+	if set == nil {
+		return []string{}
+	}
+	members := make([]string, 0, set.len())
+	set.each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// SIsMember reports for each of members whether it belongs to the set at key
+// Returns (memberships, isCorrectType)
+func (s *Store) SIsMember(key string, members ...string) ([]bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, ok := s.members(key)
+	if !ok {
+		return nil, false
+	}
+	found := make([]bool, len(members))
+	if set != nil {
+		for i, member := range members {
+			found[i] = set.contains(member)
+		}
+	}
+	return found, true
+}
+
+// SCard returns the number of members of the set at key, 0 if it does not exist
+// Returns (cardinality, isCorrectType)
+func (s *Store) SCard(key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, ok := s.members(key)
+	if set == nil {
+		return 0, ok
+	}
+	return set.len(), true
+}
+
+// SMove moves member from the set at src to the set at dst, creating dst if
+// needed. Both sets change under one write lock, so no reader can see the
+// member in both sets or in neither.
+// Returns (moved, isCorrectType)
+func (s *Store) SMove(src, dst, member string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	srcSet, ok := s.mutableMembers(src)
+	if !ok {
+		return false, false
+	}
+	if srcSet == nil {
+		return false, true
+	}
+	dstSet, ok := s.mutableMembers(dst)
+	if !ok {
+		return false, false
+	}
+	if !srcSet.contains(member) {
+		return false, true
+	}
+	if src == dst {
+		return true, true
+	}
+
+	srcSet.remove(member)
+	s.setModified(src, srcSet)
+	if dstSet == nil {
+		dstSet = s.newSet(member, 1)
+		dstSet.add(member)
+		s.put(dst, &Entry{Type: TypeSet, Value: setStorage(dstSet)})
+	} else if dstSet, ok = s.addMember(dstSet, member); ok {
+		s.setModified(dst, dstSet)
+	}
+	return true, true
+}
+
+// randomMembers picks count members of set at random: distinct members
+// (at most the whole set) if distinct is set, otherwise exactly count
+// members that may repeat
+func randomMembers(set setValue, count int, distinct bool) []string {
+	if count == 0 || set == nil || set.len() == 0 {
+		return []string{}
+	}
+	n := set.len()
+	if distinct && count >= n {
+		picked := setMembers(set)
+		rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		return picked
+	}
+	if distinct {
+		// Reservoir sampling keeps every subset of count members equally likely
+		picked := make([]string, 0, count)
+		seen := 0
+		set.each(func(member string) bool {
+			if seen < count {
+				picked = append(picked, member)
+			} else if j := rand.IntN(seen + 1); j < count {
+				picked[j] = member
+			}
+			seen++
+			return true
+		})
+		rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		return picked
+	}
+	if count == 1 {
+		// Go to a random position rather than copying the set
+		return []string{set.at(rand.IntN(n))}
+	}
+	// count comes from the client: grow the reply rather than trusting it
+	all := setMembers(set)
+	picked := make([]string, 0, min(count, n))
+	for range count {
+		picked = append(picked, all[rand.IntN(len(all))])
+	}
+	return picked
+}
+
+// SRandMember returns random members of the set at key without removing
+// them; see randomMembers for the meaning of count and distinct
+// Returns (members, isCorrectType)
+func (s *Store) SRandMember(key string, count int, distinct bool) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, ok := s.members(key)
+	if !ok {
+		return nil, false
+	}
+	return randomMembers(set, count, distinct), true
+}
+
+// SPop removes and returns up to count distinct random members of the set at key
+// Returns (members, exists, isCorrectType)
+func (s *Store) SPop(key string, count int) ([]string, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, ok := s.mutableMembers(key)
+	if !ok {
+		return nil, true, false
+	}
+	if set == nil {
+		return nil, false, true
+	}
+	popped := randomMembers(set, count, true)
+	for _, member := range popped {
+		set.remove(member)
+	}
+	if len(popped) > 0 {
+		s.setModified(key, set)
+	}
+	return popped, true, true
+}
+
+// SADD key member [member ...]
+func saddCommand(c *Client, args []string) string {
+	added, ok := c.db().SAdd(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(added)
+}
+
+// SREM key member [member ...]
+func sremCommand(c *Client, args []string) string {
+	removed, ok := c.db().SRem(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(removed)
+}
+
+// SMEMBERS key
+func smembersCommand(c *Client, args []string) string {
+	members, ok := c.db().SMembers(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatSet(members)
+}
+
+// SISMEMBER key member
+func sismemberCommand(c *Client, args []string) string {
+	found, ok := c.db().SIsMember(args[1], args[2])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatMembership(found[0])
+}
+
+// SMISMEMBER key member [member ...]
+func smismemberCommand(c *Client, args []string) string {
+	found, ok := c.db().SIsMember(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	reply := "*" + strconv.Itoa(len(found)) + "\r\n"
+	for _, f := range found {
+		reply += formatMembership(f)
+	}
+	return reply
+}
+
+// SCARD key
+func scardCommand(c *Client, args []string) string {
+	n, ok := c.db().SCard(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// SMOVE source destination member
+func smoveCommand(c *Client, args []string) string {
+	moved, ok := c.db().SMove(args[1], args[2], args[3])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatMembership(moved)
+}
+
+// formatMembership formats a set membership as the integer 1 or 0
+func formatMembership(member bool) string {
+	if member {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
+
+// SRANDMEMBER key [count]
+func srandmemberCommand(c *Client, args []string) string {
+	if len(args) > 3 {
+		return formatError("ERR syntax error")
+	}
+	if len(args) == 2 {
+		picked, ok := c.db().SRandMember(args[1], 1, true)
+		if !ok {
+			return formatError(wrongTypeError)
+		}
+		if len(picked) == 0 {
+			return formatNullBulkString()
+		}
+		return formatBulkString(picked[0])
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if count < -math.MaxInt64/2 {
+		return formatError("ERR value is out of range")
+	}
+	// A negative count allows the same member to be returned several times
+	distinct := count >= 0
+	if !distinct {
+		count = -count
+	}
+	picked, ok := c.db().SRandMember(args[1], count, distinct)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatArray(picked)
+}
+
+// SPOP key [count]
+func spopCommand(c *Client, args []string) string {
+	if len(args) > 3 {
+		return formatError("ERR syntax error")
+	}
+	count, withCount := 1, len(args) == 3
+	if withCount {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return formatError("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	popped, exists, ok := c.db().SPop(args[1], count)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if len(popped) > 0 {
+		// The members picked at random are replayed as removed
+		c.rewriteAOF(append([]string{"SREM", args[1]}, popped...))
+	}
+	if withCount {
+		return c.formatSet(popped)
+	}
+	if !exists || len(popped) == 0 {
+		return formatNullBulkString()
+	}
+	return formatBulkString(popped[0])
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: SADD counts only new members
+	if reply := run("SADD", "s", "a", "b", "a"); reply != ":2\r\n" {
+		t.Fatalf("expected :2, got %q", reply)
+	}
+	if reply := run("SADD", "s", "b", "c"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := run("SCARD", "s"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+	members, _ := databases.Get(0).SMembers("s")
+	sort.Strings(members)
+	if strings.Join(members, ",") != "a,b,c" {
+		t.Fatalf("unexpected members %v", members)
+	}
+
+	// Test 2: Membership checks
+	if reply := run("SISMEMBER", "s", "a"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if reply := run("SISMEMBER", "missing", "a"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if reply := run("SMISMEMBER", "s", "a", "x", "c"); reply != "*3\r\n:1\r\n:0\r\n:1\r\n" {
+		t.Fatalf("unexpected SMISMEMBER reply %q", reply)
+	}
+
+	// Test 3: Removing every member deletes the key
+	if reply := run("SREM", "s", "a", "x"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	run("SREM", "s", "b", "c")
+	if _, exists := databases.Get(0).KeyType("s"); exists {
+		t.Fatalf("expected empty set to be deleted")
+	}
+	if reply := run("SMEMBERS", "s"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+
+	// Test 4: RESP3 clients get a set reply
+	run("SADD", "one", "x")
+	resp3 := &Client{resp3: true}
+	if reply := resp3.execute([]string{"SMEMBERS", "one"}); reply != "~1\r\n$1\r\nx\r\n" {
+		t.Fatalf("expected RESP3 set, got %q", reply)
+	}
+
+	// Test 5: WRONGTYPE on non-set keys
+	run("SET", "str", "v")
+	for _, args := range [][]string{{"SADD", "str", "x"}, {"SREM", "str", "x"}, {"SMEMBERS", "str"}, {"SISMEMBER", "str", "x"}, {"SMISMEMBER", "str", "x"}, {"SCARD", "str"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+}
+
+func TestSetRandomMembers(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("SADD", "s", "a", "b", "c", "d", "e")
+	db := databases.Get(0)
+
+	// Test 1: A positive count returns distinct members, at most the whole set
+	picked, _ := db.SRandMember("s", 3, true)
+	seen := make(map[string]bool)
+	for _, member := range picked {
+		if seen[member] {
+			t.Fatalf("expected distinct members, got %v", picked)
+		}
+		seen[member] = true
+	}
+	if len(picked) != 3 {
+		t.Fatalf("expected 3 members, got %v", picked)
+	}
+	if reply := run("SRANDMEMBER", "s", "10"); !strings.HasPrefix(reply, "*5\r\n") {
+		t.Fatalf("expected the whole set, got %q", reply)
+	}
+
+	// Test 2: A negative count returns exactly that many, possibly repeated
+	if reply := run("SRANDMEMBER", "s", "-20"); !strings.HasPrefix(reply, "*20\r\n") {
+		t.Fatalf("expected 20 members, got %q", reply)
+	}
+	run("SADD", "one", "x")
+	if reply := run("SRANDMEMBER", "one", "-3"); reply != formatArray([]string{"x", "x", "x"}) {
+		t.Fatalf("expected repetitions, got %q", reply)
+	}
+	for _, count := range []string{"-9223372036854775808", "-4611686018427387904"} {
+		if reply := run("SRANDMEMBER", "one", count); reply != "-ERR value is out of range\r\n" {
+			t.Fatalf("expected count %s to be out of range, got %q", count, reply)
+		}
+	}
+
+	// Test 3: Missing keys and zero counts
+	if reply := run("SRANDMEMBER", "missing"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+	if reply := run("SRANDMEMBER", "missing", "5"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+	if reply := run("SRANDMEMBER", "s", "0"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+	if reply := run("SCARD", "s"); reply != ":5\r\n" {
+		t.Fatalf("expected SRANDMEMBER to leave the set intact, got %q", reply)
+	}
+
+	// Test 4: SPOP removes what it returns and deletes the emptied set
+	popped, _, _ := db.SPop("s", 2)
+	for _, member := range popped {
+		if found, _ := db.SIsMember("s", member); found[0] {
+			t.Fatalf("expected %q to be removed", member)
+		}
+	}
+	if reply := run("SCARD", "s"); reply != ":3\r\n" {
+		t.Fatalf("expected :3, got %q", reply)
+	}
+	if reply := run("SPOP", "s", "-1"); reply != "-ERR value is out of range, must be positive\r\n" {
+		t.Fatalf("expected range error, got %q", reply)
+	}
+	if reply := run("SPOP", "s", "10"); !strings.HasPrefix(reply, "*3\r\n") {
+		t.Fatalf("expected the remaining 3 members, got %q", reply)
+	}
+	if _, exists := db.KeyType("s"); exists {
+		t.Fatalf("expected empty set to be deleted")
+	}
+	if reply := run("SPOP", "s"); reply != "$-1\r\n" {
+		t.Fatalf("expected null bulk, got %q", reply)
+	}
+	if reply := run("SPOP", "s", "1"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+	if reply := run("SPOP", "one"); reply != "$1\r\nx\r\n" {
+		t.Fatalf("expected x, got %q", reply)
+	}
+}
+
+func TestSetMove(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+
+	// Test 1: Moving the last member deletes the source and creates the destination
+	run("SADD", "src", "a")
+	if reply := run("SMOVE", "src", "dst", "a"); reply != ":1\r\n" {
+		t.Fatalf("expected :1, got %q", reply)
+	}
+	if _, exists := databases.Get(0).KeyType("src"); exists {
+		t.Fatalf("expected the emptied source to be deleted")
+	}
+	if reply := run("SISMEMBER", "dst", "a"); reply != ":1\r\n" {
+		t.Fatalf("expected the member in the destination, got %q", reply)
+	}
+
+	// Test 2: Missing members, missing sources and same-key moves
+	run("SADD", "src", "b")
+	if reply := run("SMOVE", "src", "dst", "x"); reply != ":0\r\n" {
+		t.Fatalf("expected :0 for a missing member, got %q", reply)
+	}
+	if reply := run("SMOVE", "nosuch", "dst", "a"); reply != ":0\r\n" {
+		t.Fatalf("expected :0 for a missing source, got %q", reply)
+	}
+	if reply := run("SMOVE", "src", "src", "b"); reply != ":1\r\n" || run("SCARD", "src") != ":1\r\n" {
+		t.Fatalf("expected a same-key move to keep the member, got %q", reply)
+	}
+
+	// Test 3: WRONGTYPE for either side
+	run("SET", "str", "v")
+	if reply := run("SMOVE", "str", "dst", "a"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE for the source, got %q", reply)
+	}
+	if reply := run("SMOVE", "src", "str", "b"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE for the destination, got %q", reply)
+	}
+	if reply := run("SISMEMBER", "src", "b"); reply != ":1\r\n" {
+		t.Fatalf("expected a refused move to leave the source alone, got %q", reply)
+	}
+
+	// Test 4: Concurrent moves never show the member in both sets or neither
+	db := databases.Get(0)
+	db.SAdd("left", "m", "anchor")
+	db.SAdd("right", "anchor")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			db.SMove("left", "right", "m")
+			db.SMove("right", "left", "m")
+		}
+	}()
+	for moving := true; moving; {
+		select {
+		case <-done:
+			moving = false
+		default:
+		}
+		db.mu.RLock()
+		left, _ := db.members("left")
+		right, _ := db.members("right")
+		inLeft := left.contains("m")
+		inRight := right.contains("m")
+		db.mu.RUnlock()
+		if inLeft == inRight {
+			t.Fatalf("member observed in both sets or neither (left %v, right %v)", inLeft, inRight)
+		}
+	}
+}
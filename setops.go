@@ -0,0 +1,154 @@
+package main
+
+// setOperation selects the algebra SUNION, SINTER and SDIFF apply
+type setOperation int
+
+const (
+	setUnion setOperation = iota
+	setIntersection
+	setDifference
+)
+
+// combineSets applies op to the sets at keys, missing keys counting as
+// empty sets. ok is false if any key holds another type.
+// Callers must hold the lock.
+func (s *Store) combineSets(op setOperation, keys []string) (hashSet, bool) {
+	sets := make([]setValue, len(keys))
+	for i, key := range keys {
+		set, ok := s.members(key)
+		if !ok {
+			return nil, false
+		}
+		if set == nil {
+			set = hashSet(nil)
+		}
+		sets[i] = set
+	}
+
+	result := make(hashSet)
+	switch op {
+	case setUnion:
+		for _, set := range sets {
+			set.each(func(member string) bool {
+				result[member] = struct{}{}
+				return true
+			})
+		}
+	case setIntersection:
+		// Probe the other sets with the members of the smallest one
+		smallest := sets[0]
+		for _, set := range sets {
+			if set.len() < smallest.len() {
+				smallest = set
+			}
+		}
+		smallest.each(func(member string) bool {
+			for _, set := range sets {
+				if !set.contains(member) {
+					return true
+				}
+			}
+			result[member] = struct{}{}
+			return true
+		})
+	case setDifference:
+		sets[0].each(func(member string) bool {
+			result[member] = struct{}{}
+			return true
+		})
+		for _, set := range sets[1:] {
+			if len(result) == 0 {
+				break
+			}
+			set.each(func(member string) bool {
+				delete(result, member)
+				return true
+			})
+		}
+	}
+	return result, true
+}
+
+// CombineSets returns the result of op over the sets at keys
+// Returns (members, isCorrectType)
+func (s *Store) CombineSets(op setOperation, keys []string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result, ok := s.combineSets(op, keys)
+	if !ok {
+		return nil, false
+	}
+	members := make([]string, 0, len(result))
+	for member := range result {
+		members = append(members, member)
+	}
+	return members, true
+}
+
+// CombineSetsStore stores the result of op over the sets at keys in dst,
+// replacing whatever dst held; an empty result deletes dst
+// Returns (cardinality of the result, isCorrectType)
+func (s *Store) CombineSetsStore(op setOperation, dst string, keys []string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.combineSets(op, keys)
+	if !ok {
+		return 0, false
+	}
+	if len(result) == 0 {
+		if s.data.delete(dst) {
+			s.keyModified(dst)
+		}
+		return 0, true
+	}
+	s.put(dst, &Entry{Type: TypeSet, Value: setStorage(s.encodeSet(result))})
+	return len(result), true
+}
+
+func combineReply(c *Client, args []string, op setOperation) string {
+	members, ok := c.db().CombineSets(op, args[1:])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatSet(members)
+}
+
+func combineStoreReply(c *Client, args []string, op setOperation) string {
+	n, ok := c.db().CombineSetsStore(op, args[1], args[2:])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// SUNION key [key ...]
+func sunionCommand(c *Client, args []string) string {
+	return combineReply(c, args, setUnion)
+}
+
+// SINTER key [key ...]
+func sinterCommand(c *Client, args []string) string {
+	return combineReply(c, args, setIntersection)
+}
+
+// SDIFF key [key ...]
+func sdiffCommand(c *Client, args []string) string {
+	return combineReply(c, args, setDifference)
+}
+
+// SUNIONSTORE destination key [key ...]
+func sunionstoreCommand(c *Client, args []string) string {
+	return combineStoreReply(c, args, setUnion)
+}
+
+// SINTERSTORE destination key [key ...]
+func sinterstoreCommand(c *Client, args []string) string {
+	return combineStoreReply(c, args, setIntersection)
+}
+
+// SDIFFSTORE destination key [key ...]
+func sdiffstoreCommand(c *Client, args []string) string {
+	return combineStoreReply(c, args, setDifference)
+}
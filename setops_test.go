@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSetAlgebra(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	db := databases.Get(0)
+	sorted := func(op setOperation, keys ...string) string {
+		members, ok := db.CombineSets(op, keys)
+		if !ok {
+			return "WRONGTYPE"
+		}
+		sort.Strings(members)
+		return strings.Join(members, ",")
+	}
+
+	run("SADD", "a", "1", "2", "3", "4")
+	run("SADD", "b", "3", "4", "5")
+	run("SADD", "c", "4", "6")
+
+	// Test 1: Union, intersection and difference, missing keys being empty
+	if got := sorted(setUnion, "a", "b", "missing"); got != "1,2,3,4,5" {
+		t.Fatalf("unexpected union %q", got)
+	}
+	if got := sorted(setIntersection, "a", "b", "c"); got != "4" {
+		t.Fatalf("unexpected intersection %q", got)
+	}
+	if got := sorted(setIntersection, "a", "missing"); got != "" {
+		t.Fatalf("expected an empty intersection with a missing key, got %q", got)
+	}
+	if got := sorted(setDifference, "a", "b", "c"); got != "1,2" {
+		t.Fatalf("unexpected difference %q", got)
+	}
+	if reply := run("SDIFF", "missing", "a"); reply != "*0\r\n" {
+		t.Fatalf("expected empty array, got %q", reply)
+	}
+
+	// Test 2: STORE variants replace the destination and return its cardinality
+	run("SET", "dst", "string")
+	if reply := run("SUNIONSTORE", "dst", "a", "c"); reply != ":5\r\n" {
+		t.Fatalf("expected :5, got %q", reply)
+	}
+	if reply := run("SCARD", "dst"); reply != ":5\r\n" {
+		t.Fatalf("expected dst to be a set of 5, got %q", reply)
+	}
+	if reply := run("SINTERSTORE", "a", "a", "b"); reply != ":2\r\n" {
+		t.Fatalf("expected :2 when storing into a source, got %q", reply)
+	}
+	if got := sorted(setUnion, "a"); got != "3,4" {
+		t.Fatalf("unexpected stored intersection %q", got)
+	}
+
+	// Test 3: An empty result deletes the destination
+	if reply := run("SDIFFSTORE", "dst", "c", "c"); reply != ":0\r\n" {
+		t.Fatalf("expected :0, got %q", reply)
+	}
+	if _, exists := db.KeyType("dst"); exists {
+		t.Fatalf("expected empty result to delete the destination")
+	}
+
+	// Test 4: WRONGTYPE if any source is not a set
+	run("SET", "str", "v")
+	for _, args := range [][]string{{"SUNION", "a", "str"}, {"SINTER", "str", "missing"}, {"SDIFFSTORE", "x", "a", "str"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+	if _, exists := db.KeyType("x"); exists {
+		t.Fatalf("expected a failed STORE to leave the destination alone")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import "math/rand/v2"
+
+// Skiplist parameters, as Redis's ZSKIPLIST_MAXLEVEL and ZSKIPLIST_P: a node
+// reaches each further level with probability 1/4
+const (
+	skiplistMaxLevel = 32
+	skiplistBranch   = 4
+)
+
+// skiplist keeps the elements of a sorted set in order. Each forward link
+// records its span, the number of elements it skips over, so ranks are found
+// in O(log n) along with the elements.
+//
+// The span of a link without a forward node counts the elements left after
+// its node; insert relies on it when linking a node at the end of a level.
+type skiplist struct {
+	head   *skiplistNode // Sentinel linked at every level, holding no element
+	tail   *skiplistNode
+	length int
+	level  int // Levels in use, at least 1
+}
+
+type skiplistNode struct {
+	zsetElement
+	backward *skiplistNode // Previous node, nil for the first
+	levels   []skiplistLink
+}
+
+type skiplistLink struct {
+	forward *skiplistNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{head: &skiplistNode{levels: make([]skiplistLink, skiplistMaxLevel)}, level: 1}
+}
+
+// next returns the following node, nil after the last
+func (n *skiplistNode) next() *skiplistNode {
+	return n.levels[0].forward
+}
+
+// randomSkiplistLevel returns the number of levels of a new node
+func randomSkiplistLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.IntN(skiplistBranch) == 0 {
+		level++
+	}
+	return level
+}
+
+// buildSkiplist returns a skiplist of the elements each yields, which must
+// come in ascending order. Linking each node after the last one of its
+// levels takes O(n) rather than the O(n log n) of inserting them.
+func buildSkiplist(each func(yield func(zsetElement) bool)) *skiplist {
+	sl := newSkiplist()
+	var last [skiplistMaxLevel]*skiplistNode
+	var lastRank [skiplistMaxLevel]int // 1-based rank of last, 0 for the head
+	for i := range last {
+		last[i] = sl.head
+	}
+	each(func(e zsetElement) bool {
+		sl.length++
+		level := randomSkiplistLevel()
+		sl.level = max(sl.level, level)
+		x := &skiplistNode{zsetElement: e, levels: make([]skiplistLink, level)}
+		for i := range level {
+			last[i].levels[i] = skiplistLink{x, sl.length - lastRank[i]}
+			last[i], lastRank[i] = x, sl.length
+		}
+		if sl.tail != nil {
+			x.backward = sl.tail
+		}
+		sl.tail = x
+		return true
+	})
+	for i := range sl.level {
+		last[i].levels[i].span = sl.length - lastRank[i]
+	}
+	return sl
+}
+
+// insert adds e, which must not be in the list yet
+func (sl *skiplist) insert(e zsetElement) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && compareElements(x.levels[i].forward.zsetElement, e) < 0 {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkiplistLevel()
+	for i := sl.level; i < level; i++ {
+		update[i] = sl.head
+		update[i].levels[i].span = sl.length
+	}
+	sl.level = max(sl.level, level)
+
+	x = &skiplistNode{zsetElement: e, levels: make([]skiplistLink, level)}
+	for i := range level {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if next := x.next(); next != nil {
+		next.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes e, reporting whether it was in the list
+func (sl *skiplist) delete(e zsetElement) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && compareElements(x.levels[i].forward.zsetElement, e) < 0 {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.next()
+	if x == nil || compareElements(x.zsetElement, e) != 0 {
+		return false
+	}
+	sl.unlink(x, &update)
+	return true
+}
+
+// unlink removes x, given the last node before it at every level
+func (sl *skiplist) unlink(x *skiplistNode, update *[skiplistMaxLevel]*skiplistNode) {
+	for i := range sl.level {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if next := x.next(); next != nil {
+		next.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// deleteRanks removes the elements of ranks [from, to), calling fn with each
+func (sl *skiplist) deleteRanks(from, to int, fn func(zsetElement)) {
+	var update [skiplistMaxLevel]*skiplistNode
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= from {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.next()
+	for rank := from; x != nil && rank < to; rank++ {
+		next := x.next()
+		sl.unlink(x, &update)
+		fn(x.zsetElement)
+		x = next
+	}
+}
+
+// rank returns the position of e, which must be in the list, from 0
+func (sl *skiplist) rank(e zsetElement) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && compareElements(x.levels[i].forward.zsetElement, e) <= 0 {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return rank - 1
+}
+
+// nodeAt returns the node of the given rank, from 0
+func (sl *skiplist) nodeAt(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// search returns the rank of the first element for which pred is true, or
+// the length if there is none. pred must be false for a prefix of the
+// elements and true for the rest, as for sort.Search.
+func (sl *skiplist) search(pred func(zsetElement) bool) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !pred(x.levels[i].forward.zsetElement) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return rank
+}
+
+// clear unlinks every node, so that each can be collected on its own
+func (sl *skiplist) clear() {
+	for x := sl.head.next(); x != nil; {
+		next := x.next()
+		clear(x.levels)
+		x.backward = nil
+		x = next
+	}
+	clear(sl.head.levels)
+	sl.tail, sl.length, sl.level = nil, 0, 1
+}
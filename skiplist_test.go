@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkSkiplist compares sl with the elements it should hold, in order
+func checkSkiplist(t *testing.T, sl *skiplist, want []zsetElement) {
+	t.Helper()
+	if sl.length != len(want) {
+		t.Fatalf("expected length %d, got %d", len(want), sl.length)
+	}
+	var prev *skiplistNode
+	i := 0
+	for x := sl.head.next(); x != nil; x = x.next() {
+		if x.zsetElement != want[i] || x.backward != prev {
+			t.Fatalf("unexpected node %d: %v", i, x.zsetElement)
+		}
+		if rank := sl.rank(x.zsetElement); rank != i {
+			t.Fatalf("expected %v at rank %d, got %d", x.zsetElement, i, rank)
+		}
+		if at := sl.nodeAt(i); at != x {
+			t.Fatalf("expected nodeAt(%d) to be %v", i, x.zsetElement)
+		}
+		prev = x
+		i++
+	}
+	if i != len(want) || sl.tail != prev {
+		t.Fatalf("expected %d linked nodes ending at the tail, got %d", len(want), i)
+	}
+	// The spans of every level add up to the length
+	for level := range sl.level {
+		total := 0
+		for x := sl.head; x != nil; x = x.levels[level].forward {
+			total += x.levels[level].span
+		}
+		if total != sl.length {
+			t.Fatalf("spans of level %d add up to %d, want %d", level, total, sl.length)
+		}
+	}
+}
+
+func TestSkiplist(t *testing.T) {
+	// Test 1: Random inserts and deletes keep the order and the spans
+	sl := newSkiplist()
+	var model []zsetElement
+	for i := range 2000 {
+		e := zsetElement{"m" + strconv.Itoa(rand.IntN(500)), float64(rand.IntN(50))}
+		if j, found := slices.BinarySearchFunc(model, e, compareElements); found {
+			if !sl.delete(e) {
+				t.Fatalf("expected %v to be deleted", e)
+			}
+			model = slices.Delete(model, j, j+1)
+		} else {
+			sl.insert(e)
+			model = slices.Insert(model, j, e)
+		}
+		if i%100 == 0 {
+			checkSkiplist(t, sl, model)
+		}
+	}
+	checkSkiplist(t, sl, model)
+	if sl.delete(zsetElement{"absent", 1}) {
+		t.Fatalf("expected deleting a missing element to fail")
+	}
+
+	// Test 2: search finds the first element matching a monotonic predicate
+	for _, score := range []float64{-1, 0, 10.5, 25, 49, 100} {
+		want, _ := slices.BinarySearchFunc(model, score, func(e zsetElement, s float64) int {
+			if e.score < s {
+				return -1
+			}
+			return 1
+		})
+		if got := sl.search(func(e zsetElement) bool { return e.score >= score }); got != want {
+			t.Fatalf("expected the first score >= %v at rank %d, got %d", score, want, got)
+		}
+	}
+
+	// Test 3: deleteRanks removes a run of elements
+	from, to := len(model)/3, len(model)/2
+	var removed []zsetElement
+	sl.deleteRanks(from, to, func(e zsetElement) { removed = append(removed, e) })
+	if !slices.Equal(removed, model[from:to]) {
+		t.Fatalf("deleteRanks removed the wrong elements")
+	}
+	model = slices.Delete(model, from, to)
+	checkSkiplist(t, sl, model)
+	sl.deleteRanks(0, len(model), func(zsetElement) {})
+	checkSkiplist(t, sl, nil)
+	if sl.level != 1 {
+		t.Fatalf("expected an empty list to use one level, got %d", sl.level)
+	}
+
+	// Test 4: A built list behaves like an inserted one
+	built := buildSkiplist(slices.Values(removed))
+	checkSkiplist(t, built, removed)
+	e := zsetElement{"inserted", removed[len(removed)/2].score}
+	built.insert(e)
+	removed = slices.Insert(removed, built.rank(e), e)
+	checkSkiplist(t, built, removed)
+}
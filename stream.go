@@ -0,0 +1,705 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Error messages of stream commands
+const (
+	streamInvalidIDError = "ERR Invalid stream ID specified as stream command argument"
+	streamZeroIDError    = "ERR The ID specified in XADD must be greater than 0-0"
+	streamSmallIDError   = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	streamExhaustedError = "ERR The stream has exhausted the last possible ID, unable to add more items"
+)
+
+// streamID identifies a stream entry: the Unix time in milliseconds it was
+// added at and its sequence number among the entries of that millisecond
+type streamID struct{ ms, seq uint64 }
+
+// Highest possible stream ID, which no entry can follow
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// compare orders IDs by time, then by sequence number
+func (id streamID) compare(other streamID) int {
+	if c := cmp.Compare(id.ms, other.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.seq, other.seq)
+}
+
+// next returns the ID right after id, or false if id is the highest
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the ID right before id, or false if id is 0-0
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses "ms-seq", or "ms" with seq as the sequence number
+func parseStreamID(arg string, seq uint64) (streamID, bool) {
+	msArg, seqArg, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msArg, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqArg, 10, 64); err != nil {
+			return streamID{}, false
+		}
+	}
+	return streamID{ms, seq}, true
+}
+
+// streamEntry is one entry of a stream: its ID and its fields and values
+type streamEntry struct {
+	id     streamID
+	fields []string // Alternating fields and values, never changed once added
+}
+
+// Limits of a stream node, as Redis's default stream-node-max-entries and
+// stream-node-max-bytes: appending past either starts a new node
+const (
+	streamNodeMaxEntries = 100
+	streamNodeMaxBytes   = 4096
+)
+
+// streamNode is a run of consecutive entries of a stream, as Redis keeps
+// them in one listpack of its radix tree: lookups find the node before the
+// entry, and approximate trimming removes whole nodes
+type streamNode struct {
+	entries []streamEntry // Entries not deleted yet, in ID order
+	added   int           // Entries ever appended to the node
+	bytes   int           // Size of the fields and values ever appended
+}
+
+// search returns the position in the node of the first entry with an ID of
+// at least id
+func (n *streamNode) search(id streamID) int {
+	i, _ := slices.BinarySearchFunc(n.entries, id, func(e streamEntry, id streamID) int {
+		return e.id.compare(id)
+	})
+	return i
+}
+
+func (n *streamNode) last() streamEntry {
+	return n.entries[len(n.entries)-1]
+}
+
+// stream is the value of a stream key: entries in ID order, each with an ID
+// greater than any added before, even those since deleted. Entries are held
+// in nodes, so lookups and trimming scale with the number of nodes they
+// skip rather than of entries.
+type stream struct {
+	nodes        []*streamNode             // Non-empty nodes, in ID order
+	length       int                       // Entries in all nodes
+	lastID       streamID                  // ID of the newest entry ever added
+	maxDeletedID streamID                  // Highest ID removed by XDEL
+	entriesAdded uint64                    // Entries ever added
+	groups       map[string]*consumerGroup // Consumer groups by name
+}
+
+func newStream() *stream {
+	return &stream{}
+}
+
+func (st *stream) len() int {
+	return st.length
+}
+
+func (st *stream) clone() *stream {
+	copied := *st
+	copied.nodes = make([]*streamNode, len(st.nodes))
+	for i, node := range st.nodes {
+		dup := *node
+		dup.entries = slices.Clone(node.entries)
+		copied.nodes[i] = &dup
+	}
+	copied.groups = groupsClone(st.groups)
+	return &copied
+}
+
+// add appends an entry, whose ID must be greater than lastID
+func (st *stream) add(id streamID, fields []string) {
+	size := 0
+	for _, f := range fields {
+		size += len(f)
+	}
+	n := len(st.nodes)
+	if n == 0 || st.nodes[n-1].added >= streamNodeMaxEntries || st.nodes[n-1].bytes+size > streamNodeMaxBytes {
+		st.nodes = append(st.nodes, &streamNode{})
+		n++
+	}
+	node := st.nodes[n-1]
+	node.entries = append(node.entries, streamEntry{id, fields})
+	node.added++
+	node.bytes += size
+	st.length++
+	st.lastID = id
+	st.entriesAdded++
+}
+
+// nextID returns the ID XADD generates with a time of ms
+// Returns false if the stream holds the highest possible ID
+func (st *stream) nextID(ms uint64) (streamID, bool) {
+	if ms > st.lastID.ms {
+		return streamID{ms, 0}, true
+	}
+	return st.lastID.next()
+}
+
+// first returns the oldest entry, or false if the stream is empty
+func (st *stream) first() (streamEntry, bool) {
+	if len(st.nodes) == 0 {
+		return streamEntry{}, false
+	}
+	return st.nodes[0].entries[0], true
+}
+
+// last returns the newest entry, or false if the stream is empty
+func (st *stream) last() (streamEntry, bool) {
+	if len(st.nodes) == 0 {
+		return streamEntry{}, false
+	}
+	return st.nodes[len(st.nodes)-1].last(), true
+}
+
+// search returns the node and the position in it of the first entry with an
+// ID of at least id, which is past the last node if there is none
+func (st *stream) search(id streamID) (int, int) {
+	n, _ := slices.BinarySearchFunc(st.nodes, id, func(node *streamNode, id streamID) int {
+		return node.last().id.compare(id)
+	})
+	if n == len(st.nodes) {
+		return n, 0
+	}
+	return n, st.nodes[n].search(id)
+}
+
+// get returns the entry with the given ID
+func (st *stream) get(id streamID) (streamEntry, bool) {
+	n, i := st.search(id)
+	if n == len(st.nodes) || st.nodes[n].entries[i].id != id {
+		return streamEntry{}, false
+	}
+	return st.nodes[n].entries[i], true
+}
+
+// each calls fn with every entry in ID order, until fn returns false
+func (st *stream) each(fn func(e streamEntry) bool) {
+	for _, node := range st.nodes {
+		for _, e := range node.entries {
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// rangeEntries returns up to count entries (all if negative) with IDs in
+// [start, end], from the newest if reverse
+func (st *stream) rangeEntries(start, end streamID, count int, reverse bool) []streamEntry {
+	if start.compare(end) > 0 || count == 0 {
+		return nil
+	}
+	var entries []streamEntry
+	if !reverse {
+		n, i := st.search(start)
+		for ; n < len(st.nodes); n, i = n+1, 0 {
+			for _, e := range st.nodes[n].entries[i:] {
+				if e.id.compare(end) > 0 || len(entries) == count {
+					return entries
+				}
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+
+	n, i := len(st.nodes), 0
+	if next, ok := end.next(); ok {
+		n, i = st.search(next)
+	}
+	if n < len(st.nodes) {
+		// Entries of this node before i are the newest in range
+		entries = appendReversed(entries, st.nodes[n].entries[:i], start, count)
+	}
+	for n--; n >= 0 && len(entries) != count; n-- {
+		node := st.nodes[n]
+		if node.last().id.compare(start) < 0 {
+			break
+		}
+		entries = appendReversed(entries, node.entries, start, count)
+	}
+	return entries
+}
+
+// appendReversed appends the entries of run with IDs of at least start to
+// dst, newest first, until dst holds count entries (unless negative)
+func appendReversed(dst, run []streamEntry, start streamID, count int) []streamEntry {
+	for j := len(run) - 1; j >= 0 && len(dst) != count; j-- {
+		if run[j].id.compare(start) < 0 {
+			break
+		}
+		dst = append(dst, run[j])
+	}
+	return dst
+}
+
+// Ways a stream is trimmed
+const (
+	trimNone = iota
+	trimMaxLen
+	trimMinID
+)
+
+// StreamTrim selects the oldest entries of a stream to remove
+type StreamTrim struct {
+	Strategy int      // trimNone, trimMaxLen or trimMinID
+	MaxLen   int      // Most entries kept, with trimMaxLen
+	MinID    streamID // Lowest ID kept, with trimMinID
+	Approx   bool     // Only remove whole nodes ("~"), so some entries may stay
+	Limit    int      // Most entries removed with Approx, 0 for no limit
+}
+
+// Default LIMIT of approximate trimming, as in Redis
+const streamTrimDefaultLimit = 100 * streamNodeMaxEntries
+
+// trim removes the entries t selects
+// Returns the number of entries removed
+func (st *stream) trim(t StreamTrim) int {
+	if t.Strategy == trimNone {
+		return 0
+	}
+	// A node goes when every entry it holds is selected, or, trimming
+	// exactly, the last node reached loses the selected part
+	removed, nodes := 0, 0
+	for ; nodes < len(st.nodes); nodes++ {
+		node := st.nodes[nodes]
+		live := len(node.entries)
+		remove := live
+		switch t.Strategy {
+		case trimMaxLen:
+			remove = min(live, st.length-removed-t.MaxLen)
+		case trimMinID:
+			if node.last().id.compare(t.MinID) >= 0 {
+				remove = node.search(t.MinID)
+			}
+		}
+		if remove < live {
+			if !t.Approx && remove > 0 {
+				clear(node.entries[:remove])
+				node.entries = node.entries[remove:]
+				removed += remove
+			}
+			break
+		}
+		if t.Approx && t.Limit > 0 && removed+live > t.Limit {
+			break
+		}
+		removed += live
+	}
+	clear(st.nodes[:nodes])
+	st.nodes = st.nodes[nodes:]
+	st.length -= removed
+	return removed
+}
+
+// delete removes the entry with the given ID
+// Returns false if there is none
+func (st *stream) delete(id streamID) bool {
+	n, i := st.search(id)
+	if n == len(st.nodes) || st.nodes[n].entries[i].id != id {
+		return false
+	}
+	node := st.nodes[n]
+	if node.entries = slices.Delete(node.entries, i, i+1); len(node.entries) == 0 {
+		st.nodes = slices.Delete(st.nodes, n, n+1)
+	}
+	st.length--
+	if id.compare(st.maxDeletedID) > 0 {
+		st.maxDeletedID = id
+	}
+	return true
+}
+
+// streamValue returns the stream at key, or nil if key does not exist
+// Returns (stream, isCorrectType)
+// Callers must hold the lock.
+func (s *Store) streamValue(key string) (*stream, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeStream {
+		return nil, false
+	}
+	return entry.Value.(*stream), true
+}
+
+// mutableStream is like streamValue for callers about to change the stream
+// in place: a stream borrowed by readers outside the lock is copied first
+// Callers must hold the write lock.
+func (s *Store) mutableStream(key string) (*stream, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeStream {
+		return nil, false
+	}
+	entry.own()
+	return entry.Value.(*stream), true
+}
+
+// XAddOptions are how XADD picks the ID of the new entry and trims the stream
+type XAddOptions struct {
+	ID      streamID // Explicit ID, or its time with AutoSeq
+	AutoID  bool     // Generate the whole ID from the clock ("*")
+	AutoSeq bool     // Generate the sequence number for the time of ID ("ms-*")
+	Trim    StreamTrim
+	// Leave a missing key alone rather than creating the stream
+	NoMkStream bool
+}
+
+// XAdd appends an entry with fields to the stream at key, creating the
+// stream if needed unless opts forbid it, then trims it as opts ask
+// Returns the ID of the new entry and whether it was added, or an error message
+func (s *Store) XAdd(key string, opts XAddOptions, fields []string) (streamID, bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	switch {
+	case !ok:
+		return streamID{}, false, wrongTypeError
+	case st == nil && opts.NoMkStream:
+		return streamID{}, false, ""
+	}
+	created := st == nil
+	if created {
+		st = newStream()
+	}
+	if st.lastID == maxStreamID {
+		return streamID{}, false, streamExhaustedError
+	}
+
+	id := opts.ID
+	switch {
+	case opts.AutoID:
+		id, _ = st.nextID(uint64(max(clockNow().UnixMilli(), 0)))
+	case opts.AutoSeq:
+		switch {
+		case id.ms > st.lastID.ms:
+			id.seq = 0
+		case id.ms == st.lastID.ms:
+			if st.lastID.seq == math.MaxUint64 {
+				return streamID{}, false, streamSmallIDError
+			}
+			id.seq = st.lastID.seq + 1
+		default:
+			return streamID{}, false, streamSmallIDError
+		}
+	default:
+		if id.compare(st.lastID) <= 0 {
+			return streamID{}, false, streamSmallIDError
+		}
+	}
+
+	st.add(id, fields)
+	st.trim(opts.Trim)
+	if created {
+		s.put(key, &Entry{Type: TypeStream, Value: st})
+	} else {
+		s.keyModified(key)
+	}
+	return id, true, ""
+}
+
+// XLen returns the number of entries of the stream at key
+// Returns (length, isCorrectType)
+func (s *Store) XLen(key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	if st == nil {
+		return 0, ok
+	}
+	return st.len(), true
+}
+
+// XRange returns up to count entries (all if negative) of the stream at key
+// with IDs in [start, end], from the newest if reverse
+// Returns (entries, isCorrectType)
+func (s *Store) XRange(key string, start, end streamID, count int, reverse bool) ([]streamEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	if st == nil {
+		return nil, ok
+	}
+	return st.rangeEntries(start, end, count, reverse), true
+}
+
+// formatStreamEntries formats entries as an array of [ID, [field, value, ...]]
+// Entries without fields were deleted and have a null array instead.
+func formatStreamEntries(entries []streamEntry) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(entries)) + "\r\n")
+	for _, e := range entries {
+		fields := formatNullArray()
+		if e.fields != nil {
+			fields = formatArray(e.fields)
+		}
+		b.WriteString("*2\r\n" + formatBulkString(e.id.String()) + fields)
+	}
+	return b.String()
+}
+
+// parseStreamTrim parses the MAXLEN, MINID or LIMIT option at args[i],
+// shared by XADD and XTRIM, into t, whose Limit starts at -1 for unset
+// Returns the number of arguments consumed, or a non-empty error reply
+func parseStreamTrim(args []string, i int, t *StreamTrim) (int, string) {
+	if strings.EqualFold(args[i], "LIMIT") {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return 0, formatError("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return 0, formatError("ERR The LIMIT argument must be >= 0.")
+		}
+		t.Limit = int(min(limit, math.MaxInt))
+		return 2, ""
+	}
+
+	strategy := trimMaxLen
+	if strings.EqualFold(args[i], "MINID") {
+		strategy = trimMinID
+	}
+	if t.Strategy != trimNone && t.Strategy != strategy {
+		return 0, formatError("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+	}
+	n := 1
+	if i+n < len(args) && (args[i+n] == "=" || args[i+n] == "~") {
+		t.Approx = args[i+n] == "~"
+		n++
+	}
+	if i+n >= len(args) {
+		return 0, formatError("ERR syntax error")
+	}
+	t.Strategy = strategy
+	if strategy == trimMinID {
+		id, ok := parseStreamID(args[i+n], 0)
+		if !ok {
+			return 0, formatError(streamInvalidIDError)
+		}
+		t.MinID = id
+		return n + 1, ""
+	}
+	maxLen, err := strconv.ParseInt(args[i+n], 10, 64)
+	if err != nil {
+		return 0, formatError("ERR value is not an integer or out of range")
+	}
+	if maxLen < 0 {
+		return 0, formatError("ERR The MAXLEN argument must be >= 0.")
+	}
+	t.MaxLen = int(min(maxLen, math.MaxInt))
+	return n + 1, ""
+}
+
+// checkStreamTrim validates the options parseStreamTrim parsed, giving
+// approximate trimming its default LIMIT
+// Returns a non-empty error reply on failure
+func checkStreamTrim(t *StreamTrim) string {
+	switch {
+	case t.Limit >= 0 && !t.Approx:
+		return formatError("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	case t.Limit < 0 && t.Approx:
+		t.Limit = streamTrimDefaultLimit
+	case t.Limit < 0:
+		t.Limit = 0
+	}
+	return ""
+}
+
+// isStreamTrimOption reports whether arg names an option parseStreamTrim
+// parses
+func isStreamTrimOption(arg string) bool {
+	return strings.EqualFold(arg, "MAXLEN") || strings.EqualFold(arg, "MINID") || strings.EqualFold(arg, "LIMIT")
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]]
+// <* | id> field value [field value ...]
+func xaddCommand(c *Client, args []string) string {
+	opts := XAddOptions{Trim: StreamTrim{Limit: -1}}
+	i := 2
+	for ; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "NOMKSTREAM"):
+			opts.NoMkStream = true
+			continue
+		case isStreamTrimOption(args[i]) && i+1 < len(args):
+			n, errReply := parseStreamTrim(args, i, &opts.Trim)
+			if errReply != "" {
+				return errReply
+			}
+			i += n - 1
+			continue
+		}
+		break
+	}
+	if errReply := checkStreamTrim(&opts.Trim); errReply != "" {
+		return errReply
+	}
+	if i == len(args) {
+		return formatError("ERR syntax error")
+	}
+
+	switch idArg := args[i]; {
+	case idArg == "*":
+		opts.AutoID = true
+	case strings.HasSuffix(idArg, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			return formatError(streamInvalidIDError)
+		}
+		opts.ID, opts.AutoSeq = streamID{ms: ms}, true
+	default:
+		id, ok := parseStreamID(idArg, 0)
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		if id == (streamID{}) {
+			return formatError(streamZeroIDError)
+		}
+		opts.ID = id
+	}
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return formatError("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	id, added, errMsg := c.db().XAdd(args[1], opts, slices.Clone(fields))
+	switch {
+	case errMsg != "":
+		return formatError(errMsg)
+	case !added:
+		return formatNullBulkString()
+	}
+	if opts.AutoID || opts.AutoSeq {
+		// Replayed with the ID generated
+		rewritten := slices.Clone(args)
+		rewritten[i] = id.String()
+		c.rewriteAOF(rewritten)
+	}
+	return formatBulkString(id.String())
+}
+
+// XLEN key
+func xlenCommand(c *Client, args []string) string {
+	n, ok := c.db().XLen(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// parseRangeBound parses an XRANGE bound: "-", "+", an ID whose missing
+// sequence number is the lowest for a start and the highest for an end, or
+// either prefixed with "(" to exclude it
+// Returns a non-empty error reply on failure
+func parseRangeBound(arg string, isEnd bool) (streamID, string) {
+	exclusive := len(arg) > 1 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	var id streamID
+	switch arg {
+	case "-":
+	case "+":
+		id = maxStreamID
+	default:
+		var seq uint64
+		if isEnd {
+			seq = math.MaxUint64
+		}
+		var ok bool
+		if id, ok = parseStreamID(arg, seq); !ok {
+			return streamID{}, formatError(streamInvalidIDError)
+		}
+	}
+	if !exclusive {
+		return id, ""
+	}
+	var ok bool
+	if isEnd {
+		if id, ok = id.prev(); !ok {
+			return streamID{}, formatError("ERR invalid end ID for the interval")
+		}
+	} else if id, ok = id.next(); !ok {
+		return streamID{}, formatError("ERR invalid start ID for the interval")
+	}
+	return id, ""
+}
+
+// XRANGE key start end [COUNT count]
+func xrangeCommand(c *Client, args []string) string {
+	return xrangeGeneric(c, args[1], args[2], args[3], args[4:], false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func xrevrangeCommand(c *Client, args []string) string {
+	return xrangeGeneric(c, args[1], args[3], args[2], args[4:], true)
+}
+
+func xrangeGeneric(c *Client, key, startArg, endArg string, rest []string, reverse bool) string {
+	start, errReply := parseRangeBound(startArg, false)
+	if errReply != "" {
+		return errReply
+	}
+	end, errReply := parseRangeBound(endArg, true)
+	if errReply != "" {
+		return errReply
+	}
+	count := -1
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(rest[0], "COUNT"):
+		n, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		count = int(min(max(n, 0), math.MaxInt))
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	entries, ok := c.db().XRange(key, start, end, count, reverse)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatStreamEntries(entries)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreams(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	entry := func(id string, fields ...string) string {
+		return "*2\r\n" + formatBulkString(id) + formatArray(fields)
+	}
+
+	// Test 1: Generated IDs take the time in milliseconds, and a sequence
+	// number among entries of the same millisecond or when the clock is behind
+	for _, step := range []struct{ args, want string }{
+		{"XADD s * a 1", formatBulkString("1700000000000-0")},
+		{"XADD s * b 2", formatBulkString("1700000000000-1")},
+		{"XADD s 1700000000005-* c 3", formatBulkString("1700000000005-0")},
+		{"XADD s 1700000000005-* d 4", formatBulkString("1700000000005-1")},
+		{"XADD s * e 5", formatBulkString("1700000000005-2")},
+		{"XADD s 1700000000009-7 f 6 g 7", formatBulkString("1700000000009-7")},
+		{"XLEN s", ":6\r\n"},
+		{"XLEN missing", ":0\r\n"},
+		{"XADD zero 0-* a 1", formatBulkString("0-1")},
+		{"XADD plain 5 a 1", formatBulkString("5-0")},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	vc.Advance(time.Second)
+	if reply := run("XADD", "s", "*", "h", "8"); reply != formatBulkString("1700000001000-0") {
+		t.Fatalf("expected an ID at the new time, got %q", reply)
+	}
+
+	// Test 2: Ranges, in both directions, with COUNT and exclusive bounds
+	first := entry("1700000000000-0", "a", "1")
+	second := entry("1700000000000-1", "b", "2")
+	sixth := entry("1700000000009-7", "f", "6", "g", "7")
+	last := entry("1700000001000-0", "h", "8")
+	for args, want := range map[string]string{
+		"XRANGE s - + COUNT 2":                               "*2\r\n" + first + second,
+		"XRANGE s 1700000000000 1700000000000":               "*2\r\n" + first + second,
+		"XRANGE s (1700000000000-0 1700000000000-1":          "*1\r\n" + second,
+		"XRANGE s 1700000000009 +":                           "*2\r\n" + sixth + last,
+		"XRANGE s (1700000000009-7 +":                        "*1\r\n" + last,
+		"XRANGE s - (1700000000000-1":                        "*1\r\n" + first,
+		"XREVRANGE s + - COUNT 2":                            "*2\r\n" + last + sixth,
+		"XREVRANGE s 1700000000000 - COUNT 1":                "*1\r\n" + second,
+		"XREVRANGE s (1700000000000-1 -":                     "*1\r\n" + first,
+		"XRANGE s + -":                                       "*0\r\n",
+		"XRANGE s - + COUNT 0":                               "*0\r\n",
+		"XRANGE s - + COUNT -3":                              "*0\r\n",
+		"XRANGE missing - +":                                 "*0\r\n",
+		"XRANGE s 1700000000006 1700000000009-6":             "*0\r\n",
+		"XREVRANGE s 18446744073709551615 1700000000009-7-x": "-" + streamInvalidIDError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 3: IDs must grow, even past deleted entries
+	for _, step := range []struct{ args, want string }{
+		{"XADD s 1700000001000-0 x 1", "-" + streamSmallIDError + "\r\n"},
+		{"XADD s 1-* x 1", "-" + streamSmallIDError + "\r\n"},
+		{"XADD s 0-0 x 1", "-" + streamZeroIDError + "\r\n"},
+		{"XADD trimmed MAXLEN 0 100-1 x 1", formatBulkString("100-1")},
+		{"XLEN trimmed", ":0\r\n"},
+		{"XADD trimmed 100-1 x 1", "-" + streamSmallIDError + "\r\n"},
+		{"XADD top 18446744073709551615-18446744073709551615 x 1", formatBulkString("18446744073709551615-18446744073709551615")},
+		{"XADD top * x 1", "-" + streamExhaustedError + "\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 4: MAXLEN and MINID trim the oldest entries after adding
+	for _, step := range []struct{ args, want string }{
+		{"XADD t 1-1 a 1", formatBulkString("1-1")},
+		{"XADD t 2-1 a 2", formatBulkString("2-1")},
+		{"XADD t 3-1 a 3", formatBulkString("3-1")},
+		{"XADD t MAXLEN = 2 4-1 a 4", formatBulkString("4-1")},
+		{"XRANGE t - +", "*2\r\n" + entry("3-1", "a", "3") + entry("4-1", "a", "4")},
+		{"XADD t MINID 4-1 5-1 a 5", formatBulkString("5-1")},
+		{"XRANGE t - +", "*2\r\n" + entry("4-1", "a", "4") + entry("5-1", "a", "5")},
+		{"XADD t MINID 9 6-1 a 6", formatBulkString("6-1")},
+		{"XLEN t", ":0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 5: Streams report their type and encoding, and copies are independent
+	for _, step := range []struct{ args, want string }{
+		{"OBJECT ENCODING s", formatBulkString("stream")},
+		{"SCAN 0 TYPE stream COUNT 100", ""},
+		{"COPY s s2", ":1\r\n"},
+		{"XADD s2 * new 1", formatBulkString("1700000001000-1")},
+		{"XLEN s", ":7\r\n"},
+		{"XLEN s2", ":8\r\n"},
+	} {
+		reply := run(strings.Fields(step.args)...)
+		if step.want == "" {
+			// Emptied streams are kept, as in Redis
+			if !strings.Contains(reply, formatBulkString("s")) || !strings.Contains(reply, formatBulkString("t")) || strings.Contains(reply, formatBulkString("str")) {
+				t.Fatalf("expected SCAN TYPE stream to list the streams, got %q", reply)
+			}
+			continue
+		}
+		if reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 6: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"XADD s x a 1":                  "-" + streamInvalidIDError + "\r\n",
+		"XADD s 1-x a 1":                "-" + streamInvalidIDError + "\r\n",
+		"XADD s x-* a 1":                "-" + streamInvalidIDError + "\r\n",
+		"XADD s * a 1 b":                "-ERR wrong number of arguments for 'xadd' command\r\n",
+		"XADD s MAXLEN 2 *":             "-ERR wrong number of arguments for 'xadd' command\r\n",
+		"XADD s MAXLEN -1 * a 1":        "-ERR The MAXLEN argument must be >= 0.\r\n",
+		"XADD s MAXLEN x * a 1":         "-ERR value is not an integer or out of range\r\n",
+		"XADD s MINID x * a 1":          "-" + streamInvalidIDError + "\r\n",
+		"XADD s MAXLEN 1 MINID 1 * a 1": "-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n",
+		"XADD str * a 1":                "-" + wrongTypeError + "\r\n",
+		"XLEN str":                      "-" + wrongTypeError + "\r\n",
+		"XRANGE str - +":                "-" + wrongTypeError + "\r\n",
+		"XRANGE s x +":                  "-" + streamInvalidIDError + "\r\n",
+		"XRANGE s (+ +":                 "-ERR invalid start ID for the interval\r\n",
+		"XRANGE s - (-":                 "-ERR invalid end ID for the interval\r\n",
+		"XRANGE s - + COUNT x":          "-ERR value is not an integer or out of range\r\n",
+		"XRANGE s - + LIMIT 1":          "-ERR syntax error\r\n",
+		"LPUSH s x":                     "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
+
+func TestStreamNodes(t *testing.T) {
+	st := newStream()
+	var ref []streamEntry
+	refRange := func(start, end streamID, count int, reverse bool) []streamEntry {
+		var entries []streamEntry
+		for i := range ref {
+			e := ref[i]
+			if reverse {
+				e = ref[len(ref)-1-i]
+			}
+			if e.id.compare(start) >= 0 && e.id.compare(end) <= 0 && len(entries) != count {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	randomID := func() streamID {
+		return streamID{uint64(rand.IntN(int(st.lastID.ms) + 2)), uint64(rand.IntN(3))}
+	}
+
+	// Test 1: Adding, deleting and trimming across many nodes keeps lookups
+	// and ranges in both directions matching a flat list
+	for n := 0; n < 5000; n++ {
+		switch op := rand.IntN(10); {
+		case op < 6:
+			id := streamID{st.lastID.ms + uint64(rand.IntN(2)), uint64(rand.IntN(3))}
+			if id.compare(st.lastID) <= 0 {
+				id, _ = st.lastID.next()
+			}
+			st.add(id, []string{"f", strconv.Itoa(n)})
+			ref = append(ref, streamEntry{id, []string{"f", strconv.Itoa(n)}})
+		case op < 8 && len(ref) > 0:
+			i := rand.IntN(len(ref))
+			if !st.delete(ref[i].id) {
+				t.Fatalf("expected %v to be deleted", ref[i].id)
+			}
+			ref = slices.Delete(ref, i, i+1)
+		case op == 8 && n%20 == 0:
+			maxLen := max(len(ref)-rand.IntN(20), 0)
+			if removed := st.trim(StreamTrim{Strategy: trimMaxLen, MaxLen: maxLen}); removed != len(ref)-maxLen {
+				t.Fatalf("expected MAXLEN %d to remove %d entries, removed %d", maxLen, len(ref)-maxLen, removed)
+			}
+			ref = ref[len(ref)-maxLen:]
+		case op == 9 && n%20 == 0:
+			minID := randomID()
+			st.trim(StreamTrim{Strategy: trimMinID, MinID: minID})
+			ref = slices.DeleteFunc(ref, func(e streamEntry) bool { return e.id.compare(minID) < 0 })
+		}
+		if st.len() != len(ref) {
+			t.Fatalf("expected %d entries, got %d", len(ref), st.len())
+		}
+		start, end := randomID(), randomID()
+		count := rand.IntN(30) - 1
+		for _, reverse := range []bool{false, true} {
+			if got, want := st.rangeEntries(start, end, count, reverse), refRange(start, end, count, reverse); !reflect.DeepEqual(got, want) && (len(got) > 0 || len(want) > 0) {
+				t.Fatalf("range [%v, %v] count %d reverse %v: got %v, want %v", start, end, count, reverse, got, want)
+			}
+		}
+		id := randomID()
+		_, got := st.get(id)
+		if want := slices.ContainsFunc(ref, func(e streamEntry) bool { return e.id == id }); got != want {
+			t.Fatalf("get(%v): got %v, want %v", id, got, want)
+		}
+	}
+
+	// Test 2: No node is left empty, and each holds at most a node's worth
+	total := 0
+	for _, node := range st.nodes {
+		if len(node.entries) == 0 || node.added > streamNodeMaxEntries {
+			t.Fatalf("unexpected node with %d entries of %d added", len(node.entries), node.added)
+		}
+		total += len(node.entries)
+	}
+	if total != st.len() {
+		t.Fatalf("expected nodes to hold %d entries, got %d", st.len(), total)
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pendingEntry is an entry delivered to a consumer of a group and not
+// acknowledged yet
+type pendingEntry struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  time.Time // Last delivery
+	deliveryCount int64
+}
+
+// streamConsumer is a named reader of a consumer group
+type streamConsumer struct {
+	name       string
+	seenTime   time.Time // Last read attempt
+	activeTime time.Time // Last read that delivered entries, zero if none did
+	pending    map[streamID]*pendingEntry
+}
+
+// consumerGroup tracks which entries of a stream were delivered to its
+// consumers, and which of those they acknowledged
+type consumerGroup struct {
+	lastID      streamID        // Newest entry delivered with ">"
+	entriesRead int64           // Entries of the stream up to lastID, or -1 if unknown
+	pending     []*pendingEntry // Pending entries list of every consumer, in ID order
+	consumers   map[string]*streamConsumer
+}
+
+func newConsumerGroup(lastID streamID, entriesRead int64) *consumerGroup {
+	return &consumerGroup{lastID: lastID, entriesRead: entriesRead, consumers: make(map[string]*streamConsumer)}
+}
+
+// clone deeply copies the group, its consumers and their pending entries
+func (g *consumerGroup) clone() *consumerGroup {
+	copied := newConsumerGroup(g.lastID, g.entriesRead)
+	for name, consumer := range g.consumers {
+		copied.consumers[name] = &streamConsumer{
+			name:       name,
+			seenTime:   consumer.seenTime,
+			activeTime: consumer.activeTime,
+			pending:    make(map[streamID]*pendingEntry, len(consumer.pending)),
+		}
+	}
+	copied.pending = make([]*pendingEntry, len(g.pending))
+	for i, pe := range g.pending {
+		c := copied.consumers[pe.consumer.name]
+		dup := *pe
+		dup.consumer = c
+		copied.pending[i] = &dup
+		c.pending[pe.id] = &dup
+	}
+	return copied
+}
+
+// consumer returns the named consumer, created if create allows it
+func (g *consumerGroup) consumer(name string, create bool) *streamConsumer {
+	c := g.consumers[name]
+	if c == nil && create {
+		c = &streamConsumer{name: name, seenTime: clockNow(), pending: make(map[streamID]*pendingEntry)}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// consumerCommands returns the XGROUP CREATECONSUMER replaying the creation
+// of the named consumer, if it does not exist yet
+func (g *consumerGroup) consumerCommands(key, group, name string) [][]string {
+	if g.consumers[name] != nil {
+		return nil
+	}
+	return [][]string{{"XGROUP", "CREATECONSUMER", key, group, name}}
+}
+
+// setIDCommand returns the XGROUP SETID replaying where the group delivers
+// new entries from
+func (g *consumerGroup) setIDCommand(key, group string) []string {
+	return []string{"XGROUP", "SETID", key, group, g.lastID.String(), "ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10)}
+}
+
+// searchPending returns the position in the pending entries list of the
+// first entry with an ID of at least id, and whether it is id itself
+func (g *consumerGroup) searchPending(id streamID) (int, bool) {
+	return slices.BinarySearchFunc(g.pending, id, func(pe *pendingEntry, id streamID) int {
+		return pe.id.compare(id)
+	})
+}
+
+// deliver records that the entry id was delivered to consumer c, moving it
+// from another consumer if it was pending there
+func (g *consumerGroup) deliver(c *streamConsumer, id streamID, now time.Time) {
+	i, found := g.searchPending(id)
+	if found {
+		pe := g.pending[i]
+		delete(pe.consumer.pending, id)
+		pe.consumer, pe.deliveryTime, pe.deliveryCount = c, now, 1
+		c.pending[id] = pe
+		return
+	}
+	pe := &pendingEntry{id: id, consumer: c, deliveryTime: now, deliveryCount: 1}
+	g.pending = slices.Insert(g.pending, i, pe)
+	c.pending[id] = pe
+}
+
+// ack removes the entry id from the pending entries list
+// Returns false if it was not pending
+func (g *consumerGroup) ack(id streamID) bool {
+	i, found := g.searchPending(id)
+	if !found {
+		return false
+	}
+	delete(g.pending[i].consumer.pending, id)
+	g.pending = slices.Delete(g.pending, i, i+1)
+	return true
+}
+
+// deleteConsumer removes a consumer along with its pending entries
+// Returns the number of entries that were pending
+func (g *consumerGroup) deleteConsumer(name string) int {
+	c := g.consumers[name]
+	if c == nil {
+		return 0
+	}
+	n := len(c.pending)
+	for id := range c.pending {
+		g.ack(id)
+	}
+	delete(g.consumers, name)
+	return n
+}
+
+// groupsClone deeply copies the consumer groups of a stream
+func groupsClone(groups map[string]*consumerGroup) map[string]*consumerGroup {
+	if groups == nil {
+		return nil
+	}
+	copied := maps.Clone(groups)
+	for name, g := range copied {
+		copied[name] = g.clone()
+	}
+	return copied
+}
+
+// Error messages of consumer group commands
+const (
+	xgroupNoKeyError = "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+	busyGroupError   = "BUSYGROUP Consumer Group name already exists"
+)
+
+// noGroupError is the error of group commands naming a missing group
+func noGroupError(key, group string) string {
+	return "NOGROUP No such consumer group '" + group + "' for key name '" + key + "'"
+}
+
+// streamGroup returns the stream at key and its group, for XGROUP
+// subcommands, which fail on missing keys
+// Returns (stream, group, errMsg) with a nil group if it does not exist
+// Callers must hold the write lock.
+func (s *Store) streamGroup(key, group string) (*stream, *consumerGroup, string) {
+	st, ok := s.mutableStream(key)
+	switch {
+	case !ok:
+		return nil, nil, wrongTypeError
+	case st == nil:
+		return nil, nil, xgroupNoKeyError
+	}
+	return st, st.groups[group], ""
+}
+
+// XGroupCreate creates a group on the stream at key that delivers entries
+// after id, or after the newest one with useLast, having read entriesRead
+// of them (-1 if unknown). With mkstream a missing key gets an empty stream.
+// Returns an error message on failure
+func (s *Store) XGroupCreate(key, group string, id streamID, useLast bool, entriesRead int64, mkstream bool) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	switch {
+	case !ok:
+		return wrongTypeError
+	case st == nil && !mkstream:
+		return xgroupNoKeyError
+	case st != nil && st.groups[group] != nil:
+		return busyGroupError
+	}
+	created := st == nil
+	if created {
+		st = newStream()
+	}
+	if useLast {
+		id = st.lastID
+	}
+	if st.groups == nil {
+		st.groups = make(map[string]*consumerGroup)
+	}
+	st.groups[group] = newConsumerGroup(id, entriesRead)
+	if created {
+		s.put(key, &Entry{Type: TypeStream, Value: st})
+	} else {
+		s.keyModified(key)
+	}
+	return ""
+}
+
+// XGroupSetID makes a group deliver entries after id, or after the newest
+// one with useLast, having read entriesRead of them (-1 if unknown)
+// Returns an error message on failure
+func (s *Store) XGroupSetID(key, group string, id streamID, useLast bool, entriesRead int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, g, errMsg := s.streamGroup(key, group)
+	switch {
+	case errMsg != "":
+		return errMsg
+	case g == nil:
+		return noGroupError(key, group)
+	}
+	if useLast {
+		id = st.lastID
+	}
+	g.lastID, g.entriesRead = id, entriesRead
+	s.keyModified(key)
+	return ""
+}
+
+// XGroupDestroy removes a group along with its consumers
+// Returns whether the group existed, or an error message
+func (s *Store) XGroupDestroy(key, group string) (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, g, errMsg := s.streamGroup(key, group)
+	if g == nil {
+		return false, errMsg
+	}
+	delete(st.groups, group)
+	s.keyModified(key)
+	return true, ""
+}
+
+// XGroupCreateConsumer adds a consumer to a group
+// Returns whether it was created, or an error message
+func (s *Store) XGroupCreateConsumer(key, group, consumer string) (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, g, errMsg := s.streamGroup(key, group)
+	switch {
+	case errMsg != "":
+		return false, errMsg
+	case g == nil:
+		return false, noGroupError(key, group)
+	case g.consumers[consumer] != nil:
+		return false, ""
+	}
+	g.consumer(consumer, true)
+	s.keyModified(key)
+	return true, ""
+}
+
+// XGroupDelConsumer removes a consumer from a group
+// Returns the number of entries that were pending for it, or an error message
+func (s *Store) XGroupDelConsumer(key, group, consumer string) (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, g, errMsg := s.streamGroup(key, group)
+	switch {
+	case errMsg != "":
+		return 0, errMsg
+	case g == nil:
+		return 0, noGroupError(key, group)
+	}
+	n := g.deleteConsumer(consumer)
+	s.keyModified(key)
+	return n, ""
+}
+
+// groupRead is what XREADGROUP reads from one stream
+type groupRead struct {
+	key   string
+	after streamID // Entries pending for the consumer after this ID, unless New
+	new   bool     // Entries never delivered to the group (">")
+}
+
+// readGroup delivers stream entries to a consumer of group, creating the
+// consumer if needed. New entries are added to the pending entries list
+// unless noack, and move the group past them. Entries pending for the
+// consumer that were deleted since come with nil fields.
+// Returns the reads, skipping streams without new entries, and the commands
+// replaying the deliveries, or an error message
+// Callers must hold the write lock.
+func (s *Store) readGroup(reads []groupRead, group, consumer string, count int, noack bool) ([]streamRead, [][]string, string) {
+	for _, r := range reads {
+		st, ok := s.streamValue(r.key)
+		switch {
+		case !ok:
+			return nil, nil, wrongTypeError
+		case st == nil || st.groups[group] == nil:
+			return nil, nil, "NOGROUP No such key '" + r.key + "' or consumer group '" + group + "' in XREADGROUP with GROUP option"
+		}
+	}
+
+	now := clockNow()
+	var results []streamRead
+	var replay [][]string
+	for _, r := range reads {
+		st, _ := s.mutableStream(r.key)
+		g := st.groups[group]
+		replay = append(replay, g.consumerCommands(r.key, group, consumer)...)
+		c := g.consumer(consumer, true)
+		c.seenTime = now
+
+		var entries []streamEntry
+		if r.new {
+			if start, ok := g.lastID.next(); ok {
+				entries = st.rangeEntries(start, maxStreamID, count, false)
+			}
+			for _, e := range entries {
+				if !noack {
+					g.deliver(c, e.id, now)
+					i, _ := g.searchPending(e.id)
+					replay = append(replay, g.pending[i].claimCommand(r.key, group))
+				}
+				st.advanceGroup(g, e.id)
+			}
+			if len(entries) > 0 {
+				replay = append(replay, g.setIDCommand(r.key, group))
+			}
+		} else {
+			// The consumer's history, including entries since deleted
+			i, _ := g.searchPending(r.after)
+			for ; i < len(g.pending) && (count < 0 || len(entries) < count); i++ {
+				pe := g.pending[i]
+				if pe.consumer != c || pe.id == r.after {
+					continue
+				}
+				pe.deliveryTime = now
+				pe.deliveryCount++
+				e, ok := st.get(pe.id)
+				if ok {
+					replay = append(replay, pe.claimCommand(r.key, group))
+				} else {
+					// Left as logged: replaying an XCLAIM of a deleted entry
+					// would acknowledge it
+					e = streamEntry{id: pe.id}
+				}
+				entries = append(entries, e)
+			}
+		}
+		if len(entries) > 0 {
+			c.activeTime = now
+		}
+		if len(entries) > 0 || !r.new {
+			results = append(results, streamRead{r.key, entries})
+		}
+		s.keyModified(r.key)
+	}
+	return results, replay, ""
+}
+
+// XAck removes entries from the pending entries list of a group
+// Returns (entries acknowledged, isCorrectType)
+func (s *Store) XAck(key, group string, ids []streamID) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	if st == nil || st.groups[group] == nil {
+		return 0, ok
+	}
+	g := st.groups[group]
+	n := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			n++
+		}
+	}
+	if n > 0 {
+		s.keyModified(key)
+	}
+	return n, true
+}
+
+var xgroupHelp = []string{
+	"XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CREATE <key> <groupname> <id|$> [option]",
+	"    Create a new consumer group. Options are:",
+	"    * MKSTREAM",
+	"      Create the empty stream if it does not exist.",
+	"    * ENTRIESREAD entries_read",
+	"      Set the group's entries_read counter (internal use).",
+	"CREATECONSUMER <key> <groupname> <consumer>",
+	"    Create a new consumer in the specified group.",
+	"DELCONSUMER <key> <groupname> <consumer>",
+	"    Remove the specified consumer.",
+	"DESTROY <key> <groupname>",
+	"    Remove the specified group.",
+	"SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]",
+	"    Set the current group ID and entries_read counter.",
+	"HELP",
+	"    Print this help.",
+}
+
+// parseGroupID parses the ID of XGROUP CREATE and SETID, where "$" is the
+// newest entry of the stream
+// Returns (id, useLast, ok)
+func parseGroupID(arg string) (streamID, bool, bool) {
+	if arg == "$" {
+		return streamID{}, true, true
+	}
+	id, ok := parseStreamID(arg, 0)
+	return id, false, ok
+}
+
+// XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER|HELP ...
+func xgroupCommand(c *Client, args []string) string {
+	sub := strings.ToUpper(args[1])
+	if sub == "HELP" && len(args) == 2 {
+		lines := ""
+		for _, line := range xgroupHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(xgroupHelp)) + "\r\n" + lines
+	}
+
+	arity := map[string]int{"CREATE": -5, "SETID": -5, "DESTROY": 4, "CREATECONSUMER": 5, "DELCONSUMER": 5}
+	n, known := arity[sub]
+	switch {
+	case !known:
+		return formatError("ERR unknown subcommand '" + args[1] + "'. Try XGROUP HELP.")
+	case (n > 0 && len(args) != n) || (n < 0 && len(args) < -n):
+		return formatError("ERR wrong number of arguments for 'xgroup|" + strings.ToLower(sub) + "' command")
+	}
+
+	db := c.db()
+	key, group := args[2], args[3]
+	switch sub {
+	case "CREATE", "SETID":
+		id, useLast, ok := parseGroupID(args[4])
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		mkstream, entriesRead := false, int64(-1)
+		for i := 5; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i]); {
+			case opt == "MKSTREAM" && sub == "CREATE":
+				mkstream = true
+			case opt == "ENTRIESREAD" && i+1 < len(args):
+				i++
+				n, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil {
+					return formatError("ERR value is not an integer or out of range")
+				}
+				if n < -1 {
+					return formatError("ERR value for ENTRIESREAD must be positive or -1")
+				}
+				entriesRead = n
+			default:
+				return formatError("ERR syntax error")
+			}
+		}
+		var errMsg string
+		if sub == "SETID" {
+			errMsg = db.XGroupSetID(key, group, id, useLast, entriesRead)
+		} else {
+			errMsg = db.XGroupCreate(key, group, id, useLast, entriesRead, mkstream)
+		}
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		return formatSimpleString("OK")
+	case "DESTROY":
+		destroyed, errMsg := db.XGroupDestroy(key, group)
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		if !destroyed {
+			return formatInteger(0)
+		}
+		return formatInteger(1)
+	case "CREATECONSUMER":
+		created, errMsg := db.XGroupCreateConsumer(key, group, args[4])
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		if !created {
+			return formatInteger(0)
+		}
+		return formatInteger(1)
+	default:
+		pending, errMsg := db.XGroupDelConsumer(key, group, args[4])
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		return formatInteger(pending)
+	}
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...]
+func xreadgroupCommand(c *Client, args []string) string {
+	if !strings.EqualFold(args[1], "GROUP") {
+		return formatError("ERR syntax error")
+	}
+	group, consumer := args[2], args[3]
+	count, block, noack := -1, time.Duration(-1), false
+	i := 4
+options:
+	for ; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		switch {
+		case name == "STREAMS":
+			break options
+		case name == "NOACK":
+			noack = true
+			continue
+		case name == "COUNT" && i+1 < len(args):
+			var errReply string
+			if count, errReply = parseStreamCount(args[i+1]); errReply != "" {
+				return errReply
+			}
+		case name == "BLOCK" && i+1 < len(args):
+			var errReply string
+			if block, errReply = parseBlockMillis(args[i+1]); errReply != "" {
+				return errReply
+			}
+		default:
+			return formatError("ERR syntax error")
+		}
+		i++
+	}
+	if i == len(args) {
+		return formatError("ERR syntax error")
+	}
+	keys, idArgs, errReply := parseStreamsArgs("xreadgroup", args[i+1:])
+	if errReply != "" {
+		return errReply
+	}
+
+	reads := make([]groupRead, len(keys))
+	onlyNew := true
+	for j, arg := range idArgs {
+		reads[j].key = keys[j]
+		switch arg {
+		case ">":
+			reads[j].new = true
+		case "$":
+			return formatError("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			id, ok := parseStreamID(arg, 0)
+			if !ok {
+				return formatError(streamInvalidIDError)
+			}
+			reads[j].after = id
+			onlyNew = false
+		}
+	}
+
+	serve := func(db *Store, _ string) (string, bool) {
+		results, replay, errMsg := db.readGroup(reads, group, consumer, count, noack)
+		if errMsg != "" {
+			return formatError(errMsg), true
+		}
+		// Replayed as the claims and group position the read results in, on
+		// this client even when another one serves it while blocked
+		c.rewriteAOF(replay...)
+		if len(results) == 0 {
+			return "", false
+		}
+		return c.formatStreamReads(results), true
+	}
+	if block < 0 || !onlyNew {
+		// Reading history never blocks
+		db := c.db()
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if reply, ok := serve(db, ""); ok {
+			return reply
+		}
+		return formatNullArray()
+	}
+
+	reply, bc := c.db().serveOrBlock(keys, TypeStream, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, block, formatNullArray())
+}
+
+// XACK key group id [id ...]
+func xackCommand(c *Client, args []string) string {
+	ids := make([]streamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		ids = append(ids, id)
+	}
+	n, ok := c.db().XAck(args[1], args[2], ids)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
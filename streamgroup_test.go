@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamGroups(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	entry := func(id string, fields ...string) string {
+		return "*2\r\n" + formatBulkString(id) + formatArray(fields)
+	}
+	read := func(key string, entries ...string) string {
+		return "*1\r\n*2\r\n" + formatBulkString(key) + "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+	}
+	run("XADD", "s", "1-1", "f", "1")
+	run("XADD", "s", "2-1", "f", "2")
+	run("XADD", "s", "3-1", "f", "3")
+
+	// Test 1: Consumers of a group share the new entries, each delivered once
+	for _, step := range []struct{ args, want string }{
+		{"XGROUP CREATE s g 0", "+OK\r\n"},
+		{"XGROUP CREATE s g $", "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{"XREADGROUP GROUP g alice COUNT 2 STREAMS s >", read("s", entry("1-1", "f", "1"), entry("2-1", "f", "2"))},
+		{"XREADGROUP GROUP g bob STREAMS s >", read("s", entry("3-1", "f", "3"))},
+		{"XREADGROUP GROUP g bob STREAMS s >", "*-1\r\n"},
+		{"XADD s 4-1 f 4", formatBulkString("4-1")},
+		{"XREADGROUP GROUP g alice NOACK STREAMS s >", read("s", entry("4-1", "f", "4"))},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 2: An explicit ID reads the consumer's own pending entries, and
+	// acknowledged entries leave them
+	for _, step := range []struct{ args, want string }{
+		{"XREADGROUP GROUP g alice STREAMS s 0", read("s", entry("1-1", "f", "1"), entry("2-1", "f", "2"))},
+		{"XREADGROUP GROUP g alice COUNT 1 STREAMS s 1-1", read("s", entry("2-1", "f", "2"))},
+		{"XREADGROUP GROUP g bob STREAMS s 0", read("s", entry("3-1", "f", "3"))},
+		{"XACK s g 1-1 3-1 9-9", ":2\r\n"},
+		{"XACK s g 1-1", ":0\r\n"},
+		{"XREADGROUP GROUP g alice STREAMS s 0", read("s", entry("2-1", "f", "2"))},
+		{"XREADGROUP GROUP g bob STREAMS s 0", read("s")},
+		{"XREADGROUP GROUP g carol STREAMS s 0", read("s")},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	st, _ := databases.Get(0).streamValue("s")
+	g := st.groups["g"]
+	if len(g.pending) != 1 || g.pending[0].deliveryCount != 4 || g.pending[0].consumer.name != "alice" {
+		t.Fatalf("expected 2-1 pending for alice after 4 deliveries, got %+v", g.pending)
+	}
+
+	// Test 3: SETID rewinds the group, redelivering entries to whoever reads
+	// them next; XGROUP manages consumers and groups
+	for _, step := range []struct{ args, want string }{
+		{"XGROUP SETID s g 1-1", "+OK\r\n"},
+		{"XREADGROUP GROUP g bob COUNT 1 STREAMS s >", read("s", entry("2-1", "f", "2"))},
+		{"XREADGROUP GROUP g alice STREAMS s 0", read("s")},
+		{"XGROUP CREATECONSUMER s g dave", ":1\r\n"},
+		{"XGROUP CREATECONSUMER s g dave", ":0\r\n"},
+		{"XGROUP DELCONSUMER s g bob", ":1\r\n"},
+		{"XGROUP DELCONSUMER s g nobody", ":0\r\n"},
+		{"XGROUP CREATE s late $", "+OK\r\n"},
+		{"XREADGROUP GROUP late x STREAMS s >", "*-1\r\n"},
+		{"XGROUP DESTROY s late", ":1\r\n"},
+		{"XGROUP DESTROY s late", ":0\r\n"},
+		{"XGROUP CREATE fresh g $ MKSTREAM", "+OK\r\n"},
+		{"XLEN fresh", ":0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	if g := st.groups["g"]; len(g.pending) != 0 || len(g.consumers) != 3 {
+		t.Fatalf("expected bob's entries gone with him, got %d pending and %d consumers", len(g.pending), len(g.consumers))
+	}
+
+	// Test 4: Blocked readers get entries added to the stream
+	done := make(chan string, 1)
+	go func() {
+		done <- (&Client{}).execute([]string{"XREADGROUP", "GROUP", "g", "erin", "BLOCK", "5000", "STREAMS", "fresh", ">"})
+	}()
+	waitForBlocked(t, 1)
+	run("XADD", "fresh", "5-5", "k", "v")
+	if reply := <-done; reply != read("fresh", entry("5-5", "k", "v")) {
+		t.Fatalf("expected the blocked reader to get the new entry, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+	go func() {
+		done <- (&Client{}).execute([]string{"XREADGROUP", "GROUP", "g", "erin", "BLOCK", "5000", "STREAMS", "fresh", ">"})
+	}()
+	waitForBlocked(t, 1)
+	run("XGROUP", "DESTROY", "fresh", "g")
+	if reply := <-done; !strings.HasPrefix(reply, "-NOGROUP") {
+		t.Fatalf("expected a destroyed group to fail the blocked reader, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+
+	// Test 5: Copies carry independent groups
+	run("COPY", "s", "s2")
+	run("XACK", "s2", "g", "2-1")
+	run("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s2", ">")
+	if g := st.groups["g"]; g.lastID != (streamID{2, 1}) {
+		t.Fatalf("expected the original group untouched, got last ID %v", g.lastID)
+	}
+
+	// Test 6: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"XGROUP CREATE missing g $":              "-" + xgroupNoKeyError + "\r\n",
+		"XGROUP SETID missing g $":               "-" + xgroupNoKeyError + "\r\n",
+		"XGROUP SETID s nogroup $":               "-NOGROUP No such consumer group 'nogroup' for key name 's'\r\n",
+		"XGROUP CREATECONSUMER s nogroup c":      "-NOGROUP No such consumer group 'nogroup' for key name 's'\r\n",
+		"XGROUP CREATE s g2 x":                   "-" + streamInvalidIDError + "\r\n",
+		"XGROUP CREATE s g2 $ BAD":               "-ERR syntax error\r\n",
+		"XGROUP CREATE str g $":                  "-" + wrongTypeError + "\r\n",
+		"XGROUP DESTROY s":                       "-ERR wrong number of arguments for 'xgroup|destroy' command\r\n",
+		"XGROUP FOO s g":                         "-ERR unknown subcommand 'FOO'. Try XGROUP HELP.\r\n",
+		"XREADGROUP GROUP nogroup c STREAMS s >": "-NOGROUP No such key 's' or consumer group 'nogroup' in XREADGROUP with GROUP option\r\n",
+		"XREADGROUP GROUP g c STREAMS missing >": "-NOGROUP No such key 'missing' or consumer group 'g' in XREADGROUP with GROUP option\r\n",
+		"XREADGROUP GROUP g c STREAMS s $":       "-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n",
+		"XREADGROUP GROUP g c STREAMS s str > >": "-" + wrongTypeError + "\r\n",
+		"XREADGROUP GROUP g c STREAMS s > 0":     "-ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '$' must be specified.\r\n",
+		"XREADGROUP GRP g c STREAMS s >":         "-ERR syntax error\r\n",
+		"XACK s g x":                             "-" + streamInvalidIDError + "\r\n",
+		"XACK str g 1-1":                         "-" + wrongTypeError + "\r\n",
+		"XACK missing g 1-1":                     ":0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	if reply := run("XGROUP", "HELP"); !strings.HasPrefix(reply, "*17\r\n") {
+		t.Fatalf("unexpected XGROUP HELP reply %q", reply)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// firstID returns the ID of the oldest entry, or 0-0 if the stream is empty
+func (st *stream) firstID() streamID {
+	first, _ := st.first()
+	return first.id
+}
+
+// hasTombstones reports whether XDEL may have removed entries with IDs
+// between start and end, which breaks counting entries from the newest ones
+func (st *stream) hasTombstones(start, end streamID) bool {
+	if st.len() == 0 || st.maxDeletedID == (streamID{}) || st.firstID().compare(st.maxDeletedID) > 0 {
+		return false
+	}
+	return start.compare(st.maxDeletedID) <= 0 && st.maxDeletedID.compare(end) <= 0
+}
+
+// entriesUpTo returns how many entries were ever added up to id, when the
+// counters of the stream tell, or -1
+func (st *stream) entriesUpTo(id streamID) int64 {
+	added := int64(st.entriesAdded)
+	switch {
+	case added == 0:
+		return 0
+	case st.len() == 0 && id.compare(st.lastID) <= 0:
+		return added
+	}
+	switch c := id.compare(st.lastID); {
+	case c == 0:
+		return added
+	case c > 0:
+		return -1
+	}
+	if st.maxDeletedID == (streamID{}) || st.maxDeletedID.compare(st.firstID()) < 0 {
+		// No entry was deleted after the first one, so entries were only
+		// trimmed from the start
+		switch id.compare(st.firstID()) {
+		case -1:
+			return added - int64(st.len())
+		case 0:
+			return added - int64(st.len()) + 1
+		}
+	}
+	return -1
+}
+
+// advanceGroup moves the group past the entry id it just read, counting it
+func (st *stream) advanceGroup(g *consumerGroup, id streamID) {
+	if id.compare(g.lastID) <= 0 {
+		return
+	}
+	if g.entriesRead >= 0 && !st.hasTombstones(g.lastID, id) {
+		g.entriesRead++
+	} else if st.entriesAdded > 0 {
+		g.entriesRead = st.entriesUpTo(id)
+	}
+	g.lastID = id
+}
+
+// lag returns how many entries the group has yet to read
+// Returns false if the counters of the stream cannot tell
+func (st *stream) lag(g *consumerGroup) (int64, bool) {
+	added := int64(st.entriesAdded)
+	if added == 0 {
+		return 0, true
+	}
+	if g.entriesRead >= 0 && !st.hasTombstones(g.lastID, maxStreamID) {
+		return added - g.entriesRead, true
+	}
+	if read := st.entriesUpTo(g.lastID); read >= 0 {
+		return added - read, true
+	}
+	return 0, false
+}
+
+// StreamInfo is what XINFO STREAM reports
+type StreamInfo struct {
+	Length       int
+	Nodes        int
+	LastID       streamID
+	MaxDeletedID streamID
+	EntriesAdded uint64
+	FirstID      streamID
+	Groups       int
+	First, Last  *streamEntry  // Oldest and newest entries, nil if empty
+	Entries      []streamEntry // Oldest entries, with FULL
+	GroupDetails []GroupInfo   // Groups with their pending entries and consumers, with FULL
+}
+
+// GroupInfo is what XINFO reports about a consumer group
+type GroupInfo struct {
+	Name        string
+	Consumers   []ConsumerInfo // Only counted outside XINFO STREAM FULL
+	Pending     int
+	LastID      streamID
+	EntriesRead int64 // -1 if unknown
+	Lag         int64 // -1 if unknown
+	PEL         []PendingInfo
+}
+
+// ConsumerInfo is what XINFO reports about a consumer
+type ConsumerInfo struct {
+	Name       string
+	Pending    int
+	SeenTime   time.Time
+	ActiveTime time.Time // Zero if the consumer never read an entry
+	PEL        []PendingInfo
+}
+
+// groupInfo describes the group, with up to count entries (all if
+// negative) of its pending entries lists if detailed
+func (st *stream) groupInfo(name string, g *consumerGroup, now time.Time, detailed bool, count int) GroupInfo {
+	info := GroupInfo{Name: name, Pending: len(g.pending), LastID: g.lastID, EntriesRead: g.entriesRead, Lag: -1}
+	if lag, ok := st.lag(g); ok {
+		info.Lag = lag
+	}
+	limit := func(n int) int {
+		if count < 0 {
+			return n
+		}
+		return min(n, count)
+	}
+	for _, consumerName := range slices.Sorted(maps.Keys(g.consumers)) {
+		c := g.consumers[consumerName]
+		ci := ConsumerInfo{Name: consumerName, Pending: len(c.pending), SeenTime: c.seenTime, ActiveTime: c.activeTime}
+		if detailed {
+			for _, pe := range g.pending {
+				if len(ci.PEL) == limit(len(c.pending)) {
+					break
+				}
+				if pe.consumer == c {
+					ci.PEL = append(ci.PEL, pe.info(now))
+				}
+			}
+		}
+		info.Consumers = append(info.Consumers, ci)
+	}
+	if detailed {
+		for _, pe := range g.pending[:limit(len(g.pending))] {
+			info.PEL = append(info.PEL, pe.info(now))
+		}
+	}
+	return info
+}
+
+// XInfoStream describes the stream at key; full adds up to count (all if
+// negative) entries, along with every group in detail
+// Returns the description, or an error message
+func (s *Store) XInfoStream(key string, full bool, count int) (StreamInfo, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	switch {
+	case !ok:
+		return StreamInfo{}, wrongTypeError
+	case st == nil:
+		return StreamInfo{}, "ERR no such key"
+	}
+	info := StreamInfo{
+		Length:       st.len(),
+		Nodes:        len(st.nodes),
+		LastID:       st.lastID,
+		MaxDeletedID: st.maxDeletedID,
+		EntriesAdded: st.entriesAdded,
+		FirstID:      st.firstID(),
+		Groups:       len(st.groups),
+	}
+	if !full {
+		if first, ok := st.first(); ok {
+			last, _ := st.last()
+			info.First, info.Last = &first, &last
+		}
+		return info, ""
+	}
+	info.Entries = st.rangeEntries(streamID{}, maxStreamID, count, false)
+	now := clockNow()
+	for _, name := range slices.Sorted(maps.Keys(st.groups)) {
+		info.GroupDetails = append(info.GroupDetails, st.groupInfo(name, st.groups[name], now, true, count))
+	}
+	return info, ""
+}
+
+// XInfoGroups describes the groups of the stream at key, in name order
+// Returns the descriptions, or an error message
+func (s *Store) XInfoGroups(key string) ([]GroupInfo, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	switch {
+	case !ok:
+		return nil, wrongTypeError
+	case st == nil:
+		return nil, "ERR no such key"
+	}
+	infos := []GroupInfo{}
+	now := clockNow()
+	for _, name := range slices.Sorted(maps.Keys(st.groups)) {
+		infos = append(infos, st.groupInfo(name, st.groups[name], now, false, 0))
+	}
+	return infos, ""
+}
+
+// XInfoConsumers describes the consumers of a group, in name order
+// Returns the descriptions, or an error message
+func (s *Store) XInfoConsumers(key, group string) ([]ConsumerInfo, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	switch {
+	case !ok:
+		return nil, wrongTypeError
+	case st == nil:
+		return nil, "ERR no such key"
+	case st.groups[group] == nil:
+		return nil, noGroupError(key, group)
+	}
+	consumers := st.groupInfo(group, st.groups[group], clockNow(), false, 0).Consumers
+	if consumers == nil {
+		consumers = []ConsumerInfo{}
+	}
+	return consumers, ""
+}
+
+var xinfoHelp = []string{
+	"XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CONSUMERS <key> <groupname>",
+	"    Show consumers of <groupname>.",
+	"GROUPS <key>",
+	"    Show the stream consumer groups.",
+	"STREAM <key> [FULL [COUNT <count>]",
+	"    Show information about the stream.",
+	"HELP",
+	"    Print this help.",
+}
+
+// Entries and pending entries XINFO STREAM FULL lists at most without COUNT
+const xinfoDefaultCount = 10
+
+// formatOptionalInt formats n, or a null if it is negative
+func formatOptionalInt(n int64) string {
+	if n < 0 {
+		return formatNullBulkString()
+	}
+	return formatInteger(int(n))
+}
+
+// formatOptionalEntry formats an entry as XRANGE does, or a null
+func formatOptionalEntry(e *streamEntry) string {
+	if e == nil {
+		return formatNullBulkString()
+	}
+	return "*2\r\n" + formatBulkString(e.id.String()) + formatArray(e.fields)
+}
+
+// formatList formats already formatted elements as an array
+func formatList(elements []string) string {
+	return "*" + strconv.Itoa(len(elements)) + "\r\n" + strings.Join(elements, "")
+}
+
+// formatGroupInfo formats a group as XINFO GROUPS does, or with its pending
+// entries and consumers as XINFO STREAM FULL does
+func (c *Client) formatGroupInfo(g GroupInfo, full bool) string {
+	if !full {
+		return c.formatMap([]string{
+			formatBulkString("name"), formatBulkString(g.Name),
+			formatBulkString("consumers"), formatInteger(len(g.Consumers)),
+			formatBulkString("pending"), formatInteger(g.Pending),
+			formatBulkString("last-delivered-id"), formatBulkString(g.LastID.String()),
+			formatBulkString("entries-read"), formatOptionalInt(g.EntriesRead),
+			formatBulkString("lag"), formatOptionalInt(g.Lag),
+		})
+	}
+	pel := make([]string, len(g.PEL))
+	for i, pe := range g.PEL {
+		pel[i] = formatList([]string{formatBulkString(pe.ID.String()), formatBulkString(pe.Consumer),
+			formatInteger(int(pe.DeliveryTime.UnixMilli())), formatInteger(int(pe.Deliveries))})
+	}
+	consumers := make([]string, len(g.Consumers))
+	for i, ci := range g.Consumers {
+		consumerPEL := make([]string, len(ci.PEL))
+		for j, pe := range ci.PEL {
+			consumerPEL[j] = formatList([]string{formatBulkString(pe.ID.String()),
+				formatInteger(int(pe.DeliveryTime.UnixMilli())), formatInteger(int(pe.Deliveries))})
+		}
+		activeTime := int64(-1)
+		if !ci.ActiveTime.IsZero() {
+			activeTime = ci.ActiveTime.UnixMilli()
+		}
+		consumers[i] = c.formatMap([]string{
+			formatBulkString("name"), formatBulkString(ci.Name),
+			formatBulkString("seen-time"), formatInteger(int(ci.SeenTime.UnixMilli())),
+			formatBulkString("active-time"), formatInteger(int(activeTime)),
+			formatBulkString("pel-count"), formatInteger(ci.Pending),
+			formatBulkString("pel"), formatList(consumerPEL),
+		})
+	}
+	return c.formatMap([]string{
+		formatBulkString("name"), formatBulkString(g.Name),
+		formatBulkString("last-delivered-id"), formatBulkString(g.LastID.String()),
+		formatBulkString("entries-read"), formatOptionalInt(g.EntriesRead),
+		formatBulkString("lag"), formatOptionalInt(g.Lag),
+		formatBulkString("pel-count"), formatInteger(g.Pending),
+		formatBulkString("pel"), formatList(pel),
+		formatBulkString("consumers"), formatList(consumers),
+	})
+}
+
+// XINFO STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group | HELP
+func xinfoCommand(c *Client, args []string) string {
+	sub := strings.ToUpper(args[1])
+	if sub == "HELP" && len(args) == 2 {
+		lines := ""
+		for _, line := range xinfoHelp {
+			lines += formatSimpleString(line)
+		}
+		return "*" + strconv.Itoa(len(xinfoHelp)) + "\r\n" + lines
+	}
+	switch {
+	case sub != "STREAM" && sub != "GROUPS" && sub != "CONSUMERS":
+		return formatError("ERR unknown subcommand '" + args[1] + "'. Try XINFO HELP.")
+	case (sub == "STREAM" && len(args) < 3) || (sub == "GROUPS" && len(args) != 3) || (sub == "CONSUMERS" && len(args) != 4):
+		return formatError("ERR wrong number of arguments for 'xinfo|" + strings.ToLower(sub) + "' command")
+	}
+
+	db := c.db()
+	switch sub {
+	case "GROUPS":
+		groups, errMsg := db.XInfoGroups(args[2])
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		replies := make([]string, len(groups))
+		for i, g := range groups {
+			replies[i] = c.formatGroupInfo(g, false)
+		}
+		return formatList(replies)
+	case "CONSUMERS":
+		consumers, errMsg := db.XInfoConsumers(args[2], args[3])
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		now := clockNow()
+		replies := make([]string, len(consumers))
+		for i, ci := range consumers {
+			inactive := int64(-1)
+			if !ci.ActiveTime.IsZero() {
+				inactive = max(now.Sub(ci.ActiveTime), 0).Milliseconds()
+			}
+			replies[i] = c.formatMap([]string{
+				formatBulkString("name"), formatBulkString(ci.Name),
+				formatBulkString("pending"), formatInteger(ci.Pending),
+				formatBulkString("idle"), formatInteger(int(max(now.Sub(ci.SeenTime), 0).Milliseconds())),
+				formatBulkString("inactive"), formatInteger(int(inactive)),
+			})
+		}
+		return formatList(replies)
+	}
+
+	full, count := false, xinfoDefaultCount
+	switch rest := args[3:]; {
+	case len(rest) == 0:
+	case len(rest) == 1 && strings.EqualFold(rest[0], "FULL"):
+		full = true
+	case len(rest) == 3 && strings.EqualFold(rest[0], "FULL") && strings.EqualFold(rest[1], "COUNT"):
+		n, err := strconv.ParseInt(rest[2], 10, 64)
+		if err != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		full, count = true, int(max(n, 0))
+		if count == 0 {
+			count = -1 // COUNT 0 lists everything
+		}
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	info, errMsg := db.XInfoStream(args[2], full, count)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	// Nodes are kept in order rather than in a radix tree, so both radix
+	// tree counts report them
+	pairs := []string{
+		formatBulkString("length"), formatInteger(info.Length),
+		formatBulkString("radix-tree-keys"), formatInteger(info.Nodes),
+		formatBulkString("radix-tree-nodes"), formatInteger(info.Nodes),
+		formatBulkString("last-generated-id"), formatBulkString(info.LastID.String()),
+		formatBulkString("max-deleted-entry-id"), formatBulkString(info.MaxDeletedID.String()),
+		formatBulkString("entries-added"), formatInteger(int(info.EntriesAdded)),
+		formatBulkString("recorded-first-entry-id"), formatBulkString(info.FirstID.String()),
+	}
+	if !full {
+		pairs = append(pairs,
+			formatBulkString("groups"), formatInteger(info.Groups),
+			formatBulkString("first-entry"), formatOptionalEntry(info.First),
+			formatBulkString("last-entry"), formatOptionalEntry(info.Last),
+		)
+		return c.formatMap(pairs)
+	}
+	groups := make([]string, len(info.GroupDetails))
+	for i, g := range info.GroupDetails {
+		groups[i] = c.formatGroupInfo(g, true)
+	}
+	pairs = append(pairs,
+		formatBulkString("entries"), formatStreamEntries(info.Entries),
+		formatBulkString("groups"), formatList(groups),
+	)
+	return c.formatMap(pairs)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamInfo(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	bulk := func(s string) string { return formatBulkString(s) }
+	groupInfo := func(name string, consumers, pending int, lastID, entriesRead, lag string) string {
+		return "*12\r\n" + bulk("name") + bulk(name) + bulk("consumers") + formatInteger(consumers) +
+			bulk("pending") + formatInteger(pending) + bulk("last-delivered-id") + bulk(lastID) +
+			bulk("entries-read") + entriesRead + bulk("lag") + lag
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		run("XADD", "s", id, "a", id)
+	}
+	run("XGROUP", "CREATE", "s", "g", "0")
+	run("XGROUP", "CREATE", "s", "late", "$")
+	run("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+
+	// Test 1: XINFO GROUPS counts what each group has read and has yet to read
+	want := "*2\r\n" + groupInfo("g", 1, 2, "2-0", ":2\r\n", ":1\r\n") + groupInfo("late", 0, 0, "3-0", formatNullBulkString(), ":0\r\n")
+	if reply := run("XINFO", "GROUPS", "s"); reply != want {
+		t.Fatalf("expected XINFO GROUPS reply %q, got %q", want, reply)
+	}
+	run("XGROUP", "CREATE", "s", "fresh", "0")
+	if reply := run("XINFO", "GROUPS", "s"); !strings.Contains(reply, groupInfo("fresh", 0, 0, "0-0", formatNullBulkString(), ":3\r\n")) {
+		t.Fatalf("expected a group at 0-0 to lag by the whole stream, got %q", reply)
+	}
+	run("XGROUP", "DESTROY", "s", "fresh")
+	run("XGROUP", "SETID", "s", "late", "1", "ENTRIESREAD", "1")
+	if reply := run("XINFO", "GROUPS", "s"); !strings.Contains(reply, groupInfo("late", 0, 0, "1-0", ":1\r\n", ":2\r\n")) {
+		t.Fatalf("expected ENTRIESREAD to seed the lag, got %q", reply)
+	}
+
+	// Test 2: XINFO CONSUMERS reports idle and inactive times
+	run("XGROUP", "CREATECONSUMER", "s", "g", "bob")
+	vc.Advance(100 * time.Millisecond)
+	want = "*2\r\n" +
+		"*8\r\n" + bulk("name") + bulk("alice") + bulk("pending") + ":2\r\n" + bulk("idle") + ":100\r\n" + bulk("inactive") + ":100\r\n" +
+		"*8\r\n" + bulk("name") + bulk("bob") + bulk("pending") + ":0\r\n" + bulk("idle") + ":100\r\n" + bulk("inactive") + ":-1\r\n"
+	if reply := run("XINFO", "CONSUMERS", "s", "g"); reply != want {
+		t.Fatalf("expected XINFO CONSUMERS reply %q, got %q", want, reply)
+	}
+
+	// Test 3: XINFO STREAM describes the stream and its ends
+	entry := func(id string) string { return "*2\r\n" + bulk(id+"-0") + formatArray([]string{"a", id}) }
+	want = "*20\r\n" + bulk("length") + ":3\r\n" + bulk("radix-tree-keys") + ":1\r\n" + bulk("radix-tree-nodes") + ":1\r\n" +
+		bulk("last-generated-id") + bulk("3-0") + bulk("max-deleted-entry-id") + bulk("0-0") +
+		bulk("entries-added") + ":3\r\n" + bulk("recorded-first-entry-id") + bulk("1-0") +
+		bulk("groups") + ":2\r\n" + bulk("first-entry") + entry("1") + bulk("last-entry") + entry("3")
+	if reply := run("XINFO", "STREAM", "s"); reply != want {
+		t.Fatalf("expected XINFO STREAM reply %q, got %q", want, reply)
+	}
+
+	// Test 4: FULL lists entries, pending entries and consumers up to COUNT
+	reply := run("XINFO", "STREAM", "s", "FULL", "COUNT", "1")
+	for _, part := range []string{
+		bulk("entries") + "*1\r\n" + entry("1") + bulk("groups") + "*2\r\n",
+		bulk("pel-count") + ":2\r\n" + bulk("pel") + "*1\r\n*4\r\n" + bulk("1-0") + bulk("alice") + ":1700000000000\r\n:1\r\n",
+		bulk("name") + bulk("bob") + bulk("seen-time") + ":1700000000000\r\n" + bulk("active-time") + ":-1\r\n",
+	} {
+		if !strings.Contains(reply, part) {
+			t.Fatalf("expected XINFO STREAM FULL reply to contain %q, got %q", part, reply)
+		}
+	}
+	if reply := run("XINFO", "STREAM", "s", "FULL"); !strings.Contains(reply, bulk("entries")+"*3\r\n") {
+		t.Fatalf("expected FULL to list every entry, got %q", reply)
+	}
+
+	// Test 5: Deleting entries past a group's position makes its lag unknown
+	// until it reads past them
+	run("XDEL", "s", "3")
+	if reply := run("XINFO", "GROUPS", "s"); !strings.Contains(reply, groupInfo("g", 2, 2, "2-0", ":2\r\n", formatNullBulkString())) {
+		t.Fatalf("expected unknown lag after XDEL, got %q", reply)
+	}
+	run("XADD", "s", "4", "a", "4")
+	run("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	if reply := run("XINFO", "GROUPS", "s"); !strings.Contains(reply, groupInfo("g", 2, 3, "4-0", ":4\r\n", ":0\r\n")) {
+		t.Fatalf("expected the group to catch up, got %q", reply)
+	}
+
+	// Test 6: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"XINFO STREAM missing":              "-ERR no such key\r\n",
+		"XINFO GROUPS str":                  "-" + wrongTypeError + "\r\n",
+		"XINFO CONSUMERS s nope":            "-" + noGroupError("s", "nope") + "\r\n",
+		"XINFO STREAM s FULL COUNT x":       "-ERR value is not an integer or out of range\r\n",
+		"XINFO STREAM s COUNT 1":            "-ERR syntax error\r\n",
+		"XINFO GROUPS":                      "-ERR wrong number of arguments for 'xinfo|groups' command\r\n",
+		"XINFO NOPE s":                      "-ERR unknown subcommand 'NOPE'. Try XINFO HELP.\r\n",
+		"XGROUP SETID s g 0 ENTRIESREAD -2": "-ERR value for ENTRIESREAD must be positive or -1\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	if reply := run("XINFO", "HELP"); !strings.HasPrefix(reply, "*9\r\n") {
+		t.Fatalf("unexpected XINFO HELP reply %q", reply)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pendingGroup returns the stream at key and its group, for the commands
+// inspecting and claiming pending entries
+// Returns (stream, group, errMsg)
+// Callers must hold the write lock.
+func (s *Store) pendingGroup(key, group string) (*stream, *consumerGroup, string) {
+	st, ok := s.mutableStream(key)
+	switch {
+	case !ok:
+		return nil, nil, wrongTypeError
+	case st == nil || st.groups[group] == nil:
+		return nil, nil, "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"
+	}
+	return st, st.groups[group], ""
+}
+
+// idleTime returns how long ago the entry was last delivered
+func (pe *pendingEntry) idleTime(now time.Time) time.Duration {
+	return max(now.Sub(pe.deliveryTime), 0)
+}
+
+// claimCommand returns the XCLAIM that gives the entry back to its consumer
+// with its delivery time and count when the append-only file is replayed
+func (pe *pendingEntry) claimCommand(key, group string) []string {
+	return []string{"XCLAIM", key, group, pe.consumer.name, "0", pe.id.String(),
+		"TIME", strconv.FormatInt(pe.deliveryTime.UnixMilli(), 10),
+		"RETRYCOUNT", strconv.FormatInt(pe.deliveryCount, 10), "FORCE", "JUSTID"}
+}
+
+// PendingSummary is what XPENDING reports without a range
+type PendingSummary struct {
+	Count     int
+	Min, Max  streamID
+	Consumers []ConsumerPending // In name order, only those with pending entries
+}
+
+// ConsumerPending is the number of entries pending for a consumer
+type ConsumerPending struct {
+	Name  string
+	Count int
+}
+
+// XPendingSummary summarizes the pending entries list of a group
+// Returns the summary, or an error message
+func (s *Store) XPendingSummary(key, group string) (PendingSummary, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, g, errMsg := s.pendingGroup(key, group)
+	if errMsg != "" {
+		return PendingSummary{}, errMsg
+	}
+	summary := PendingSummary{Count: len(g.pending)}
+	if len(g.pending) > 0 {
+		summary.Min, summary.Max = g.pending[0].id, g.pending[len(g.pending)-1].id
+	}
+	for name, c := range g.consumers {
+		if len(c.pending) > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{name, len(c.pending)})
+		}
+	}
+	slices.SortFunc(summary.Consumers, func(a, b ConsumerPending) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return summary, ""
+}
+
+// PendingQuery selects the pending entries XPENDING lists
+type PendingQuery struct {
+	Start, End streamID
+	Count      int
+	MinIdle    time.Duration // Only entries delivered at least this long ago
+	Consumer   string        // Only the entries of this consumer, if not empty
+}
+
+// PendingInfo describes one pending entry
+type PendingInfo struct {
+	ID           streamID
+	Consumer     string
+	Idle         time.Duration
+	DeliveryTime time.Time
+	Deliveries   int64
+}
+
+// info describes the pending entry at now
+func (pe *pendingEntry) info(now time.Time) PendingInfo {
+	return PendingInfo{pe.id, pe.consumer.name, pe.idleTime(now), pe.deliveryTime, pe.deliveryCount}
+}
+
+// XPendingRange lists the pending entries of a group q selects, in ID order
+// Returns the entries, or an error message
+func (s *Store) XPendingRange(key, group string, q PendingQuery) ([]PendingInfo, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, g, errMsg := s.pendingGroup(key, group)
+	if errMsg != "" {
+		return nil, errMsg
+	}
+	now := clockNow()
+	var infos []PendingInfo
+	i, _ := g.searchPending(q.Start)
+	for ; i < len(g.pending) && len(infos) < q.Count; i++ {
+		pe := g.pending[i]
+		if pe.id.compare(q.End) > 0 {
+			break
+		}
+		if (q.Consumer != "" && pe.consumer.name != q.Consumer) || pe.idleTime(now) < q.MinIdle {
+			continue
+		}
+		infos = append(infos, pe.info(now))
+	}
+	return infos, ""
+}
+
+// XClaimOptions are the options of XCLAIM
+type XClaimOptions struct {
+	DeliveryTime time.Time // Delivery time of claimed entries (IDLE or TIME), zero for now
+	RetryCount   int64     // Delivery count of claimed entries, or -1 to count one more
+	Force        bool      // Create missing pending entries for existing stream entries
+	JustID       bool      // Leave delivery counts alone; the caller replies with IDs only
+	LastID       streamID  // The group delivers new entries after this one at least
+}
+
+// claim moves a pending entry to consumer c
+func (g *consumerGroup) claim(pe *pendingEntry, c *streamConsumer, deliveryTime time.Time) {
+	delete(pe.consumer.pending, pe.id)
+	pe.consumer, pe.deliveryTime = c, deliveryTime
+	c.pending[pe.id] = pe
+}
+
+// XClaim gives consumer the entries with ids pending in group for at least
+// minIdle, dropping those deleted from the stream
+// Returns the claimed entries and the commands replaying the claim, or an
+// error message
+func (s *Store) XClaim(key, group, consumer string, minIdle time.Duration, ids []streamID, opts XClaimOptions) ([]streamEntry, [][]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, g, errMsg := s.pendingGroup(key, group)
+	if errMsg != "" {
+		return nil, nil, errMsg
+	}
+	replay := g.consumerCommands(key, group, consumer)
+	now := clockNow()
+	deliveryTime := opts.DeliveryTime
+	if deliveryTime.IsZero() || deliveryTime.After(now) {
+		deliveryTime = now
+	}
+	if opts.LastID.compare(g.lastID) > 0 {
+		g.lastID = opts.LastID
+		replay = append(replay, g.setIDCommand(key, group))
+	}
+	c := g.consumer(consumer, true)
+	c.seenTime = now
+
+	var claimed []streamEntry
+	for _, id := range ids {
+		entry, exists := st.get(id)
+		i, found := g.searchPending(id)
+		switch {
+		case !found && (!opts.Force || !exists):
+			continue
+		case !found:
+			g.pending = slices.Insert(g.pending, i, &pendingEntry{id: id, consumer: c, deliveryCount: 1})
+			c.pending[id] = g.pending[i]
+		case !exists:
+			g.ack(id)
+			replay = append(replay, []string{"XACK", key, group, id.String()})
+			continue
+		}
+		pe := g.pending[i]
+		if found && minIdle > 0 && pe.idleTime(now) < minIdle {
+			continue
+		}
+		g.claim(pe, c, deliveryTime)
+		switch {
+		case opts.RetryCount >= 0:
+			pe.deliveryCount = opts.RetryCount
+		case !opts.JustID:
+			pe.deliveryCount++
+		}
+		claimed = append(claimed, entry)
+		replay = append(replay, pe.claimCommand(key, group))
+	}
+	if len(claimed) > 0 {
+		c.activeTime = now
+	}
+	s.keyModified(key)
+	return claimed, replay, ""
+}
+
+// XAutoClaim gives consumer up to count entries pending in group for at
+// least minIdle, scanning the pending entries list from start, and drops
+// those deleted from the stream. At most ten times count entries are looked at.
+// Returns the ID to continue the scan from (0-0 once done), the claimed
+// entries, the IDs dropped and the commands replaying the claim, or an error
+// message
+func (s *Store) XAutoClaim(key, group, consumer string, minIdle time.Duration, start streamID, count int, justID bool) (streamID, []streamEntry, []streamID, [][]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, g, errMsg := s.pendingGroup(key, group)
+	if errMsg != "" {
+		return streamID{}, nil, nil, nil, errMsg
+	}
+	replay := g.consumerCommands(key, group, consumer)
+	now := clockNow()
+	c := g.consumer(consumer, true)
+	c.seenTime = now
+
+	var claimed []streamEntry
+	var deleted []streamID
+	attempts := count * 10
+	i, _ := g.searchPending(start)
+	for ; i < len(g.pending) && attempts > 0 && len(claimed) < count; attempts-- {
+		pe := g.pending[i]
+		entry, exists := st.get(pe.id)
+		if !exists {
+			deleted = append(deleted, pe.id)
+			g.ack(pe.id)
+			replay = append(replay, []string{"XACK", key, group, pe.id.String()})
+			continue
+		}
+		i++
+		if minIdle > 0 && pe.idleTime(now) < minIdle {
+			continue
+		}
+		g.claim(pe, c, now)
+		if !justID {
+			pe.deliveryCount++
+		}
+		claimed = append(claimed, entry)
+		replay = append(replay, pe.claimCommand(key, group))
+	}
+	var next streamID
+	if i < len(g.pending) {
+		next = g.pending[i].id
+	}
+	if len(claimed) > 0 {
+		c.activeTime = now
+	}
+	s.keyModified(key)
+	return next, claimed, deleted, replay, ""
+}
+
+// parseMinIdle parses a min-idle-time argument in milliseconds; negative
+// ones are zero
+func parseMinIdle(arg string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(min(max(ms, 0), math.MaxInt64/int64(time.Millisecond))) * time.Millisecond, true
+}
+
+// formatStreamIDs formats ids as an array of bulk strings
+func formatStreamIDs(ids []streamID) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(ids)) + "\r\n")
+	for _, id := range ids {
+		b.WriteString(formatBulkString(id.String()))
+	}
+	return b.String()
+}
+
+// formatClaimed formats the entries XCLAIM and XAUTOCLAIM claimed, or only
+// their IDs with justID
+func formatClaimed(entries []streamEntry, justID bool) string {
+	if !justID {
+		return formatStreamEntries(entries)
+	}
+	ids := make([]streamID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id
+	}
+	return formatStreamIDs(ids)
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xpendingCommand(c *Client, args []string) string {
+	db := c.db()
+	key, group := args[1], args[2]
+	if len(args) == 3 {
+		summary, errMsg := db.XPendingSummary(key, group)
+		if errMsg != "" {
+			return formatError(errMsg)
+		}
+		if summary.Count == 0 {
+			return "*4\r\n" + formatInteger(0) + formatNullBulkString() + formatNullBulkString() + formatNullArray()
+		}
+		var b strings.Builder
+		b.WriteString("*4\r\n" + formatInteger(summary.Count))
+		b.WriteString(formatBulkString(summary.Min.String()) + formatBulkString(summary.Max.String()))
+		b.WriteString("*" + strconv.Itoa(len(summary.Consumers)) + "\r\n")
+		for _, cp := range summary.Consumers {
+			b.WriteString(formatArray([]string{cp.Name, strconv.Itoa(cp.Count)}))
+		}
+		return b.String()
+	}
+
+	var q PendingQuery
+	rest := args[3:]
+	if len(rest) >= 2 && strings.EqualFold(rest[0], "IDLE") {
+		var ok bool
+		if q.MinIdle, ok = parseMinIdle(rest[1]); !ok {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return formatError("ERR syntax error")
+	}
+	var errReply string
+	if q.Start, errReply = parseRangeBound(rest[0], false); errReply != "" {
+		return errReply
+	}
+	if q.End, errReply = parseRangeBound(rest[1], true); errReply != "" {
+		return errReply
+	}
+	count, err := strconv.ParseInt(rest[2], 10, 64)
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	q.Count = int(min(max(count, 0), math.MaxInt))
+	if len(rest) == 4 {
+		q.Consumer = rest[3]
+	}
+
+	infos, errMsg := db.XPendingRange(key, group, q)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(infos)) + "\r\n")
+	for _, info := range infos {
+		b.WriteString("*4\r\n" + formatBulkString(info.ID.String()) + formatBulkString(info.Consumer))
+		b.WriteString(formatInteger(int(info.Idle/time.Millisecond)) + formatInteger(int(info.Deliveries)))
+	}
+	return b.String()
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms]
+// [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id]
+func xclaimCommand(c *Client, args []string) string {
+	minIdle, ok := parseMinIdle(args[4])
+	if !ok {
+		return formatError("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	// IDs come first; the first argument that is not one starts the options
+	i := 5
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	opts := XClaimOptions{RetryCount: -1}
+	for ; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		hasValue := i+1 < len(args)
+		switch {
+		case name == "FORCE":
+			opts.Force = true
+		case name == "JUSTID":
+			opts.JustID = true
+		case name == "IDLE" && hasValue:
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			opts.DeliveryTime = clockNow().Add(-time.Duration(ms) * time.Millisecond)
+		case name == "TIME" && hasValue:
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR Invalid TIME option argument for XCLAIM")
+			}
+			opts.DeliveryTime = time.UnixMilli(ms)
+		case name == "RETRYCOUNT" && hasValue:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n < 0 {
+				return formatError("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			opts.RetryCount = n
+		case name == "LASTID" && hasValue:
+			i++
+			id, ok := parseStreamID(args[i], 0)
+			if !ok {
+				return formatError(streamInvalidIDError)
+			}
+			opts.LastID = id
+		default:
+			return formatError("ERR Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+
+	claimed, replay, errMsg := c.db().XClaim(args[1], args[2], args[3], minIdle, ids, opts)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	// Replayed with the resulting owners, delivery times and counts, which
+	// replaying the idle times would compute anew
+	c.rewriteAOF(replay...)
+	return formatClaimed(claimed, opts.JustID)
+}
+
+// Entries XAUTOCLAIM claims at most without COUNT
+const xautoclaimDefaultCount = 100
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func xautoclaimCommand(c *Client, args []string) string {
+	minIdle, ok := parseMinIdle(args[4])
+	if !ok {
+		return formatError("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, errReply := parseRangeBound(args[5], false)
+	if errReply != "" {
+		return errReply
+	}
+	count, justID := xautoclaimDefaultCount, false
+	for i := 6; i < len(args); i++ {
+		switch name := strings.ToUpper(args[i]); {
+		case name == "JUSTID":
+			justID = true
+		case name == "COUNT" && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			if n < 1 || n > math.MaxInt/10 {
+				return formatError("ERR COUNT must be > 0")
+			}
+			count = int(n)
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+
+	next, claimed, deleted, replay, errMsg := c.db().XAutoClaim(args[1], args[2], args[3], minIdle, start, count, justID)
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	c.rewriteAOF(replay...) // As for XCLAIM
+	return "*3\r\n" + formatBulkString(next.String()) + formatClaimed(claimed, justID) + formatStreamIDs(deleted)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamPending(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	entry := func(id string, fields ...string) string {
+		return "*2\r\n" + formatBulkString(id) + formatArray(fields)
+	}
+	pending := func(id, consumer string, idle, deliveries int) string {
+		return "*4\r\n" + formatBulkString(id) + formatBulkString(consumer) + formatInteger(idle) + formatInteger(deliveries)
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		run("XADD", "s", id, "f", id)
+	}
+	run("XGROUP", "CREATE", "s", "g", "0")
+
+	// Test 1: Summaries and listings of the pending entries list
+	if reply := run("XPENDING", "s", "g"); reply != "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n" {
+		t.Fatalf("unexpected empty summary %q", reply)
+	}
+	run("XREADGROUP", "GROUP", "g", "bob", "COUNT", "1", "STREAMS", "s", ">")
+	vc.Advance(100 * time.Millisecond)
+	run("XREADGROUP", "GROUP", "g", "alice", "COUNT", "3", "STREAMS", "s", ">")
+	vc.Advance(50 * time.Millisecond)
+	for args, want := range map[string]string{
+		"XPENDING s g": "*4\r\n:4\r\n" + formatBulkString("1-0") + formatBulkString("4-0") +
+			"*2\r\n" + formatArray([]string{"alice", "3"}) + formatArray([]string{"bob", "1"}),
+		"XPENDING s g - + 10":               "*4\r\n" + pending("1-0", "bob", 150, 1) + pending("2-0", "alice", 50, 1) + pending("3-0", "alice", 50, 1) + pending("4-0", "alice", 50, 1),
+		"XPENDING s g - + 2 alice":          "*2\r\n" + pending("2-0", "alice", 50, 1) + pending("3-0", "alice", 50, 1),
+		"XPENDING s g (2-0 3 10":            "*1\r\n" + pending("3-0", "alice", 50, 1),
+		"XPENDING s g IDLE 100 - + 10":      "*1\r\n" + pending("1-0", "bob", 150, 1),
+		"XPENDING s g IDLE 100 - + 10 nope": "*0\r\n",
+		"XPENDING s g - + -1":               "*0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 2: XCLAIM moves entries idle long enough, counting a delivery
+	// unless JUSTID, with IDLE, TIME, RETRYCOUNT and FORCE
+	for _, step := range []struct{ args, want string }{
+		{"XCLAIM s g carol 100 1-0 2-0", "*1\r\n" + entry("1-0", "f", "1-0")},
+		{"XPENDING s g - + 1", "*1\r\n" + pending("1-0", "carol", 0, 2)},
+		{"XCLAIM s g carol 0 2-0 JUSTID IDLE 500", formatArray([]string{"2-0"})},
+		{"XPENDING s g 2-0 2-0 1", "*1\r\n" + pending("2-0", "carol", 500, 1)},
+		{"XCLAIM s g bob 0 3-0 RETRYCOUNT 7 TIME 1699999999000", "*1\r\n" + entry("3-0", "f", "3-0")},
+		{"XPENDING s g 3-0 3-0 1", "*1\r\n" + pending("3-0", "bob", 1150, 7)},
+		{"XACK s g 4-0", ":1\r\n"},
+		{"XCLAIM s g bob 0 4-0", "*0\r\n"},
+		{"XCLAIM s g bob 0 4-0 9-0 FORCE", "*1\r\n" + entry("4-0", "f", "4-0")},
+		{"XPENDING s g 4-0 + 10", "*1\r\n" + pending("4-0", "bob", 0, 2)},
+		{"XCLAIM s g bob 0 LASTID 9-0", "*0\r\n"},
+		{"XREADGROUP GROUP g bob STREAMS s >", "*-1\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 3: XAUTOCLAIM scans from a cursor and drops entries trimmed from
+	// the stream
+	vc.Advance(time.Second)
+	run("XADD", "s", "MAXLEN", "2", "5-0", "f", "5-0") // Trims 1-0, 2-0 and 3-0
+	for _, step := range []struct{ args, want string }{
+		{"XAUTOCLAIM s g dave 100 - COUNT 1", "*3\r\n" + formatBulkString("0-0") + "*1\r\n" + entry("4-0", "f", "4-0") + formatArray([]string{"1-0", "2-0", "3-0"})},
+		{"XPENDING s g", "*4\r\n:1\r\n" + formatBulkString("4-0") + formatBulkString("4-0") + "*1\r\n" + formatArray([]string{"dave", "1"})},
+		{"XREADGROUP GROUP g erin STREAMS s 0", "*1\r\n*2\r\n" + formatBulkString("s") + "*0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	run("XGROUP", "SETID", "s", "g", "0")
+	run("XREADGROUP", "GROUP", "g", "erin", "STREAMS", "s", ">")
+	vc.Advance(time.Second)
+	for _, step := range []struct{ args, want string }{
+		{"XAUTOCLAIM s g frank 0 - COUNT 1 JUSTID", "*3\r\n" + formatBulkString("5-0") + formatArray([]string{"4-0"}) + "*0\r\n"},
+		{"XAUTOCLAIM s g frank 5000 5-0", "*3\r\n" + formatBulkString("0-0") + "*0\r\n*0\r\n"},
+		{"XPENDING s g - + 10", "*2\r\n" + pending("4-0", "frank", 0, 1) + pending("5-0", "erin", 1000, 1)},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 4: Errors
+	run("SET", "str", "x")
+	noGroup := "-NOGROUP No such key 's' or consumer group 'nope'\r\n"
+	for args, want := range map[string]string{
+		"XPENDING s nope":                 noGroup,
+		"XPENDING s nope - + 1":           noGroup,
+		"XCLAIM s nope c 0 1-0":           noGroup,
+		"XAUTOCLAIM s nope c 0 -":         noGroup,
+		"XPENDING missing g":              "-NOGROUP No such key 'missing' or consumer group 'g'\r\n",
+		"XPENDING str g":                  "-" + wrongTypeError + "\r\n",
+		"XPENDING s g - +":                "-ERR syntax error\r\n",
+		"XPENDING s g IDLE x - + 1":       "-ERR value is not an integer or out of range\r\n",
+		"XPENDING s g x + 1":              "-" + streamInvalidIDError + "\r\n",
+		"XCLAIM s g c x 1-0":              "-ERR Invalid min-idle-time argument for XCLAIM\r\n",
+		"XCLAIM s g c 0 1-0 IDLE x":       "-ERR Invalid IDLE option argument for XCLAIM\r\n",
+		"XCLAIM s g c 0 1-0 TIME x":       "-ERR Invalid TIME option argument for XCLAIM\r\n",
+		"XCLAIM s g c 0 1-0 RETRYCOUNT x": "-ERR Invalid RETRYCOUNT option argument for XCLAIM\r\n",
+		"XCLAIM s g c 0 1-0 BOGUS":        "-ERR Unrecognized XCLAIM option 'BOGUS'\r\n",
+		"XAUTOCLAIM s g c x -":            "-ERR Invalid min-idle-time argument for XAUTOCLAIM\r\n",
+		"XAUTOCLAIM s g c 0 x":            "-" + streamInvalidIDError + "\r\n",
+		"XAUTOCLAIM s g c 0 - COUNT 0":    "-ERR COUNT must be > 0\r\n",
+		"XAUTOCLAIM s g c 0 - BOGUS":      "-ERR syntax error\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// XTrim removes the oldest entries of the stream at key as t selects
+// Returns (entries removed, isCorrectType)
+func (s *Store) XTrim(key string, t StreamTrim) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	if st == nil {
+		return 0, ok
+	}
+	n := st.trim(t)
+	if n > 0 {
+		s.keyModified(key)
+	}
+	return n, true
+}
+
+// XDel removes the entries with ids from the stream at key; an emptied
+// stream is kept, as in Redis
+// Returns (entries removed, isCorrectType)
+func (s *Store) XDel(key string, ids []streamID) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	if st == nil {
+		return 0, ok
+	}
+	n := 0
+	for _, id := range ids {
+		if st.delete(id) {
+			n++
+		}
+	}
+	if n > 0 {
+		s.keyModified(key)
+	}
+	return n, true
+}
+
+// XSetID sets the ID of the newest entry ever added to the stream at key,
+// and with entriesAdded (unless negative) and maxDeleted (unless nil) the
+// counters XINFO reports
+// Returns an error message on failure
+func (s *Store) XSetID(key string, id streamID, entriesAdded int64, maxDeleted *streamID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.mutableStream(key)
+	switch {
+	case !ok:
+		return wrongTypeError
+	case st == nil:
+		return "ERR no such key"
+	}
+	if last, ok := st.last(); ok {
+		if id.compare(last.id) < 0 {
+			return "ERR The ID specified in XSETID is smaller than the target stream top item"
+		}
+		if entriesAdded >= 0 && uint64(entriesAdded) < uint64(st.len()) {
+			return "ERR The entries_added specified in XSETID is smaller than the target stream length"
+		}
+	}
+	st.lastID = id
+	if entriesAdded >= 0 {
+		st.entriesAdded = uint64(entriesAdded)
+	}
+	if maxDeleted != nil && *maxDeleted != (streamID{}) {
+		st.maxDeletedID = *maxDeleted
+	}
+	s.keyModified(key)
+	return ""
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func xtrimCommand(c *Client, args []string) string {
+	t := StreamTrim{Limit: -1}
+	for i := 2; i < len(args); i++ {
+		if !isStreamTrimOption(args[i]) || i+1 == len(args) {
+			return formatError("ERR syntax error")
+		}
+		n, errReply := parseStreamTrim(args, i, &t)
+		if errReply != "" {
+			return errReply
+		}
+		i += n - 1
+	}
+	if t.Strategy == trimNone {
+		return formatError("ERR syntax error")
+	}
+	if errReply := checkStreamTrim(&t); errReply != "" {
+		return errReply
+	}
+
+	n, ok := c.db().XTrim(args[1], t)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// XDEL key id [id ...]
+func xdelCommand(c *Client, args []string) string {
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		ids = append(ids, id)
+	}
+	n, ok := c.db().XDel(args[1], ids)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func xsetidCommand(c *Client, args []string) string {
+	id, ok := parseStreamID(args[2], 0)
+	if !ok {
+		return formatError(streamInvalidIDError)
+	}
+	entriesAdded := int64(-1)
+	var maxDeleted *streamID
+	for i := 3; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		if i+1 == len(args) || (name != "ENTRIESADDED" && name != "MAXDELETEDID") {
+			return formatError("ERR syntax error")
+		}
+		i++
+		if name == "ENTRIESADDED" {
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return formatError("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+			continue
+		}
+		maxID, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		if id.compare(maxID) < 0 {
+			return formatError("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+		}
+		maxDeleted = &maxID
+	}
+
+	if errMsg := c.db().XSetID(args[1], id, entriesAdded, maxDeleted); errMsg != "" {
+		return formatError(errMsg)
+	}
+	return formatSimpleString("OK")
+}
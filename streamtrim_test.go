@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamTrim(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	for i := 1; i <= 250; i++ {
+		run("XADD", "s", strconv.Itoa(i)+"-0", "f", "v")
+	}
+
+	// Test 1: Approximate trimming only removes whole nodes, within LIMIT
+	for _, step := range []struct{ args, want string }{
+		{"XTRIM s MAXLEN ~ 120", ":100\r\n"},
+		{"XLEN s", ":150\r\n"},
+		{"XTRIM s MINID ~ 190", ":0\r\n"},
+		{"XTRIM s MINID 190", ":89\r\n"},
+		{"XRANGE s - + COUNT 1", "*1\r\n*2\r\n" + formatBulkString("190-0") + formatArray([]string{"f", "v"})},
+		{"XTRIM s MAXLEN ~ 0 LIMIT 50", ":11\r\n"},
+		{"XTRIM s MAXLEN = 100", ":0\r\n"},
+		{"XTRIM s MAXLEN ~ 0 LIMIT 0", ":50\r\n"},
+		{"XLEN s", ":0\r\n"},
+		{"XTRIM missing MAXLEN 0", ":0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+
+	// Test 2: Large entries fill nodes by size
+	big := strings.Repeat("x", 3000)
+	for i := 1; i <= 3; i++ {
+		run("XADD", "big", strconv.Itoa(i), "f", big)
+	}
+	if reply := run("XADD", "big", "MAXLEN", "~", "1", "4", "f", big); reply != formatBulkString("4-0") {
+		t.Fatalf("unexpected XADD reply %q", reply)
+	}
+	if reply := run("XLEN", "big"); reply != ":1\r\n" {
+		t.Fatalf("expected single-entry nodes trimmed to 1, got %q", reply)
+	}
+
+	// Test 3: XDEL removes entries anywhere, dropping emptied nodes, and
+	// NOMKSTREAM leaves missing keys alone
+	for _, step := range []struct{ args, want string }{
+		{"XADD d 1 a 1", formatBulkString("1-0")},
+		{"XADD d 2 a 2", formatBulkString("2-0")},
+		{"XADD d 3 a 3", formatBulkString("3-0")},
+		{"XDEL d 2-0 9-0 2-0", ":1\r\n"},
+		{"XRANGE d - +", "*2\r\n*2\r\n" + formatBulkString("1-0") + formatArray([]string{"a", "1"}) + "*2\r\n" + formatBulkString("3-0") + formatArray([]string{"a", "3"})},
+		{"XDEL d 1 3", ":2\r\n"},
+		{"XLEN d", ":0\r\n"},
+		{"XADD d 3 a 3", "-" + streamSmallIDError + "\r\n"},
+		{"XADD new NOMKSTREAM * a 1", formatNullBulkString()},
+		{"XLEN new", ":0\r\n"},
+		{"XADD d NOMKSTREAM MAXLEN 1 4 a 4", formatBulkString("4-0")},
+		{"XDEL missing 1", ":0\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	st, _ := databases.Get(0).streamValue("d")
+	if len(st.nodes) != 1 || len(st.nodes[0].entries) != 1 || st.maxDeletedID != (streamID{3, 0}) {
+		t.Fatalf("expected one node with one entry and 3-0 deleted last, got %+v and %v", st.nodes, st.maxDeletedID)
+	}
+
+	// Test 4: XSETID moves the last ID, never below the newest entry
+	for _, step := range []struct{ args, want string }{
+		{"XSETID d 10-0", "+OK\r\n"},
+		{"XADD d 5 a 5", "-" + streamSmallIDError + "\r\n"},
+		{"XADD d 10-* a 10", formatBulkString("10-1")},
+		{"XSETID d 9-0", "-ERR The ID specified in XSETID is smaller than the target stream top item\r\n"},
+		{"XSETID d 10-1 ENTRIESADDED 20 MAXDELETEDID 5", "+OK\r\n"},
+	} {
+		if reply := run(strings.Fields(step.args)...); reply != step.want {
+			t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+		}
+	}
+	if st, _ = databases.Get(0).streamValue("d"); st.entriesAdded != 20 || st.maxDeletedID != (streamID{5, 0}) {
+		t.Fatalf("expected XSETID to set the counters, got %d and %v", st.entriesAdded, st.maxDeletedID)
+	}
+
+	// Test 5: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"XTRIM s":                        "-ERR wrong number of arguments for 'xtrim' command\r\n",
+		"XTRIM s LIMIT 10":               "-ERR syntax error\r\n",
+		"XTRIM s MAXLEN 10 LIMIT 5":      "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n",
+		"XTRIM s MAXLEN ~ 10 LIMIT -1":   "-ERR The LIMIT argument must be >= 0.\r\n",
+		"XTRIM s MAXLEN 10 MINID 1":      "-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n",
+		"XTRIM s MAXLEN 10 FOO":          "-ERR syntax error\r\n",
+		"XTRIM str MAXLEN 0":             "-" + wrongTypeError + "\r\n",
+		"XADD s MAXLEN 10 LIMIT 5 * a 1": "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n",
+		"XDEL s x":                       "-" + streamInvalidIDError + "\r\n",
+		"XDEL str 1":                     "-" + wrongTypeError + "\r\n",
+		"XSETID missing 1":               "-ERR no such key\r\n",
+		"XSETID str 1":                   "-" + wrongTypeError + "\r\n",
+		"XSETID d x":                     "-" + streamInvalidIDError + "\r\n",
+		"XSETID d 20 ENTRIESADDED -1":    "-ERR entries_added must be positive\r\n",
+		"XSETID d 20 ENTRIESADDED 0":     "-ERR The entries_added specified in XSETID is smaller than the target stream length\r\n",
+		"XSETID d 20 MAXDELETEDID 30":    "-ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id\r\n",
+		"XSETID d 20 FOO 1":              "-ERR syntax error\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// tombstone is an entry removed by DEL while soft deletion is enabled.
+// It is retained for the tombstone window so the deletion can be inspected
+// (e.g. by a replication layer) or reverted with UNDELETE.
+type tombstone struct {
+	key       string
+	entry     *Entry
+	deletedAt time.Time
+}
+
+// SetTombstoneWindow enables soft deletion with the given retention window
+// A zero window disables it and drops every retained tombstone
+func (s *Store) SetTombstoneWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tombstoneWindow = window
+	if window == 0 {
+		s.tombstones = make(map[string]*tombstone)
+		s.tombstoneOrder = nil
+	}
+	s.purgeTombstones(clockNow())
+}
+
+// bury records a deleted entry as a tombstone if soft deletion is enabled
+// Callers must hold the write lock
+func (s *Store) bury(key string, entry *Entry) {
+	if s.tombstoneWindow == 0 {
+		return
+	}
+	now := clockNow()
+	s.purgeTombstones(now)
+	t := &tombstone{key: key, entry: entry, deletedAt: now}
+	s.tombstones[key] = t
+	s.tombstoneOrder = append(s.tombstoneOrder, t)
+}
+
+// purgeTombstones forgets tombstones older than the window
+// Tombstones are queued in deletion order, so only the expired prefix is visited
+// Callers must hold the write lock
+func (s *Store) purgeTombstones(now time.Time) {
+	n := 0
+	for n < len(s.tombstoneOrder) && now.Sub(s.tombstoneOrder[n].deletedAt) >= s.tombstoneWindow {
+		t := s.tombstoneOrder[n]
+		// The key may have been deleted again (or undeleted) since
+		if s.tombstones[t.key] == t {
+			delete(s.tombstones, t.key)
+		}
+		s.tombstoneOrder[n] = nil
+		n++
+	}
+	s.tombstoneOrder = s.tombstoneOrder[n:]
+}
+
+// Tombstone returns when key was deleted, if it is still within the window
+func (s *Store) Tombstone(key string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tombstones[key]
+	if !ok || clockNow().Sub(t.deletedAt) >= s.tombstoneWindow {
+		return time.Time{}, false
+	}
+	return t.deletedAt, true
+}
+
+// TombstoneCount returns the number of retained tombstones
+func (s *Store) TombstoneCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.tombstones)
+}
+
+// Undelete restores a key from its tombstone
+// Returns false if there is no live tombstone, or if the key has been
+// recreated since and replace is false
+func (s *Store) Undelete(key string, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeTombstones(clockNow())
+	t, ok := s.tombstones[key]
+	if !ok {
+		return false
+	}
+	if !replace && s.data.get(key) != nil {
+		return false
+	}
+	delete(s.tombstones, key)
+	s.put(key, t.entry)
+	return true
+}
+
+// UNDELETE key [REPLACE]
+func undeleteCommand(c *Client, args []string) string {
+	replace := false
+	switch {
+	case len(args) > 3:
+		return formatError("ERR syntax error")
+	case len(args) == 3:
+		if strings.ToUpper(args[2]) != "REPLACE" {
+			return formatError("ERR syntax error")
+		}
+		replace = true
+	}
+
+	db := c.db()
+	db.mu.RLock()
+	enabled := db.tombstoneWindow > 0
+	db.mu.RUnlock()
+	if !enabled {
+		return formatError("ERR soft deletion is disabled (set tombstone-window to enable it)")
+	}
+
+	if db.Undelete(args[1], replace) {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreTombstones(t *testing.T) {
+	store := NewStore()
+
+	// Test 1: Without a window DEL is final
+	store.Set("k", "v")
+	store.Del("k")
+	if store.Undelete("k", false) {
+		t.Fatalf("expected undelete to fail with soft deletion disabled")
+	}
+
+	// Test 2: Deleted keys can be restored within the window
+	store.SetTombstoneWindow(time.Hour)
+	store.Set("k", "v1")
+	store.Del("k")
+	if _, ok := store.Tombstone("k"); !ok {
+		t.Fatalf("expected a tombstone for k")
+	}
+	if !store.Undelete("k", false) {
+		t.Fatalf("expected undelete to succeed")
+	}
+	if value, exists, _ := store.Get("k"); !exists || value != "v1" {
+		t.Fatalf("expected (v1, true) after undelete, got (%s, %v)", value, exists)
+	}
+	if store.TombstoneCount() != 0 {
+		t.Fatalf("expected tombstone to be consumed, got %d", store.TombstoneCount())
+	}
+
+	// Test 3: A recreated key is only overwritten with replace
+	store.Del("k")
+	store.Set("k", "v2")
+	if store.Undelete("k", false) {
+		t.Fatalf("expected undelete onto a live key to fail without replace")
+	}
+	if !store.Undelete("k", true) {
+		t.Fatalf("expected undelete with replace to succeed")
+	}
+	if value, _, _ := store.Get("k"); value != "v1" {
+		t.Fatalf("expected v1 after replacing undelete, got %s", value)
+	}
+
+	// Test 4: Tombstones expire after the window
+	store.SetTombstoneWindow(20 * time.Millisecond)
+	store.Del("k")
+	time.Sleep(30 * time.Millisecond)
+	if store.Undelete("k", false) {
+		t.Fatalf("expected tombstone to have expired")
+	}
+}
+
+func TestUndeleteCommand(t *testing.T) {
+	client := &Client{}
+	for _, args := range [][]string{{"UNDELETE", "k", "KEEP"}, {"UNDELETE", "k", "REPLACE", "junk"}} {
+		if reply := client.execute(args); reply != "-ERR syntax error\r\n" {
+			t.Fatalf("expected %v to be a syntax error, got %q", args, reply)
+		}
+	}
+}
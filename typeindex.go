@@ -0,0 +1,93 @@
+package main
+
+import (
+	"hash/maphash"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// indexedType reports whether keys of entryType are kept in a type index.
+// Strings are left out: they usually make up most of the keyspace, so an
+// index would double the cost of the most common writes, while a filtered
+// scan of the main table finds them nearly as quickly.
+func indexedType(entryType string) bool {
+	return entryType != TypeString
+}
+
+// newTypeIndex creates the index of one type: a keyspace holding the same
+// entries as the main one, without expiration tracking or indexes of its own
+func newTypeIndex(codec *keyCodec) *keyspace {
+	return &keyspace{
+		seed:    maphash.MakeSeed(),
+		buckets: make([]map[string]*Entry, keyspaceInitialBuckets),
+		codec:   codec,
+	}
+}
+
+// reindex updates the type counts and indexes after key went from holding
+// old to holding entry; either may be nil for an insertion or a removal
+func (ks *keyspace) reindex(key string, old, entry *Entry) {
+	if old != nil && (entry == nil || old.Type != entry.Type) {
+		if ks.types[old.Type]--; ks.types[old.Type] == 0 {
+			delete(ks.types, old.Type)
+		}
+		if indexedType(old.Type) {
+			ks.indexes[old.Type].delete(key)
+		}
+	}
+	if entry == nil {
+		return
+	}
+	if old == nil || old.Type != entry.Type {
+		ks.types[entry.Type]++
+	}
+	if indexedType(entry.Type) {
+		index := ks.indexes[entry.Type]
+		if index == nil {
+			index = newTypeIndex(ks.codec)
+			ks.indexes[entry.Type] = index
+		}
+		// Also refreshes the entry of a key replaced by one of the same type
+		index.set(key, entry)
+	}
+}
+
+// scanTable returns the table SCAN walks for keys of entryType (all keys if
+// empty), or nil if no key of the type was ever stored
+func (ks *keyspace) scanTable(entryType string) *keyspace {
+	if entryType == "" || !indexedType(entryType) {
+		return ks
+	}
+	return ks.indexes[entryType]
+}
+
+// TypeCounts returns the number of keys of each type, expired keys not yet
+// removed included
+func (s *Store) TypeCounts() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int, len(s.data.types))
+	for entryType, n := range s.data.types {
+		counts[entryType] = n
+	}
+	return counts
+}
+
+func infoKeyTypes() []string {
+	var lines []string
+	for i, db := range databases.All() {
+		counts := db.TypeCounts()
+		if len(counts) == 0 {
+			continue
+		}
+		fields := make([]string, 0, len(counts))
+		for entryType, n := range counts {
+			fields = append(fields, redisTypeNames[entryType]+"="+strconv.Itoa(n))
+		}
+		sort.Strings(fields)
+		lines = append(lines, "db"+strconv.Itoa(i)+":"+strings.Join(fields, ","))
+	}
+	return lines
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// WAITAOF numlocal numreplicas timeout
+//
+// Blocks until the writes of the client are fsynced to the append-only file
+// of numlocal servers (0 or 1, the local one) and of numreplicas replicas,
+// or until timeout milliseconds pass (0 waits forever). The reply holds the
+// number of local and replica acknowledgements. This server has no replicas
+// yet, so it behaves like Redis with no replica connected: requiring replicas
+// waits for the timeout. Requiring a local fsync is an error with appendonly
+// off.
+func waitaofCommand(c *Client, args []string) string {
+	numLocal, err := strconv.Atoi(args[1])
+	if err != nil || numLocal < 0 {
+		return formatError("ERR value is out of range, must be positive")
+	}
+	numReplicas, err := strconv.Atoi(args[2])
+	if err != nil || numReplicas < 0 {
+		return formatError("ERR value is out of range, must be positive")
+	}
+	ms, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return formatError("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return formatError("ERR timeout is negative")
+	}
+
+	f := aof.Load()
+	if numLocal > 0 && f == nil {
+		return formatError("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+	var fsynced <-chan struct{}
+	if numReplicas == 0 && numLocal > 0 && !c.aofFsynced(f) {
+		fsynced = f.waitFsync(c.aofOffset)
+	}
+	if numReplicas > 0 || fsynced != nil {
+		if refused := c.waitTimeout(time.Duration(ms)*time.Millisecond, fsynced); refused != "" || c.closing {
+			return refused
+		}
+	}
+	local := 0
+	if f != nil && c.aofFsynced(f) {
+		local = 1
+	}
+	return "*2\r\n" + formatInteger(local) + formatInteger(0)
+}
+
+// waitTimeout parks the client for timeout (forever if 0), or until ready is
+// closed if not nil. It returns the UNBLOCKED error if CLIENT UNBLOCK ...
+// ERROR ended the wait, and sets c.closing if the connection was closed in
+// the meantime.
+func (c *Client) waitTimeout(timeout time.Duration, ready <-chan struct{}) string {
+	if c.inExec {
+		return ""
+	}
+	defer c.releaseExec()()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	unblocked := c.beginBlock(nil, timeout)
+	defer c.endBlock()
+
+	select {
+	case <-expired:
+	case <-ready:
+	case withError := <-unblocked:
+		if withError {
+			return formatError(unblockedError)
+		}
+	case <-c.hangup: // The peer closed the connection
+		c.closing = true
+	}
+	return ""
+}
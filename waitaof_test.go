@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitAOF(t *testing.T) {
+	client := &Client{}
+
+	// Test 1: Nothing to wait for replies at once with no acknowledgements
+	if reply := client.execute([]string{"WAITAOF", "0", "0", "0"}); reply != "*2\r\n:0\r\n:0\r\n" {
+		t.Fatalf("expected [0 0], got %q", reply)
+	}
+
+	// Test 2: A local fsync cannot be waited for without an append-only file
+	if reply := client.execute([]string{"WAITAOF", "1", "0", "0"}); reply != "-ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.\r\n" {
+		t.Fatalf("expected appendonly error, got %q", reply)
+	}
+
+	// Test 3: Waiting for replicas runs into the timeout
+	start := time.Now()
+	if reply := client.execute([]string{"WAITAOF", "0", "1", "50"}); reply != "*2\r\n:0\r\n:0\r\n" {
+		t.Fatalf("expected [0 0], got %q", reply)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("expected WAITAOF to wait for its timeout")
+	}
+
+	// Test 4: Argument validation
+	for _, args := range [][]string{{"WAITAOF", "-1", "0", "0"}, {"WAITAOF", "0", "x", "0"}, {"WAITAOF", "0", "0", "-5"}} {
+		if reply := client.execute(args); reply[0] != '-' {
+			t.Fatalf("expected an error for %v, got %q", args, reply)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+// watchedKey is a key a client watches, in the database it selected then
+type watchedKey struct {
+	db  *Store
+	key string
+}
+
+// watch adds c to the watchers of key in this database
+// Returns false if it already watched it
+func (s *Store) watch(c *Client, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.watchers[key][c]; ok {
+		return false
+	}
+	if s.watchers == nil {
+		s.watchers = make(map[string]map[*Client]bool)
+	}
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[*Client]bool)
+	}
+	entry := s.data.lookup(key)
+	s.watchers[key][c] = entry != nil && entry.expired(clockNow())
+	return true
+}
+
+// unwatch removes c from the watchers of key
+func (s *Store) unwatch(c *Client, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.watchers[key], c)
+	if len(s.watchers[key]) == 0 {
+		delete(s.watchers, key)
+	}
+}
+
+// touchWatched makes EXEC fail for the clients watching key, which a write
+// changed or removed. Removing a key that had already expired when a client
+// watched it leaves that client's transaction alone, as nothing it could
+// see changed.
+// Called by keyModified with the write lock held.
+func (s *Store) touchWatched(key string) {
+	if len(s.watchers[key]) == 0 {
+		return
+	}
+	exists := s.data.peek(key) != nil
+	for c, expired := range s.watchers[key] {
+		if exists || !expired {
+			c.watchDirty.Store(true)
+		}
+	}
+}
+
+// touchAllWatched makes EXEC fail for the clients watching a key that exists
+// in this database or in other (if not nil), before FLUSHDB empties it or
+// SWAPDB exchanges the two
+// Callers must hold the write lock, and other's too.
+func (s *Store) touchAllWatched(other *Store) {
+	for key, clients := range s.watchers {
+		if s.data.peek(key) == nil && (other == nil || other.data.peek(key) == nil) {
+			continue
+		}
+		for c := range clients {
+			c.watchDirty.Store(true)
+		}
+	}
+}
+
+// watchExpired reports whether key expired since c watched it, which counts
+// as a change even before the key is removed
+func (s *Store) watchExpired(c *Client, key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.data.lookup(key)
+	return entry != nil && entry.expired(clockNow()) && !s.watchers[key][c]
+}
+
+// watchChanged reports whether a key the client watches changed since WATCH
+func (c *Client) watchChanged() bool {
+	if c.watchDirty.Load() {
+		return true
+	}
+	for _, w := range c.watching {
+		if w.db.watchExpired(c, w.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwatchAll forgets every key the client watches
+func (c *Client) unwatchAll() {
+	for _, w := range c.watching {
+		w.db.unwatch(c, w.key)
+	}
+	c.watching = nil
+	c.watchDirty.Store(false)
+}
+
+// WATCH key [key ...]
+func watchCommand(c *Client, args []string) string {
+	if c.multi != nil {
+		return formatError("ERR WATCH inside MULTI is not allowed")
+	}
+	db := c.db()
+	for _, key := range args[1:] {
+		if db.watch(c, key) {
+			c.watching = append(c.watching, watchedKey{db: db, key: key})
+		}
+	}
+	return formatSimpleString("OK")
+}
+
+// UNWATCH
+func unwatchCommand(c *Client, args []string) string {
+	c.unwatchAll()
+	return formatSimpleString("OK")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+	vc := NewVirtualClock(time.UnixMilli(1700000000000))
+	SetClock(vc)
+	defer SetClock(nil)
+
+	client, other := &Client{}, &Client{}
+	steps := func(steps []struct{ args, want string }) {
+		t.Helper()
+		for _, step := range steps {
+			c := client
+			args := step.args
+			if rest, ok := strings.CutPrefix(args, "other: "); ok {
+				c, args = other, rest
+			}
+			if reply := c.execute(strings.Fields(args)); reply != step.want {
+				t.Fatalf("expected %s to reply %q, got %q", step.args, step.want, reply)
+			}
+		}
+	}
+
+	// Test 1: EXEC runs the transaction if no watched key changed, and
+	// replies with a null array without running it otherwise
+	steps([]struct{ args, want string }{
+		{"SET k 1", "+OK\r\n"},
+		{"WATCH k other", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"SET k 2", "+QUEUED\r\n"},
+		{"EXEC", "*1\r\n+OK\r\n"},
+		{"WATCH k", "+OK\r\n"},
+		{"other: SET k 3", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"SET k 4", "+QUEUED\r\n"},
+		{"EXEC", formatNullArray()},
+		{"GET k", "$1\r\n3\r\n"},
+	})
+
+	// Test 2: EXEC and UNWATCH forget the watched keys
+	steps([]struct{ args, want string }{
+		{"other: SET k 5", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", "*0\r\n"},
+		{"WATCH k", "+OK\r\n"},
+		{"UNWATCH", "+OK\r\n"},
+		{"other: DEL k", ":1\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", "*0\r\n"},
+		{"WATCH k", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"WATCH k", "-ERR WATCH inside MULTI is not allowed\r\n"},
+		{"DISCARD", "+OK\r\n"},
+	})
+	if n := len(databases.Get(0).watchers); n != 0 {
+		t.Fatalf("expected no watched keys left, got %d", n)
+	}
+
+	// Test 3: Creating a missing key, or letting a key expire, counts as a
+	// change
+	databases.Get(0).SetDefaultTTLs(0, map[string]time.Duration{"tmp:": time.Second})
+	steps([]struct{ args, want string }{
+		{"WATCH missing", "+OK\r\n"},
+		{"other: SET missing x", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", formatNullArray()},
+		{"SET tmp:k x", "+OK\r\n"},
+		{"WATCH tmp:k", "+OK\r\n"},
+	})
+	vc.Advance(2 * time.Second)
+	steps([]struct{ args, want string }{
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", formatNullArray()},
+	})
+
+	// Test 4: FLUSHDB and SWAPDB change the watched keys that exist
+	steps([]struct{ args, want string }{
+		{"WATCH missing", "+OK\r\n"},
+		{"other: FLUSHDB", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", formatNullArray()},
+		{"WATCH nowhere", "+OK\r\n"},
+		{"other: SWAPDB 0 1", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", "*0\r\n"},
+		{"other: SELECT 1", "+OK\r\n"},
+		{"other: SET k x", "+OK\r\n"},
+		{"WATCH k", "+OK\r\n"},
+		{"other: SWAPDB 0 1", "+OK\r\n"},
+		{"MULTI", "+OK\r\n"},
+		{"EXEC", formatNullArray()},
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamRead is the entries XREAD returns from one stream
+type streamRead struct {
+	key     string
+	entries []streamEntry
+}
+
+// readStreams returns up to count entries (all if negative) of each stream
+// in keys with IDs greater than the matching one in after, skipping streams
+// with none. Keys of other types fail the read.
+// Returns (reads, isCorrectType)
+// Callers must hold the lock.
+func (s *Store) readStreams(keys []string, after []streamID, count int) ([]streamRead, bool) {
+	var reads []streamRead
+	for i, key := range keys {
+		st, ok := s.streamValue(key)
+		if !ok {
+			return nil, false
+		}
+		start, more := after[i].next()
+		if st == nil || !more {
+			continue
+		}
+		if entries := st.rangeEntries(start, maxStreamID, count, false); len(entries) > 0 {
+			reads = append(reads, streamRead{key, entries})
+		}
+	}
+	return reads, true
+}
+
+// XLastID returns the ID of the newest entry ever added to the stream at
+// key, which is 0-0 for a missing key
+// Returns (id, isCorrectType)
+func (s *Store) XLastID(key string) (streamID, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.streamValue(key)
+	if st == nil {
+		return streamID{}, ok
+	}
+	return st.lastID, true
+}
+
+// formatStreamReads formats reads as an array of [key, entries] pairs, or a
+// map from keys to entries for RESP3 clients
+func (c *Client) formatStreamReads(reads []streamRead) string {
+	var b strings.Builder
+	if c.resp3 {
+		b.WriteString("%" + strconv.Itoa(len(reads)) + "\r\n")
+	} else {
+		b.WriteString("*" + strconv.Itoa(len(reads)) + "\r\n")
+	}
+	for _, r := range reads {
+		if !c.resp3 {
+			b.WriteString("*2\r\n")
+		}
+		b.WriteString(formatBulkString(r.key) + formatStreamEntries(r.entries))
+	}
+	return b.String()
+}
+
+// parseStreamsArgs splits the arguments following STREAMS into keys and
+// their IDs, which come in the same order
+// Returns a non-empty error reply on failure
+func parseStreamsArgs(name string, args []string) (keys, ids []string, errReply string) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, nil, formatError("ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	return args[:len(args)/2], args[len(args)/2:], ""
+}
+
+// parseStreamCount parses the COUNT option of stream reads; zero or a
+// negative count returns every entry
+// Returns a non-empty error reply on failure
+func parseStreamCount(arg string) (int, string) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, formatError("ERR value is not an integer or out of range")
+	}
+	if n <= 0 {
+		return -1, ""
+	}
+	return int(min(n, math.MaxInt)), ""
+}
+
+// parseBlockMillis parses the BLOCK option of stream reads, in milliseconds
+// Returns a non-empty error reply on failure
+func parseBlockMillis(arg string) (time.Duration, string) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, formatError("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, formatError("ERR timeout is negative")
+	}
+	return time.Duration(min(ms, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond, ""
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xreadCommand(c *Client, args []string) string {
+	count, block := -1, time.Duration(-1)
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		switch {
+		case name == "STREAMS":
+			break options
+		case name == "COUNT" && i+1 < len(args):
+			var errReply string
+			if count, errReply = parseStreamCount(args[i+1]); errReply != "" {
+				return errReply
+			}
+		case name == "BLOCK" && i+1 < len(args):
+			var errReply string
+			if block, errReply = parseBlockMillis(args[i+1]); errReply != "" {
+				return errReply
+			}
+		default:
+			return formatError("ERR syntax error")
+		}
+		i++
+	}
+	if i == len(args) {
+		return formatError("ERR syntax error")
+	}
+	keys, idArgs, errReply := parseStreamsArgs("xread", args[i+1:])
+	if errReply != "" {
+		return errReply
+	}
+
+	db := c.db()
+	after := make([]streamID, len(keys))
+	for j, arg := range idArgs {
+		if arg == "$" {
+			// Only entries added from now on
+			id, ok := db.XLastID(keys[j])
+			if !ok {
+				return formatError(wrongTypeError)
+			}
+			after[j] = id
+			continue
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return formatError(streamInvalidIDError)
+		}
+		after[j] = id
+	}
+
+	serve := func(db *Store, _ string) (string, bool) {
+		reads, ok := db.readStreams(keys, after, count)
+		if !ok {
+			return formatError(wrongTypeError), true
+		}
+		if len(reads) == 0 {
+			return "", false
+		}
+		return c.formatStreamReads(reads), true
+	}
+	if block < 0 {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		if reply, ok := serve(db, ""); ok {
+			return reply
+		}
+		return formatNullArray()
+	}
+
+	reply, bc := db.serveOrBlock(keys, TypeStream, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, block, formatNullArray())
+}
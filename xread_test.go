@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXRead(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	entry := func(id string, fields ...string) string {
+		return "*2\r\n" + formatBulkString(id) + formatArray(fields)
+	}
+	read := func(key string, entries ...string) string {
+		return "*2\r\n" + formatBulkString(key) + "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+	}
+	run("XADD", "a", "1-1", "f", "1")
+	run("XADD", "a", "2-1", "f", "2")
+	run("XADD", "b", "1-5", "g", "1")
+
+	// Test 1: Entries after the given IDs, only from streams that have some
+	for args, want := range map[string]string{
+		"XREAD STREAMS a b 0 0":                                     "*2\r\n" + read("a", entry("1-1", "f", "1"), entry("2-1", "f", "2")) + read("b", entry("1-5", "g", "1")),
+		"XREAD COUNT 1 STREAMS a b 1-1 0-0":                         "*2\r\n" + read("a", entry("2-1", "f", "2")) + read("b", entry("1-5", "g", "1")),
+		"XREAD COUNT 0 STREAMS a 1-1":                               "*1\r\n" + read("a", entry("2-1", "f", "2")),
+		"XREAD STREAMS a b missing 2-1 1 0":                         "*1\r\n" + read("b", entry("1-5", "g", "1")),
+		"XREAD STREAMS a b $ $":                                     "*-1\r\n",
+		"XREAD STREAMS missing 0":                                   "*-1\r\n",
+		"XREAD STREAMS a 18446744073709551615-18446744073709551615": "*-1\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 2: RESP3 clients get a map from keys to entries
+	resp3 := &Client{resp3: true}
+	if reply := resp3.execute([]string{"XREAD", "COUNT", "1", "STREAMS", "a", "0"}); reply != "%1\r\n"+formatBulkString("a")+"*1\r\n"+entry("1-1", "f", "1") {
+		t.Fatalf("unexpected RESP3 reply %q", reply)
+	}
+
+	// Test 3: BLOCK waits for entries added after $, and times out with a
+	// null array
+	done := make(chan string, 1)
+	go func() { done <- (&Client{}).execute([]string{"XREAD", "BLOCK", "5000", "STREAMS", "a", "c", "$", "$"}) }()
+	waitForBlocked(t, 1)
+	run("XADD", "a", "3-1", "f", "3")
+	if reply := <-done; reply != "*1\r\n"+read("a", entry("3-1", "f", "3")) {
+		t.Fatalf("expected the blocked read to get the new entry, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+
+	go func() { done <- (&Client{}).execute([]string{"XREAD", "BLOCK", "0", "STREAMS", "new", "$"}) }()
+	waitForBlocked(t, 1)
+	run("SET", "other", "x")
+	run("XADD", "new", "7-7", "k", "v")
+	if reply := <-done; reply != "*1\r\n"+read("new", entry("7-7", "k", "v")) {
+		t.Fatalf("expected BLOCK 0 to wait for a new stream, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+
+	start := time.Now()
+	if reply := run("XREAD", "BLOCK", "50", "STREAMS", "a", "$"); reply != "*-1\r\n" {
+		t.Fatalf("expected a null array on timeout, got %q", reply)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("expected XREAD to wait for its timeout")
+	}
+	if reply := run("XREAD", "BLOCK", "1000", "STREAMS", "a", "0"); !strings.HasPrefix(reply, "*1\r\n") {
+		t.Fatalf("expected existing entries to be served without blocking, got %q", reply)
+	}
+
+	// Test 4: Errors
+	run("SET", "str", "x")
+	for args, want := range map[string]string{
+		"XREAD STREAMS a b 0":         "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n",
+		"XREAD COUNT 1 a 0":           "-ERR syntax error\r\n",
+		"XREAD COUNT x STREAMS a 0":   "-ERR value is not an integer or out of range\r\n",
+		"XREAD BLOCK -1 STREAMS a 0":  "-ERR timeout is negative\r\n",
+		"XREAD BLOCK x STREAMS a 0":   "-ERR timeout is not an integer or out of range\r\n",
+		"XREAD STREAMS a x":           "-" + streamInvalidIDError + "\r\n",
+		"XREAD STREAMS a str 0 0":     "-" + wrongTypeError + "\r\n",
+		"XREAD STREAMS str $":         "-" + wrongTypeError + "\r\n",
+		"XREAD BLOCK 0 STREAMS str 0": "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
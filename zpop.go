@@ -0,0 +1,210 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ZPop removes up to count elements with the lowest scores from the sorted
+// set at key, or with highest the highest ones first
+// Returns (elements, isCorrectType)
+func (s *Store) ZPop(key string, highest bool, count int) ([]zsetElement, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.zpop(key, highest, count)
+}
+
+// zpop is ZPop for callers holding the write lock
+func (s *Store) zpop(key string, highest bool, count int) ([]zsetElement, bool) {
+	zs, ok := s.mutableSortedSet(key)
+	if zs == nil || count <= 0 {
+		return nil, ok
+	}
+	n := min(count, zs.len())
+	from, to := 0, n
+	if highest {
+		from, to = zs.len()-n, zs.len()
+	}
+	popped := zs.appendRange(make([]zsetElement, 0, n), from, to, highest)
+	zs.removeRanks(from, to)
+	s.sortedSetModified(key, zs)
+	return popped, true
+}
+
+// ZMPop pops up to count elements from the first non-empty sorted set among keys
+// Returns (key, elements, isCorrectType)
+func (s *Store) ZMPop(keys []string, highest bool, count int) (string, []zsetElement, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		popped, ok := s.zpop(key, highest, count)
+		if !ok {
+			return "", nil, false
+		}
+		if len(popped) > 0 {
+			return key, popped, true
+		}
+	}
+	return "", nil, true
+}
+
+// formatPairs formats sorted set elements as an array of [member, score]
+// pairs, as ZMPOP replies in both protocols
+func (c *Client) formatPairs(elements []zsetElement) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(elements)) + "\r\n")
+	for _, e := range elements {
+		b.WriteString("*2\r\n" + formatBulkString(e.member) + c.formatDouble(e.score))
+	}
+	return b.String()
+}
+
+// ZPOPMIN key [count]
+func zpopminCommand(c *Client, args []string) string {
+	return zpopGeneric(c, args, false)
+}
+
+// ZPOPMAX key [count]
+func zpopmaxCommand(c *Client, args []string) string {
+	return zpopGeneric(c, args, true)
+}
+
+// zpopGeneric implements ZPOPMIN and ZPOPMAX. Without a count the reply is
+// a flat member and score; with one, RESP3 clients get pairs.
+func zpopGeneric(c *Client, args []string, highest bool) string {
+	count := 1
+	switch len(args) {
+	case 2:
+	case 3:
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		if n < 0 {
+			return formatError("ERR value is out of range, must be positive")
+		}
+		count = n
+	default:
+		return formatError("ERR syntax error")
+	}
+
+	popped, ok := c.db().ZPop(args[1], highest, count)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if len(args) == 3 && c.resp3 {
+		return c.formatPairs(popped)
+	}
+	return c.formatElements(popped, true)
+}
+
+// BZPOPMIN key [key ...] timeout
+func bzpopminCommand(c *Client, args []string) string {
+	return bzpopGeneric(c, args, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func bzpopmaxCommand(c *Client, args []string) string {
+	return bzpopGeneric(c, args, true)
+}
+
+// bzpopGeneric implements BZPOPMIN and BZPOPMAX, replying with the key, the
+// member and its score
+func bzpopGeneric(c *Client, args []string, highest bool) string {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != "" {
+		return errReply
+	}
+	serve := func(db *Store, key string) (string, bool) {
+		popped, ok := db.zpop(key, highest, 1)
+		if !ok || len(popped) == 0 {
+			return "", false
+		}
+		return "*3\r\n" + formatBulkString(key) + formatBulkString(popped[0].member) + c.formatDouble(popped[0].score), true
+	}
+
+	reply, bc := c.db().serveOrBlock(args[1:len(args)-1], TypeSortedSet, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, timeout, formatNullArray())
+}
+
+// parseZMPop parses the "numkeys key [key ...] MIN|MAX [COUNT count]"
+// arguments shared by ZMPOP and BZMPOP
+// Returns a non-empty error reply on failure
+func parseZMPop(args []string) (keys []string, highest bool, count int, errReply string) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, formatError("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-1 {
+		return nil, false, 0, formatError("ERR Number of keys can't be greater than number of args")
+	}
+	keys = args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+	if len(rest) == 0 {
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+
+	switch strings.ToUpper(rest[0]) {
+	case "MIN":
+	case "MAX":
+		highest = true
+	default:
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+	count = 1
+	switch {
+	case len(rest) == 3 && strings.ToUpper(rest[1]) == "COUNT":
+		count, err = strconv.Atoi(rest[2])
+		if err != nil || count <= 0 {
+			return nil, false, 0, formatError("ERR count should be greater than 0")
+		}
+	case len(rest) != 1:
+		return nil, false, 0, formatError("ERR syntax error")
+	}
+	return keys, highest, count, ""
+}
+
+// ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+func zmpopCommand(c *Client, args []string) string {
+	keys, highest, count, errReply := parseZMPop(args[1:])
+	if errReply != "" {
+		return errReply
+	}
+	key, popped, ok := c.db().ZMPop(keys, highest, count)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	if len(popped) == 0 {
+		return formatNullArray()
+	}
+	return "*2\r\n" + formatBulkString(key) + c.formatPairs(popped)
+}
+
+// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+func bzmpopCommand(c *Client, args []string) string {
+	timeout, errReply := parseBlockTimeout(args[1])
+	if errReply != "" {
+		return errReply
+	}
+	keys, highest, count, errReply := parseZMPop(args[2:])
+	if errReply != "" {
+		return errReply
+	}
+	serve := func(db *Store, key string) (string, bool) {
+		popped, ok := db.zpop(key, highest, count)
+		if !ok || len(popped) == 0 {
+			return "", false
+		}
+		return "*2\r\n" + formatBulkString(key) + c.formatPairs(popped), true
+	}
+
+	reply, bc := c.db().serveOrBlock(keys, TypeSortedSet, serve)
+	if bc == nil {
+		return reply
+	}
+	return c.waitBlocked(bc, args, timeout, formatNullArray())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZPop(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+
+	// Test 1: ZPOPMIN and ZPOPMAX pop from either end
+	if reply := run("ZPOPMIN", "z"); reply != formatArray([]string{"a", "1"}) {
+		t.Fatalf("unexpected ZPOPMIN %q", reply)
+	}
+	if reply := run("ZPOPMAX", "z", "2"); reply != formatArray([]string{"d", "4", "c", "3"}) {
+		t.Fatalf("unexpected ZPOPMAX %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"ZPOPMIN", "z", "5"}); reply != "*1\r\n*2\r\n$1\r\nb\r\n,2\r\n" {
+		t.Fatalf("expected RESP3 pairs, got %q", reply)
+	}
+	if _, found := databases.Get(0).KeyType("z"); found {
+		t.Fatalf("expected the emptied key to be deleted")
+	}
+	for args, want := range map[string]string{
+		"ZPOPMIN z":     "*0\r\n",
+		"ZPOPMIN z 0":   "*0\r\n",
+		"ZPOPMIN z -1":  "-ERR value is out of range, must be positive\r\n",
+		"ZPOPMIN z x":   "-ERR value is not an integer or out of range\r\n",
+		"ZPOPMAX z 1 2": "-ERR syntax error\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 2: ZMPOP pops from the first non-empty key
+	run("ZADD", "second", "5", "x", "6", "y", "7", "z")
+	want := "*2\r\n$6\r\nsecond\r\n*2\r\n*2\r\n$1\r\nz\r\n$1\r\n7\r\n*2\r\n$1\r\ny\r\n$1\r\n6\r\n"
+	if reply := run("ZMPOP", "2", "first", "second", "MAX", "COUNT", "2"); reply != want {
+		t.Fatalf("unexpected ZMPOP %q", reply)
+	}
+	for args, want := range map[string]string{
+		"ZMPOP 1 none MIN":           "*-1\r\n",
+		"ZMPOP 0 z MIN":              "-ERR numkeys should be greater than 0\r\n",
+		"ZMPOP 3 z MIN":              "-ERR Number of keys can't be greater than number of args\r\n",
+		"ZMPOP 1 second LEFT":        "-ERR syntax error\r\n",
+		"ZMPOP 1 second MIN COUNT 0": "-ERR count should be greater than 0\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	run("SET", "s", "v")
+	if run("ZPOPMIN", "s") != formatError(wrongTypeError) || run("ZMPOP", "1", "s", "MIN") != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE")
+	}
+}
+
+func TestBlockingZPop(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	writer := &Client{}
+
+	// Test 1: Data already present is served without blocking
+	writer.execute([]string{"ZADD", "z", "1", "a", "2", "b"})
+	if reply := (&Client{}).execute([]string{"BZPOPMAX", "empty", "z", "0"}); reply != formatArray([]string{"z", "b", "2"}) {
+		t.Fatalf("expected an immediate pop, got %q", reply)
+	}
+	if reply := (&Client{}).execute([]string{"BZPOPMIN", "empty", "0.01"}); reply != "*-1\r\n" {
+		t.Fatalf("expected a null array on timeout, got %q", reply)
+	}
+
+	// Test 2: ZADD wakes waiters in the order they blocked
+	first, second := make(chan string, 1), make(chan string, 1)
+	go func() { first <- (&Client{}).execute([]string{"BZPOPMIN", "q", "5"}) }()
+	waitForBlocked(t, 1)
+	go func() { second <- (&Client{}).execute([]string{"BZMPOP", "5", "2", "other", "q", "MIN", "COUNT", "5"}) }()
+	waitForBlocked(t, 2)
+	writer.execute([]string{"ZADD", "q", "3", "c", "1", "a", "2", "b"})
+	if reply := <-first; reply != formatArray([]string{"q", "a", "1"}) {
+		t.Fatalf("expected the first waiter to get a, got %q", reply)
+	}
+	want := "*2\r\n$1\r\nq\r\n*2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"
+	if reply := <-second; reply != want {
+		t.Fatalf("expected the second waiter to get the rest, got %q", reply)
+	}
+	waitForBlocked(t, 0)
+
+	// Test 3: Keys of another type fail without blocking
+	writer.execute([]string{"RPUSH", "l", "a"})
+	if reply := (&Client{}).execute([]string{"BZPOPMIN", "l", "0"}); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
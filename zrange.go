@@ -0,0 +1,396 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// scoreRange is a score interval as given to ZRANGEBYSCORE: "(" before a
+// bound excludes it, and the bounds may be -inf or +inf
+type scoreRange struct {
+	min, max     float64
+	minEx, maxEx bool // Whether the bounds are excluded
+}
+
+// parseScoreRange parses the min and max arguments of a score range
+func parseScoreRange(min, max string) (scoreRange, bool) {
+	var r scoreRange
+	var okMin, okMax bool
+	r.min, r.minEx, okMin = parseScoreBound(min)
+	r.max, r.maxEx, okMax = parseScoreBound(max)
+	return r, okMin && okMax
+}
+
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	score, ok := parseScore(strings.TrimPrefix(s, "("))
+	return score, exclusive, ok
+}
+
+func (r scoreRange) aboveMin(score float64) bool {
+	return score > r.min || (score == r.min && !r.minEx)
+}
+
+func (r scoreRange) belowMax(score float64) bool {
+	return score < r.max || (score == r.max && !r.maxEx)
+}
+
+// lexBound is one end of a lexicographic range: "-" and "+" are the lowest
+// and highest possible members, "[x" includes x and "(x" excludes it
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for "-", 1 for "+", 0 for a member
+}
+
+// lexRange is a member interval as given to ZRANGEBYLEX, meaningful when all
+// members have the same score
+type lexRange struct {
+	min, max lexBound
+}
+
+// parseLexRange parses the min and max arguments of a lexicographic range
+func parseLexRange(min, max string) (lexRange, bool) {
+	var r lexRange
+	var okMin, okMax bool
+	r.min, okMin = parseLexBound(min)
+	r.max, okMax = parseLexBound(max)
+	return r, okMin && okMax
+}
+
+func parseLexBound(s string) (lexBound, bool) {
+	switch {
+	case s == "-":
+		return lexBound{inf: -1}, true
+	case s == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return lexBound{value: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return lexBound{value: s[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+func (r lexRange) aboveMin(member string) bool {
+	if r.min.inf != 0 {
+		return r.min.inf < 0
+	}
+	c := strings.Compare(member, r.min.value)
+	return c > 0 || (c == 0 && !r.min.exclusive)
+}
+
+func (r lexRange) belowMax(member string) bool {
+	if r.max.inf != 0 {
+		return r.max.inf > 0
+	}
+	c := strings.Compare(member, r.max.value)
+	return c < 0 || (c == 0 && !r.max.exclusive)
+}
+
+// scoreRanks returns the ranks [from, to) of the elements whose score is within r
+func (zs *zset) scoreRanks(r scoreRange) (int, int) {
+	from := zs.search(func(e zsetElement) bool { return r.aboveMin(e.score) })
+	to := zs.search(func(e zsetElement) bool { return !r.belowMax(e.score) })
+	return from, max(from, to)
+}
+
+// lexRanks returns the ranks [from, to) of the elements whose member is within r
+func (zs *zset) lexRanks(r lexRange) (int, int) {
+	from := zs.search(func(e zsetElement) bool { return r.aboveMin(e.member) })
+	to := zs.search(func(e zsetElement) bool { return !r.belowMax(e.member) })
+	return from, max(from, to)
+}
+
+// How a ZRANGE interprets its start and stop arguments
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// ZRangeQuery selects the elements ZRANGE returns
+type ZRangeQuery struct {
+	By          int // zrangeByRank, zrangeByScore or zrangeByLex
+	Start, Stop int // Ranks, negative ones counting from the end
+	Score       scoreRange
+	Lex         lexRange
+	Reverse     bool // Order from the highest score; ranks count from it too
+	Offset      int  // Elements of the range skipped, with Count
+	Count       int  // Most elements returned, or -1 for all
+}
+
+// ranks returns the ascending ranks [from, to) of the elements the query
+// selects before LIMIT
+func (q ZRangeQuery) ranks(zs *zset) (int, int) {
+	switch q.By {
+	case zrangeByScore:
+		return zs.scoreRanks(q.Score)
+	case zrangeByLex:
+		return zs.lexRanks(q.Lex)
+	}
+	start, stop, ok := clampRange(q.Start, q.Stop, zs.len())
+	if !ok {
+		return 0, 0
+	}
+	if q.Reverse {
+		start, stop = zs.len()-1-stop, zs.len()-1-start
+	}
+	return start, stop + 1
+}
+
+// ZRange returns the elements of the sorted set at key selected by q, in
+// the order of the query
+// Returns (elements, isCorrectType)
+func (s *Store) ZRange(key string, q ZRangeQuery) ([]zsetElement, bool) {
+	s.mu.RLock()
+	zs, ok := s.sortedSet(key)
+	if zs == nil {
+		s.mu.RUnlock()
+		return nil, ok
+	}
+
+	from, to := q.ranks(zs)
+	if q.By != zrangeByRank {
+		if q.Offset < 0 {
+			from = to
+		}
+		skip := min(max(q.Offset, 0), to-from)
+		if q.Reverse {
+			to -= skip
+		} else {
+			from += skip
+		}
+		if q.Count >= 0 && q.Count < to-from {
+			if q.Reverse {
+				from = to - q.Count
+			} else {
+				to = from + q.Count
+			}
+		}
+	}
+
+	if to-from < cowMinElements {
+		defer s.mu.RUnlock()
+	} else {
+		// Copy long ranges without holding up writers
+		share := s.data.lookup(key).borrow()
+		s.mu.RUnlock()
+		defer share.release()
+	}
+	return zs.appendRange(make([]zsetElement, 0, to-from), from, to, q.Reverse), true
+}
+
+// ZCount counts the elements of the sorted set at key within the rank,
+// score or lex range of q, from the ranks bounding it
+// Returns (count, isCorrectType)
+func (s *Store) ZCount(key string, q ZRangeQuery) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if zs == nil {
+		return 0, ok
+	}
+	from, to := q.ranks(zs)
+	return to - from, true
+}
+
+// ZRemRange removes the elements of the sorted set at key that the rank,
+// score or lex range of q selects, deleting the key once the set is empty
+// Returns (number of elements removed, isCorrectType)
+func (s *Store) ZRemRange(key string, q ZRangeQuery) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zs, ok := s.mutableSortedSet(key)
+	if zs == nil {
+		return 0, ok
+	}
+	from, to := q.ranks(zs)
+	if from == to {
+		return 0, true
+	}
+	zs.removeRanks(from, to)
+	s.sortedSetModified(key, zs)
+	return to - from, true
+}
+
+// formatElements formats sorted set elements as an array of members, with
+// withScores followed by their scores: flat for RESP2, as [member, score]
+// pairs for RESP3
+func (c *Client) formatElements(elements []zsetElement, withScores bool) string {
+	var b strings.Builder
+	if withScores && !c.resp3 {
+		b.WriteString("*" + strconv.Itoa(2*len(elements)) + "\r\n")
+	} else {
+		b.WriteString("*" + strconv.Itoa(len(elements)) + "\r\n")
+	}
+	for _, e := range elements {
+		if withScores && c.resp3 {
+			b.WriteString("*2\r\n")
+		}
+		b.WriteString(formatBulkString(e.member))
+		if withScores {
+			b.WriteString(c.formatDouble(e.score))
+		}
+	}
+	return b.String()
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zrangeCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByRank, false, true)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func zrevrangeCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByRank, true, false)
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func zrangebyscoreCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByScore, false, false)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func zrevrangebyscoreCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByScore, true, false)
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func zrangebylexCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByLex, false, false)
+}
+
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func zrevrangebylexCommand(c *Client, args []string) string {
+	return zrangeGeneric(c, args, zrangeByLex, true, false)
+}
+
+// zrangeGeneric implements ZRANGE and its legacy forms, which fix the kind
+// of range and its direction. unified allows BYSCORE, BYLEX and REV, as
+// ZRANGE does. Reversed score and lex ranges take the maximum first.
+func zrangeGeneric(c *Client, args []string, by int, reverse, unified bool) string {
+	q := ZRangeQuery{By: by, Reverse: reverse, Count: -1}
+	withScores, limit := false, false
+	for i := 4; i < len(args); i++ {
+		switch arg := strings.ToUpper(args[i]); {
+		case arg == "WITHSCORES":
+			withScores = true
+		case arg == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return formatError("ERR value is not an integer or out of range")
+			}
+			q.Offset, q.Count, limit = offset, count, true
+			i += 2
+		case unified && arg == "REV" && !q.Reverse:
+			q.Reverse = true
+		case unified && arg == "BYSCORE" && q.By == zrangeByRank:
+			q.By = zrangeByScore
+		case unified && arg == "BYLEX" && q.By == zrangeByRank:
+			q.By = zrangeByLex
+		default:
+			return formatError("ERR syntax error")
+		}
+	}
+	switch {
+	case limit && q.By == zrangeByRank:
+		return formatError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	case withScores && q.By == zrangeByLex:
+		return formatError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	low, high := args[2], args[3]
+	if q.Reverse && q.By != zrangeByRank {
+		low, high = high, low
+	}
+	var ok bool
+	switch q.By {
+	case zrangeByScore:
+		if q.Score, ok = parseScoreRange(low, high); !ok {
+			return formatError("ERR min or max is not a float")
+		}
+	case zrangeByLex:
+		if q.Lex, ok = parseLexRange(low, high); !ok {
+			return formatError("ERR min or max not valid string range item")
+		}
+	default:
+		start, err1 := strconv.Atoi(low)
+		stop, err2 := strconv.Atoi(high)
+		if err1 != nil || err2 != nil {
+			return formatError("ERR value is not an integer or out of range")
+		}
+		q.Start, q.Stop = start, stop
+	}
+
+	elements, ok := c.db().ZRange(args[1], q)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatElements(elements, withScores)
+}
+
+// ZCOUNT key min max
+func zcountCommand(c *Client, args []string) string {
+	r, ok := parseScoreRange(args[2], args[3])
+	if !ok {
+		return formatError("ERR min or max is not a float")
+	}
+	return zcountGeneric(c, args[1], ZRangeQuery{By: zrangeByScore, Score: r})
+}
+
+// ZLEXCOUNT key min max
+func zlexcountCommand(c *Client, args []string) string {
+	r, ok := parseLexRange(args[2], args[3])
+	if !ok {
+		return formatError("ERR min or max not valid string range item")
+	}
+	return zcountGeneric(c, args[1], ZRangeQuery{By: zrangeByLex, Lex: r})
+}
+
+func zcountGeneric(c *Client, key string, q ZRangeQuery) string {
+	count, ok := c.db().ZCount(key, q)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(count)
+}
+
+// ZREMRANGEBYRANK key start stop
+func zremrangebyrankCommand(c *Client, args []string) string {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	return zremrangeGeneric(c, args[1], ZRangeQuery{By: zrangeByRank, Start: start, Stop: stop})
+}
+
+// ZREMRANGEBYSCORE key min max
+func zremrangebyscoreCommand(c *Client, args []string) string {
+	r, ok := parseScoreRange(args[2], args[3])
+	if !ok {
+		return formatError("ERR min or max is not a float")
+	}
+	return zremrangeGeneric(c, args[1], ZRangeQuery{By: zrangeByScore, Score: r})
+}
+
+// ZREMRANGEBYLEX key min max
+func zremrangebylexCommand(c *Client, args []string) string {
+	r, ok := parseLexRange(args[2], args[3])
+	if !ok {
+		return formatError("ERR min or max not valid string range item")
+	}
+	return zremrangeGeneric(c, args[1], ZRangeQuery{By: zrangeByLex, Lex: r})
+}
+
+func zremrangeGeneric(c *Client, key string, q ZRangeQuery) string {
+	removed, ok := c.db().ZRemRange(key, q)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(removed)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZRange(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	run("ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	members := func(members ...string) string {
+		return formatArray(members)
+	}
+
+	// Test 1: Ranges by rank, from either end
+	for args, want := range map[string]string{
+		"ZRANGE z 0 -1":              members("a", "b", "c", "d", "e"),
+		"ZRANGE z -2 100":            members("d", "e"),
+		"ZRANGE z 3 1":               members(),
+		"ZRANGE z 0 1 REV":           members("e", "d"),
+		"ZREVRANGE z 1 2":            members("d", "c"),
+		"ZRANGE z 0 0 WITHSCORES":    members("a", "1"),
+		"ZREVRANGE z 0 0 withscores": members("e", "5"),
+		"ZRANGE none 0 -1":           members(),
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 2: Ranges by score, with exclusive bounds, infinities and LIMIT
+	for args, want := range map[string]string{
+		"ZRANGE z 2 4 BYSCORE":                     members("b", "c", "d"),
+		"ZRANGE z (2 (4 BYSCORE":                   members("c"),
+		"ZRANGE z -inf +inf BYSCORE LIMIT 1 2":     members("b", "c"),
+		"ZRANGE z +inf 3 BYSCORE REV":              members("e", "d", "c"),
+		"ZRANGE z +inf -inf BYSCORE REV LIMIT 1 1": members("d"),
+		"ZRANGEBYSCORE z (1 3 WITHSCORES":          members("b", "2", "c", "3"),
+		"ZRANGEBYSCORE z 1 5 LIMIT 3 -1":           members("d", "e"),
+		"ZRANGEBYSCORE z 1 5 LIMIT -1 2":           members(),
+		"ZREVRANGEBYSCORE z 4 (2":                  members("d", "c"),
+		"ZRANGEBYSCORE z 4 2":                      members(),
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 3: Lexicographic ranges
+	for args, want := range map[string]string{
+		"ZRANGE lex [b (d BYLEX":            members("b", "c"),
+		"ZRANGE lex - + BYLEX LIMIT 3 5":    members("d", "e"),
+		"ZRANGE lex + [c BYLEX REV":         members("e", "d", "c"),
+		"ZRANGEBYLEX lex (a [b":             members("b"),
+		"ZRANGEBYLEX lex + -":               members(),
+		"ZREVRANGEBYLEX lex (c - LIMIT 0 1": members("b"),
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 4: RESP3 clients get [member, score] pairs
+	resp3 := &Client{resp3: true}
+	if reply := resp3.execute([]string{"ZRANGE", "z", "0", "1", "WITHSCORES"}); reply != "*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2\r\n" {
+		t.Fatalf("unexpected RESP3 reply %q", reply)
+	}
+
+	// Test 5: Invalid combinations and arguments
+	for args, want := range map[string]string{
+		"ZRANGE z 0 1 LIMIT 0 1":          "LIMIT is only supported",
+		"ZRANGE lex - + BYLEX WITHSCORES": "WITHSCORES not supported",
+		"ZRANGE z 0 1 BYSCORE BYLEX":      "syntax error",
+		"ZRANGEBYSCORE z 0 1 REV":         "syntax error",
+		"ZRANGE z a 1":                    "not an integer",
+		"ZRANGE z x 1 BYSCORE":            "not a float",
+		"ZRANGE z a b BYLEX":              "not valid string range item",
+		"ZRANGEBYSCORE z 0 1 LIMIT 0":     "syntax error",
+		"ZRANGEBYSCORE z 0 1 LIMIT 0 x":   "not an integer",
+	} {
+		if reply := run(strings.Fields(args)...); !strings.Contains(reply, want) {
+			t.Fatalf("expected %s to fail with %q, got %q", args, want, reply)
+		}
+	}
+	run("SET", "s", "v")
+	if reply := run("ZRANGE", "s", "0", "-1"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
+
+func TestZRemRange(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	exists := func(key string) bool {
+		_, found := databases.Get(0).KeyType(key)
+		return found
+	}
+	reset := func() {
+		run("DEL", "z")
+		run("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	}
+
+	// Test 1: Each form removes its range and reports the count
+	for args, want := range map[string]string{
+		"ZREMRANGEBYRANK z 0 1":        "c,d,e",
+		"ZREMRANGEBYRANK z -2 -1":      "a,b,c",
+		"ZREMRANGEBYRANK z 3 1":        "a,b,c,d,e",
+		"ZREMRANGEBYSCORE z (1 3":      "a,d,e",
+		"ZREMRANGEBYSCORE z 4 +inf":    "a,b,c",
+		"ZREMRANGEBYLEX z [b (e":       "a,e",
+		"ZREMRANGEBYLEX z - [a":        "b,c,d,e",
+		"ZREMRANGEBYSCORE z -inf +inf": "",
+	} {
+		reset()
+		run(strings.Fields(args)...)
+		var members []string
+		if zs, _ := databases.Get(0).sortedSet("z"); zs != nil {
+			zs.each(func(member string, _ float64) bool {
+				members = append(members, member)
+				return true
+			})
+		}
+		if got := strings.Join(members, ","); got != want {
+			t.Fatalf("expected %s to leave %q, got %q", args, want, got)
+		}
+	}
+	reset()
+	if reply := run("ZREMRANGEBYSCORE", "z", "2", "4"); reply != ":3\r\n" {
+		t.Fatalf("expected 3 removed, got %q", reply)
+	}
+
+	// Test 2: Emptying the set deletes the key
+	run("ZREMRANGEBYRANK", "z", "0", "-1")
+	if exists("z") {
+		t.Fatalf("expected the emptied key to be deleted")
+	}
+
+	// Test 3: Invalid ranges
+	for args, want := range map[string]string{
+		"ZREMRANGEBYRANK z a 1":  "not an integer",
+		"ZREMRANGEBYSCORE z x 1": "not a float",
+		"ZREMRANGEBYLEX z a b":   "not valid string range item",
+	} {
+		if reply := run(strings.Fields(args)...); !strings.Contains(reply, want) {
+			t.Fatalf("expected %s to fail with %q, got %q", args, want, reply)
+		}
+	}
+}
+
+func TestZCount(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "z", "1", "a", "2", "b", "2", "c", "3", "d")
+	run("ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+
+	// Test 1: Counts follow the same bounds as the ranges
+	for args, want := range map[string]string{
+		"ZCOUNT z 2 2":        ":2\r\n",
+		"ZCOUNT z (1 3":       ":3\r\n",
+		"ZCOUNT z -inf +inf":  ":4\r\n",
+		"ZCOUNT z 3 1":        ":0\r\n",
+		"ZCOUNT none 0 1":     ":0\r\n",
+		"ZLEXCOUNT lex - +":   ":4\r\n",
+		"ZLEXCOUNT lex (a [c": ":2\r\n",
+		"ZLEXCOUNT lex + -":   ":0\r\n",
+		"ZCOUNT z x 1":        "-ERR min or max is not a float\r\n",
+		"ZLEXCOUNT lex a c":   "-ERR min or max not valid string range item\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	run("SET", "s", "v")
+	if reply := run("ZCOUNT", "s", "0", "1"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zsetElement is a member of a sorted set with its score
+type zsetElement struct {
+	member string
+	score  float64
+}
+
+// compareElements orders sorted set elements by score, then by member
+func compareElements(a, b zsetElement) int {
+	switch {
+	case a.score < b.score:
+		return -1
+	case a.score > b.score:
+		return 1
+	}
+	return strings.Compare(a.member, b.member)
+}
+
+// zset is the representation of a sorted set. A small set is packed: its
+// elements in order in a single slice, searched linearly by member, as
+// Redis's listpack encoding. A larger one has the score of each member, and
+// a skiplist of the elements in order for ranges and ranks.
+type zset struct {
+	packed []zsetElement // Elements of a packed set; nil otherwise
+	dict   map[string]float64
+	list   *skiplist // nil while packed
+}
+
+func newZset(size int) *zset {
+	return &zset{dict: make(map[string]float64, size), list: newSkiplist()}
+}
+
+// sortedElements returns the elements with the score of each member, in order
+func sortedElements(scores map[string]float64) []zsetElement {
+	sorted := make([]zsetElement, 0, len(scores))
+	for member, score := range scores {
+		sorted = append(sorted, zsetElement{member, score})
+	}
+	slices.SortFunc(sorted, compareElements)
+	return sorted
+}
+
+// zsetFromScores builds a sorted set from the score of each member, sorting
+// once rather than inserting members one at a time
+func zsetFromScores(scores map[string]float64) *zset {
+	return &zset{dict: scores, list: buildSkiplist(slices.Values(sortedElements(scores)))}
+}
+
+// isPacked reports whether the set uses the packed encoding
+func (zs *zset) isPacked() bool {
+	return zs.list == nil
+}
+
+// find returns the index of member in a packed set, or -1
+func (zs *zset) find(member string) int {
+	return slices.IndexFunc(zs.packed, func(e zsetElement) bool { return e.member == member })
+}
+
+func (zs *zset) len() int {
+	if zs.isPacked() {
+		return len(zs.packed)
+	}
+	return zs.list.length
+}
+
+func (zs *zset) score(member string) (float64, bool) {
+	if zs.isPacked() {
+		if i := zs.find(member); i >= 0 {
+			return zs.packed[i].score, true
+		}
+		return 0, false
+	}
+	score, found := zs.dict[member]
+	return score, found
+}
+
+// set gives member the score, adding it if needed
+func (zs *zset) set(member string, score float64) {
+	e := zsetElement{member, score}
+	if zs.isPacked() {
+		if i := zs.find(member); i >= 0 {
+			if zs.packed[i].score == score {
+				return
+			}
+			zs.packed = slices.Delete(zs.packed, i, i+1)
+		}
+		i, _ := slices.BinarySearchFunc(zs.packed, e, compareElements)
+		zs.packed = slices.Insert(zs.packed, i, e)
+		return
+	}
+	if old, found := zs.dict[member]; found {
+		if old == score {
+			return
+		}
+		zs.list.delete(zsetElement{member, old})
+	}
+	zs.dict[member] = score
+	zs.list.insert(e)
+}
+
+// rank returns the position of member in ascending order, from 0
+func (zs *zset) rank(member string) (int, bool) {
+	if zs.isPacked() {
+		i := zs.find(member)
+		return i, i >= 0
+	}
+	score, found := zs.dict[member]
+	if !found {
+		return 0, false
+	}
+	return zs.list.rank(zsetElement{member, score}), true
+}
+
+func (zs *zset) remove(member string) bool {
+	if zs.isPacked() {
+		i := zs.find(member)
+		if i < 0 {
+			return false
+		}
+		zs.packed = slices.Delete(zs.packed, i, i+1)
+		return true
+	}
+	score, found := zs.dict[member]
+	if !found {
+		return false
+	}
+	delete(zs.dict, member)
+	zs.list.delete(zsetElement{member, score})
+	return true
+}
+
+// removeRanks deletes the elements of ranks [from, to) in ascending order
+func (zs *zset) removeRanks(from, to int) {
+	if zs.isPacked() {
+		zs.packed = slices.Delete(zs.packed, from, to)
+		return
+	}
+	zs.list.deleteRanks(from, to, func(e zsetElement) {
+		delete(zs.dict, e.member)
+	})
+}
+
+// at returns the element of the given rank in ascending order
+func (zs *zset) at(rank int) zsetElement {
+	if zs.isPacked() {
+		return zs.packed[rank]
+	}
+	return zs.list.nodeAt(rank).zsetElement
+}
+
+// search returns the rank of the first element for which pred is true, or
+// the length if there is none; see skiplist.search
+func (zs *zset) search(pred func(zsetElement) bool) int {
+	if zs.isPacked() {
+		return sort.Search(len(zs.packed), func(i int) bool { return pred(zs.packed[i]) })
+	}
+	return zs.list.search(pred)
+}
+
+// appendRange appends the elements of ranks [from, to) to dst, in
+// descending order with reverse
+func (zs *zset) appendRange(dst []zsetElement, from, to int, reverse bool) []zsetElement {
+	switch {
+	case from >= to:
+		return dst
+	case zs.isPacked() && reverse:
+		for i := to - 1; i >= from; i-- {
+			dst = append(dst, zs.packed[i])
+		}
+		return dst
+	case zs.isPacked():
+		return append(dst, zs.packed[from:to]...)
+	case reverse:
+		x := zs.list.nodeAt(to - 1)
+		for range to - from {
+			dst = append(dst, x.zsetElement)
+			x = x.backward
+		}
+		return dst
+	}
+	x := zs.list.nodeAt(from)
+	for range to - from {
+		dst = append(dst, x.zsetElement)
+		x = x.next()
+	}
+	return dst
+}
+
+// each calls fn with every element in ascending order until fn returns false
+func (zs *zset) each(fn func(member string, score float64) bool) {
+	if zs.isPacked() {
+		for _, e := range zs.packed {
+			if !fn(e.member, e.score) {
+				return
+			}
+		}
+		return
+	}
+	for x := zs.list.head.next(); x != nil; x = x.next() {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// clone returns an independent copy of the sorted set
+func (zs *zset) clone() *zset {
+	if zs.isPacked() {
+		return &zset{packed: slices.Clone(zs.packed)}
+	}
+	dict := make(map[string]float64, len(zs.dict))
+	for member, score := range zs.dict {
+		dict[member] = score
+	}
+	list := buildSkiplist(func(yield func(zsetElement) bool) {
+		zs.each(func(member string, score float64) bool {
+			return yield(zsetElement{member, score})
+		})
+	})
+	return &zset{dict: dict, list: list}
+}
+
+// unpack converts a packed set to the skiplist encoding in place
+func (zs *zset) unpack() {
+	zs.dict = make(map[string]float64, len(zs.packed)+1)
+	for _, e := range zs.packed {
+		zs.dict[e.member] = e.score
+	}
+	zs.list = buildSkiplist(slices.Values(zs.packed))
+	zs.packed = nil
+}
+
+// parseScore parses a sorted set score as Redis does: a float, inf, +inf
+// or -inf, but never NaN
+func parseScore(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatScore formats a score as Redis 7.2 does: the shortest digits that
+// parse back to f, written without an exponent unless it is large
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0:
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+	}
+	mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(math.Abs(f), 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(exponent) // Power of ten of the first digit
+	k := exp - len(digits) + 1       // Power of ten of the last digit
+	absExp := max(exp, -exp)
+
+	switch {
+	case k >= 0 && absExp < len(digits)+7:
+		return sign + digits + strings.Repeat("0", k)
+	case k < 0 && (k > -7 || absExp < 4):
+		point := len(digits) + k
+		if point > 0 {
+			return sign + digits[:point] + "." + digits[point:]
+		}
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+	s := sign + digits[:1]
+	if len(digits) > 1 {
+		s += "." + digits[1:]
+	}
+	if exp < 0 {
+		return s + "e-" + strconv.Itoa(absExp)
+	}
+	return s + "e+" + strconv.Itoa(absExp)
+}
+
+// sortedSet returns the sorted set stored at key. The set is nil if the key
+// does not exist; ok is false if the key holds another type.
+// Callers must hold the lock.
+func (s *Store) sortedSet(key string) (*zset, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeSortedSet {
+		return nil, false
+	}
+	return entry.Value.(*zset), true
+}
+
+// mutableSortedSet is like sortedSet for callers about to change the set in
+// place: a set borrowed by readers outside the lock is copied first
+// Callers must hold the write lock.
+func (s *Store) mutableSortedSet(key string) (*zset, bool) {
+	entry := s.data.get(key)
+	if entry == nil {
+		return nil, true
+	}
+	if entry.Type != TypeSortedSet {
+		return nil, false
+	}
+	entry.own()
+	return entry.Value.(*zset), true
+}
+
+// sortedSetModified must be called after changing the sorted set at key in
+// place; an emptied set is removed, as Redis never keeps empty sorted sets
+// Callers must hold the write lock.
+func (s *Store) sortedSetModified(key string, zs *zset) {
+	if zs.len() == 0 {
+		s.data.delete(key)
+	}
+	s.keyModified(key)
+}
+
+// ZAddOptions are the conditions and modes of ZADD
+type ZAddOptions struct {
+	NX   bool // Only add new members
+	XX   bool // Only update existing members
+	GT   bool // Only update scores to greater ones
+	LT   bool // Only update scores to lower ones
+	CH   bool // Count updated members along with added ones
+	Incr bool // Add the score to the existing one, as ZINCRBY
+}
+
+// ZAdd adds elements to the sorted set at key, or updates their scores, as
+// opts allow. The set is created if an element is added to a missing key.
+// Returns the number of members added (and updated with CH), with Incr the
+// new score or nil if the conditions prevented the update, or an error message
+func (s *Store) ZAdd(key string, opts ZAddOptions, elements []zsetElement) (int, *float64, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zs, ok := s.mutableSortedSet(key)
+	if !ok {
+		return 0, nil, wrongTypeError
+	}
+	created := zs == nil
+	if created {
+		zs = s.newSortedSet(len(elements))
+	}
+
+	added, updated := 0, 0
+	var result *float64
+	for _, e := range elements {
+		current, exists := zs.score(e.member)
+		if !exists {
+			if opts.XX {
+				continue
+			}
+			s.setScore(zs, e.member, e.score)
+			added++
+			result = &e.score
+			continue
+		}
+		if opts.NX {
+			continue
+		}
+		score := e.score
+		if opts.Incr {
+			if score += current; math.IsNaN(score) {
+				return 0, nil, "ERR resulting score is not a number (NaN)"
+			}
+		}
+		if (opts.GT && score <= current) || (opts.LT && score >= current) {
+			continue
+		}
+		if score != current {
+			s.setScore(zs, e.member, score)
+			updated++
+		}
+		result = &score
+	}
+
+	switch {
+	case created && added > 0:
+		s.put(key, &Entry{Type: TypeSortedSet, Value: zs})
+	case !created && added+updated > 0:
+		s.sortedSetModified(key, zs)
+	}
+	if opts.CH {
+		added += updated
+	}
+	return added, result, ""
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zaddCommand(c *Client, args []string) string {
+	var opts ZAddOptions
+	i := 2
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			opts.Incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := args[i:]
+	switch {
+	case len(pairs) == 0 || len(pairs)%2 != 0:
+		return formatError("ERR syntax error")
+	case opts.NX && opts.XX:
+		return formatError("ERR XX and NX options at the same time are not compatible")
+	case (opts.GT && opts.NX) || (opts.LT && opts.NX) || (opts.GT && opts.LT):
+		return formatError("ERR GT, LT, and/or NX options at the same time are not compatible")
+	case opts.Incr && len(pairs) > 2:
+		return formatError("ERR INCR option supports a single increment-element pair")
+	}
+
+	elements := make([]zsetElement, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			return formatError("ERR value is not a valid float")
+		}
+		elements = append(elements, zsetElement{member: pairs[j+1], score: score})
+	}
+
+	count, score, errMsg := c.db().ZAdd(args[1], opts, elements)
+	switch {
+	case errMsg != "":
+		return formatError(errMsg)
+	case !opts.Incr:
+		return formatInteger(count)
+	case score == nil:
+		return formatNullBulkString()
+	}
+	return c.formatDouble(*score)
+}
+
+// ZINCRBY key increment member
+func zincrbyCommand(c *Client, args []string) string {
+	increment, ok := parseScore(args[2])
+	if !ok {
+		return formatError("ERR value is not a valid float")
+	}
+	_, score, errMsg := c.db().ZAdd(args[1], ZAddOptions{Incr: true}, []zsetElement{{member: args[3], score: increment}})
+	if errMsg != "" {
+		return formatError(errMsg)
+	}
+	return c.formatDouble(*score)
+}
+
+// ZRem removes members from the sorted set at key, deleting the key once
+// the set is empty
+// Returns (number of members removed, isCorrectType)
+func (s *Store) ZRem(key string, members ...string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zs, ok := s.mutableSortedSet(key)
+	if zs == nil {
+		return 0, ok
+	}
+	removed := 0
+	for _, member := range members {
+		if zs.remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.sortedSetModified(key, zs)
+	}
+	return removed, true
+}
+
+// ZREM key member [member ...]
+func zremCommand(c *Client, args []string) string {
+	removed, ok := c.db().ZRem(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(removed)
+}
+
+// ZScore returns the scores of members in the sorted set at key, nil for
+// missing members
+// Returns (scores, isCorrectType)
+func (s *Store) ZScore(key string, members ...string) ([]*float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if !ok {
+		return nil, false
+	}
+	scores := make([]*float64, len(members))
+	if zs == nil {
+		return scores, true
+	}
+	for i, member := range members {
+		if score, found := zs.score(member); found {
+			scores[i] = &score
+		}
+	}
+	return scores, true
+}
+
+// ZCard returns the number of members of the sorted set at key
+// Returns (count, isCorrectType)
+func (s *Store) ZCard(key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if zs == nil {
+		return 0, ok
+	}
+	return zs.len(), true
+}
+
+// ZRank returns the position of member in the sorted set at key, from the
+// lowest score or with reverse from the highest, along with its score
+// Returns (rank, score, found, isCorrectType)
+func (s *Store) ZRank(key, member string, reverse bool) (int, float64, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if zs == nil {
+		return 0, 0, false, ok
+	}
+	rank, found := zs.rank(member)
+	if !found {
+		return 0, 0, false, true
+	}
+	score, _ := zs.score(member)
+	if reverse {
+		rank = zs.len() - 1 - rank
+	}
+	return rank, score, true, true
+}
+
+// formatScores formats scores as an array, missing ones as nil
+func (c *Client) formatScores(scores []*float64) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(scores)) + "\r\n")
+	for _, score := range scores {
+		if score == nil {
+			b.WriteString(formatNullBulkString())
+		} else {
+			b.WriteString(c.formatDouble(*score))
+		}
+	}
+	return b.String()
+}
+
+// ZSCORE key member
+func zscoreCommand(c *Client, args []string) string {
+	scores, ok := c.db().ZScore(args[1], args[2])
+	switch {
+	case !ok:
+		return formatError(wrongTypeError)
+	case scores[0] == nil:
+		return formatNullBulkString()
+	}
+	return c.formatDouble(*scores[0])
+}
+
+// ZMSCORE key member [member ...]
+func zmscoreCommand(c *Client, args []string) string {
+	scores, ok := c.db().ZScore(args[1], args[2:]...)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatScores(scores)
+}
+
+// ZCARD key
+func zcardCommand(c *Client, args []string) string {
+	count, ok := c.db().ZCard(args[1])
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(count)
+}
+
+// ZRANK key member [WITHSCORE]
+func zrankCommand(c *Client, args []string) string {
+	return zrankGeneric(c, args, false)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func zrevrankCommand(c *Client, args []string) string {
+	return zrankGeneric(c, args, true)
+}
+
+// zrankGeneric implements ZRANK and ZREVRANK. With WITHSCORE the reply is
+// a [rank, score] pair, nil as a null array when the member is missing.
+func zrankGeneric(c *Client, args []string, reverse bool) string {
+	withScore := false
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[3], "WITHSCORE"):
+		withScore = true
+	case len(args) > 3:
+		return formatError("ERR syntax error")
+	}
+
+	rank, score, found, ok := c.db().ZRank(args[1], args[2], reverse)
+	switch {
+	case !ok:
+		return formatError(wrongTypeError)
+	case !found && withScore:
+		return formatNullArray()
+	case !found:
+		return formatNullBulkString()
+	case withScore:
+		return "*2\r\n" + formatInteger(rank) + c.formatDouble(score)
+	}
+	return formatInteger(rank)
+}
+
+// ZRandMember returns count random elements of the sorted set at key. With
+// distinct, each member is picked at most once, so fewer than count may be
+// returned; otherwise members may repeat and exactly count are returned.
+// Returns (elements, isCorrectType)
+func (s *Store) ZRandMember(key string, count int, distinct bool) ([]zsetElement, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zs, ok := s.sortedSet(key)
+	if zs == nil || count == 0 {
+		return []zsetElement{}, ok
+	}
+	n := zs.len()
+	if distinct {
+		// Sample ranks with a partial Fisher-Yates shuffle that only
+		// remembers the positions it swapped
+		count = min(count, n)
+		swapped := make(map[int]int, count)
+		picked := make([]zsetElement, count)
+		for i := range picked {
+			j := i + rand.IntN(n-i)
+			rank, moved := swapped[j]
+			if !moved {
+				rank = j
+			}
+			if displaced, moved := swapped[i]; moved {
+				swapped[j] = displaced
+			} else {
+				swapped[j] = i
+			}
+			picked[i] = zs.at(rank)
+		}
+		return picked, true
+	}
+	// count comes from the client: grow the reply rather than trusting it
+	picked := make([]zsetElement, 0, min(count, n))
+	for range count {
+		picked = append(picked, zs.at(rand.IntN(n)))
+	}
+	return picked, true
+}
+
+// ZRANDMEMBER key [count [WITHSCORES]]
+func zrandmemberCommand(c *Client, args []string) string {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHSCORES")) {
+		return formatError("ERR syntax error")
+	}
+	if len(args) == 2 {
+		picked, ok := c.db().ZRandMember(args[1], 1, true)
+		if !ok {
+			return formatError(wrongTypeError)
+		}
+		if len(picked) == 0 {
+			return formatNullBulkString()
+		}
+		return formatBulkString(picked[0].member)
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return formatError("ERR value is not an integer or out of range")
+	}
+	if count < -math.MaxInt64/2 {
+		return formatError("ERR value is out of range")
+	}
+	// A negative count allows the same member to be returned several times
+	distinct := count >= 0
+	if !distinct {
+		count = -count
+	}
+	picked, ok := c.db().ZRandMember(args[1], count, distinct)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatElements(picked, len(args) == 4)
+}
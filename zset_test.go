@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFormatScore(t *testing.T) {
+	// Test 1: Scores use the shortest round-tripping digits, as Redis 7.2
+	for f, want := range map[float64]string{
+		0: "0", 1: "1", -2.5: "-2.5", 1.1: "1.1", 0.30000000000000004: "0.30000000000000004",
+		1e6: "1000000", 1.5e7: "15000000", 1e20: "1e+20", 123.456: "123.456",
+		0.0001: "0.0001", 1.5e-7: "1.5e-7", 1.234567890123: "1.234567890123",
+		math.Inf(1): "inf", math.Inf(-1): "-inf",
+	} {
+		if got := formatScore(f); got != want {
+			t.Errorf("formatScore(%v) = %q, want %q", f, got, want)
+		}
+	}
+
+	// Test 2: Scores parse like Redis's, refusing NaN
+	for s, ok := range map[string]bool{
+		"1": true, "-1.5": true, "+inf": true, "-inf": true, "inf": true, "1e3": true,
+		"nan": false, "": false, "abc": false, " 1": false, "1e400": false,
+	} {
+		if _, got := parseScore(s); got != ok {
+			t.Errorf("parseScore(%q) ok = %v, want %v", s, got, ok)
+		}
+	}
+}
+
+func TestZAdd(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	members := func(key string) string {
+		zs, _ := databases.Get(0).sortedSet(key)
+		if zs == nil {
+			return ""
+		}
+		var parts []string
+		zs.each(func(member string, score float64) bool {
+			parts = append(parts, member+"="+formatScore(score))
+			return true
+		})
+		return strings.Join(parts, ",")
+	}
+
+	// Test 1: Members are ordered by score, then member
+	if reply := run("ZADD", "z", "2", "b", "1", "c", "2", "a"); reply != ":3\r\n" {
+		t.Fatalf("expected 3 added, got %q", reply)
+	}
+	if got := members("z"); got != "c=1,a=2,b=2" {
+		t.Fatalf("unexpected order %s", got)
+	}
+	if kind, _ := databases.Get(0).KeyType("z"); kind != TypeSortedSet {
+		t.Fatalf("expected a sorted set, got %s", kind)
+	}
+
+	// Test 2: Updates move members and only count with CH
+	if reply := run("ZADD", "z", "0", "b", "5", "d"); reply != ":1\r\n" {
+		t.Fatalf("expected 1 added, got %q", reply)
+	}
+	if reply := run("ZADD", "z", "CH", "0", "b", "3", "c", "9", "e"); reply != ":2\r\n" {
+		t.Fatalf("expected 2 added or changed, got %q", reply)
+	}
+	if got := members("z"); got != "b=0,a=2,c=3,d=5,e=9" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	// Test 3: NX, XX, GT and LT restrict what changes
+	run("ZADD", "z", "NX", "100", "a", "1", "f")
+	run("ZADD", "z", "XX", "4", "d", "1", "g")
+	run("ZADD", "z", "GT", "CH", "1", "a", "10", "e")
+	if reply := run("ZADD", "z", "LT", "CH", "7", "c", "-1", "b", "6", "h"); reply != ":2\r\n" {
+		t.Fatalf("expected LT to add h and lower b only, got %q", reply)
+	}
+	if got := members("z"); got != "b=-1,f=1,a=2,c=3,d=4,h=6,e=10" {
+		t.Fatalf("unexpected order %s", got)
+	}
+	if reply := run("ZADD", "none", "XX", "1", "a"); reply != ":0\r\n" || members("none") != "" {
+		t.Fatalf("expected XX not to create the key, got %q", reply)
+	}
+
+	// Test 4: INCR returns the new score, or nil when a condition prevents it
+	if reply := run("ZADD", "z", "INCR", "1.5", "a"); reply != "$3\r\n3.5\r\n" {
+		t.Fatalf("expected 3.5, got %q", reply)
+	}
+	if reply := run("ZADD", "z", "INCR", "GT", "-1", "a"); reply != "$-1\r\n" {
+		t.Fatalf("expected nil, got %q", reply)
+	}
+	if reply := run("ZADD", "z", "INCR", "NX", "1", "a"); reply != "$-1\r\n" {
+		t.Fatalf("expected nil for NX on an existing member, got %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"ZADD", "z", "INCR", "+inf", "new"}); reply != ",inf\r\n" {
+		t.Fatalf("expected a RESP3 double, got %q", reply)
+	}
+	if reply := run("ZADD", "z", "INCR", "-inf", "new"); !strings.Contains(reply, "NaN") {
+		t.Fatalf("expected a NaN error, got %q", reply)
+	}
+
+	// Test 5: Invalid combinations and arguments
+	for _, args := range [][]string{
+		{"ZADD", "z", "1"},
+		{"ZADD", "z", "NX", "XX", "1", "a"},
+		{"ZADD", "z", "GT", "LT", "1", "a"},
+		{"ZADD", "z", "INCR", "1", "a", "2", "b"},
+		{"ZADD", "z", "one", "a"},
+		{"ZADD", "z", "nan", "a"},
+	} {
+		if reply := run(args...); !strings.HasPrefix(reply, "-ERR") {
+			t.Fatalf("expected %v to fail, got %q", args, reply)
+		}
+	}
+	run("SET", "s", "v")
+	if reply := run("ZADD", "s", "1", "a"); reply != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE, got %q", reply)
+	}
+
+	// Test 6: ZSCAN returns members with their scores
+	if reply := run("ZSCAN", "z", "0", "MATCH", "h"); reply != "*2\r\n$1\r\n0\r\n"+formatArray([]string{"h", "6"}) {
+		t.Fatalf("unexpected ZSCAN reply %q", reply)
+	}
+}
+
+func TestZScoreAndRank(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "z", "1", "a", "2.5", "b", "2.5", "c", "1e20", "d")
+
+	// Test 1: ZSCORE and ZMSCORE format scores as Redis does
+	if reply := run("ZSCORE", "z", "d"); reply != "$5\r\n1e+20\r\n" {
+		t.Fatalf("unexpected ZSCORE %q", reply)
+	}
+	if reply := run("ZSCORE", "z", "nosuch"); reply != "$-1\r\n" {
+		t.Fatalf("expected nil for a missing member, got %q", reply)
+	}
+	if reply := run("ZMSCORE", "z", "b", "nosuch", "a"); reply != "*3\r\n$3\r\n2.5\r\n$-1\r\n$1\r\n1\r\n" {
+		t.Fatalf("unexpected ZMSCORE %q", reply)
+	}
+	if reply := run("ZMSCORE", "none", "a"); reply != "*1\r\n$-1\r\n" {
+		t.Fatalf("expected nils for a missing key, got %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"ZMSCORE", "z", "a", "x"}); reply != "*2\r\n,1\r\n$-1\r\n" {
+		t.Fatalf("expected RESP3 doubles, got %q", reply)
+	}
+
+	// Test 2: ZCARD counts members
+	if run("ZCARD", "z") != ":4\r\n" || run("ZCARD", "none") != ":0\r\n" {
+		t.Fatalf("unexpected ZCARD")
+	}
+
+	// Test 3: Ranks count from either end, ties ordered by member
+	for args, want := range map[string]string{
+		"ZRANK z a":                ":0\r\n",
+		"ZRANK z c":                ":2\r\n",
+		"ZREVRANK z c":             ":1\r\n",
+		"ZREVRANK z d":             ":0\r\n",
+		"ZRANK z nosuch":           "$-1\r\n",
+		"ZRANK none a":             "$-1\r\n",
+		"ZRANK z b WITHSCORE":      "*2\r\n:1\r\n$3\r\n2.5\r\n",
+		"ZREVRANK z a withscore":   "*2\r\n:3\r\n$1\r\n1\r\n",
+		"ZRANK z nosuch WITHSCORE": "*-1\r\n",
+		"ZRANK z a WITHSCORES":     "-ERR syntax error\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+
+	// Test 4: Other types are refused
+	run("SET", "s", "v")
+	for _, args := range [][]string{{"ZSCORE", "s", "a"}, {"ZMSCORE", "s", "a"}, {"ZCARD", "s"}, {"ZRANK", "s", "a"}} {
+		if reply := run(args...); reply != formatError(wrongTypeError) {
+			t.Fatalf("expected WRONGTYPE for %v, got %q", args, reply)
+		}
+	}
+}
+
+func TestZIncrByAndRem(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	exists := func(key string) bool {
+		_, found := databases.Get(0).KeyType(key)
+		return found
+	}
+
+	// Test 1: ZINCRBY creates members and moves them
+	if reply := run("ZINCRBY", "z", "2.5", "a"); reply != "$3\r\n2.5\r\n" {
+		t.Fatalf("expected 2.5, got %q", reply)
+	}
+	run("ZADD", "z", "1", "b")
+	if reply := run("ZINCRBY", "z", "-2", "a"); reply != "$3\r\n0.5\r\n" {
+		t.Fatalf("expected 0.5, got %q", reply)
+	}
+	if reply := run("ZRANGE", "z", "0", "-1"); reply != formatArray([]string{"a", "b"}) {
+		t.Fatalf("expected a to move before b, got %q", reply)
+	}
+	if reply := run("ZINCRBY", "z", "x", "a"); reply != "-ERR value is not a valid float\r\n" {
+		t.Fatalf("expected a float error, got %q", reply)
+	}
+	run("ZINCRBY", "z", "+inf", "b")
+	if reply := run("ZINCRBY", "z", "-inf", "b"); !strings.Contains(reply, "NaN") {
+		t.Fatalf("expected a NaN error, got %q", reply)
+	}
+
+	// Test 2: ZREM counts removed members and deletes the emptied key
+	if reply := run("ZREM", "z", "a", "nosuch", "a"); reply != ":1\r\n" {
+		t.Fatalf("expected 1 removed, got %q", reply)
+	}
+	if reply := run("ZREM", "z", "b"); reply != ":1\r\n" || exists("z") {
+		t.Fatalf("expected the emptied key to be deleted, got %q", reply)
+	}
+	if reply := run("ZREM", "none", "a"); reply != ":0\r\n" {
+		t.Fatalf("expected 0 for a missing key, got %q", reply)
+	}
+	run("SET", "s", "v")
+	if run("ZINCRBY", "s", "1", "a") != formatError(wrongTypeError) || run("ZREM", "s", "a") != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE")
+	}
+}
+
+func TestZRandMember(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	db := databases.Get(0)
+
+	// Test 1: A positive count picks distinct members, at most all of them
+	for count := 0; count <= 7; count++ {
+		picked, _ := db.ZRandMember("z", count, true)
+		if len(picked) != min(count, 5) {
+			t.Fatalf("expected %d members for count %d, got %v", min(count, 5), count, picked)
+		}
+		seen := map[string]bool{}
+		for _, e := range picked {
+			if seen[e.member] {
+				t.Fatalf("member %s picked twice for count %d", e.member, count)
+			}
+			seen[e.member] = true
+			if want := float64(e.member[0]-'a') + 1; e.score != want {
+				t.Fatalf("expected %s to score %v, got %v", e.member, want, e.score)
+			}
+		}
+	}
+
+	// Test 2: Every member is eventually picked
+	counts := map[string]int{}
+	for range 500 {
+		picked, _ := db.ZRandMember("z", 2, true)
+		for _, e := range picked {
+			counts[e.member]++
+		}
+	}
+	if len(counts) != 5 {
+		t.Fatalf("expected every member to be picked, got %v", counts)
+	}
+
+	// Test 3: A negative count returns exactly that many, repeating members
+	if picked, _ := db.ZRandMember("z", 20, false); len(picked) != 20 {
+		t.Fatalf("expected 20 members, got %d", len(picked))
+	}
+	if reply := run("ZRANDMEMBER", "z", "-3", "WITHSCORES"); !strings.HasPrefix(reply, "*6\r\n") {
+		t.Fatalf("expected 3 members with scores, got %q", reply)
+	}
+	if reply := (&Client{resp3: true}).execute([]string{"ZRANDMEMBER", "z", "-2", "WITHSCORES"}); !strings.HasPrefix(reply, "*2\r\n*2\r\n") {
+		t.Fatalf("expected RESP3 pairs, got %q", reply)
+	}
+
+	// Test 4: Without a count the reply is a single member, nil if missing
+	if reply := run("ZRANDMEMBER", "z"); len(reply) != len("$1\r\na\r\n") {
+		t.Fatalf("expected one member, got %q", reply)
+	}
+	for args, want := range map[string]string{
+		"ZRANDMEMBER none":             "$-1\r\n",
+		"ZRANDMEMBER none 3":           "*0\r\n",
+		"ZRANDMEMBER z 0":              "*0\r\n",
+		"ZRANDMEMBER z x":              "-ERR value is not an integer or out of range\r\n",
+		"ZRANDMEMBER z 1 WITHVALUES":   "-ERR syntax error\r\n",
+		"ZRANDMEMBER z 1 WITHSCORES x": "-ERR syntax error\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+	for _, count := range []string{"-9223372036854775808", "-4611686018427387904"} {
+		if reply := run("ZRANDMEMBER", "z", count, "WITHSCORES"); reply != "-ERR value is out of range\r\n" {
+			t.Fatalf("expected count %s to be out of range, got %q", count, reply)
+		}
+	}
+	run("SET", "s", "v")
+	if run("ZRANDMEMBER", "s") != formatError(wrongTypeError) {
+		t.Fatalf("expected WRONGTYPE")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// zsetAggregate selects how ZUNION and ZINTER combine the scores a member
+// has in several inputs
+type zsetAggregate int
+
+const (
+	aggregateSum zsetAggregate = iota
+	aggregateMin
+	aggregateMax
+)
+
+// ZCombineOptions are the WEIGHTS and AGGREGATE options of ZUNION and ZINTER
+type ZCombineOptions struct {
+	Weights   []float64 // Score multiplier of each input, nil for all 1
+	Aggregate zsetAggregate
+}
+
+func (opts ZCombineOptions) weight(i int) float64 {
+	if opts.Weights == nil {
+		return 1
+	}
+	return opts.Weights[i]
+}
+
+// aggregate combines two scores of a member. As in Redis, the NaN of adding
+// opposite infinities counts as 0.
+func (opts ZCombineOptions) aggregate(a, b float64) float64 {
+	switch opts.Aggregate {
+	case aggregateMin:
+		return min(a, b)
+	case aggregateMax:
+		return max(a, b)
+	}
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// zsetSource is an input of ZUNION, ZINTER or ZDIFF: a sorted set, or a
+// set whose members all score 1. Both are nil for a missing key.
+type zsetSource struct {
+	zs  *zset
+	set setValue
+}
+
+func (src zsetSource) len() int {
+	switch {
+	case src.zs != nil:
+		return src.zs.len()
+	case src.set != nil:
+		return src.set.len()
+	}
+	return 0
+}
+
+func (src zsetSource) score(member string) (float64, bool) {
+	switch {
+	case src.zs != nil:
+		return src.zs.score(member)
+	case src.set != nil:
+		return 1, src.set.contains(member)
+	}
+	return 0, false
+}
+
+func (src zsetSource) each(fn func(member string, score float64) bool) {
+	switch {
+	case src.zs != nil:
+		src.zs.each(fn)
+	case src.set != nil:
+		src.set.each(func(member string) bool { return fn(member, 1) })
+	}
+}
+
+// zsetSources reads the inputs at keys
+// Callers must hold the lock.
+func (s *Store) zsetSources(keys []string) ([]zsetSource, bool) {
+	sources := make([]zsetSource, len(keys))
+	for i, key := range keys {
+		entry := s.data.get(key)
+		switch {
+		case entry == nil:
+		case entry.Type == TypeSortedSet:
+			sources[i].zs = entry.Value.(*zset)
+		case entry.Type == TypeSet:
+			sources[i].set = asSet(entry.Value)
+		default:
+			return nil, false
+		}
+	}
+	return sources, true
+}
+
+// combineSortedSets applies op to the sorted sets (or sets) at keys, missing
+// keys counting as empty. ok is false if any key holds another type.
+// Callers must hold the lock.
+func (s *Store) combineSortedSets(op setOperation, keys []string, opts ZCombineOptions) (map[string]float64, bool) {
+	sources, ok := s.zsetSources(keys)
+	if !ok {
+		return nil, false
+	}
+
+	result := make(map[string]float64)
+	// A weighted score is 0 rather than the NaN of 0 * inf
+	weighted := func(i int, score float64) float64 {
+		if w := score * opts.weight(i); !math.IsNaN(w) {
+			return w
+		}
+		return 0
+	}
+	switch op {
+	case setUnion:
+		for i, src := range sources {
+			src.each(func(member string, score float64) bool {
+				score = weighted(i, score)
+				if current, found := result[member]; found {
+					score = opts.aggregate(current, score)
+				}
+				result[member] = score
+				return true
+			})
+		}
+	case setIntersection:
+		// Probe the other inputs with the members of the smallest one
+		smallest := 0
+		for i, src := range sources {
+			if src.len() < sources[smallest].len() {
+				smallest = i
+			}
+		}
+		sources[smallest].each(func(member string, _ float64) bool {
+			var total float64
+			for i, src := range sources {
+				score, found := src.score(member)
+				if !found {
+					return true
+				}
+				if i == 0 {
+					total = weighted(i, score)
+				} else {
+					total = opts.aggregate(total, weighted(i, score))
+				}
+			}
+			result[member] = total
+			return true
+		})
+	case setDifference:
+		sources[0].each(func(member string, score float64) bool {
+			for _, src := range sources[1:] {
+				if _, found := src.score(member); found {
+					return true
+				}
+			}
+			result[member] = score
+			return true
+		})
+	}
+	return result, true
+}
+
+// CombineSortedSets returns the result of op over the sorted sets at keys,
+// ordered by score
+// Returns (elements, isCorrectType)
+func (s *Store) CombineSortedSets(op setOperation, keys []string, opts ZCombineOptions) ([]zsetElement, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result, ok := s.combineSortedSets(op, keys, opts)
+	if !ok {
+		return nil, false
+	}
+	return sortedElements(result), true
+}
+
+// CombineSortedSetsStore stores the result of op over the sorted sets at
+// keys in dst, replacing whatever dst held; an empty result deletes dst
+// Returns (cardinality of the result, isCorrectType)
+func (s *Store) CombineSortedSetsStore(op setOperation, dst string, keys []string, opts ZCombineOptions) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.combineSortedSets(op, keys, opts)
+	if !ok {
+		return 0, false
+	}
+	if len(result) == 0 {
+		if s.data.delete(dst) {
+			s.keyModified(dst)
+		}
+		return 0, true
+	}
+	s.put(dst, &Entry{Type: TypeSortedSet, Value: s.encodeZset(result)})
+	return len(result), true
+}
+
+// parseZCombine parses the "numkeys key [key ...]" arguments of ZUNION,
+// ZINTER and ZDIFF and their STORE forms, with WEIGHTS and AGGREGATE except
+// for ZDIFF and WITHSCORES if withScoresAllowed
+// Returns a non-empty error reply on failure
+func parseZCombine(name string, args []string, op setOperation, withScoresAllowed bool) (keys []string, opts ZCombineOptions, withScores bool, errReply string) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, opts, false, formatError("ERR value is not an integer or out of range")
+	}
+	if numKeys < 1 {
+		return nil, opts, false, formatError("ERR at least 1 input key is needed for '" + strings.ToLower(name) + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, opts, false, formatError("ERR syntax error")
+	}
+	keys = args[1 : 1+numKeys]
+
+	for i := 1 + numKeys; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch arg := strings.ToUpper(args[i]); {
+		case arg == "WEIGHTS" && op != setDifference && remaining >= numKeys:
+			opts.Weights = make([]float64, numKeys)
+			for j := range opts.Weights {
+				i++
+				weight, err := strconv.ParseFloat(args[i], 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, opts, false, formatError("ERR weight value is not a float")
+				}
+				opts.Weights[j] = weight
+			}
+		case arg == "AGGREGATE" && op != setDifference && remaining >= 1:
+			i++
+			switch strings.ToUpper(args[i]) {
+			case "SUM":
+				opts.Aggregate = aggregateSum
+			case "MIN":
+				opts.Aggregate = aggregateMin
+			case "MAX":
+				opts.Aggregate = aggregateMax
+			default:
+				return nil, opts, false, formatError("ERR syntax error")
+			}
+		case arg == "WITHSCORES" && withScoresAllowed:
+			withScores = true
+		default:
+			return nil, opts, false, formatError("ERR syntax error")
+		}
+	}
+	return keys, opts, withScores, ""
+}
+
+func zcombineReply(c *Client, args []string, op setOperation) string {
+	keys, opts, withScores, errReply := parseZCombine(args[0], args[1:], op, true)
+	if errReply != "" {
+		return errReply
+	}
+	elements, ok := c.db().CombineSortedSets(op, keys, opts)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return c.formatElements(elements, withScores)
+}
+
+func zcombineStoreReply(c *Client, args []string, op setOperation) string {
+	keys, opts, _, errReply := parseZCombine(args[0], args[2:], op, false)
+	if errReply != "" {
+		return errReply
+	}
+	n, ok := c.db().CombineSortedSetsStore(op, args[1], keys, opts)
+	if !ok {
+		return formatError(wrongTypeError)
+	}
+	return formatInteger(n)
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zunionCommand(c *Client, args []string) string {
+	return zcombineReply(c, args, setUnion)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zinterCommand(c *Client, args []string) string {
+	return zcombineReply(c, args, setIntersection)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func zdiffCommand(c *Client, args []string) string {
+	return zcombineReply(c, args, setDifference)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zunionstoreCommand(c *Client, args []string) string {
+	return zcombineStoreReply(c, args, setUnion)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zinterstoreCommand(c *Client, args []string) string {
+	return zcombineStoreReply(c, args, setIntersection)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func zdiffstoreCommand(c *Client, args []string) string {
+	return zcombineStoreReply(c, args, setDifference)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZSetAlgebra(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(1)
+	defer func() { databases = saved }()
+
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	run("ZADD", "a", "1", "x", "2", "y", "3", "z")
+	run("ZADD", "b", "10", "y", "20", "z", "30", "w")
+
+	// Test 1: ZUNION sums scores, ordered by the result
+	want := formatArray([]string{"x", "1", "y", "12", "z", "23", "w", "30"})
+	if reply := run("ZUNION", "2", "a", "b", "WITHSCORES"); reply != want {
+		t.Fatalf("unexpected ZUNION %q", reply)
+	}
+	if reply := run("ZUNION", "3", "a", "missing", "b"); reply != formatArray([]string{"x", "y", "z", "w"}) {
+		t.Fatalf("unexpected ZUNION without scores %q", reply)
+	}
+
+	// Test 2: WEIGHTS and AGGREGATE
+	want = formatArray([]string{"y", "4", "z", "6"})
+	if reply := run("ZINTER", "2", "a", "b", "WEIGHTS", "2", "0.1", "AGGREGATE", "MAX", "WITHSCORES"); reply != want {
+		t.Fatalf("unexpected weighted ZINTER %q", reply)
+	}
+	want = formatArray([]string{"y", "2", "z", "3"})
+	if reply := run("ZINTER", "2", "a", "b", "aggregate", "min", "withscores"); reply != want {
+		t.Fatalf("unexpected ZINTER MIN %q", reply)
+	}
+	if reply := run("ZINTER", "2", "a", "missing"); reply != "*0\r\n" {
+		t.Fatalf("expected an empty intersection with a missing key, got %q", reply)
+	}
+
+	// Test 3: ZDIFF keeps the scores of the first key
+	if reply := run("ZDIFF", "2", "a", "b", "WITHSCORES"); reply != formatArray([]string{"x", "1"}) {
+		t.Fatalf("unexpected ZDIFF %q", reply)
+	}
+
+	// Test 4: Plain sets count as members scoring 1
+	run("SADD", "s", "x", "w", "v")
+	if reply := run("ZINTER", "3", "s", "a", "b", "AGGREGATE", "SUM", "WITHSCORES"); reply != "*0\r\n" {
+		t.Fatalf("unexpected three-way ZINTER %q", reply)
+	}
+	if reply := run("ZINTER", "2", "s", "b", "WITHSCORES"); reply != formatArray([]string{"w", "31"}) {
+		t.Fatalf("unexpected ZINTER with a set %q", reply)
+	}
+	want = formatArray([]string{"v", "2", "w", "2", "y", "2", "x", "3", "z", "3"})
+	if reply := run("ZUNION", "2", "s", "a", "WEIGHTS", "2", "1", "WITHSCORES"); reply != want {
+		t.Fatalf("unexpected ZUNION with a set %q", reply)
+	}
+
+	// Test 5: The STORE forms replace the destination and reply its size
+	run("SET", "dst", "string")
+	if reply := run("ZUNIONSTORE", "dst", "2", "a", "b"); reply != formatInteger(4) {
+		t.Fatalf("unexpected ZUNIONSTORE %q", reply)
+	}
+	if reply := run("ZRANGE", "dst", "0", "-1", "WITHSCORES"); reply != formatArray([]string{"x", "1", "y", "12", "z", "23", "w", "30"}) {
+		t.Fatalf("unexpected stored union %q", reply)
+	}
+	if reply := run("ZINTERSTORE", "dst", "2", "dst", "a", "WEIGHTS", "1", "-1"); reply != formatInteger(3) {
+		t.Fatalf("unexpected ZINTERSTORE %q", reply)
+	}
+	if reply := run("ZSCORE", "dst", "z"); reply != formatBulkString("20") {
+		t.Fatalf("unexpected stored intersection score %q", reply)
+	}
+	if reply := run("ZDIFFSTORE", "dst", "2", "a", "a"); reply != formatInteger(0) {
+		t.Fatalf("unexpected ZDIFFSTORE %q", reply)
+	}
+	if _, found := databases.Get(0).KeyType("dst"); found {
+		t.Fatalf("expected an empty result to delete the destination")
+	}
+
+	// Test 6: Infinite scores that cancel out sum to 0
+	run("ZADD", "pos", "+inf", "m")
+	run("ZADD", "neg", "-inf", "m")
+	if reply := run("ZUNION", "2", "pos", "neg", "WITHSCORES"); reply != formatArray([]string{"m", "0"}) {
+		t.Fatalf("expected inf + -inf to be 0, got %q", reply)
+	}
+	if reply := run("ZUNION", "1", "pos", "WEIGHTS", "0", "WITHSCORES"); reply != formatArray([]string{"m", "0"}) {
+		t.Fatalf("expected 0 * inf to be 0, got %q", reply)
+	}
+
+	// Test 7: Errors
+	run("SET", "str", "v")
+	for args, want := range map[string]string{
+		"ZUNION 0 a":                     "-ERR at least 1 input key is needed for 'zunion' command\r\n",
+		"ZINTERSTORE d 0 a":              "-ERR at least 1 input key is needed for 'zinterstore' command\r\n",
+		"ZUNION x a":                     "-ERR value is not an integer or out of range\r\n",
+		"ZUNION 3 a b":                   "-ERR syntax error\r\n",
+		"ZUNION 2 a b WEIGHTS 1":         "-ERR syntax error\r\n",
+		"ZUNION 2 a b WEIGHTS 1 x":       "-ERR weight value is not a float\r\n",
+		"ZUNION 2 a b AGGREGATE AVG":     "-ERR syntax error\r\n",
+		"ZDIFF 2 a b WEIGHTS 1 1":        "-ERR syntax error\r\n",
+		"ZUNIONSTORE d 2 a b WITHSCORES": "-ERR syntax error\r\n",
+		"ZUNION 2 a str":                 "-" + wrongTypeError + "\r\n",
+		"ZDIFFSTORE d 2 a str":           "-" + wrongTypeError + "\r\n",
+	} {
+		if reply := run(strings.Fields(args)...); reply != want {
+			t.Fatalf("expected %s to reply %q, got %q", args, want, reply)
+		}
+	}
+}
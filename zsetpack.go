@@ -0,0 +1,104 @@
+package main
+
+import "strconv"
+
+// Sorted set encoding limits, as Redis's zset-max-listpack-entries and
+// zset-max-listpack-value defaults
+const (
+	defaultZsetListpackEntries = 128
+	defaultZsetListpackValue   = 64
+)
+
+// SetZsetListpackLimits sets the store's zset-max-listpack-entries and
+// zset-max-listpack-value: sorted sets of at most entries members, none of
+// which is longer than value bytes, are stored packed. Existing sets keep
+// their encoding until they are next written.
+func (s *Store) SetZsetListpackLimits(entries, value int) {
+	s.mu.Lock()
+	s.zsetPackEntries, s.zsetPackValue = entries, value
+	s.mu.Unlock()
+}
+
+// zsetListpackLimits returns the limits set by SetZsetListpackLimits
+func (s *Store) zsetListpackLimits() (entries, value int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.zsetPackEntries, s.zsetPackValue
+}
+
+// newSortedSet returns an empty sorted set in the encoding suited to size
+// members
+func (s *Store) newSortedSet(size int) *zset {
+	if size <= s.zsetPackEntries {
+		return &zset{packed: make([]zsetElement, 0, size)}
+	}
+	return newZset(size)
+}
+
+// encodeZset returns a newly built sorted set with the score of each member,
+// in the most compact encoding allowed
+func (s *Store) encodeZset(scores map[string]float64) *zset {
+	if len(scores) == 0 || len(scores) > s.zsetPackEntries {
+		return zsetFromScores(scores)
+	}
+	for member := range scores {
+		if len(member) > s.zsetPackValue {
+			return zsetFromScores(scores)
+		}
+	}
+	return &zset{packed: sortedElements(scores)}
+}
+
+// setScore gives member of zs the score, first converting a packed set that
+// would outgrow the limits to the skiplist encoding
+// Callers must hold the write lock.
+func (s *Store) setScore(zs *zset, member string, score float64) {
+	if zs.isPacked() {
+		tooLong := len(member) > s.zsetPackValue
+		if tooLong || (zs.len() >= s.zsetPackEntries && zs.find(member) < 0) {
+			zs.unpack()
+		}
+	}
+	zs.set(member, score)
+}
+
+// Sorted set encoding parameters of CONFIG GET and CONFIG SET, which apply
+// to every database
+var zsetConfigParams = []configParam{
+	{
+		name: "zset-max-listpack-entries",
+		get: func() string {
+			entries, _ := databases.Get(0).zsetListpackLimits()
+			return strconv.Itoa(entries)
+		},
+		set: func(v string) error {
+			n, err := parseListpackLimit("zset-max-listpack-entries", v)
+			if err != nil {
+				return err
+			}
+			for _, db := range databases.All() {
+				_, value := db.zsetListpackLimits()
+				db.SetZsetListpackLimits(n, value)
+			}
+			return nil
+		},
+	},
+	{
+		name: "zset-max-listpack-value",
+		get: func() string {
+			_, value := databases.Get(0).zsetListpackLimits()
+			return strconv.Itoa(value)
+		},
+		set: func(v string) error {
+			n, err := parseListpackLimit("zset-max-listpack-value", v)
+			if err != nil {
+				return err
+			}
+			for _, db := range databases.All() {
+				entries, _ := db.zsetListpackLimits()
+				db.SetZsetListpackLimits(entries, n)
+			}
+			return nil
+		},
+	},
+}
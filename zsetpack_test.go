@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestZsetListpackEncoding(t *testing.T) {
+	saved := databases
+	databases = NewDatabases(2)
+	defer func() { databases = saved }()
+
+	db := databases.Get(0)
+	client := &Client{}
+	run := func(args ...string) string {
+		return client.execute(args)
+	}
+	encoding := func(key string) string {
+		enc, _, _, _ := db.ObjectInfo(key)
+		return enc
+	}
+
+	// Test 1: Small sorted sets are packed and every command works on them
+	run("ZADD", "z", "3", "c", "1", "a", "2", "b", "4", "d")
+	if enc := encoding("z"); enc != "listpack" {
+		t.Fatalf("expected listpack, got %s", enc)
+	}
+	run("ZINCRBY", "z", "10", "a")
+	run("ZREM", "z", "c")
+	if reply := run("ZRANGE", "z", "0", "-1", "WITHSCORES"); reply != formatArray([]string{"b", "2", "d", "4", "a", "11"}) {
+		t.Fatalf("unexpected elements %q", reply)
+	}
+	if reply := run("ZRANK", "z", "d"); reply != ":1\r\n" {
+		t.Fatalf("expected rank 1, got %q", reply)
+	}
+	if reply := run("ZRANGEBYSCORE", "z", "(2", "+inf", "LIMIT", "0", "1"); reply != formatArray([]string{"d"}) {
+		t.Fatalf("unexpected score range %q", reply)
+	}
+	if reply := run("ZPOPMAX", "z"); reply != formatArray([]string{"a", "11"}) {
+		t.Fatalf("unexpected ZPOPMAX %q", reply)
+	}
+	if reply := run("ZREMRANGEBYRANK", "z", "0", "0"); reply != ":1\r\n" || run("ZCARD", "z") != ":1\r\n" {
+		t.Fatalf("expected one element left, got %q", reply)
+	}
+
+	// Test 2: A long member converts the set, keeping its elements
+	run("ZADD", "z", "5", strings.Repeat("m", defaultZsetListpackValue+1))
+	if enc := encoding("z"); enc != "skiplist" {
+		t.Fatalf("expected skiplist after a long member, got %s", enc)
+	}
+	if reply := run("ZSCORE", "z", "d"); reply != "$1\r\n4\r\n" {
+		t.Fatalf("expected 4, got %q", reply)
+	}
+
+	// Test 3: CONFIG SET changes the limits of every database
+	if reply := run("CONFIG", "SET", "zset-max-listpack-entries", "3", "zset-max-listpack-value", "10"); reply != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", reply)
+	}
+	if entries, value := databases.Get(1).zsetListpackLimits(); entries != 3 || value != 10 {
+		t.Fatalf("expected limits 3 and 10 in database 1, got %d and %d", entries, value)
+	}
+	want := "*4\r\n" + formatBulkString("zset-max-listpack-entries") + formatBulkString("3") +
+		formatBulkString("zset-max-listpack-value") + formatBulkString("10")
+	if reply := run("CONFIG", "GET", "zset-*"); reply != want {
+		t.Fatalf("unexpected CONFIG GET reply %q", reply)
+	}
+	run("ZADD", "small", "1", "a", "2", "b", "3", "c")
+	run("ZADD", "small", "30", "c")
+	if enc := encoding("small"); enc != "listpack" {
+		t.Fatalf("expected listpack at the limit, got %s", enc)
+	}
+	run("ZADD", "small", "4", "d")
+	if enc := encoding("small"); enc != "skiplist" {
+		t.Fatalf("expected skiplist past the limit, got %s", enc)
+	}
+	if reply := run("ZRANGE", "small", "0", "-1"); reply != formatArray([]string{"a", "b", "d", "c"}) {
+		t.Fatalf("unexpected elements after conversion %q", reply)
+	}
+	if reply := run("CONFIG", "SET", "zset-max-listpack-value", "-1"); !strings.Contains(reply, "invalid zset-max-listpack-value") {
+		t.Fatalf("expected an invalid value error, got %q", reply)
+	}
+
+	// Test 4: Stored results and restored values get the compact encoding
+	run("ZUNIONSTORE", "union", "1", "small")
+	if enc := encoding("union"); enc != "skiplist" {
+		t.Fatalf("expected a result past the limit to be a skiplist, got %s", enc)
+	}
+	run("ZINTERSTORE", "inter", "2", "small", "small")
+	run("ZREMRANGEBYRANK", "inter", "0", "1")
+	run("ZDIFFSTORE", "diff", "2", "small", "inter")
+	if enc := encoding("diff"); enc != "listpack" {
+		t.Fatalf("expected a small result to be packed, got %s", enc)
+	}
+	dump := run("DUMP", "small")
+	run("CONFIG", "SET", "zset-max-listpack-entries", "128")
+	payload := dump[strings.Index(dump, "\r\n")+2 : len(dump)-2]
+	if reply := run("RESTORE", "restored", "0", payload); reply != "+OK\r\n" {
+		t.Fatalf("expected RESTORE to succeed, got %q", reply)
+	}
+	if enc := encoding("restored"); enc != "listpack" {
+		t.Fatalf("expected a restored small set to be packed, got %s", enc)
+	}
+
+	// Test 5: Packed sets take a fraction of the memory of a skiplist
+	db.SetZsetListpackLimits(defaultZsetListpackEntries, defaultZsetListpackValue)
+	elements := make([]string, 0, 200)
+	for i := range 100 {
+		elements = append(elements, strconv.Itoa(i), "member:"+strconv.Itoa(i))
+	}
+	run(append([]string{"ZADD", "packed"}, elements...)...)
+	db.SetZsetListpackLimits(0, defaultZsetListpackValue)
+	run(append([]string{"ZADD", "list"}, elements...)...)
+	packed, _ := db.MemoryUsage("packed", 0)
+	list, _ := db.MemoryUsage("list", 0)
+	if encoding("packed") != "listpack" || encoding("list") != "skiplist" || packed*3 > list {
+		t.Fatalf("expected the packed set to be much smaller: %d vs %d bytes", packed, list)
+	}
+
+	// Test 6: Directives set the limits
+	cfg, err := ParseConfig(strings.NewReader("zset-max-listpack-entries 16\nzset-max-ziplist-value 32\n"))
+	if err != nil || cfg.ZsetMaxListpackEntries != 16 || cfg.ZsetMaxListpackValue != 32 {
+		t.Fatalf("unexpected parsed limits %+v, %v", cfg, err)
+	}
+	if _, err := ParseConfig(strings.NewReader("zset-max-listpack-entries many\n")); err == nil {
+		t.Fatalf("expected a non-numeric limit to be rejected")
+	}
+}